@@ -6,6 +6,7 @@ from .reflora import REFLORACrawler
 from .gift import GIFTCrawler
 from .wcvp import WCVPCrawler
 from .worldclim import WorldClimCrawler
+from .worldclim_future import WorldClimFutureCrawler
 from .treegoer import TreeGOERCrawler
 from .iucn import IUCNCrawler
 from .try_db import TRYCrawler
@@ -19,6 +20,7 @@ CRAWLERS = {
     'gift': GIFTCrawler,
     'wcvp': WCVPCrawler,
     'worldclim': WorldClimCrawler,
+    'worldclim_future': WorldClimFutureCrawler,
     'treegoer': TreeGOERCrawler,
     'iucn': IUCNCrawler,
     'try': TRYCrawler,
@@ -47,6 +49,7 @@ __all__ = [
     'GIFTCrawler',
     'WCVPCrawler',
     'WorldClimCrawler',
+    'WorldClimFutureCrawler',
     'TreeGOERCrawler',
     'IUCNCrawler',
     'TRYCrawler',
@@ -6,6 +6,9 @@ import logging
 from sqlalchemy import create_engine, text
 from sqlalchemy.orm import Session, sessionmaker
 
+from .checksums import ChecksumStore, record_checksum
+from .validation import ValidationReport
+
 # Configure logging
 logging.basicConfig(
     level=logging.INFO,
@@ -16,6 +19,15 @@ logging.basicConfig(
 class BaseCrawler(ABC):
     """Abstract base class for all data crawlers."""
 
+    # Whether the crawler inserts species it does not find (trait-only
+    # sources such as TRY only update existing ones)
+    creates_species = True
+
+    # Whether the crawler writes from fetch_data rather than through _save;
+    # in a dry run such crawlers skip their writes and count them with
+    # report.would_write
+    writes_in_fetch = False
+
     def __init__(self, db_url: str):
         """
         Initialize the crawler.
@@ -31,10 +43,20 @@ class BaseCrawler(ABC):
             'inserted': 0,
             'updated': 0,
             'errors': 0,
-            'skipped': 0
+            'skipped': 0,
+            'unchanged': 0
         }
         self._run_id: Optional[int] = None
 
+        # Records unchanged since they were last saved are skipped (see
+        # checksums.py); force saves them anyway
+        self.checksums = ChecksumStore(self.name)
+        self.force = False
+
+        # Dry run: validate and report without writing (see validation.py)
+        self.dry_run = False
+        self.report = ValidationReport(self.name)
+
     @property
     @abstractmethod
     def name(self) -> str:
@@ -115,17 +137,78 @@ class BaseCrawler(ABC):
 
         try:
             transformed = self.transform(raw_data)
+            if self.dry_run and self.writes_in_fetch:
+                return
 
             if not self.validate(transformed):
                 self.stats['skipped'] += 1
+                if self.dry_run:
+                    self.report.invalid(transformed)
                 return
 
-            self._save(transformed)
+            self._save_or_preview(transformed)
 
         except Exception as e:
             self.stats['errors'] += 1
             self.logger.warning(f"Error processing item: {e}")
 
+    def _record_key(self, data: Dict) -> str:
+        """The key a record's checksum is stored under: its source ID, else its name."""
+        _, id_value = self._get_source_id_field(data)
+        return str(id_value) if id_value else data['canonical_name']
+
+    def _changed_checksum(self, key: str, data: Any) -> Optional[str]:
+        """
+        Checksum of a record about to be saved, or None if it is unchanged
+        since last saved (counted in stats['unchanged']).
+        """
+        checksum = record_checksum(data)
+        self.checksums.load(self.engine)
+        if not self.force and self.checksums.unchanged(key, checksum):
+            self.stats['unchanged'] += 1
+            if self.dry_run:
+                self.report.unchanged()
+            return None
+        return checksum
+
+    def _saved_checksum(self, key: str, checksum: str):
+        """Record the checksum of a saved record."""
+        if self.dry_run:
+            return
+        self.checksums.add(key, checksum)
+        if self.checksums.should_flush():
+            self.checksums.flush(self.engine, self._run_id)
+
+    def _save_or_preview(self, data: Dict):
+        """Save a changed record, or in a dry run add it to the validation report."""
+        key = self._record_key(data)
+        checksum = self._changed_checksum(key, data)
+        if checksum is None:
+            return
+
+        if not self.dry_run:
+            self._save(data)
+            self._saved_checksum(key, checksum)
+            return
+
+        with Session(self.engine) as session:
+            exists = session.execute(
+                text("SELECT 1 FROM species WHERE canonical_name = :name"),
+                {'name': data['canonical_name']}
+            ).fetchone() is not None
+
+        if exists:
+            self.stats['updated'] += 1
+        elif self.creates_species:
+            self.stats['inserted'] += 1
+        else:
+            self.stats['skipped'] += 1
+        self.report.add(data, exists if exists or self.creates_species else None)
+
+    def validation_report(self) -> Dict:
+        """The dry-run validation report."""
+        return self.report.to_dict(self.stats['processed'])
+
     def _save(self, data: Dict):
         """Save or update a species record using UPSERT."""
         with Session(self.engine) as session:
@@ -149,6 +232,10 @@ class BaseCrawler(ABC):
             if 'brazil_distribution' in data:
                 self._save_brazil_distribution(session, species_id, data['brazil_distribution'])
 
+            # Species-level Flora e Funga do Brasil attributes (from REFLORA)
+            if 'brazil_flora' in data:
+                self._save_brazil_flora(session, species_id, data.get('reflora_id'), data['brazil_flora'])
+
             session.commit()
 
     def _upsert_species(self, session: Session, data: Dict) -> tuple:
@@ -365,6 +452,29 @@ class BaseCrawler(ABC):
                 savepoint.rollback()
                 self.logger.debug(f"Skipped distribution {state_code}: {e}")
 
+    def _save_brazil_flora(self, session: Session, species_id: int, reflora_id: Optional[str], flora: Dict):
+        """Save endemism, phytogeographic domains and vegetation types of a species."""
+        session.execute(
+            text("""
+                INSERT INTO species_brazil_flora
+                    (species_id, reflora_id, is_endemic, phytogeographic_domains, vegetation_types, synced_at)
+                VALUES (:sid, :rid, :endemic, :domains, :vegetation, NOW())
+                ON CONFLICT (species_id) DO UPDATE SET
+                    reflora_id = EXCLUDED.reflora_id,
+                    is_endemic = EXCLUDED.is_endemic,
+                    phytogeographic_domains = EXCLUDED.phytogeographic_domains,
+                    vegetation_types = EXCLUDED.vegetation_types,
+                    synced_at = NOW()
+            """),
+            {
+                'sid': species_id,
+                'rid': reflora_id,
+                'endemic': flora.get('is_endemic', False),
+                'domains': flora.get('phytogeographic_domains', []),
+                'vegetation': flora.get('vegetation_types', []),
+            }
+        )
+
     def _save_common_names(self, session: Session, species_id: int, names: list):
         """Save common names for a species using savepoints for error isolation."""
         for name_data in names:
@@ -399,6 +509,8 @@ class BaseCrawler(ABC):
 
     def _log_start(self):
         """Log crawler start to database."""
+        if self.dry_run:
+            return
         with Session(self.engine) as session:
             # Update status
             session.execute(
@@ -425,6 +537,9 @@ class BaseCrawler(ABC):
 
     def _log_success(self):
         """Log successful completion."""
+        if self.dry_run:
+            return
+        self.checksums.flush(self.engine, self._run_id)
         with Session(self.engine) as session:
             session.execute(
                 text("""
@@ -445,14 +560,16 @@ class BaseCrawler(ABC):
                             status = 'completed',
                             records_processed = :processed,
                             records_inserted = :inserted,
-                            records_updated = :updated
+                            records_updated = :updated,
+                            records_unchanged = :unchanged
                         WHERE id = :id
                     """),
                     {
                         'id': self._run_id,
                         'processed': self.stats['processed'],
                         'inserted': self.stats['inserted'],
-                        'updated': self.stats['updated']
+                        'updated': self.stats['updated'],
+                        'unchanged': self.stats['unchanged']
                     }
                 )
 
@@ -460,6 +577,10 @@ class BaseCrawler(ABC):
 
     def _log_error(self, message: str):
         """Log error to database."""
+        if self.dry_run:
+            return
+        # Keep the progress made, so a re-run skips it
+        self.checksums.flush(self.engine, self._run_id)
         with Session(self.engine) as session:
             session.execute(
                 text("""
@@ -489,6 +610,8 @@ class BaseCrawler(ABC):
     def _log_message(self, level: str, message: str, details: dict = None):
         """Log a message to the crawler_logs table."""
         import json
+        if self.dry_run:
+            return
         with Session(self.engine) as session:
             session.execute(
                 text("""
@@ -514,6 +637,9 @@ class BaseCrawler(ABC):
         Args:
             species_ids: Optional list of species IDs to refresh. If None, refreshes all.
         """
+        if self.dry_run:
+            self.logger.info("Dry run: not refreshing unified tables")
+            return
         self.logger.info("Refreshing unified tables...")
 
         with Session(self.engine) as session:
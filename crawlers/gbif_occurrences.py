@@ -34,6 +34,8 @@ class GBIFOccurrenceCrawler(BaseCrawler):
     5. Calculate envelope statistics (mean, percentiles, min/max)
     """
 
+    writes_in_fetch = True
+
     @property
     def name(self) -> str:
         return 'gbif_occurrences'
@@ -43,6 +45,7 @@ class GBIFOccurrenceCrawler(BaseCrawler):
 
     # Quality filters
     MIN_OCCURRENCES = 20  # Minimum points for reliable envelope
+    ELEVATION_RANGE_M = (-100, 6500)  # Plausible plant elevations; others are dropped
     MAX_UNCERTAINTY_M = 10000  # 10km max coordinate uncertainty
     MIN_YEAR = 1970  # Only recent observations
     MAX_OCCURRENCES_PER_SPECIES = 1000  # Limit per species for performance
@@ -199,7 +202,8 @@ class GBIFOccurrenceCrawler(BaseCrawler):
                         'longitude': occ.get('decimalLongitude'),
                         'uncertainty_m': occ.get('coordinateUncertaintyInMeters'),
                         'year': occ.get('year'),
-                        'country_code': occ.get('countryCode')
+                        'country_code': occ.get('countryCode'),
+                        'elevation_m': self._clean_elevation(occ.get('elevation'))
                     })
 
                     if len(occurrences) >= limit:
@@ -244,6 +248,18 @@ class GBIFOccurrenceCrawler(BaseCrawler):
 
         return True
 
+    def _clean_elevation(self, elevation: Any) -> Optional[float]:
+        """Return the occurrence elevation in meters, or None if missing or implausible."""
+        try:
+            value = float(elevation)
+        except (TypeError, ValueError):
+            return None
+
+        low, high = self.ELEVATION_RANGE_M
+        if value < low or value > high:
+            return None
+        return value
+
     def extract_climate_at_points(self, occurrences: List[Dict]) -> List[Dict]:
         """
         Extract WorldClim climate data at each occurrence point.
@@ -388,6 +404,13 @@ class GBIFOccurrenceCrawler(BaseCrawler):
                 self.stats['skipped'] += 1
                 continue
 
+            # Skip species whose occurrences are unchanged before the raster
+            # sampling; keyed by species_id like climate_envelope_gbif
+            key = str(species_id)
+            checksum = self._changed_checksum(key, occurrences)
+            if checksum is None:
+                continue
+
             # Extract climate at each point
             climate_data = self.extract_climate_at_points(occurrences)
 
@@ -396,17 +419,25 @@ class GBIFOccurrenceCrawler(BaseCrawler):
                 self.stats['skipped'] += 1
                 continue
 
-            # Save occurrences
-            saved_count = self._save_occurrences(species_id, climate_data)
-            total_occurrences += saved_count
-
-            # Calculate and save envelope
             envelope = self.calculate_envelope(climate_data)
-            if envelope:
-                self._save_envelope(species_id, envelope)
-                self._update_analysis(species_id)
-                envelopes_created += 1
-                self.stats['inserted'] += 1
+            if self.dry_run:
+                self.report.would_write('gbif_occurrences', len(climate_data))
+                if envelope:
+                    self.report.would_write('climate_envelope_gbif')
+                    envelopes_created += 1
+                    self.stats['inserted'] += 1
+            else:
+                # Save occurrences
+                saved_count = self._save_occurrences(species_id, climate_data)
+                total_occurrences += saved_count
+
+                # Save envelope
+                if envelope:
+                    self._save_envelope(species_id, envelope)
+                    self._update_analysis(species_id)
+                    envelopes_created += 1
+                    self.stats['inserted'] += 1
+                self._saved_checksum(key, checksum)
 
             processed += 1
             self.stats['processed'] += 1
@@ -444,14 +475,15 @@ class GBIFOccurrenceCrawler(BaseCrawler):
                         text("""
                             INSERT INTO gbif_occurrences (
                                 species_id, gbif_id, latitude, longitude,
-                                coordinate_uncertainty_m, year, country_code,
+                                coordinate_uncertainty_m, year, country_code, elevation_m,
                                 bio1, bio5, bio6, bio7, bio12, bio15
                             ) VALUES (
                                 :species_id, :gbif_id, :lat, :lon,
-                                :uncertainty, :year, :country,
+                                :uncertainty, :year, :country, :elevation,
                                 :bio1, :bio5, :bio6, :bio7, :bio12, :bio15
                             )
-                            ON CONFLICT (gbif_id) DO NOTHING
+                            ON CONFLICT (gbif_id) DO UPDATE SET
+                                elevation_m = COALESCE(gbif_occurrences.elevation_m, EXCLUDED.elevation_m)
                         """),
                         {
                             'species_id': species_id,
@@ -461,6 +493,7 @@ class GBIFOccurrenceCrawler(BaseCrawler):
                             'uncertainty': d.get('uncertainty_m'),
                             'year': d.get('year'),
                             'country': d.get('country_code'),
+                            'elevation': d.get('elevation_m'),
                             'bio1': d.get('bio1'),
                             'bio5': d.get('bio5'),
                             'bio6': d.get('bio6'),
@@ -523,7 +556,7 @@ class GBIFOccurrenceCrawler(BaseCrawler):
             session.commit()
 
     def _update_analysis(self, species_id: int) -> None:
-        """Update the analysis table for this species."""
+        """Update the analysis table and elevation range for this species."""
         with Session(self.engine) as session:
             try:
                 session.execute(
@@ -534,6 +567,16 @@ class GBIFOccurrenceCrawler(BaseCrawler):
             except Exception as e:
                 self.logger.debug(f"Analysis update error: {e}")
 
+            try:
+                session.execute(
+                    text("SELECT refresh_gbif_elevation_range(:species_id)"),
+                    {'species_id': species_id}
+                )
+                session.commit()
+            except Exception as e:
+                session.rollback()
+                self.logger.debug(f"Elevation range update error: {e}")
+
     def transform(self, raw_data: Dict) -> Dict:
         """Transform is not used - we handle everything in fetch_data."""
         return raw_data
@@ -571,7 +571,7 @@ class GIFTCrawler(BaseCrawler):
 
             # Combine traits using Climber.R logic and save
             for record in self.combine_species_traits(species_data):
-                self._save(record)
+                self._save_or_preview(record)
                 self.stats['processed'] += 1
 
             self._log_success()
@@ -13,6 +13,7 @@ import pandas as pd
 import json
 import os
 import tempfile
+from sqlalchemy import text
 from .base import BaseCrawler
 
 
@@ -255,6 +256,8 @@ class REFLORACrawler(BaseCrawler):
 
         # Handle Brazilian state distribution
         distributions = raw_data.get('distributions', [])
+        endemic_brazil = False
+        all_domains = set()
         if distributions:
             brazil_dist = []
             for dist in distributions:
@@ -275,6 +278,9 @@ class REFLORACrawler(BaseCrawler):
                         except (json.JSONDecodeError, TypeError):
                             pass
 
+                    endemic_brazil = endemic_brazil or is_endemic
+                    all_domains.update(d for d in (domains or []) if isinstance(d, str) and d.strip())
+
                     brazil_dist.append({
                         'state_code': location_id,
                         'establishment': dist.get('establishmentMeans'),
@@ -285,8 +291,28 @@ class REFLORACrawler(BaseCrawler):
             if brazil_dist:
                 transformed['brazil_distribution'] = brazil_dist
 
+        # Species-level checklist attributes (species_brazil_flora), used by
+        # the domain and vegetation-type filters of /api/recommend
+        vegetation_types = traits.get('vegetation_types') or []
+        if 'brazil_distribution' in transformed or vegetation_types:
+            transformed['brazil_flora'] = {
+                'is_endemic': endemic_brazil,
+                'phytogeographic_domains': sorted(d.strip() for d in all_domains),
+                'vegetation_types': sorted({v.strip() for v in vegetation_types if isinstance(v, str) and v.strip()}),
+            }
+
         return transformed
 
+    def run(self, mode: str = 'incremental', **kwargs):
+        """Run the crawler, then apply the checklist status to species_regions."""
+        super().run(mode=mode, **kwargs)
+        if self.dry_run:
+            return
+
+        with self.engine.begin() as conn:
+            updated = conn.execute(text("SELECT refresh_brazil_flora_regions()")).scalar()
+        self.logger.info(f"Updated {updated} Brazilian species_regions rows from the checklist")
+
     def _map_life_form(self, life_form: str) -> str:
         """Map REFLORA life form to standardized growth_form."""
         if not life_form:
@@ -6,8 +6,10 @@ Usage:
     python -m crawlers.run --source gbif --mode incremental
     python -m crawlers.run --source all --mode full
     python -m crawlers.run --list
+    python -m crawlers.run --source reflora --dry-run --report reflora.json
 """
 import argparse
+import json
 import os
 import sys
 import logging
@@ -93,6 +95,24 @@ def parse_args():
         help='Refresh unified tables (species_unified, species_regions) after crawler run'
     )
 
+    parser.add_argument(
+        '--dry-run',
+        action='store_true',
+        help='Fetch and validate without writing; print a validation report'
+    )
+
+    parser.add_argument(
+        '--report',
+        default=None,
+        help='With --dry-run, also write the validation report(s) to this JSON file'
+    )
+
+    parser.add_argument(
+        '--force',
+        action='store_true',
+        help='Save records even if unchanged since the last run (checksums are still updated)'
+    )
+
     parser.add_argument(
         '--only-refresh',
         action='store_true',
@@ -102,9 +122,10 @@ def parse_args():
     return parser.parse_args()
 
 
-def run_crawler(source: str, mode: str, db_url: str, refresh_unified: bool = False, **kwargs):
-    """Run a single crawler."""
-    logger.info(f"Running {source} crawler in {mode} mode")
+def run_crawler(source: str, mode: str, db_url: str, refresh_unified: bool = False,
+                dry_run: bool = False, reports: list = None, force: bool = False, **kwargs):
+    """Run a single crawler. With dry_run its validation report is appended to reports."""
+    logger.info(f"Running {source} crawler in {mode} mode{' (dry run)' if dry_run else ''}")
     start_time = datetime.now()
 
     try:
@@ -113,12 +134,21 @@ def run_crawler(source: str, mode: str, db_url: str, refresh_unified: bool = Fal
             logger.error(f"Unknown crawler: {source}")
             return False
 
+        crawler.dry_run = dry_run
+        crawler.force = force
         crawler.run(mode=mode, **kwargs)
 
         elapsed = datetime.now() - start_time
         logger.info(f"Completed {source} in {elapsed}")
         logger.info(f"Stats: {crawler.stats}")
 
+        if dry_run:
+            report = crawler.validation_report()
+            print(json.dumps(report, indent=2, ensure_ascii=False))
+            if reports is not None:
+                reports.append(report)
+            return True
+
         # Refresh unified tables if requested
         if refresh_unified:
             crawler.refresh_unified_tables()
@@ -153,6 +183,13 @@ def refresh_unified_tables(db_url: str):
         return False
 
 
+def write_reports(path: str, reports: list):
+    """Write dry-run validation reports to a JSON file."""
+    with open(path, 'w', encoding='utf-8') as f:
+        json.dump(reports[0] if len(reports) == 1 else reports, f, indent=2, ensure_ascii=False)
+    logger.info(f"Validation report written to {path}")
+
+
 def run_all_crawlers(mode: str, db_url: str, **kwargs):
     """Run all crawlers sequentially."""
     results = {}
@@ -230,15 +267,25 @@ def main():
             kwargs['limit'] = args.limit
         if args.by_family:
             kwargs['by_family'] = True
+        if args.force:
+            kwargs['force'] = True
+        reports = []
+        if args.dry_run:
+            kwargs['dry_run'] = True
+            kwargs['reports'] = reports
 
         if args.source == 'all':
             run_all_crawlers(args.mode, db_url, **kwargs)
             # Refresh unified tables after all crawlers if requested
-            if args.refresh_unified:
+            if args.refresh_unified and not args.dry_run:
                 refresh_unified_tables(db_url)
+            if args.report and reports:
+                write_reports(args.report, reports)
         else:
             success = run_crawler(args.source, args.mode, db_url,
                                   refresh_unified=args.refresh_unified, **kwargs)
+            if args.report and reports:
+                write_reports(args.report, reports)
             sys.exit(0 if success else 1)
     else:
         logger.error("No source specified. Use --source, --list, or --only-refresh")
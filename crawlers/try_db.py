@@ -24,6 +24,7 @@ class TRYCrawler(BaseCrawler):
     """
 
     name = 'try'
+    creates_species = False
 
     # Path to pre-processed TRY data
     DATA_FILE = 'data/TRY_lifespan_numeric_processed.txt'
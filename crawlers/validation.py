@@ -0,0 +1,193 @@
+"""Validation report for dry-run crawler runs.
+
+With --dry-run a crawler fetches, transforms and validates as usual but
+writes nothing; each record is checked against the schema constraints it
+would hit on insert and against values that are legal but unlikely, and
+the report summarizes what the import would do so curators can review it
+before committing.
+"""
+import re
+from collections import Counter, defaultdict
+from typing import Dict, List, Optional, Tuple
+
+# Column limits from database/schema.sql
+MAX_LENGTHS = {
+    'canonical_name': 255,
+    'genus': 100,
+    'family': 100,
+    'taxonomic_status': 50,
+}
+TRAIT_MAX_LENGTHS = {
+    'growth_form': 50,
+    'stratum': 20,
+    'life_form': 100,
+    'woodiness': 50,
+    'dispersal_syndrome': 100,
+    'deciduousness': 50,
+}
+COMMON_NAME_MAX_LENGTH = 255
+
+GROWTH_FORMS = {
+    'tree', 'shrub', 'subshrub', 'palm', 'liana', 'vine',
+    'forb', 'graminoid', 'fern', 'bamboo', 'succulent',
+    'aquatic', 'epiphyte', 'other', 'herb', 'climber', 'grass', 'scrambler',
+}
+TAXONOMIC_STATUSES = {'accepted', 'synonym', 'unresolved'}
+
+MAX_PLAUSIBLE_HEIGHT_M = 120    # Tallest known trees are about 116 m
+MAX_PLAUSIBLE_LIFESPAN = 15000  # Larrea tridentata clones, about 11,700 years
+
+# Genus plus epithet, optionally an infraspecific rank and name
+BINOMIAL = re.compile(
+    r"^[A-Z][a-zë-]+( ×)? [a-zë×-]+( (subsp\.|var\.|f\.|subvar\.) [a-zë-]+)?$"
+)
+
+EXAMPLES_PER_ISSUE = 10
+
+
+def check_record(data: Dict) -> Tuple[List[str], List[str]]:
+    """
+    Check a transformed record.
+
+    Returns:
+        (violations, suspicious) issue codes. Violations would fail or be
+        truncated on insert; suspicious values would be stored as they are.
+    """
+    violations = []
+    suspicious = []
+
+    for col, limit in MAX_LENGTHS.items():
+        value = data.get(col)
+        if isinstance(value, str) and len(value) > limit:
+            violations.append(f'{col}_too_long')
+
+    name = data.get('canonical_name') or ''
+    if name and not BINOMIAL.match(name):
+        suspicious.append('canonical_name_not_binomial')
+
+    genus = data.get('genus')
+    if genus and name and not name.startswith(genus + ' '):
+        suspicious.append('genus_mismatch')
+
+    status = data.get('taxonomic_status')
+    if status and status not in TAXONOMIC_STATUSES:
+        suspicious.append('unknown_taxonomic_status')
+
+    traits = data.get('traits') or {}
+    for col, limit in TRAIT_MAX_LENGTHS.items():
+        value = traits.get(col)
+        if isinstance(value, str) and len(value) > limit:
+            violations.append(f'{col}_too_long')
+
+    growth_form = traits.get('growth_form')
+    if growth_form and growth_form not in GROWTH_FORMS:
+        suspicious.append('unknown_growth_form')
+
+    height = traits.get('max_height_m')
+    if height is not None:
+        try:
+            height = float(height)
+        except (TypeError, ValueError):
+            violations.append('max_height_m_not_numeric')
+        else:
+            if height <= 0 or height > MAX_PLAUSIBLE_HEIGHT_M:
+                suspicious.append('implausible_max_height_m')
+
+    lifespan = traits.get('lifespan_years')
+    if lifespan is not None:
+        try:
+            lifespan = float(lifespan)
+        except (TypeError, ValueError):
+            violations.append('lifespan_years_not_numeric')
+        else:
+            if lifespan < 1 or lifespan > MAX_PLAUSIBLE_LIFESPAN:
+                suspicious.append('implausible_lifespan_years')
+
+    for common in data.get('common_names') or []:
+        if len(common.get('name') or '') > COMMON_NAME_MAX_LENGTH:
+            violations.append('common_name_too_long')
+            break
+
+    return violations, suspicious
+
+
+class ValidationReport:
+    """Accumulates what a dry run would have done."""
+
+    def __init__(self, source: str):
+        self.source = source
+        self.counts = Counter()
+        self.violations = Counter()
+        self.suspicious = Counter()
+        self.examples = defaultdict(list)
+        self.writes = Counter()
+        self._seen = set()
+
+    def add(self, data: Dict, exists: Optional[bool]):
+        """
+        Record a transformed, valid record.
+
+        Args:
+            data: Transformed data
+            exists: Whether the species is already in the database; None if
+                the crawler does not create species
+        """
+        name = data.get('canonical_name')
+        self.counts['valid'] += 1
+
+        if name in self._seen:
+            # The upsert would merge it into the earlier row
+            self.counts['duplicates'] += 1
+            self._example('duplicate', name)
+        else:
+            self._seen.add(name)
+            if exists:
+                self.counts['updated'] += 1
+            elif exists is None:
+                self.counts['skipped_unknown_species'] += 1
+            else:
+                self.counts['new'] += 1
+
+        violations, suspicious = check_record(data)
+        for issue in violations:
+            self.violations[issue] += 1
+            self._example(issue, name)
+        for issue in suspicious:
+            self.suspicious[issue] += 1
+            self._example(issue, name)
+
+    def unchanged(self):
+        """Count a record skipped because its checksum is unchanged."""
+        self.counts['unchanged'] += 1
+
+    def would_write(self, table: str, n: int = 1):
+        """Count rows a crawler writing outside _save would have written."""
+        self.writes[table] += n
+
+    def invalid(self, data: Optional[Dict]):
+        """Record a record that failed the crawler's validate()."""
+        self.counts['invalid'] += 1
+        name = (data or {}).get('canonical_name') or '(no canonical_name)'
+        self._example('invalid', name)
+
+    def _example(self, issue: str, name: Optional[str]):
+        if len(self.examples[issue]) < EXAMPLES_PER_ISSUE:
+            self.examples[issue].append(name)
+
+    def to_dict(self, processed: int) -> Dict:
+        return {
+            'source': self.source,
+            'dry_run': True,
+            'rows_parsed': processed,
+            'valid': self.counts['valid'],
+            'invalid': self.counts['invalid'],
+            'new_species': self.counts['new'],
+            'updated_species': self.counts['updated'],
+            'unchanged': self.counts['unchanged'],
+            'duplicates': self.counts['duplicates'],
+            'skipped_unknown_species': self.counts['skipped_unknown_species'],
+            'constraint_violations': dict(self.violations),
+            'suspicious_values': dict(self.suspicious),
+            'examples': dict(self.examples),
+            'would_write': dict(self.writes),
+        }
@@ -585,6 +585,9 @@ class WCVPCrawler(BaseCrawler):
         if kwargs.get('skip_distribution', False):
             self.logger.info("Skipping distribution data (skip_distribution=True)")
             return
+        if self.dry_run:
+            self.logger.info("Dry run: not processing distribution data")
+            return
 
         self.logger.info("Processing WCVP distribution data...")
         self._save_distribution_data()
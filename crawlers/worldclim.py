@@ -25,6 +25,7 @@ class WorldClimCrawler(BaseCrawler):
     """
 
     name = 'worldclim'
+    writes_in_fetch = True
 
     # Updated URL (changed from biogeo.ucdavis.edu to geodata.ucdavis.edu)
     BASE_URL = 'https://geodata.ucdavis.edu/climate/worldclim/2_1/base'
@@ -111,9 +112,21 @@ class WorldClimCrawler(BaseCrawler):
 
         processed = 0
         errors = 0
+        raster = self._raster_identity(resolution)
 
         for tdwg_code, geom_wkt in tdwg_regions:
             try:
+                # Checksum the inputs before the zonal statistics, keyed by
+                # tdwg_code like the tdwg_climate row: an unchanged region is
+                # skipped cheaply, and one computed at another resolution is
+                # recomputed rather than taken as current
+                checksum = None
+                if store_db:
+                    checksum = self._changed_checksum(
+                        tdwg_code, {'geom': geom_wkt, 'resolution': resolution, 'raster': raster})
+                    if checksum is None:
+                        continue
+
                 climate_data = self._calculate_zonal_stats(tdwg_code, geom_wkt, resolution)
 
                 if climate_data:
@@ -122,8 +135,10 @@ class WorldClimCrawler(BaseCrawler):
                     climate_data['tdwg_code'] = tdwg_code
                     climate_data['resolution'] = resolution
 
-                    if store_db:
-                        self._store_climate_data(climate_data)
+                    if checksum and self.dry_run:
+                        self.report.would_write('tdwg_climate')
+                    elif checksum and self._store_climate_data(climate_data):
+                        self._saved_checksum(tdwg_code, checksum)
 
                     processed += 1
                     yield climate_data
@@ -179,6 +194,13 @@ class WorldClimCrawler(BaseCrawler):
             self.logger.error(f"Download failed: {e}")
             return {'status': 'error', 'error': str(e)}
 
+    def _raster_identity(self, resolution: str) -> Dict:
+        """The downloaded bio zip of a resolution, as a checksum input."""
+        filename = f"wc2.1_{resolution}_bio.zip"
+        local_path = os.path.join(self._cache_dir, filename)
+        size = os.path.getsize(local_path) if os.path.exists(local_path) else None
+        return {'file': filename, 'bytes': size}
+
     def _get_tdwg_regions(self) -> List[Tuple[str, str]]:
         """Get all TDWG Level 3 regions with their geometries."""
         query = text("""
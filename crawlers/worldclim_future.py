@@ -0,0 +1,251 @@
+"""WorldClim CMIP6 future climate crawler, for climate analog regions."""
+from typing import Generator, Dict, Any, Optional, List, Tuple
+import requests
+import os
+import tempfile
+from sqlalchemy import text
+from sqlalchemy.orm import Session
+from .base import BaseCrawler
+
+
+class WorldClimFutureCrawler(BaseCrawler):
+    """
+    Crawler for WorldClim CMIP6 downscaled climate projections.
+
+    Source: https://www.worldclim.org/data/cmip6/cmip6climate.html
+    Data: Bioclimatic variables per GCM, SSP and 20-year period, one
+          19-band GeoTIFF each
+    Resolution: 10m, 5m, 2.5m, 30s
+
+    For each scenario and GCM, the means of the variables of the climate
+    match (bio1, bio5, bio6, bio12, bio15) are computed per TDWG Level 3
+    region, with the same zonal statistics as the worldclim crawler, and
+    stored in tdwg_climate_future (migration 039). The 'ensemble' row of a
+    region is the mean over the GCMs loaded; /api/climate/analogs reads it
+    by default.
+    """
+
+    name = 'worldclim_future'
+    writes_in_fetch = True
+
+    BASE_URL = 'https://geodata.ucdavis.edu/cmip6'
+
+    # Scenario codes of tdwg_climate_future: '<ssp>_<period midpoint>'
+    SCENARIOS = {
+        'ssp245_2050': ('ssp245', '2041-2060'),
+        'ssp245_2070': ('ssp245', '2061-2080'),
+        'ssp585_2050': ('ssp585', '2041-2060'),
+        'ssp585_2070': ('ssp585', '2061-2080'),
+    }
+
+    # GCMs averaged into the ensemble, spanning low and high climate
+    # sensitivity
+    GCMS = ['ACCESS-CM2', 'EC-Earth3-Veg', 'MIROC6', 'MPI-ESM1-2-HR', 'UKESM1-0-LL']
+
+    # Band of each stored variable in the bioc GeoTIFF
+    BANDS = {'bio1': 1, 'bio5': 5, 'bio6': 6, 'bio12': 12, 'bio15': 15}
+
+    def __init__(self, db_url: str):
+        super().__init__(db_url)
+        self._cache_dir = os.path.join(tempfile.gettempdir(), 'worldclim_future_cache')
+        os.makedirs(self._cache_dir, exist_ok=True)
+
+    def fetch_data(self, mode='incremental', **kwargs) -> Generator[Dict[str, Any], None, None]:
+        """
+        Compute the projected climate of every TDWG region per scenario and GCM.
+
+        Args:
+            mode: 'full' or 'incremental'
+            **kwargs: Additional parameters
+                - resolution: '10m', '5m', '2.5m', '30s' (default: '10m')
+                - scenarios: scenario codes (default: all of SCENARIOS)
+                - gcms: GCM names (default: GCMS)
+
+        Yields:
+            Projected climate per TDWG region, scenario and GCM
+        """
+        resolution = kwargs.get('resolution', '10m')
+        scenarios = kwargs.get('scenarios') or list(self.SCENARIOS)
+        gcms = kwargs.get('gcms') or self.GCMS
+
+        tdwg_regions = self._get_tdwg_regions()
+        if not tdwg_regions:
+            self.logger.error("No TDWG regions found in database")
+            yield {'status': 'error', 'error': 'No TDWG regions in database'}
+            return
+
+        for scenario in scenarios:
+            if scenario not in self.SCENARIOS:
+                self.logger.error(f"Unknown scenario {scenario}")
+                yield {'status': 'error', 'error': f'Unknown scenario {scenario}'}
+                continue
+
+            loaded = 0
+            for gcm in gcms:
+                tif_path = self._download(resolution, scenario, gcm)
+                if tif_path is None:
+                    yield {'status': 'error', 'error': f'Download failed: {scenario} {gcm}'}
+                    continue
+                loaded += 1
+                raster = {'file': os.path.basename(tif_path), 'bytes': os.path.getsize(tif_path)}
+
+                for tdwg_code, geom_wkt in tdwg_regions:
+                    try:
+                        # Keyed like the tdwg_climate_future row, checked
+                        # before the zonal statistics
+                        key = f'{tdwg_code}:{scenario}:{gcm}'
+                        checksum = self._changed_checksum(
+                            key, {'geom': geom_wkt, 'resolution': resolution, 'raster': raster})
+                        if checksum is None:
+                            continue
+
+                        climate_data = self._calculate_zonal_stats(tdwg_code, geom_wkt, tif_path)
+                        if not climate_data:
+                            continue
+                        climate_data.update({
+                            'tdwg_code': tdwg_code, 'scenario': scenario,
+                            'gcm': gcm, 'resolution': resolution,
+                        })
+
+                        if self.dry_run:
+                            self.report.would_write('tdwg_climate_future')
+                        elif self._store_climate_data(climate_data):
+                            self._saved_checksum(key, checksum)
+                        yield climate_data
+
+                    except Exception as e:
+                        self.logger.error(f"Error processing {tdwg_code} {scenario} {gcm}: {e}")
+
+            if loaded and not self.dry_run:
+                self._store_ensemble(scenario, resolution)
+            yield {'status': 'completed', 'scenario': scenario, 'gcms': loaded}
+
+    def _download(self, resolution: str, scenario: str, gcm: str) -> Optional[str]:
+        """Download the bioc GeoTIFF of a scenario and GCM, or use the cached one."""
+        ssp, period = self.SCENARIOS[scenario]
+        filename = f"wc2.1_{resolution}_bioc_{gcm}_{ssp}_{period}.tif"
+        local_path = os.path.join(self._cache_dir, filename)
+
+        if os.path.exists(local_path):
+            self.logger.info(f"Using cached {filename}")
+            return local_path
+
+        url = f"{self.BASE_URL}/{resolution}/{gcm}/{ssp}/{filename}"
+        self.logger.info(f"Downloading {url}")
+
+        try:
+            response = requests.get(url, stream=True, timeout=1200)
+            response.raise_for_status()
+            partial = local_path + '.part'
+            with open(partial, 'wb') as f:
+                for chunk in response.iter_content(chunk_size=8192):
+                    f.write(chunk)
+            os.replace(partial, local_path)
+            self.logger.info(f"Downloaded {filename} ({os.path.getsize(local_path) / 1024 / 1024:.1f} MB)")
+            return local_path
+
+        except Exception as e:
+            self.logger.error(f"Download failed: {e}")
+            return None
+
+    def _get_tdwg_regions(self) -> List[Tuple[str, str]]:
+        """Get all TDWG Level 3 regions with their geometries."""
+        query = text("""
+            SELECT level3_code, ST_AsText(geom) as geom_wkt
+            FROM tdwg_level3
+            WHERE geom IS NOT NULL
+            ORDER BY level3_code
+        """)
+
+        with Session(self.engine) as session:
+            result = session.execute(query)
+            return result.fetchall()
+
+    def _calculate_zonal_stats(self, tdwg_code: str, geom_wkt: str,
+                               tif_path: str) -> Optional[Dict]:
+        """Mean of each stored variable within a TDWG region."""
+        try:
+            from rasterstats import zonal_stats
+            from shapely import wkt
+        except ImportError:
+            self.logger.error("rasterstats and shapely required for climate extraction")
+            return None
+
+        try:
+            geom_geojson = wkt.loads(geom_wkt).__geo_interface__
+        except Exception as e:
+            self.logger.error(f"Error parsing geometry for {tdwg_code}: {e}")
+            return None
+
+        climate_data = {}
+        for var_name, band in self.BANDS.items():
+            stats = zonal_stats(geom_geojson, tif_path, band=band,
+                                stats=['mean', 'count'], nodata=-9999)
+            if stats and stats[0]['count'] > 0 and stats[0]['mean'] is not None:
+                climate_data[f'{var_name}_mean'] = stats[0]['mean']
+
+        return climate_data if climate_data else None
+
+    def _store_climate_data(self, climate_data: Dict) -> bool:
+        """Store the projected climate of one region, scenario and GCM."""
+        columns = ['tdwg_code', 'scenario', 'gcm', 'resolution'] + [
+            f'{var}_mean' for var in self.BANDS if f'{var}_mean' in climate_data
+        ]
+        update_clause = ', '.join(
+            f'{col} = EXCLUDED.{col}' for col in columns
+            if col not in ('tdwg_code', 'scenario', 'gcm')
+        )
+
+        query = text(f"""
+            INSERT INTO tdwg_climate_future ({', '.join(columns)})
+            VALUES ({', '.join(f':{col}' for col in columns)})
+            ON CONFLICT (tdwg_code, scenario, gcm) DO UPDATE SET
+            {update_clause},
+            created_at = CURRENT_TIMESTAMP
+        """)
+
+        try:
+            with Session(self.engine) as session:
+                session.execute(query, {col: climate_data[col] for col in columns})
+                session.commit()
+            return True
+        except Exception as e:
+            self.logger.error(f"Error storing future climate data: {e}")
+            return False
+
+    def _store_ensemble(self, scenario: str, resolution: str):
+        """Recompute the 'ensemble' rows of a scenario as the mean over its GCMs."""
+        query = text("""
+            INSERT INTO tdwg_climate_future (tdwg_code, scenario, gcm, resolution,
+                bio1_mean, bio5_mean, bio6_mean, bio12_mean, bio15_mean)
+            SELECT tdwg_code, scenario, 'ensemble', :resolution,
+                   AVG(bio1_mean), AVG(bio5_mean), AVG(bio6_mean), AVG(bio12_mean), AVG(bio15_mean)
+            FROM tdwg_climate_future
+            WHERE scenario = :scenario AND gcm <> 'ensemble'
+            GROUP BY tdwg_code, scenario
+            ON CONFLICT (tdwg_code, scenario, gcm) DO UPDATE SET
+                resolution = EXCLUDED.resolution,
+                bio1_mean = EXCLUDED.bio1_mean,
+                bio5_mean = EXCLUDED.bio5_mean,
+                bio6_mean = EXCLUDED.bio6_mean,
+                bio12_mean = EXCLUDED.bio12_mean,
+                bio15_mean = EXCLUDED.bio15_mean,
+                created_at = CURRENT_TIMESTAMP
+        """)
+
+        try:
+            with Session(self.engine) as session:
+                session.execute(query, {'scenario': scenario, 'resolution': resolution})
+                session.commit()
+        except Exception as e:
+            self.logger.error(f"Error storing the {scenario} ensemble: {e}")
+
+    def transform(self, raw_data: Dict) -> Dict:
+        """Transform is handled during zonal stats calculation."""
+        return raw_data
+
+    def validate(self, data: Dict) -> bool:
+        """Validate future climate record."""
+        if data.get('status') in ['completed', 'error']:
+            return True
+        return bool(data.get('tdwg_code') and data.get('scenario') and data.get('gcm'))
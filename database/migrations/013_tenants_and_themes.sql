@@ -0,0 +1,62 @@
+-- Migration 013: Tenants, API keys and whitelabel report themes
+-- Adds tenant-scoped API keys for the query-explorer API and a per-tenant
+-- theme (logo + color palette) applied to generated reports.
+
+-- ============================================================================
+-- TABLE 1: tenants
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS tenants (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(50) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- ============================================================================
+-- TABLE 2: api_keys
+-- Only the SHA-256 hash of each key is stored
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    tenant_id INTEGER REFERENCES tenants(id) ON DELETE CASCADE,
+    owner VARCHAR(255) NOT NULL,        -- Person or service the key was issued to
+    role VARCHAR(20) NOT NULL DEFAULT 'user', -- user, curator, admin
+    revoked BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    CHECK (role IN ('user', 'curator', 'admin'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_tenant ON api_keys(tenant_id);
+
+-- ============================================================================
+-- TABLE 3: tenant_themes
+-- Logo and palette used by PDF reports, label sheets and HTML bundles
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS tenant_themes (
+    tenant_id INTEGER PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+
+    -- Logo (stored inline; max 1 MB enforced by the API)
+    logo BYTEA,
+    logo_content_type VARCHAR(50),
+
+    -- Palette (#RRGGBB)
+    primary_color CHAR(7),
+    secondary_color CHAR(7),
+    accent_color CHAR(7),
+    text_color CHAR(7),
+    background_color CHAR(7),
+
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER trigger_tenant_themes_updated_at
+    BEFORE UPDATE ON tenant_themes
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at();
+
+COMMENT ON TABLE tenant_themes IS 'Per-tenant whitelabel theme applied by the report renderer';
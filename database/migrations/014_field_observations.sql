@@ -0,0 +1,45 @@
+-- Migration 014: User field survey observations
+-- Georeferenced species observations submitted through POST /api/observations.
+-- Accepted observations (after curator review) are folded into
+-- species_ecoregions and species_regions.
+
+CREATE TABLE IF NOT EXISTS observations (
+    id SERIAL PRIMARY KEY,
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    submitted_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+
+    -- Location
+    latitude DECIMAL(10,6) NOT NULL,
+    longitude DECIMAL(10,6) NOT NULL,
+    coordinate_uncertainty_m INTEGER,
+    tdwg_code VARCHAR(10),      -- Resolved at submission time
+    eco_id INTEGER,             -- Resolved at submission time
+
+    observed_on DATE,
+    establishment VARCHAR(20) DEFAULT 'unknown', -- native, introduced, unknown
+    notes TEXT,
+
+    -- Review state
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, accepted, rejected
+    applied_at TIMESTAMP,       -- When folded into species_regions/species_ecoregions
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (status IN ('pending', 'accepted', 'rejected')),
+    CHECK (establishment IN ('native', 'introduced', 'unknown'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_observations_species ON observations(species_id);
+CREATE INDEX IF NOT EXISTS idx_observations_submitter ON observations(submitted_by);
+CREATE INDEX IF NOT EXISTS idx_observations_pending ON observations(created_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS observation_photos (
+    id SERIAL PRIMARY KEY,
+    observation_id INTEGER NOT NULL REFERENCES observations(id) ON DELETE CASCADE,
+    content_type VARCHAR(50) NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_observation_photos_obs ON observation_photos(observation_id);
+
+COMMENT ON TABLE observations IS 'Citizen-science field observations; applied to distribution tables once accepted by a curator';
@@ -0,0 +1,78 @@
+-- Migration 015: Curator review queue
+-- User suggestions (common names, trait values) join field observations in a
+-- moderation queue. Reviews record the curator and reason, and submitters are
+-- notified of the outcome.
+
+-- ============================================================================
+-- Review columns on observations
+-- ============================================================================
+
+ALTER TABLE observations
+    ADD COLUMN IF NOT EXISTS reviewed_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS review_reason TEXT;
+
+-- ============================================================================
+-- TABLE 1: common_name_suggestions
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS common_name_suggestions (
+    id SERIAL PRIMARY KEY,
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    common_name VARCHAR(255) NOT NULL,
+    language VARCHAR(10) NOT NULL,
+    reference TEXT,
+    submitted_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    reviewed_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP,
+    review_reason TEXT,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (status IN ('pending', 'accepted', 'rejected'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_cn_suggestions_pending ON common_name_suggestions(created_at) WHERE status = 'pending';
+
+-- ============================================================================
+-- TABLE 2: trait_suggestions
+-- Proposed corrections to species_unified values
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS trait_suggestions (
+    id SERIAL PRIMARY KEY,
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    trait VARCHAR(50) NOT NULL,    -- species_unified column name
+    value TEXT NOT NULL,
+    reference TEXT,
+    submitted_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    reviewed_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP,
+    review_reason TEXT,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (status IN ('pending', 'accepted', 'rejected'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_trait_suggestions_pending ON trait_suggestions(created_at) WHERE status = 'pending';
+
+-- ============================================================================
+-- TABLE 3: notifications
+-- Messages to API key owners (review outcomes)
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    api_key_id INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,        -- review_accepted, review_rejected
+    subject_type VARCHAR(50),         -- observation, common_name, trait
+    subject_id INTEGER,
+    message TEXT NOT NULL,
+    read_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(api_key_id, created_at DESC) WHERE read_at IS NULL;
@@ -0,0 +1,94 @@
+-- Migration 016: Establishment means on species_regions
+-- Replaces the is_native/is_introduced pair with a richer status:
+--   native       - occurs naturally in the region
+--   naturalized  - introduced, self-sustaining populations
+--   invasive     - introduced, spreading with ecological impact
+--   cultivated   - only known from cultivation in the region
+-- The boolean flags are kept (and synchronized by trigger) for existing queries.
+
+BEGIN;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'establishment_means') THEN
+        CREATE TYPE establishment_means AS ENUM ('native', 'naturalized', 'invasive', 'cultivated');
+    END IF;
+END$$;
+
+ALTER TABLE species_regions
+ADD COLUMN IF NOT EXISTS establishment_means establishment_means;
+
+COMMENT ON COLUMN species_regions.establishment_means IS 'Status de estabelecimento: native, naturalized, invasive, cultivated';
+
+-- =============================================
+-- Migrate existing flags
+-- =============================================
+
+-- 1. Native records
+UPDATE species_regions
+SET establishment_means = 'native'
+WHERE establishment_means IS NULL AND is_native = TRUE;
+
+-- 2. Introduced records: REFLORA distinguishes cultivated from naturalized
+--    for Brazilian states, so use it where available
+UPDATE species_regions sr
+SET establishment_means = 'cultivated'
+FROM species_distribution_brazil sdb
+JOIN brazil_state_tdwg_map m ON sdb.state_code = m.state_code
+WHERE sr.establishment_means IS NULL
+  AND sr.is_introduced = TRUE
+  AND sdb.species_id = sr.species_id
+  AND m.tdwg_code = sr.tdwg_code
+  AND sdb.establishment = 'CULTIVADA'
+  AND NOT EXISTS (
+      SELECT 1 FROM species_distribution_brazil sdb2
+      JOIN brazil_state_tdwg_map m2 ON sdb2.state_code = m2.state_code
+      WHERE sdb2.species_id = sr.species_id
+        AND m2.tdwg_code = sr.tdwg_code
+        AND sdb2.establishment = 'NATURALIZADA'
+  );
+
+-- 3. Remaining introduced records default to naturalized
+UPDATE species_regions
+SET establishment_means = 'naturalized'
+WHERE establishment_means IS NULL AND is_introduced = TRUE;
+
+-- =============================================
+-- Keep flags and establishment_means consistent
+-- =============================================
+
+CREATE OR REPLACE FUNCTION sync_establishment_means()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+       AND NEW.establishment_means IS NOT DISTINCT FROM OLD.establishment_means
+       AND (NEW.is_native IS DISTINCT FROM OLD.is_native
+            OR NEW.is_introduced IS DISTINCT FROM OLD.is_introduced) THEN
+        -- Legacy writer changed the flags: re-derive the status from them
+        NEW.establishment_means := NULL;
+    END IF;
+
+    IF NEW.establishment_means IS NULL THEN
+        -- Legacy writers only set the flags
+        IF NEW.is_native THEN
+            NEW.establishment_means := 'native';
+        ELSIF NEW.is_introduced THEN
+            NEW.establishment_means := 'naturalized';
+        END IF;
+    ELSE
+        NEW.is_native := NEW.establishment_means = 'native';
+        NEW.is_introduced := NEW.establishment_means <> 'native';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_sync_establishment_means ON species_regions;
+CREATE TRIGGER trigger_sync_establishment_means
+    BEFORE INSERT OR UPDATE ON species_regions
+    FOR EACH ROW
+    EXECUTE FUNCTION sync_establishment_means();
+
+CREATE INDEX IF NOT EXISTS idx_regions_establishment ON species_regions(tdwg_code, establishment_means);
+
+COMMIT;
@@ -0,0 +1,30 @@
+-- Migration 017: Trait quality flags
+-- Implausible trait values (e.g. 80 m herbs, 0.1 m trees) detected by the
+-- query-explorer data-quality job. Values with an open flag are ignored by the
+-- recommendation engine unless a request opts in.
+
+CREATE TABLE IF NOT EXISTS trait_quality_flags (
+    id SERIAL PRIMARY KEY,
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    trait VARCHAR(50) NOT NULL,          -- species_unified column (max_height_m, lifespan_years)
+    value DECIMAL(10,2),                 -- Value at detection time
+    growth_form VARCHAR(50),
+    rule VARCHAR(50) NOT NULL,           -- e.g. height_above_max, height_below_min
+    message TEXT NOT NULL,
+
+    status VARCHAR(20) NOT NULL DEFAULT 'open', -- open, resolved, dismissed
+    resolved_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMP,
+    resolution_note TEXT,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (status IN ('open', 'resolved', 'dismissed'))
+);
+
+-- One open flag per species/trait/rule; dismissed flags keep the job from re-flagging
+CREATE UNIQUE INDEX IF NOT EXISTS idx_trait_flags_unique_open
+    ON trait_quality_flags(species_id, trait, rule) WHERE status IN ('open', 'dismissed');
+CREATE INDEX IF NOT EXISTS idx_trait_flags_open
+    ON trait_quality_flags(species_id, trait) WHERE status = 'open';
+
+COMMENT ON TABLE trait_quality_flags IS 'Implausible trait values flagged for curator correction';
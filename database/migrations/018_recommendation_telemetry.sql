@@ -0,0 +1,38 @@
+-- Migration 018: Recommendation telemetry
+-- One anonymized row per /api/recommend execution (no caller identity, no
+-- coordinates; location is recorded at TDWG level only). Aggregated by
+-- /api/admin/reco-telemetry to guide algorithm optimization.
+
+CREATE TABLE IF NOT EXISTS recommendation_telemetry (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    -- Request shape
+    location_kind VARCHAR(10),          -- tdwg, state, coords
+    tdwg_code VARCHAR(10),
+    n_species_requested INTEGER,
+    climate_threshold DECIMAL(3,2),
+    plugins TEXT[],
+
+    -- Algorithm behaviour
+    candidate_pool_size INTEGER,
+    n_selected INTEGER,
+    greedy_iterations INTEGER,
+    candidate_evaluations BIGINT,       -- Marginal-diversity evaluations in the greedy loop
+
+    -- Timing
+    phase_ms JSONB,                     -- {"resolve": 12.3, "candidates": 840.1, ...}
+    total_ms DECIMAL(12,3),
+
+    -- Result
+    functional_diversity DECIMAL(6,3),
+    total_diversity_score DECIMAL(6,3),
+    n_families INTEGER,
+    n_growth_forms INTEGER,
+
+    error TEXT                          -- Set when the recommendation failed
+);
+
+CREATE INDEX IF NOT EXISTS idx_reco_telemetry_created ON recommendation_telemetry(created_at DESC);
+
+COMMENT ON TABLE recommendation_telemetry IS 'Anonymized per-request recommendation telemetry (no user or coordinate data)';
@@ -0,0 +1,75 @@
+-- Migration 019: Species elevation ranges
+-- Per-species altitudinal ranges used by the recommender to filter or score
+-- candidates by site elevation. TDWG units in the Atlantic Forest span from
+-- sea level to >2000 m, so climate alone does not separate montane species.
+--
+-- Sources:
+--   gbif  - 5th/95th percentiles of GBIF occurrence elevations (crawler)
+--   flora - published ranges from regional floras (scripts/load_flora_elevation.py)
+
+ALTER TABLE gbif_occurrences
+ADD COLUMN IF NOT EXISTS elevation_m DECIMAL(7,1);
+
+COMMENT ON COLUMN gbif_occurrences.elevation_m IS 'Elevation reported by the occurrence record (meters)';
+
+CREATE TABLE IF NOT EXISTS species_elevation_ranges (
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    source VARCHAR(20) NOT NULL,        -- gbif, flora
+    min_m DECIMAL(7,1),
+    max_m DECIMAL(7,1),
+    p05_m DECIMAL(7,1),                 -- gbif only
+    p95_m DECIMAL(7,1),                 -- gbif only
+    n_records INTEGER,
+    reference TEXT,                     -- Flora citation
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (species_id, source),
+    CHECK (source IN ('gbif', 'flora'))
+);
+
+COMMENT ON TABLE species_elevation_ranges IS 'Per-species elevation ranges from GBIF occurrences and published floras';
+
+-- =============================================
+-- GBIF aggregation
+-- =============================================
+
+CREATE OR REPLACE FUNCTION refresh_gbif_elevation_range(p_species_id INTEGER)
+RETURNS VOID AS $$
+BEGIN
+    DELETE FROM species_elevation_ranges
+    WHERE species_id = p_species_id AND source = 'gbif';
+
+    INSERT INTO species_elevation_ranges (species_id, source, min_m, max_m, p05_m, p95_m, n_records)
+    SELECT
+        p_species_id,
+        'gbif',
+        MIN(elevation_m),
+        MAX(elevation_m),
+        ROUND(percentile_cont(0.05) WITHIN GROUP (ORDER BY elevation_m)::numeric, 1),
+        ROUND(percentile_cont(0.95) WITHIN GROUP (ORDER BY elevation_m)::numeric, 1),
+        COUNT(*)
+    FROM gbif_occurrences
+    WHERE species_id = p_species_id
+      AND elevation_m IS NOT NULL
+    HAVING COUNT(*) >= 10;  -- Too few records give meaningless percentiles
+END;
+$$ LANGUAGE plpgsql;
+
+-- =============================================
+-- Unified view: flora > GBIF percentiles
+-- =============================================
+
+CREATE OR REPLACE VIEW species_elevation_unified AS
+SELECT
+    COALESCE(f.species_id, g.species_id) AS species_id,
+    CASE WHEN f.species_id IS NOT NULL THEN 'flora' ELSE 'gbif' END AS elevation_source,
+    COALESCE(f.min_m, g.p05_m) AS low_m,
+    COALESCE(f.max_m, g.p95_m) AS high_m
+FROM (SELECT * FROM species_elevation_ranges WHERE source = 'flora') f
+FULL OUTER JOIN (SELECT * FROM species_elevation_ranges WHERE source = 'gbif') g
+    ON f.species_id = g.species_id;
+
+COMMENT ON VIEW species_elevation_unified IS 'Best available elevation range per species (published flora, else GBIF 5th-95th percentile)';
+
+-- Backfill from occurrences that already have elevations
+SELECT refresh_gbif_elevation_range(species_id)
+FROM (SELECT DISTINCT species_id FROM gbif_occurrences WHERE elevation_m IS NOT NULL) s;
@@ -0,0 +1,49 @@
+-- Migration 020: Wetland indicator status
+-- Hydrological affinity per species, following the National Wetland Plant
+-- List categories:
+--   obligate_wetland     (OBL)  almost always in wetlands
+--   facultative_wetland  (FACW) usually in wetlands
+--   facultative          (FAC)  wetlands and uplands alike
+--   facultative_upland   (FACU) usually in uplands
+--   upland               (UPL)  almost never in wetlands
+-- Used by the recommender's site_hydrology preference (riparian/APP restoration).
+
+ALTER TABLE species_unified
+ADD COLUMN IF NOT EXISTS wetland_indicator VARCHAR(30),
+ADD COLUMN IF NOT EXISTS wetland_indicator_source VARCHAR(50);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'species_unified_wetland_indicator_check') THEN
+        ALTER TABLE species_unified ADD CONSTRAINT species_unified_wetland_indicator_check
+            CHECK (wetland_indicator IN ('obligate_wetland', 'facultative_wetland', 'facultative', 'facultative_upland', 'upland'));
+    END IF;
+END$$;
+
+COMMENT ON COLUMN species_unified.wetland_indicator IS 'Wetland indicator status: obligate_wetland, facultative_wetland, facultative, facultative_upland, upland';
+
+-- =============================================
+-- Derive from life forms where nothing better is known
+-- =============================================
+
+-- 1. WCVP hydrophytes (hydroannual, hydroperennial, hydrosubshrub...) and
+--    GIFT/REFLORA aquatics are obligate wetland plants
+UPDATE species_unified su
+SET wetland_indicator = 'obligate_wetland',
+    wetland_indicator_source = st.source
+FROM species_traits st
+WHERE st.species_id = su.species_id
+  AND su.wetland_indicator IS NULL
+  AND (st.life_form ILIKE '%hydro%' OR st.life_form ILIKE '%aquatic%'
+       OR st.growth_form = 'aquatic');
+
+-- 2. WCVP helophytes (rooted in waterlogged soil, emergent) are facultative wetland
+UPDATE species_unified su
+SET wetland_indicator = 'facultative_wetland',
+    wetland_indicator_source = st.source
+FROM species_traits st
+WHERE st.species_id = su.species_id
+  AND su.wetland_indicator IS NULL
+  AND st.life_form ILIKE '%helophyte%';
+
+CREATE INDEX IF NOT EXISTS idx_unified_wetland_indicator ON species_unified(wetland_indicator);
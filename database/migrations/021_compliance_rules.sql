@@ -0,0 +1,37 @@
+-- Migration 021: Restoration compliance rule sets
+-- Composition rules checked by the query-explorer compliance report
+-- (recommendations and /api/compliance/check). One rule set per state
+-- regulation, keyed by state code (BR-SP, BR-MG, ...); 'default' applies where
+-- no state rule set exists. Rules are JSON, see ComplianceRules in
+-- query-explorer/compliance.go:
+--   min_species, min_native_species, min_native_proportion,
+--   max_single_species_share, max_invasive_species, min_families,
+--   min_threatened_species
+
+CREATE TABLE IF NOT EXISTS compliance_rule_sets (
+    code VARCHAR(20) PRIMARY KEY,       -- 'default' or state code
+    name VARCHAR(255) NOT NULL,
+    reference TEXT,                     -- Regulation citation
+    rules JSONB NOT NULL DEFAULT '{}',
+    updated_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE compliance_rule_sets IS 'Per-state restoration composition rules (native share, richness, dominance)';
+
+-- Conservative generic rules; state regulations are configured by admins via
+-- PUT /api/admin/compliance/rules/{code} with the values of the current norm
+INSERT INTO compliance_rule_sets (code, name, reference, rules)
+VALUES (
+    'default',
+    'Regras gerais de composição',
+    'Boas práticas de restauração ecológica (sem norma estadual configurada)',
+    '{"min_species": 10, "min_native_proportion": 1.0, "max_invasive_species": 0, "max_single_species_share": 0.2}'
+)
+ON CONFLICT (code) DO NOTHING;
+
+DROP TRIGGER IF EXISTS trigger_compliance_rule_sets_updated_at ON compliance_rule_sets;
+CREATE TRIGGER trigger_compliance_rule_sets_updated_at
+    BEFORE UPDATE ON compliance_rule_sets
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at();
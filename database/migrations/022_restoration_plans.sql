@@ -0,0 +1,48 @@
+-- Migration 022: Restoration plans
+-- A user's planting plan: site, area and the chosen species with seedling
+-- quantities and spacing. Created from a recommendation or by hand through
+-- /api/plans and exported to the agency spreadsheet layouts (PRA/SICAR).
+
+CREATE TABLE IF NOT EXISTS restoration_plans (
+    id SERIAL PRIMARY KEY,
+    owner_key_id INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+
+    -- Site
+    tdwg_code VARCHAR(10) NOT NULL,     -- Resolved at creation; nativeness is relative to it
+    state_code VARCHAR(10),             -- BR-SP, BR-MG, ... (compliance rule set)
+    municipality VARCHAR(255),
+    car_code VARCHAR(100),              -- SICAR property registration (recibo do CAR)
+    area_ha DECIMAL(12,4),
+
+    -- Default planting layout, overridable per species
+    spacing_row_m DECIMAL(5,2),
+    spacing_plant_m DECIMAL(5,2),
+    method VARCHAR(50),                 -- total_planting, enrichment, nucleation, direct_seeding
+
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (area_ha IS NULL OR area_ha > 0),
+    CHECK (method IS NULL OR method IN ('total_planting', 'enrichment', 'nucleation', 'direct_seeding'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_restoration_plans_owner ON restoration_plans(owner_key_id);
+
+CREATE TABLE IF NOT EXISTS restoration_plan_species (
+    plan_id INTEGER NOT NULL REFERENCES restoration_plans(id) ON DELETE CASCADE,
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    quantity INTEGER CHECK (quantity IS NULL OR quantity >= 0),  -- Seedlings
+    spacing_row_m DECIMAL(5,2),
+    spacing_plant_m DECIMAL(5,2),
+    position INTEGER NOT NULL DEFAULT 0,                          -- Display order
+    PRIMARY KEY (plan_id, species_id)
+);
+
+COMMENT ON TABLE restoration_plans IS 'User restoration planting plans, exportable to PRA/SICAR spreadsheets';
+
+DROP TRIGGER IF EXISTS trigger_restoration_plans_updated_at ON restoration_plans;
+CREATE TRIGGER trigger_restoration_plans_updated_at
+    BEFORE UPDATE ON restoration_plans
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at();
@@ -0,0 +1,148 @@
+-- Migration 023: Localized names for regions, biomes and climate zones
+-- Display names for the internal (English) labels, looked up by the
+-- query-explorer from Accept-Language / ?lang= (see i18n.go). Keys:
+--   tdwg_region      tdwg_level3.level3_code (BZL, BZS, ...)
+--   biome            ecoregions.biome_num (RESOLVE 2017 biomes, 1-14)
+--   ecoregion        ecoregions.eco_id
+--   whittaker_biome  tdwg_climate.whittaker_biome label
+--   koppen_zone      tdwg_climate.koppen_zone code (Af, Cfa, ...)
+-- Missing translations fall back to the stored label.
+
+CREATE TABLE IF NOT EXISTS localized_names (
+    kind VARCHAR(20) NOT NULL,
+    code VARCHAR(100) NOT NULL,
+    language VARCHAR(5) NOT NULL,   -- ISO 639-1 (pt, en, es)
+    name VARCHAR(255) NOT NULL,
+    PRIMARY KEY (kind, code, language),
+    CHECK (kind IN ('tdwg_region', 'biome', 'ecoregion', 'whittaker_biome', 'koppen_zone'))
+);
+
+COMMENT ON TABLE localized_names IS 'Translated display names for TDWG regions, biomes, ecoregions and Köppen zones';
+
+-- localized_name returns the translation or the fallback label
+CREATE OR REPLACE FUNCTION localized_name(p_kind TEXT, p_code TEXT, p_language TEXT, p_fallback TEXT)
+RETURNS TEXT AS $$
+    SELECT COALESCE(
+        (SELECT name FROM localized_names WHERE kind = p_kind AND code = p_code AND language = p_language),
+        p_fallback
+    );
+$$ LANGUAGE SQL STABLE;
+
+-- =============================================
+-- TDWG Level 3: Brazil and neighbours
+-- =============================================
+
+INSERT INTO localized_names (kind, code, language, name) VALUES
+    ('tdwg_region', 'BZC', 'pt', 'Brasil Centro-Oeste'),
+    ('tdwg_region', 'BZE', 'pt', 'Brasil Nordeste'),
+    ('tdwg_region', 'BZL', 'pt', 'Brasil Sudeste'),
+    ('tdwg_region', 'BZN', 'pt', 'Brasil Norte'),
+    ('tdwg_region', 'BZS', 'pt', 'Brasil Sul'),
+    ('tdwg_region', 'BZC', 'es', 'Brasil Centro-Oeste'),
+    ('tdwg_region', 'BZE', 'es', 'Brasil Nordeste'),
+    ('tdwg_region', 'BZL', 'es', 'Brasil Sudeste'),
+    ('tdwg_region', 'BZN', 'es', 'Brasil Norte'),
+    ('tdwg_region', 'BZS', 'es', 'Brasil Sur'),
+    ('tdwg_region', 'AGE', 'pt', 'Argentina Nordeste'),
+    ('tdwg_region', 'AGS', 'pt', 'Argentina Sul'),
+    ('tdwg_region', 'AGW', 'pt', 'Argentina Noroeste'),
+    ('tdwg_region', 'BOL', 'pt', 'Bolívia'),
+    ('tdwg_region', 'CLM', 'pt', 'Colômbia'),
+    ('tdwg_region', 'FRG', 'pt', 'Guiana Francesa'),
+    ('tdwg_region', 'GUY', 'pt', 'Guiana'),
+    ('tdwg_region', 'PAR', 'pt', 'Paraguai'),
+    ('tdwg_region', 'PER', 'pt', 'Peru'),
+    ('tdwg_region', 'SUR', 'pt', 'Suriname'),
+    ('tdwg_region', 'URU', 'pt', 'Uruguai'),
+    ('tdwg_region', 'VEN', 'pt', 'Venezuela')
+ON CONFLICT (kind, code, language) DO NOTHING;
+
+-- =============================================
+-- RESOLVE biomes
+-- =============================================
+
+INSERT INTO localized_names (kind, code, language, name) VALUES
+    ('biome', '1',  'pt', 'Florestas tropicais e subtropicais úmidas de folhas largas'),
+    ('biome', '2',  'pt', 'Florestas tropicais e subtropicais secas de folhas largas'),
+    ('biome', '3',  'pt', 'Florestas tropicais e subtropicais de coníferas'),
+    ('biome', '4',  'pt', 'Florestas temperadas de folhas largas e mistas'),
+    ('biome', '5',  'pt', 'Florestas temperadas de coníferas'),
+    ('biome', '6',  'pt', 'Florestas boreais / taiga'),
+    ('biome', '7',  'pt', 'Savanas e campos tropicais e subtropicais'),
+    ('biome', '8',  'pt', 'Savanas e campos temperados'),
+    ('biome', '9',  'pt', 'Campos e savanas inundáveis'),
+    ('biome', '10', 'pt', 'Campos e arbustais de montanha'),
+    ('biome', '11', 'pt', 'Tundra'),
+    ('biome', '12', 'pt', 'Florestas, bosques e arbustais mediterrâneos'),
+    ('biome', '13', 'pt', 'Desertos e arbustais xéricos'),
+    ('biome', '14', 'pt', 'Manguezais'),
+    ('biome', '1',  'es', 'Bosques húmedos tropicales y subtropicales de hoja ancha'),
+    ('biome', '2',  'es', 'Bosques secos tropicales y subtropicales de hoja ancha'),
+    ('biome', '3',  'es', 'Bosques tropicales y subtropicales de coníferas'),
+    ('biome', '4',  'es', 'Bosques templados de hoja ancha y mixtos'),
+    ('biome', '5',  'es', 'Bosques templados de coníferas'),
+    ('biome', '6',  'es', 'Bosques boreales / taiga'),
+    ('biome', '7',  'es', 'Pastizales, sabanas y matorrales tropicales y subtropicales'),
+    ('biome', '8',  'es', 'Pastizales, sabanas y matorrales templados'),
+    ('biome', '9',  'es', 'Pastizales y sabanas inundables'),
+    ('biome', '10', 'es', 'Pastizales y matorrales de montaña'),
+    ('biome', '11', 'es', 'Tundra'),
+    ('biome', '12', 'es', 'Bosques, matorrales y arbustales mediterráneos'),
+    ('biome', '13', 'es', 'Desiertos y matorrales xéricos'),
+    ('biome', '14', 'es', 'Manglares')
+ON CONFLICT (kind, code, language) DO NOTHING;
+
+-- =============================================
+-- Whittaker biomes (get_climate_at_point labels)
+-- =============================================
+
+INSERT INTO localized_names (kind, code, language, name) VALUES
+    ('whittaker_biome', 'Tropical Rainforest',      'pt', 'Floresta tropical úmida'),
+    ('whittaker_biome', 'Tropical Seasonal Forest', 'pt', 'Floresta tropical estacional'),
+    ('whittaker_biome', 'Tropical Savanna',         'pt', 'Savana tropical'),
+    ('whittaker_biome', 'Hot Desert',               'pt', 'Deserto quente'),
+    ('whittaker_biome', 'Temperate Rainforest',     'pt', 'Floresta temperada úmida'),
+    ('whittaker_biome', 'Temperate Forest',         'pt', 'Floresta temperada'),
+    ('whittaker_biome', 'Temperate Grassland',      'pt', 'Campo temperado'),
+    ('whittaker_biome', 'Cold Desert',              'pt', 'Deserto frio'),
+    ('whittaker_biome', 'Boreal Forest',            'pt', 'Floresta boreal'),
+    ('whittaker_biome', 'Tundra',                   'pt', 'Tundra'),
+    ('whittaker_biome', 'Unknown',                  'pt', 'Desconhecido')
+ON CONFLICT (kind, code, language) DO NOTHING;
+
+-- =============================================
+-- Köppen zones
+-- =============================================
+
+INSERT INTO localized_names (kind, code, language, name) VALUES
+    ('koppen_zone', 'Af',  'en', 'Tropical rainforest'),
+    ('koppen_zone', 'Am',  'en', 'Tropical monsoon'),
+    ('koppen_zone', 'Aw',  'en', 'Tropical savanna'),
+    ('koppen_zone', 'BWh', 'en', 'Hot desert'),
+    ('koppen_zone', 'BWk', 'en', 'Cold desert'),
+    ('koppen_zone', 'BSh', 'en', 'Hot semi-arid'),
+    ('koppen_zone', 'BSk', 'en', 'Cold semi-arid'),
+    ('koppen_zone', 'Cfa', 'en', 'Humid subtropical'),
+    ('koppen_zone', 'Cfb', 'en', 'Temperate oceanic'),
+    ('koppen_zone', 'Cwa', 'en', 'Monsoon-influenced humid subtropical'),
+    ('koppen_zone', 'Cwb', 'en', 'Subtropical highland'),
+    ('koppen_zone', 'Dfb', 'en', 'Warm-summer humid continental'),
+    ('koppen_zone', 'Dfd', 'en', 'Extremely cold subarctic'),
+    ('koppen_zone', 'ET',  'en', 'Tundra'),
+    ('koppen_zone', 'EF',  'en', 'Ice cap'),
+    ('koppen_zone', 'Af',  'pt', 'Equatorial úmido'),
+    ('koppen_zone', 'Am',  'pt', 'Tropical de monção'),
+    ('koppen_zone', 'Aw',  'pt', 'Tropical com inverno seco'),
+    ('koppen_zone', 'BWh', 'pt', 'Árido quente'),
+    ('koppen_zone', 'BWk', 'pt', 'Árido frio'),
+    ('koppen_zone', 'BSh', 'pt', 'Semiárido quente'),
+    ('koppen_zone', 'BSk', 'pt', 'Semiárido frio'),
+    ('koppen_zone', 'Cfa', 'pt', 'Subtropical úmido com verão quente'),
+    ('koppen_zone', 'Cfb', 'pt', 'Subtropical úmido com verão temperado'),
+    ('koppen_zone', 'Cwa', 'pt', 'Subtropical com inverno seco e verão quente'),
+    ('koppen_zone', 'Cwb', 'pt', 'Subtropical de altitude com inverno seco'),
+    ('koppen_zone', 'Dfb', 'pt', 'Continental úmido com verão temperado'),
+    ('koppen_zone', 'Dfd', 'pt', 'Subártico com inverno extremo'),
+    ('koppen_zone', 'ET',  'pt', 'Tundra'),
+    ('koppen_zone', 'EF',  'pt', 'Glacial')
+ON CONFLICT (kind, code, language) DO NOTHING;
@@ -0,0 +1,29 @@
+-- Migration 024: Saved queries
+-- Named SQL kept in the query-explorer (/api/queries) so recurring
+-- diagnostic queries need not be pasted in again. Shared queries are visible
+-- to every key of the owner's tenant (or to everyone for keys without one).
+
+CREATE TABLE IF NOT EXISTS saved_queries (
+    id SERIAL PRIMARY KEY,
+    owner_key_id INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    tenant_id INTEGER REFERENCES tenants(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    sql_text TEXT NOT NULL,
+    shared BOOLEAN NOT NULL DEFAULT FALSE,
+    last_run_at TIMESTAMP,
+    run_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_queries_owner ON saved_queries(owner_key_id);
+CREATE INDEX IF NOT EXISTS idx_saved_queries_shared ON saved_queries(tenant_id) WHERE shared;
+
+COMMENT ON TABLE saved_queries IS 'Named read-only SQL queries saved in the query-explorer';
+
+DROP TRIGGER IF EXISTS trigger_saved_queries_updated_at ON saved_queries;
+CREATE TRIGGER trigger_saved_queries_updated_at
+    BEFORE UPDATE ON saved_queries
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at();
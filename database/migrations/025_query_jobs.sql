@@ -0,0 +1,23 @@
+-- Migration 025: Asynchronous query jobs
+-- /api/query/async stores the query here and returns the job ID; in-process
+-- workers of the query-explorer run it and keep the result for polling via
+-- /api/query/jobs/{id}. Finished jobs expire after a day.
+
+CREATE TABLE IF NOT EXISTS query_jobs (
+    id VARCHAR(32) PRIMARY KEY,          -- Random hex token; knowing it grants access
+    sql_text TEXT NOT NULL,
+    row_limit INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    result JSONB,                        -- {"columns": [...], "rows": [[...]], "row_count": n}
+    error TEXT,
+    created_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    CHECK (status IN ('queued', 'running', 'succeeded', 'failed', 'cancelled'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_query_jobs_queued ON query_jobs(created_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_query_jobs_finished ON query_jobs(finished_at) WHERE finished_at IS NOT NULL;
+
+COMMENT ON TABLE query_jobs IS 'Asynchronous /api/query jobs and their results';
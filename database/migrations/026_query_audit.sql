@@ -0,0 +1,27 @@
+-- Migration 026: Query audit log
+-- One row per statement run through the query-explorer (/api/query, saved
+-- queries and async jobs), including rejected ones, for debugging slow
+-- queries and for compliance. Read via /api/query/history.
+
+CREATE TABLE IF NOT EXISTS query_audit (
+    id BIGSERIAL PRIMARY KEY,
+    executed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    api_key_id INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,  -- NULL: anonymous
+    remote_addr VARCHAR(64),
+    source VARCHAR(20) NOT NULL,         -- 'adhoc', 'saved', 'async'
+    saved_query_id INTEGER REFERENCES saved_queries(id) ON DELETE SET NULL,
+    job_id VARCHAR(32),
+    sql_text TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    duration_ms DOUBLE PRECISION NOT NULL,
+    row_count INTEGER,
+    error TEXT,
+    CHECK (source IN ('adhoc', 'saved', 'async')),
+    CHECK (status IN ('ok', 'error', 'timeout', 'rejected'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_query_audit_executed ON query_audit(executed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_query_audit_key ON query_audit(api_key_id, executed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_query_audit_slow ON query_audit(duration_ms DESC);
+
+COMMENT ON TABLE query_audit IS 'Statements executed through the query-explorer';
@@ -0,0 +1,25 @@
+-- Migration 027: Pre-simplified geometries
+-- Region polygons simplified once per zoom band by the query-explorer's
+-- geometry-cache job, so map endpoints do not run ST_Simplify on every
+-- request. source_md5 is the hash of the source geometry the row was built
+-- from; the job rebuilds rows whose source has changed.
+--
+-- Levels: 0 = zoom 0-4, 1 = zoom 5-8, 2 = zoom 9+ (tolerances in degrees,
+-- see geometry_cache.go)
+
+CREATE TABLE IF NOT EXISTS simplified_geometries (
+    layer VARCHAR(20) NOT NULL,          -- 'tdwg_level3', 'ecoregion'
+    code VARCHAR(20) NOT NULL,           -- level3_code, eco_id
+    level SMALLINT NOT NULL,
+    tolerance DOUBLE PRECISION NOT NULL,
+    geom GEOMETRY(MultiPolygon, 4326) NOT NULL,
+    n_points INTEGER NOT NULL,
+    source_md5 CHAR(32) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (layer, code, level),
+    CHECK (layer IN ('tdwg_level3', 'ecoregion'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_simplified_geometries_geom ON simplified_geometries USING GIST(geom);
+
+COMMENT ON TABLE simplified_geometries IS 'Region geometries pre-simplified per zoom band for map rendering';
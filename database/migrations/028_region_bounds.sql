@@ -0,0 +1,59 @@
+-- Migration 028: Stored bounding boxes and centroids of regions
+-- Lets the map zoom to a TDWG region or ecoregion (/api/tdwg/{code}/bounds,
+-- /api/ecoregion/{eco_id}/bounds) without downloading its geometry. Kept in
+-- sync with geom by triggers.
+--
+-- Regions crossing the antimeridian (Fiji, Chukotka, the Aleutians) get the
+-- narrower box of the longitude-shifted geometry, so max longitude may
+-- exceed 180.
+
+CREATE OR REPLACE FUNCTION region_bbox(g GEOMETRY)
+RETURNS GEOMETRY AS $$
+    SELECT CASE
+        WHEN g IS NULL THEN NULL
+        WHEN ST_XMax(ST_Envelope(ST_ShiftLongitude(g))) - ST_XMin(ST_Envelope(ST_ShiftLongitude(g)))
+             < ST_XMax(ST_Envelope(g)) - ST_XMin(ST_Envelope(g))
+        THEN ST_Envelope(ST_ShiftLongitude(g))
+        ELSE ST_Envelope(g)
+    END
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION update_region_bounds()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.bbox = region_bbox(NEW.geom);
+    NEW.centroid = ST_Centroid(NEW.geom);
+    NEW.label_point = ST_PointOnSurface(NEW.geom);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE tdwg_level3 ADD COLUMN IF NOT EXISTS bbox GEOMETRY(Geometry, 4326);
+ALTER TABLE tdwg_level3 ADD COLUMN IF NOT EXISTS centroid GEOMETRY(Point, 4326);
+ALTER TABLE tdwg_level3 ADD COLUMN IF NOT EXISTS label_point GEOMETRY(Point, 4326);
+ALTER TABLE ecoregions ADD COLUMN IF NOT EXISTS bbox GEOMETRY(Geometry, 4326);
+ALTER TABLE ecoregions ADD COLUMN IF NOT EXISTS centroid GEOMETRY(Point, 4326);
+ALTER TABLE ecoregions ADD COLUMN IF NOT EXISTS label_point GEOMETRY(Point, 4326);
+
+COMMENT ON COLUMN tdwg_level3.centroid IS 'Geometric centroid; may fall outside concave or multi-part regions';
+COMMENT ON COLUMN tdwg_level3.label_point IS 'Point guaranteed inside the region, for labels and markers';
+
+UPDATE tdwg_level3
+SET bbox = region_bbox(geom), centroid = ST_Centroid(geom), label_point = ST_PointOnSurface(geom)
+WHERE geom IS NOT NULL;
+
+UPDATE ecoregions
+SET bbox = region_bbox(geom), centroid = ST_Centroid(geom), label_point = ST_PointOnSurface(geom)
+WHERE geom IS NOT NULL;
+
+DROP TRIGGER IF EXISTS trigger_tdwg_level3_bounds ON tdwg_level3;
+CREATE TRIGGER trigger_tdwg_level3_bounds
+    BEFORE INSERT OR UPDATE OF geom ON tdwg_level3
+    FOR EACH ROW
+    EXECUTE FUNCTION update_region_bounds();
+
+DROP TRIGGER IF EXISTS trigger_ecoregions_bounds ON ecoregions;
+CREATE TRIGGER trigger_ecoregions_bounds
+    BEFORE INSERT OR UPDATE OF geom ON ecoregions
+    FOR EACH ROW
+    EXECUTE FUNCTION update_region_bounds();
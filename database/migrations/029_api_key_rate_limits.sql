@@ -0,0 +1,11 @@
+-- Migration 029: Per-key rate limit quotas
+-- The query-explorer rate-limits API requests per client (ratelimit.go).
+-- Requests with a valid key get the configured limits multiplied by
+-- RATE_LIMIT_KEY_FACTOR, or by this column when set (0 = unlimited).
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rate_limit_factor REAL;
+
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_rate_limit_factor_check;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_rate_limit_factor_check CHECK (rate_limit_factor >= 0);
+
+COMMENT ON COLUMN api_keys.rate_limit_factor IS 'Multiplier of the rate limits for this key; NULL = RATE_LIMIT_KEY_FACTOR, 0 = unlimited';
@@ -0,0 +1,8 @@
+-- Migration 030: Saved query parameters
+-- Saved queries may use named parameters (:tdwg_code, :limit) that are bound
+-- as placeholders when run (POST /api/queries/{id}/run). params lists them
+-- in order of first use: [{"name", "type", "default", "description"}].
+
+ALTER TABLE saved_queries ADD COLUMN IF NOT EXISTS params JSONB NOT NULL DEFAULT '[]';
+
+COMMENT ON COLUMN saved_queries.params IS 'Named parameters of sql_text, in order of first use';
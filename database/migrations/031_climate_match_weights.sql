@@ -0,0 +1,84 @@
+-- Migration 031: Weighted climate match
+-- calculate_climate_match (migration 009) with caller-chosen variable
+-- weights, for recommendation requests with climate_variables (e.g. dropping
+-- bio15 for irrigated sites). p_weights holds the weights of bio1, bio5,
+-- bio6, bio12, bio15 and frost, in that order; a variable with weight 0 is
+-- left out, including the bio5/bio6 hard limits. The score is normalized by
+-- the total weight, so the default weights
+-- {0.25, 0.125, 0.125, 0.20, 0.15, 0.15} reproduce calculate_climate_match.
+
+CREATE OR REPLACE FUNCTION calculate_climate_match_weighted(
+    p_species_id INTEGER,
+    p_bio1 DECIMAL,
+    p_bio5 DECIMAL,
+    p_bio6 DECIMAL,
+    p_bio12 DECIMAL,
+    p_bio15 DECIMAL,
+    p_weights DECIMAL[]
+) RETURNS DECIMAL AS $$
+DECLARE
+    v_envelope RECORD;
+    v_score DECIMAL := 0;
+    v_total DECIMAL;
+BEGIN
+    v_total := p_weights[1] + p_weights[2] + p_weights[3] + p_weights[4] + p_weights[5] + p_weights[6];
+    IF v_total IS NULL OR v_total <= 0 THEN
+        RETURN 0;
+    END IF;
+
+    SELECT * INTO v_envelope
+    FROM species_climate_envelope
+    WHERE species_id = p_species_id;
+
+    IF NOT FOUND THEN
+        RETURN 0;
+    END IF;
+
+    -- 1. Temperature mean match, ±10°C tolerance
+    IF p_weights[1] > 0 THEN
+        v_score := v_score + GREATEST(0, 1 - ABS(p_bio1 - v_envelope.temp_mean) / 10.0) * p_weights[1];
+    END IF;
+
+    -- 2. Temperature extremes: hard limits (±3°C margin)
+    IF p_weights[2] > 0 THEN
+        IF p_bio5 > v_envelope.temp_max + 3 THEN
+            RETURN 0;
+        END IF;
+        v_score := v_score + p_weights[2];
+    END IF;
+    IF p_weights[3] > 0 THEN
+        IF p_bio6 < v_envelope.temp_min - 3 THEN
+            RETURN 0;
+        END IF;
+        v_score := v_score + p_weights[3];
+    END IF;
+
+    -- 3. Precipitation match, half credit without data
+    IF p_weights[4] > 0 THEN
+        IF v_envelope.precip_mean > 0 THEN
+            v_score := v_score + GREATEST(0, 1 - ABS(p_bio12 - v_envelope.precip_mean) / v_envelope.precip_mean) * p_weights[4];
+        ELSE
+            v_score := v_score + 0.5 * p_weights[4];
+        END IF;
+    END IF;
+
+    -- 4. Precipitation seasonality, ±50 tolerance
+    IF p_weights[5] > 0 THEN
+        v_score := v_score + GREATEST(0, 1 - ABS(p_bio15 - v_envelope.precip_seasonality) / 50.0) * p_weights[5];
+    END IF;
+
+    -- 5. Cold hardiness where frost occurs, a third of the credit if risky
+    IF p_weights[6] > 0 THEN
+        IF p_bio6 < 0 AND (v_envelope.cold_month_min < p_bio6 - 2) IS NOT TRUE THEN
+            v_score := v_score + p_weights[6] / 3.0;
+        ELSE
+            v_score := v_score + p_weights[6];
+        END IF;
+    END IF;
+
+    RETURN ROUND((v_score / v_total)::numeric, 3);
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+COMMENT ON FUNCTION calculate_climate_match_weighted(INTEGER, DECIMAL, DECIMAL, DECIMAL, DECIMAL, DECIMAL, DECIMAL[]) IS
+    'calculate_climate_match with weights for bio1, bio5, bio6, bio12, bio15 and frost; weight 0 drops a variable';
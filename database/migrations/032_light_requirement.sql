@@ -0,0 +1,21 @@
+-- Migration 032: Light requirement
+-- Light demand per species, used with lifespan and growth form to place
+-- species in succession stages (the recommender's succession_stages option):
+--   light_demanding  establishes in full sun (pioneers, early secondaries)
+--   intermediate     tolerates partial shade when young
+--   shade_tolerant   establishes under canopy (late secondaries, climax)
+-- Filled by imports and accepted trait suggestions.
+
+ALTER TABLE species_unified
+ADD COLUMN IF NOT EXISTS light_requirement VARCHAR(20),
+ADD COLUMN IF NOT EXISTS light_requirement_source VARCHAR(50);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'species_unified_light_requirement_check') THEN
+        ALTER TABLE species_unified ADD CONSTRAINT species_unified_light_requirement_check
+            CHECK (light_requirement IN ('light_demanding', 'intermediate', 'shade_tolerant'));
+    END IF;
+END$$;
+
+COMMENT ON COLUMN species_unified.light_requirement IS 'Light requirement: light_demanding, intermediate, shade_tolerant';
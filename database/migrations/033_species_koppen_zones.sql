@@ -0,0 +1,36 @@
+-- Migration 033: Species Köppen zones
+-- The Köppen zones of the TDWG regions where each species is native, for the
+-- recommender's koppen_match preference (site zone within the species'
+-- zones). Derived from species_regions and tdwg_climate; run
+-- SELECT refresh_species_koppen_zones() after importing distributions.
+
+CREATE TABLE IF NOT EXISTS species_koppen_zones (
+    species_id INTEGER PRIMARY KEY REFERENCES species(id) ON DELETE CASCADE,
+    koppen_zones TEXT[] NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_species_koppen_zones_zones ON species_koppen_zones USING GIN(koppen_zones);
+
+COMMENT ON TABLE species_koppen_zones IS 'Köppen zones of the regions where each species is native (sorted, distinct)';
+
+CREATE OR REPLACE FUNCTION refresh_species_koppen_zones() RETURNS INTEGER AS $$
+DECLARE
+    v_count INTEGER;
+BEGIN
+    DELETE FROM species_koppen_zones;
+
+    INSERT INTO species_koppen_zones (species_id, koppen_zones)
+    SELECT sr.species_id, array_agg(DISTINCT c.koppen_zone ORDER BY c.koppen_zone)
+    FROM species_regions sr
+    JOIN tdwg_climate c ON sr.tdwg_code = c.tdwg_code
+    WHERE sr.is_native = TRUE
+      AND c.koppen_zone IS NOT NULL
+    GROUP BY sr.species_id;
+
+    GET DIAGNOSTICS v_count = ROW_COUNT;
+    RETURN v_count;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT refresh_species_koppen_zones();
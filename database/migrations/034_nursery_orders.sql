@@ -0,0 +1,19 @@
+-- Migration 034: Nursery partner orders
+-- Catalog codes of the species a partner nursery sells, and the order
+-- reference the nursery returned when a plan was sent to it
+-- (POST /api/plans/{id}/order).
+
+CREATE TABLE IF NOT EXISTS nursery_catalog (
+    partner VARCHAR(50) NOT NULL,        -- NURSERY_PARTNER
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    catalog_code VARCHAR(100) NOT NULL,  -- The nursery's product code
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (partner, species_id)
+);
+
+COMMENT ON TABLE nursery_catalog IS 'Species ID to partner nursery catalog code mapping, for plan orders';
+
+ALTER TABLE restoration_plans
+    ADD COLUMN IF NOT EXISTS nursery_partner VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS nursery_order_ref VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS nursery_ordered_at TIMESTAMP;
@@ -0,0 +1,9 @@
+-- Migration 035: Full responses in the recommendation cache
+-- recommendation_cache kept only the species IDs and metrics, not enough to
+-- answer a request, so every request was recomputed. The serialized
+-- RecommendResponse is now stored too and served on a hit; older entries
+-- without it are recomputed and overwritten.
+
+ALTER TABLE recommendation_cache ADD COLUMN IF NOT EXISTS response JSONB;
+
+COMMENT ON COLUMN recommendation_cache.response IS 'Serialized RecommendResponse served on cache hits';
@@ -0,0 +1,69 @@
+-- Migration 036: Flora e Funga do Brasil attributes
+-- Per-species attributes from the Flora e Funga do Brasil checklist
+-- (REFLORA crawler): endemism to Brazil, phytogeographic domains (Mata
+-- Atlântica, Cerrado, ...) and vegetation types (Floresta Ombrófila Densa,
+-- Cerrado (lato sensu), ...), filterable in /api/recommend. The crawler also
+-- calls refresh_brazil_flora_regions() after a run, so the checklist's
+-- nativeness and endemism reach species_regions for the Brazilian TDWG units.
+
+CREATE TABLE IF NOT EXISTS species_brazil_flora (
+    species_id INTEGER PRIMARY KEY REFERENCES species(id) ON DELETE CASCADE,
+    reflora_id VARCHAR(50),
+    is_endemic BOOLEAN NOT NULL DEFAULT FALSE,  -- Endemic to Brazil
+    phytogeographic_domains TEXT[] NOT NULL DEFAULT '{}',
+    vegetation_types TEXT[] NOT NULL DEFAULT '{}',
+    synced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_brazil_flora_domains ON species_brazil_flora USING GIN (phytogeographic_domains);
+CREATE INDEX IF NOT EXISTS idx_brazil_flora_vegetation ON species_brazil_flora USING GIN (vegetation_types);
+
+COMMENT ON TABLE species_brazil_flora IS 'Flora e Funga do Brasil endemism, phytogeographic domains and vegetation types per species';
+
+-- ============================================================================
+-- FUNCTION: refresh_brazil_flora_regions
+-- Writes the checklist's status per Brazilian TDWG unit to species_regions:
+-- native if native in any state of the unit, else naturalized, else
+-- cultivated. is_endemic (endemic to that unit alone) is set for species
+-- endemic to Brazil that occur in a single unit. Returns the rows written.
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION refresh_brazil_flora_regions()
+RETURNS INTEGER AS $$
+DECLARE
+    v_count INTEGER;
+BEGIN
+    WITH by_unit AS (
+        SELECT sdb.species_id, m.tdwg_code,
+               CASE
+                   WHEN bool_or(sdb.establishment = 'NATIVA') THEN 'native'
+                   WHEN bool_or(sdb.establishment = 'NATURALIZADA') THEN 'naturalized'
+                   WHEN bool_or(sdb.establishment = 'CULTIVADA') THEN 'cultivated'
+               END::establishment_means AS means
+        FROM species_distribution_brazil sdb
+        JOIN brazil_state_tdwg_map m ON sdb.state_code = m.state_code
+        GROUP BY sdb.species_id, m.tdwg_code
+    ),
+    units AS (
+        SELECT species_id, COUNT(*) AS n_units FROM by_unit GROUP BY species_id
+    )
+    INSERT INTO species_regions (species_id, tdwg_code, establishment_means, is_endemic, source)
+    SELECT b.species_id, b.tdwg_code, b.means,
+           COALESCE(bf.is_endemic, FALSE) AND u.n_units = 1 AND b.means = 'native',
+           'reflora'
+    FROM by_unit b
+    JOIN units u ON u.species_id = b.species_id
+    LEFT JOIN species_brazil_flora bf ON bf.species_id = b.species_id
+    WHERE b.means IS NOT NULL
+    ON CONFLICT (species_id, tdwg_code) DO UPDATE
+    SET establishment_means = EXCLUDED.establishment_means,
+        is_endemic = EXCLUDED.is_endemic
+    WHERE species_regions.establishment_means IS DISTINCT FROM EXCLUDED.establishment_means
+       OR species_regions.is_endemic IS DISTINCT FROM EXCLUDED.is_endemic;
+
+    GET DIAGNOSTICS v_count = ROW_COUNT;
+    RETURN v_count;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON FUNCTION refresh_brazil_flora_regions() IS 'Apply Flora e Funga do Brasil nativeness and endemism to species_regions for Brazilian TDWG units';
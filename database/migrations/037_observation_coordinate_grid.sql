@@ -0,0 +1,10 @@
+-- Migration 037: Generalized observation coordinates
+-- Observations of threatened species (and those submitted with
+-- generalize_coordinates) are stored at the center of a grid cell; the grid
+-- size in degrees is recorded here. NULL: exact coordinates. Existing rows
+-- are left as they are.
+
+ALTER TABLE observations
+    ADD COLUMN IF NOT EXISTS coordinate_grid_deg NUMERIC(6,4);
+
+COMMENT ON COLUMN observations.coordinate_grid_deg IS 'Grid (degrees) the stored coordinates were generalized to; NULL if exact';
@@ -0,0 +1,56 @@
+-- Migration 038: Climate re-scoring runs
+-- species_region_climate_match keeps the default climate match of every
+-- species recorded in a TDWG region, recomputed by the admin re-scoring job
+-- (POST /api/admin/rescore) after climate layers or envelopes change. Each
+-- run records its progress and how far scores moved, overall and per
+-- region, so a data update cannot silently change recommendations.
+
+CREATE TABLE IF NOT EXISTS species_region_climate_match (
+    tdwg_code VARCHAR(10) NOT NULL,
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    score NUMERIC(5,4) NOT NULL,          -- calculate_climate_match, default weights
+    computed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tdwg_code, species_id)
+);
+
+COMMENT ON TABLE species_region_climate_match IS 'Climate match score of each species in each of its TDWG regions, kept by the re-scoring job';
+
+CREATE TABLE IF NOT EXISTS climate_rescore_runs (
+    id SERIAL PRIMARY KEY,
+    started_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    reason TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    regions JSONB,                        -- Requested regions; NULL for all
+    regions_total INTEGER NOT NULL DEFAULT 0,
+    regions_done INTEGER NOT NULL DEFAULT 0,
+    cache_invalidated INTEGER NOT NULL DEFAULT 0,
+
+    -- Drift over the regions done
+    n_scores BIGINT NOT NULL DEFAULT 0,
+    n_new BIGINT NOT NULL DEFAULT 0,      -- No previous score
+    n_changed BIGINT NOT NULL DEFAULT 0,
+    n_removed BIGINT NOT NULL DEFAULT 0,  -- Species no longer in the region
+    n_crossed_threshold BIGINT NOT NULL DEFAULT 0, -- Moved across the default climate_threshold
+    sum_abs_drift DOUBLE PRECISION NOT NULL DEFAULT 0,
+    max_abs_drift DOUBLE PRECISION NOT NULL DEFAULT 0,
+
+    error TEXT,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    CHECK (status IN ('running', 'completed', 'failed', 'cancelled'))
+);
+
+CREATE TABLE IF NOT EXISTS climate_rescore_region_drift (
+    run_id INTEGER NOT NULL REFERENCES climate_rescore_runs(id) ON DELETE CASCADE,
+    tdwg_code VARCHAR(10) NOT NULL,
+    n_scores INTEGER NOT NULL,
+    n_new INTEGER NOT NULL,
+    n_changed INTEGER NOT NULL,
+    n_removed INTEGER NOT NULL,
+    n_crossed_threshold INTEGER NOT NULL,
+    mean_abs_drift DOUBLE PRECISION NOT NULL, -- Over species with a previous score
+    max_abs_drift DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (run_id, tdwg_code)
+);
+
+COMMENT ON TABLE climate_rescore_runs IS 'Admin re-scoring runs after climate data updates, with progress and score drift';
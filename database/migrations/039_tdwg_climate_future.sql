@@ -0,0 +1,27 @@
+-- Migration 039: Future climate per TDWG region
+-- Projected bioclimatic means per TDWG Level 3 region and scenario, from
+-- the WorldClim CMIP6 downscaled rasters with the same zonal statistics as
+-- tdwg_climate. scenario is '<ssp>_<period midpoint>', e.g. ssp245_2050
+-- (SSP2-4.5, 2041-2060); gcm is the model, or 'ensemble' for the
+-- multi-model mean. Loaded by the worldclim_future crawler; used by
+-- /api/climate/analogs.
+
+CREATE TABLE IF NOT EXISTS tdwg_climate_future (
+    tdwg_code VARCHAR(10) NOT NULL,
+    scenario VARCHAR(20) NOT NULL,
+    gcm VARCHAR(50) NOT NULL DEFAULT 'ensemble',
+
+    bio1_mean DECIMAL(6,2),   -- Annual Mean Temperature
+    bio5_mean DECIMAL(6,2),   -- Max Temp of Warmest Month
+    bio6_mean DECIMAL(6,2),   -- Min Temp of Coldest Month
+    bio12_mean DECIMAL(10,2), -- Annual Precipitation
+    bio15_mean DECIMAL(10,2), -- Precipitation Seasonality (CV)
+
+    resolution VARCHAR(10),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tdwg_code, scenario, gcm)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tdwg_climate_future_scenario ON tdwg_climate_future(scenario);
+
+COMMENT ON TABLE tdwg_climate_future IS 'Projected bioclimatic means per TDWG region, scenario and GCM (WorldClim CMIP6)';
@@ -0,0 +1,19 @@
+-- Migration 040: Source record checksums
+-- One SHA-256 per source record (the crawler's transformed row), written
+-- after the record is saved. A re-run skips records whose checksum has not
+-- changed, so it reports true deltas and, after an interruption, passes
+-- quickly over what was already loaded.
+
+CREATE TABLE IF NOT EXISTS source_record_checksums (
+    source VARCHAR(50) NOT NULL,       -- Crawler name
+    record_key TEXT NOT NULL,          -- Source ID or canonical name
+    checksum CHAR(64) NOT NULL,        -- SHA-256 hex
+    run_id INTEGER REFERENCES crawler_runs(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (source, record_key)
+);
+
+COMMENT ON TABLE source_record_checksums IS 'Checksum of each source record as last ingested, to skip unchanged records on re-runs';
+
+ALTER TABLE crawler_runs
+    ADD COLUMN IF NOT EXISTS records_unchanged INTEGER DEFAULT 0;
@@ -0,0 +1,19 @@
+-- Migration 041: DEM Raster Storage
+-- Digital elevation model tiles for /api/elevation (elevation, slope and
+-- aspect at a point) and for elevation_mode without an explicit elevation_m.
+-- Loaded by scripts/load_dem_raster.py; any DEM in EPSG:4326 with meters as
+-- values works (SRTM, GMTED2010, Copernicus GLO-90).
+
+CREATE EXTENSION IF NOT EXISTS postgis_raster;
+
+CREATE TABLE IF NOT EXISTS dem_raster (
+    rid SERIAL PRIMARY KEY,
+    rast RASTER NOT NULL,
+    source VARCHAR(50) NOT NULL,          -- 'srtm', 'gmted2010', ...
+    resolution VARCHAR(10) NOT NULL,      -- '30s', '7.5s', '3s'
+    filename VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_dem_raster_gist
+    ON dem_raster USING GIST (ST_ConvexHull(rast));
@@ -0,0 +1,40 @@
+-- Migration 042: Soil data
+-- Site soil from SoilGrids (ISRIC, 250 m), 0-30 cm means, for /api/soil and
+-- the recommender's soil_match preference; species soil tolerances from
+-- EcoCrop (absolute pH range and texture classes).
+--
+-- soil_raster is loaded by scripts/load_soil_raster.py, converted to
+-- conventional units: pH (H2O), sand/silt/clay in %, organic carbon in g/kg.
+-- species_soil_tolerance is loaded by scripts/load_soil_tolerance.py.
+
+CREATE EXTENSION IF NOT EXISTS postgis_raster;
+
+CREATE TABLE IF NOT EXISTS soil_raster (
+    rid SERIAL PRIMARY KEY,
+    property VARCHAR(20) NOT NULL CHECK (property IN ('phh2o', 'sand', 'silt', 'clay', 'soc')),
+    depth VARCHAR(10) NOT NULL DEFAULT '0-30cm',
+    rast RASTER NOT NULL,
+    resolution VARCHAR(10) NOT NULL DEFAULT '250m',
+    filename VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_soil_raster_gist
+    ON soil_raster USING GIST (ST_ConvexHull(rast));
+
+CREATE INDEX IF NOT EXISTS idx_soil_raster_property
+    ON soil_raster(property, depth);
+
+-- Texture classes as in EcoCrop: light (sandy), medium (loamy), heavy
+-- (clayey), organic (peat, muck)
+CREATE TABLE IF NOT EXISTS species_soil_tolerance (
+    species_id INTEGER PRIMARY KEY REFERENCES species(id) ON DELETE CASCADE,
+    ph_min NUMERIC(3,1),
+    ph_max NUMERIC(3,1),
+    textures VARCHAR(10)[] CHECK (textures <@ ARRAY['light', 'medium', 'heavy', 'organic']::VARCHAR(10)[]),
+    source VARCHAR(50) NOT NULL DEFAULT 'ecocrop',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ph_min IS NULL OR ph_max IS NULL OR ph_min <= ph_max)
+);
+
+COMMENT ON TABLE species_soil_tolerance IS 'Soil pH range and texture classes a species establishes on (EcoCrop absolute ranges)';
@@ -0,0 +1,89 @@
+-- Migration 043: Source summary
+-- /api/sources reads per-source counts from source_summary instead of
+-- aggregating species_unified on every call. The API refreshes it nightly
+-- (SOURCE_SUMMARY_AT) with refresh_source_summary(); it can also be
+-- run by hand after an import.
+
+CREATE TABLE IF NOT EXISTS source_summary (
+    attribute VARCHAR(20) NOT NULL CHECK (attribute IN ('growth_form', 'threat_status', 'lifespan')),
+    source VARCHAR(50) NOT NULL,
+    total BIGINT NOT NULL,
+
+    -- growth_form
+    trees BIGINT,
+    shrubs BIGINT,
+    herbs BIGINT,
+    climbers BIGINT,
+    palms BIGINT,
+
+    -- threat_status
+    cr BIGINT,
+    en BIGINT,
+    vu BIGINT,
+    nt BIGINT,
+    lc BIGINT,
+
+    -- lifespan
+    avg_lifespan NUMERIC(8,1),
+    min_lifespan NUMERIC(8,1),
+    max_lifespan NUMERIC(8,1),
+
+    refreshed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (attribute, source)
+);
+
+-- Drill-down by source (/api/sources/{name}/species)
+CREATE INDEX IF NOT EXISTS idx_species_unified_growth_form_source ON species_unified(growth_form_source);
+CREATE INDEX IF NOT EXISTS idx_species_unified_threat_status_source ON species_unified(threat_status_source);
+CREATE INDEX IF NOT EXISTS idx_species_unified_lifespan_source ON species_unified(lifespan_source);
+
+-- ============================================================================
+-- FUNCTION: refresh_source_summary
+-- Rebuilds source_summary from species_unified in one transaction, so
+-- readers see either the old or the new counts. Returns the rows written.
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION refresh_source_summary()
+RETURNS INTEGER AS $$
+DECLARE
+    v_count INTEGER;
+BEGIN
+    DELETE FROM source_summary;
+
+    INSERT INTO source_summary (attribute, source, total, trees, shrubs, herbs, climbers, palms)
+    SELECT 'growth_form', growth_form_source, COUNT(*),
+           COUNT(*) FILTER (WHERE is_tree),
+           COUNT(*) FILTER (WHERE is_shrub),
+           COUNT(*) FILTER (WHERE is_herb),
+           COUNT(*) FILTER (WHERE is_climber),
+           COUNT(*) FILTER (WHERE is_palm)
+    FROM species_unified
+    WHERE growth_form_source IS NOT NULL
+    GROUP BY growth_form_source;
+
+    INSERT INTO source_summary (attribute, source, total, cr, en, vu, nt, lc)
+    SELECT 'threat_status', threat_status_source, COUNT(*),
+           COUNT(*) FILTER (WHERE threat_status = 'CR'),
+           COUNT(*) FILTER (WHERE threat_status = 'EN'),
+           COUNT(*) FILTER (WHERE threat_status = 'VU'),
+           COUNT(*) FILTER (WHERE threat_status = 'NT'),
+           COUNT(*) FILTER (WHERE threat_status = 'LC')
+    FROM species_unified
+    WHERE threat_status_source IS NOT NULL
+    GROUP BY threat_status_source;
+
+    INSERT INTO source_summary (attribute, source, total, avg_lifespan, min_lifespan, max_lifespan)
+    SELECT 'lifespan', lifespan_source, COUNT(*),
+           ROUND(AVG(lifespan_years)::numeric, 1),
+           ROUND(MIN(lifespan_years)::numeric, 1),
+           ROUND(MAX(lifespan_years)::numeric, 1)
+    FROM species_unified
+    WHERE lifespan_source IS NOT NULL
+    GROUP BY lifespan_source;
+
+    SELECT COUNT(*) INTO v_count FROM source_summary;
+    RETURN v_count;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT refresh_source_summary();
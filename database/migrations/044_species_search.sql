@@ -0,0 +1,11 @@
+-- Migration 044: Species search
+-- /api/species/search matches canonical and common names by trigram
+-- similarity (pg_trgm) and substring; both use these GIN indexes.
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_species_canonical_trgm
+    ON species USING gin (canonical_name gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS idx_common_names_trgm
+    ON common_names USING gin (common_name gin_trgm_ops);
@@ -0,0 +1,28 @@
+-- Migration 045: Retention and archiving
+-- The query-explorer deletes stored recommendations, finished async jobs and
+-- audit rows older than their retention period (RETENTION_*), first writing
+-- them to the archive (ARCHIVE_URL) as gzipped NDJSON, one object per batch.
+-- archive_batches lists the objects; archived_recommendations maps each
+-- archived recommendation to its object so it can be restored by ID.
+
+CREATE TABLE IF NOT EXISTS archive_batches (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(30) NOT NULL,           -- 'recommendations', 'query_jobs', 'query_audit'
+    object_key TEXT NOT NULL,
+    n_records INTEGER NOT NULL,
+    bytes BIGINT NOT NULL,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_archive_batches_kind ON archive_batches(kind, archived_at DESC);
+
+CREATE TABLE IF NOT EXISTS archived_recommendations (
+    id INTEGER PRIMARY KEY,              -- recommendation_cache.id
+    cache_key VARCHAR(255) NOT NULL,
+    batch_id BIGINT NOT NULL REFERENCES archive_batches(id),
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    restored_at TIMESTAMP
+);
+
+COMMENT ON TABLE archive_batches IS 'Archive objects written before retention deletes';
+COMMENT ON TABLE archived_recommendations IS 'Archived recommendation_cache rows, for restore by ID';
@@ -0,0 +1,25 @@
+-- Migration 046: GBIF backbone
+-- A local copy of the plant names of the GBIF Backbone Taxonomy, loaded by
+-- scripts/load_gbif_backbone.py from the backbone's Taxon.tsv. Used by
+-- /api/names/resolve to map submitted names, synonyms and misspellings
+-- included, to accepted canonical names. Reloaded whole on each sync.
+
+CREATE TABLE IF NOT EXISTS gbif_backbone (
+    taxon_key BIGINT PRIMARY KEY,
+    scientific_name TEXT NOT NULL,           -- With authorship
+    canonical_name TEXT NOT NULL,
+    authorship TEXT,
+    rank VARCHAR(20) NOT NULL,               -- species, subspecies, variety, form
+    taxonomic_status VARCHAR(30) NOT NULL,   -- accepted, synonym, heterotypic synonym, homotypic synonym, proparte synonym, doubtful
+    accepted_key BIGINT,                     -- Accepted taxon of a synonym
+    family VARCHAR(100),
+    genus VARCHAR(100),
+    synced_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_gbif_backbone_canonical ON gbif_backbone(LOWER(canonical_name));
+CREATE INDEX IF NOT EXISTS idx_gbif_backbone_canonical_trgm ON gbif_backbone USING gin (canonical_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_gbif_backbone_accepted ON gbif_backbone(accepted_key) WHERE accepted_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_species_gbif_taxon_key ON species(gbif_taxon_key) WHERE gbif_taxon_key IS NOT NULL;
+
+COMMENT ON TABLE gbif_backbone IS 'Plant names of the GBIF Backbone Taxonomy, for name resolution';
@@ -0,0 +1,35 @@
+-- Migration 047: Planting outcomes
+-- Monitored survival and growth of the species of a plan, uploaded as CSV to
+-- POST /api/plans/{id}/outcomes. Plans built from a recommendation keep its
+-- selection hash and each species' climate match score, and every outcome
+-- copies them, so /api/admin/evaluation can ask whether the score predicted
+-- survival even after the recommendation itself has expired.
+
+ALTER TABLE restoration_plans
+    ADD COLUMN IF NOT EXISTS selection_hash VARCHAR(16);   -- RecommendResponse.selection_hash
+
+ALTER TABLE restoration_plan_species
+    ADD COLUMN IF NOT EXISTS climate_match_score DECIMAL(5,4);
+
+CREATE TABLE IF NOT EXISTS planting_outcomes (
+    id BIGSERIAL PRIMARY KEY,
+    plan_id INTEGER NOT NULL,
+    species_id INTEGER NOT NULL,
+    monitored_at DATE NOT NULL,
+    planted INTEGER NOT NULL CHECK (planted > 0),   -- Seedlings planted
+    surviving INTEGER NOT NULL CHECK (surviving >= 0 AND surviving <= planted),
+    mean_height_m DECIMAL(6,2) CHECK (mean_height_m IS NULL OR mean_height_m >= 0),
+    notes TEXT,
+
+    -- Copied from the plan at upload
+    selection_hash VARCHAR(16),
+    climate_match_score DECIMAL(5,4),
+
+    imported_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (plan_id, species_id) REFERENCES restoration_plan_species(plan_id, species_id) ON DELETE CASCADE,
+    UNIQUE (plan_id, species_id, monitored_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_planting_outcomes_score ON planting_outcomes(climate_match_score);
+
+COMMENT ON TABLE planting_outcomes IS 'Monitored survival and growth per plan and species, for recommendation evaluation';
@@ -0,0 +1,25 @@
+-- Migration 048: Public research dataset
+-- Recommendations whose request set share_for_research, anonymized when
+-- recorded: month instead of timestamp, coordinates moved to a 1 degree
+-- cell, no API key or address. Released periodically as immutable,
+-- numbered versions of the whole table, published at /api/dataset.
+
+CREATE TABLE IF NOT EXISTS research_contributions (
+    id BIGSERIAL PRIMARY KEY,
+    contributed_month DATE NOT NULL,     -- First day of the month
+    tdwg_code VARCHAR(10),
+    record JSONB NOT NULL                -- The published record
+);
+
+CREATE TABLE IF NOT EXISTS dataset_releases (
+    version INTEGER PRIMARY KEY,
+    released_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    n_records INTEGER NOT NULL,
+    last_contribution_id BIGINT NOT NULL,  -- A release holds every contribution up to this one
+    object_key TEXT NOT NULL,              -- In PUBLIC_DATASET_URL
+    bytes BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL
+);
+
+COMMENT ON TABLE research_contributions IS 'Anonymized recommendations shared for research (opt-in)';
+COMMENT ON TABLE dataset_releases IS 'Published versions of the research dataset';
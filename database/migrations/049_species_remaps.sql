@@ -0,0 +1,28 @@
+-- Migration 049: Species remaps
+-- When a synchronization marks a species as a synonym (taxonomic_status =
+-- 'synonym' with accepted_name_id), the propagation job in
+-- taxonomy_propagation.go moves its regions, traits, envelopes, plan
+-- entries and other rows to the accepted species and records the move
+-- here. The synonym's species row stays, so old IDs and old plan exports
+-- still resolve to the accepted name through /api/species/remaps.
+
+CREATE TABLE IF NOT EXISTS species_remaps (
+    from_species_id INTEGER PRIMARY KEY REFERENCES species(id) ON DELETE CASCADE,
+    to_species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    from_name VARCHAR(255) NOT NULL,     -- Names at the time of the remap
+    to_name VARCHAR(255) NOT NULL,
+    rows_moved JSONB,                    -- {"species_regions": 12, ...}
+    rows_dropped JSONB,                  -- Rows the accepted species already had
+    remapped_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_species_remaps_to ON species_remaps(to_species_id);
+CREATE INDEX IF NOT EXISTS idx_species_remaps_from_name ON species_remaps(LOWER(from_name));
+
+-- Outcomes follow their plan species when it is remapped
+ALTER TABLE planting_outcomes DROP CONSTRAINT IF EXISTS planting_outcomes_plan_id_species_id_fkey;
+ALTER TABLE planting_outcomes ADD CONSTRAINT planting_outcomes_plan_id_species_id_fkey
+    FOREIGN KEY (plan_id, species_id) REFERENCES restoration_plan_species(plan_id, species_id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
+
+COMMENT ON TABLE species_remaps IS 'Synonym species whose data was moved to the accepted species';
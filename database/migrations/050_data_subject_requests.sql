@@ -0,0 +1,20 @@
+-- Migration 050: Data-subject requests (LGPD)
+-- An API key's holder can export everything stored about them
+-- (/api/me/export) or delete it (/api/me/delete), removing the key itself
+-- with plans, observations, suggestions, saved queries, jobs, audit rows and
+-- notifications. Each request is logged here, without the holder's name, as
+-- the record that it was honored.
+
+CREATE TABLE IF NOT EXISTS data_subject_requests (
+    id SERIAL PRIMARY KEY,
+    api_key_id INTEGER NOT NULL,         -- No FK: the key is gone after a deletion
+    kind VARCHAR(10) NOT NULL,           -- 'export', 'delete'
+    requested_by INTEGER NOT NULL,       -- The key itself, or the admin acting for its holder
+    rows_deleted JSONB,                  -- {"restoration_plans": 2, ...}
+    requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (kind IN ('export', 'delete'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_subject_requests_key ON data_subject_requests(api_key_id);
+
+COMMENT ON TABLE data_subject_requests IS 'LGPD export and deletion requests, one row each';
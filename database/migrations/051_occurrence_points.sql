@@ -0,0 +1,8 @@
+-- Migration 051: Occurrence point index
+-- /api/species/within finds the GBIF occurrences inside a bbox or site
+-- polygon. gbif_occurrences keeps coordinates as latitude/longitude
+-- columns, so the points are indexed as an expression; queries must use
+-- the same expression to hit it.
+
+CREATE INDEX IF NOT EXISTS idx_gbif_occ_point ON gbif_occurrences
+    USING GIST (ST_SetSRID(ST_MakePoint(longitude::float8, latitude::float8), 4326));
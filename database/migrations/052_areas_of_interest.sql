@@ -0,0 +1,17 @@
+-- Migration 052: Areas of interest
+-- Project boundaries uploaded as GeoJSON through POST /api/aoi (see
+-- aoi.go). An area is immutable once stored, so recommendations cached for
+-- an aoi_id stay valid; it is read by its random id, which is what other
+-- endpoints take as a location.
+
+CREATE TABLE IF NOT EXISTS areas_of_interest (
+    id VARCHAR(32) PRIMARY KEY,              -- Random hex, unguessable
+    owner_key_id INTEGER REFERENCES api_keys(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    geom GEOMETRY(MultiPolygon, 4326) NOT NULL,
+    area_km2 DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_areas_of_interest_owner ON areas_of_interest(owner_key_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_areas_of_interest_geom ON areas_of_interest USING GIST(geom);
@@ -0,0 +1,117 @@
+-- Migration 053: Outlier-robust climate envelopes
+-- species_climate_envelope (migration 009) takes the absolute MIN/MAX of
+-- the native regions' climate, so one wrong distribution record in a hot or
+-- cold region widens a species' tolerance for every recommendation. This
+-- table holds envelopes whose limits are the lower/upper quantiles across
+-- the native regions instead, by percentile or by kernel density, rebuilt
+-- by POST /api/admin/envelopes (see robust_envelope.go). Means are the same
+-- averages as in species_climate_envelope. Species with too few regions for
+-- a quantile to mean anything have no row and keep their min/max envelope.
+
+CREATE TABLE IF NOT EXISTS species_climate_envelope_robust (
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    method VARCHAR(16) NOT NULL CHECK (method IN ('percentile', 'kde')),
+
+    temp_mean DECIMAL(6,2),
+    temp_min DECIMAL(6,2),        -- Lower quantile of bio1_min
+    temp_max DECIMAL(6,2),        -- Upper quantile of bio1_max
+    precip_mean DECIMAL(8,2),
+    precip_min DECIMAL(8,2),      -- Lower quantile of bio12_min
+    precip_max DECIMAL(8,2),      -- Upper quantile of bio12_max
+    precip_seasonality DECIMAL(6,2),
+    cold_month_min DECIMAL(6,2),  -- Lower quantile of bio6_mean
+    warm_month_max DECIMAL(6,2),  -- Upper quantile of bio5_mean
+
+    lower_quantile DECIMAL(4,3) NOT NULL,
+    upper_quantile DECIMAL(4,3) NOT NULL,
+    n_regions_sampled INTEGER,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (species_id, method)
+);
+
+-- calculate_climate_match_weighted (migration 031) against the robust
+-- envelope of p_method, falling back to species_climate_envelope for
+-- species without one. The terms are the same.
+CREATE OR REPLACE FUNCTION calculate_climate_match_envelope(
+    p_species_id INTEGER,
+    p_bio1 DECIMAL,
+    p_bio5 DECIMAL,
+    p_bio6 DECIMAL,
+    p_bio12 DECIMAL,
+    p_bio15 DECIMAL,
+    p_weights DECIMAL[],
+    p_method TEXT
+) RETURNS DECIMAL AS $$
+DECLARE
+    v_envelope RECORD;
+    v_score DECIMAL := 0;
+    v_total DECIMAL;
+BEGIN
+    v_total := p_weights[1] + p_weights[2] + p_weights[3] + p_weights[4] + p_weights[5] + p_weights[6];
+    IF v_total IS NULL OR v_total <= 0 THEN
+        RETURN 0;
+    END IF;
+
+    SELECT temp_mean, temp_min, temp_max, precip_mean, precip_seasonality, cold_month_min INTO v_envelope
+    FROM species_climate_envelope_robust
+    WHERE species_id = p_species_id AND method = p_method;
+
+    IF NOT FOUND THEN
+        SELECT temp_mean, temp_min, temp_max, precip_mean, precip_seasonality, cold_month_min INTO v_envelope
+        FROM species_climate_envelope
+        WHERE species_id = p_species_id;
+
+        IF NOT FOUND THEN
+            RETURN 0;
+        END IF;
+    END IF;
+
+    -- 1. Temperature mean match, ±10°C tolerance
+    IF p_weights[1] > 0 THEN
+        v_score := v_score + GREATEST(0, 1 - ABS(p_bio1 - v_envelope.temp_mean) / 10.0) * p_weights[1];
+    END IF;
+
+    -- 2. Temperature extremes: hard limits (±3°C margin)
+    IF p_weights[2] > 0 THEN
+        IF p_bio5 > v_envelope.temp_max + 3 THEN
+            RETURN 0;
+        END IF;
+        v_score := v_score + p_weights[2];
+    END IF;
+    IF p_weights[3] > 0 THEN
+        IF p_bio6 < v_envelope.temp_min - 3 THEN
+            RETURN 0;
+        END IF;
+        v_score := v_score + p_weights[3];
+    END IF;
+
+    -- 3. Precipitation match, half credit without data
+    IF p_weights[4] > 0 THEN
+        IF v_envelope.precip_mean > 0 THEN
+            v_score := v_score + GREATEST(0, 1 - ABS(p_bio12 - v_envelope.precip_mean) / v_envelope.precip_mean) * p_weights[4];
+        ELSE
+            v_score := v_score + 0.5 * p_weights[4];
+        END IF;
+    END IF;
+
+    -- 4. Precipitation seasonality, ±50 tolerance
+    IF p_weights[5] > 0 THEN
+        v_score := v_score + GREATEST(0, 1 - ABS(p_bio15 - v_envelope.precip_seasonality) / 50.0) * p_weights[5];
+    END IF;
+
+    -- 5. Cold hardiness where frost occurs, a third of the credit if risky
+    IF p_weights[6] > 0 THEN
+        IF p_bio6 < 0 AND (v_envelope.cold_month_min < p_bio6 - 2) IS NOT TRUE THEN
+            v_score := v_score + p_weights[6] / 3.0;
+        ELSE
+            v_score := v_score + p_weights[6];
+        END IF;
+    END IF;
+
+    RETURN ROUND((v_score / v_total)::numeric, 3);
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+COMMENT ON FUNCTION calculate_climate_match_envelope(INTEGER, DECIMAL, DECIMAL, DECIMAL, DECIMAL, DECIMAL, DECIMAL[], TEXT) IS
+    'calculate_climate_match_weighted against the percentile or kde envelope, falling back to species_climate_envelope';
@@ -0,0 +1,35 @@
+-- Migration 054: GeoParquet exports of the spatial layers
+-- POST /api/export/spatial queues an export of tdwg_level3, ecoregions or
+-- species_geometry; a worker of the query-explorer writes the GeoParquet
+-- file into spatial_export_files, downloaded from
+-- /api/export/spatial/{id}.parquet. The file lives in its own table so the
+-- retention job archives only the job row; finished jobs expire after a day
+-- (RETENTION_SPATIAL_EXPORTS) and take their file with them.
+
+CREATE TABLE IF NOT EXISTS spatial_export_jobs (
+    id VARCHAR(32) PRIMARY KEY,          -- Random hex token
+    layer VARCHAR(32) NOT NULL,
+    tolerance DOUBLE PRECISION NOT NULL, -- Simplification, degrees; 0 = full detail
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    n_rows INTEGER,
+    bytes BIGINT,
+    error TEXT,
+    created_by INTEGER REFERENCES api_keys(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    CHECK (layer IN ('tdwg_level3', 'ecoregions', 'species_geometry')),
+    CHECK (status IN ('queued', 'running', 'succeeded', 'failed', 'cancelled'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_spatial_export_jobs_queued ON spatial_export_jobs(created_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_spatial_export_jobs_finished ON spatial_export_jobs(finished_at) WHERE finished_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_spatial_export_jobs_owner ON spatial_export_jobs(created_by);
+
+CREATE TABLE IF NOT EXISTS spatial_export_files (
+    job_id VARCHAR(32) PRIMARY KEY REFERENCES spatial_export_jobs(id) ON DELETE CASCADE,
+    data BYTEA NOT NULL
+);
+
+COMMENT ON TABLE spatial_export_jobs IS 'Asynchronous GeoParquet exports of the spatial layers';
+COMMENT ON TABLE spatial_export_files IS 'GeoParquet files of succeeded spatial_export_jobs';
@@ -0,0 +1,94 @@
+-- Migration 055: Regional abundance classes
+-- How often a species is actually recorded in a region, as a proxy for how
+-- reliably it can be sourced there: GBIF occurrences inside the region's
+-- polygon plus accepted field observations, per 10,000 km² of region area.
+-- The classes are absolute, so a sparsely recorded region can have no
+-- common species (see abundance_class_of):
+--   common      - at least 5 records per 10,000 km², and 50 records
+--   occasional  - at least 0.5 records per 10,000 km², and 10 records
+--   rare        - anything less
+-- Species without any record in a region have no row (unknown abundance).
+-- The API fills it on its first start and refreshes it nightly
+-- (ABUNDANCE_INTERVAL) with refresh_species_abundance(); it can also be run
+-- by hand after an import.
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'abundance_class') THEN
+        CREATE TYPE abundance_class AS ENUM ('rare', 'occasional', 'common');
+    END IF;
+END$$;
+
+CREATE TABLE IF NOT EXISTS species_region_abundance (
+    species_id INTEGER NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    tdwg_code VARCHAR(10) NOT NULL,
+    n_occurrences INTEGER NOT NULL,     -- GBIF occurrences + accepted observations
+    density DOUBLE PRECISION NOT NULL,  -- Records per 10,000 km²
+    abundance abundance_class NOT NULL,
+    refreshed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (species_id, tdwg_code)
+);
+
+CREATE INDEX IF NOT EXISTS idx_species_region_abundance_region ON species_region_abundance(tdwg_code, abundance);
+
+COMMENT ON TABLE species_region_abundance IS 'Abundance classes per species and TDWG region, from occurrence densities';
+
+-- ============================================================================
+-- FUNCTION: abundance_class_of
+-- The class of a species in a region from its record count and density.
+-- The minimum counts keep a couple of records in a small region from
+-- making a species common.
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION abundance_class_of(p_n_occurrences INTEGER, p_density DOUBLE PRECISION)
+RETURNS abundance_class AS $$
+    SELECT CASE
+        WHEN p_density >= 5 AND p_n_occurrences >= 50 THEN 'common'
+        WHEN p_density >= 0.5 AND p_n_occurrences >= 10 THEN 'occasional'
+        ELSE 'rare'
+    END::abundance_class
+$$ LANGUAGE sql IMMUTABLE;
+
+-- ============================================================================
+-- FUNCTION: refresh_species_abundance
+-- Rebuilds species_region_abundance in one transaction, so readers see
+-- either the old or the new classes. Returns the rows written.
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION refresh_species_abundance()
+RETURNS INTEGER AS $$
+DECLARE
+    v_count INTEGER;
+BEGIN
+    DELETE FROM species_region_abundance;
+
+    WITH records AS (
+        SELECT o.species_id, t.level3_code AS tdwg_code, COUNT(*) AS n
+        FROM gbif_occurrences o
+        JOIN tdwg_level3 t
+          ON ST_Contains(t.geom, ST_SetSRID(ST_MakePoint(o.longitude::float8, o.latitude::float8), 4326))
+        WHERE o.species_id IS NOT NULL AND o.latitude IS NOT NULL AND o.longitude IS NOT NULL
+        GROUP BY o.species_id, t.level3_code
+
+        UNION ALL
+
+        SELECT species_id, tdwg_code, COUNT(*)
+        FROM observations
+        WHERE status = 'accepted' AND tdwg_code IS NOT NULL
+        GROUP BY species_id, tdwg_code
+    ),
+    densities AS (
+        SELECT r.species_id, r.tdwg_code, SUM(r.n)::int AS n_occurrences,
+               SUM(r.n) * 10000.0 / GREATEST(ST_Area(t.geom::geography) / 1e6, 1) AS density
+        FROM records r
+        JOIN tdwg_level3 t ON t.level3_code = r.tdwg_code
+        GROUP BY r.species_id, r.tdwg_code, t.geom
+    )
+    INSERT INTO species_region_abundance (species_id, tdwg_code, n_occurrences, density, abundance)
+    SELECT species_id, tdwg_code, n_occurrences, density, abundance_class_of(n_occurrences, density)
+    FROM densities;
+
+    SELECT COUNT(*) INTO v_count FROM species_region_abundance;
+    RETURN v_count;
+END;
+$$ LANGUAGE plpgsql;
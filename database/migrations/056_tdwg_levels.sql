@@ -0,0 +1,185 @@
+-- Migration 056: TDWG level-1 (continents) and level-2 (regions) units
+-- WGSRPD groups the level-3 "botanical countries" of tdwg_level3 into 52
+-- regions (tdwg_level3.level2_code, two digits) and 9 continents (one digit,
+-- the first of their regions' codes). The units are listed here with their
+-- names and species counts rolled up from species_regions, refreshed by the
+-- API (TDWG_ROLLUP_INTERVAL) with refresh_tdwg_rollups().
+-- Localized names use kind 'tdwg_region' with the unit code; the codes of
+-- the three levels (digit, two digits, three letters) never collide.
+
+CREATE TABLE IF NOT EXISTS tdwg_level1 (
+    level1_code VARCHAR(2) PRIMARY KEY,
+    level1_name VARCHAR(100) NOT NULL,
+    n_level3 INTEGER,            -- Roll-ups, NULL before the first refresh
+    n_species INTEGER,
+    n_native_species INTEGER,
+    refreshed_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS tdwg_level2 (
+    level2_code VARCHAR(3) PRIMARY KEY,
+    level2_name VARCHAR(100) NOT NULL,
+    level1_code VARCHAR(2) NOT NULL REFERENCES tdwg_level1(level1_code),
+    n_level3 INTEGER,
+    n_species INTEGER,
+    n_native_species INTEGER,
+    refreshed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tdwg_level2_level1 ON tdwg_level2(level1_code);
+CREATE INDEX IF NOT EXISTS idx_tdwg_level3_level2 ON tdwg_level3(level2_code);
+
+COMMENT ON TABLE tdwg_level1 IS 'WGSRPD level-1 units (continents) with species roll-ups';
+COMMENT ON TABLE tdwg_level2 IS 'WGSRPD level-2 units (regions) with species roll-ups';
+
+INSERT INTO tdwg_level1 (level1_code, level1_name) VALUES
+    ('1', 'Europe'),
+    ('2', 'Africa'),
+    ('3', 'Asia-Temperate'),
+    ('4', 'Asia-Tropical'),
+    ('5', 'Australasia'),
+    ('6', 'Pacific'),
+    ('7', 'Northern America'),
+    ('8', 'Southern America'),
+    ('9', 'Antarctic')
+ON CONFLICT (level1_code) DO UPDATE SET level1_name = EXCLUDED.level1_name;
+
+INSERT INTO tdwg_level2 (level2_code, level2_name, level1_code) VALUES
+    ('10', 'Northern Europe', '1'),
+    ('11', 'Middle Europe', '1'),
+    ('12', 'Southwestern Europe', '1'),
+    ('13', 'Southeastern Europe', '1'),
+    ('14', 'Eastern Europe', '1'),
+    ('20', 'Northern Africa', '2'),
+    ('21', 'Macaronesia', '2'),
+    ('22', 'West Tropical Africa', '2'),
+    ('23', 'West-Central Tropical Africa', '2'),
+    ('24', 'Northeast Tropical Africa', '2'),
+    ('25', 'East Tropical Africa', '2'),
+    ('26', 'South Tropical Africa', '2'),
+    ('27', 'Southern Africa', '2'),
+    ('28', 'Middle Atlantic Ocean', '2'),
+    ('29', 'Western Indian Ocean', '2'),
+    ('30', 'Siberia', '3'),
+    ('31', 'Russian Far East', '3'),
+    ('32', 'Middle Asia', '3'),
+    ('33', 'Caucasus', '3'),
+    ('34', 'Western Asia', '3'),
+    ('35', 'Arabian Peninsula', '3'),
+    ('36', 'China', '3'),
+    ('37', 'Mongolia', '3'),
+    ('38', 'Eastern Asia', '3'),
+    ('40', 'Indian Subcontinent', '4'),
+    ('41', 'Indo-China', '4'),
+    ('42', 'Malesia', '4'),
+    ('43', 'Papuasia', '4'),
+    ('50', 'Australia', '5'),
+    ('51', 'New Zealand', '5'),
+    ('60', 'Southwestern Pacific', '6'),
+    ('61', 'South-Central Pacific', '6'),
+    ('62', 'Northwestern Pacific', '6'),
+    ('63', 'North-Central Pacific', '6'),
+    ('70', 'Subarctic America', '7'),
+    ('71', 'Western Canada', '7'),
+    ('72', 'Eastern Canada', '7'),
+    ('73', 'Northwestern U.S.A.', '7'),
+    ('74', 'North-Central U.S.A.', '7'),
+    ('75', 'Northeastern U.S.A.', '7'),
+    ('76', 'Southwestern U.S.A.', '7'),
+    ('77', 'South-Central U.S.A.', '7'),
+    ('78', 'Southeastern U.S.A.', '7'),
+    ('79', 'Mexico', '7'),
+    ('80', 'Central America', '8'),
+    ('81', 'Caribbean', '8'),
+    ('82', 'Northern South America', '8'),
+    ('83', 'Western South America', '8'),
+    ('84', 'Brazil', '8'),
+    ('85', 'Southern South America', '8'),
+    ('90', 'Subantarctic Islands', '9'),
+    ('91', 'Antarctic Continent', '9')
+ON CONFLICT (level2_code) DO UPDATE SET level2_name = EXCLUDED.level2_name, level1_code = EXCLUDED.level1_code;
+
+INSERT INTO localized_names (kind, code, language, name) VALUES
+    ('tdwg_region', '1', 'pt', 'Europa'),
+    ('tdwg_region', '2', 'pt', 'África'),
+    ('tdwg_region', '3', 'pt', 'Ásia Temperada'),
+    ('tdwg_region', '4', 'pt', 'Ásia Tropical'),
+    ('tdwg_region', '5', 'pt', 'Australásia'),
+    ('tdwg_region', '6', 'pt', 'Pacífico'),
+    ('tdwg_region', '7', 'pt', 'América do Norte'),
+    ('tdwg_region', '8', 'pt', 'América do Sul'),
+    ('tdwg_region', '9', 'pt', 'Antártida'),
+    ('tdwg_region', '79', 'pt', 'México'),
+    ('tdwg_region', '80', 'pt', 'América Central'),
+    ('tdwg_region', '81', 'pt', 'Caribe'),
+    ('tdwg_region', '82', 'pt', 'Norte da América do Sul'),
+    ('tdwg_region', '83', 'pt', 'Oeste da América do Sul'),
+    ('tdwg_region', '84', 'pt', 'Brasil'),
+    ('tdwg_region', '85', 'pt', 'Sul da América do Sul'),
+    ('tdwg_region', '1', 'es', 'Europa'),
+    ('tdwg_region', '2', 'es', 'África'),
+    ('tdwg_region', '3', 'es', 'Asia templada'),
+    ('tdwg_region', '4', 'es', 'Asia tropical'),
+    ('tdwg_region', '5', 'es', 'Australasia'),
+    ('tdwg_region', '6', 'es', 'Pacífico'),
+    ('tdwg_region', '7', 'es', 'América del Norte'),
+    ('tdwg_region', '8', 'es', 'América del Sur'),
+    ('tdwg_region', '9', 'es', 'Antártida'),
+    ('tdwg_region', '84', 'es', 'Brasil')
+ON CONFLICT (kind, code, language) DO NOTHING;
+
+-- ============================================================================
+-- FUNCTION: refresh_tdwg_rollups
+-- Recounts the level-3 units and the species (all, and native somewhere in
+-- the unit) of every level-1 and level-2 unit, in one transaction. Returns
+-- the units updated.
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION refresh_tdwg_rollups()
+RETURNS INTEGER AS $$
+DECLARE
+    v_level1 INTEGER;
+    v_level2 INTEGER;
+BEGIN
+    UPDATE tdwg_level2 u
+    SET n_level3 = COALESCE(c.n_level3, 0),
+        n_species = COALESCE(c.n_species, 0),
+        n_native_species = COALESCE(c.n_native_species, 0),
+        refreshed_at = CURRENT_TIMESTAMP
+    FROM tdwg_level2 u2
+    LEFT JOIN (
+        SELECT t.level2_code,
+               COUNT(DISTINCT t.level3_code) AS n_level3,
+               COUNT(DISTINCT sr.species_id) AS n_species,
+               COUNT(DISTINCT sr.species_id) FILTER (WHERE sr.is_native) AS n_native_species
+        FROM tdwg_level3 t
+        LEFT JOIN species_regions sr ON sr.tdwg_code = t.level3_code
+        GROUP BY t.level2_code
+    ) c ON c.level2_code = u2.level2_code
+    WHERE u.level2_code = u2.level2_code;
+    GET DIAGNOSTICS v_level2 = ROW_COUNT;
+
+    UPDATE tdwg_level1 u
+    SET n_level3 = COALESCE(c.n_level3, 0),
+        n_species = COALESCE(c.n_species, 0),
+        n_native_species = COALESCE(c.n_native_species, 0),
+        refreshed_at = CURRENT_TIMESTAMP
+    FROM tdwg_level1 u1
+    LEFT JOIN (
+        SELECT l2.level1_code,
+               COUNT(DISTINCT t.level3_code) AS n_level3,
+               COUNT(DISTINCT sr.species_id) AS n_species,
+               COUNT(DISTINCT sr.species_id) FILTER (WHERE sr.is_native) AS n_native_species
+        FROM tdwg_level2 l2
+        JOIN tdwg_level3 t ON t.level2_code = l2.level2_code
+        LEFT JOIN species_regions sr ON sr.tdwg_code = t.level3_code
+        GROUP BY l2.level1_code
+    ) c ON c.level1_code = u1.level1_code
+    WHERE u.level1_code = u1.level1_code;
+    GET DIAGNOSTICS v_level1 = ROW_COUNT;
+
+    RETURN v_level1 + v_level2;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT refresh_tdwg_rollups();
@@ -0,0 +1,127 @@
+-- Migration 057: Administrative units and their TDWG crosswalk
+-- state_code on /api/recommend (and plans, compliance checks and
+-- /api/climate/match) used to be looked up in a hard-coded table of
+-- Brazilian states. It now accepts any ISO 3166-1 country (BR) or 3166-2
+-- subdivision (BR-SC) in admin_units, resolved through admin_to_tdwg: the
+-- share of the unit's area in each TDWG level-3 region.
+--
+-- Brazilian states are seeded with their WGSRPD regions, which follow state
+-- borders (source 'wgsrpd'). Other units come from Natural Earth boundaries
+-- loaded with scripts/load_admin_units.py, which then computes their shares
+-- spatially with refresh_admin_to_tdwg() (source 'spatial').
+
+CREATE TABLE IF NOT EXISTS admin_units (
+    id SERIAL PRIMARY KEY,
+    iso_code VARCHAR(10) NOT NULL UNIQUE,  -- ISO 3166-1 alpha-2 or 3166-2
+    name VARCHAR(255) NOT NULL,
+    country_code CHAR(2) NOT NULL,
+    level SMALLINT NOT NULL,               -- 0 country, 1 subdivision
+    geom GEOMETRY(MultiPolygon, 4326),     -- NULL for seeded units
+    CHECK (level IN (0, 1))
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_units_country ON admin_units(country_code, level);
+CREATE INDEX IF NOT EXISTS idx_admin_units_geom ON admin_units USING GIST(geom);
+
+CREATE TABLE IF NOT EXISTS admin_to_tdwg (
+    iso_code VARCHAR(10) NOT NULL REFERENCES admin_units(iso_code) ON DELETE CASCADE,
+    tdwg_code VARCHAR(10) NOT NULL,
+    share DOUBLE PRECISION NOT NULL,       -- Of the unit's area, 0-1
+    source VARCHAR(20) NOT NULL DEFAULT 'spatial',
+    PRIMARY KEY (iso_code, tdwg_code),
+    CHECK (share > 0 AND share <= 1),
+    CHECK (source IN ('wgsrpd', 'spatial'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_to_tdwg_tdwg ON admin_to_tdwg(tdwg_code);
+
+COMMENT ON TABLE admin_units IS 'ISO 3166 countries and subdivisions accepted as state_code';
+COMMENT ON TABLE admin_to_tdwg IS 'Share of each administrative unit''s area in each TDWG level-3 region';
+
+-- =============================================
+-- Brazil: states by WGSRPD region
+-- =============================================
+
+INSERT INTO admin_units (iso_code, name, country_code, level) VALUES
+    ('BR', 'Brasil', 'BR', 0),
+    ('BR-AC', 'Acre', 'BR', 1),
+    ('BR-AL', 'Alagoas', 'BR', 1),
+    ('BR-AP', 'Amapá', 'BR', 1),
+    ('BR-AM', 'Amazonas', 'BR', 1),
+    ('BR-BA', 'Bahia', 'BR', 1),
+    ('BR-CE', 'Ceará', 'BR', 1),
+    ('BR-DF', 'Distrito Federal', 'BR', 1),
+    ('BR-ES', 'Espírito Santo', 'BR', 1),
+    ('BR-GO', 'Goiás', 'BR', 1),
+    ('BR-MA', 'Maranhão', 'BR', 1),
+    ('BR-MT', 'Mato Grosso', 'BR', 1),
+    ('BR-MS', 'Mato Grosso do Sul', 'BR', 1),
+    ('BR-MG', 'Minas Gerais', 'BR', 1),
+    ('BR-PA', 'Pará', 'BR', 1),
+    ('BR-PB', 'Paraíba', 'BR', 1),
+    ('BR-PR', 'Paraná', 'BR', 1),
+    ('BR-PE', 'Pernambuco', 'BR', 1),
+    ('BR-PI', 'Piauí', 'BR', 1),
+    ('BR-RJ', 'Rio de Janeiro', 'BR', 1),
+    ('BR-RN', 'Rio Grande do Norte', 'BR', 1),
+    ('BR-RS', 'Rio Grande do Sul', 'BR', 1),
+    ('BR-RO', 'Rondônia', 'BR', 1),
+    ('BR-RR', 'Roraima', 'BR', 1),
+    ('BR-SC', 'Santa Catarina', 'BR', 1),
+    ('BR-SP', 'São Paulo', 'BR', 1),
+    ('BR-SE', 'Sergipe', 'BR', 1),
+    ('BR-TO', 'Tocantins', 'BR', 1)
+ON CONFLICT (iso_code) DO NOTHING;
+
+INSERT INTO admin_to_tdwg (iso_code, tdwg_code, share, source) VALUES
+    -- BZN Brazil North
+    ('BR-AC', 'BZN', 1, 'wgsrpd'), ('BR-AP', 'BZN', 1, 'wgsrpd'), ('BR-AM', 'BZN', 1, 'wgsrpd'),
+    ('BR-PA', 'BZN', 1, 'wgsrpd'), ('BR-RO', 'BZN', 1, 'wgsrpd'), ('BR-RR', 'BZN', 1, 'wgsrpd'),
+    ('BR-TO', 'BZN', 1, 'wgsrpd'),
+    -- BZE Brazil Northeast
+    ('BR-AL', 'BZE', 1, 'wgsrpd'), ('BR-BA', 'BZE', 1, 'wgsrpd'), ('BR-CE', 'BZE', 1, 'wgsrpd'),
+    ('BR-MA', 'BZE', 1, 'wgsrpd'), ('BR-PB', 'BZE', 1, 'wgsrpd'), ('BR-PE', 'BZE', 1, 'wgsrpd'),
+    ('BR-PI', 'BZE', 1, 'wgsrpd'), ('BR-RN', 'BZE', 1, 'wgsrpd'), ('BR-SE', 'BZE', 1, 'wgsrpd'),
+    -- BZC Brazil West-Central
+    ('BR-DF', 'BZC', 1, 'wgsrpd'), ('BR-GO', 'BZC', 1, 'wgsrpd'), ('BR-MT', 'BZC', 1, 'wgsrpd'),
+    ('BR-MS', 'BZC', 1, 'wgsrpd'),
+    -- BZL Brazil Southeast
+    ('BR-ES', 'BZL', 1, 'wgsrpd'), ('BR-MG', 'BZL', 1, 'wgsrpd'), ('BR-RJ', 'BZL', 1, 'wgsrpd'),
+    ('BR-SP', 'BZL', 1, 'wgsrpd'),
+    -- BZS Brazil South
+    ('BR-PR', 'BZS', 1, 'wgsrpd'), ('BR-RS', 'BZS', 1, 'wgsrpd'), ('BR-SC', 'BZS', 1, 'wgsrpd'),
+    -- The country, by the IBGE areas of the states
+    ('BR', 'BZN', 0.4525, 'wgsrpd'), ('BR', 'BZE', 0.1825, 'wgsrpd'), ('BR', 'BZC', 0.1886, 'wgsrpd'),
+    ('BR', 'BZL', 0.1085, 'wgsrpd'), ('BR', 'BZS', 0.0679, 'wgsrpd')
+ON CONFLICT (iso_code, tdwg_code) DO NOTHING;
+
+-- ============================================================================
+-- FUNCTION: refresh_admin_to_tdwg
+-- Recomputes the spatial shares of the units with a geometry, except those
+-- mapped by definition ('wgsrpd'); overlaps under 0.1% (border slivers) are
+-- dropped. Returns the rows written.
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION refresh_admin_to_tdwg()
+RETURNS INTEGER AS $$
+DECLARE
+    v_count INTEGER;
+BEGIN
+    DELETE FROM admin_to_tdwg WHERE source = 'spatial';
+
+    INSERT INTO admin_to_tdwg (iso_code, tdwg_code, share, source)
+    SELECT iso_code, level3_code, LEAST(share, 1), 'spatial'
+    FROM (
+        SELECT a.iso_code, t.level3_code,
+               ST_Area(ST_Intersection(a.geom, t.geom)::geography) / NULLIF(ST_Area(a.geom::geography), 0) AS share
+        FROM admin_units a
+        JOIN tdwg_level3 t ON ST_Intersects(a.geom, t.geom)
+        WHERE a.geom IS NOT NULL
+          AND NOT EXISTS (SELECT 1 FROM admin_to_tdwg x WHERE x.iso_code = a.iso_code AND x.source = 'wgsrpd')
+    ) o
+    WHERE share >= 0.001;
+
+    GET DIAGNOSTICS v_count = ROW_COUNT;
+    RETURN v_count;
+END;
+$$ LANGUAGE plpgsql;
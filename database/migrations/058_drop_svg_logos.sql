@@ -0,0 +1,8 @@
+-- Migration 058: Drop SVG tenant logos
+-- Logos are served from the API origin, where an SVG with script is stored
+-- XSS; the API now accepts PNG and JPEG only. Logos uploaded as SVG before
+-- are removed, and the tenant uploads a raster one.
+
+UPDATE tenant_themes
+SET logo = NULL, logo_content_type = NULL
+WHERE logo_content_type = 'image/svg+xml';
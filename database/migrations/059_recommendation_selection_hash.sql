@@ -0,0 +1,8 @@
+-- Migration 059: Look up cached recommendations by selection hash
+-- POST /api/plans takes the climate match scores of a plan built from a
+-- recommendation from the cached response with its selection_hash, rather
+-- than trusting the client's copy, so planting outcomes are evaluated
+-- against the scores the service actually gave.
+
+CREATE INDEX IF NOT EXISTS idx_recommendation_cache_selection_hash
+    ON recommendation_cache ((response->>'selection_hash'));
@@ -0,0 +1,8 @@
+-- Migration 060: Level-3 regions of a plan
+-- A plan for a level-2 or administrative unit spanning several WGSRPD
+-- regions (e.g. state_code BR) keeps all of them, not only tdwg_code, the
+-- largest, so the nativeness of its species is read over the same regions
+-- its recommendation pooled. NULL for a plan in a single region.
+
+ALTER TABLE restoration_plans
+    ADD COLUMN IF NOT EXISTS level3_codes TEXT[];
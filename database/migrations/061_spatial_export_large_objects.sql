@@ -0,0 +1,27 @@
+-- Migration 061: Spatial export files as large objects
+-- A full-detail species_geometry export can exceed the 1 GB limit of a
+-- BYTEA, and the worker had to hold the whole file in memory to insert it.
+-- The worker now streams the GeoParquet file into a large object and the
+-- download reads it back in chunks; spatial_export_files keeps its OID. The
+-- object is unlinked with its row, so jobs expired by the retention job or
+-- deleted with their API key still take their file with them.
+
+ALTER TABLE spatial_export_files ADD COLUMN IF NOT EXISTS lo OID;
+UPDATE spatial_export_files SET lo = lo_from_bytea(0, data) WHERE lo IS NULL;
+ALTER TABLE spatial_export_files DROP COLUMN IF EXISTS data;
+ALTER TABLE spatial_export_files ALTER COLUMN lo SET NOT NULL;
+
+CREATE OR REPLACE FUNCTION unlink_spatial_export_file() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM lo_unlink(OLD.lo);
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_spatial_export_files_unlink ON spatial_export_files;
+CREATE TRIGGER trigger_spatial_export_files_unlink
+    AFTER DELETE ON spatial_export_files
+    FOR EACH ROW
+    EXECUTE FUNCTION unlink_spatial_export_file();
+
+COMMENT ON COLUMN spatial_export_files.lo IS 'Large object holding the GeoParquet file';
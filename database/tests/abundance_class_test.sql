@@ -0,0 +1,20 @@
+-- abundance_class_of (migration 055): absolute classes, with minimum record
+-- counts for occasional and common. Run with `make sql-test`.
+
+DO $$
+BEGIN
+    -- A region where almost every species has one record: all rare, and a
+    -- second record does not make a species common
+    ASSERT abundance_class_of(1, 0.07) = 'rare', 'single record';
+    ASSERT abundance_class_of(2, 0.14) = 'rare', 'two records';
+
+    -- Density alone is not enough in a small region
+    ASSERT abundance_class_of(3, 30) = 'rare', 'few records, high density';
+    ASSERT abundance_class_of(12, 30) = 'occasional', 'occasional count, high density';
+
+    ASSERT abundance_class_of(10, 0.5) = 'occasional', 'occasional boundary';
+    ASSERT abundance_class_of(10, 0.49) = 'rare', 'below occasional density';
+    ASSERT abundance_class_of(50, 5) = 'common', 'common boundary';
+    ASSERT abundance_class_of(49, 5) = 'occasional', 'below common count';
+    ASSERT abundance_class_of(500, 4.9) = 'occasional', 'below common density';
+END$$;
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// REGIONAL ABUNDANCE
+// ============================================================================
+//
+// Whether a species grows in a region says little about whether seed or
+// seedlings can be found there. species_region_abundance (migration 055)
+// classes each species per TDWG region as rare, occasional or common from
+// the density of its GBIF occurrences and accepted observations, against
+// fixed thresholds (abundance_class_of), so a sparsely recorded region can
+// have no common species. Species with no records in a region have no class
+// there.
+//
+//   - /api/species/{id} returns the class of each region, and /api/species
+//     the class in tdwg_code.
+//   - /api/recommend returns the class of each candidate in the location's
+//     region (the best one of a border blend), and with
+//     Preferences.MinAbundance keeps only species at least that abundant
+//     there, for projects that must source their material locally.
+//
+// The table is refreshed every ABUNDANCE_INTERVAL (default 24h).
+
+// abundanceClasses are the values of the abundance_class enum, in order
+var abundanceClasses = []string{"rare", "occasional", "common"}
+
+// parseAbundanceClass validates an abundance class; "" is none
+func parseAbundanceClass(v string) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" {
+		return "", nil
+	}
+	for _, c := range abundanceClasses {
+		if v == c {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid abundance class: %s (use rare, occasional or common)", v)
+}
+
+// validateMinAbundance checks the min_abundance preference
+func validateMinAbundance(prefs *Preferences) error {
+	class, err := parseAbundanceClass(prefs.MinAbundance)
+	if err != nil {
+		return fmt.Errorf("min_abundance: %w", err)
+	}
+	prefs.MinAbundance = class
+	return nil
+}
+
+// abundanceRegionSQL is the condition on ab.tdwg_code for the candidate
+// query's regions: $6 is the location's region, or the codes of its blend
+// or level-2 unit
+func abundanceRegionSQL(loc LocationInfo) string {
+	if loc.multiRegion() {
+		return "ab.tdwg_code = ANY($6::text[])"
+	}
+	return "ab.tdwg_code = $6"
+}
+
+// abundanceSelectSQL returns the candidate column with the best abundance
+// class of the species in the location's regions
+func abundanceSelectSQL(loc LocationInfo) string {
+	return fmt.Sprintf(`(SELECT MAX(ab.abundance)::text FROM species_region_abundance ab
+			    WHERE ab.species_id = s.id AND %s)`, abundanceRegionSQL(loc))
+}
+
+// abundanceFilterSQL returns the candidate clause for min_abundance (empty
+// when unset), with the class bound as $n
+func abundanceFilterSQL(prefs Preferences, loc LocationInfo, n int) string {
+	if prefs.MinAbundance == "" {
+		return ""
+	}
+	return fmt.Sprintf(`AND EXISTS (
+		    SELECT 1 FROM species_region_abundance ab
+		    WHERE ab.species_id = s.id AND %s AND ab.abundance >= $%d::abundance_class)`, abundanceRegionSQL(loc), n)
+}
+
+// refreshAbundance rebuilds species_region_abundance
+func (s *Server) refreshAbundance(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT refresh_species_abundance()`).Scan(&n)
+	return n, err
+}
+
+// startAbundanceJob refreshes the abundance classes every interval, and at
+// startup if the last refresh is older than that (or never happened)
+func (s *Server) startAbundanceJob(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	run := func() {
+		start := time.Now()
+		n, err := s.refreshAbundance(context.Background())
+		if err != nil {
+			s.log.Printf("Abundance refresh failed: %v", err)
+			return
+		}
+		s.log.Printf("Abundance refresh: %d rows (%s)", n, time.Since(start))
+	}
+
+	go func() {
+		var stale bool
+		err := s.db.QueryRow(`
+			SELECT COALESCE(MAX(refreshed_at) < NOW() - $1::interval, TRUE) FROM species_region_abundance
+		`, fmt.Sprintf("%d seconds", int(interval.Seconds()))).Scan(&stale)
+		if err == nil && stale {
+			run()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+	s.log.Printf("Abundance job scheduled every %s", interval)
+}
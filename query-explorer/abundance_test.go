@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateMinAbundance(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", "", true},
+		{"rare", "rare", true},
+		{" Common ", "common", true},
+		{"abundant", "", false},
+	}
+	for _, tc := range tests {
+		prefs := Preferences{MinAbundance: tc.in}
+		err := validateMinAbundance(&prefs)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: err = %v, want ok=%v", tc.in, err, tc.ok)
+		}
+		if tc.ok && prefs.MinAbundance != tc.want {
+			t.Errorf("%q: normalized to %q, want %q", tc.in, prefs.MinAbundance, tc.want)
+		}
+	}
+}
+
+func TestAbundanceFilterSQL(t *testing.T) {
+	if clause := abundanceFilterSQL(Preferences{}, LocationInfo{}, 9); clause != "" {
+		t.Errorf("min_abundance off: %q", clause)
+	}
+
+	prefs := Preferences{MinAbundance: "occasional"}
+	clause := abundanceFilterSQL(prefs, LocationInfo{TDWGCode: "BZS"}, 9)
+	if !strings.Contains(clause, "ab.tdwg_code = $6") || !strings.Contains(clause, "ab.abundance >= $9::abundance_class") {
+		t.Errorf("single region: %q", clause)
+	}
+
+	blend := LocationInfo{TDWGCode: "BZS", Blend: []BorderRegion{{TDWGCode: "BZS"}, {TDWGCode: "AGE"}}}
+	if clause := abundanceFilterSQL(prefs, blend, 9); !strings.Contains(clause, "ANY($6::text[])") {
+		t.Errorf("border blend: %q", clause)
+	}
+	if col := abundanceSelectSQL(blend); !strings.Contains(col, "MAX(ab.abundance)") || !strings.Contains(col, "ANY($6::text[])") {
+		t.Errorf("border blend column: %q", col)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// ADMINISTRATIVE UNITS
+// ============================================================================
+//
+// state_code used to go through a hard-coded table of Brazilian states
+// (with Ceará, Piauí and Maranhão in the wrong region, and six states
+// missing). It now takes any ISO 3166-1 country (BR) or 3166-2 subdivision
+// (BR-SC, AR-X) in admin_units, resolved with the admin_to_tdwg crosswalk
+// (migration 057): the share of the unit's area in each TDWG level-3
+// region. Regions under minAdminShare of the unit are ignored.
+//
+//   - A unit within one region resolves to it, as that tdwg_code would.
+//   - A unit spanning several (a country, or a subdivision across a WGSRPD
+//     border) stands for all of them as a level-2 code does (see
+//     tdwg_levels.go), with the climate of its regions weighted by share;
+//     tdwg_code in location_info is its largest region.
+//
+//	GET /api/admin-units   paginated list (see pagination.go; sort=name or
+//	                       iso_code, q over name and code), filtered by
+//	                       country and level (0 countries, 1 subdivisions),
+//	                       each with its regions and shares
+
+const minAdminShare = 0.05
+
+var validAdminCode = regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`)
+
+// normalizeAdminCode validates an ISO 3166-1 alpha-2 or 3166-2 code
+func normalizeAdminCode(v string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(v))
+	if !validAdminCode.MatchString(code) {
+		return "", fmt.Errorf("invalid state code: %s (use an ISO 3166 code, e.g. BR or BR-SC)", v)
+	}
+	return code, nil
+}
+
+var adminUnitList = listSpec{
+	DefaultLimit: 100,
+	MaxLimit:     1000,
+	Sorts: map[string]string{
+		"name":     "a.name",
+		"iso_code": "a.iso_code",
+	},
+	DefaultSort:   "iso_code",
+	IDColumn:      "a.id",
+	SearchColumns: []string{"a.name", "a.iso_code"},
+}
+
+// AdminRegion is a TDWG region of an administrative unit
+type AdminRegion struct {
+	TDWGCode string  `json:"tdwg_code"`
+	Share    float64 `json:"share"` // Of the unit's area
+}
+
+type AdminUnit struct {
+	ISOCode     string        `json:"iso_code"`
+	Name        string        `json:"name"`
+	CountryCode string        `json:"country_code"`
+	Level       int           `json:"level"` // 0 country, 1 subdivision
+	Regions     []AdminRegion `json:"regions"`
+}
+
+type AdminUnitListResponse struct {
+	AdminUnits []AdminUnit `json:"admin_units"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+// adminWeights keeps the regions at minAdminShare or more, reweighted to
+// sum 1; largest first, as they come from the crosswalk
+func adminWeights(regions []AdminRegion) []BorderRegion {
+	var kept []BorderRegion
+	total := 0.0
+	for _, rg := range regions {
+		if rg.Share >= minAdminShare {
+			kept = append(kept, BorderRegion{TDWGCode: rg.TDWGCode, Weight: rg.Share})
+			total += rg.Share
+		}
+	}
+	for i := range kept {
+		kept[i].Weight /= total
+	}
+	return kept
+}
+
+// resolveAdminLocation is resolveLocationClimate for an ISO 3166 code
+func (s *Server) resolveAdminLocation(ctx context.Context, req RecommendRequest) (LocationInfo, error) {
+	code, err := normalizeAdminCode(req.StateCode)
+	if err != nil {
+		return LocationInfo{}, err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT tdwg_code, share FROM admin_to_tdwg
+		WHERE iso_code = $1
+		ORDER BY share DESC, tdwg_code
+	`, code)
+	if err != nil {
+		return LocationInfo{}, err
+	}
+	defer rows.Close()
+
+	var shares []AdminRegion
+	for rows.Next() {
+		var rg AdminRegion
+		if err := rows.Scan(&rg.TDWGCode, &rg.Share); err != nil {
+			return LocationInfo{}, err
+		}
+		shares = append(shares, rg)
+	}
+	if err := rows.Err(); err != nil {
+		return LocationInfo{}, err
+	}
+	regions := adminWeights(shares)
+	if len(regions) == 0 {
+		return LocationInfo{}, fmt.Errorf("invalid state code: %s", req.StateCode)
+	}
+
+	if len(regions) == 1 {
+		req.TDWGCode, req.StateCode = regions[0].TDWGCode, ""
+		location, err := s.resolveLocationClimate(ctx, req)
+		location.AdminCode = code
+		return location, err
+	}
+
+	location := LocationInfo{TDWGCode: regions[0].TDWGCode, AdminCode: code}
+	codes, _ := borderCodes(regions)
+	location.Level3Codes = codes
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(level3_name, level3_code) FROM tdwg_level3 WHERE level3_code = $1
+	`, location.TDWGCode).Scan(&location.TDWGName); err != nil {
+		return location, fmt.Errorf("invalid TDWG code: %s", location.TDWGCode)
+	}
+	return location, s.setBlendedClimate(ctx, &location, regions)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleAdminUnits handles GET /api/admin-units
+func (s *Server) handleAdminUnits(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	p, err := parseListParams(r, adminUnitList)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	var filter string
+	var args []interface{}
+	if country := r.URL.Query().Get("country"); country != "" {
+		args = append(args, strings.ToUpper(country))
+		filter += fmt.Sprintf(" AND a.country_code = $%d", len(args))
+	}
+	if v := r.URL.Query().Get("level"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil || (level != 0 && level != 1) {
+			http.Error(w, `{"error": "level must be 0 (countries) or 1 (subdivisions)"}`, http.StatusBadRequest)
+			return
+		}
+		args = append(args, level)
+		filter += fmt.Sprintf(" AND a.level = $%d", len(args))
+	}
+
+	where, tail, args := p.SQL(args)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT a.iso_code, a.name, a.country_code, a.level,
+		       ARRAY(SELECT x.tdwg_code FROM admin_to_tdwg x WHERE x.iso_code = a.iso_code ORDER BY x.share DESC, x.tdwg_code),
+		       ARRAY(SELECT x.share FROM admin_to_tdwg x WHERE x.iso_code = a.iso_code ORDER BY x.share DESC, x.tdwg_code),
+		       a.id, `+p.CursorColumn()+`
+		FROM admin_units a
+		WHERE TRUE`+filter+where+`
+		`+tail, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := AdminUnitListResponse{AdminUnits: []AdminUnit{}}
+	var keys []listKey
+	for rows.Next() {
+		var u AdminUnit
+		var codes []string
+		var shares []float64
+		var id int64
+		var cursorValue string
+		if err := rows.Scan(&u.ISOCode, &u.Name, &u.CountryCode, &u.Level,
+			pq.Array(&codes), pq.Array(&shares), &id, &cursorValue); err != nil {
+			s.log.Printf("Error scanning admin unit row: %v", err)
+			continue
+		}
+		u.Regions = []AdminRegion{}
+		for i := range codes {
+			u.Regions = append(u.Regions, AdminRegion{TDWGCode: codes[i], Share: shares[i]})
+		}
+		resp.AdminUnits = append(resp.AdminUnits, u)
+		keys = append(keys, listKey{cursorValue, id})
+	}
+	n, next := p.trim(keys)
+	resp.AdminUnits, resp.NextCursor = resp.AdminUnits[:n], next
+
+	writeCompressedJSON(w, r, resp)
+}
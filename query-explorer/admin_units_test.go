@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeAdminCode(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"BR", "BR", true},
+		{" br-sc ", "BR-SC", true},
+		{"AR-X", "AR-X", true},
+		{"GB-ENG", "GB-ENG", true},
+		{"BRA", "", false},
+		{"BR-", "", false},
+		{"BR-SC-1", "", false},
+		{"", "", false},
+	}
+	for _, tc := range tests {
+		got, err := normalizeAdminCode(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("normalizeAdminCode(%q) = %q, %v; want %q ok=%v", tc.in, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestAdminWeights(t *testing.T) {
+	// A border sliver under minAdminShare is dropped and the rest reweighted
+	got := adminWeights([]AdminRegion{{"BZE", 0.6}, {"BZN", 0.38}, {"BZC", 0.02}})
+	if len(got) != 2 || got[0].TDWGCode != "BZE" || math.Abs(got[0].Weight-0.6/0.98) > 1e-9 || math.Abs(got[1].Weight-0.38/0.98) > 1e-9 {
+		t.Errorf("weights: %+v", got)
+	}
+
+	if got := adminWeights([]AdminRegion{{"BZS", 1}}); len(got) != 1 || got[0].Weight != 1 {
+		t.Errorf("single region: %+v", got)
+	}
+	if got := adminWeights(nil); len(got) != 0 {
+		t.Errorf("no regions: %+v", got)
+	}
+}
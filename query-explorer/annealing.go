@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ============================================================================
+// SIMULATED ANNEALING SELECTION
+// ============================================================================
+//
+// The greedy loop adds, each round, the candidate farthest from its nearest
+// selected species. That is fast but myopic: for large n_species the set it
+// ends with has a noticeably lower mean pairwise Gower distance (functional
+// diversity) than the best one. algorithm: "annealing" starts from the
+// greedy set and improves it by swaps, maximizing
+//
+//	diversity_weight × mean pairwise distance
+//	  + climate_weight × mean climate match + mean plugin adjustment
+//
+// A swap that worsens the objective is still accepted with probability
+// exp(Δ/T), the temperature T cooling geometrically over a fixed number of
+// iterations; the best set seen is kept. The search also stops after
+// time_budget_ms (default 200, max 5000), except with deterministic: true,
+// where only the iteration count bounds it so the same seed always gives
+// the same set. Each iteration costs O(n_species) distances, so the count is
+// also clamped to annealingMaxWork / n_species, which keeps a deterministic
+// search for a large set within a few seconds; a request whose context ends
+// mid-search (client gone, server timeout) stops it with the context error.
+// With growth_form_quotas, swaps only exchange species of the
+// same growth form, keeping the greedy composition. The final set is ranked
+// by the greedy order among its members, so selection_rank and
+// diversity_contribution mean the same as for greedy.
+
+const (
+	defaultTimeBudgetMs      = 200
+	maxTimeBudgetMs          = 5000
+	annealingItersPerSpecies = 5000
+	annealingMaxIterations   = 200000
+	annealingMaxWork         = 20000000 // Iterations × n_species
+	annealingFinalTempRatio  = 1e-3     // Final temperature / initial temperature
+	annealingSampleMoves     = 100      // Moves sampled to set the initial temperature
+)
+
+var algorithms = map[string]bool{"greedy": true, "annealing": true}
+
+// AnnealingStats reports how the annealing search went
+type AnnealingStats struct {
+	Iterations       int     `json:"iterations"`
+	Accepted         int     `json:"accepted"`
+	InitialObjective float64 `json:"initial_objective"` // Of the greedy set
+	FinalObjective   float64 `json:"final_objective"`   // Of the returned set
+	StoppedBy        string  `json:"stopped_by"`        // iterations, time_budget
+}
+
+// annealingOptions tunes annealingSelection
+type annealingOptions struct {
+	Seed       int64
+	TimeBudget time.Duration // 0: iterations only
+}
+
+// parseAlgorithm validates algorithm and time_budget_ms and fills in
+// defaults. annealing without any seed gets random_seed (see determinism.go)
+// so the response can report it.
+func parseAlgorithm(req *RecommendRequest) error {
+	if req.Algorithm == "" {
+		req.Algorithm = "greedy"
+	}
+	if !algorithms[req.Algorithm] {
+		return fmt.Errorf("invalid algorithm: %s (use greedy or annealing)", req.Algorithm)
+	}
+	if req.Algorithm != "annealing" {
+		req.TimeBudgetMs = 0
+		return nil
+	}
+	if req.TimeBudgetMs < 0 || req.TimeBudgetMs > maxTimeBudgetMs {
+		return fmt.Errorf("time_budget_ms must be between 0 and %d", maxTimeBudgetMs)
+	}
+	if req.TimeBudgetMs == 0 {
+		req.TimeBudgetMs = defaultTimeBudgetMs
+	}
+	if req.RandomSeed == nil {
+		seed := req.seed()
+		req.RandomSeed, req.randomSeedFilled = &seed, true
+	}
+	return nil
+}
+
+// annealingConfig returns the annealing options of a normalized request
+func (r *RecommendRequest) annealingConfig() annealingOptions {
+	opts := annealingOptions{}
+	if r.RandomSeed != nil {
+		opts.Seed = *r.RandomSeed
+	}
+	if !r.Deterministic {
+		opts.TimeBudget = time.Duration(r.TimeBudgetMs) * time.Millisecond
+	}
+	return opts
+}
+
+// annealingIterations is the iteration count of a search for n species
+func annealingIterations(n int) int {
+	return max(min(annealingItersPerSpecies*n, annealingMaxIterations, annealingMaxWork/max(n, 1)), 1)
+}
+
+// annealingSelection picks nSpecies candidates by simulated annealing from
+// the greedy selection. opts.OnSelect is only called for the final ranking.
+func annealingSelection(
+	ctx context.Context,
+	candidates []SpeciesRecommendation,
+	traits map[int64]TraitVector,
+	nSpecies int,
+	opts selectionOptions,
+	anneal annealingOptions,
+) ([]SpeciesRecommendation, *AnnealingStats, error) {
+	onSelect := opts.OnSelect
+	opts.OnSelect = nil
+	initial := greedyDiversitySelection(candidates, traits, nSpecies, opts)
+	n := len(initial)
+	if n < 2 || n >= len(candidates) {
+		opts.OnSelect = onSelect
+		return greedyDiversitySelection(initial, traits, n, opts), &AnnealingStats{StoppedBy: "iterations"}, nil
+	}
+
+	diversityWeight, climateWeight := opts.DiversityWeight, opts.ClimateWeight
+	if diversityWeight == 0 && climateWeight == 0 {
+		diversityWeight, climateWeight = defaultDiversityWeight, defaultClimateWeight
+	}
+	vec := make([]TraitVector, len(candidates))
+	gain := make([]float64, len(candidates)) // Climate and adjustment terms
+	indexByID := make(map[int64]int, len(candidates))
+	for i, c := range candidates {
+		vec[i] = traits[c.SpeciesID]
+		gain[i] = c.rankScore()*climateWeight + opts.Adjustments[c.SpeciesID]
+		indexByID[c.SpeciesID] = i
+	}
+
+	// sel holds candidate indexes, out the rest; selSum[j] is the distance
+	// sum from sel[j] to the other selected species
+	sel := make([]int, 0, n)
+	inSel := make([]bool, len(candidates))
+	for _, sp := range initial {
+		i := indexByID[sp.SpeciesID]
+		sel = append(sel, i)
+		inSel[i] = true
+	}
+	out := make([]int, 0, len(candidates)-n)
+	for i := range candidates {
+		if !inSel[i] {
+			out = append(out, i)
+		}
+	}
+	selSum := make([]float64, n)
+	pairSum, gainSum := 0.0, 0.0
+	for j, a := range sel {
+		gainSum += gain[a]
+		for k := j + 1; k < n; k++ {
+			d := gowerDistance(vec[a], vec[sel[k]])
+			selSum[j] += d
+			selSum[k] += d
+			pairSum += d
+		}
+	}
+	pairs := float64(n*(n-1)) / 2
+	objective := func(pairSum, gainSum float64) float64 {
+		return diversityWeight*pairSum/pairs + gainSum/float64(n)
+	}
+
+	rng := rand.New(rand.NewSource(anneal.Seed))
+	quotas := len(opts.GrowthFormQuotas) > 0
+
+	// propose draws a swap of sel[j] for out[k] and returns its objective change
+	propose := func() (j, k int, delta float64, ok bool) {
+		j, k = rng.Intn(n), rng.Intn(len(out))
+		a, b := sel[j], out[k]
+		if quotas && candidates[a].GrowthForm != candidates[b].GrowthForm {
+			return 0, 0, 0, false
+		}
+		distB := 0.0
+		for _, s := range sel {
+			distB += gowerDistance(vec[b], vec[s])
+		}
+		distB -= gowerDistance(vec[b], vec[a])
+		delta = diversityWeight*(distB-selSum[j])/pairs + (gain[b]-gain[a])/float64(n)
+		return j, k, delta, true
+	}
+
+	// Initial temperature: accept an average worsening move with
+	// probability 1/e at the start
+	temp, sampled := 0.0, 0
+	for s := 0; s < annealingSampleMoves; s++ {
+		if _, _, delta, ok := propose(); ok {
+			temp += math.Abs(delta)
+			sampled++
+		}
+	}
+	if sampled == 0 || temp == 0 {
+		opts.OnSelect = onSelect
+		obj := objective(pairSum, gainSum)
+		return greedyDiversitySelection(initial, traits, n, opts), &AnnealingStats{InitialObjective: obj, FinalObjective: obj, StoppedBy: "iterations"}, nil
+	}
+	temp /= float64(sampled)
+
+	iterations := annealingIterations(n)
+	cooling := math.Pow(annealingFinalTempRatio, 1/float64(iterations))
+	stats := &AnnealingStats{InitialObjective: objective(pairSum, gainSum), StoppedBy: "iterations"}
+	best := append([]int(nil), sel...)
+	bestObj := stats.InitialObjective
+	current := bestObj
+
+	var deadline time.Time
+	if anneal.TimeBudget > 0 {
+		deadline = time.Now().Add(anneal.TimeBudget)
+	}
+	for it := 0; it < iterations; it++ {
+		if it%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				stats.StoppedBy = "time_budget"
+				break
+			}
+		}
+		stats.Iterations++
+		temp *= cooling
+
+		j, k, delta, ok := propose()
+		if !ok || (delta < 0 && rng.Float64() >= math.Exp(delta/temp)) {
+			continue
+		}
+		a, b := sel[j], out[k]
+		for m, s := range sel {
+			if m == j {
+				continue
+			}
+			da, db := gowerDistance(vec[s], vec[a]), gowerDistance(vec[s], vec[b])
+			selSum[m] += db - da
+		}
+		selSum[j] = 0
+		for m, s := range sel {
+			if m != j {
+				selSum[j] += gowerDistance(vec[b], vec[s])
+			}
+		}
+		sel[j], out[k] = b, a
+		current += delta
+		stats.Accepted++
+		if current > bestObj {
+			bestObj = current
+			copy(best, sel)
+		}
+	}
+
+	final := make([]SpeciesRecommendation, n)
+	for j, i := range best {
+		final[j] = candidates[i]
+	}
+	// Recompute rather than trust the running sum
+	stats.FinalObjective = annealingObjective(final, traits, opts.Adjustments, diversityWeight, climateWeight)
+	opts.OnSelect = onSelect
+	return greedyDiversitySelection(final, traits, n, opts), stats, nil
+}
+
+// annealingObjective is the objective maximized by annealingSelection
+func annealingObjective(species []SpeciesRecommendation, traits map[int64]TraitVector, adjustments map[int64]float64, diversityWeight, climateWeight float64) float64 {
+	n := len(species)
+	if n < 2 {
+		return 0
+	}
+	pairSum, gainSum := 0.0, 0.0
+	for i, a := range species {
+		gainSum += a.rankScore()*climateWeight + adjustments[a.SpeciesID]
+		for _, b := range species[i+1:] {
+			pairSum += gowerDistance(traits[a.SpeciesID], traits[b.SpeciesID])
+		}
+	}
+	return diversityWeight*pairSum/(float64(n*(n-1))/2) + gainSum/float64(n)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomPool builds n candidates with random traits from a fixed seed
+func randomPool(n int) ([]SpeciesRecommendation, map[int64]TraitVector) {
+	rng := rand.New(rand.NewSource(1))
+	forms := []string{"tree", "shrub", "forb"}
+	candidates := make([]SpeciesRecommendation, n)
+	traits := make(map[int64]TraitVector, n)
+	for i := range candidates {
+		id := int64(i + 1)
+		form := forms[rng.Intn(len(forms))]
+		candidates[i] = SpeciesRecommendation{SpeciesID: id, GrowthForm: form, ClimateMatchScore: 0.6 + 0.4*rng.Float64()}
+		traits[id] = TraitVector{
+			IsTree:          form == "tree",
+			IsShrub:         form == "shrub",
+			IsHerb:          form == "forb",
+			HeightNorm:      rng.Float64(),
+			LifespanNorm:    rng.Float64(),
+			IsNitrogenFixer: rng.Intn(4) == 0,
+			DispersalAnimal: rng.Intn(2) == 0,
+			DispersalWind:   rng.Intn(3) == 0,
+			FamilyCode:      rng.Intn(8),
+		}
+	}
+	return candidates, traits
+}
+
+func TestAnnealingImprovesOnGreedy(t *testing.T) {
+	candidates, traits := randomPool(200)
+	opts := selectionOptions{DiversityWeight: 1, ClimateWeight: 0}
+
+	greedy := greedyDiversitySelection(candidates, traits, 30, opts)
+	selected, stats, _ := annealingSelection(context.Background(), candidates, traits, 30, opts, annealingOptions{Seed: 7})
+	if len(selected) != 30 {
+		t.Fatalf("selected %d species, want 30", len(selected))
+	}
+	greedyObj := annealingObjective(greedy, traits, nil, 1, 0)
+	if stats.InitialObjective != greedyObj {
+		t.Errorf("initial_objective = %v, want the greedy set's %v", stats.InitialObjective, greedyObj)
+	}
+	if stats.FinalObjective <= greedyObj {
+		t.Errorf("annealing objective %v does not improve on greedy %v", stats.FinalObjective, greedyObj)
+	}
+	if stats.StoppedBy != "iterations" || stats.Iterations == 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	for i, sp := range selected {
+		if sp.SelectionRank != i+1 {
+			t.Errorf("selected[%d].SelectionRank = %d", i, sp.SelectionRank)
+		}
+	}
+
+	again, _, _ := annealingSelection(context.Background(), candidates, traits, 30, opts, annealingOptions{Seed: 7})
+	if !equalIDs(selectedIDs(selected), selectedIDs(again)) {
+		t.Error("the same seed gave different selections")
+	}
+}
+
+func TestAnnealingKeepsQuotaComposition(t *testing.T) {
+	candidates, traits := randomPool(100)
+	opts := selectionOptions{GrowthFormQuotas: map[string]float64{"tree": 0.5, "shrub": 0.3, "forb": 0.2}}
+
+	selected, _, _ := annealingSelection(context.Background(), candidates, traits, 10, opts, annealingOptions{Seed: 3})
+	if got, want := growthFormCounts(selected), map[string]int{"tree": 5, "shrub": 3, "forb": 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("composition = %v, want %v", got, want)
+	}
+}
+
+func TestAnnealingIterations(t *testing.T) {
+	for _, n := range []int{2, 30, 200, 1000, 100000} {
+		if got := annealingIterations(n); got < 1 || got > annealingMaxIterations || got*n > annealingMaxWork {
+			t.Errorf("annealingIterations(%d) = %d", n, got)
+		}
+	}
+	if got := annealingIterations(10); got != annealingItersPerSpecies*10 {
+		t.Errorf("annealingIterations(10) = %d, want %d", got, annealingItersPerSpecies*10)
+	}
+}
+
+func TestAnnealingStopsOnContext(t *testing.T) {
+	candidates, traits := randomPool(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := annealingSelection(ctx, candidates, traits, 10, selectionOptions{}, annealingOptions{Seed: 3}); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	req := RecommendRequest{TimeBudgetMs: 100}
+	if err := parseAlgorithm(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Algorithm != "greedy" || req.TimeBudgetMs != 0 || req.RandomSeed != nil {
+		t.Errorf("greedy defaults: %+v", req)
+	}
+
+	req = RecommendRequest{Algorithm: "annealing"}
+	if err := parseAlgorithm(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.TimeBudgetMs != defaultTimeBudgetMs || req.RandomSeed == nil {
+		t.Errorf("annealing defaults: %+v", req)
+	}
+
+	for _, bad := range []RecommendRequest{
+		{Algorithm: "genetic"},
+		{Algorithm: "annealing", TimeBudgetMs: -1},
+		{Algorithm: "annealing", TimeBudgetMs: maxTimeBudgetMs + 1},
+	} {
+		if err := parseAlgorithm(&bad); err == nil {
+			t.Errorf("parseAlgorithm(%+v): expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// AREAS OF INTEREST
+// ============================================================================
+//
+// An area of interest (AOI) is a project boundary uploaded once as GeoJSON
+// and stored (migration 052), so the site is analysed as drawn instead of
+// as the TDWG region around it:
+//
+//	POST   /api/aoi                   {"name", "geometry"}: a Polygon,
+//	                                  MultiPolygon or Feature with one
+//	GET    /api/aoi                   the caller's areas
+//	GET    /api/aoi/{id}              the area as a GeoJSON Feature
+//	DELETE /api/aoi/{id}              (owner only)
+//	GET    /api/aoi/{id}/regions      intersecting TDWG regions, by overlap
+//	GET    /api/aoi/{id}/ecoregions   intersecting ecoregions, by overlap
+//	GET    /api/aoi/{id}/climate      area-weighted climate
+//	GET    /api/aoi/{id}/species      species, as /api/species/within
+//
+// Creating and listing need a user key; the id is random and unguessable,
+// so reading by id works like a share link and aoi_id is accepted as the
+// location of /api/recommend (and the endpoints built on it: batch sites,
+// sensitivity, sandbox, explain), /api/climate/match, /api/compliance/check,
+// /api/plans and /api/species/within. Such a location is the TDWG region
+// with the largest overlap, for nativeness, and the climate of the area
+// itself. The endpoints that take a region or a point instead (/api/species,
+// /api/ecoregion/species, /api/soil, /api/elevation, /api/climate/analogs
+// and the iNaturalist observations of /api/climate/species) take aoi_id as
+// that region and the area's point on surface (see resolveAOIQuery).
+//
+// The climate is the mean of the WorldClim cells inside the area; an area
+// smaller than a cell takes the cell at its point on surface, and where the
+// raster has no data the region means of tdwg_climate are weighted by
+// overlap area. Areas are immutable, so cached recommendations for an
+// aoi_id stay valid; deleting the area purges them, as they hold its
+// coordinates.
+
+const (
+	maxAOIBodyBytes = 1 << 20
+	maxAOINameLen   = 255
+	maxAOIsListed   = 500
+)
+
+// aoiBioVars are the climate variables of an area, in the order of
+// AOIClimate's fields
+var aoiBioVars = []string{"bio1", "bio5", "bio6", "bio12", "bio15"}
+
+type AreaOfInterest struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	AreaKm2   float64    `json:"area_km2"`
+	BBox      [4]float64 `json:"bbox"`      // min_lon, min_lat, max_lon, max_lat
+	Latitude  float64    `json:"latitude"`  // Point on surface
+	Longitude float64    `json:"longitude"` // Point on surface
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// AOIRegion is a TDWG region intersecting an area
+type AOIRegion struct {
+	Code       string  `json:"tdwg_code"`
+	Name       string  `json:"tdwg_name"`
+	OverlapKm2 float64 `json:"overlap_km2"`
+	Share      float64 `json:"share"` // Of the area
+
+	climate *[5]float64 // tdwg_climate means, when complete
+}
+
+// AOIEcoregion is an ecoregion intersecting an area
+type AOIEcoregion struct {
+	EcoID      int     `json:"eco_id"`
+	EcoName    string  `json:"eco_name"`
+	BiomeNum   int     `json:"biome_num"`
+	BiomeName  string  `json:"biome_name"`
+	Realm      string  `json:"realm"`
+	OverlapKm2 float64 `json:"overlap_km2"`
+	Share      float64 `json:"share"`
+}
+
+type AOIClimate struct {
+	Source string  `json:"source"`            // worldclim, worldclim_point or tdwg_weighted
+	NCells int64   `json:"n_cells,omitempty"` // WorldClim cells averaged (source=worldclim)
+	Bio1   float64 `json:"bio1"`
+	Bio5   float64 `json:"bio5"`
+	Bio6   float64 `json:"bio6"`
+	Bio12  float64 `json:"bio12"`
+	Bio15  float64 `json:"bio15"`
+}
+
+type AOIRegionsResponse struct {
+	AOIID     string      `json:"aoi_id"`
+	AreaKm2   float64     `json:"area_km2"`
+	Regions   []AOIRegion `json:"regions"`
+	QueryTime string      `json:"query_time"`
+}
+
+type AOIListResponse struct {
+	Areas []AreaOfInterest `json:"areas"`
+}
+
+// AOIFeature is an area of interest as a GeoJSON Feature
+type AOIFeature struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id"`
+	Properties AreaOfInterest  `json:"properties"`
+	Geometry   json.RawMessage `json:"geometry"`
+}
+
+type AOIEcoregionsResponse struct {
+	AOIID      string         `json:"aoi_id"`
+	AreaKm2    float64        `json:"area_km2"`
+	Ecoregions []AOIEcoregion `json:"ecoregions"`
+	QueryTime  string         `json:"query_time"`
+}
+
+type AOIClimateResponse struct {
+	AOIID string `json:"aoi_id"`
+	AOIClimate
+	QueryTime string `json:"query_time"`
+}
+
+// validAOIID reports whether id has the form of newQueryJobID's ids
+func validAOIID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return len(id) == 32 && err == nil
+}
+
+// aoiArea is the SQL of the geometry of the area whose id is param
+func aoiArea(param string) string {
+	return "(SELECT geom FROM areas_of_interest WHERE id = " + param + ")"
+}
+
+// setBio sets the climate fields from values in aoiBioVars order
+func (c *AOIClimate) setBio(v [5]float64) {
+	c.Bio1, c.Bio5, c.Bio6, c.Bio12, c.Bio15 = v[0], v[1], v[2], v[3], v[4]
+}
+
+// areaWeightedClimate averages the climate of regions weighted by their
+// overlap with the area, over the regions with climate data
+func areaWeightedClimate(regions []AOIRegion) ([5]float64, bool) {
+	var sum [5]float64
+	weight := 0.0
+	for _, rg := range regions {
+		if rg.climate == nil || rg.OverlapKm2 <= 0 {
+			continue
+		}
+		for i, v := range rg.climate {
+			sum[i] += v * rg.OverlapKm2
+		}
+		weight += rg.OverlapKm2
+	}
+	if weight == 0 {
+		return sum, false
+	}
+	for i := range sum {
+		sum[i] /= weight
+	}
+	return sum, true
+}
+
+const aoiColumns = `a.id, a.name, a.area_km2,
+	ST_XMin(a.geom), ST_YMin(a.geom), ST_XMax(a.geom), ST_YMax(a.geom),
+	ST_Y(ST_PointOnSurface(a.geom)), ST_X(ST_PointOnSurface(a.geom)), a.created_at`
+
+func scanAOI(row interface{ Scan(...interface{}) error }) (AreaOfInterest, error) {
+	var a AreaOfInterest
+	err := row.Scan(&a.ID, &a.Name, &a.AreaKm2, &a.BBox[0], &a.BBox[1], &a.BBox[2], &a.BBox[3],
+		&a.Latitude, &a.Longitude, &a.CreatedAt)
+	return a, err
+}
+
+// getAOI loads an area; sql.ErrNoRows if there is none with id
+func (s *Server) getAOI(ctx context.Context, id string) (AreaOfInterest, error) {
+	if !validAOIID(id) {
+		return AreaOfInterest{}, sql.ErrNoRows
+	}
+	return scanAOI(s.db.QueryRowContext(ctx, `SELECT `+aoiColumns+` FROM areas_of_interest a WHERE a.id = $1`, id))
+}
+
+// createAOI stores geometry, GeoJSON from parseWithinGeometry, made valid
+func (s *Server) createAOI(ctx context.Context, key *APIKey, name string, geometry []byte) (AreaOfInterest, error) {
+	id, err := newQueryJobID()
+	if err != nil {
+		return AreaOfInterest{}, err
+	}
+	return scanAOI(s.db.QueryRowContext(ctx, `
+		WITH g AS (
+			SELECT ST_Multi(ST_CollectionExtract(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON($4), 4326)), 3)) AS geom
+		)
+		INSERT INTO areas_of_interest (id, owner_key_id, name, geom, area_km2)
+		SELECT $1, $2, $3, g.geom, ST_Area(g.geom::geography) / 1e6
+		FROM g
+		WHERE NOT ST_IsEmpty(g.geom)
+		RETURNING `+aoiColumns, id, key.ID, name, string(geometry)))
+}
+
+// deleteAOI deletes the caller's area with id, and the cached
+// recommendations made for it; it returns the areas deleted (0 or 1)
+func (s *Server) deleteAOI(ctx context.Context, key *APIKey, id string) (int64, error) {
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM areas_of_interest WHERE id = $1 AND owner_key_id = $2`, id, key.ID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM recommendation_cache WHERE response->'location_info'->>'aoi_id' = $1
+	`, id); err != nil {
+		return 0, err
+	}
+	return 1, tx.Commit()
+}
+
+// aoiRegions returns the TDWG regions intersecting a, largest overlap first
+func (s *Server) aoiRegions(ctx context.Context, a AreaOfInterest) ([]AOIRegion, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH a AS (SELECT geom FROM areas_of_interest WHERE id = $1)
+		SELECT t.level3_code, COALESCE(t.level3_name, t.level3_code),
+		       ST_Area(ST_Intersection(t.geom, a.geom)::geography) / 1e6 AS overlap,
+		       c.bio1_mean, c.bio5_mean, c.bio6_mean, c.bio12_mean, c.bio15_mean
+		FROM tdwg_level3 t
+		CROSS JOIN a
+		LEFT JOIN tdwg_climate c ON c.tdwg_code = t.level3_code
+		WHERE t.geom && a.geom AND ST_Intersects(t.geom, a.geom)
+		ORDER BY overlap DESC, t.level3_code
+	`, a.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	regions := []AOIRegion{}
+	for rows.Next() {
+		var rg AOIRegion
+		var bio [5]sql.NullFloat64
+		if err := rows.Scan(&rg.Code, &rg.Name, &rg.OverlapKm2, &bio[0], &bio[1], &bio[2], &bio[3], &bio[4]); err != nil {
+			return nil, err
+		}
+		if a.AreaKm2 > 0 {
+			rg.Share = rg.OverlapKm2 / a.AreaKm2
+		}
+		if bio[0].Valid && bio[1].Valid && bio[2].Valid && bio[3].Valid && bio[4].Valid {
+			rg.climate = &[5]float64{bio[0].Float64, bio[1].Float64, bio[2].Float64, bio[3].Float64, bio[4].Float64}
+		}
+		regions = append(regions, rg)
+	}
+	return regions, rows.Err()
+}
+
+// aoiEcoregions returns the ecoregions intersecting a, largest overlap first
+func (s *Server) aoiEcoregions(ctx context.Context, a AreaOfInterest) ([]AOIEcoregion, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH a AS (SELECT geom FROM areas_of_interest WHERE id = $1)
+		SELECT e.eco_id, COALESCE(e.eco_name, ''), COALESCE(e.biome_num, 0), COALESCE(e.biome_name, ''),
+		       COALESCE(e.realm, ''), ST_Area(ST_Intersection(e.geom, a.geom)::geography) / 1e6 AS overlap
+		FROM ecoregions e
+		CROSS JOIN a
+		WHERE e.geom && a.geom AND ST_Intersects(e.geom, a.geom)
+		ORDER BY overlap DESC, e.eco_id
+	`, a.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ecoregions := []AOIEcoregion{}
+	for rows.Next() {
+		var e AOIEcoregion
+		if err := rows.Scan(&e.EcoID, &e.EcoName, &e.BiomeNum, &e.BiomeName, &e.Realm, &e.OverlapKm2); err != nil {
+			return nil, err
+		}
+		if a.AreaKm2 > 0 {
+			e.Share = e.OverlapKm2 / a.AreaKm2
+		}
+		ecoregions = append(ecoregions, e)
+	}
+	return ecoregions, rows.Err()
+}
+
+// aoiClimate computes the climate of a, given its regions
+func (s *Server) aoiClimate(ctx context.Context, a AreaOfInterest, regions []AOIRegion) (AOIClimate, error) {
+	// Mean of the cells inside the area, pooled over raster tiles
+	rows, err := s.db.QueryContext(ctx, `
+		WITH a AS (SELECT geom FROM areas_of_interest WHERE id = $1),
+		tiles AS (
+			SELECT wr.bio_var, ST_SummaryStats(ST_Clip(wr.rast, 1, a.geom, true), 1, true) AS st
+			FROM worldclim_raster wr, a
+			WHERE wr.bio_var IN ('bio1', 'bio5', 'bio6', 'bio12', 'bio15') AND ST_Intersects(wr.rast, a.geom)
+		)
+		SELECT bio_var, SUM((st).mean * (st).count) / SUM((st).count), SUM((st).count)
+		FROM tiles
+		WHERE (st).count > 0
+		GROUP BY bio_var
+	`, a.ID)
+	if err != nil {
+		return AOIClimate{}, err
+	}
+	means := map[string]float64{}
+	var cells int64
+	for rows.Next() {
+		var v string
+		var mean float64
+		var n int64
+		if err := rows.Scan(&v, &mean, &n); err != nil {
+			rows.Close()
+			return AOIClimate{}, err
+		}
+		means[v], cells = mean, max(cells, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return AOIClimate{}, err
+	}
+
+	var climate AOIClimate
+	var bio [5]float64
+	complete := len(means) == len(aoiBioVars)
+	for i, v := range aoiBioVars {
+		bio[i] = means[v]
+	}
+	if complete {
+		climate.Source, climate.NCells = "worldclim", cells
+		climate.setBio(bio)
+		return climate, nil
+	}
+
+	// Smaller than a cell: the cell at the point on surface
+	var point [5]sql.NullFloat64
+	err = s.db.QueryRowContext(ctx, `
+		SELECT
+			MAX(CASE WHEN bio_var = 'bio1' THEN value END),
+			MAX(CASE WHEN bio_var = 'bio5' THEN value END),
+			MAX(CASE WHEN bio_var = 'bio6' THEN value END),
+			MAX(CASE WHEN bio_var = 'bio12' THEN value END),
+			MAX(CASE WHEN bio_var = 'bio15' THEN value END)
+		FROM get_climate_at_point($1, $2)
+	`, a.Latitude, a.Longitude).Scan(&point[0], &point[1], &point[2], &point[3], &point[4])
+	if err == nil && point[0].Valid && point[1].Valid && point[2].Valid && point[3].Valid && point[4].Valid {
+		climate.Source = "worldclim_point"
+		climate.setBio([5]float64{point[0].Float64, point[1].Float64, point[2].Float64, point[3].Float64, point[4].Float64})
+		return climate, nil
+	}
+
+	weighted, ok := areaWeightedClimate(regions)
+	if !ok {
+		return climate, fmt.Errorf("no climate data for area of interest %s", a.ID)
+	}
+	climate.Source = "tdwg_weighted"
+	climate.setBio(weighted)
+	return climate, nil
+}
+
+// aoiSite loads the area with id and its TDWG regions, largest overlap first
+func (s *Server) aoiSite(ctx context.Context, id string) (AreaOfInterest, []AOIRegion, error) {
+	a, err := s.getAOI(ctx, id)
+	if err == sql.ErrNoRows {
+		return a, nil, fmt.Errorf("unknown aoi_id: %s", id)
+	}
+	if err != nil {
+		return a, nil, fmt.Errorf("failed to load area of interest: %w", err)
+	}
+	regions, err := s.aoiRegions(ctx, a)
+	if err != nil {
+		return a, nil, fmt.Errorf("failed to resolve area of interest to TDWG: %w", err)
+	}
+	if len(regions) == 0 {
+		return a, nil, fmt.Errorf("area of interest %s is outside every TDWG region", id)
+	}
+	return a, regions, nil
+}
+
+// resolveAOILocation is resolveLocationClimate for an aoi_id
+func (s *Server) resolveAOILocation(ctx context.Context, id string) (LocationInfo, error) {
+	location := LocationInfo{AOIID: id}
+	a, regions, err := s.aoiSite(ctx, id)
+	if err != nil {
+		return location, err
+	}
+	climate, err := s.aoiClimate(ctx, a, regions)
+	if err != nil {
+		return location, fmt.Errorf("failed to get climate data: %w", err)
+	}
+
+	location.TDWGCode, location.TDWGName = regions[0].Code, regions[0].Name
+	location.Latitude, location.Longitude = &a.Latitude, &a.Longitude
+	location.Bio1, location.Bio5, location.Bio6 = climate.Bio1, climate.Bio5, climate.Bio6
+	location.Bio12, location.Bio15 = climate.Bio12, climate.Bio15
+	return location, nil
+}
+
+// resolveAOIQuery makes ?aoi_id= the location of an endpoint that reads
+// tdwg_code or lat and lon from the query: they are set to the region with
+// the largest overlap and the area's point on surface. aoi_id together with
+// either is an error.
+func (s *Server) resolveAOIQuery(r *http.Request) error {
+	q := r.URL.Query()
+	id := q.Get("aoi_id")
+	if id == "" {
+		return nil
+	}
+	if q.Get("tdwg_code") != "" || q.Get("lat") != "" || q.Get("lon") != "" {
+		return fmt.Errorf("provide aoi_id or tdwg_code/lat/lon, not both")
+	}
+	a, regions, err := s.aoiSite(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	q.Set("tdwg_code", regions[0].Code)
+	q.Set("lat", strconv.FormatFloat(a.Latitude, 'f', -1, 64))
+	q.Set("lon", strconv.FormatFloat(a.Longitude, 'f', -1, 64))
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleAOIs handles GET/POST /api/aoi
+func (s *Server) handleAOIs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT `+aoiColumns+`
+			FROM areas_of_interest a
+			WHERE a.owner_key_id = $1
+			ORDER BY a.created_at DESC
+			LIMIT `+strconv.Itoa(maxAOIsListed), key.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		areas := []AreaOfInterest{}
+		for rows.Next() {
+			a, err := scanAOI(rows)
+			if err != nil {
+				s.log.Printf("Error scanning area of interest row: %v", err)
+				continue
+			}
+			areas = append(areas, a)
+		}
+		json.NewEncoder(w).Encode(AOIListResponse{Areas: areas})
+		return
+	}
+
+	var req struct {
+		Name     string          `json:"name"`
+		Geometry json.RawMessage `json:"geometry"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAOIBodyBytes)
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAOINameLen {
+		http.Error(w, fmt.Sprintf(`{"error": "name required, at most %d characters"}`, maxAOINameLen), http.StatusBadRequest)
+		return
+	}
+	if len(req.Geometry) == 0 {
+		http.Error(w, `{"error": "geometry required"}`, http.StatusBadRequest)
+		return
+	}
+	geometry, err := parseWithinGeometry(req.Geometry)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	a, err := s.createAOI(ctx, key, req.Name, geometry)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "geometry has no area"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.log.Printf("Error creating area of interest: %v", err)
+		http.Error(w, `{"error": "Failed to create area of interest"}`, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Location", "/api/aoi/"+a.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// aoiView returns the handler of /api/aoi/{id} (view "") or of its
+// /regions, /ecoregions, /climate and /species views
+func aoiView(view string) func(*Server, http.ResponseWriter, *http.Request) {
+	return func(s *Server, w http.ResponseWriter, r *http.Request) {
+		s.handleAOI(w, r, view)
+	}
+}
+
+// handleAOI answers a view of the area of interest of the route
+func (s *Server) handleAOI(w http.ResponseWriter, r *http.Request, view string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	id := pathParam(r, "id")
+	if !validAOIID(id) {
+		http.Error(w, `{"error": "Area of interest not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		key, ok := s.requireRole(w, r, roleUser)
+		if !ok {
+			return
+		}
+		n, err := s.deleteAOI(ctx, key, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if n == 0 {
+			http.Error(w, `{"error": "Area of interest not found"}`, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	start := time.Now()
+	a, err := s.getAOI(ctx, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Area of interest not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if view == "species" {
+		s.writeSpeciesWithin(w, r, aoiArea("$1"), []interface{}{a.ID})
+		return
+	}
+	lang := requestLanguage(r)
+
+	switch view {
+	case "":
+		var geometry json.RawMessage
+		if err := s.db.QueryRowContext(ctx, `SELECT ST_AsGeoJSON(geom, 6) FROM areas_of_interest WHERE id = $1`, id).Scan(&geometry); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		writeCompressedJSON(w, r, AOIFeature{Type: "Feature", ID: a.ID, Properties: a, Geometry: geometry})
+
+	case "regions":
+		regions, err := s.aoiRegions(ctx, a)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		for i := range regions {
+			regions[i].Name = s.localize(ctx, nameKindTDWG, regions[i].Code, lang, regions[i].Name)
+		}
+		setContentLanguage(w, lang)
+		json.NewEncoder(w).Encode(AOIRegionsResponse{AOIID: a.ID, AreaKm2: a.AreaKm2, Regions: regions, QueryTime: time.Since(start).String()})
+
+	case "ecoregions":
+		ecoregions, err := s.aoiEcoregions(ctx, a)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		for i := range ecoregions {
+			e := &ecoregions[i]
+			e.EcoName = s.localize(ctx, nameKindEcoregion, strconv.Itoa(e.EcoID), lang, e.EcoName)
+			e.BiomeName = s.localize(ctx, nameKindBiome, strconv.Itoa(e.BiomeNum), lang, e.BiomeName)
+		}
+		setContentLanguage(w, lang)
+		json.NewEncoder(w).Encode(AOIEcoregionsResponse{AOIID: a.ID, AreaKm2: a.AreaKm2, Ecoregions: ecoregions, QueryTime: time.Since(start).String()})
+
+	case "climate":
+		regions, err := s.aoiRegions(ctx, a)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		climate, err := s.aoiClimate(ctx, a, regions)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(AOIClimateResponse{AOIID: a.ID, AOIClimate: climate, QueryTime: time.Since(start).String()})
+	}
+}
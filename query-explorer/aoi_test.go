@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidAOIID(t *testing.T) {
+	id, err := newQueryJobID()
+	if err != nil || !validAOIID(id) {
+		t.Fatalf("newQueryJobID %q (%v) is not a valid AOI id", id, err)
+	}
+	for _, id := range []string{"", "abc", strings.Repeat("g", 32), strings.Repeat("a", 33), "'; DROP TABLE x; --"} {
+		if validAOIID(id) {
+			t.Errorf("%q accepted", id)
+		}
+	}
+}
+
+func TestAreaWeightedClimate(t *testing.T) {
+	regions := []AOIRegion{
+		{Code: "BZL", OverlapKm2: 30, climate: &[5]float64{20, 30, 10, 1200, 50}},
+		{Code: "BZS", OverlapKm2: 10, climate: &[5]float64{16, 26, 6, 1600, 30}},
+		{Code: "BZC", OverlapKm2: 50}, // No climate data
+		{Code: "BZE", OverlapKm2: 0, climate: &[5]float64{99, 99, 99, 99, 99}},
+	}
+	got, ok := areaWeightedClimate(regions)
+	if !ok {
+		t.Fatal("expected a climate")
+	}
+	want := [5]float64{19, 29, 9, 1300, 45}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Errorf("%s = %v, want %v", aoiBioVars[i], got[i], want[i])
+		}
+	}
+
+	if _, ok := areaWeightedClimate(regions[2:3]); ok {
+		t.Error("regions without climate data gave a climate")
+	}
+	if _, ok := areaWeightedClimate(nil); ok {
+		t.Error("no regions gave a climate")
+	}
+}
+
+func TestAOIValidation(t *testing.T) {
+	s := newTestServer()
+	routed := s.router().ServeHTTP
+	id := strings.Repeat("ab", 16)
+	for _, tc := range []struct {
+		method, path string
+		handler      http.HandlerFunc
+		want         int
+	}{
+		{http.MethodGet, "/api/aoi", s.handleAOIs, http.StatusUnauthorized},
+		{http.MethodPost, "/api/aoi", s.handleAOIs, http.StatusUnauthorized},
+		{http.MethodPut, "/api/aoi", s.handleAOIs, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/aoi/not-an-id", routed, http.StatusNotFound},
+		{http.MethodGet, "/api/aoi/" + id + "/soil", routed, http.StatusNotFound},
+		{http.MethodGet, "/api/aoi/" + id + "/regions/x", routed, http.StatusNotFound},
+		{http.MethodDelete, "/api/aoi/" + id, routed, http.StatusUnauthorized},
+		{http.MethodPost, "/api/aoi/" + id + "/climate", routed, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/species/within?aoi_id=nope", s.handleSpeciesWithin, http.StatusNotFound},
+
+		// Endpoints taking a region or a point (resolveAOIQuery)
+		{http.MethodGet, "/api/species?aoi_id=nope", s.handleSpecies, http.StatusBadRequest},
+		{http.MethodGet, "/api/ecoregion/species?aoi_id=nope", s.handleEcoregionSpecies, http.StatusBadRequest},
+		{http.MethodGet, "/api/soil?aoi_id=" + id + "&lat=1&lon=1", s.handleSoil, http.StatusBadRequest},
+		{http.MethodGet, "/api/elevation?aoi_id=nope", s.handleElevation, http.StatusBadRequest},
+		{http.MethodGet, "/api/climate/analogs?aoi_id=" + id + "&tdwg_code=BZS&scenario=ssp245_2050", s.handleClimateAnalogs, http.StatusBadRequest},
+		{http.MethodGet, "/api/climate/species?id=1&aoi_id=nope", s.handleClimateSpecies, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
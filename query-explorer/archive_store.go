@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ARCHIVE STORAGE
+// ============================================================================
+//
+// Where retention.go writes rows before deleting them. ARCHIVE_URL selects
+// the backend:
+//
+//	s3://bucket/prefix   any S3-compatible service (AWS, MinIO, R2, ...),
+//	                     path-style requests signed with SigV4; endpoint from
+//	                     ARCHIVE_S3_ENDPOINT (default AWS in ARCHIVE_S3_REGION),
+//	                     credentials from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY
+//	file:///var/archive  a local directory, e.g. a mounted bucket
+//
+// Objects are written once and never modified.
+
+const archiveRequestTimeout = 5 * time.Minute
+
+// archiveStore keeps archive objects by key
+type archiveStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	String() string
+}
+
+// newArchiveStore opens the store named by rawURL; "" means no archive
+func newArchiveStore(rawURL string) (archiveStore, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("file archive needs a path")
+		}
+		return dirArchive{root: u.Path}, nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("s3 archive needs a bucket")
+		}
+		region := getEnv("ARCHIVE_S3_REGION", "us-east-1")
+		a := &s3Archive{
+			endpoint:  strings.TrimSuffix(getEnv("ARCHIVE_S3_ENDPOINT", "https://s3."+region+".amazonaws.com"), "/"),
+			bucket:    u.Host,
+			prefix:    strings.Trim(u.Path, "/"),
+			region:    region,
+			accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			client:    &http.Client{Timeout: archiveRequestTimeout},
+		}
+		if a.accessKey == "" || a.secretKey == "" {
+			return nil, fmt.Errorf("s3 archive needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive scheme %q (use s3:// or file://)", u.Scheme)
+	}
+}
+
+// ============================================================================
+// LOCAL DIRECTORY
+// ============================================================================
+
+type dirArchive struct {
+	root string
+}
+
+func (a dirArchive) String() string { return "file://" + a.root }
+
+// path keeps keys inside the root
+func (a dirArchive) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("empty archive key")
+	}
+	return filepath.Join(a.root, clean), nil
+}
+
+// Put writes to a temporary file and renames it, so a crash never leaves a
+// truncated object
+func (a dirArchive) Put(ctx context.Context, key string, data []byte) error {
+	path, err := a.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (a dirArchive) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := a.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// ============================================================================
+// S3
+// ============================================================================
+
+type s3Archive struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func (a *s3Archive) String() string { return "s3://" + a.bucket + "/" + a.prefix }
+
+func (a *s3Archive) objectURL(key string) string {
+	if a.prefix != "" {
+		key = a.prefix + "/" + key
+	}
+	return a.endpoint + "/" + a.bucket + "/" + key
+}
+
+func (a *s3Archive) Put(ctx context.Context, key string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, a.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	a.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	_, err = a.do(req)
+	return err
+}
+
+func (a *s3Archive) Get(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(nil)
+	a.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	return a.do(req)
+}
+
+func (a *s3Archive) do(req *http.Request) ([]byte, error) {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// sign adds AWS Signature Version 4 headers, signing every header already
+// set on req
+func (a *s3Archive) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + a.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + a.secretKey)
+	for _, part := range []string{day, a.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		a.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, and
+// '/' too when encodeSlash is set
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(values url.Values) string {
+	var pairs []string
+	for name, vs := range values {
+		for _, v := range vs {
+			pairs = append(pairs, awsURIEncode(name, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Roles, in increasing order of privilege
+const (
+	roleUser    = "user"
+	roleCurator = "curator"
+	roleAdmin   = "admin"
+)
+
+var roleLevel = map[string]int{
+	roleUser:    1,
+	roleCurator: 2,
+	roleAdmin:   3,
+}
+
+var errNoAPIKey = errors.New("API key required")
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey is the authenticated caller of a request
+type APIKey struct {
+	ID       int64
+	TenantID sql.NullInt64
+	Owner    string
+	Role     string
+}
+
+// hasRole reports whether the key has at least the given role
+func (k *APIKey) hasRole(role string) bool {
+	return roleLevel[k.Role] >= roleLevel[role]
+}
+
+// hashAPIKey returns the hex SHA-256 of a raw key, as stored in api_keys
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// apiKeyFromRequest extracts the raw key from X-API-Key or a Bearer token
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
+// authenticate resolves the API key sent with the request
+func (s *Server) authenticate(r *http.Request) (*APIKey, error) {
+	ctx := r.Context()
+	raw := apiKeyFromRequest(r)
+	if raw == "" {
+		return nil, errNoAPIKey
+	}
+
+	var key APIKey
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE key_hash = $1 AND NOT revoked
+		RETURNING id, tenant_id, owner, role
+	`, hashAPIKey(raw)).Scan(&key.ID, &key.TenantID, &key.Owner, &key.Role)
+	if err == sql.ErrNoRows {
+		return nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// requireRole authenticates the request and checks the caller has at least
+// the given role. On failure it writes the error response and returns false.
+func (s *Server) requireRole(w http.ResponseWriter, r *http.Request, role string) (*APIKey, bool) {
+	key, err := s.authenticate(r)
+	if err == errNoAPIKey || err == errInvalidAPIKey {
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error": "Authentication failed"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !key.hasRole(role) {
+		http.Error(w, `{"error": "Insufficient permissions"}`, http.StatusForbidden)
+		return nil, false
+	}
+
+	return key, true
+}
+
+// requireTenant is requireRole for endpoints that operate on the caller's tenant
+func (s *Server) requireTenant(w http.ResponseWriter, r *http.Request, role string) (*APIKey, bool) {
+	key, ok := s.requireRole(w, r, role)
+	if !ok {
+		return nil, false
+	}
+	if !key.TenantID.Valid {
+		http.Error(w, `{"error": "API key is not associated with a tenant"}`, http.StatusForbidden)
+		return nil, false
+	}
+	return key, true
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// BATCH RECOMMENDATIONS
+// ============================================================================
+//
+// POST /api/recommend/batch runs one recommendation per site with shared
+// parameters, for planning many plots at once. Sites run concurrently on
+// batchWorkers goroutines (each holds database connections while it runs);
+// a failing site reports its error without failing the batch. The summary
+// lists the species recommended at more than one site, which is what a
+// nursery order for the whole project starts from.
+
+const (
+	maxBatchSites = 50
+	batchWorkers  = 4
+)
+
+// BatchSite is one location; the fields mean the same as in RecommendRequest
+type BatchSite struct {
+	ID         string   `json:"id,omitempty"` // Caller's label (default: position in sites)
+	TDWGCode   string   `json:"tdwg_code,omitempty"`
+	StateCode  string   `json:"state_code,omitempty"`
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+	AOIID      string   `json:"aoi_id,omitempty"`
+	ElevationM *float64 `json:"elevation_m,omitempty"`
+}
+
+// BatchRecommendRequest holds the shared parameters; their location fields
+// are ignored
+type BatchRecommendRequest struct {
+	RecommendRequest
+	Sites []BatchSite `json:"sites"`
+}
+
+type BatchSiteResult struct {
+	SiteID string             `json:"site_id"`
+	Result *RecommendResponse `json:"result,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+type SharedSpecies struct {
+	SpeciesID     int64    `json:"species_id"`
+	CanonicalName string   `json:"canonical_name"`
+	NSites        int      `json:"n_sites"`
+	SiteIDs       []string `json:"site_ids"`
+}
+
+type BatchRecommendResponse struct {
+	Sites         []BatchSiteResult `json:"sites"`
+	NSucceeded    int               `json:"n_succeeded"`
+	NFailed       int               `json:"n_failed"`
+	NDistinct     int               `json:"n_distinct_species"` // Over all successful sites
+	SharedSpecies []SharedSpecies   `json:"shared_species"`     // Recommended at 2+ sites, most shared first
+	QueryTime     string            `json:"query_time"`
+}
+
+// siteRequest returns the shared request placed at site
+func siteRequest(shared RecommendRequest, site BatchSite) RecommendRequest {
+	req := shared
+	req.TDWGCode, req.StateCode, req.AOIID = site.TDWGCode, site.StateCode, site.AOIID
+	req.Latitude, req.Longitude, req.ElevationM = site.Latitude, site.Longitude, site.ElevationM
+	return req
+}
+
+// runBatch runs sites 0..n-1 on a bounded pool of workers; results keep the
+// order of sites
+func runBatch(ctx context.Context, n int, run func(ctx context.Context, site int) (*RecommendResponse, error)) []BatchSiteResult {
+	results := make([]BatchSiteResult, n)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(batchWorkers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				resp, err := run(ctx, i)
+				if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				resp.QueryTime = time.Since(start).String()
+				results[i].Result = resp
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if ctx.Err() != nil {
+			results[i].Error = ctx.Err().Error()
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// sharedSpecies lists the species recommended at more than one site
+func sharedSpecies(results []BatchSiteResult) (shared []SharedSpecies, distinct int) {
+	byID := map[int64]*SharedSpecies{}
+	for _, r := range results {
+		if r.Result == nil {
+			continue
+		}
+		for _, sp := range r.Result.Species {
+			s, ok := byID[sp.SpeciesID]
+			if !ok {
+				s = &SharedSpecies{SpeciesID: sp.SpeciesID, CanonicalName: sp.CanonicalName}
+				byID[sp.SpeciesID] = s
+			}
+			s.NSites++
+			s.SiteIDs = append(s.SiteIDs, r.SiteID)
+		}
+	}
+
+	shared = []SharedSpecies{}
+	for _, s := range byID {
+		if s.NSites > 1 {
+			shared = append(shared, *s)
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].NSites != shared[j].NSites {
+			return shared[i].NSites > shared[j].NSites
+		}
+		return shared[i].SpeciesID < shared[j].SpeciesID
+	})
+	return shared, len(byID)
+}
+
+// handleRecommendBatch handles POST /api/recommend/batch
+func (s *Server) handleRecommendBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	display, ok := requestDisplay(w, r)
+	if !ok {
+		return
+	}
+	var body BatchRecommendRequest
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if len(body.Sites) == 0 || len(body.Sites) > maxBatchSites {
+		http.Error(w, fmt.Sprintf(`{"error": "sites must list 1 to %d locations"}`, maxBatchSites), http.StatusBadRequest)
+		return
+	}
+
+	// Validate every site up front, so a bad one is reported before any runs
+	reqs := make([]RecommendRequest, len(body.Sites))
+	plugins := make([]*pipelinePlugins, len(body.Sites))
+	ids := make([]string, len(body.Sites))
+	seen := map[string]bool{}
+	for i, site := range body.Sites {
+		ids[i] = site.ID
+		if ids[i] == "" {
+			ids[i] = strconv.Itoa(i)
+		}
+		if seen[ids[i]] {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "duplicate site id "+ids[i]), http.StatusBadRequest)
+			return
+		}
+		seen[ids[i]] = true
+
+		reqs[i] = siteRequest(body.RecommendRequest, site)
+		var err error
+		if plugins[i], err = normalizeRecommendRequest(&reqs[i]); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "site "+ids[i]+": "+err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	start := time.Now()
+	results := runBatch(ctx, len(reqs), func(ctx context.Context, i int) (*RecommendResponse, error) {
+		if cached, ok := s.getCachedRecommendation(ctx, reqs[i].CacheKey()); ok {
+			return cached, nil
+		}
+		return s.executeRecommendation(ctx, reqs[i], plugins[i], nil)
+	})
+
+	lang := requestLanguage(r)
+	resp := BatchRecommendResponse{Sites: results}
+	for i := range resp.Sites {
+		resp.Sites[i].SiteID = ids[i]
+		if res := resp.Sites[i].Result; res != nil {
+			s.localizeRecommendation(ctx, res, lang)
+			applyDisplay(res, display)
+			resp.NSucceeded++
+		} else {
+			resp.NFailed++
+		}
+	}
+	resp.SharedSpecies, resp.NDistinct = sharedSpecies(resp.Sites)
+	resp.QueryTime = time.Since(start).String()
+
+	setContentLanguage(w, lang)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunBatch(t *testing.T) {
+	var running, peak int32
+	results := runBatch(context.Background(), 10, func(ctx context.Context, i int) (*RecommendResponse, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		if i == 3 {
+			return nil, errors.New("no climate data")
+		}
+		return &RecommendResponse{StartStrategy: strconv.Itoa(i)}, nil
+	})
+
+	if peak > batchWorkers {
+		t.Errorf("%d sites ran at once, want at most %d", peak, batchWorkers)
+	}
+	for i, r := range results {
+		if i == 3 {
+			if r.Error == "" || r.Result != nil {
+				t.Errorf("site 3 = %+v, want the error", r)
+			}
+			continue
+		}
+		if r.Result == nil || r.Result.StartStrategy != strconv.Itoa(i) {
+			t.Errorf("site %d = %+v, results out of order", i, r)
+		}
+	}
+}
+
+func TestSharedSpecies(t *testing.T) {
+	site := func(id string, ids ...int64) BatchSiteResult {
+		res := &RecommendResponse{}
+		for _, sp := range ids {
+			res.Species = append(res.Species, SpeciesRecommendation{SpeciesID: sp})
+		}
+		return BatchSiteResult{SiteID: id, Result: res}
+	}
+	results := []BatchSiteResult{
+		site("a", 1, 2, 3),
+		site("b", 2, 3),
+		{SiteID: "c", Error: "failed"},
+		site("d", 3, 4),
+	}
+
+	shared, distinct := sharedSpecies(results)
+	if distinct != 4 {
+		t.Errorf("distinct = %d, want 4", distinct)
+	}
+	want := []SharedSpecies{
+		{SpeciesID: 3, NSites: 3, SiteIDs: []string{"a", "b", "d"}},
+		{SpeciesID: 2, NSites: 2, SiteIDs: []string{"a", "b"}},
+	}
+	if !reflect.DeepEqual(shared, want) {
+		t.Errorf("shared = %+v, want %+v", shared, want)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// BORDER BLENDING
+// ============================================================================
+//
+// A coordinate is hard-assigned to the TDWG polygon containing it, so two
+// sites a kilometre apart on either side of a border get different region
+// climates and candidate pools. With a blend distance D (blend_km on
+// /api/climate, border_blend_km on /api/recommend, up to 50 km) every
+// region within D of the point takes part, weighted by
+//
+//	w = D - signed distance
+//
+// where the distance is negative inside the containing region (to its own
+// border) and positive outside, normalized to sum 1. On a border the two
+// regions weigh the same; D inside a region it is the only one left.
+//
+//   - /api/climate averages the tdwg_climate means of the regions by weight
+//     (Köppen zone and biome are the heaviest region's).
+//   - /api/recommend pools the candidates of the regions: each is ranked and
+//     selected by its climate match times region_weight, the weight of the
+//     regions it qualifies in (native, or by the establishment filters),
+//     while climate_match_score, the climate threshold, and the plans and
+//     outcomes built on it keep the unweighted match. The WorldClim
+//     cell at the point is already continuous across borders, so only the
+//     region-mean fallback climate is blended.
+//
+// Points farther than D from every border behave as without blending; the
+// regions used are returned as border_blend.
+
+const maxBorderBlendKm = 50.0
+
+// BorderRegion is a region taking part in a blend
+type BorderRegion struct {
+	TDWGCode   string  `json:"tdwg_code"`
+	TDWGName   string  `json:"tdwg_name"`
+	DistanceKm float64 `json:"distance_km"` // Negative inside: to the region's own border
+	Weight     float64 `json:"weight"`
+}
+
+// parseBlendKm parses a blend distance; "" is 0, no blending
+func parseBlendKm(v string) (float64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	km, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("blend_km must be a number of km")
+	}
+	return km, validateBlendKm(km)
+}
+
+func validateBlendKm(km float64) error {
+	if km < 0 || km > maxBorderBlendKm || math.IsNaN(km) {
+		return fmt.Errorf("blend distance must be between 0 and %g km", maxBorderBlendKm)
+	}
+	return nil
+}
+
+// borderWeights sets the weights of regions at blend distance km, dropping
+// regions at km or farther; heaviest first
+func borderWeights(regions []BorderRegion, km float64) []BorderRegion {
+	var kept []BorderRegion
+	total := 0.0
+	for _, rg := range regions {
+		if w := km - rg.DistanceKm; w > 0 {
+			rg.Weight = w
+			kept = append(kept, rg)
+			total += w
+		}
+	}
+	for i := range kept {
+		kept[i].Weight /= total
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Weight > kept[j].Weight })
+	return kept
+}
+
+// borderRegions returns the regions within km of (lat, lon), weighted;
+// none when no region is that close
+func (s *Server) borderRegions(ctx context.Context, lat, lon, km float64) ([]BorderRegion, error) {
+	// Degrees covering km at this latitude, to use the geometry index
+	degrees := km / (110.0 * math.Max(math.Cos(lat*math.Pi/180), 0.05))
+	rows, err := s.db.QueryContext(ctx, `
+		WITH p AS (SELECT ST_SetSRID(ST_Point($1, $2), 4326) AS pt)
+		SELECT t.level3_code, COALESCE(t.level3_name, t.level3_code),
+		       CASE WHEN ST_Contains(t.geom, p.pt)
+		            THEN -ST_Distance(ST_Boundary(t.geom)::geography, p.pt::geography)
+		            ELSE ST_Distance(t.geom::geography, p.pt::geography)
+		       END / 1000
+		FROM tdwg_level3 t, p
+		WHERE ST_DWithin(t.geom, p.pt, $3)
+	`, lon, lat, degrees)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var regions []BorderRegion
+	for rows.Next() {
+		var rg BorderRegion
+		if err := rows.Scan(&rg.TDWGCode, &rg.TDWGName, &rg.DistanceKm); err != nil {
+			return nil, err
+		}
+		regions = append(regions, rg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return borderWeights(regions, km), nil
+}
+
+// validateBorderBlend checks border_blend_km, which needs a coordinate
+// location
+func validateBorderBlend(req RecommendRequest) error {
+	if err := validateBlendKm(req.BorderBlendKm); err != nil {
+		return fmt.Errorf("border_blend_km: %w", err)
+	}
+	coordinates := req.Latitude != nil && req.Longitude != nil && req.AOIID == "" && req.TDWGCode == "" && req.StateCode == ""
+	if req.BorderBlendKm > 0 && !coordinates {
+		return fmt.Errorf("border_blend_km needs a latitude/longitude location")
+	}
+	return nil
+}
+
+// borderCodes returns the codes and weights of a blend, as query arrays
+func borderCodes(regions []BorderRegion) (codes []string, weights []float64) {
+	for _, rg := range regions {
+		codes = append(codes, rg.TDWGCode)
+		weights = append(weights, rg.Weight)
+	}
+	return codes, weights
+}
+
+// candidateRegionSQL returns the species_regions join and condition of the
+// candidate query, with the region weight column: the location's region
+// ($6), or with a blend or a level-2 unit one row per species over the
+// regions, where nativeClause picks the regions each qualifies in
+func candidateRegionSQL(loc LocationInfo, nativeClause string, args []interface{}) (join, where, weight string, _ []interface{}) {
+	if len(loc.Level3Codes) > 0 {
+		args[5] = pq.Array(loc.Level3Codes)
+		join = fmt.Sprintf(`JOIN (
+				SELECT sr.species_id,
+				       BOOL_OR(sr.is_native) AS is_native, BOOL_OR(sr.is_endemic) AS is_endemic,
+				       MIN(sr.establishment_means) AS establishment_means
+				FROM species_regions sr
+				WHERE sr.tdwg_code = ANY($6::text[]) %s
+				GROUP BY sr.species_id
+			) sr ON s.id = sr.species_id`, nativeClause)
+		return join, "TRUE", "NULL::float8", args
+	}
+	if len(loc.Blend) < 2 {
+		return "JOIN species_regions sr ON s.id = sr.species_id", "sr.tdwg_code = $6 " + nativeClause, "NULL::float8", args
+	}
+	codes, weights := borderCodes(loc.Blend)
+	args[5] = pq.Array(codes)
+	args = append(args, pq.Array(weights))
+	join = fmt.Sprintf(`JOIN (
+			SELECT sr.species_id, LEAST(SUM(bw.weight), 1) AS region_weight,
+			       BOOL_OR(sr.is_native) AS is_native, BOOL_OR(sr.is_endemic) AS is_endemic,
+			       (ARRAY_AGG(sr.establishment_means ORDER BY bw.weight DESC))[1] AS establishment_means
+			FROM species_regions sr
+			JOIN UNNEST($6::text[], $%d::float8[]) AS bw(tdwg_code, weight) ON sr.tdwg_code = bw.tdwg_code
+			WHERE TRUE %s
+			GROUP BY sr.species_id
+		) sr ON s.id = sr.species_id`, len(args), nativeClause)
+	return join, "TRUE", "sr.region_weight", args
+}
+
+// setBlendedClimate sets the climate of loc to the blend of the regions'
+// means (its border blend, or the regions of a level-2 or administrative
+// unit)
+func (s *Server) setBlendedClimate(ctx context.Context, loc *LocationInfo, regions []BorderRegion) error {
+	data, err := s.blendedClimate(ctx, regions)
+	if err != nil {
+		return err
+	}
+	bio := []*float64{data.Bio1Mean, data.Bio5Mean, data.Bio6Mean, data.Bio12Mean, data.Bio15Mean}
+	for _, v := range bio {
+		if v == nil {
+			return fmt.Errorf("incomplete climate data for the regions of %s", loc.TDWGCode)
+		}
+	}
+	loc.Bio1, loc.Bio5, loc.Bio6, loc.Bio12, loc.Bio15 = *bio[0], *bio[1], *bio[2], *bio[3], *bio[4]
+	return nil
+}
+
+// ============================================================================
+// CLIMATE
+// ============================================================================
+
+// climateMeans returns the numeric fields of d, for blending
+func (d *ClimateData) climateMeans() []**float64 {
+	return []**float64{
+		&d.Bio1Mean, &d.Bio1Min, &d.Bio1Max, &d.Bio2Mean, &d.Bio3Mean, &d.Bio4Mean,
+		&d.Bio5Mean, &d.Bio6Mean, &d.Bio7Mean, &d.Bio8Mean, &d.Bio9Mean, &d.Bio10Mean, &d.Bio11Mean,
+		&d.Bio12Mean, &d.Bio12Min, &d.Bio12Max, &d.Bio13Mean, &d.Bio14Mean, &d.Bio15Mean,
+		&d.Bio16Mean, &d.Bio17Mean, &d.Bio18Mean, &d.Bio19Mean, &d.AridityIndex,
+	}
+}
+
+// blendClimateData averages the climate of regions by weight, each field
+// over the regions that have it; the rest is the first (heaviest) region's
+func blendClimateData(regions []ClimateData, weights []float64) ClimateData {
+	blended := regions[0]
+	fields := blended.climateMeans()
+	for f := range fields {
+		sum, total := 0.0, 0.0
+		for i := range regions {
+			if v := *regions[i].climateMeans()[f]; v != nil {
+				sum += *v * weights[i]
+				total += weights[i]
+			}
+		}
+		if total == 0 {
+			*fields[f] = nil
+			continue
+		}
+		mean := sum / total
+		*fields[f] = &mean
+	}
+	return blended
+}
+
+// blendedClimate is the /api/climate response for a blend of regions
+func (s *Server) blendedClimate(ctx context.Context, blend []BorderRegion) (ClimateData, error) {
+	codes, _ := borderCodes(blend)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.tdwg_code, t.level3_name,
+			   c.bio1_mean, c.bio1_min, c.bio1_max,
+			   c.bio2_mean, c.bio3_mean, c.bio4_mean,
+			   c.bio5_mean, c.bio6_mean, c.bio7_mean,
+			   c.bio8_mean, c.bio9_mean, c.bio10_mean, c.bio11_mean,
+			   c.bio12_mean, c.bio12_min, c.bio12_max,
+			   c.bio13_mean, c.bio14_mean, c.bio15_mean,
+			   c.bio16_mean, c.bio17_mean, c.bio18_mean, c.bio19_mean,
+			   c.koppen_zone, c.whittaker_biome, c.aridity_index
+		FROM tdwg_climate c
+		JOIN tdwg_level3 t ON c.tdwg_code = t.level3_code
+		WHERE c.tdwg_code = ANY($1)
+	`, pq.Array(codes))
+	if err != nil {
+		return ClimateData{}, err
+	}
+	defer rows.Close()
+
+	byCode := map[string]ClimateData{}
+	for rows.Next() {
+		var data ClimateData
+		if err := rows.Scan(
+			&data.TDWGCode, &data.TDWGName,
+			&data.Bio1Mean, &data.Bio1Min, &data.Bio1Max,
+			&data.Bio2Mean, &data.Bio3Mean, &data.Bio4Mean,
+			&data.Bio5Mean, &data.Bio6Mean, &data.Bio7Mean,
+			&data.Bio8Mean, &data.Bio9Mean, &data.Bio10Mean, &data.Bio11Mean,
+			&data.Bio12Mean, &data.Bio12Min, &data.Bio12Max,
+			&data.Bio13Mean, &data.Bio14Mean, &data.Bio15Mean,
+			&data.Bio16Mean, &data.Bio17Mean, &data.Bio18Mean, &data.Bio19Mean,
+			&data.KoppenZone, &data.WhittakerBiome, &data.AridityIndex,
+		); err != nil {
+			return ClimateData{}, err
+		}
+		byCode[data.TDWGCode] = data
+	}
+	if err := rows.Err(); err != nil {
+		return ClimateData{}, err
+	}
+
+	// Regions without climate data drop out of the blend
+	var regions []ClimateData
+	var used []BorderRegion
+	total := 0.0
+	for _, rg := range blend {
+		if data, ok := byCode[rg.TDWGCode]; ok {
+			regions = append(regions, data)
+			used = append(used, rg)
+			total += rg.Weight
+		}
+	}
+	if len(regions) == 0 {
+		return ClimateData{}, fmt.Errorf("no climate data for this location")
+	}
+	weights := make([]float64, len(used))
+	for i := range used {
+		used[i].Weight /= total
+		weights[i] = used[i].Weight
+	}
+	data := blendClimateData(regions, weights)
+	data.Blend = used
+	return data, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBorderWeights(t *testing.T) {
+	// On the border: the two regions weigh the same
+	got := borderWeights([]BorderRegion{{TDWGCode: "BZS", DistanceKm: 0}, {TDWGCode: "AGE", DistanceKm: 0}}, 10)
+	if len(got) != 2 || got[0].Weight != 0.5 || got[1].Weight != 0.5 {
+		t.Errorf("on the border: %+v", got)
+	}
+
+	// 4 km inside BZS, 4 km from AGE, 12 km from PAR (beyond the distance)
+	got = borderWeights([]BorderRegion{
+		{TDWGCode: "AGE", DistanceKm: 4}, {TDWGCode: "PAR", DistanceKm: 12}, {TDWGCode: "BZS", DistanceKm: -4},
+	}, 10)
+	if len(got) != 2 || got[0].TDWGCode != "BZS" || math.Abs(got[0].Weight-0.7) > 1e-9 || math.Abs(got[1].Weight-0.3) > 1e-9 {
+		t.Errorf("near the border: %+v", got)
+	}
+
+	// The distance inside: only the containing region is left
+	got = borderWeights([]BorderRegion{{TDWGCode: "BZS", DistanceKm: -10}, {TDWGCode: "AGE", DistanceKm: 10}}, 10)
+	if len(got) != 1 || got[0].TDWGCode != "BZS" || got[0].Weight != 1 {
+		t.Errorf("far from the border: %+v", got)
+	}
+}
+
+func TestBlendClimateData(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	koppen := "Cfa"
+	regions := []ClimateData{
+		{TDWGCode: "BZS", Bio1Mean: f(20), Bio12Mean: f(1500), KoppenZone: &koppen},
+		{TDWGCode: "AGE", Bio1Mean: f(10), AridityIndex: f(0.8)},
+	}
+	got := blendClimateData(regions, []float64{0.75, 0.25})
+	if got.TDWGCode != "BZS" || got.KoppenZone != &koppen {
+		t.Errorf("heaviest region fields: %+v", got)
+	}
+	if *got.Bio1Mean != 17.5 || *got.Bio12Mean != 1500 || *got.AridityIndex != 0.8 || got.Bio5Mean != nil {
+		t.Errorf("got bio1 %g, bio12 %g, aridity %g, bio5 %v", *got.Bio1Mean, *got.Bio12Mean, *got.AridityIndex, got.Bio5Mean)
+	}
+	if *regions[0].Bio1Mean != 20 {
+		t.Error("blending changed the region's climate")
+	}
+}
+
+func TestValidateBorderBlend(t *testing.T) {
+	lat, lon := -25.5, -54.6
+	for _, tc := range []struct {
+		req     RecommendRequest
+		wantErr bool
+	}{
+		{RecommendRequest{TDWGCode: "BZS"}, false},
+		{RecommendRequest{Latitude: &lat, Longitude: &lon, BorderBlendKm: 10}, false},
+		{RecommendRequest{Latitude: &lat, Longitude: &lon, BorderBlendKm: 51}, true},
+		{RecommendRequest{Latitude: &lat, Longitude: &lon, BorderBlendKm: -1}, true},
+		{RecommendRequest{TDWGCode: "BZS", BorderBlendKm: 10}, true},
+		{RecommendRequest{AOIID: "x", Latitude: &lat, Longitude: &lon, BorderBlendKm: 10}, true},
+	} {
+		if err := validateBorderBlend(tc.req); (err != nil) != tc.wantErr {
+			t.Errorf("%+v: got %v", tc.req, err)
+		}
+	}
+	for _, v := range []string{"abc", "NaN", "60"} {
+		if _, err := parseBlendKm(v); err == nil {
+			t.Errorf("parseBlendKm(%q) accepted", v)
+		}
+	}
+}
+
+func TestCandidateRegionSQL(t *testing.T) {
+	args := []interface{}{1.0, 2.0, 3.0, 4.0, 5.0, "BZS", 0.6}
+	join, where, weight, got := candidateRegionSQL(LocationInfo{TDWGCode: "BZS"}, "AND sr.is_native = TRUE", args)
+	if !strings.Contains(join, "JOIN species_regions sr") || where != "sr.tdwg_code = $6 AND sr.is_native = TRUE" || weight != "NULL::float8" || len(got) != 7 {
+		t.Errorf("single region: %s / %s / %s with %d args", join, where, weight, len(got))
+	}
+
+	blend := LocationInfo{TDWGCode: "BZS", Blend: []BorderRegion{{TDWGCode: "BZS", Weight: 0.7}, {TDWGCode: "AGE", Weight: 0.3}}}
+	args = []interface{}{1.0, 2.0, 3.0, 4.0, 5.0, "BZS", 0.6, "native"}
+	join, where, weight, got = candidateRegionSQL(blend, "AND sr.establishment_means::text = ANY($8)", args)
+	if len(got) != 9 || where != "TRUE" || weight != "sr.region_weight" {
+		t.Fatalf("blend: %s / %s with %d args", where, weight, len(got))
+	}
+	if !strings.Contains(join, "UNNEST($6::text[], $9::float8[])") || !strings.Contains(join, "WHERE TRUE AND sr.establishment_means::text = ANY($8)") {
+		t.Errorf("blend join: %s", join)
+	}
+	if _, ok := got[5].(string); ok {
+		t.Error("region code not replaced by the blend's codes")
+	}
+}
+
+func TestClimateBlendValidation(t *testing.T) {
+	s := newTestServer()
+	for _, q := range []string{"blend_km=abc", "blend_km=100", "blend_km=-5"} {
+		w := httptest.NewRecorder()
+		s.handleClimate(w, httptest.NewRequest(http.MethodGet, "/api/climate?lat=-25.5&lon=-54.6&"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// PHYTOGEOGRAPHIC DOMAIN AND VEGETATION FILTERS
+// ============================================================================
+//
+// Biome-level matching is coarse for Brazilian sites. The Flora e Funga do
+// Brasil checklist (REFLORA crawler, species_brazil_flora, migration 036)
+// records where each species occurs: phytogeographic domains ("Mata
+// Atlântica", "Cerrado") and vegetation types ("Floresta Ombrófila
+// (= Floresta Pluvial)", "Restinga"). Preferences.PhytogeographicDomains and
+// VegetationTypes keep species recorded in any of the listed ones, compared
+// case-insensitively. Species absent from the checklist are dropped by
+// these filters. /api/species takes the same filters as ?domain= and
+// ?vegetation_type= (comma-separated), and /api/flora-brasil/vocabulary
+// lists the values in use, with their species counts.
+
+// brazilFloraClauses returns the candidate clauses for the domain and
+// vegetation filters; arg binds a value to the next placeholder
+func brazilFloraClauses(prefs Preferences, arg func(interface{}) string) []string {
+	var clauses []string
+	for _, f := range []struct {
+		column string
+		values []string
+	}{
+		{"phytogeographic_domains", prefs.PhytogeographicDomains},
+		{"vegetation_types", prefs.VegetationTypes},
+	} {
+		if len(f.values) == 0 {
+			continue
+		}
+		clauses = append(clauses, `EXISTS (
+			SELECT 1 FROM species_brazil_flora bf, unnest(bf.`+f.column+`) v
+			WHERE bf.species_id = s.id AND lower(v) = ANY(`+arg(pq.Array(f.values))+`))`)
+	}
+	return clauses
+}
+
+// parseBrazilFloraFilters reads ?domain= and ?vegetation_type= into the
+// matching preferences
+func parseBrazilFloraFilters(q url.Values) (Preferences, error) {
+	var prefs Preferences
+	var err error
+	split := func(key string) []string {
+		var values []string
+		for _, v := range q[key] {
+			values = append(values, strings.Split(v, ",")...)
+		}
+		return values
+	}
+	if prefs.PhytogeographicDomains, err = parseExclusions("domain", split("domain")); err != nil {
+		return prefs, err
+	}
+	if prefs.VegetationTypes, err = parseExclusions("vegetation_type", split("vegetation_type")); err != nil {
+		return prefs, err
+	}
+	return prefs, nil
+}
+
+type FloraVocabularyItem struct {
+	Value    string `json:"value"`
+	NSpecies int    `json:"n_species"`
+}
+
+type FloraVocabularyResponse struct {
+	PhytogeographicDomains []FloraVocabularyItem `json:"phytogeographic_domains"`
+	VegetationTypes        []FloraVocabularyItem `json:"vegetation_types"`
+	QueryTime              string                `json:"query_time"`
+}
+
+// handleFloraVocabulary handles GET /api/flora-brasil/vocabulary
+func (s *Server) handleFloraVocabulary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	resp := FloraVocabularyResponse{}
+	for _, f := range []struct {
+		column string
+		items  *[]FloraVocabularyItem
+	}{
+		{"phytogeographic_domains", &resp.PhytogeographicDomains},
+		{"vegetation_types", &resp.VegetationTypes},
+	} {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT v, COUNT(*) FROM species_brazil_flora, unnest(`+f.column+`) v
+			GROUP BY v ORDER BY v`)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		*f.items = []FloraVocabularyItem{}
+		for rows.Next() {
+			var item FloraVocabularyItem
+			if err := rows.Scan(&item.Value, &item.NSpecies); err != nil {
+				rows.Close()
+				http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+				return
+			}
+			*f.items = append(*f.items, item)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
+	resp.QueryTime = time.Since(start).String()
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(resp)
+}
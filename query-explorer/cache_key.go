@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// ============================================================================
+// CACHE KEY GENERATION
+// ============================================================================
+//
+// The key used to be a format string of hand-picked request fields, which
+// every new option had to remember to join; missing one served a cached
+// result computed for other preferences. It is now the hash of the whole
+// normalized request, so every field that can change the result is part
+// of it, less the seeds the server filled in (see determinism.go).
+
+// CacheKey hashes the normalized request
+func (r *RecommendRequest) CacheKey() string {
+	data, _ := json.Marshal(r.withClientSeeds())
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
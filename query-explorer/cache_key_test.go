@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestCacheKeyCoversRequest(t *testing.T) {
+	elevation, tolerance, minHeight := 800.0, 50.0, 4.0
+	base := RecommendRequest{TDWGCode: "BZS", NSpecies: 10, ClimateThreshold: 0.6}
+	key := base.CacheKey()
+
+	// Options that were once missing from the hand-built key
+	variants := map[string]func(*RecommendRequest){
+		"elevation_m":         func(r *RecommendRequest) { r.ElevationM = &elevation },
+		"elevation_mode":      func(r *RecommendRequest) { r.Preferences.ElevationMode = "filter" },
+		"tolerance":           func(r *RecommendRequest) { r.Preferences.ElevationToleranceM = &tolerance },
+		"min_height_m":        func(r *RecommendRequest) { r.Preferences.MinHeightM = &minHeight },
+		"algorithm":           func(r *RecommendRequest) { r.Algorithm = "annealing" },
+		"climate_diagnostics": func(r *RecommendRequest) { r.ClimateDiagnostics = true },
+	}
+	for name, change := range variants {
+		req := base
+		change(&req)
+		if req.CacheKey() == key {
+			t.Errorf("%s not part of the cache key", name)
+		}
+	}
+	if again := base; again.CacheKey() != key {
+		t.Error("cache key not stable")
+	}
+}
+
+func TestCacheKeyIgnoresFilledSeeds(t *testing.T) {
+	normalized := func(req RecommendRequest) RecommendRequest {
+		if _, err := normalizeRecommendRequest(&req); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	// Unseeded requests draw a new seed each time but share a cache key
+	req := RecommendRequest{TDWGCode: "BZS", NSpecies: 10, StartStrategy: "random_top_k", Algorithm: "annealing"}
+	a, b := normalized(req), normalized(req)
+	if a.StartSeed == nil || a.RandomSeed == nil {
+		t.Fatal("seeds not filled in")
+	}
+	if a.CacheKey() != b.CacheKey() {
+		t.Error("unseeded requests get distinct cache keys")
+	}
+
+	// A seed the client sent is part of the key
+	seed := int64(7)
+	seeded := req
+	seeded.StartSeed = &seed
+	if s := normalized(seeded); s.CacheKey() == a.CacheKey() {
+		t.Error("client start_seed left out of the cache key")
+	}
+	if got := normalized(seeded); got.withClientSeeds().StartSeed == nil || got.withClientSeeds().RandomSeed != nil {
+		t.Errorf("client seeds = %v, %v", got.withClientSeeds().StartSeed, got.withClientSeeds().RandomSeed)
+	}
+}
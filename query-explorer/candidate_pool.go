@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// ============================================================================
+// CANDIDATE POOL SIZING
+// ============================================================================
+//
+// The greedy selection evaluates every remaining candidate for every species
+// it adds, so its cost grows with pool size times n_species. Candidates come
+// ordered by climate match, and the pool is cut after the best
+// n_species * CANDIDATE_POOL_PER_SPECIES of them, kept within
+// [CANDIDATE_POOL_MIN, CANDIDATE_POOL_MAX] (500 to 2000 by default). A
+// request may ask for another size with max_candidates, up to
+// CANDIDATE_POOL_CAP. Requests without n_species or max_candidates ask for
+// all candidates, with no selection, and get all of them.
+//
+// A cut pool is reported in the response. When the selection also reaches
+// into the tail of the pool, for distinct species with low climate match,
+// the species beyond the cut would likely have been picked too and the
+// diversity of the result is limited by the pool: that is logged, so the
+// defaults can be tuned.
+
+const (
+	defaultCandidatePoolMin        = 500
+	defaultCandidatePoolMax        = 2000
+	defaultCandidatePoolPerSpecies = 50
+	defaultCandidatePoolCap        = 5000
+
+	// Share of a truncated pool, by climate rank, counted as its tail
+	candidatePoolTail = 0.1
+)
+
+type candidatePoolLimits struct {
+	Min, Max   int
+	PerSpecies int
+	Cap        int
+}
+
+// loadCandidatePoolLimits reads CANDIDATE_POOL_MIN, CANDIDATE_POOL_MAX,
+// CANDIDATE_POOL_PER_SPECIES and CANDIDATE_POOL_CAP; invalid combinations
+// fall back to the defaults
+func loadCandidatePoolLimits() candidatePoolLimits {
+	l := candidatePoolLimits{
+		Min:        getEnvInt("CANDIDATE_POOL_MIN", defaultCandidatePoolMin),
+		Max:        getEnvInt("CANDIDATE_POOL_MAX", defaultCandidatePoolMax),
+		PerSpecies: getEnvInt("CANDIDATE_POOL_PER_SPECIES", defaultCandidatePoolPerSpecies),
+		Cap:        getEnvInt("CANDIDATE_POOL_CAP", defaultCandidatePoolCap),
+	}
+	if l.Min < 1 || l.Max < l.Min || l.Cap < l.Max || l.PerSpecies < 1 {
+		log.Printf("Invalid candidate pool limits %+v, using defaults", l)
+		return candidatePoolLimits{
+			Min:        defaultCandidatePoolMin,
+			Max:        defaultCandidatePoolMax,
+			PerSpecies: defaultCandidatePoolPerSpecies,
+			Cap:        defaultCandidatePoolCap,
+		}
+	}
+	return l
+}
+
+// size returns the number of candidates to fetch for req, 0 for all
+func (l candidatePoolLimits) size(req RecommendRequest) int {
+	switch {
+	case req.MaxCandidates > 0:
+		return min(req.MaxCandidates, l.Cap)
+	case req.NSpecies > 0:
+		return min(max(req.NSpecies*l.PerSpecies, l.Min), l.Max)
+	}
+	return 0
+}
+
+func validateMaxCandidates(req RecommendRequest) error {
+	if req.MaxCandidates < 0 {
+		return fmt.Errorf("max_candidates must be positive")
+	}
+	if req.MaxCandidates > 0 && req.NSpecies > req.MaxCandidates {
+		return fmt.Errorf("max_candidates (%d) is below n_species (%d)", req.MaxCandidates, req.NSpecies)
+	}
+	return nil
+}
+
+type CandidatePoolInfo struct {
+	Size      int  `json:"size"`      // Candidates left after plugin filters
+	Limit     int  `json:"limit"`     // Candidates fetched at most, 0 for all
+	Truncated bool `json:"truncated"` // More candidates passed the threshold than the limit
+}
+
+// poolTailSelections counts the selected species whose climate rank falls in
+// the tail of a truncated pool (pool in climate order)
+func poolTailSelections(pool, selected []SpeciesRecommendation) int {
+	start := len(pool) - int(float64(len(pool))*candidatePoolTail)
+	tail := make(map[int64]bool, len(pool)-start)
+	for _, c := range pool[start:] {
+		tail[c.SpeciesID] = true
+	}
+	n := 0
+	for _, sp := range selected {
+		if tail[sp.SpeciesID] {
+			n++
+		}
+	}
+	return n
+}
+
+// logPoolTruncation logs a truncated pool whose tail the selection used
+func logPoolTruncation(loc LocationInfo, info CandidatePoolInfo, pool, selected []SpeciesRecommendation) {
+	if !info.Truncated {
+		return
+	}
+	if n := poolTailSelections(pool, selected); n > 0 {
+		log.Printf("recommend: candidate pool for %s truncated at %d; %d of %d selected species come from its last %.0f%%, diversity is likely limited by the pool size",
+			loc.TDWGCode, info.Limit, n, len(selected), candidatePoolTail*100)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestCandidatePoolSize(t *testing.T) {
+	l := candidatePoolLimits{Min: 500, Max: 2000, PerSpecies: 50, Cap: 5000}
+	tests := []struct {
+		nSpecies, maxCandidates int
+		want                    int
+	}{
+		{nSpecies: 5, want: 500},
+		{nSpecies: 20, want: 1000},
+		{nSpecies: 100, want: 2000},
+		{nSpecies: 0, want: 0}, // All candidates
+		{maxCandidates: 9000, want: 5000},
+		{nSpecies: 20, maxCandidates: 300, want: 300},
+		{nSpecies: 20, maxCandidates: 9000, want: 5000},
+	}
+	for _, tt := range tests {
+		req := RecommendRequest{NSpecies: tt.nSpecies, MaxCandidates: tt.maxCandidates}
+		if got := l.size(req); got != tt.want {
+			t.Errorf("size(n_species=%d, max_candidates=%d) = %d, want %d", tt.nSpecies, tt.maxCandidates, got, tt.want)
+		}
+	}
+}
+
+func TestValidateMaxCandidates(t *testing.T) {
+	for _, req := range []RecommendRequest{
+		{MaxCandidates: -1},
+		{NSpecies: 20, MaxCandidates: 10},
+	} {
+		if err := validateMaxCandidates(req); err == nil {
+			t.Errorf("validateMaxCandidates(%+v): expected an error", req)
+		}
+	}
+	if err := validateMaxCandidates(RecommendRequest{NSpecies: 20, MaxCandidates: 20}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPoolTailSelections(t *testing.T) {
+	pool := make([]SpeciesRecommendation, 20)
+	for i := range pool {
+		pool[i].SpeciesID = int64(i + 1)
+	}
+	// The last 10% of 20 candidates are species 19 and 20
+	selected := []SpeciesRecommendation{pool[0], pool[10], pool[18], pool[19]}
+	if got := poolTailSelections(pool, selected); got != 2 {
+		t.Errorf("poolTailSelections = %d, want 2", got)
+	}
+	if got := poolTailSelections(pool, selected[:2]); got != 0 {
+		t.Errorf("poolTailSelections = %d, want 0", got)
+	}
+}
+
+func TestCandidateQueryLimit(t *testing.T) {
+	loc := LocationInfo{TDWGCode: "BZS", Bio1: 18, Bio5: 28, Bio6: 8, Bio12: 1500, Bio15: 30}
+	for limit, want := range map[int]interface{}{100: 101, 0: nil} {
+		_, args, err := candidateQuery(loc, RecommendRequest{ClimateThreshold: 0.6}, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := args[len(args)-1]; got != want {
+			t.Errorf("limit %d: LIMIT bound to %v, want %v", limit, got, want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// CLIMATE ANALOG REGIONS
+// ============================================================================
+//
+// GET /api/climate/analogs?tdwg_code=BZS&scenario=ssp245_2050 ranks TDWG
+// regions by how close their current climate is to the target region's
+// projected climate (tdwg_climate_future, migration 039, loaded by the
+// worldclim_future crawler). Seed and seedlings sourced from the closest
+// analogs are adapted to the climate the planting will face, not the one it
+// has today.
+//
+// Distances are over bio1, bio5, bio6, bio12 and bio15, the variables of
+// the climate match. method=euclidean (default) standardizes each variable
+// by its spread across the current climate of all regions, so degrees and
+// millimetres weigh alike; method=mahalanobis also accounts for their
+// correlation (bio1, bio5 and bio6 largely move together), so a shift in
+// all three counts as one shift.
+
+const (
+	defaultClimateAnalogs = 20
+	maxClimateAnalogs     = 100
+)
+
+var analogVariables = []string{"bio1", "bio5", "bio6", "bio12", "bio15"}
+
+type ClimateAnalog struct {
+	Rank        int                `json:"rank"`
+	TDWGCode    string             `json:"tdwg_code"`
+	Name        string             `json:"name"`
+	Distance    float64            `json:"distance"`
+	Climate     map[string]float64 `json:"climate"`     // Current
+	Differences map[string]float64 `json:"differences"` // Current minus the target's projected climate
+}
+
+type ClimateAnalogsResponse struct {
+	TDWGCode        string             `json:"tdwg_code"`
+	Name            string             `json:"name"`
+	Scenario        string             `json:"scenario"`
+	GCM             string             `json:"gcm"`
+	Method          string             `json:"method"`
+	Variables       []string           `json:"variables"`
+	CurrentClimate  map[string]float64 `json:"current_climate"`
+	FutureClimate   map[string]float64 `json:"future_climate"`
+	ShiftDistance   float64            `json:"shift_distance"` // From the region's current climate to its projected one
+	RegionsCompared int                `json:"regions_compared"`
+	Analogs         []ClimateAnalog    `json:"analogs"`
+	QueryTime       string             `json:"query_time"`
+}
+
+// climateMetric measures distances between climate vectors
+type climateMetric func(a, b []float64) float64
+
+// newClimateMetric builds the metric of method from the current climate of
+// all regions
+func newClimateMetric(method string, regions [][]float64) (climateMetric, error) {
+	if len(regions) < 2 {
+		return nil, fmt.Errorf("not enough regions with climate data")
+	}
+	k := len(regions[0])
+	mean := make([]float64, k)
+	for _, v := range regions {
+		for i := range v {
+			mean[i] += v[i] / float64(len(regions))
+		}
+	}
+	cov := make([][]float64, k)
+	for i := range cov {
+		cov[i] = make([]float64, k)
+		for j := range cov[i] {
+			for _, v := range regions {
+				cov[i][j] += (v[i] - mean[i]) * (v[j] - mean[j])
+			}
+			cov[i][j] /= float64(len(regions) - 1)
+		}
+	}
+
+	switch method {
+	case "euclidean":
+		std := make([]float64, k)
+		for i := range std {
+			std[i] = math.Sqrt(cov[i][i])
+			if std[i] == 0 {
+				std[i] = 1
+			}
+		}
+		return func(a, b []float64) float64 {
+			var sum float64
+			for i := range a {
+				d := (a[i] - b[i]) / std[i]
+				sum += d * d
+			}
+			return math.Sqrt(sum)
+		}, nil
+	case "mahalanobis":
+		inv, ok := invertMatrix(cov)
+		if !ok {
+			return nil, fmt.Errorf("climate covariance is singular; use method=euclidean")
+		}
+		return func(a, b []float64) float64 {
+			var sum float64
+			for i := range a {
+				for j := range b {
+					sum += (a[i] - b[i]) * inv[i][j] * (a[j] - b[j])
+				}
+			}
+			return math.Sqrt(math.Max(0, sum))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown method %q (euclidean, mahalanobis)", method)
+}
+
+// invertMatrix inverts m by Gauss-Jordan elimination; false if singular
+func invertMatrix(m [][]float64) ([][]float64, bool) {
+	n := len(m)
+	a := make([][]float64, n)
+	for i := range m {
+		a[i] = make([]float64, 2*n)
+		copy(a[i], m[i])
+		a[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		p := a[col][col]
+		for j := range a[col] {
+			a[col][j] /= p
+		}
+		for row := 0; row < n; row++ {
+			if row != col && a[row][col] != 0 {
+				f := a[row][col]
+				for j := range a[row] {
+					a[row][j] -= f * a[col][j]
+				}
+			}
+		}
+	}
+	inv := make([][]float64, n)
+	for i := range a {
+		inv[i] = a[i][n:]
+	}
+	return inv, true
+}
+
+// climateMap names the values of a climate vector
+func climateMap(v []float64) map[string]float64 {
+	m := make(map[string]float64, len(v))
+	for i, name := range analogVariables {
+		m[name] = math.Round(v[i]*100) / 100
+	}
+	return m
+}
+
+// handleClimateAnalogs handles GET /api/climate/analogs
+func (s *Server) handleClimateAnalogs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.resolveAOIQuery(r); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	code := strings.ToUpper(q.Get("tdwg_code"))
+	scenario := strings.ToLower(q.Get("scenario"))
+	if code == "" || scenario == "" {
+		http.Error(w, `{"error": "tdwg_code (or aoi_id) and scenario are required"}`, http.StatusBadRequest)
+		return
+	}
+	gcm := q.Get("gcm")
+	if gcm == "" {
+		gcm = "ensemble"
+	}
+	method := q.Get("method")
+	if method == "" {
+		method = "euclidean"
+	}
+	limit := defaultClimateAnalogs
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxClimateAnalogs {
+			http.Error(w, fmt.Sprintf(`{"error": "limit must be between 1 and %d"}`, maxClimateAnalogs), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	start := time.Now()
+	future := make([]float64, len(analogVariables))
+	err := s.db.QueryRowContext(ctx, `
+		SELECT bio1_mean, bio5_mean, bio6_mean, bio12_mean, bio15_mean
+		FROM tdwg_climate_future
+		WHERE tdwg_code = $1 AND scenario = $2 AND gcm = $3
+		  AND bio1_mean IS NOT NULL AND bio5_mean IS NOT NULL AND bio6_mean IS NOT NULL
+		  AND bio12_mean IS NOT NULL AND bio15_mean IS NOT NULL
+	`, code, scenario, gcm).Scan(&future[0], &future[1], &future[2], &future[3], &future[4])
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, fmt.Sprintf("No %s projection (gcm %s) for %s", scenario, gcm, code)), http.StatusNotFound)
+		return
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.tdwg_code, COALESCE(t.level3_name, c.tdwg_code),
+		       c.bio1_mean, c.bio5_mean, c.bio6_mean, c.bio12_mean, c.bio15_mean
+		FROM tdwg_climate c
+		LEFT JOIN tdwg_level3 t ON t.level3_code = c.tdwg_code
+		WHERE c.bio1_mean IS NOT NULL AND c.bio5_mean IS NOT NULL AND c.bio6_mean IS NOT NULL
+		  AND c.bio12_mean IS NOT NULL AND c.bio15_mean IS NOT NULL
+	`)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	var analogs []ClimateAnalog
+	var current [][]float64
+	for rows.Next() {
+		var a ClimateAnalog
+		v := make([]float64, len(analogVariables))
+		if err := rows.Scan(&a.TDWGCode, &a.Name, &v[0], &v[1], &v[2], &v[3], &v[4]); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		analogs = append(analogs, a)
+		current = append(current, v)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	metric, err := newClimateMetric(method, current)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	resp := ClimateAnalogsResponse{
+		TDWGCode:        code,
+		Name:            code,
+		Scenario:        scenario,
+		GCM:             gcm,
+		Method:          method,
+		Variables:       analogVariables,
+		FutureClimate:   climateMap(future),
+		RegionsCompared: len(analogs),
+	}
+	for i := range analogs {
+		a := &analogs[i]
+		a.Distance = round3(metric(current[i], future))
+		a.Climate = climateMap(current[i])
+		a.Differences = make(map[string]float64, len(analogVariables))
+		for j, name := range analogVariables {
+			a.Differences[name] = math.Round((current[i][j]-future[j])*100) / 100
+		}
+		if a.TDWGCode == code {
+			resp.Name = a.Name
+			resp.CurrentClimate = a.Climate
+			resp.ShiftDistance = a.Distance
+		}
+	}
+	sort.SliceStable(analogs, func(i, j int) bool {
+		if analogs[i].Distance != analogs[j].Distance {
+			return analogs[i].Distance < analogs[j].Distance
+		}
+		return analogs[i].TDWGCode < analogs[j].TDWGCode
+	})
+	if len(analogs) > limit {
+		analogs = analogs[:limit]
+	}
+	for i := range analogs {
+		analogs[i].Rank = i + 1
+	}
+	resp.Analogs = analogs
+
+	resp.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInvertMatrix(t *testing.T) {
+	m := [][]float64{{4, 7}, {2, 6}}
+	inv, ok := invertMatrix(m)
+	if !ok {
+		t.Fatal("matrix is invertible")
+	}
+	want := [][]float64{{0.6, -0.7}, {-0.2, 0.4}}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(inv[i][j]-want[i][j]) > 1e-9 {
+				t.Fatalf("inv = %v, want %v", inv, want)
+			}
+		}
+	}
+	if _, ok := invertMatrix([][]float64{{1, 2}, {2, 4}}); ok {
+		t.Fatal("singular matrix inverted")
+	}
+}
+
+func TestClimateMetrics(t *testing.T) {
+	// Two variables on very different scales, uncorrelated
+	regions := [][]float64{{20, 1000}, {22, 1000}, {20, 2000}, {22, 2000}}
+
+	euclid, err := newClimateMetric("euclidean", regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One standard deviation in either variable is the same distance
+	dt := euclid([]float64{20, 1000}, []float64{22, 1000})
+	dp := euclid([]float64{20, 1000}, []float64{20, 2000})
+	if math.Abs(dt-dp) > 1e-9 {
+		t.Errorf("standardized distances differ: %v vs %v", dt, dp)
+	}
+	if euclid(regions[0], regions[0]) != 0 {
+		t.Error("distance to itself is not 0")
+	}
+
+	// Uncorrelated variables: Mahalanobis equals standardized Euclidean
+	maha, err := newClimateMetric("mahalanobis", regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := maha([]float64{20, 1000}, []float64{22, 2000}); math.Abs(d-euclid([]float64{20, 1000}, []float64{22, 2000})) > 1e-9 {
+		t.Errorf("mahalanobis = %v, want the euclidean distance", d)
+	}
+
+	// Correlated variables: a shift along the correlation is closer than one across it
+	correlated := [][]float64{{0, 0}, {1, 1.1}, {2, 1.9}, {3, 3.2}, {4, 3.9}}
+	maha, err = newClimateMetric("mahalanobis", correlated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if along, across := maha([]float64{1, 1}, []float64{2, 2}), maha([]float64{1, 1}, []float64{2, 0}); along >= across {
+		t.Errorf("along = %v, across = %v", along, across)
+	}
+
+	if _, err := newClimateMetric("cosine", regions); err == nil {
+		t.Error("unknown method accepted")
+	}
+}
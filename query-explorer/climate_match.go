@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// PER-VARIABLE CLIMATE MATCH DIAGNOSTICS
+// ============================================================================
+//
+// calculate_climate_match (migration 009) returns one score. The functions
+// below recompute it term by term from the same species_climate_envelope row,
+// so the weighted per-variable scores add up to climate_match_score:
+//
+//	bio1   annual mean temp vs envelope mean, ±10 °C tolerance      25%
+//	bio5   warmest-month max vs temp_max + 3 °C (hard limit)       12.5%
+//	bio6   coldest-month min vs temp_min - 3 °C (hard limit)       12.5%
+//	bio12  annual precipitation vs envelope mean, relative          20%
+//	bio15  precipitation seasonality vs envelope mean, ±50          15%
+//	frost  bio6 below 0 °C vs cold_month_min - 2 °C                 15%
+//
+// Requests with climate_variables replace these weights (see
+// climate_variables.go); variables with weight 0 are left out.
+//
+// /api/recommend returns the breakdown with climate_diagnostics: true;
+// GET /api/climate/match returns it for any species at any location, so a
+// low score can be traced to cold minima or to precipitation without
+// running a recommendation.
+
+// climateEnvelope is a species_climate_envelope row; nil means no data
+type climateEnvelope struct {
+	TempMean          *float64
+	TempMin           *float64
+	TempMax           *float64
+	PrecipMean        *float64
+	PrecipMin         *float64
+	PrecipMax         *float64
+	PrecipSeasonality *float64
+	ColdMonthMin      *float64
+}
+
+type ClimateVariableMatch struct {
+	Variable     string   `json:"variable"` // bio1, bio5, bio6, bio12, bio15, frost
+	Group        string   `json:"group"`    // thermal, hydrological
+	SiteValue    float64  `json:"site_value"`
+	EnvelopeMin  *float64 `json:"envelope_min,omitempty"`
+	EnvelopeMax  *float64 `json:"envelope_max,omitempty"`
+	EnvelopeMean *float64 `json:"envelope_mean,omitempty"`
+	Status       string   `json:"status"`    // inside, below, above, no_data
+	Deviation    float64  `json:"deviation"` // Distance outside the envelope, in the variable's units (0 when inside)
+	Score        float64  `json:"score"`     // 0-1 suitability for this variable
+	Weight       float64  `json:"weight"`    // Share of climate_match_score
+}
+
+type ClimateDiagnostics struct {
+	Variables         []ClimateVariableMatch `json:"variables"`
+	HardLimitExceeded bool                   `json:"hard_limit_exceeded"` // bio5/bio6 outside tolerance: score is 0
+	ThermalScore      float64                `json:"thermal_score"`       // Weighted 0-1 over thermal variables
+	HydrologicalScore float64                `json:"hydrological_score"`  // Weighted 0-1 over hydrological variables
+	LimitingGroup     string                 `json:"limiting_group,omitempty"`
+}
+
+const (
+	groupThermal      = "thermal"
+	groupHydrological = "hydrological"
+)
+
+// rangeStatus places v relative to [min, max] and returns how far outside it is
+func rangeStatus(v float64, min, max *float64) (string, float64) {
+	switch {
+	case min != nil && v < *min:
+		return "below", *min - v
+	case max != nil && v > *max:
+		return "above", v - *max
+	case min == nil && max == nil:
+		return "no_data", 0
+	}
+	return "inside", 0
+}
+
+// toleranceStatus places v relative to mean ± tol
+func toleranceStatus(v, mean, tol float64) (string, float64) {
+	switch {
+	case v < mean-tol:
+		return "below", mean - tol - v
+	case v > mean+tol:
+		return "above", v - (mean + tol)
+	}
+	return "inside", 0
+}
+
+func offset(p *float64, d float64) *float64 {
+	if p == nil {
+		return nil
+	}
+	v := *p + d
+	return &v
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// computeClimateDiagnostics breaks the climate match of env at loc into its
+// per-variable terms, weighted by weights (climateVariableWeights)
+func computeClimateDiagnostics(loc LocationInfo, env climateEnvelope, weights map[string]float64) *ClimateDiagnostics {
+	d := &ClimateDiagnostics{}
+	add := func(m ClimateVariableMatch) {
+		if m.Weight == 0 {
+			return
+		}
+		m.Score = round3(m.Score)
+		m.Deviation = round3(m.Deviation)
+		d.Variables = append(d.Variables, m)
+	}
+
+	// bio1: annual mean temperature
+	bio1 := ClimateVariableMatch{Variable: "bio1", Group: groupThermal, SiteValue: loc.Bio1, Weight: weights["bio1"],
+		EnvelopeMin: env.TempMin, EnvelopeMax: env.TempMax, EnvelopeMean: env.TempMean}
+	if env.TempMean == nil {
+		bio1.Status = "no_data"
+	} else {
+		bio1.Status, bio1.Deviation = rangeStatus(loc.Bio1, env.TempMin, env.TempMax)
+		bio1.Score = math.Max(0, 1-math.Abs(loc.Bio1-*env.TempMean)/10.0)
+	}
+	add(bio1)
+
+	// bio5 / bio6: hard limits on temperature extremes (±3 °C margin)
+	bio5 := ClimateVariableMatch{Variable: "bio5", Group: groupThermal, SiteValue: loc.Bio5, Weight: weights["bio5"],
+		EnvelopeMax: offset(env.TempMax, 3)}
+	bio6 := ClimateVariableMatch{Variable: "bio6", Group: groupThermal, SiteValue: loc.Bio6, Weight: weights["bio6"],
+		EnvelopeMin: offset(env.TempMin, -3)}
+	bio5.Status, bio5.Deviation = rangeStatus(loc.Bio5, nil, bio5.EnvelopeMax)
+	bio6.Status, bio6.Deviation = rangeStatus(loc.Bio6, bio6.EnvelopeMin, nil)
+	// A dropped limit does not rule the species out
+	if (bio5.Weight > 0 && bio5.Status == "above") || (bio6.Weight > 0 && bio6.Status == "below") {
+		d.HardLimitExceeded = true
+	} else {
+		bio5.Score, bio6.Score = 1, 1
+	}
+	add(bio5)
+	add(bio6)
+
+	// bio12: annual precipitation, tolerance relative to the envelope mean
+	bio12 := ClimateVariableMatch{Variable: "bio12", Group: groupHydrological, SiteValue: loc.Bio12, Weight: weights["bio12"],
+		EnvelopeMin: env.PrecipMin, EnvelopeMax: env.PrecipMax, EnvelopeMean: env.PrecipMean}
+	switch {
+	case env.PrecipMean == nil:
+		bio12.Status = "no_data"
+	case *env.PrecipMean > 0:
+		bio12.Status, bio12.Deviation = rangeStatus(loc.Bio12, env.PrecipMin, env.PrecipMax)
+		bio12.Score = math.Max(0, 1 - math.Abs(loc.Bio12-*env.PrecipMean) / *env.PrecipMean)
+	default:
+		bio12.Status = "no_data"
+		bio12.Score = 0.5 // Partial credit, as in calculate_climate_match
+	}
+	add(bio12)
+
+	// bio15: precipitation seasonality
+	bio15 := ClimateVariableMatch{Variable: "bio15", Group: groupHydrological, SiteValue: loc.Bio15, Weight: weights["bio15"],
+		EnvelopeMean: env.PrecipSeasonality}
+	if env.PrecipSeasonality == nil {
+		bio15.Status = "no_data"
+	} else {
+		bio15.Status, bio15.Deviation = toleranceStatus(loc.Bio15, *env.PrecipSeasonality, 50)
+		bio15.Score = math.Max(0, 1-math.Abs(loc.Bio15-*env.PrecipSeasonality)/50.0)
+	}
+	add(bio15)
+
+	// frost: cold hardiness, only relevant where bio6 is below zero
+	frost := ClimateVariableMatch{Variable: "frost", Group: groupThermal, SiteValue: loc.Bio6, Weight: weights["frost"],
+		EnvelopeMin: offset(env.ColdMonthMin, 2)}
+	switch {
+	case loc.Bio6 >= 0:
+		frost.Status, frost.Score = "inside", 1
+	case env.ColdMonthMin != nil && *env.ColdMonthMin < loc.Bio6-2:
+		frost.Status, frost.Score = "inside", 1
+	case env.ColdMonthMin == nil:
+		frost.Status, frost.Score = "no_data", 1.0/3
+	default:
+		// cold_month_min + 2 is the coldest bio6 the species is known to handle
+		frost.Status = "below"
+		frost.Deviation = *env.ColdMonthMin + 2 - loc.Bio6
+		frost.Score = 1.0 / 3
+	}
+	add(frost)
+
+	// Group summaries: weighted mean score, and which group lost more points
+	var weight, score, lost = map[string]float64{}, map[string]float64{}, map[string]float64{}
+	for _, v := range d.Variables {
+		weight[v.Group] += v.Weight
+		score[v.Group] += v.Weight * v.Score
+		lost[v.Group] += v.Weight * (1 - v.Score)
+	}
+	// A group whose variables were all dropped scores 0
+	if weight[groupThermal] > 0 {
+		d.ThermalScore = round3(score[groupThermal] / weight[groupThermal])
+	}
+	if weight[groupHydrological] > 0 {
+		d.HydrologicalScore = round3(score[groupHydrological] / weight[groupHydrological])
+	}
+	switch {
+	case d.HardLimitExceeded || lost[groupThermal] > lost[groupHydrological]:
+		d.LimitingGroup = groupThermal
+	case lost[groupHydrological] > 0:
+		d.LimitingGroup = groupHydrological
+	}
+
+	return d
+}
+
+// loadClimateEnvelopes reads the envelopes calculate_climate_match uses,
+// or for a robust mode those calculate_climate_match_envelope uses
+func (s *Server) loadClimateEnvelopes(ctx context.Context, ids []int64, mode string) (map[int64]climateEnvelope, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.species_id,
+		       CASE WHEN r.species_id IS NULL THEN e.temp_mean ELSE r.temp_mean END,
+		       CASE WHEN r.species_id IS NULL THEN e.temp_min ELSE r.temp_min END,
+		       CASE WHEN r.species_id IS NULL THEN e.temp_max ELSE r.temp_max END,
+		       CASE WHEN r.species_id IS NULL THEN e.precip_mean ELSE r.precip_mean END,
+		       CASE WHEN r.species_id IS NULL THEN e.precip_min ELSE r.precip_min END,
+		       CASE WHEN r.species_id IS NULL THEN e.precip_max ELSE r.precip_max END,
+		       CASE WHEN r.species_id IS NULL THEN e.precip_seasonality ELSE r.precip_seasonality END,
+		       CASE WHEN r.species_id IS NULL THEN e.cold_month_min ELSE r.cold_month_min END
+		FROM species_climate_envelope e
+		LEFT JOIN species_climate_envelope_robust r ON r.species_id = e.species_id AND r.method = $2
+		WHERE e.species_id = ANY($1)
+	`, pq.Array(ids), mode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	envelopes := make(map[int64]climateEnvelope)
+	for rows.Next() {
+		var id int64
+		var env climateEnvelope
+		if err := rows.Scan(&id, &env.TempMean, &env.TempMin, &env.TempMax,
+			&env.PrecipMean, &env.PrecipMin, &env.PrecipMax, &env.PrecipSeasonality,
+			&env.ColdMonthMin); err != nil {
+			return nil, err
+		}
+		envelopes[id] = env
+	}
+	return envelopes, rows.Err()
+}
+
+// attachClimateDiagnostics fills ClimateDiagnostics on every species in place
+func (s *Server) attachClimateDiagnostics(ctx context.Context, loc LocationInfo, species []SpeciesRecommendation, weights map[string]float64, mode string) error {
+	ids := make([]int64, len(species))
+	for i, sp := range species {
+		ids[i] = sp.SpeciesID
+	}
+
+	envelopes, err := s.loadClimateEnvelopes(ctx, ids, mode)
+	if err != nil {
+		return err
+	}
+	for i := range species {
+		species[i].ClimateDiagnostics = computeClimateDiagnostics(loc, envelopes[species[i].SpeciesID], weights)
+	}
+	return nil
+}
+
+const maxClimateMatchSpecies = 100
+
+type SpeciesClimateMatch struct {
+	SpeciesID          int64               `json:"species_id"`
+	CanonicalName      string              `json:"canonical_name"`
+	ClimateMatchScore  float64             `json:"climate_match_score"`
+	ClimateDiagnostics *ClimateDiagnostics `json:"climate_diagnostics"`
+}
+
+type ClimateMatchResponse struct {
+	LocationInfo LocationInfo          `json:"location_info"`
+	Species      []SpeciesClimateMatch `json:"species"`
+	QueryTime    string                `json:"query_time"`
+}
+
+// matchScore adds up the weighted per-variable scores, as
+// calculate_climate_match does
+func (d *ClimateDiagnostics) matchScore() float64 {
+	if d.HardLimitExceeded {
+		return 0
+	}
+	total := 0.0
+	for _, v := range d.Variables {
+		total += v.Weight * v.Score
+	}
+	return round3(total)
+}
+
+// parseSpeciesIDs reads a comma-separated species_id list
+func parseSpeciesIDs(value string, limit int) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid species_id: %s", part)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 || len(ids) > limit {
+		return nil, fmt.Errorf("provide 1 to %d species_id values", limit)
+	}
+	return ids, nil
+}
+
+// handleClimateMatch handles GET /api/climate/match?species_id=&tdwg_code=
+// (or state_code, lat and lon, or aoi_id), with optional envelope_mode
+func (s *Server) handleClimateMatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	ids, err := parseSpeciesIDs(q.Get("species_id"), maxClimateMatchSpecies)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	mode, err := parseEnvelopeMode(q.Get("envelope_mode"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	req := RecommendRequest{TDWGCode: q.Get("tdwg_code"), StateCode: q.Get("state_code"), AOIID: q.Get("aoi_id")}
+	if q.Get("lat") != "" || q.Get("lon") != "" {
+		lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
+		lon, err2 := strconv.ParseFloat(q.Get("lon"), 64)
+		if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			http.Error(w, `{"error": "Invalid lat/lon"}`, http.StatusBadRequest)
+			return
+		}
+		req.Latitude, req.Longitude = &lat, &lon
+	}
+	if req.TDWGCode == "" && req.StateCode == "" && req.Latitude == nil && req.AOIID == "" {
+		http.Error(w, `{"error": "Provide tdwg_code, state_code, lat and lon, or aoi_id"}`, http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	loc, err := s.resolveLocation(ctx, req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusNotFound)
+		return
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, canonical_name FROM species WHERE id = ANY($1) ORDER BY canonical_name`, pq.Array(ids))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	var species []SpeciesClimateMatch
+	for rows.Next() {
+		var sp SpeciesClimateMatch
+		if err := rows.Scan(&sp.SpeciesID, &sp.CanonicalName); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		species = append(species, sp)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if len(species) == 0 {
+		http.Error(w, `{"error": "Species not found"}`, http.StatusNotFound)
+		return
+	}
+
+	envelopes, err := s.loadClimateEnvelopes(ctx, ids, mode)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to load climate envelopes"}`, http.StatusInternalServerError)
+		return
+	}
+	for i := range species {
+		d := computeClimateDiagnostics(loc, envelopes[species[i].SpeciesID], defaultClimateVariableWeights)
+		species[i].ClimateDiagnostics = d
+		species[i].ClimateMatchScore = d.matchScore()
+	}
+
+	lang := requestLanguage(r)
+	setContentLanguage(w, lang)
+	s.localizeLocation(ctx, &loc, lang)
+	json.NewEncoder(w).Encode(ClimateMatchResponse{LocationInfo: loc, Species: species, QueryTime: time.Since(start).String()})
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func fp(v float64) *float64 { return &v }
+
+func TestClimateMatchScore(t *testing.T) {
+	env := climateEnvelope{
+		TempMean: fp(20), TempMin: fp(10), TempMax: fp(30),
+		PrecipMean: fp(1500), PrecipMin: fp(1000), PrecipMax: fp(2000),
+		PrecipSeasonality: fp(40), ColdMonthMin: fp(5),
+	}
+
+	// Matching site: only bio1 (25 vs 20 of ±10) and bio12 lose points
+	site := LocationInfo{Bio1: 25, Bio5: 31, Bio6: 9, Bio12: 1200, Bio15: 40}
+	d := computeClimateDiagnostics(site, env, defaultClimateVariableWeights)
+	want := 0.25*0.5 + 0.125 + 0.125 + 0.20*0.8 + 0.15 + 0.15
+	if got := d.matchScore(); got != round3(want) {
+		t.Errorf("matchScore = %v, want %v", got, round3(want))
+	}
+	if d.LimitingGroup != groupThermal {
+		t.Errorf("limiting group = %q, want thermal", d.LimitingGroup)
+	}
+
+	// Colder than the bio6 hard limit (10 - 3 °C)
+	site.Bio6 = 5
+	if d := computeClimateDiagnostics(site, env, defaultClimateVariableWeights); d.matchScore() != 0 || !d.HardLimitExceeded {
+		t.Errorf("hard limit: score %v, exceeded %v", d.matchScore(), d.HardLimitExceeded)
+	}
+}
+
+func TestParseSpeciesIDs(t *testing.T) {
+	ids, err := parseSpeciesIDs("3, 1,,2", 5)
+	if err != nil || !equalIDs(ids, []int64{3, 1, 2}) {
+		t.Errorf("parseSpeciesIDs = %v, %v", ids, err)
+	}
+	for _, bad := range []string{"", "1,x", "-4", "1,2,3,4,5,6"} {
+		if _, err := parseSpeciesIDs(bad, 5); err == nil {
+			t.Errorf("parseSpeciesIDs(%q): expected an error", bad)
+		}
+	}
+}
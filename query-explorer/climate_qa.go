@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// CLIMATE DATA QA
+// ============================================================================
+//
+// GET /api/admin/climate-qa samples random points inside each TDWG region,
+// reads worldclim_raster there and compares the sample means with the
+// region means in tdwg_climate, which every recommendation is scored
+// against. A loading error (a raster left in WorldClim 1.4 units of 0.1 °C,
+// a shifted or missing tile, an aggregation over the wrong polygon) skews
+// recommendations without failing anything; this makes it visible.
+//
+//	regions=BZS,BZL   only these regions (default: all)
+//	points=20         sample points per region (at most 100)
+//	seed=1            placement of the points, for repeatable runs
+//	all=true          list unflagged regions too
+//
+// A random sample mean differs from the pixel mean by chance, so tolerances
+// are loose: they are meant to catch errors, not small biases. Flags:
+//
+//	no_raster_data    no sample point hit raster data
+//	scale_factor      sample and stored values differ by about 10x
+//	mismatch          a variable differs beyond its tolerance
+//	outside_range     the stored mean lies outside the stored min/max
+
+const (
+	defaultClimateQAPoints = 20
+	maxClimateQAPoints     = 100
+)
+
+// climateQATolerance is the allowed gap between sample and stored means:
+// absolute, or for precipitation a share of the stored mean with a floor
+var climateQATolerance = map[string]struct{ Abs, Rel float64 }{
+	"bio1":  {Abs: 3},
+	"bio5":  {Abs: 3},
+	"bio6":  {Abs: 3},
+	"bio12": {Abs: 150, Rel: 0.3},
+	"bio15": {Abs: 15},
+}
+
+var climateQAVariables = []string{"bio1", "bio5", "bio6", "bio12", "bio15"}
+
+type ClimateQAVariable struct {
+	Stored  *float64 `json:"stored"`
+	Sampled *float64 `json:"sampled"`
+	Diff    *float64 `json:"diff"`
+}
+
+type ClimateQARegion struct {
+	TDWGCode  string                       `json:"tdwg_code"`
+	NPoints   int                          `json:"n_points"`
+	NSampled  int                          `json:"n_sampled"` // Points with raster data
+	Variables map[string]ClimateQAVariable `json:"variables"`
+	Flags     []string                     `json:"flags"`
+
+	// Stored range of bio1 and bio12 (outside_range)
+	bio1Min, bio1Max, bio12Min, bio12Max *float64
+}
+
+type ClimateQAResponse struct {
+	RegionsChecked  int               `json:"regions_checked"`
+	RegionsFlagged  int               `json:"regions_flagged"`
+	PointsPerRegion int               `json:"points_per_region"`
+	Seed            int               `json:"seed"`
+	Regions         []ClimateQARegion `json:"regions"`
+	QueryTime       string            `json:"query_time"`
+}
+
+// nearRatio reports whether a/b is within 15% of ratio
+func nearRatio(a, b, ratio float64) bool {
+	if b == 0 {
+		return false
+	}
+	return math.Abs(a/b/ratio-1) < 0.15
+}
+
+// assessClimateQA fills in the differences and flags of a region
+func assessClimateQA(region *ClimateQARegion) {
+	region.Flags = []string{}
+	if region.NSampled == 0 {
+		region.Flags = append(region.Flags, "no_raster_data")
+	}
+
+	scaled, mismatch := false, false
+	for _, name := range climateQAVariables {
+		v := region.Variables[name]
+		if v.Stored == nil || v.Sampled == nil {
+			continue
+		}
+		diff := math.Round((*v.Sampled-*v.Stored)*100) / 100
+		v.Diff = &diff
+		region.Variables[name] = v
+
+		// Near-zero temperatures make ratios meaningless
+		if math.Abs(*v.Stored) >= 1 && math.Abs(*v.Sampled) >= 1 &&
+			(nearRatio(*v.Sampled, *v.Stored, 10) || nearRatio(*v.Stored, *v.Sampled, 10)) {
+			scaled = true
+			continue
+		}
+		tol := climateQATolerance[name]
+		limit := math.Max(tol.Abs, tol.Rel*math.Abs(*v.Stored))
+		if math.Abs(diff) > limit {
+			mismatch = true
+		}
+	}
+	if scaled {
+		region.Flags = append(region.Flags, "scale_factor")
+	}
+	if mismatch {
+		region.Flags = append(region.Flags, "mismatch")
+	}
+
+	outside := func(mean, min, max *float64) bool {
+		return mean != nil && ((min != nil && *mean < *min) || (max != nil && *mean > *max))
+	}
+	if outside(region.Variables["bio1"].Stored, region.bio1Min, region.bio1Max) ||
+		outside(region.Variables["bio12"].Stored, region.bio12Min, region.bio12Max) {
+		region.Flags = append(region.Flags, "outside_range")
+	}
+}
+
+// handleClimateQA handles GET /api/admin/climate-qa
+func (s *Server) handleClimateQA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	points := defaultClimateQAPoints
+	if v := query.Get("points"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxClimateQAPoints {
+			http.Error(w, fmt.Sprintf(`{"error": "points must be between 1 and %d"}`, maxClimateQAPoints), http.StatusBadRequest)
+			return
+		}
+		points = n
+	}
+	seed := 1
+	if v := query.Get("seed"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, `{"error": "seed must be an integer"}`, http.StatusBadRequest)
+			return
+		}
+		seed = n
+	}
+	var regions []string
+	if v := query.Get("regions"); v != "" {
+		regions = normalizeRescoreRegions(strings.Split(v, ","))
+	}
+	includeAll := query.Get("all") == "true"
+
+	start := time.Now()
+	var regionFilter interface{}
+	if len(regions) > 0 {
+		regionFilter = pq.Array(regions)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		WITH pts AS (
+			SELECT t.level3_code AS code, d.path[1] AS k, d.geom AS pt
+			FROM tdwg_level3 t,
+			     LATERAL ST_Dump(ST_GeneratePoints(t.geom, $2, $3)) d
+			WHERE t.geom IS NOT NULL
+			  AND ($1::text[] IS NULL OR t.level3_code = ANY($1::text[]))
+		),
+		samples AS (
+			SELECT p.code, p.k,
+			       MAX(ST_Value(wr.rast, 1, p.pt, true)) FILTER (WHERE wr.bio_var = 'bio1') AS bio1,
+			       MAX(ST_Value(wr.rast, 1, p.pt, true)) FILTER (WHERE wr.bio_var = 'bio5') AS bio5,
+			       MAX(ST_Value(wr.rast, 1, p.pt, true)) FILTER (WHERE wr.bio_var = 'bio6') AS bio6,
+			       MAX(ST_Value(wr.rast, 1, p.pt, true)) FILTER (WHERE wr.bio_var = 'bio12') AS bio12,
+			       MAX(ST_Value(wr.rast, 1, p.pt, true)) FILTER (WHERE wr.bio_var = 'bio15') AS bio15
+			FROM pts p
+			LEFT JOIN worldclim_raster wr
+			  ON wr.bio_var IN ('bio1', 'bio5', 'bio6', 'bio12', 'bio15') AND ST_Intersects(wr.rast, p.pt)
+			GROUP BY p.code, p.k
+		)
+		SELECT tc.tdwg_code, COUNT(sm.k), COUNT(sm.bio1),
+		       tc.bio1_mean, AVG(sm.bio1), tc.bio5_mean, AVG(sm.bio5), tc.bio6_mean, AVG(sm.bio6),
+		       tc.bio12_mean, AVG(sm.bio12), tc.bio15_mean, AVG(sm.bio15),
+		       tc.bio1_min, tc.bio1_max, tc.bio12_min, tc.bio12_max
+		FROM tdwg_climate tc
+		LEFT JOIN samples sm ON sm.code = tc.tdwg_code
+		WHERE ($1::text[] IS NULL OR tc.tdwg_code = ANY($1::text[]))
+		GROUP BY tc.tdwg_code, tc.bio1_mean, tc.bio5_mean, tc.bio6_mean, tc.bio12_mean, tc.bio15_mean,
+		         tc.bio1_min, tc.bio1_max, tc.bio12_min, tc.bio12_max
+		ORDER BY tc.tdwg_code
+	`, regionFilter, points, seed)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := ClimateQAResponse{PointsPerRegion: points, Seed: seed, Regions: []ClimateQARegion{}}
+	for rows.Next() {
+		region := ClimateQARegion{Variables: map[string]ClimateQAVariable{}}
+		vars := make([]ClimateQAVariable, len(climateQAVariables))
+		var sampled [5]sql.NullFloat64
+		if err := rows.Scan(&region.TDWGCode, &region.NPoints, &region.NSampled,
+			&vars[0].Stored, &sampled[0], &vars[1].Stored, &sampled[1], &vars[2].Stored, &sampled[2],
+			&vars[3].Stored, &sampled[3], &vars[4].Stored, &sampled[4],
+			&region.bio1Min, &region.bio1Max, &region.bio12Min, &region.bio12Max); err != nil {
+			s.log.Printf("Error scanning climate QA row: %v", err)
+			continue
+		}
+		for i, name := range climateQAVariables {
+			if sampled[i].Valid {
+				mean := math.Round(sampled[i].Float64*100) / 100
+				vars[i].Sampled = &mean
+			}
+			region.Variables[name] = vars[i]
+		}
+		assessClimateQA(&region)
+
+		resp.RegionsChecked++
+		if len(region.Flags) > 0 {
+			resp.RegionsFlagged++
+		}
+		if includeAll || len(region.Flags) > 0 {
+			resp.Regions = append(resp.Regions, region)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	resp.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func qaRegion(sampled int, stored, sample [5]float64) *ClimateQARegion {
+	region := &ClimateQARegion{TDWGCode: "BZS", NPoints: 20, NSampled: sampled, Variables: map[string]ClimateQAVariable{}}
+	for i, name := range climateQAVariables {
+		st, sm := stored[i], sample[i]
+		v := ClimateQAVariable{Stored: &st}
+		if sampled > 0 {
+			v.Sampled = &sm
+		}
+		region.Variables[name] = v
+	}
+	return region
+}
+
+func TestAssessClimateQA(t *testing.T) {
+	stored := [5]float64{19.5, 28, 9, 1500, 40}
+	cases := []struct {
+		name    string
+		sampled int
+		sample  [5]float64
+		want    string
+	}{
+		{"agreeing", 20, [5]float64{20.4, 27, 10, 1380, 45}, ""},
+		{"no raster data", 0, [5]float64{}, "no_raster_data"},
+		{"tenths of a degree", 20, [5]float64{195, 280, 90, 1500, 40}, "scale_factor"},
+		{"shifted temperature", 20, [5]float64{14, 28, 9, 1500, 40}, "mismatch"},
+		{"precipitation off by half", 20, [5]float64{19.5, 28, 9, 750, 40}, "mismatch"},
+	}
+	for _, c := range cases {
+		region := qaRegion(c.sampled, stored, c.sample)
+		assessClimateQA(region)
+		if got := strings.Join(region.Flags, ","); got != c.want {
+			t.Errorf("%s: flags %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	region := qaRegion(20, stored, [5]float64{20.4, 27, 10, 1380, 45})
+	assessClimateQA(region)
+	if d := region.Variables["bio12"].Diff; d == nil || *d != -120 {
+		t.Errorf("bio12 diff %v, want -120", d)
+	}
+}
+
+func TestAssessClimateQAStoredRange(t *testing.T) {
+	stored := [5]float64{19.5, 28, 9, 1500, 40}
+	region := qaRegion(20, stored, stored)
+	lo, hi := 21.0, 25.0
+	region.bio1Min, region.bio1Max = &lo, &hi
+	assessClimateQA(region)
+	if len(region.Flags) != 1 || region.Flags[0] != "outside_range" {
+		t.Errorf("flags %v, want outside_range", region.Flags)
+	}
+}
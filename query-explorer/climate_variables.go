@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// CLIMATE VARIABLE SELECTION
+// ============================================================================
+//
+// By default the climate match weighs bio1, bio5, bio6, bio12, bio15 and
+// frost as calculate_climate_match does (see climate_match.go). A request
+// may list the variables that should count instead, with relative weights,
+// in climate_variables: {"bio1": 1, "bio5": 0.5, "bio6": 0.5, "bio12": 1}
+// ignores precipitation seasonality and frost, e.g. for an irrigated site.
+// Unlisted variables are dropped; a dropped bio5 or bio6 no longer rules
+// species out. The weights are normalized to sum to 1 and used by both the
+// SQL matcher (calculate_climate_match_weighted, migration 031) and the
+// per-variable diagnostics.
+
+// climateVariables lists the matched variables in the order of the
+// p_weights argument of calculate_climate_match_weighted
+var climateVariables = []string{"bio1", "bio5", "bio6", "bio12", "bio15", "frost"}
+
+var defaultClimateVariableWeights = map[string]float64{
+	"bio1": 0.25, "bio5": 0.125, "bio6": 0.125, "bio12": 0.20, "bio15": 0.15, "frost": 0.15,
+}
+
+// normalizeClimateVariables validates req.ClimateVariables and scales the
+// weights to sum to 1
+func normalizeClimateVariables(req *RecommendRequest) error {
+	if len(req.ClimateVariables) == 0 {
+		req.ClimateVariables = nil
+		return nil
+	}
+	sum := 0.0
+	for name, w := range req.ClimateVariables {
+		if _, ok := defaultClimateVariableWeights[name]; !ok {
+			return fmt.Errorf("unknown climate variable %q (one of %s)", name, strings.Join(climateVariables, ", "))
+		}
+		if math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+			return fmt.Errorf("climate_variables.%s must be a non-negative weight", name)
+		}
+		sum += w
+	}
+	if sum == 0 {
+		return fmt.Errorf("climate_variables needs at least one variable with a positive weight")
+	}
+	weights := make(map[string]float64, len(req.ClimateVariables))
+	for name, w := range req.ClimateVariables {
+		if w > 0 {
+			weights[name] = w / sum
+		}
+	}
+	req.ClimateVariables = weights
+	return nil
+}
+
+// climateVariableWeights returns the weight of every variable for req,
+// 0 for dropped ones
+func (r *RecommendRequest) climateVariableWeights() map[string]float64 {
+	if r.ClimateVariables == nil {
+		return defaultClimateVariableWeights
+	}
+	weights := make(map[string]float64, len(climateVariables))
+	for _, name := range climateVariables {
+		weights[name] = r.ClimateVariables[name]
+	}
+	return weights
+}
+
+// climateMatchSQL returns the climate match expression for species column
+// col, with the site's bio1, bio5, bio6, bio12 and bio15 at placeholders
+// $1 to $5. Custom weights, and the envelope mode when robust, are
+// appended to args.
+func climateMatchSQL(req RecommendRequest, col string, args []interface{}) (string, []interface{}) {
+	if req.ClimateVariables == nil && req.EnvelopeMode == "" {
+		return fmt.Sprintf("calculate_climate_match(%s, $1, $2, $3, $4, $5)", col), args
+	}
+	weights := req.climateVariableWeights()
+	ordered := make([]float64, len(climateVariables))
+	for i, name := range climateVariables {
+		ordered[i] = weights[name]
+	}
+	args = append(args, pq.Array(ordered))
+	if req.EnvelopeMode != "" {
+		args = append(args, req.EnvelopeMode)
+		return fmt.Sprintf("calculate_climate_match_envelope(%s, $1, $2, $3, $4, $5, $%d, $%d)", col, len(args)-1, len(args)), args
+	}
+	return fmt.Sprintf("calculate_climate_match_weighted(%s, $1, $2, $3, $4, $5, $%d)", col, len(args)), args
+}
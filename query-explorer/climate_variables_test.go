@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeClimateVariables(t *testing.T) {
+	req := RecommendRequest{ClimateVariables: map[string]float64{"bio1": 2, "bio12": 1, "bio15": 1, "frost": 0}}
+	if err := normalizeClimateVariables(&req); err != nil {
+		t.Fatal(err)
+	}
+	weights := req.climateVariableWeights()
+	want := map[string]float64{"bio1": 0.5, "bio5": 0, "bio6": 0, "bio12": 0.25, "bio15": 0.25, "frost": 0}
+	for name, w := range want {
+		if math.Abs(weights[name]-w) > 1e-9 {
+			t.Errorf("%s weight = %v, want %v", name, weights[name], w)
+		}
+	}
+
+	for _, vars := range []map[string]float64{
+		{"bio7": 1},
+		{"bio1": -1},
+		{"bio1": 0, "bio5": 0},
+	} {
+		req := RecommendRequest{ClimateVariables: vars}
+		if err := normalizeClimateVariables(&req); err == nil {
+			t.Errorf("normalizeClimateVariables(%v): expected an error", vars)
+		}
+	}
+}
+
+func TestClimateMatchSQL(t *testing.T) {
+	args := make([]interface{}, 7)
+	expr, got := climateMatchSQL(RecommendRequest{}, "s.id", args)
+	if expr != "calculate_climate_match(s.id, $1, $2, $3, $4, $5)" || len(got) != 7 {
+		t.Errorf("default: %s with %d args", expr, len(got))
+	}
+
+	req := RecommendRequest{ClimateVariables: map[string]float64{"bio1": 1}}
+	expr, got = climateMatchSQL(req, "s.id", args)
+	if !strings.HasPrefix(expr, "calculate_climate_match_weighted(s.id, $1, $2, $3, $4, $5, $8)") || len(got) != 8 {
+		t.Errorf("weighted: %s with %d args", expr, len(got))
+	}
+
+	expr, got = climateMatchSQL(RecommendRequest{EnvelopeMode: envelopeKDE}, "s.id", args)
+	if expr != "calculate_climate_match_envelope(s.id, $1, $2, $3, $4, $5, $8, $9)" || len(got) != 9 || got[8] != envelopeKDE {
+		t.Errorf("kde: %s with %d args", expr, len(got))
+	}
+}
+
+func TestClimateDiagnosticsWeights(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	// Colder than the species' known minimum, so bio6 rules it out
+	loc := LocationInfo{Bio1: 20, Bio5: 30, Bio6: 2, Bio12: 1200, Bio15: 60}
+	env := climateEnvelope{TempMean: f(24), TempMin: f(10), TempMax: f(30), PrecipMean: f(1500), PrecipSeasonality: f(40), ColdMonthMin: f(8)}
+
+	d := computeClimateDiagnostics(loc, env, defaultClimateVariableWeights)
+	if !d.HardLimitExceeded || len(d.Variables) != 6 {
+		t.Errorf("default weights: hard limit %v, %d variables", d.HardLimitExceeded, len(d.Variables))
+	}
+
+	req := RecommendRequest{ClimateVariables: map[string]float64{"bio1": 1, "bio12": 1}}
+	if err := normalizeClimateVariables(&req); err != nil {
+		t.Fatal(err)
+	}
+	d = computeClimateDiagnostics(loc, env, req.climateVariableWeights())
+	if d.HardLimitExceeded {
+		t.Error("dropped bio6 still rules the species out")
+	}
+	if len(d.Variables) != 2 || d.Variables[0].Variable != "bio1" || d.Variables[1].Variable != "bio12" {
+		t.Errorf("variables: %+v", d.Variables)
+	}
+	sum := 0.0
+	for _, v := range d.Variables {
+		sum += v.Weight
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("weights sum to %v", sum)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// RESTORATION COMPLIANCE
+// ============================================================================
+//
+// Checks a species list against the composition rules of a state regulation
+// (compliance_rule_sets, migration 021) before planting. Without quantities
+// every species is assumed to contribute the same number of individuals.
+
+// ComplianceRules are the configurable composition rules; nil means unchecked
+type ComplianceRules struct {
+	MinSpecies            *int     `json:"min_species,omitempty"`
+	MinNativeSpecies      *int     `json:"min_native_species,omitempty"`
+	MinNativeProportion   *float64 `json:"min_native_proportion,omitempty"`    // Share of species (0-1)
+	MaxSingleSpeciesShare *float64 `json:"max_single_species_share,omitempty"` // Share of individuals (0-1)
+	MaxInvasiveSpecies    *int     `json:"max_invasive_species,omitempty"`
+	MinFamilies           *int     `json:"min_families,omitempty"`
+	MinThreatenedSpecies  *int     `json:"min_threatened_species,omitempty"` // IUCN CR, EN or VU
+}
+
+type ComplianceRuleSet struct {
+	Code      string          `json:"code"`
+	Name      string          `json:"name"`
+	Reference *string         `json:"reference,omitempty"`
+	Rules     ComplianceRules `json:"rules"`
+}
+
+type ComplianceCheck struct {
+	Rule     string  `json:"rule"`
+	Required float64 `json:"required"`
+	Actual   float64 `json:"actual"`
+	Passed   bool    `json:"passed"`
+	Message  string  `json:"message,omitempty"`
+}
+
+type ComplianceReport struct {
+	RuleSet   string            `json:"rule_set"`
+	Name      string            `json:"name"`
+	Reference *string           `json:"reference,omitempty"`
+	Compliant bool              `json:"compliant"`
+	Checks    []ComplianceCheck `json:"checks"`
+	Notes     []string          `json:"notes,omitempty"`
+}
+
+var threatenedStatuses = map[string]bool{"CR": true, "EN": true, "VU": true}
+
+// loadComplianceRuleSet returns the rule set for code, falling back to
+// 'default' when the state has none configured
+func (s *Server) loadComplianceRuleSet(ctx context.Context, code string) (*ComplianceRuleSet, error) {
+	var rs ComplianceRuleSet
+	var rules []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT code, name, reference, rules
+		FROM compliance_rule_sets
+		WHERE code IN ($1, 'default')
+		ORDER BY code = 'default'
+		LIMIT 1
+	`, strings.ToUpper(code)).Scan(&rs.Code, &rs.Name, &rs.Reference, &rules)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rules, &rs.Rules); err != nil {
+		return nil, fmt.Errorf("invalid rules for %s: %w", rs.Code, err)
+	}
+	return &rs, nil
+}
+
+// evaluateCompliance checks species against rs. quantities maps species ID
+// to number of individuals and may be nil.
+func evaluateCompliance(rs *ComplianceRuleSet, species []SpeciesRecommendation, quantities map[int64]int) *ComplianceReport {
+	report := &ComplianceReport{RuleSet: rs.Code, Name: rs.Name, Reference: rs.Reference, Compliant: true, Checks: []ComplianceCheck{}}
+	rules := rs.Rules
+
+	nNative, nInvasive, nThreatened := 0, 0, 0
+	families := map[string]bool{}
+	for _, sp := range species {
+		if sp.IsNative {
+			nNative++
+		}
+		if sp.EstablishmentMeans != nil && *sp.EstablishmentMeans == "invasive" {
+			nInvasive++
+		}
+		if sp.ThreatStatus != nil && threatenedStatuses[strings.ToUpper(*sp.ThreatStatus)] {
+			nThreatened++
+		}
+		families[sp.Family] = true
+	}
+
+	check := func(rule string, required, actual float64, passed bool, msg string) {
+		c := ComplianceCheck{Rule: rule, Required: required, Actual: round3(actual), Passed: passed}
+		if !passed {
+			c.Message = msg
+			report.Compliant = false
+		}
+		report.Checks = append(report.Checks, c)
+	}
+	atLeast := func(rule string, min *int, actual int, what string) {
+		if min != nil {
+			check(rule, float64(*min), float64(actual), actual >= *min,
+				fmt.Sprintf("%d %s, at least %d required", actual, what, *min))
+		}
+	}
+
+	atLeast("min_species", rules.MinSpecies, len(species), "species")
+	atLeast("min_native_species", rules.MinNativeSpecies, nNative, "native species")
+	atLeast("min_families", rules.MinFamilies, len(families), "families")
+	atLeast("min_threatened_species", rules.MinThreatenedSpecies, nThreatened, "threatened species")
+
+	if rules.MinNativeProportion != nil {
+		share := 0.0
+		if len(species) > 0 {
+			share = float64(nNative) / float64(len(species))
+		}
+		check("min_native_proportion", *rules.MinNativeProportion, share, share >= *rules.MinNativeProportion,
+			fmt.Sprintf("%.0f%% of species are native, at least %.0f%% required", share*100, *rules.MinNativeProportion*100))
+	}
+
+	if rules.MaxInvasiveSpecies != nil {
+		max := *rules.MaxInvasiveSpecies
+		check("max_invasive_species", float64(max), float64(nInvasive), nInvasive <= max,
+			fmt.Sprintf("%d invasive species, at most %d allowed", nInvasive, max))
+	}
+
+	if rules.MaxSingleSpeciesShare != nil && len(species) > 0 {
+		maxShare, dominant := 0.0, ""
+		if quantities == nil {
+			maxShare = 1 / float64(len(species))
+			report.Notes = append(report.Notes, "No quantities given: equal numbers of individuals per species assumed")
+		} else {
+			total := 0
+			for _, sp := range species {
+				total += quantities[sp.SpeciesID]
+			}
+			for _, sp := range species {
+				if total > 0 {
+					if share := float64(quantities[sp.SpeciesID]) / float64(total); share > maxShare {
+						maxShare, dominant = share, sp.CanonicalName
+					}
+				}
+			}
+		}
+		msg := fmt.Sprintf("a single species makes up %.0f%% of individuals, at most %.0f%% allowed", maxShare*100, *rules.MaxSingleSpeciesShare*100)
+		if dominant != "" {
+			msg = fmt.Sprintf("%s makes up %.0f%% of individuals, at most %.0f%% allowed", dominant, maxShare*100, *rules.MaxSingleSpeciesShare*100)
+		}
+		check("max_single_species_share", *rules.MaxSingleSpeciesShare, maxShare, maxShare <= *rules.MaxSingleSpeciesShare, msg)
+	}
+
+	return report
+}
+
+// complianceCode picks the rule set for a request: explicit code, else the
+// request's state
+func complianceCode(explicit, stateCode string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if stateCode != "" {
+		return stateCode
+	}
+	return "default"
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+type ComplianceCheckRequest struct {
+	TDWGCode  string `json:"tdwg_code,omitempty"`
+	StateCode string `json:"state_code,omitempty"`
+	AOIID     string `json:"aoi_id,omitempty"`
+	RuleSet   string `json:"rule_set,omitempty"` // Default: state_code, else 'default'
+	Species   []struct {
+		SpeciesID int64 `json:"species_id"`
+		Quantity  *int  `json:"quantity,omitempty"`
+	} `json:"species"`
+}
+
+// handleComplianceCheck handles POST /api/compliance/check
+func (s *Server) handleComplianceCheck(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ComplianceCheckRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Species) == 0 {
+		http.Error(w, `{"error": "species required"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Nativeness is relative to the site's TDWG unit
+	location, err := s.resolveLocationClimate(ctx, RecommendRequest{TDWGCode: req.TDWGCode, StateCode: req.StateCode, AOIID: req.AOIID})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ids := make([]int64, len(req.Species))
+	var quantities map[int64]int
+	for i, sp := range req.Species {
+		ids[i] = sp.SpeciesID
+		if sp.Quantity != nil {
+			if quantities == nil {
+				quantities = make(map[int64]int)
+			}
+			quantities[sp.SpeciesID] += *sp.Quantity
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.canonical_name, COALESCE(s.family, 'Unknown'),
+		       COALESCE(su.growth_form, 'unknown'), su.threat_status,
+		       COALESCE(sr.is_native, false), sr.establishment_means::text
+		FROM species s
+		LEFT JOIN species_unified su ON s.id = su.species_id
+		`+regionStatusJoin("s.id", "$2")+`
+		WHERE s.id = ANY($1)
+	`, pq.Array(ids), pq.Array(location.regionCodes()))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var species []SpeciesRecommendation
+	for rows.Next() {
+		var sp SpeciesRecommendation
+		if err := rows.Scan(&sp.SpeciesID, &sp.CanonicalName, &sp.Family, &sp.GrowthForm,
+			&sp.ThreatStatus, &sp.IsNative, &sp.EstablishmentMeans); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		species = append(species, sp)
+	}
+	if len(species) != len(uniqueIDs(ids)) {
+		http.Error(w, `{"error": "unknown species_id in list"}`, http.StatusBadRequest)
+		return
+	}
+
+	rs, err := s.loadComplianceRuleSet(ctx, complianceCode(req.RuleSet, req.StateCode))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "failed to load rule set: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(evaluateCompliance(rs, species, quantities))
+}
+
+func uniqueIDs(ids []int64) map[int64]bool {
+	set := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// handleComplianceRules handles GET /api/compliance/rules
+func (s *Server) handleComplianceRules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	rows, err := s.db.QueryContext(ctx, `SELECT code, name, reference, rules FROM compliance_rule_sets ORDER BY code`)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sets := []ComplianceRuleSet{}
+	for rows.Next() {
+		var rs ComplianceRuleSet
+		var rules []byte
+		if err := rows.Scan(&rs.Code, &rs.Name, &rs.Reference, &rules); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		json.Unmarshal(rules, &rs.Rules)
+		sets = append(sets, rs)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"rule_sets": sets})
+}
+
+// handleAdminComplianceRules handles PUT /api/admin/compliance/rules/{code}
+func (s *Server) handleAdminComplianceRules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPut {
+		http.Error(w, `{"error": "PUT required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := s.requireRole(w, r, roleAdmin)
+	if !ok {
+		return
+	}
+
+	code := strings.ToUpper(pathParam(r, "code"))
+	if code == "DEFAULT" {
+		code = "default"
+	}
+	if code == "" || len(code) > 20 {
+		http.Error(w, `{"error": "rule set code required"}`, http.StatusBadRequest)
+		return
+	}
+
+	var rs ComplianceRuleSet
+	if !decodeJSONBody(w, r, &rs) {
+		return
+	}
+	if strings.TrimSpace(rs.Name) == "" {
+		http.Error(w, `{"error": "name required"}`, http.StatusBadRequest)
+		return
+	}
+	for field, share := range map[string]*float64{
+		"min_native_proportion":    rs.Rules.MinNativeProportion,
+		"max_single_species_share": rs.Rules.MaxSingleSpeciesShare,
+	} {
+		if share != nil && (*share < 0 || *share > 1) {
+			http.Error(w, fmt.Sprintf(`{"error": "%s must be between 0 and 1"}`, field), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rules, _ := json.Marshal(rs.Rules)
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO compliance_rule_sets (code, name, reference, rules, updated_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (code) DO UPDATE
+		SET name = EXCLUDED.name,
+		    reference = EXCLUDED.reference,
+		    rules = EXCLUDED.rules,
+		    updated_by = EXCLUDED.updated_by
+	`, code, rs.Name, rs.Reference, rules, key.ID)
+	if err != nil {
+		s.log.Printf("Error saving compliance rules: %v", err)
+		http.Error(w, `{"error": "Failed to save rule set"}`, http.StatusInternalServerError)
+		return
+	}
+
+	rs.Code = code
+	json.NewEncoder(w).Encode(rs)
+}
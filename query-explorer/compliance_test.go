@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestEvaluateCompliance(t *testing.T) {
+	minSpecies, maxInvasive := 3, 0
+	minNative, maxShare := 0.5, 0.5
+	rs := &ComplianceRuleSet{Code: "BR-XX", Rules: ComplianceRules{
+		MinSpecies:            &minSpecies,
+		MinNativeProportion:   &minNative,
+		MaxInvasiveSpecies:    &maxInvasive,
+		MaxSingleSpeciesShare: &maxShare,
+	}}
+	invasive := "invasive"
+	species := []SpeciesRecommendation{
+		{SpeciesID: 1, CanonicalName: "A", IsNative: true},
+		{SpeciesID: 2, CanonicalName: "B", IsNative: true},
+		{SpeciesID: 3, CanonicalName: "C", EstablishmentMeans: &invasive},
+	}
+
+	failed := func(report *ComplianceReport) map[string]bool {
+		out := map[string]bool{}
+		for _, c := range report.Checks {
+			if !c.Passed {
+				out[c.Rule] = true
+			}
+		}
+		return out
+	}
+
+	// Equal shares: 1/3 per species, only the invasive rule fails
+	report := evaluateCompliance(rs, species, nil)
+	if report.Compliant || len(failed(report)) != 1 || !failed(report)["max_invasive_species"] {
+		t.Errorf("equal shares: failed = %v", failed(report))
+	}
+	if len(report.Notes) == 0 {
+		t.Error("equal shares assumption not noted")
+	}
+
+	// Quantities: species A dominates
+	report = evaluateCompliance(rs, species[:2], map[int64]int{1: 80, 2: 20})
+	if got := failed(report); !got["max_single_species_share"] || !got["min_species"] || got["min_native_proportion"] {
+		t.Errorf("quantities: failed = %v", got)
+	}
+
+	report = evaluateCompliance(rs, species[:2], map[int64]int{1: 50, 2: 50})
+	if got := failed(report); len(got) != 1 || !got["min_species"] {
+		t.Errorf("balanced: failed = %v", got)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+)
+
+// ============================================================================
+// COORDINATE GENERALIZATION
+// ============================================================================
+//
+// Exact localities of threatened species help collectors and loggers find
+// them. Following biodiversity data sensitivity practice (as GBIF and
+// SiBBr do), observations of species assessed CR, EN or VU, and any
+// observation submitted with generalize_coordinates: true, are stored with
+// their coordinates moved to the center of a grid cell (OBSERVATION_COORDINATE_GRID
+// degrees, default 0.1, about 11 km). The TDWG region and ecoregion are
+// resolved from the exact point first, so distribution updates stay
+// accurate; coordinate_uncertainty_m grows to cover the cell, and
+// coordinate_grid_deg records the grid so consumers know the point is not
+// the locality. The exact coordinates are never stored. A threat status
+// that cannot be looked up counts as threatened, so locations fail closed.
+//
+// The same grid applies to the other places a locality is kept or shown:
+// the cached recommendations that include threatened species store the
+// site generalized (location_lat/location_lon and the stored response), and
+// the public widget API (public.go) snaps every point to its cell center;
+// GBIF occurrences of threatened species count in /api/species/within by
+// their cell center (species_within.go); uploaded photos lose their EXIF
+// position (photo_metadata.go).
+
+const defaultCoordinateGridDeg = 0.1
+
+// coordinatePrivacy configures coordinate generalization
+type coordinatePrivacy struct {
+	GridDeg              float64
+	GeneralizeThreatened bool // Generalize threatened species without being asked
+}
+
+func loadCoordinatePrivacy() coordinatePrivacy {
+	c := coordinatePrivacy{
+		GridDeg:              defaultCoordinateGridDeg,
+		GeneralizeThreatened: getEnv("OBSERVATION_GENERALIZE_THREATENED", "true") == "true",
+	}
+	if value, ok := os.LookupEnv("OBSERVATION_COORDINATE_GRID"); ok {
+		grid, err := strconv.ParseFloat(value, 64)
+		if err != nil || grid <= 0 || grid > 1 {
+			log.Printf("Invalid OBSERVATION_COORDINATE_GRID=%q, using %g", value, defaultCoordinateGridDeg)
+		} else {
+			c.GridDeg = grid
+		}
+	}
+	return c
+}
+
+// generalizes reports whether a locality of a (possibly) threatened species
+// is generalized
+func (c coordinatePrivacy) generalizes(requested, threatened bool) bool {
+	return requested || (c.GeneralizeThreatened && threatened)
+}
+
+// speciesThreatened reports whether the species is assessed CR, EN or VU;
+// true when the lookup fails
+func (s *Server) speciesThreatened(ctx context.Context, speciesID int64) bool {
+	var status sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT threat_status FROM species_unified WHERE species_id = $1`, speciesID).Scan(&status)
+	if err != nil && err != sql.ErrNoRows {
+		s.log.Printf("Threat status of species %d unknown (%v), generalizing", speciesID, err)
+		return true
+	}
+	return threatenedStatuses[status.String]
+}
+
+// includesThreatened reports whether any of the species is assessed CR, EN
+// or VU
+func includesThreatened(species []SpeciesRecommendation) bool {
+	for _, sp := range species {
+		if sp.ThreatStatus != nil && threatenedStatuses[*sp.ThreatStatus] {
+			return true
+		}
+	}
+	return false
+}
+
+// generalizePoint is generalizeCoordinates for optional coordinates
+func generalizePoint(lat, lon *float64, gridDeg float64) (*float64, *float64) {
+	if lat == nil || lon == nil {
+		return lat, lon
+	}
+	glat, glon := generalizeCoordinates(*lat, *lon, gridDeg)
+	return &glat, &glon
+}
+
+// generalizeCoordinates moves lat/lon to the center of their grid cell
+func generalizeCoordinates(lat, lon, gridDeg float64) (float64, float64) {
+	center := func(v, limit float64) float64 {
+		c := (math.Floor(v/gridDeg) + 0.5) * gridDeg
+		c = math.Max(-limit, math.Min(limit, c))
+		return math.Round(c*1e6) / 1e6 // The columns keep 6 decimals
+	}
+	return center(lat, 90), center(lon, 180)
+}
+
+// cellCenterSQL is generalizeCoordinates in SQL: the point at the center
+// of the grid cell of the lat/lon expressions
+func cellCenterSQL(lat, lon string, gridDeg float64) string {
+	return fmt.Sprintf("ST_SetSRID(ST_MakePoint((FLOOR((%[2]s) / %[3]g) + 0.5) * %[3]g, (FLOOR((%[1]s) / %[3]g) + 0.5) * %[3]g), 4326)",
+		lat, lon, gridDeg)
+}
+
+// generalizedUncertaintyM is the uncertainty of a point moved to its cell
+// center: the larger of the original one and the cell's half diagonal
+// (measured at the equator, where cells are widest)
+func generalizedUncertaintyM(uncertaintyM *int, gridDeg float64) *int {
+	const metersPerDegree = 111320.0
+	m := int(math.Ceil(gridDeg * metersPerDegree * math.Sqrt2 / 2))
+	if uncertaintyM != nil && *uncertaintyM > m {
+		m = *uncertaintyM
+	}
+	return &m
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestGeneralizeCoordinates(t *testing.T) {
+	tests := []struct {
+		lat, lon, grid   float64
+		wantLat, wantLon float64
+	}{
+		{-23.5617, -46.6559, 0.1, -23.55, -46.65},
+		{-23.5, -46.6, 0.1, -23.45, -46.55},
+		{10.01, 20.99, 0.5, 10.25, 20.75},
+		{89.99, 179.99, 1, 89.5, 179.5},
+	}
+	for _, tt := range tests {
+		lat, lon := generalizeCoordinates(tt.lat, tt.lon, tt.grid)
+		if lat != tt.wantLat || lon != tt.wantLon {
+			t.Errorf("generalizeCoordinates(%v, %v, %v) = %v, %v, want %v, %v", tt.lat, tt.lon, tt.grid, lat, lon, tt.wantLat, tt.wantLon)
+		}
+	}
+}
+
+func TestGeneralizedUncertainty(t *testing.T) {
+	if got := *generalizedUncertaintyM(nil, 0.1); got != 7872 {
+		t.Errorf("0.1° cell: %d m, want 7872", got)
+	}
+	larger := 20000
+	if got := *generalizedUncertaintyM(&larger, 0.1); got != larger {
+		t.Errorf("larger original uncertainty replaced: %d", got)
+	}
+}
+
+func TestCoordinatePrivacyGeneralizes(t *testing.T) {
+	c := coordinatePrivacy{GridDeg: 0.1, GeneralizeThreatened: true}
+	if !c.generalizes(false, true) || c.generalizes(false, false) || !c.generalizes(true, false) {
+		t.Error("threatened species or explicit requests not generalized")
+	}
+	c.GeneralizeThreatened = false
+	if c.generalizes(false, true) {
+		t.Error("threatened species generalized with OBSERVATION_GENERALIZE_THREATENED=false")
+	}
+}
+
+func TestIncludesThreatened(t *testing.T) {
+	vu, lc := "VU", "LC"
+	if includesThreatened([]SpeciesRecommendation{{ThreatStatus: &lc}, {}}) {
+		t.Error("LC and unassessed species counted as threatened")
+	}
+	if !includesThreatened([]SpeciesRecommendation{{ThreatStatus: &lc}, {ThreatStatus: &vu}}) {
+		t.Error("VU species not counted")
+	}
+
+	lat, lon := -23.5617, -46.6559
+	glat, glon := generalizePoint(&lat, &lon, 0.1)
+	if *glat != -23.55 || *glon != -46.65 || lat != -23.5617 {
+		t.Errorf("generalizePoint = %v, %v (input %v)", *glat, *glon, lat)
+	}
+	if glat, glon := generalizePoint(nil, &lon, 0.1); glat != nil || glon != &lon {
+		t.Error("partial coordinates changed")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ============================================================================
+// BULK COPY EXPORT
+// ============================================================================
+//
+// The largest tables are exported with COPY ... TO STDOUT instead of scanning
+// rows one by one through database/sql: Postgres formats the CSV itself and
+// the bytes go straight to the client. lib/pq cannot read COPY output, so
+// copyTo opens its own connection with pgconn (pgx's low-level driver).
+//
+// Exports are ordered by a unique integer key; ?after_id= resumes an
+// interrupted download after the last ID received.
+
+// copyExport is a named export: a SELECT with a %d placeholder for the
+// after_id bound, ordered by that key
+type copyExport struct {
+	Query string
+}
+
+var copyExports = map[string]copyExport{
+	"species_regions": {`
+		SELECT sr.id, sr.species_id, s.canonical_name, s.family, sr.tdwg_code,
+		       sr.is_native, sr.is_endemic, sr.is_introduced, sr.source
+		FROM species_regions sr
+		JOIN species s ON sr.species_id = s.id
+		WHERE sr.id > %d
+		ORDER BY sr.id`},
+	"species_climate_envelope": {`
+		SELECT e.species_id, s.canonical_name, e.temp_mean, e.temp_min, e.temp_max, e.temp_range,
+		       e.precip_mean, e.precip_min, e.precip_max, e.precip_seasonality,
+		       e.cold_month_min, e.warm_month_max, e.n_koppen_zones, e.n_whittaker_biomes,
+		       e.climate_breadth_score, e.n_regions_sampled, e.updated_at
+		FROM species_climate_envelope e
+		JOIN species s ON e.species_id = s.id
+		WHERE e.species_id > %d
+		ORDER BY e.species_id`},
+}
+
+// copyTo runs a COPY ... TO STDOUT statement on a dedicated read-only
+// connection and writes its output to w. ctx cancels the COPY, and its
+// deadline becomes the statement_timeout as in beginTx.
+func (s *Server) copyTo(ctx context.Context, w io.Writer, copySQL string) (int64, error) {
+	cfg, err := pgconn.ParseConfig(s.connString)
+	if err != nil {
+		return 0, err
+	}
+	cfg.RuntimeParams["default_transaction_read_only"] = "on"
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		cfg.RuntimeParams["statement_timeout"] = strconv.FormatInt(ms, 10)
+	}
+
+	conn, err := pgconn.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close(context.Background())
+
+	tag, err := conn.CopyTo(ctx, w, copySQL)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// copyRows streams the rows of query through COPY ... TO STDOUT and calls fn
+// with each row's fields; NULLs arrive as empty fields. Internal bulk reads
+// (the envelope rebuild) use it in place of rows.Next scanning.
+func (s *Server) copyRows(ctx context.Context, query string, fn func([]string) error) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.copyTo(ctx, pw, "COPY ("+query+") TO STDOUT WITH (FORMAT csv)")
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	r := csv.NewReader(pr)
+	r.ReuseRecord = true
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = fn(record)
+		}
+		if err != nil {
+			// Unblocks the COPY, which then fails on its next write
+			pr.CloseWithError(err)
+			<-done
+			return err
+		}
+	}
+	return <-done
+}
+
+// copyCSV wraps a SELECT into a COPY with a CSV header row
+func copyCSV(query string) string {
+	return "COPY (" + query + ") TO STDOUT WITH (FORMAT csv, HEADER true)"
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleExport handles GET /api/export/{name}
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimSuffix(pathParam(r, "name"), ".csv")
+	export, ok := copyExports[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf(`{"error": "Unknown export; available: %s"}`,
+			strings.Join(copyExportNames(), ", ")), http.StatusNotFound)
+		return
+	}
+
+	if _, ok := s.requireRole(w, r, roleUser); !ok {
+		return
+	}
+
+	var afterID int64
+	if v := r.URL.Query().Get("after_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, `{"error": "after_id must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		afterID = n
+	}
+
+	out := &exportWriter{w: w, filename: csvFilename(name), bom: r.URL.Query().Get("bom") == "true"}
+	start := time.Now()
+	n, err := s.copyTo(ctx, out, copyCSV(fmt.Sprintf(export.Query, afterID)))
+	if err != nil {
+		s.log.Printf("Export %s (after_id=%d) failed: %v", name, afterID, err)
+		if !out.started {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		}
+		// Otherwise the status is gone; the client resumes with after_id
+		return
+	}
+	s.log.Printf("Export %s (after_id=%d): %d rows in %s", name, afterID, n, time.Since(start))
+}
+
+func copyExportNames() []string {
+	names := make([]string, 0, len(copyExports))
+	for name := range copyExports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exportWriter sends the CSV headers on the first write, so a COPY that
+// fails before any output can still be answered with a JSON error
+type exportWriter struct {
+	w        http.ResponseWriter
+	filename string
+	bom      bool
+	started  bool
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, e.filename))
+		e.w.Header().Set("X-Accel-Buffering", "no")
+		e.w.WriteHeader(http.StatusOK)
+		if e.bom {
+			e.w.Write([]byte("\ufeff"))
+		}
+	}
+	return e.w.Write(p)
+}
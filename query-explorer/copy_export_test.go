@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleExportRequest(t *testing.T) {
+	s, _ := newFakeDBServer(t, fakeAdminKey)
+	// Fails before connecting, so valid requests stop at copyTo
+	s.connString = "postgres://%zz"
+
+	for _, tc := range []struct {
+		path, body string
+		code       int
+	}{
+		{"/api/export/species", "Unknown export; available: species_climate_envelope, species_regions", http.StatusNotFound},
+		{"/api/export/species_regions?after_id=abc", "after_id must be a non-negative integer", http.StatusBadRequest},
+		{"/api/export/species_regions?after_id=-5", "after_id must be a non-negative integer", http.StatusBadRequest},
+		{"/api/export/species_regions.csv?after_id=120", "", http.StatusInternalServerError},
+		{"/api/export/species_climate_envelope", "", http.StatusInternalServerError},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, req)
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.body) || !json.Valid(w.Body.Bytes()) {
+			t.Errorf("%s: %d %s, want %d %q", tc.path, w.Code, w.Body.String(), tc.code, tc.body)
+		}
+	}
+}
+
+func TestExportWriterHeadersOnFirstWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+	out := &exportWriter{w: w, filename: "species_regions.csv", bom: true}
+	if out.started {
+		t.Fatal("started before writing")
+	}
+
+	out.Write([]byte("id,species_id\n"))
+	// Set after the first row; the response headers are already sent
+	w.Header().Set("Content-Type", "application/json")
+	out.Write([]byte("1,42\n"))
+
+	res := w.Result()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/csv; charset=utf-8" ||
+		res.Header.Get("Content-Disposition") != `attachment; filename="species_regions.csv"` {
+		t.Errorf("status %d, headers %v", res.StatusCode, res.Header)
+	}
+	if got := w.Body.String(); got != "\ufeffid,species_id\n1,42\n" {
+		t.Errorf("body %q, want one BOM before the rows", got)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// CSV EXPORT
+// ============================================================================
+//
+// /api/query and /api/species answer with CSV when asked via ?format=csv or
+// "Accept: text/csv": RFC 4180 with a header row, served as an attachment.
+// ?bom=true prefixes a UTF-8 BOM so Excel reads accented names correctly (R
+// and pandas do not need it). Text fields that a spreadsheet would run as a
+// formula are escaped (csvSafe): names and common names come from users.
+
+func wantsCSV(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "csv"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+// newCSVResponse sets the download headers and returns a writer on w
+func newCSVResponse(w http.ResponseWriter, r *http.Request, filename string) *csv.Writer {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	if r.URL.Query().Get("bom") == "true" {
+		w.Write([]byte("\ufeff"))
+	}
+	cw := csv.NewWriter(w)
+	if mark := watermark(r.Context()); mark != "" {
+		cw.Write([]string{mark})
+	}
+	return cw
+}
+
+// csvSafe keeps spreadsheets from reading a text field as a formula: one
+// starting with = + - @, a tab or a carriage return gets a leading quote.
+// Only for text; numbers are written as they are.
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// csvSafeRecord is csvSafe on every field of record
+func csvSafeRecord(record []string) []string {
+	out := make([]string, len(record))
+	for i, v := range record {
+		out[i] = csvSafe(v)
+	}
+	return out
+}
+
+// csvFilename is prefix plus a timestamp, e.g. query-20240131-154500.csv;
+// anything but letters, digits and dashes is dropped from prefix
+func csvFilename(prefix string) string {
+	prefix = strings.Map(func(c rune) rune {
+		if c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			return c
+		}
+		return -1
+	}, prefix)
+	return fmt.Sprintf("%s-%s.csv", prefix, time.Now().Format("20060102-150405"))
+}
+
+// csvValue formats a scanned value; NULL is an empty field
+func csvValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return csvSafe(val)
+	case []byte:
+		return csvSafe(string(val))
+	case time.Time:
+		return val.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// streamQueryCSV writes /api/query rows as they are scanned, flushing every
+// flush_every rows like the NDJSON mode. It returns the number of rows
+// written and the error that ended the export, if any.
+func streamQueryCSV(w http.ResponseWriter, r *http.Request, rows *sql.Rows, columns []string) (int, error) {
+	flushEvery := defaultStreamFlushEvery
+	if n, err := strconv.Atoi(r.URL.Query().Get("flush_every")); err == nil && n > 0 {
+		flushEvery = n
+	}
+
+	cw := newCSVResponse(w, r, csvFilename("query"))
+	cw.Write(columns)
+
+	types := queryColumnTypes(rows)
+	record := make([]string, len(columns))
+	count := 0
+	for rows.Next() {
+		row, err := scanQueryRow(rows, types)
+		if err != nil {
+			log.Printf("CSV query export stopped after %d rows: %v", count, err)
+			cw.Flush()
+			return count, err
+		}
+		for i, v := range row {
+			record[i] = csvValue(v)
+		}
+		if err := cw.Write(record); err != nil {
+			log.Printf("CSV query export aborted after %d rows: %v", count, err)
+			return count, err
+		}
+		count++
+		if count%flushEvery == 0 {
+			cw.Flush()
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+	err := rows.Err()
+	if err != nil {
+		// The header is already sent; the truncation is only visible in the log
+		log.Printf("CSV query export failed after %d rows: %v", count, err)
+	}
+	cw.Flush()
+	return count, err
+}
+
+// writeSpeciesCSV writes the /api/species page as CSV
+func writeSpeciesCSV(w http.ResponseWriter, r *http.Request, tdwgCode string, species []SpeciesItem) {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+
+	cw := newCSVResponse(w, r, csvFilename("species-"+strings.ToLower(tdwgCode)))
+	cw.Write([]string{"id", "canonical_name", "family", "growth_form", "source", "common_name", "is_native", "establishment_means", "abundance"})
+	for _, sp := range species {
+		cw.Write(append([]string{strconv.FormatInt(sp.ID, 10)}, csvSafeRecord([]string{
+			sp.CanonicalName, sp.Family, sp.GrowthForm, sp.Source,
+			str(sp.CommonName), strconv.FormatBool(sp.IsNative), str(sp.Establishment), str(sp.Abundance),
+		})...))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("Error writing species CSV: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCSVSafe(t *testing.T) {
+	for in, want := range map[string]string{
+		"=1+1":          "'=1+1",
+		"+55 11":        "'+55 11",
+		"-2":            "'-2",
+		"@SUM(A1)":      "'@SUM(A1)",
+		"\tcmd":         "'\tcmd",
+		"\rx":           "'\rx",
+		"Inga edulis":   "Inga edulis",
+		"ingá-do-brejo": "ingá-do-brejo",
+		"":              "",
+	} {
+		if got := csvSafe(in); got != want {
+			t.Errorf("csvSafe(%q) = %q, want %q", in, got, want)
+		}
+	}
+	// Scanned numbers are never escaped, only text
+	if csvValue(-46.65) != "-46.65" || csvValue(int64(-3)) != "-3" || csvValue([]byte("=cmd")) != "'=cmd" {
+		t.Error("csvValue escaping")
+	}
+}
+
+func TestWriteSpeciesCSV(t *testing.T) {
+	name, native := `=HYPERLINK("http://x")`, "native"
+	w := httptest.NewRecorder()
+	writeSpeciesCSV(w, httptest.NewRequest("GET", "/api/species?format=csv&bom=true", nil), "BZS", []SpeciesItem{
+		{ID: 12, CanonicalName: "Euterpe edulis", Family: "Arecaceae", GrowthForm: "palm", Source: "wcvp",
+			CommonName: &name, IsNative: true, Establishment: &native},
+	})
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="species-bzs-`) {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "\ufeff") {
+		t.Error("no BOM with bom=true")
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(body, "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []string{"id", "canonical_name", "family", "growth_form", "source", "common_name", "is_native", "establishment_means", "abundance"}
+	if len(records) != 2 || !reflect.DeepEqual(records[0], header) {
+		t.Fatalf("records = %q", records)
+	}
+	want := []string{"12", "Euterpe edulis", "Arecaceae", "palm", "wcvp", `'=HYPERLINK("http://x")`, "true", "native", ""}
+	if !reflect.DeepEqual(records[1], want) {
+		t.Errorf("row = %q, want %q", records[1], want)
+	}
+}
+
+func TestCSVFilename(t *testing.T) {
+	name := csvFilename(`sp"ecies/../bzs`)
+	if !regexp.MustCompile(`^speciesbzs-\d{8}-\d{6}\.csv$`).MatchString(name) {
+		t.Errorf("csvFilename = %q", name)
+	}
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// SUBMISSION TYPES
+// ============================================================================
+
+// curationType describes one kind of user submission in the review queue
+type curationType struct {
+	table string
+	label string
+	// accept applies an accepted submission to the curated tables
+	accept func(ctx context.Context, tx *sql.Tx, id int64) error
+}
+
+var curationTypes = map[string]curationType{
+	"observation": {table: "observations", label: "observation", accept: applyObservation},
+	"common_name": {table: "common_name_suggestions", label: "common name suggestion", accept: applyCommonNameSuggestion},
+	"trait":       {table: "trait_suggestions", label: "trait suggestion", accept: applyTraitSuggestion},
+}
+
+// traitSpec maps a suggestible trait to its species_unified column
+type traitSpec struct {
+	column       string
+	sourceColumn string // Empty if the trait has no provenance column
+	kind         string // number, bool, text, growth_form, wetland_indicator, light_requirement
+}
+
+var suggestibleTraits = map[string]traitSpec{
+	"growth_form":        {column: "growth_form", sourceColumn: "growth_form_source", kind: "growth_form"},
+	"max_height_m":       {column: "max_height_m", sourceColumn: "height_source", kind: "number"},
+	"lifespan_years":     {column: "lifespan_years", sourceColumn: "lifespan_source", kind: "number"},
+	"nitrogen_fixer":     {column: "nitrogen_fixer", kind: "bool"},
+	"woodiness":          {column: "woodiness", kind: "text"},
+	"dispersal_syndrome": {column: "dispersal_syndrome", kind: "text"},
+	"deciduousness":      {column: "deciduousness", kind: "text"},
+	"wetland_indicator":  {column: "wetland_indicator", sourceColumn: "wetland_indicator_source", kind: "wetland_indicator"},
+	"light_requirement":  {column: "light_requirement", sourceColumn: "light_requirement_source", kind: "light_requirement"},
+}
+
+// parseTraitValue validates a suggested value for the trait's column type
+func parseTraitValue(spec traitSpec, value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	switch spec.kind {
+	case "number":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 {
+			return nil, fmt.Errorf("%s must be a positive number", spec.column)
+		}
+		return f, nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", spec.column)
+		}
+		return b, nil
+	case "growth_form":
+		if !validGrowthForms[value] {
+			return nil, fmt.Errorf("invalid growth form: %s", value)
+		}
+		return value, nil
+	case "wetland_indicator":
+		if !validWetlandIndicators[value] {
+			return nil, fmt.Errorf("invalid wetland indicator: %s", value)
+		}
+		return value, nil
+	case "light_requirement":
+		if !validLightRequirements[value] {
+			return nil, fmt.Errorf("invalid light requirement: %s", value)
+		}
+		return value, nil
+	default:
+		if value == "" || len(value) > 100 {
+			return nil, fmt.Errorf("%s must be between 1 and 100 characters", spec.column)
+		}
+		return value, nil
+	}
+}
+
+func applyCommonNameSuggestion(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO common_names (species_id, common_name, language, source, verified)
+		SELECT species_id, common_name, language, 'user', TRUE
+		FROM common_name_suggestions
+		WHERE id = $1
+		ON CONFLICT (species_id, common_name, language) DO UPDATE SET verified = TRUE
+	`, id)
+	return err
+}
+
+func applyTraitSuggestion(ctx context.Context, tx *sql.Tx, id int64) error {
+	var speciesID int64
+	var trait, value string
+	err := tx.QueryRowContext(ctx, `SELECT species_id, trait, value FROM trait_suggestions WHERE id = $1`, id).
+		Scan(&speciesID, &trait, &value)
+	if err != nil {
+		return err
+	}
+
+	spec, ok := suggestibleTraits[trait]
+	if !ok {
+		return fmt.Errorf("trait %s can no longer be suggested", trait)
+	}
+	parsed, err := parseTraitValue(spec, value)
+	if err != nil {
+		return err
+	}
+
+	// Column names come from the suggestibleTraits whitelist, never from input
+	set := fmt.Sprintf("%s = $2", spec.column)
+	if spec.sourceColumn != "" {
+		set += fmt.Sprintf(", %s = 'curated'", spec.sourceColumn)
+	}
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE species_unified SET %s WHERE species_id = $1`, set), speciesID, parsed)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("species %d has no unified traits record", speciesID)
+	}
+	return nil
+}
+
+// ============================================================================
+// SUGGESTION SUBMISSION
+// ============================================================================
+
+type CommonNameSuggestionRequest struct {
+	SpeciesID  int64  `json:"species_id"`
+	CommonName string `json:"common_name"`
+	Language   string `json:"language"`
+	Reference  string `json:"reference,omitempty"`
+}
+
+type TraitSuggestionRequest struct {
+	SpeciesID int64  `json:"species_id"`
+	Trait     string `json:"trait"`
+	Value     string `json:"value"`
+	Reference string `json:"reference,omitempty"`
+}
+
+// handleCommonNameSuggestion handles POST /api/suggestions/common-names
+func (s *Server) handleCommonNameSuggestion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	var req CommonNameSuggestionRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	req.CommonName = strings.TrimSpace(req.CommonName)
+	if req.SpeciesID == 0 || req.CommonName == "" || len(req.CommonName) > 255 {
+		http.Error(w, `{"error": "species_id and common_name (max 255 chars) required"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Language) < 2 || len(req.Language) > 10 {
+		http.Error(w, `{"error": "language must be a language code such as pt or en"}`, http.StatusBadRequest)
+		return
+	}
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO common_name_suggestions (species_id, common_name, language, reference, submitted_by)
+		VALUES ($1, $2, LOWER($3), NULLIF($4, ''), $5)
+		RETURNING id
+	`, req.SpeciesID, req.CommonName, req.Language, req.Reference, key.ID).Scan(&id)
+	if err != nil {
+		s.log.Printf("Error saving common name suggestion: %v", err)
+		http.Error(w, `{"error": "Failed to save suggestion (unknown species?)"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": "pending"})
+}
+
+// handleTraitSuggestion handles POST /api/suggestions/traits
+func (s *Server) handleTraitSuggestion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	var req TraitSuggestionRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	spec, known := suggestibleTraits[req.Trait]
+	if req.SpeciesID == 0 || !known {
+		http.Error(w, `{"error": "species_id and a supported trait required"}`, http.StatusBadRequest)
+		return
+	}
+	if _, err := parseTraitValue(spec, req.Value); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO trait_suggestions (species_id, trait, value, reference, submitted_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		RETURNING id
+	`, req.SpeciesID, req.Trait, strings.TrimSpace(req.Value), req.Reference, key.ID).Scan(&id)
+	if err != nil {
+		s.log.Printf("Error saving trait suggestion: %v", err)
+		http.Error(w, `{"error": "Failed to save suggestion (unknown species?)"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": "pending"})
+}
+
+// ============================================================================
+// REVIEW QUEUE
+// ============================================================================
+
+type CurationItem struct {
+	Type          string          `json:"type"`
+	ID            int64           `json:"id"`
+	SpeciesID     int64           `json:"species_id"`
+	CanonicalName string          `json:"canonical_name"`
+	SubmittedBy   *string         `json:"submitted_by"`
+	CreatedAt     string          `json:"created_at"`
+	Details       json.RawMessage `json:"details"`
+}
+
+type CurationQueueResponse struct {
+	Items      []CurationItem `json:"items"`
+	Total      int64          `json:"total"` // Pending items of the type
+	NextCursor string         `json:"next_cursor"`
+}
+
+// Oldest first; IDs repeat across types, so the cursor ID is queue_id
+var curationQueueList = listSpec{
+	DefaultLimit:  50,
+	MaxLimit:      200,
+	Sorts:         map[string]string{"created_at": "q.created_at"},
+	DefaultSort:   "created_at",
+	IDColumn:      "q.queue_id",
+	TimeColumn:    "q.created_at",
+	SearchColumns: []string{"s.canonical_name"},
+}
+
+// curationQueueSQL lists pending submissions of every type with a
+// type-specific details object. queue_id is unique across the types.
+const curationQueueSQL = `
+	SELECT 'observation' AS type, o.id, o.id * 3 AS queue_id, o.species_id, o.submitted_by, o.created_at,
+	       json_build_object(
+	           'latitude', o.latitude, 'longitude', o.longitude,
+	           'coordinate_uncertainty_m', o.coordinate_uncertainty_m,
+	           'coordinate_grid_deg', o.coordinate_grid_deg,
+	           'tdwg_code', o.tdwg_code, 'eco_id', o.eco_id,
+	           'observed_on', o.observed_on, 'establishment', o.establishment,
+	           'notes', o.notes,
+	           'photo_ids', ARRAY(SELECT p.id FROM observation_photos p WHERE p.observation_id = o.id ORDER BY p.id)
+	       ) AS details
+	FROM observations o WHERE o.status = 'pending'
+	UNION ALL
+	SELECT 'common_name', c.id, c.id * 3 + 1, c.species_id, c.submitted_by, c.created_at,
+	       json_build_object('common_name', c.common_name, 'language', c.language, 'reference', c.reference)
+	FROM common_name_suggestions c WHERE c.status = 'pending'
+	UNION ALL
+	SELECT 'trait', t.id, t.id * 3 + 2, t.species_id, t.submitted_by, t.created_at,
+	       json_build_object('trait', t.trait, 'value', t.value, 'reference', t.reference,
+	                         'current_value', (SELECT to_jsonb(su) -> t.trait FROM species_unified su WHERE su.species_id = t.species_id))
+	FROM trait_suggestions t WHERE t.status = 'pending'
+`
+
+// handleCurationQueue handles GET /api/curation/queue
+func (s *Server) handleCurationQueue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.requireRole(w, r, roleCurator); !ok {
+		return
+	}
+
+	itemType := r.URL.Query().Get("type")
+	if itemType != "" {
+		if _, ok := curationTypes[itemType]; !ok {
+			http.Error(w, `{"error": "type must be observation, common_name or trait"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, err := parseListParams(r, curationQueueList)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	resp := CurationQueueResponse{Items: []CurationItem{}}
+
+	s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (`+curationQueueSQL+`) q WHERE $1 = '' OR q.type = $1`, itemType).Scan(&resp.Total)
+
+	where, tail, args := p.SQL([]interface{}{itemType})
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT q.type, q.id, q.queue_id, q.species_id, s.canonical_name, k.owner,
+		       TO_CHAR(q.created_at, 'YYYY-MM-DD"T"HH24:MI:SS'), q.details, `+p.CursorColumn()+`
+		FROM (`+curationQueueSQL+`) q
+		JOIN species s ON q.species_id = s.id
+		LEFT JOIN api_keys k ON q.submitted_by = k.id
+		WHERE ($1 = '' OR q.type = $1)`+where+`
+		`+tail, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var keys []listKey
+	for rows.Next() {
+		var item CurationItem
+		var queueID int64
+		var details []byte
+		var cursorValue string
+		if err := rows.Scan(&item.Type, &item.ID, &queueID, &item.SpeciesID, &item.CanonicalName,
+			&item.SubmittedBy, &item.CreatedAt, &details, &cursorValue); err != nil {
+			s.log.Printf("Error scanning curation row: %v", err)
+			continue
+		}
+		item.Details = details
+		resp.Items = append(resp.Items, item)
+		keys = append(keys, listKey{cursorValue, queueID})
+	}
+
+	n, next := p.trim(keys)
+	resp.Items, resp.NextCursor = resp.Items[:n], next
+	json.NewEncoder(w).Encode(resp)
+}
+
+type ReviewRequest struct {
+	Reason string `json:"reason"`
+}
+
+// curationReview returns the handler of POST /api/curation/{type}/{id}/accept
+// (accept) or /reject
+func curationReview(accept bool) func(*Server, http.ResponseWriter, *http.Request) {
+	return func(s *Server, w http.ResponseWriter, r *http.Request) {
+		s.handleCurationReview(w, r, accept)
+	}
+}
+
+// handleCurationReview accepts or rejects the submission of the route
+func (s *Server) handleCurationReview(w http.ResponseWriter, r *http.Request, accept bool) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	typeName := pathParam(r, "type")
+	ct, ok := curationTypes[typeName]
+	if !ok {
+		http.Error(w, `{"error": "Unknown submission type"}`, http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid id"}`, http.StatusBadRequest)
+		return
+	}
+
+	curator, ok := s.requireRole(w, r, roleCurator)
+	if !ok {
+		return
+	}
+
+	var req ReviewRequest
+	if r.ContentLength != 0 {
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if !accept && req.Reason == "" {
+		http.Error(w, `{"error": "A reason is required when rejecting"}`, http.StatusBadRequest)
+		return
+	}
+
+	status, err := s.reviewSubmission(ctx, typeName, id, accept, req.Reason, curator)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Submission not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.Printf("Error reviewing %s %d: %v", ct.label, id, err)
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusConflict)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"type": typeName, "id": id, "status": status})
+}
+
+// reviewSubmission accepts or rejects a pending submission in one
+// transaction: the curated tables, review state and submitter notification
+// are updated together.
+func (s *Server) reviewSubmission(ctx context.Context, typeName string, id int64, accept bool, reason string, curator *APIKey) (string, error) {
+	ct := curationTypes[typeName]
+
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Table names come from curationTypes, never from input
+	var status string
+	var submittedBy sql.NullInt64
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT status, submitted_by FROM %s WHERE id = $1 FOR UPDATE`, ct.table), id).
+		Scan(&status, &submittedBy)
+	if err != nil {
+		return "", err
+	}
+	if status != "pending" {
+		return "", fmt.Errorf("%s was already %s", ct.label, status)
+	}
+
+	newStatus := "rejected"
+	if accept {
+		newStatus = "accepted"
+		if err := ct.accept(ctx, tx, id); err != nil {
+			return "", fmt.Errorf("failed to apply %s: %w", ct.label, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET status = $2, reviewed_by = $3, reviewed_at = NOW(), review_reason = NULLIF($4, '')
+		WHERE id = $1
+	`, ct.table), id, newStatus, curator.ID, reason)
+	if err != nil {
+		return "", err
+	}
+
+	if submittedBy.Valid {
+		message := fmt.Sprintf("Your %s #%d was %s.", ct.label, id, newStatus)
+		if reason != "" {
+			message += " Reason: " + reason
+		}
+		if err := notify(ctx, tx, submittedBy.Int64, "review_"+newStatus, typeName, id, message); err != nil {
+			return "", err
+		}
+	}
+
+	return newStatus, tx.Commit()
+}
+
+// ============================================================================
+// NOTIFICATIONS
+// ============================================================================
+
+type Notification struct {
+	ID          int64   `json:"id"`
+	Kind        string  `json:"kind"`
+	SubjectType *string `json:"subject_type,omitempty"`
+	SubjectID   *int64  `json:"subject_id,omitempty"`
+	Message     string  `json:"message"`
+	Read        bool    `json:"read"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// notify queues a notification for an API key owner
+func notify(ctx context.Context, tx *sql.Tx, apiKeyID int64, kind, subjectType string, subjectID int64, message string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO notifications (api_key_id, kind, subject_type, subject_id, message)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+	`, apiKeyID, kind, subjectType, subjectID, message)
+	return err
+}
+
+// notificationIDsArg binds the ids to mark as read; none (mark all) must be
+// an empty array, not NULL, for the cardinality check to match
+func notificationIDsArg(ids []int64) interface{} {
+	if ids == nil {
+		ids = []int64{}
+	}
+	return pq.Array(ids)
+}
+
+// handleNotifications handles GET /api/notifications and
+// POST /api/notifications (mark as read: {"ids": [..]} or {} for all)
+func (s *Server) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		unreadOnly := r.URL.Query().Get("unread") == "true"
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT id, kind, subject_type, subject_id, message, read_at IS NOT NULL,
+			       TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SS')
+			FROM notifications
+			WHERE api_key_id = $1 AND (NOT $2 OR read_at IS NULL)
+			ORDER BY created_at DESC
+			LIMIT 200
+		`, key.ID, unreadOnly)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		notifications := []Notification{}
+		for rows.Next() {
+			var n Notification
+			if err := rows.Scan(&n.ID, &n.Kind, &n.SubjectType, &n.SubjectID, &n.Message, &n.Read, &n.CreatedAt); err != nil {
+				continue
+			}
+			notifications = append(notifications, n)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"notifications": notifications})
+
+	case http.MethodPost:
+		var req struct {
+			IDs []int64 `json:"ids"`
+		}
+		if r.ContentLength != 0 {
+			if !decodeJSONBody(w, r, &req) {
+				return
+			}
+		}
+
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE notifications SET read_at = NOW()
+			WHERE api_key_id = $1 AND read_at IS NULL
+			  AND (cardinality($2::int[]) = 0 OR id = ANY($2))
+		`, key.ID, notificationIDsArg(req.IDs))
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		n, _ := res.RowsAffected()
+		json.NewEncoder(w).Encode(map[string]interface{}{"marked_read": n})
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
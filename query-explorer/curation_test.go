@@ -0,0 +1,22 @@
+package main
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestNotificationIDsArg(t *testing.T) {
+	for _, tc := range []struct {
+		ids  []int64
+		want string
+	}{
+		{nil, "{}"}, // {} in the request body: mark all
+		{[]int64{}, "{}"},
+		{[]int64{3, 7}, "{3,7}"},
+	} {
+		v, err := notificationIDsArg(tc.ids).(driver.Valuer).Value()
+		if err != nil || v != tc.want {
+			t.Errorf("notificationIDsArg(%v) = %v, %v; want %q", tc.ids, v, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// DASHBOARD PROXY
+// ============================================================================
+//
+// /diversiplant/ is proxied to the Shiny dashboard (DASHBOARD_URL) and, if
+// set, a secondary instance (DASHBOARD_SECONDARY_URL) serving the same
+// paths. A connection error on an idempotent request without a body is
+// retried once, on the other upstream when there is one, before the offline
+// page is served. Upstream health is tracked from proxied traffic and a
+// periodic probe, and reported by /api/health. Paths can be rewritten for
+// upstreams that expect to live at the root (see dashboard_rewrite.go).
+
+const (
+	upstreamProbeInterval = 15 * time.Second
+	upstreamRetryDelay    = 250 * time.Millisecond
+)
+
+type upstream struct {
+	URL *url.URL
+
+	mu        sync.Mutex
+	healthy   bool
+	lastError string
+	lastCheck time.Time
+	failures  int
+}
+
+type UpstreamHealth struct {
+	URL                 string  `json:"url"`
+	Healthy             bool    `json:"healthy"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	LastError           string  `json:"last_error,omitempty"`
+	LastCheck           *string `json:"last_check"`
+}
+
+func (u *upstream) mark(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lastCheck = time.Now()
+	if err == nil {
+		u.healthy, u.failures, u.lastError = true, 0, ""
+		return
+	}
+	u.healthy = false
+	u.failures++
+	u.lastError = err.Error()
+}
+
+func (u *upstream) health() UpstreamHealth {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	h := UpstreamHealth{
+		URL:                 u.URL.String(),
+		Healthy:             u.healthy,
+		ConsecutiveFailures: u.failures,
+		LastError:           u.lastError,
+	}
+	if !u.lastCheck.IsZero() {
+		s := u.lastCheck.Format(time.RFC3339)
+		h.LastCheck = &s
+	}
+	return h
+}
+
+// upstreamPool is the proxy's transport: it sends each request to the
+// healthiest upstream and retries connection errors where that is safe
+type upstreamPool struct {
+	upstreams []*upstream
+	transport http.RoundTripper
+}
+
+func newUpstreamPool(urls ...*url.URL) *upstreamPool {
+	p := &upstreamPool{transport: http.DefaultTransport}
+	for _, u := range urls {
+		// Assumed up until a request or probe says otherwise
+		p.upstreams = append(p.upstreams, &upstream{URL: u, healthy: true})
+	}
+	return p
+}
+
+// attempts lists the upstreams to try for req: healthy ones first, and a
+// second attempt only for requests that can be replayed
+func (p *upstreamPool) attempts(req *http.Request) []*upstream {
+	var healthy, down []*upstream
+	for _, u := range p.upstreams {
+		u.mu.Lock()
+		ok := u.healthy
+		u.mu.Unlock()
+		if ok {
+			healthy = append(healthy, u)
+		} else {
+			down = append(down, u)
+		}
+	}
+	order := append(healthy, down...)
+
+	if !isRetryable(req) {
+		return order[:1]
+	}
+	if len(order) == 1 {
+		return []*upstream{order[0], order[0]}
+	}
+	return order[:2]
+}
+
+// isRetryable: idempotent methods whose (empty) body can be sent again
+func isRetryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+func (p *upstreamPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	var lastErr error
+	for i, u := range p.attempts(req) {
+		if i > 0 {
+			log.Printf("Dashboard proxy: retrying %s %s on %s after: %v", req.Method, req.URL.Path, u.URL.Host, lastErr)
+			select {
+			case <-time.After(upstreamRetryDelay):
+			case <-req.Context().Done():
+				return nil, lastErr
+			}
+		}
+
+		out := req.Clone(req.Context())
+		out.URL.Scheme = u.URL.Scheme
+		out.URL.Host = u.URL.Host
+		resp, err := p.transport.RoundTrip(out)
+		u.mark(err)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// probe checks every upstream; any HTTP response counts as up
+func (p *upstreamPool) probe(ctx context.Context) {
+	for _, u := range p.upstreams {
+		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u.URL.String(), nil)
+		resp, err := p.transport.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		u.mark(err)
+		cancel()
+	}
+}
+
+func (p *upstreamPool) health() []UpstreamHealth {
+	out := make([]UpstreamHealth, len(p.upstreams))
+	for i, u := range p.upstreams {
+		out[i] = u.health()
+	}
+	return out
+}
+
+func (s *Server) newDashboardProxy() http.Handler {
+	dashboardURL := getEnv("DASHBOARD_URL", "http://127.0.0.1:8001")
+	target, _ := url.Parse(dashboardURL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	urls := []*url.URL{target}
+	if secondary := getEnv("DASHBOARD_SECONDARY_URL", ""); secondary != "" {
+		if u, err := url.Parse(secondary); err == nil && u.Host != "" {
+			urls = append(urls, u)
+		} else {
+			s.log.Printf("Invalid DASHBOARD_SECONDARY_URL=%q, ignored", secondary)
+		}
+	}
+	s.upstreams = newUpstreamPool(urls...)
+	proxy.Transport = s.upstreams
+	loadProxyRewrite(urls).install(proxy)
+
+	go func() {
+		ticker := time.NewTicker(upstreamProbeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.upstreams.probe(context.Background())
+		}
+	}()
+
+	// Localized offline page, with the upstream status, when the Python
+	// server cannot be reached
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		s.log.Printf("Dashboard proxy error: %v", err)
+		s.serveOfflinePage(w, r)
+	}
+
+	return proxy
+}
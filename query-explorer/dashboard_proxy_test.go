@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUpstreamPoolRetriesOnSecondary(t *testing.T) {
+	// A closed server refuses connections
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL, _ := url.Parse(dead.URL)
+	dead.Close()
+
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secondary "+r.URL.Path)
+	}))
+	defer live.Close()
+	liveURL, _ := url.Parse(live.URL)
+
+	pool := newUpstreamPool(deadURL, liveURL)
+	proxy := httputil.NewSingleHostReverseProxy(deadURL)
+	proxy.Transport = pool
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("GET", "/diversiplant/app", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "secondary /diversiplant/app" {
+		t.Fatalf("GET: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	health := pool.health()
+	if health[0].Healthy || health[0].ConsecutiveFailures != 1 || !health[1].Healthy {
+		t.Errorf("health after failover: %+v", health)
+	}
+
+	// The primary is now known to be down, so requests go to the secondary
+	// first, and a POST (not retried) succeeds too
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("POST", "/diversiplant/upload", strings.NewReader("x")))
+	if rec.Code != http.StatusOK {
+		t.Errorf("POST: got %d", rec.Code)
+	}
+}
+
+func TestUpstreamPoolDoesNotRetryRequestsWithBody(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL, _ := url.Parse(dead.URL)
+	dead.Close()
+
+	pool := newUpstreamPool(deadURL)
+	if n := len(pool.attempts(httptest.NewRequest("GET", "/", nil))); n != 2 {
+		t.Errorf("GET on a single upstream: got %d attempts, want 2", n)
+	}
+	if n := len(pool.attempts(httptest.NewRequest("POST", "/", strings.NewReader("x")))); n != 1 {
+		t.Errorf("POST: got %d attempts, want 1", n)
+	}
+}
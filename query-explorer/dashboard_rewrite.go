@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// DASHBOARD PATH REWRITING
+// ============================================================================
+//
+// The dashboard is public under /diversiplant/, but upstream apps are often
+// written to live at the root and emit absolute paths ("/static/app.js",
+// Location: /login) that break behind the gateway. Paths are mapped both
+// ways so such apps work unmodified:
+//
+//   - DASHBOARD_STRIP_PREFIX=true forwards /diversiplant/x as /x
+//   - the path of DASHBOARD_URL (e.g. http://127.0.0.1:8001/app) is put in
+//     front of what is forwarded, as before
+//
+// When either changes the path, responses are mapped back: Location and
+// Content-Location headers, Set-Cookie paths and, unless
+// DASHBOARD_REWRITE_HTML=false, root-relative href, src and action
+// attributes (base hrefs included) of HTML pages. URLs already under
+// /diversiplant/ are left alone, for apps honoring the X-Forwarded-Prefix
+// sent when the prefix is stripped.
+
+const (
+	dashboardPrefix       = "/diversiplant"
+	maxRewrittenHTMLBytes = 10 << 20
+)
+
+// htmlURLAttr matches href, src and action attributes with a root-relative
+// value
+var htmlURLAttr = regexp.MustCompile(`(?i)(\s(?:href|src|action)\s*=\s*["'])(/[^"'>]*)`)
+
+// proxyRewrite maps between public paths and the upstream's
+type proxyRewrite struct {
+	Strip    bool            // Drop dashboardPrefix before forwarding
+	Upstream string          // Path of DASHBOARD_URL, prepended to forwarded paths
+	Hosts    map[string]bool // Upstream hosts, whose absolute URLs become public paths
+	HTML     bool            // Rewrite HTML attributes
+}
+
+// loadProxyRewrite reads DASHBOARD_STRIP_PREFIX and DASHBOARD_REWRITE_HTML
+// for the upstreams, the first being DASHBOARD_URL
+func loadProxyRewrite(upstreams []*url.URL) proxyRewrite {
+	rw := proxyRewrite{
+		Strip:    getEnv("DASHBOARD_STRIP_PREFIX", "false") == "true",
+		Upstream: strings.TrimSuffix(upstreams[0].Path, "/"),
+		Hosts:    map[string]bool{},
+		HTML:     getEnv("DASHBOARD_REWRITE_HTML", "true") != "false",
+	}
+	for _, u := range upstreams {
+		rw.Hosts[u.Host] = true
+	}
+	return rw
+}
+
+// active reports whether upstream paths differ from public ones
+func (rw proxyRewrite) active() bool {
+	return rw.Strip || rw.Upstream != ""
+}
+
+// install hooks the rewriting into proxy
+func (rw proxyRewrite) install(proxy *httputil.ReverseProxy) {
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		if rw.Strip {
+			req.URL.Path = rw.stripPrefix(req.URL.Path)
+			req.URL.RawPath = ""
+			req.Header.Set("X-Forwarded-Prefix", dashboardPrefix)
+		}
+		director(req)
+	}
+	if rw.active() {
+		proxy.ModifyResponse = rw.modifyResponse
+	}
+}
+
+func (rw proxyRewrite) stripPrefix(path string) string {
+	path = strings.TrimPrefix(path, dashboardPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// publicPath maps a root-relative upstream path to the public one
+func (rw proxyRewrite) publicPath(path string) string {
+	if path == dashboardPrefix || strings.HasPrefix(path, dashboardPrefix+"/") {
+		return path // Already public
+	}
+	if rw.Upstream != "" && (path == rw.Upstream || strings.HasPrefix(path, rw.Upstream+"/")) {
+		path = strings.TrimPrefix(path, rw.Upstream)
+		if path == "" {
+			path = "/"
+		}
+	}
+	if rw.Strip {
+		return dashboardPrefix + path
+	}
+	return path
+}
+
+// publicURL maps a URL from the upstream: root-relative ones and absolute
+// ones on an upstream host become public paths, anything else is kept
+func (rw proxyRewrite) publicURL(v string) string {
+	if strings.HasPrefix(v, "/") && !strings.HasPrefix(v, "//") {
+		path, rest := v, ""
+		if i := strings.IndexAny(v, "?#"); i >= 0 {
+			path, rest = v[:i], v[i:]
+		}
+		return rw.publicPath(path) + rest
+	}
+	u, err := url.Parse(v)
+	if err != nil || !u.IsAbs() || !rw.Hosts[u.Host] {
+		return v
+	}
+	out := &url.URL{Path: rw.publicPath(u.EscapedPath()), RawQuery: u.RawQuery, Fragment: u.Fragment}
+	if out.Path == "" {
+		out.Path = rw.publicPath("/")
+	}
+	return out.String()
+}
+
+// rewriteCookiePath maps the Path attribute of a Set-Cookie value
+func (rw proxyRewrite) rewriteCookiePath(cookie string) string {
+	parts := strings.Split(cookie, ";")
+	for i, p := range parts {
+		name, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if ok && strings.EqualFold(name, "path") && strings.HasPrefix(value, "/") {
+			parts[i] = " Path=" + rw.publicPath(value)
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// rewriteHTML maps the root-relative URL attributes of page
+func (rw proxyRewrite) rewriteHTML(page []byte) []byte {
+	return htmlURLAttr.ReplaceAllFunc(page, func(m []byte) []byte {
+		sub := htmlURLAttr.FindSubmatch(m)
+		if bytes.HasPrefix(sub[2], []byte("//")) {
+			return m
+		}
+		return append(append([]byte{}, sub[1]...), rw.publicURL(string(sub[2]))...)
+	})
+}
+
+func (rw proxyRewrite) modifyResponse(resp *http.Response) error {
+	for _, name := range []string{"Location", "Content-Location"} {
+		if v := resp.Header.Get(name); v != "" {
+			resp.Header.Set(name, rw.publicURL(v))
+		}
+	}
+	if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 {
+		resp.Header.Del("Set-Cookie")
+		for _, c := range cookies {
+			resp.Header.Add("Set-Cookie", rw.rewriteCookiePath(c))
+		}
+	}
+
+	if !rw.HTML || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+	encoding := resp.Header.Get("Content-Encoding")
+	if (encoding != "" && encoding != "gzip") || resp.ContentLength > maxRewrittenHTMLBytes {
+		return nil
+	}
+
+	var body io.Reader = resp.Body
+	if encoding == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = gz
+	}
+	page, err := io.ReadAll(io.LimitReader(body, maxRewrittenHTMLBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if len(page) > maxRewrittenHTMLBytes {
+		// Too large to rewrite: passed on as read so far plus the rest
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(page), body), resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		return nil
+	}
+	resp.Body.Close()
+	page = rw.rewriteHTML(page)
+	resp.Body = io.NopCloser(bytes.NewReader(page))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(page)))
+	resp.ContentLength = int64(len(page))
+	return nil
+}
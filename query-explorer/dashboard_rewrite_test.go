@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProxyRewritePaths(t *testing.T) {
+	rw := proxyRewrite{Strip: true, Upstream: "/app", Hosts: map[string]bool{"127.0.0.1:8001": true}}
+	for in, want := range map[string]string{
+		"/":                             "/diversiplant/",
+		"/app":                          "/diversiplant/",
+		"/app/login?next=/x":            "/diversiplant/login?next=/x",
+		"/static/app.js":                "/diversiplant/static/app.js",
+		"/diversiplant/already":         "/diversiplant/already",
+		"http://127.0.0.1:8001/app/ws":  "/diversiplant/ws",
+		"https://example.org/app/login": "https://example.org/app/login",
+		"//cdn.example.org/lib.js":      "//cdn.example.org/lib.js",
+		"relative/page":                 "relative/page",
+	} {
+		if got := rw.publicURL(in); got != want {
+			t.Errorf("publicURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if got := rw.rewriteCookiePath("session=abc; Path=/; HttpOnly"); got != "session=abc; Path=/diversiplant/; HttpOnly" {
+		t.Errorf("cookie: got %q", got)
+	}
+
+	page := `<html><head><base href="/"><link href='/static/a.css'><script src="//cdn.example.org/x.js"></script></head>` +
+		`<body><form action="/app/submit"><a href="https://example.org/">x</a></form></body></html>`
+	want := `<html><head><base href="/diversiplant/"><link href='/diversiplant/static/a.css'><script src="//cdn.example.org/x.js"></script></head>` +
+		`<body><form action="/diversiplant/submit"><a href="https://example.org/">x</a></form></body></html>`
+	if got := string(rw.rewriteHTML([]byte(page))); got != want {
+		t.Errorf("HTML:\n got %s\nwant %s", got, want)
+	}
+
+	// Without strip, only the upstream path is mapped back
+	rw = proxyRewrite{Upstream: "/app"}
+	if got := rw.publicURL("/app/diversiplant/login"); got != "/diversiplant/login" {
+		t.Errorf("no strip: got %q", got)
+	}
+	if (proxyRewrite{}).active() {
+		t.Error("no prefixes: rewriting active")
+	}
+}
+
+func TestProxyRewriteEndToEnd(t *testing.T) {
+	var gotPath, gotPrefix string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotPrefix = r.URL.Path, r.Header.Get("X-Forwarded-Prefix")
+		if r.URL.Path == "/app/old" {
+			http.Redirect(w, r, "/app/new", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		io.WriteString(gz, `<script src="/app/main.js"></script>`)
+		gz.Close()
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL + "/app")
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = newUpstreamPool(target)
+	proxyRewrite{Strip: true, Upstream: "/app", Hosts: map[string]bool{target.Host: true}, HTML: true}.install(proxy)
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("GET", "/diversiplant/old", nil))
+	if gotPath != "/app/old" || gotPrefix != dashboardPrefix {
+		t.Errorf("forwarded %q with prefix %q", gotPath, gotPrefix)
+	}
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusFound || loc != "/diversiplant/new" {
+		t.Errorf("redirect: got %d %q", rec.Code, loc)
+	}
+
+	req := httptest.NewRequest("GET", "/diversiplant/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	body := rec.Body.Bytes()
+	if rec.Header().Get("Content-Encoding") != "" || !bytes.Equal(body, []byte(`<script src="/diversiplant/main.js"></script>`)) {
+		t.Errorf("page: got %q (%s)", body, rec.Header().Get("Content-Encoding"))
+	}
+	if !strings.HasPrefix(gotPath, "/app") {
+		t.Errorf("forwarded %q", gotPath)
+	}
+}
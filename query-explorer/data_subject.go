@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// DATA-SUBJECT REQUESTS (LGPD)
+// ============================================================================
+//
+// Accounts are API keys: a key's owner is the data subject for everything
+// stored under it. Two endpoints serve the LGPD (art. 18) rights of access
+// and deletion:
+//
+//	GET  /api/me/export   the key and every row keyed to it, as one JSON
+//	                      document (observation photos as URLs)
+//	POST /api/me/delete   {"confirm": true}: deletes those rows and the key,
+//	                      in one transaction
+//
+// An admin acting on a request received by other means adds ?key_id=. Rows
+// others created from the data (accepted suggestions applied to
+// common_names, folded observations, anonymized research records) are not
+// personal and stay; reviews the key made of others' submissions keep the
+// submission with reviewed_by cleared. Audit rows and query jobs already
+// moved to the archive by retention (see retention.go) are not rewritten;
+// they age out with the archive. Each request is logged in
+// data_subject_requests (migration 050).
+
+// personalDataTable is one section of the export and what deleting it
+// removes, both for the key in $1
+type personalDataTable struct {
+	Name   string
+	Select string // Rows, ordered
+	Delete string
+}
+
+var personalDataTables = []personalDataTable{
+	{
+		Name: "restoration_plans",
+		Select: `SELECT p.*,
+			(SELECT COALESCE(json_agg(ps ORDER BY ps.species_id), '[]') FROM restoration_plan_species ps WHERE ps.plan_id = p.id) AS species,
+			(SELECT COALESCE(json_agg(o ORDER BY o.monitored_at, o.species_id), '[]') FROM planting_outcomes o WHERE o.plan_id = p.id) AS outcomes
+			FROM restoration_plans p WHERE p.owner_key_id = $1 ORDER BY p.id`,
+		Delete: `DELETE FROM restoration_plans WHERE owner_key_id = $1`, // Species and outcomes cascade
+	},
+	{
+		Name: "observations",
+		Select: `SELECT o.*,
+			ARRAY(SELECT '/api/observations/photos/' || ph.id FROM observation_photos ph WHERE ph.observation_id = o.id ORDER BY ph.id) AS photo_urls
+			FROM observations o WHERE o.submitted_by = $1 ORDER BY o.id`,
+		Delete: `DELETE FROM observations WHERE submitted_by = $1`, // Photos cascade
+	},
+	{
+		Name:   "common_name_suggestions",
+		Select: `SELECT * FROM common_name_suggestions WHERE submitted_by = $1 ORDER BY id`,
+		Delete: `DELETE FROM common_name_suggestions WHERE submitted_by = $1`,
+	},
+	{
+		Name:   "trait_suggestions",
+		Select: `SELECT * FROM trait_suggestions WHERE submitted_by = $1 ORDER BY id`,
+		Delete: `DELETE FROM trait_suggestions WHERE submitted_by = $1`,
+	},
+	{
+		Name: "areas_of_interest",
+		Select: `SELECT id, name, ST_AsGeoJSON(geom)::json AS geometry, area_km2, created_at
+			FROM areas_of_interest WHERE owner_key_id = $1 ORDER BY created_at`,
+		Delete: `DELETE FROM areas_of_interest WHERE owner_key_id = $1`,
+	},
+	{
+		Name:   "saved_queries",
+		Select: `SELECT * FROM saved_queries WHERE owner_key_id = $1 ORDER BY id`,
+		Delete: `DELETE FROM saved_queries WHERE owner_key_id = $1`,
+	},
+	{
+		Name: "query_jobs",
+		Select: `SELECT id, sql_text, row_limit, status, error, created_at, started_at, finished_at
+			FROM query_jobs WHERE created_by = $1 ORDER BY created_at`,
+		Delete: `DELETE FROM query_jobs WHERE created_by = $1`,
+	},
+	{
+		Name: "spatial_export_jobs",
+		Select: `SELECT id, layer, tolerance, status, n_rows, bytes, error, created_at, started_at, finished_at
+			FROM spatial_export_jobs WHERE created_by = $1 ORDER BY created_at`,
+		Delete: `DELETE FROM spatial_export_jobs WHERE created_by = $1`,
+	},
+	{
+		Name:   "query_audit",
+		Select: `SELECT * FROM query_audit WHERE api_key_id = $1 ORDER BY id`,
+		Delete: `DELETE FROM query_audit WHERE api_key_id = $1`,
+	},
+	{
+		Name:   "notifications",
+		Select: `SELECT * FROM notifications WHERE api_key_id = $1 ORDER BY id`,
+		Delete: `DELETE FROM notifications WHERE api_key_id = $1`,
+	},
+}
+
+// jsonRowsSQL wraps a row query into one JSON array value
+func jsonRowsSQL(query string) string {
+	return fmt.Sprintf(`SELECT COALESCE(json_agg(t), '[]') FROM (%s) t`, query)
+}
+
+type PersonalDataExport struct {
+	ExportedAt string                     `json:"exported_at"`
+	APIKey     json.RawMessage            `json:"api_key"`
+	Data       map[string]json.RawMessage `json:"data"`
+}
+
+// exportPersonalData reads everything keyed to keyID in one snapshot
+func (s *Server) exportPersonalData(ctx context.Context, keyID int64) (*PersonalDataExport, error) {
+	tx, err := s.beginTx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	export := &PersonalDataExport{ExportedAt: time.Now().UTC().Format(time.RFC3339), Data: map[string]json.RawMessage{}}
+	var key []byte
+	if err := tx.QueryRowContext(ctx, `
+		SELECT row_to_json(k) FROM (
+			SELECT id, owner, role, tenant_id, revoked, created_at, last_used_at FROM api_keys WHERE id = $1
+		) k
+	`, keyID).Scan(&key); err != nil {
+		return nil, err
+	}
+	export.APIKey = key
+
+	for _, t := range personalDataTables {
+		var rows []byte
+		if err := tx.QueryRowContext(ctx, jsonRowsSQL(t.Select), keyID).Scan(&rows); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name, err)
+		}
+		export.Data[t.Name] = rows
+	}
+	return export, tx.Commit()
+}
+
+// deletePersonalData removes everything keyed to keyID and the key, and
+// logs the request; it returns the rows deleted per table
+func (s *Server) deletePersonalData(ctx context.Context, keyID, requestedBy int64) (map[string]int64, error) {
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleted := map[string]int64{}
+	for _, t := range append(personalDataTables, personalDataTable{Name: "api_keys", Delete: `DELETE FROM api_keys WHERE id = $1`}) {
+		res, err := tx.ExecContext(ctx, t.Delete, keyID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			deleted[t.Name] = n
+		}
+	}
+	if deleted["api_keys"] == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	counts, _ := json.Marshal(deleted)
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO data_subject_requests (api_key_id, kind, requested_by, rows_deleted) VALUES ($1, 'delete', $2, $3)
+	`, keyID, requestedBy, string(counts)); err != nil {
+		return nil, err
+	}
+	return deleted, tx.Commit()
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// dataSubjectKey authenticates the request and returns the caller and the
+// key acted on: the caller's own, or ?key_id= for an admin
+func (s *Server) dataSubjectKey(w http.ResponseWriter, r *http.Request) (*APIKey, int64, bool) {
+	caller, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return nil, 0, false
+	}
+	v := r.URL.Query().Get("key_id")
+	if v == "" {
+		return caller, caller.ID, true
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, `{"error": "Invalid key_id"}`, http.StatusBadRequest)
+		return nil, 0, false
+	}
+	if id != caller.ID && !caller.hasRole(roleAdmin) {
+		http.Error(w, `{"error": "Only admins can act on another key"}`, http.StatusForbidden)
+		return nil, 0, false
+	}
+	return caller, id, true
+}
+
+// handleMeExport handles GET /api/me/export
+func (s *Server) handleMeExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	caller, keyID, ok := s.dataSubjectKey(w, r)
+	if !ok {
+		return
+	}
+
+	export, err := s.exportPersonalData(ctx, keyID)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "API key not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO data_subject_requests (api_key_id, kind, requested_by) VALUES ($1, 'export', $2)
+	`, keyID, caller.ID); err != nil {
+		s.log.Printf("Error logging data export for key %d: %v", keyID, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="diversiplant-personal-data-%d.json"`, keyID))
+	writeCompressedJSON(w, r, export)
+}
+
+// handleMeDelete handles POST /api/me/delete
+func (s *Server) handleMeDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	caller, keyID, ok := s.dataSubjectKey(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Confirm bool `json:"confirm"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !req.Confirm {
+		http.Error(w, `{"error": "Deletion cannot be undone; send {\"confirm\": true}"}`, http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.deletePersonalData(ctx, keyID, caller.ID)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "API key not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	s.log.Printf("Deleted personal data of key %d (requested by key %d): %v", keyID, caller.ID, deleted)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key_id":       keyID,
+		"rows_deleted": deleted,
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPersonalDataTables(t *testing.T) {
+	seen := map[string]bool{}
+	for _, tbl := range personalDataTables {
+		if seen[tbl.Name] {
+			t.Errorf("%s listed twice", tbl.Name)
+		}
+		seen[tbl.Name] = true
+		for _, q := range []string{tbl.Select, tbl.Delete} {
+			if !strings.Contains(q, "= $1") {
+				t.Errorf("%s: query not keyed to $1: %s", tbl.Name, q)
+			}
+		}
+		if !strings.HasPrefix(tbl.Delete, "DELETE FROM "+tbl.Name+" ") {
+			t.Errorf("%s: delete touches another table: %s", tbl.Name, tbl.Delete)
+		}
+	}
+	if got := jsonRowsSQL("SELECT 1"); got != "SELECT COALESCE(json_agg(t), '[]') FROM (SELECT 1) t" {
+		t.Errorf("jsonRowsSQL = %q", got)
+	}
+}
+
+func TestMeEndpointsRequireKey(t *testing.T) {
+	s := newTestServer()
+	for _, tc := range []struct {
+		method, url string
+		handler     http.HandlerFunc
+		want        int
+	}{
+		{"GET", "/api/me/export", s.handleMeExport, http.StatusUnauthorized},
+		{"POST", "/api/me/export", s.handleMeExport, http.StatusMethodNotAllowed},
+		{"POST", "/api/me/delete", s.handleMeDelete, http.StatusUnauthorized},
+		{"GET", "/api/me/delete", s.handleMeDelete, http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, tc.url, strings.NewReader(`{"confirm": true}`)))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.url, w.Code, tc.want)
+		}
+	}
+}
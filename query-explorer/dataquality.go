@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// PLAUSIBILITY RULES
+// ============================================================================
+
+// traitBounds is the plausible [Min, Max] range of a trait for a growth form
+type traitBounds struct {
+	Min float64
+	Max float64
+}
+
+// heightBoundsByGrowthForm holds plausible max_height_m ranges (meters)
+var heightBoundsByGrowthForm = map[string]traitBounds{
+	"tree":      {Min: 1.5, Max: 120},
+	"palm":      {Min: 0.5, Max: 70},
+	"bamboo":    {Min: 0.3, Max: 40},
+	"shrub":     {Min: 0.3, Max: 15},
+	"subshrub":  {Min: 0.05, Max: 3},
+	"forb":      {Min: 0.01, Max: 6},
+	"graminoid": {Min: 0.01, Max: 8},
+	"liana":     {Min: 0.5, Max: 80},
+	"vine":      {Min: 0.1, Max: 40},
+	"scrambler": {Min: 0.1, Max: 20},
+}
+
+// lifespanBoundsByGrowthForm holds plausible lifespan_years ranges
+var lifespanBoundsByGrowthForm = map[string]traitBounds{
+	"tree":      {Min: 5, Max: 15000},
+	"palm":      {Min: 5, Max: 700},
+	"forb":      {Min: 0.1, Max: 500},
+	"graminoid": {Min: 0.1, Max: 500},
+}
+
+// traitRuleSet ties a species_unified column to its per-growth-form bounds
+type traitRuleSet struct {
+	trait  string
+	unit   string
+	bounds map[string]traitBounds
+}
+
+var traitRuleSets = []traitRuleSet{
+	{trait: "max_height_m", unit: "m", bounds: heightBoundsByGrowthForm},
+	{trait: "lifespan_years", unit: "years", bounds: lifespanBoundsByGrowthForm},
+}
+
+// flaggedTraitSQL returns a boolean SQL expression that is true when the
+// species (identified by speciesCol) has an open flag on the trait.
+// trait must be a constant column name, never user input.
+func flaggedTraitSQL(speciesCol, trait string) string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM trait_quality_flags tqf
+		WHERE tqf.species_id = %s AND tqf.trait = '%s' AND tqf.status = 'open')`, speciesCol, trait)
+}
+
+// cleanTraitSQL returns the trait column, or NULL when it has an open flag
+func cleanTraitSQL(speciesCol, column, trait string, includeFlagged bool) string {
+	if includeFlagged {
+		return column
+	}
+	return fmt.Sprintf("(CASE WHEN %s THEN NULL ELSE %s END)", flaggedTraitSQL(speciesCol, trait), column)
+}
+
+// ============================================================================
+// DATA-QUALITY JOB
+// ============================================================================
+
+type DataQualitySummary struct {
+	Detected     int64  `json:"detected"`
+	NewFlags     int64  `json:"new_flags"`
+	AutoResolved int64  `json:"auto_resolved"`
+	Duration     string `json:"duration"`
+	FinishedAt   string `json:"finished_at"`
+}
+
+// runTraitQualityCheck scans species_unified against the plausibility rules,
+// opens flags for new violations and resolves open flags whose value has
+// since been corrected.
+func (s *Server) runTraitQualityCheck(ctx context.Context) (*DataQualitySummary, error) {
+	if !s.dataQualityMu.TryLock() {
+		return nil, fmt.Errorf("a data-quality check is already running")
+	}
+	defer s.dataQualityMu.Unlock()
+
+	start := time.Now()
+	summary := &DataQualitySummary{}
+
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TEMP TABLE tqf_detected (
+			species_id INTEGER, trait TEXT, value DECIMAL(10,2),
+			growth_form TEXT, rule TEXT, message TEXT
+		) ON COMMIT DROP
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rs := range traitRuleSets {
+		forms := make([]string, 0, len(rs.bounds))
+		for gf := range rs.bounds {
+			forms = append(forms, gf)
+		}
+		sort.Strings(forms)
+
+		for _, gf := range forms {
+			b := rs.bounds[gf]
+			checks := []struct {
+				rule    string
+				op      string
+				limit   float64
+				message string
+			}{
+				{rs.trait + "_above_max", ">", b.Max, fmt.Sprintf("%s above the %g %s plausible maximum for %s", rs.trait, b.Max, rs.unit, gf)},
+				{rs.trait + "_below_min", "<", b.Min, fmt.Sprintf("%s below the %g %s plausible minimum for %s", rs.trait, b.Min, rs.unit, gf)},
+			}
+			for _, c := range checks {
+				// Column and operator come from the rule tables above
+				res, err := tx.ExecContext(ctx, fmt.Sprintf(`
+					INSERT INTO tqf_detected (species_id, trait, value, growth_form, rule, message)
+					SELECT species_id, $1, %[1]s, growth_form, $2, $3
+					FROM species_unified
+					WHERE growth_form = $4 AND %[1]s IS NOT NULL AND %[1]s %[2]s $5
+				`, rs.trait, c.op), rs.trait, c.rule, c.message, gf, c.limit)
+				if err != nil {
+					return nil, fmt.Errorf("rule %s/%s: %w", c.rule, gf, err)
+				}
+				n, _ := res.RowsAffected()
+				summary.Detected += n
+			}
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO trait_quality_flags (species_id, trait, value, growth_form, rule, message)
+		SELECT species_id, trait, value, growth_form, rule, message FROM tqf_detected
+		ON CONFLICT DO NOTHING
+	`)
+	if err != nil {
+		return nil, err
+	}
+	summary.NewFlags, _ = res.RowsAffected()
+
+	res, err = tx.ExecContext(ctx, `
+		UPDATE trait_quality_flags f
+		SET status = 'resolved', resolved_at = NOW(),
+		    resolution_note = 'Value no longer outside the plausible range'
+		WHERE f.status = 'open'
+		  AND NOT EXISTS (
+		      SELECT 1 FROM tqf_detected d
+		      WHERE d.species_id = f.species_id AND d.trait = f.trait AND d.rule = f.rule
+		  )
+	`)
+	if err != nil {
+		return nil, err
+	}
+	summary.AutoResolved, _ = res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	summary.Duration = time.Since(start).String()
+	summary.FinishedAt = time.Now().Format(time.RFC3339)
+	return summary, nil
+}
+
+// startDataQualityJob runs the trait check periodically in the background
+func (s *Server) startDataQualityJob(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			summary, err := s.runTraitQualityCheck(context.Background())
+			if err != nil {
+				s.log.Printf("Data-quality check failed: %v", err)
+				continue
+			}
+			s.log.Printf("Data-quality check: %d violations, %d new flags, %d auto-resolved (%s)",
+				summary.Detected, summary.NewFlags, summary.AutoResolved, summary.Duration)
+		}
+	}()
+	s.log.Printf("Data-quality job scheduled every %s", interval)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleDataQualityRun handles POST /api/admin/data-quality/run
+func (s *Server) handleDataQualityRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	summary, err := s.runTraitQualityCheck(ctx)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusConflict)
+		return
+	}
+
+	json.NewEncoder(w).Encode(summary)
+}
+
+type TraitFlag struct {
+	ID             int64    `json:"id"`
+	SpeciesID      int64    `json:"species_id"`
+	CanonicalName  string   `json:"canonical_name"`
+	Trait          string   `json:"trait"`
+	Value          *float64 `json:"value"`
+	CurrentValue   *float64 `json:"current_value"`
+	GrowthForm     *string  `json:"growth_form"`
+	Rule           string   `json:"rule"`
+	Message        string   `json:"message"`
+	Status         string   `json:"status"`
+	ResolutionNote *string  `json:"resolution_note,omitempty"`
+	CreatedAt      string   `json:"created_at"`
+}
+
+var traitFlagList = listSpec{
+	DefaultLimit: 100,
+	MaxLimit:     500,
+	Sorts: map[string]string{
+		"created_at": "f.created_at",
+		"trait":      "f.trait",
+	},
+	DefaultSort:   "-created_at",
+	IDColumn:      "f.id",
+	TimeColumn:    "f.created_at",
+	SearchColumns: []string{"s.canonical_name"},
+}
+
+// handleTraitFlags handles GET /api/curation/flags. Besides the list
+// parameters it filters on status (default open), trait and growth_form.
+func (s *Server) handleTraitFlags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.requireRole(w, r, roleCurator); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	trait := r.URL.Query().Get("trait")
+	growthForm := r.URL.Query().Get("growth_form")
+	p, err := parseListParams(r, traitFlagList)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	var total int64
+	s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM trait_quality_flags
+		WHERE status = $1 AND ($2 = '' OR trait = $2) AND ($3 = '' OR growth_form = $3)
+	`, status, trait, growthForm).Scan(&total)
+
+	where, tail, args := p.SQL([]interface{}{status, trait, growthForm})
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT f.id, f.species_id, s.canonical_name, f.trait, f.value,
+		       CASE f.trait WHEN 'max_height_m' THEN su.max_height_m
+		                    WHEN 'lifespan_years' THEN su.lifespan_years END,
+		       f.growth_form, f.rule, f.message, f.status, f.resolution_note,
+		       TO_CHAR(f.created_at, 'YYYY-MM-DD"T"HH24:MI:SS'), `+p.CursorColumn()+`
+		FROM trait_quality_flags f
+		JOIN species s ON f.species_id = s.id
+		LEFT JOIN species_unified su ON f.species_id = su.species_id
+		WHERE f.status = $1 AND ($2 = '' OR f.trait = $2) AND ($3 = '' OR f.growth_form = $3)`+where+`
+		`+tail, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	flags := []TraitFlag{}
+	var keys []listKey
+	for rows.Next() {
+		var f TraitFlag
+		var cursorValue string
+		if err := rows.Scan(&f.ID, &f.SpeciesID, &f.CanonicalName, &f.Trait, &f.Value, &f.CurrentValue,
+			&f.GrowthForm, &f.Rule, &f.Message, &f.Status, &f.ResolutionNote, &f.CreatedAt, &cursorValue); err != nil {
+			s.log.Printf("Error scanning trait flag row: %v", err)
+			continue
+		}
+		flags = append(flags, f)
+		keys = append(keys, listKey{cursorValue, f.ID})
+	}
+
+	n, next := p.trim(keys)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"flags":       flags[:n],
+		"total":       total,
+		"next_cursor": next,
+	})
+}
+
+type FlagResolutionRequest struct {
+	CorrectedValue string `json:"corrected_value,omitempty"`
+	Note           string `json:"note,omitempty"`
+}
+
+// traitFlagAction returns the handler of POST /api/curation/flags/{id}/resolve
+// (resolve) or /dismiss
+func traitFlagAction(resolve bool) func(*Server, http.ResponseWriter, *http.Request) {
+	return func(s *Server, w http.ResponseWriter, r *http.Request) {
+		s.handleTraitFlagAction(w, r, resolve)
+	}
+}
+
+// handleTraitFlagAction resolves or dismisses the flag of the route.
+// Resolving may carry a corrected value that is written to species_unified;
+// dismissing marks the value as verified so it is used again and not
+// re-flagged.
+func (s *Server) handleTraitFlagAction(w http.ResponseWriter, r *http.Request, resolve bool) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid id"}`, http.StatusBadRequest)
+		return
+	}
+
+	curator, ok := s.requireRole(w, r, roleCurator)
+	if !ok {
+		return
+	}
+
+	var req FlagResolutionRequest
+	if r.ContentLength != 0 {
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+	}
+
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to update flag"}`, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var speciesID int64
+	var trait, status string
+	err = tx.QueryRowContext(ctx, `SELECT species_id, trait, status FROM trait_quality_flags WHERE id = $1 FOR UPDATE`, id).
+		Scan(&speciesID, &trait, &status)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Flag not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil || status != "open" {
+		http.Error(w, `{"error": "Flag is not open"}`, http.StatusConflict)
+		return
+	}
+
+	newStatus := "dismissed"
+	if resolve {
+		newStatus = "resolved"
+		if req.CorrectedValue != "" {
+			spec := suggestibleTraits[trait]
+			value, err := parseTraitValue(spec, req.CorrectedValue)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+				return
+			}
+			// spec.column/sourceColumn come from suggestibleTraits
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE species_unified SET %s = $2, %s = 'curated' WHERE species_id = $1`,
+				spec.column, spec.sourceColumn), speciesID, value)
+			if err != nil {
+				http.Error(w, `{"error": "Failed to apply corrected value"}`, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE trait_quality_flags
+		SET status = $2, resolved_by = $3, resolved_at = NOW(), resolution_note = NULLIF($4, '')
+		WHERE id = $1
+	`, id, newStatus, curator.ID, req.Note)
+	if err != nil || tx.Commit() != nil {
+		http.Error(w, `{"error": "Failed to update flag"}`, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": newStatus})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCleanTraitSQL(t *testing.T) {
+	if got := cleanTraitSQL("su.species_id", "su.max_height_m", "max_height_m", true); got != "su.max_height_m" {
+		t.Errorf("with include_flagged_traits: %s", got)
+	}
+
+	got := cleanTraitSQL("su.species_id", "su.max_height_m", "max_height_m", false)
+	for _, want := range []string{
+		"CASE WHEN EXISTS (SELECT 1 FROM trait_quality_flags tqf",
+		"tqf.species_id = su.species_id AND tqf.trait = 'max_height_m' AND tqf.status = 'open'",
+		"THEN NULL ELSE su.max_height_m END",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%s\nlacks %s", got, want)
+		}
+	}
+	if _, err := validateReadOnlyQuery("SELECT " + got + " FROM species_unified su"); err != nil {
+		t.Errorf("does not parse: %v", err)
+	}
+}
+
+func TestRunTraitQualityCheck(t *testing.T) {
+	s, db := newFakeDBServer(t,
+		fakeQuery{match: "CREATE TEMP TABLE tqf_detected"},
+		fakeQuery{
+			match: "INSERT INTO tqf_detected",
+			// One tree taller than 120 m
+			when: func(args []driver.Value) bool { return args[1] == "max_height_m_above_max" && args[3] == "tree" },
+			rows: [][]driver.Value{{}},
+		},
+		fakeQuery{match: "INSERT INTO tqf_detected"},
+		fakeQuery{match: "INSERT INTO trait_quality_flags", rows: [][]driver.Value{{}}},
+		fakeQuery{match: "SET status = 'resolved', resolved_at = NOW()", rows: [][]driver.Value{{}, {}}},
+	)
+
+	summary, err := s.runTraitQualityCheck(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Detected != 1 || summary.NewFlags != 1 || summary.AutoResolved != 2 {
+		t.Errorf("summary %+v", summary)
+	}
+	if db.index("COMMIT") < 0 {
+		t.Error("check not committed")
+	}
+
+	// Two rules per growth form and trait
+	rules := db.executed("INSERT INTO tqf_detected")
+	if want := 2 * (len(heightBoundsByGrowthForm) + len(lifespanBoundsByGrowthForm)); len(rules) != want {
+		t.Errorf("%d rule statements, want %d", len(rules), want)
+	}
+	args := db.argsOf("INSERT INTO tqf_detected")
+	heights := db.executed("max_height_m > $5")
+	if len(heights) != len(heightBoundsByGrowthForm) || len(db.executed("lifespan_years < $5")) != len(lifespanBoundsByGrowthForm) {
+		t.Errorf("%d height maximum statements", len(heights))
+	}
+	// Growth forms in order, bamboo first
+	if args[0] != "max_height_m" || args[1] != "max_height_m_above_max" || args[3] != "bamboo" || args[4] != 40.0 ||
+		args[2] != "max_height_m above the 40 m plausible maximum for bamboo" {
+		t.Errorf("first rule args %v", args)
+	}
+}
+
+func TestTraitFlagActions(t *testing.T) {
+	flag := func(id int64, trait, status string) fakeQuery {
+		return fakeQuery{
+			match:   "FROM trait_quality_flags WHERE id = $1 FOR UPDATE",
+			when:    func(args []driver.Value) bool { return args[0] == id },
+			columns: []string{"species_id", "trait", "status"},
+			rows:    [][]driver.Value{{int64(42), trait, status}},
+		}
+	}
+	for _, tc := range []struct {
+		name, path, body string
+		code             int
+		status           string
+		corrected        interface{}
+	}{
+		{"resolve", "/api/curation/flags/5/resolve", `{"note": "typo in source"}`, http.StatusOK, "resolved", nil},
+		{"resolve with value", "/api/curation/flags/5/resolve", `{"corrected_value": "12.5"}`, http.StatusOK, "resolved", 12.5},
+		{"invalid value", "/api/curation/flags/5/resolve", `{"corrected_value": "-3"}`, http.StatusBadRequest, "", nil},
+		{"dismiss", "/api/curation/flags/5/dismiss", "", http.StatusOK, "dismissed", nil},
+		{"resolve closed", "/api/curation/flags/6/resolve", "", http.StatusConflict, "", nil},
+		{"dismiss closed", "/api/curation/flags/6/dismiss", "", http.StatusConflict, "", nil},
+		{"unknown", "/api/curation/flags/7/dismiss", "", http.StatusNotFound, "", nil},
+		{"invalid id", "/api/curation/flags/x/dismiss", "", http.StatusBadRequest, "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, db := newFakeDBServer(t, fakeAdminKey,
+				flag(5, "max_height_m", "open"),
+				flag(6, "max_height_m", "resolved"),
+				fakeQuery{match: "FROM trait_quality_flags WHERE id = $1 FOR UPDATE", columns: []string{"species_id", "trait", "status"}},
+				fakeQuery{match: "UPDATE species_unified SET max_height_m = $2, height_source = 'curated'"},
+				fakeQuery{match: "SET status = $2, resolved_by = $3"},
+			)
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer admin")
+			w := httptest.NewRecorder()
+			s.router().ServeHTTP(w, req)
+			if w.Code != tc.code {
+				t.Fatalf("%d %s, want %d", w.Code, w.Body.String(), tc.code)
+			}
+
+			update := db.argsOf("SET status = $2, resolved_by = $3")
+			if tc.status == "" {
+				if update != nil || db.index("UPDATE species_unified") >= 0 || db.index("COMMIT") >= 0 {
+					t.Errorf("flag changed: %q", db.log)
+				}
+				return
+			}
+			if len(update) != 4 || update[0] != int64(5) || update[1] != tc.status || update[2] != int64(1) || db.index("COMMIT") < 0 {
+				t.Errorf("flag updated with %v, log %q", update, db.log)
+			}
+			if value := db.argsOf("UPDATE species_unified"); (tc.corrected == nil) != (value == nil) || (value != nil && value[1] != tc.corrected) {
+				t.Errorf("species_unified updated with %v, want %v", value, tc.corrected)
+			}
+			if !strings.Contains(w.Body.String(), `"status":"`+tc.status+`"`) {
+				t.Errorf("body %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// DEMO MODE
+// ============================================================================
+//
+// DEMO_MODE=true turns a deployment into a public try-it instance. Requests
+// without a valid API key, or with the published DEMO_API_KEY, are demo
+// requests:
+//
+//   - read-only: GET on the catalog, climate and species endpoints, and POST
+//     only to the recommendation endpoints that compute without storing;
+//     ad-hoc SQL, jobs, exports, plans, curation and admin are refused (403)
+//   - small quotas: DEMO_RATE_LIMIT per client IP and DEMO_DAILY_QUOTA
+//     requests a day, on top of the normal rate limits, and recommendations
+//     of at most DEMO_MAX_SPECIES species
+//   - watermarked: CSV downloads start with a notice row and recommendations
+//     carry a watermark field, so demo output is not mistaken for a report
+//
+// Requests with a real API key, and the public widget API (see public.go,
+// limited on its own), are not affected. GET /api/demo describes the demo
+// for the try-it page, and is 404 when demo mode is off.
+
+const (
+	defaultDemoAPIKey     = "demo"
+	defaultDemoRateLimit  = "20/m"
+	defaultDemoDailyQuota = 300
+	defaultDemoMaxSpecies = 20
+	demoWatermark         = "DiversiPlant demo: for evaluation only, not for official use"
+)
+
+// demoBlockedPaths are refused to demo requests, as prefixes
+var demoBlockedPaths = []string{
+	"/api/query", // Also /api/query/async, /api/query/jobs/...
+	"/api/queries",
+	"/api/export/",
+	"/api/recommend/batch",
+	"/api/recommend/sandbox",
+	"/api/recommend/sensitivity",
+	"/api/plans",
+	"/api/observations",
+	"/api/suggestions/",
+	"/api/curation",
+	"/api/admin/",
+	"/api/tenant/",
+	"/api/notifications",
+}
+
+// demoPostPaths compute a response without writing anything
+var demoPostPaths = []string{
+	"/api/recommend",
+	"/api/recommend/stream",
+	"/api/recommend/explain",
+	"/api/compliance/check",
+}
+
+type demoConfig struct {
+	Enabled    bool
+	APIKey     string
+	RateLimit  rateLimit
+	DailyQuota int
+	MaxSpecies int
+}
+
+// loadDemoConfig reads DEMO_MODE, DEMO_API_KEY, DEMO_RATE_LIMIT,
+// DEMO_DAILY_QUOTA and DEMO_MAX_SPECIES
+func loadDemoConfig() demoConfig {
+	c := demoConfig{
+		Enabled:    getEnv("DEMO_MODE", "false") == "true",
+		APIKey:     getEnv("DEMO_API_KEY", defaultDemoAPIKey),
+		DailyQuota: getEnvInt("DEMO_DAILY_QUOTA", defaultDemoDailyQuota),
+		MaxSpecies: getEnvInt("DEMO_MAX_SPECIES", defaultDemoMaxSpecies),
+	}
+	var err error
+	if c.RateLimit, err = parseRateLimit(getEnv("DEMO_RATE_LIMIT", defaultDemoRateLimit)); err != nil {
+		log.Printf("DEMO_RATE_LIMIT: %v, using %s", err, defaultDemoRateLimit)
+		c.RateLimit, _ = parseRateLimit(defaultDemoRateLimit)
+	}
+	if c.MaxSpecies <= 0 {
+		c.MaxSpecies = defaultDemoMaxSpecies
+	}
+	return c
+}
+
+// demoAllows reports whether a demo request may use method on path
+func demoAllows(method, path string) bool {
+	for _, prefix := range demoBlockedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		for _, p := range demoPostPaths {
+			if path == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type demoContextKey struct{}
+
+// demoFromContext returns the demo settings of a demo request, nil for
+// any other
+func demoFromContext(ctx context.Context) *demoConfig {
+	cfg, _ := ctx.Value(demoContextKey{}).(*demoConfig)
+	return cfg
+}
+
+func isDemoRequest(ctx context.Context) bool {
+	return demoFromContext(ctx) != nil
+}
+
+// demoCapSpecies limits n_species of a demo recommendation; 0 would return
+// every candidate
+func demoCapSpecies(ctx context.Context, req *RecommendRequest) {
+	cfg := demoFromContext(ctx)
+	if cfg == nil {
+		return
+	}
+	if req.NSpecies == 0 || req.NSpecies > cfg.MaxSpecies {
+		req.NSpecies = cfg.MaxSpecies
+	}
+}
+
+// watermark is the notice demo output carries, "" otherwise
+func watermark(ctx context.Context) string {
+	if isDemoRequest(ctx) {
+		return demoWatermark
+	}
+	return ""
+}
+
+// demoMiddleware restricts and meters demo requests; it sits outside the
+// rate limiter, which still applies
+func (s *Server) demoMiddleware(next http.Handler, cfg demoConfig) http.Handler {
+	if !cfg.Enabled {
+		return next
+	}
+
+	limiter := newRateLimiter()
+	daily := rateLimit{Requests: cfg.DailyQuota, Period: 24 * time.Hour}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			limiter.sweep(now.Add(-24 * time.Hour))
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if raw := apiKeyFromRequest(r); raw != "" && raw != cfg.APIKey {
+			if key := s.lookupRateLimitKey(r.Context(), raw); key.id != 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("X-Demo-Mode", "true")
+		if !demoAllows(r.Method, r.URL.Path) {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Not available in the demo; use an API key"}`, http.StatusForbidden)
+			return
+		}
+
+		ip := clientIP(r)
+		if ip == nil || !s.cfg.RateLimits.isExempt(ip) {
+			now := time.Now()
+			for _, bucket := range []struct {
+				name  string
+				limit rateLimit
+			}{{"rate", cfg.RateLimit}, {"daily", daily}} {
+				if bucket.limit.Requests <= 0 {
+					continue
+				}
+				if ok, _, wait := limiter.take(ip.String()+"|"+bucket.name, bucket.limit, 1, now); !ok {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					w.Header().Set("Content-Type", "application/json")
+					http.Error(w, `{"error": "Demo quota exceeded, retry later"}`, http.StatusTooManyRequests)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), demoContextKey{}, &cfg)))
+	})
+}
+
+// handleDemo handles GET /api/demo
+func (s *Server) handleDemo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cfg := s.cfg.Demo
+	if !cfg.Enabled {
+		http.Error(w, `{"error": "Demo mode is not enabled"}`, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_key": cfg.APIKey,
+		"rate_limit": map[string]interface{}{
+			"requests":       cfg.RateLimit.Requests,
+			"period_seconds": cfg.RateLimit.Period.Seconds(),
+		},
+		"daily_quota":   cfg.DailyQuota,
+		"max_species":   cfg.MaxSpecies,
+		"blocked_paths": demoBlockedPaths,
+		"post_paths":    demoPostPaths,
+		"watermark":     demoWatermark,
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDemoAllows(t *testing.T) {
+	for _, tc := range []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/api/species", true},
+		{"GET", "/api/species/42", true},
+		{"GET", "/api/climate/point", true},
+		{"POST", "/api/recommend", true},
+		{"POST", "/api/recommend/stream", true},
+		{"POST", "/api/recommend/batch", false},
+		{"POST", "/api/recommend/sandbox", false},
+		{"POST", "/api/query", false},
+		{"GET", "/api/query/jobs/abc", false},
+		{"GET", "/api/queries", false},
+		{"GET", "/api/export/species.csv", false},
+		{"GET", "/api/admin/retention", false},
+		{"POST", "/api/observations", false},
+		{"DELETE", "/api/species/42", false},
+		{"POST", "/api/climate/match", false},
+	} {
+		if got := demoAllows(tc.method, tc.path); got != tc.want {
+			t.Errorf("%s %s: %v, want %v", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestDemoCapSpecies(t *testing.T) {
+	ctx := context.WithValue(context.Background(), demoContextKey{}, &demoConfig{MaxSpecies: 20})
+	for n, want := range map[int]int{0: 20, 10: 10, 200: 20} {
+		req := RecommendRequest{NSpecies: n}
+		demoCapSpecies(ctx, &req)
+		if req.NSpecies != want {
+			t.Errorf("n_species %d: %d, want %d", n, req.NSpecies, want)
+		}
+	}
+	req := RecommendRequest{NSpecies: 200}
+	demoCapSpecies(context.Background(), &req)
+	if req.NSpecies != 200 {
+		t.Error("requests outside the demo must not be capped")
+	}
+}
+
+func TestDemoMiddleware(t *testing.T) {
+	s := newTestServer()
+	cfg := demoConfig{Enabled: true, APIKey: "demo", RateLimit: rateLimit{Requests: 2, Period: 60e9}, DailyQuota: 100, MaxSpecies: 20}
+	var sawDemo bool
+	handler := s.demoMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawDemo = isDemoRequest(r.Context())
+		newCSVResponse(w, r, "x.csv").Flush()
+	}), cfg)
+
+	serve := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "203.0.113.7:4000"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("POST", "/api/query", ""); w.Code != http.StatusForbidden {
+		t.Errorf("ad-hoc SQL: %d, want 403", w.Code)
+	}
+	w := serve("GET", "/api/species?format=csv", "demo")
+	if w.Code != http.StatusOK || !sawDemo || w.Header().Get("X-Demo-Mode") != "true" {
+		t.Fatalf("demo key: %d, demo %v", w.Code, sawDemo)
+	}
+	if line, _, _ := strings.Cut(w.Body.String(), "\n"); !strings.Contains(line, demoWatermark) {
+		t.Errorf("CSV not watermarked: %q", w.Body.String())
+	}
+	serve("GET", "/api/species", "")
+	if w := serve("GET", "/api/species", ""); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("third request: %d, want 429", w.Code)
+	}
+}
+
+func TestCSVWithoutDemoHasNoWatermark(t *testing.T) {
+	w := httptest.NewRecorder()
+	newCSVResponse(w, httptest.NewRequest("GET", "/api/species", nil), "x.csv").Flush()
+	if w.Body.Len() != 0 {
+		t.Errorf("unexpected output %q", w.Body.String())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// REPRODUCIBLE RECOMMENDATIONS
+// ============================================================================
+//
+// Published restoration plans must be reproducible. The selection itself is
+// deterministic: candidates come ordered by climate match and species ID,
+// and ties are broken without regard to candidate order (see
+// preferCandidate). What remains random is seeded:
+//
+//   - random_seed seeds every random choice that has no seed of its own
+//     (start_seed of random_top_k defaults to it)
+//   - deterministic: true without random_seed derives the seed from the
+//     request, so the same request always gives the same list
+//   - otherwise a fresh seed is drawn and echoed, and sending it back
+//     reproduces the result
+//
+// Seeds the server filled in are not part of the request the client made:
+// they are left out of the cache key, or every unseeded request would get
+// a key of its own, and out of the research dataset, where a drawn seed
+// would be the request time to the nanosecond.
+//
+// selection_hash fingerprints the ranked species, so a published plan can
+// be checked against a later run of the same request.
+
+// seed returns the seed for a random choice without its own
+func (r *RecommendRequest) seed() int64 {
+	if r.RandomSeed != nil {
+		return *r.RandomSeed
+	}
+	if r.Deterministic {
+		return requestSeed(*r)
+	}
+	return time.Now().UnixNano()
+}
+
+// withClientSeeds returns r without the seeds the server filled in
+func (r RecommendRequest) withClientSeeds() RecommendRequest {
+	if r.startSeedFilled {
+		r.StartSeed = nil
+	}
+	if r.randomSeedFilled {
+		r.RandomSeed = nil
+	}
+	return r
+}
+
+// requestSeed derives a non-negative seed from the request, ignoring seeds
+// already filled in
+func requestSeed(req RecommendRequest) int64 {
+	req.StartSeed, req.RandomSeed = nil, nil
+	sum := sha256.Sum256([]byte(req.CacheKey()))
+	return int64(binary.BigEndian.Uint64(sum[:8]) >> 1)
+}
+
+// selectionHash fingerprints the species IDs in rank order
+func selectionHash(species []SpeciesRecommendation) string {
+	h := sha256.New()
+	for _, sp := range species {
+		h.Write([]byte(strconv.FormatInt(sp.SpeciesID, 10)))
+		h.Write([]byte{','})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// ============================================================================
+// RECOMMENDATION DISPLAY
+// ============================================================================
+//
+// ?display= on /api/recommend and /api/recommend/batch changes how the
+// selected species are listed, not which are selected:
+//
+//	display=common_name_pt   display_name is the Portuguese common name,
+//	                         the scientific name when there is none, and the
+//	                         list is sorted by it in Portuguese collation
+//	                         (case and accents ignored: "ipê" by "ipe")
+//	display=scientific_name  display_name is the scientific name, sorted
+//	                         alphabetically
+//
+// Without it the list keeps the selection order. Field teams read species
+// lists by common name, so this is what exports for them should use. Like
+// the language it is presentation only: it is applied after the cache, and
+// selection_rank still tells each species' rank.
+
+const (
+	displayCommonNamePT   = "common_name_pt"
+	displayScientificName = "scientific_name"
+)
+
+var displayModes = map[string]bool{displayCommonNamePT: true, displayScientificName: true}
+
+// requestDisplay reads ?display=, answering 400 for an unknown mode
+func requestDisplay(w http.ResponseWriter, r *http.Request) (string, bool) {
+	mode := r.URL.Query().Get("display")
+	if mode != "" && !displayModes[mode] {
+		http.Error(w, `{"error": "display must be common_name_pt or scientific_name"}`, http.StatusBadRequest)
+		return "", false
+	}
+	return mode, true
+}
+
+// portugueseName is the species' Portuguese common name, nil without one:
+// the localized name when it is Portuguese, else the candidate query's
+func portugueseName(sp SpeciesRecommendation) *string {
+	if sp.CommonName != nil && sp.CommonNameLanguage != nil && *sp.CommonNameLanguage == "pt" {
+		return sp.CommonName
+	}
+	return sp.CommonNamePT
+}
+
+// applyDisplay labels and sorts resp's species for mode ("" leaves them)
+func applyDisplay(resp *RecommendResponse, mode string) {
+	if mode == "" {
+		return
+	}
+	resp.Display = mode
+	for i, sp := range resp.Species {
+		resp.Species[i].DisplayName = sp.CanonicalName
+		if name := portugueseName(sp); mode == displayCommonNamePT && name != nil && *name != "" {
+			resp.Species[i].DisplayName = *name
+		}
+	}
+
+	c := collate.New(language.BrazilianPortuguese, collate.Loose)
+	sort.SliceStable(resp.Species, func(i, j int) bool {
+		a, b := resp.Species[i], resp.Species[j]
+		if cmp := c.CompareString(a.DisplayName, b.DisplayName); cmp != 0 {
+			return cmp < 0
+		}
+		return a.CanonicalName < b.CanonicalName
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApplyDisplayCommonNamePT(t *testing.T) {
+	pt := func(s string) *string { return &s }
+	resp := &RecommendResponse{Species: []SpeciesRecommendation{
+		{CanonicalName: "Handroanthus albus", CommonNamePT: pt("Ipê-amarelo"), SelectionRank: 1},
+		{CanonicalName: "Schinus terebinthifolia", CommonNamePT: pt("aroeira-pimenteira"), SelectionRank: 2},
+		{CanonicalName: "Inga vera", SelectionRank: 3},
+		{CanonicalName: "Cecropia pachystachya", CommonName: pt("embaúba"), CommonNameLanguage: pt("pt"), SelectionRank: 4},
+		{CanonicalName: "Eugenia uniflora", CommonName: pt("Surinam cherry"), CommonNameLanguage: pt("en"), CommonNamePT: pt("pitanga"), SelectionRank: 5},
+	}}
+	applyDisplay(resp, displayCommonNamePT)
+
+	want := []string{"aroeira-pimenteira", "embaúba", "Inga vera", "Ipê-amarelo", "pitanga"}
+	for i, sp := range resp.Species {
+		if sp.DisplayName != want[i] {
+			t.Errorf("species[%d] = %q, want %q", i, sp.DisplayName, want[i])
+		}
+	}
+	if resp.Display != displayCommonNamePT || resp.Species[0].SelectionRank != 2 {
+		t.Errorf("display %q, first rank %d", resp.Display, resp.Species[0].SelectionRank)
+	}
+	body, _ := json.Marshal(resp)
+	checkSchema(t, "RecommendResponse", body)
+}
+
+func TestApplyDisplayDefault(t *testing.T) {
+	resp := &RecommendResponse{Species: []SpeciesRecommendation{{CanonicalName: "B b"}, {CanonicalName: "A a"}}}
+	applyDisplay(resp, "")
+	if resp.Species[0].CanonicalName != "B b" || resp.Species[0].DisplayName != "" {
+		t.Error("default display changed the selection order")
+	}
+	applyDisplay(resp, displayScientificName)
+	if resp.Species[0].DisplayName != "A a" {
+		t.Errorf("scientific_name order: %+v", resp.Species)
+	}
+}
+
+func TestRequestDisplay(t *testing.T) {
+	w := httptest.NewRecorder()
+	if _, ok := requestDisplay(w, httptest.NewRequest("POST", "/api/recommend?display=latin", nil)); ok || w.Code != 400 {
+		t.Errorf("unknown display accepted (%d)", w.Code)
+	}
+	if mode, ok := requestDisplay(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/recommend?display=common_name_pt", nil)); !ok || mode != displayCommonNamePT {
+		t.Errorf("display = %q, %v", mode, ok)
+	}
+}
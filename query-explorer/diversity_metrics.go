@@ -0,0 +1,120 @@
+package main
+
+// ============================================================================
+// INCREMENTAL DIVERSITY METRICS
+// ============================================================================
+//
+// calculateDiversityMetrics recomputes every pairwise distance of a
+// selection, O(n²). diversityAccumulator keeps the running pairwise distance
+// sum and the family and growth-form counts instead, so adding or removing
+// one species costs O(n), the distances to the other members. Sandboxes keep
+// one across edits; the greedy loop similarly keeps each candidate's
+// distance to its nearest selected species (calculateMarginalDiversity)
+// up to date as species are picked.
+
+type diversityAccumulator struct {
+	traits map[int64]TraitVector
+
+	members       []SpeciesRecommendation
+	families      map[string]int
+	growthForms   map[string]int
+	totalDistance float64
+}
+
+func newDiversityAccumulator(traits map[int64]TraitVector) *diversityAccumulator {
+	return &diversityAccumulator{
+		traits:      traits,
+		families:    map[string]int{},
+		growthForms: map[string]int{},
+	}
+}
+
+// add includes sp in the selection
+func (a *diversityAccumulator) add(sp SpeciesRecommendation) {
+	t := a.traits[sp.SpeciesID]
+	for _, m := range a.members {
+		a.totalDistance += gowerDistance(t, a.traits[m.SpeciesID])
+	}
+	a.members = append(a.members, sp)
+	a.families[sp.Family]++
+	a.growthForms[sp.GrowthForm]++
+}
+
+// remove takes species id out of the selection; unknown ids are ignored
+func (a *diversityAccumulator) remove(id int64) {
+	idx := -1
+	for i, m := range a.members {
+		if m.SpeciesID == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return
+	}
+	sp := a.members[idx]
+	a.members = append(a.members[:idx], a.members[idx+1:]...)
+
+	t := a.traits[id]
+	for _, m := range a.members {
+		a.totalDistance -= gowerDistance(t, a.traits[m.SpeciesID])
+	}
+	if len(a.members) < 2 {
+		// No pairs left: drop the rounding error of the subtractions
+		a.totalDistance = 0
+	}
+	decrement(a.families, sp.Family)
+	decrement(a.growthForms, sp.GrowthForm)
+}
+
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
+
+// sync makes next the selection, adding and removing only the species that
+// changed since the last call
+func (a *diversityAccumulator) sync(next []SpeciesRecommendation) {
+	inNext := make(map[int64]bool, len(next))
+	for _, sp := range next {
+		inNext[sp.SpeciesID] = true
+	}
+	current := make(map[int64]bool, len(a.members))
+	for _, m := range append([]SpeciesRecommendation(nil), a.members...) {
+		current[m.SpeciesID] = true
+		if !inNext[m.SpeciesID] {
+			a.remove(m.SpeciesID)
+		}
+	}
+	for _, sp := range next {
+		if !current[sp.SpeciesID] {
+			a.add(sp)
+		}
+	}
+}
+
+func (a *diversityAccumulator) metrics(weights MetricWeights) DiversityMetrics {
+	n := len(a.members)
+	return summarizeDiversity(n, len(a.families), a.growthForms, a.totalDistance, n*(n-1)/2, weights)
+}
+
+// clone copies the accumulator; the trait map is shared
+func (a *diversityAccumulator) clone() *diversityAccumulator {
+	c := &diversityAccumulator{
+		traits:        a.traits,
+		members:       append([]SpeciesRecommendation(nil), a.members...),
+		families:      make(map[string]int, len(a.families)),
+		growthForms:   make(map[string]int, len(a.growthForms)),
+		totalDistance: a.totalDistance,
+	}
+	for k, v := range a.families {
+		c.families[k] = v
+	}
+	for k, v := range a.growthForms {
+		c.growthForms[k] = v
+	}
+	return c
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func metricsClose(a, b DiversityMetrics) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.NSpecies == b.NSpecies && a.NFamilies == b.NFamilies && a.NGrowthForms == b.NGrowthForms &&
+		near(a.FunctionalDiversity, b.FunctionalDiversity) &&
+		near(a.PhylogeneticDiversity, b.PhylogeneticDiversity) &&
+		near(a.GrowthFormRichness, b.GrowthFormRichness) &&
+		near(a.TotalDiversityScore, b.TotalDiversityScore)
+}
+
+func TestDiversityAccumulatorMatchesFullRecompute(t *testing.T) {
+	pool, traits := testPool()
+	acc := newDiversityAccumulator(traits)
+	var selection []SpeciesRecommendation
+	check := func(step string) {
+		t.Helper()
+		want := calculateDiversityMetrics(selection, traits, defaultMetricWeights)
+		if got := acc.metrics(defaultMetricWeights); !metricsClose(got, want) {
+			t.Errorf("%s: got %+v, want %+v", step, got, want)
+		}
+	}
+
+	for _, sp := range pool {
+		acc.add(sp)
+		selection = append(selection, sp)
+		check("add")
+	}
+	for len(selection) > 0 {
+		// Remove from the middle, so members are not only popped
+		i := len(selection) / 2
+		acc.remove(selection[i].SpeciesID)
+		selection = append(selection[:i], selection[i+1:]...)
+		check("remove")
+	}
+
+	acc.remove(42)
+	check("unknown id")
+
+	acc.sync(pool[:3])
+	selection = append([]SpeciesRecommendation(nil), pool[:3]...)
+	check("sync")
+	copied := acc.clone()
+	acc.sync(pool[2:6])
+	selection = append([]SpeciesRecommendation(nil), pool[2:6]...)
+	check("sync")
+	if got := copied.metrics(defaultMetricWeights); got.NSpecies != 3 {
+		t.Errorf("clone changed with the original: %+v", got)
+	}
+}
+
+func TestSandboxMetricsFollowEdits(t *testing.T) {
+	ctx := context.Background()
+	sb := testSandbox(3)
+	pool, _ := testPool()
+	updates := []SandboxUpdate{
+		{Remove: []int64{sb.selected[0].SpeciesID}},
+		{Lock: []int64{pool[len(pool)-1].SpeciesID}},
+		{Restore: []int64{sb.selected[0].SpeciesID}},
+	}
+	for _, u := range updates {
+		if err := sb.apply(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		want := calculateDiversityMetrics(sb.selected, sb.traits, sb.req.metricWeights())
+		if got := sb.diversity.metrics(sb.req.metricWeights()); !metricsClose(got, want) {
+			t.Errorf("after %+v: got %+v, want %+v", u, got, want)
+		}
+	}
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // EcoregionRequest represents the request for ecoregion species lookup
@@ -17,6 +19,7 @@ type EcoregionRequest struct {
 	Limit            int      `json:"limit"`
 	ClimateThreshold float64  `json:"climate_threshold"`
 	GrowthForms      []string `json:"growth_forms"`
+	AOIID            string   `json:"aoi_id,omitempty"` // Instead of the coordinates: the area's point on surface
 }
 
 // EcoregionInfo contains information about the ecoregion at a location
@@ -41,16 +44,19 @@ type BiomeClimate struct {
 
 // EcoregionSpecies represents a species found in the biome
 type EcoregionSpecies struct {
-	SpeciesID         int64    `json:"species_id"`
-	CanonicalName     string   `json:"canonical_name"`
-	Family            string   `json:"family"`
-	GrowthForm        *string  `json:"growth_form"`
-	MaxHeightM        *float64 `json:"max_height_m"`
-	LifespanYears     *float64 `json:"lifespan_years"`
-	ThreatStatus      *string  `json:"threat_status"`
-	ClimateMatchScore float64  `json:"climate_match_score"`
-	NEcoregions       int      `json:"n_ecoregions"`
-	NObservations     int      `json:"n_observations"`
+	SpeciesID          int64    `json:"species_id"`
+	CanonicalName      string   `json:"canonical_name"`
+	Family             string   `json:"family"`
+	GrowthForm         *string  `json:"growth_form"`
+	MaxHeightM         *float64 `json:"max_height_m"`
+	LifespanYears      *float64 `json:"lifespan_years"`
+	ThreatStatus       *string  `json:"threat_status"`
+	ThreatStatusLabel  *string  `json:"threat_status_label,omitempty"`
+	CommonName         *string  `json:"common_name,omitempty"`
+	CommonNameLanguage *string  `json:"common_name_language,omitempty"` // Requested language or the nearest fallback
+	ClimateMatchScore  float64  `json:"climate_match_score"`
+	NEcoregions        int      `json:"n_ecoregions"`
+	NObservations      int      `json:"n_observations"`
 }
 
 // EcoregionResponse contains the full response
@@ -63,17 +69,22 @@ type EcoregionResponse struct {
 }
 
 // handleEcoregionSpecies handles GET/POST /api/ecoregion/species
-func handleEcoregionSpecies(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleEcoregionSpecies(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
 	var req EcoregionRequest
 
 	if r.Method == http.MethodPost {
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		if !decodeJSONBody(w, r, &req) {
 			return
 		}
 	} else if r.Method == http.MethodGet {
+		if err := s.resolveAOIQuery(r); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+
 		// Parse query parameters
 		lat, _ := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
 		lon, _ := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
@@ -91,6 +102,15 @@ func handleEcoregionSpecies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.AOIID != "" {
+		a, _, err := s.aoiSite(ctx, req.AOIID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		req.Latitude, req.Longitude = a.Latitude, a.Longitude
+	}
+
 	// Validate coordinates
 	if req.Latitude < -90 || req.Latitude > 90 || req.Longitude < -180 || req.Longitude > 180 {
 		http.Error(w, `{"error": "Invalid coordinates"}`, http.StatusBadRequest)
@@ -108,37 +128,56 @@ func handleEcoregionSpecies(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
 	// Get ecoregion at coordinates
-	ecoregion, err := getEcoregionAtPoint(req.Latitude, req.Longitude)
+	ecoregion, err := s.getEcoregionAtPoint(ctx, req.Latitude, req.Longitude)
 	if err != nil {
-		log.Printf("Error getting ecoregion: %v", err)
+		s.log.Printf("Error getting ecoregion: %v", err)
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to get ecoregion: %s"}`, err.Error()), http.StatusInternalServerError)
 		return
 	}
 
 	// Get climate at coordinates
-	climate, err := getClimateAtCoords(req.Latitude, req.Longitude)
+	climate, err := s.getClimateAtCoords(ctx, req.Latitude, req.Longitude)
 	if err != nil {
-		log.Printf("Error getting climate: %v", err)
+		s.log.Printf("Error getting climate: %v", err)
 		// Continue without climate data
 		climate = BiomeClimate{}
 	}
 
 	// Resolve TDWG code for the coordinates (to merge WCVP species)
 	var tdwgCode string
-	err = db.QueryRow(`SELECT level3_code FROM get_tdwg_by_coords($1, $2)`, req.Latitude, req.Longitude).Scan(&tdwgCode)
+	err = s.db.QueryRowContext(ctx, `SELECT level3_code FROM get_tdwg_by_coords($1, $2)`, req.Latitude, req.Longitude).Scan(&tdwgCode)
 	if err != nil {
-		log.Printf("Warning: could not resolve TDWG code for coords: %v", err)
+		s.log.Printf("Warning: could not resolve TDWG code for coords: %v", err)
 		// Continue without TDWG enrichment
 	}
 
 	// Get species for the biome with climate adaptation (+ TDWG enrichment)
-	species, totalInBiome, err := getSpeciesForBiome(ecoregion.BiomeNum, climate, req.ClimateThreshold, req.Limit, req.GrowthForms, tdwgCode)
+	species, totalInBiome, err := s.getSpeciesForBiome(ctx, ecoregion.BiomeNum, climate, req.ClimateThreshold, req.Limit, req.GrowthForms, tdwgCode)
 	if err != nil {
-		log.Printf("Error getting species: %v", err)
+		s.log.Printf("Error getting species: %v", err)
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to get species: %s"}`, err.Error()), http.StatusInternalServerError)
 		return
 	}
 
+	lang := requestLanguage(r)
+	ecoregion.EcoName = s.localize(ctx, nameKindEcoregion, strconv.Itoa(ecoregion.EcoID), lang, ecoregion.EcoName)
+	ecoregion.BiomeName = s.localize(ctx, nameKindBiome, strconv.Itoa(ecoregion.BiomeNum), lang, ecoregion.BiomeName)
+	ids := make([]int64, len(species))
+	for i := range species {
+		species[i].ThreatStatusLabel = threatStatusLabel(species[i].ThreatStatus, lang)
+		ids[i] = species[i].SpeciesID
+	}
+	names, err := s.commonNames(ctx, ids, lang)
+	if err != nil {
+		s.log.Printf("Error loading common names: %v", err)
+	}
+	for i := range species {
+		if cn, ok := names[species[i].SpeciesID]; ok {
+			species[i].CommonName, species[i].CommonNameLanguage = &cn.Name, &cn.Language
+		}
+	}
+	setContentLanguage(w, lang)
+
 	response := EcoregionResponse{
 		Ecoregion:    ecoregion,
 		Climate:      climate,
@@ -151,12 +190,12 @@ func handleEcoregionSpecies(w http.ResponseWriter, r *http.Request) {
 }
 
 // getEcoregionAtPoint finds the ecoregion containing the given coordinates
-func getEcoregionAtPoint(lat, lon float64) (EcoregionInfo, error) {
+func (s *Server) getEcoregionAtPoint(ctx context.Context, lat, lon float64) (EcoregionInfo, error) {
 	var eco EcoregionInfo
 	eco.Latitude = lat
 	eco.Longitude = lon
 
-	err := db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
 		SELECT eco_id, eco_name, biome_name, biome_num, realm
 		FROM ecoregions
 		WHERE ST_Contains(geom, ST_SetSRID(ST_Point($1, $2), 4326))
@@ -174,10 +213,10 @@ func getEcoregionAtPoint(lat, lon float64) (EcoregionInfo, error) {
 }
 
 // getClimateAtCoords gets climate data for coordinates
-func getClimateAtCoords(lat, lon float64) (BiomeClimate, error) {
+func (s *Server) getClimateAtCoords(ctx context.Context, lat, lon float64) (BiomeClimate, error) {
 	var climate BiomeClimate
 
-	err := db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
 		SELECT
 			MAX(CASE WHEN bio_var = 'bio1' THEN value END) as bio1,
 			MAX(CASE WHEN bio_var = 'bio5' THEN value END) as bio5,
@@ -196,11 +235,11 @@ func getClimateAtCoords(lat, lon float64) (BiomeClimate, error) {
 
 // getSpeciesForBiome returns species from ecoregions in the given biome + WCVP/TDWG region, ordered by climate match.
 // tdwgCode enriches results with species from species_regions (WCVP) that may not have GBIF ecoregion observations.
-func getSpeciesForBiome(biomeNum int, climate BiomeClimate, threshold float64, limit int, growthForms []string, tdwgCode string) ([]EcoregionSpecies, int, error) {
+func (s *Server) getSpeciesForBiome(ctx context.Context, biomeNum int, climate BiomeClimate, threshold float64, limit int, growthForms []string, tdwgCode string) ([]EcoregionSpecies, int, error) {
 	// First, get total count of species in this biome (from both sources)
 	var totalInBiome int
 	if tdwgCode != "" {
-		err := db.QueryRow(`
+		err := s.db.QueryRowContext(ctx, `
 			SELECT COUNT(DISTINCT species_id) FROM (
 				SELECT se.species_id FROM species_ecoregions se
 				JOIN ecoregions e ON se.eco_id = e.eco_id WHERE e.biome_num = $1
@@ -212,7 +251,7 @@ func getSpeciesForBiome(biomeNum int, climate BiomeClimate, threshold float64, l
 			return nil, 0, err
 		}
 	} else {
-		err := db.QueryRow(`
+		err := s.db.QueryRowContext(ctx, `
 			SELECT COUNT(DISTINCT se.species_id)
 			FROM species_ecoregions se
 			JOIN ecoregions e ON se.eco_id = e.eco_id
@@ -223,29 +262,81 @@ func getSpeciesForBiome(biomeNum int, climate BiomeClimate, threshold float64, l
 		}
 	}
 
-	// Build growth form filter
+	query, args := biomeSpeciesQuery(biomeNum, climate, threshold, limit, growthForms, tdwgCode)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, totalInBiome, err
+	}
+	defer rows.Close()
+
+	var species []EcoregionSpecies
+	for rows.Next() {
+		var sp EcoregionSpecies
+		err := rows.Scan(
+			&sp.SpeciesID,
+			&sp.CanonicalName,
+			&sp.Family,
+			&sp.GrowthForm,
+			&sp.MaxHeightM,
+			&sp.LifespanYears,
+			&sp.ThreatStatus,
+			&sp.ClimateMatchScore,
+			&sp.NEcoregions,
+			&sp.NObservations,
+		)
+		if err != nil {
+			s.log.Printf("Error scanning species row: %v", err)
+			continue
+		}
+		species = append(species, sp)
+	}
+
+	return species, totalInBiome, nil
+}
+
+// biomeSpeciesQuery builds the species query of getSpeciesForBiome. Every
+// caller-supplied value is a placeholder in args.
+func biomeSpeciesQuery(biomeNum int, climate BiomeClimate, threshold float64, limit int, growthForms []string, tdwgCode string) (string, []interface{}) {
+	args := []interface{}{biomeNum}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Climate score, when the point has climate data
+	scoreExpr, scoreFilter, order := "0.5", "", "cs.total_obs DESC"
+	if climate.Bio1 != nil && climate.Bio5 != nil && climate.Bio6 != nil {
+		scoreExpr = fmt.Sprintf("COALESCE(calculate_climate_match(s.id, %s, %s, %s, %s, %s), 0.5)",
+			arg(*climate.Bio1), arg(*climate.Bio5), arg(*climate.Bio6),
+			arg(coalesceFloat(climate.Bio12, 1000)), arg(coalesceFloat(climate.Bio15, 50)))
+		scoreFilter = fmt.Sprintf("AND %s >= %s", scoreExpr, arg(threshold))
+		order = "climate_score DESC, cs.total_obs DESC"
+	}
+
+	// Build the combined CTE: GBIF ecoregions UNION WCVP/TDWG regions
+	wcvpSource := ""
+	if tdwgCode != "" {
+		wcvpSource = fmt.Sprintf(`
+					UNION ALL
+					-- Source 2: WCVP/TDWG distribution records
+					SELECT sr.species_id, 0 as total_obs, 0 as n_ecoregions
+					FROM species_regions sr
+					WHERE sr.tdwg_code = %s`, arg(tdwgCode))
+	}
+
+	// Build growth form filter (any of the forms)
 	growthFormFilter := ""
 	if len(growthForms) > 0 {
-		growthFormFilter = " AND ("
-		for i, gf := range growthForms {
-			if i > 0 {
-				growthFormFilter += " OR "
-			}
-			growthFormFilter += fmt.Sprintf("su.growth_form = '%s'", gf)
-		}
-		growthFormFilter += ")"
+		growthFormFilter = "AND su.growth_form = ANY(" + arg(pq.Array(growthForms)) + ")"
 	}
 
 	// Build LIMIT clause (0 = no limit, return all)
 	limitClause := ""
 	if limit > 0 {
-		limitClause = fmt.Sprintf("LIMIT %d", limit)
+		limitClause = "LIMIT " + arg(limit)
 	}
 
-	// Build the combined CTE: GBIF ecoregions UNION WCVP/TDWG regions
-	var combinedCTE string
-	if tdwgCode != "" {
-		combinedCTE = fmt.Sprintf(`
+	query := fmt.Sprintf(`
 			WITH combined_species AS (
 				SELECT species_id, SUM(total_obs) as total_obs, MAX(n_ecoregions) as n_ecoregions
 				FROM (
@@ -254,59 +345,10 @@ func getSpeciesForBiome(biomeNum int, climate BiomeClimate, threshold float64, l
 					FROM species_ecoregions se
 					JOIN ecoregions e ON se.eco_id = e.eco_id
 					WHERE e.biome_num = $1
-					GROUP BY se.species_id
-					UNION ALL
-					-- Source 2: WCVP/TDWG distribution records
-					SELECT sr.species_id, 0 as total_obs, 0 as n_ecoregions
-					FROM species_regions sr
-					WHERE sr.tdwg_code = '%s'
+					GROUP BY se.species_id%s
 				) sources
 				GROUP BY species_id
-			)`, tdwgCode)
-	} else {
-		combinedCTE = `
-			WITH combined_species AS (
-				SELECT se.species_id, SUM(se.n_observations) as total_obs, COUNT(DISTINCT se.eco_id) as n_ecoregions
-				FROM species_ecoregions se
-				JOIN ecoregions e ON se.eco_id = e.eco_id
-				WHERE e.biome_num = $1
-				GROUP BY se.species_id
-			)`
-	}
-
-	var query string
-	var rows *sql.Rows
-	var err error
-
-	if climate.Bio1 != nil && climate.Bio5 != nil && climate.Bio6 != nil {
-		query = fmt.Sprintf(`
-			%s
-			SELECT
-				s.id,
-				s.canonical_name,
-				COALESCE(s.family, 'Unknown'),
-				su.growth_form,
-				su.max_height_m,
-				su.lifespan_years,
-				su.threat_status,
-				COALESCE(calculate_climate_match(s.id, $2, $3, $4, $5, $6), 0.5) as climate_score,
-				cs.n_ecoregions,
-				cs.total_obs
-			FROM combined_species cs
-			JOIN species s ON cs.species_id = s.id
-			LEFT JOIN species_unified su ON s.id = su.species_id
-			WHERE COALESCE(calculate_climate_match(s.id, $2, $3, $4, $5, $6), 0.5) >= $7
-			  AND su.growth_form IS NOT NULL
-			%s
-			ORDER BY climate_score DESC, cs.total_obs DESC
-			%s
-		`, combinedCTE, growthFormFilter, limitClause)
-		rows, err = db.Query(query, biomeNum, *climate.Bio1, *climate.Bio5, *climate.Bio6,
-			coalesceFloat(climate.Bio12, 1000), coalesceFloat(climate.Bio15, 50),
-			threshold)
-	} else {
-		query = fmt.Sprintf(`
-			%s
+			)
 			SELECT
 				s.id,
 				s.canonical_name,
@@ -315,48 +357,19 @@ func getSpeciesForBiome(biomeNum int, climate BiomeClimate, threshold float64, l
 				su.max_height_m,
 				su.lifespan_years,
 				su.threat_status,
-				0.5 as climate_score,
+				%s as climate_score,
 				cs.n_ecoregions,
 				cs.total_obs
 			FROM combined_species cs
 			JOIN species s ON cs.species_id = s.id
 			LEFT JOIN species_unified su ON s.id = su.species_id
 			WHERE su.growth_form IS NOT NULL
+			  %s
+			  %s
+			ORDER BY %s
 			%s
-			ORDER BY cs.total_obs DESC
-			%s
-		`, combinedCTE, growthFormFilter, limitClause)
-		rows, err = db.Query(query, biomeNum)
-	}
-
-	if err != nil {
-		return nil, totalInBiome, err
-	}
-	defer rows.Close()
-
-	var species []EcoregionSpecies
-	for rows.Next() {
-		var sp EcoregionSpecies
-		err := rows.Scan(
-			&sp.SpeciesID,
-			&sp.CanonicalName,
-			&sp.Family,
-			&sp.GrowthForm,
-			&sp.MaxHeightM,
-			&sp.LifespanYears,
-			&sp.ThreatStatus,
-			&sp.ClimateMatchScore,
-			&sp.NEcoregions,
-			&sp.NObservations,
-		)
-		if err != nil {
-			log.Printf("Error scanning species row: %v", err)
-			continue
-		}
-		species = append(species, sp)
-	}
-
-	return species, totalInBiome, nil
+		`, wcvpSource, scoreExpr, scoreFilter, growthFormFilter, order, limitClause)
+	return query, args
 }
 
 func coalesceFloat(f *float64, defaultVal float64) float64 {
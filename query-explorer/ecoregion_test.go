@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBiomeSpeciesQueryUsesPlaceholders(t *testing.T) {
+	hostileCode := "BZS'); DROP TABLE species; --"
+	hostileForm := "tree' OR '1'='1"
+	bio1, bio5, bio6 := 22.0, 30.0, 12.0
+
+	tests := []struct {
+		name     string
+		climate  BiomeClimate
+		tdwgCode string
+		forms    []string
+		limit    int
+		wantArgs int
+	}{
+		{"no climate, no region", BiomeClimate{}, "", nil, 0, 1},
+		{"region and forms", BiomeClimate{}, hostileCode, []string{hostileForm}, 50, 4},
+		{"climate", BiomeClimate{Bio1: &bio1, Bio5: &bio5, Bio6: &bio6}, hostileCode, []string{"tree", hostileForm}, 10, 10},
+	}
+	for _, tt := range tests {
+		query, args := biomeSpeciesQuery(1, tt.climate, 0.3, tt.limit, tt.forms, tt.tdwgCode)
+
+		if strings.Contains(query, "DROP") || strings.Contains(query, "'1'='1") {
+			t.Errorf("%s: values interpolated into SQL:\n%s", tt.name, query)
+		}
+		if len(args) != tt.wantArgs || maxPlaceholder(query) != len(args) {
+			t.Errorf("%s: %d args, placeholders up to $%d, want %d", tt.name, len(args), maxPlaceholder(query), tt.wantArgs)
+		}
+		if _, err := validateReadOnlyQuery(query); err != nil {
+			t.Errorf("%s: query does not parse: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// ECOREGION LISTING AND GEOJSON
+// ============================================================================
+//
+// The point lookup in ecoregion.go only answers "which ecoregion is here";
+// these endpoints let the dashboard browse and map them:
+//
+//	GET /api/ecoregions                  paginated list (see pagination.go;
+//	                                     sort=name or eco_id, q over the
+//	                                     name), filtered by biome (number or
+//	                                     name) and realm
+//	GET /api/ecoregions/{eco_id}.geojson one ecoregion, as a Feature
+//
+// List items carry the bbox of migration 028, so the map can zoom to an
+// ecoregion before fetching its geometry. Geometries are simplified with
+// tolerance as in /api/tdwg.geojson (see tdwg_geojson.go). Ecoregion and
+// biome names are localized with lang.
+
+var ecoregionList = listSpec{
+	DefaultLimit: 100,
+	MaxLimit:     1000,
+	Sorts: map[string]string{
+		"name":   "COALESCE(e.eco_name, '')",
+		"eco_id": "e.eco_id",
+	},
+	DefaultSort:   "name",
+	IDColumn:      "e.eco_id",
+	SearchColumns: []string{"e.eco_name"},
+}
+
+type EcoregionSummary struct {
+	EcoID     int         `json:"eco_id"`
+	EcoName   string      `json:"eco_name"`
+	BiomeNum  int         `json:"biome_num"`
+	BiomeName string      `json:"biome_name"`
+	Realm     string      `json:"realm"`
+	BBox      *[4]float64 `json:"bbox"` // [min_lon, min_lat, max_lon, max_lat]; null before migration 028 ran
+}
+
+type EcoregionListResponse struct {
+	Ecoregions []EcoregionSummary `json:"ecoregions"`
+	NextCursor string             `json:"next_cursor"`
+}
+
+type ecoregionFeature struct {
+	Type       string           `json:"type"`
+	ID         int              `json:"id"`
+	Properties EcoregionSummary `json:"properties"`
+	Geometry   json.RawMessage  `json:"geometry"`
+}
+
+// ecoregionFilter returns the biome and realm conditions (each prefixed
+// with AND) on ecoregions e, numbering placeholders after args
+func ecoregionFilter(biome, realm string, args []interface{}) (string, []interface{}) {
+	var b strings.Builder
+	if biome != "" {
+		args = append(args, biome)
+		if n, err := strconv.Atoi(biome); err == nil {
+			args[len(args)-1] = n
+			fmt.Fprintf(&b, " AND e.biome_num = $%d", len(args))
+		} else {
+			fmt.Fprintf(&b, " AND LOWER(e.biome_name) = LOWER($%d)", len(args))
+		}
+	}
+	if realm != "" {
+		args = append(args, realm)
+		fmt.Fprintf(&b, " AND LOWER(e.realm) = LOWER($%d)", len(args))
+	}
+	return b.String(), args
+}
+
+// localizeEcoregion localizes the ecoregion and biome names of e
+func (s *Server) localizeEcoregion(ctx context.Context, e *EcoregionSummary, lang string) {
+	e.EcoName = s.localize(ctx, nameKindEcoregion, strconv.Itoa(e.EcoID), lang, e.EcoName)
+	e.BiomeName = s.localize(ctx, nameKindBiome, strconv.Itoa(e.BiomeNum), lang, e.BiomeName)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleEcoregions handles GET /api/ecoregions
+func (s *Server) handleEcoregions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	p, err := parseListParams(r, ecoregionList)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	filter, args := ecoregionFilter(r.URL.Query().Get("biome"), r.URL.Query().Get("realm"), nil)
+	where, tail, args := p.SQL(args)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.eco_id, COALESCE(e.eco_name, ''), COALESCE(e.biome_num, 0), COALESCE(e.biome_name, ''),
+		       COALESCE(e.realm, ''), e.bbox IS NOT NULL,
+		       COALESCE(ST_XMin(e.bbox), 0), COALESCE(ST_YMin(e.bbox), 0),
+		       COALESCE(ST_XMax(e.bbox), 0), COALESCE(ST_YMax(e.bbox), 0),
+		       `+p.CursorColumn()+`
+		FROM ecoregions e
+		WHERE e.eco_id IS NOT NULL`+filter+where+`
+		`+tail, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lang := requestLanguage(r)
+	resp := EcoregionListResponse{Ecoregions: []EcoregionSummary{}}
+	var keys []listKey
+	for rows.Next() {
+		var e EcoregionSummary
+		var hasBBox bool
+		var bbox [4]float64
+		var cursorValue string
+		if err := rows.Scan(&e.EcoID, &e.EcoName, &e.BiomeNum, &e.BiomeName, &e.Realm, &hasBBox,
+			&bbox[0], &bbox[1], &bbox[2], &bbox[3], &cursorValue); err != nil {
+			s.log.Printf("Error scanning ecoregion row: %v", err)
+			continue
+		}
+		if hasBBox {
+			e.BBox = &bbox
+		}
+		s.localizeEcoregion(ctx, &e, lang)
+		resp.Ecoregions = append(resp.Ecoregions, e)
+		keys = append(keys, listKey{cursorValue, int64(e.EcoID)})
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	n, next := p.trim(keys)
+	resp.Ecoregions, resp.NextCursor = resp.Ecoregions[:n], next
+
+	setContentLanguage(w, lang)
+	writeCompressedJSON(w, r, resp)
+}
+
+// handleEcoregionGeoJSON handles GET /api/ecoregions/{eco_id}.geojson
+func (s *Server) handleEcoregionGeoJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	ecoID, err := strconv.Atoi(pathParam(r, "eco_id"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid eco_id"}`, http.StatusBadRequest)
+		return
+	}
+	tolerance, err := parseTolerance(r.URL.Query().Get("tolerance"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	join, expr := layerGeometry("ecoregion", "e", tolerance)
+	f := ecoregionFeature{Type: "Feature"}
+	var geometry string
+	var hasBBox bool
+	var bbox [4]float64
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT e.eco_id, COALESCE(e.eco_name, ''), COALESCE(e.biome_num, 0), COALESCE(e.biome_name, ''),
+		       COALESCE(e.realm, ''), e.bbox IS NOT NULL,
+		       COALESCE(ST_XMin(e.bbox), 0), COALESCE(ST_YMin(e.bbox), 0),
+		       COALESCE(ST_XMax(e.bbox), 0), COALESCE(ST_YMax(e.bbox), 0),
+		       ST_AsGeoJSON(%s, 5)
+		FROM ecoregions e
+		%s
+		WHERE e.eco_id = $1 AND e.geom IS NOT NULL
+	`, expr, join), ecoID).Scan(&f.Properties.EcoID, &f.Properties.EcoName, &f.Properties.BiomeNum,
+		&f.Properties.BiomeName, &f.Properties.Realm, &hasBBox, &bbox[0], &bbox[1], &bbox[2], &bbox[3], &geometry)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Ecoregion not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if hasBBox {
+		f.Properties.BBox = &bbox
+	}
+	f.ID = f.Properties.EcoID
+	f.Geometry = json.RawMessage(geometry)
+
+	lang := requestLanguage(r)
+	s.localizeEcoregion(ctx, &f.Properties, lang)
+	setContentLanguage(w, lang)
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	writeCompressedJSON(w, r, f)
+}
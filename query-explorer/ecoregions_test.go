@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEcoregionFilter(t *testing.T) {
+	where, args := ecoregionFilter("1", "neotropic", []interface{}{"x"})
+	if where != " AND e.biome_num = $2 AND LOWER(e.realm) = LOWER($3)" || len(args) != 3 || args[1] != 1 {
+		t.Errorf("biome number: %s with %v", where, args)
+	}
+	hostile := "Deserts'); DROP TABLE ecoregions; --"
+	where, args = ecoregionFilter(hostile, "", nil)
+	if where != " AND LOWER(e.biome_name) = LOWER($1)" || args[0] != hostile {
+		t.Errorf("biome name: %s with %v", where, args)
+	}
+	if where, args := ecoregionFilter("", "", nil); where != "" || len(args) != 0 {
+		t.Errorf("no filter: %s with %v", where, args)
+	}
+}
+
+func TestEcoregionGeometry(t *testing.T) {
+	if join, expr := layerGeometry("ecoregion", "e", 0.05); !strings.Contains(join, "sg.layer = 'ecoregion'") || !strings.Contains(expr, "e.geom") {
+		t.Errorf("cached band: got %s / %s", join, expr)
+	}
+	if join, expr := layerGeometry("ecoregion", "e", 0.02); join != "" || expr != "ST_SimplifyPreserveTopology(e.geom, 0.02)" {
+		t.Errorf("uncached: got %q / %s", join, expr)
+	}
+}
+
+func TestEcoregionsValidation(t *testing.T) {
+	s := newTestServer()
+	routed := s.router().ServeHTTP
+	for _, tc := range []struct {
+		method, path string
+		handler      http.HandlerFunc
+		want         int
+	}{
+		{http.MethodPost, "/api/ecoregions", s.handleEcoregions, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/ecoregions?sort=area", s.handleEcoregions, http.StatusBadRequest},
+		{http.MethodGet, "/api/ecoregions?since=2024-01-01", s.handleEcoregions, http.StatusBadRequest},
+		{http.MethodGet, "/api/ecoregions/123", routed, http.StatusNotFound},
+		{http.MethodGet, "/api/ecoregions/.geojson", routed, http.StatusNotFound},
+		{http.MethodGet, "/api/ecoregions/a/b.geojson", routed, http.StatusNotFound},
+		{http.MethodGet, "/api/ecoregions/abc.geojson", routed, http.StatusBadRequest},
+		{http.MethodGet, "/api/ecoregions/123.geojson?tolerance=5", routed, http.StatusBadRequest},
+		{http.MethodPost, "/api/ecoregions/123.geojson", routed, http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
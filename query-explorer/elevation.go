@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ============================================================================
+// ELEVATION MATCHING
+// ============================================================================
+//
+// Species ranges come from species_elevation_unified (migration 019): the
+// published flora range when known, otherwise the GBIF 5th-95th percentile.
+// Species without a known range are never excluded or penalized.
+//
+//	filter  drop species whose range ± tolerance excludes the site elevation
+//	score   keep them, but lower their greedy score in proportion to the
+//	        distance outside the range
+//
+// The site elevation is elevation_m, or else the DEM at latitude/longitude
+// (see terrain.go).
+
+const (
+	defaultElevationToleranceM = 100.0
+	elevationPenaltyWeight     = 0.3   // Maximum greedy score penalty
+	elevationPenaltyScaleM     = 500.0 // Distance outside the range at which the penalty is maximal
+)
+
+// validateElevationPreferences checks elevation_mode and fills in defaults
+func validateElevationPreferences(req *RecommendRequest) error {
+	prefs := &req.Preferences
+	switch prefs.ElevationMode {
+	case "":
+		prefs.ElevationToleranceM = nil
+		return nil
+	case "filter", "score":
+	default:
+		return fmt.Errorf("invalid elevation_mode: %s (use filter or score)", prefs.ElevationMode)
+	}
+
+	if req.ElevationM == nil && (req.Latitude == nil || req.Longitude == nil) {
+		return fmt.Errorf("elevation_mode requires elevation_m or latitude/longitude")
+	}
+	if req.ElevationM != nil && (*req.ElevationM < -500 || *req.ElevationM > 9000) {
+		return fmt.Errorf("elevation_m out of range")
+	}
+	if prefs.ElevationToleranceM == nil {
+		tol := defaultElevationToleranceM
+		prefs.ElevationToleranceM = &tol
+	} else if *prefs.ElevationToleranceM < 0 {
+		return fmt.Errorf("elevation_tolerance_m must not be negative")
+	}
+	return nil
+}
+
+// elevationDistance returns how far elevation lies outside [low, high]
+// widened by tol, and false when the species has no known range
+func elevationDistance(sp SpeciesRecommendation, elevation, tol float64) (float64, bool) {
+	if sp.ElevationLowM == nil && sp.ElevationHighM == nil {
+		return 0, false
+	}
+	switch {
+	case sp.ElevationLowM != nil && elevation < *sp.ElevationLowM-tol:
+		return *sp.ElevationLowM - tol - elevation, true
+	case sp.ElevationHighM != nil && elevation > *sp.ElevationHighM+tol:
+		return elevation - (*sp.ElevationHighM + tol), true
+	}
+	return 0, true
+}
+
+// elevationAdjustments returns greedy score penalties for elevation_mode
+// "score", merged into adjustments (which may be nil)
+func elevationAdjustments(req RecommendRequest, candidates []SpeciesRecommendation, adjustments map[int64]float64) map[int64]float64 {
+	if req.Preferences.ElevationMode != "score" || req.ElevationM == nil {
+		return adjustments
+	}
+	if adjustments == nil {
+		adjustments = make(map[int64]float64)
+	}
+
+	tol := *req.Preferences.ElevationToleranceM
+	for _, c := range candidates {
+		if d, ok := elevationDistance(c, *req.ElevationM, tol); ok && d > 0 {
+			adjustments[c.SpeciesID] -= elevationPenaltyWeight * math.Min(1, d/elevationPenaltyScaleM)
+		}
+	}
+	return adjustments
+}
+
+// elevationFilterSQL returns the candidate clause for elevation_mode
+// "filter" (empty otherwise); elevation and tolerance are bound as
+// $n and $n+1
+func elevationFilterSQL(prefs Preferences, n int) string {
+	if prefs.ElevationMode != "filter" {
+		return ""
+	}
+	return fmt.Sprintf(`AND (ev.species_id IS NULL
+		       OR ($%[1]d >= COALESCE(ev.low_m, $%[1]d) - $%[2]d
+		           AND $%[1]d <= COALESCE(ev.high_m, $%[1]d) + $%[2]d))`, n, n+1)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// EXPLAIN PLANS
+// ============================================================================
+//
+// POST /api/query/explain plans a SELECT with EXPLAIN (FORMAT JSON) without
+// running it and returns the plan as a tree the admin UI can draw. Postgres
+// names keys like "Node Type"; the common ones become fields and everything
+// else is kept under details.
+
+type PlanNode struct {
+	NodeType     string                 `json:"node_type"`
+	Relationship string                 `json:"parent_relationship,omitempty"`
+	Relation     string                 `json:"relation,omitempty"`
+	Alias        string                 `json:"alias,omitempty"`
+	Index        string                 `json:"index,omitempty"`
+	JoinType     string                 `json:"join_type,omitempty"`
+	StartupCost  float64                `json:"startup_cost"`
+	TotalCost    float64                `json:"total_cost"`
+	PlanRows     float64                `json:"plan_rows"`
+	PlanWidth    int                    `json:"plan_width"`
+	Filter       string                 `json:"filter,omitempty"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+	Plans        []PlanNode             `json:"plans,omitempty"`
+}
+
+type ExplainResponse struct {
+	Plan      PlanNode `json:"plan"`
+	TotalCost float64  `json:"total_cost"`
+	PlanRows  float64  `json:"plan_rows"`
+	NodeCount int      `json:"node_count"`
+	SeqScans  []string `json:"seq_scans"` // Relations read in full
+	QueryTime string   `json:"query_time"`
+}
+
+// UnmarshalJSON reads a node of Postgres' EXPLAIN (FORMAT JSON) output
+func (n *PlanNode) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"Node Type":           &n.NodeType,
+		"Parent Relationship": &n.Relationship,
+		"Relation Name":       &n.Relation,
+		"Alias":               &n.Alias,
+		"Index Name":          &n.Index,
+		"Join Type":           &n.JoinType,
+		"Startup Cost":        &n.StartupCost,
+		"Total Cost":          &n.TotalCost,
+		"Plan Rows":           &n.PlanRows,
+		"Plan Width":          &n.PlanWidth,
+		"Filter":              &n.Filter,
+		"Plans":               &n.Plans,
+	}
+	for key, value := range raw {
+		if dst, ok := fields[key]; ok {
+			if err := json.Unmarshal(value, dst); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err != nil {
+			return err
+		}
+		if n.Details == nil {
+			n.Details = map[string]interface{}{}
+		}
+		n.Details[key] = v
+	}
+	return nil
+}
+
+// parseExplainJSON parses the single-row output of EXPLAIN (FORMAT JSON)
+func parseExplainJSON(data []byte) (*ExplainResponse, error) {
+	var out []struct {
+		Plan PlanNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	if len(out) != 1 {
+		return nil, fmt.Errorf("expected one plan, got %d", len(out))
+	}
+
+	resp := &ExplainResponse{
+		Plan:      out[0].Plan,
+		TotalCost: out[0].Plan.TotalCost,
+		PlanRows:  out[0].Plan.PlanRows,
+		SeqScans:  []string{},
+	}
+	var walk func(n *PlanNode)
+	walk = func(n *PlanNode) {
+		resp.NodeCount++
+		if n.NodeType == "Seq Scan" && n.Relation != "" {
+			resp.SeqScans = append(resp.SeqScans, n.Relation)
+		}
+		for i := range n.Plans {
+			walk(&n.Plans[i])
+		}
+	}
+	walk(&resp.Plan)
+	return resp, nil
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleQueryExplain handles POST /api/query/explain
+func (s *Server) handleQueryExplain(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SQL string `json:"sql"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	validated, err := validateReadOnlyQuery(req.SQL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusForbidden)
+		return
+	}
+	if validated.Explain {
+		http.Error(w, `{"error": "Send the SELECT without EXPLAIN"}`, http.StatusBadRequest)
+		return
+	}
+	query := strings.TrimSuffix(strings.TrimSpace(req.SQL), ";")
+
+	start := time.Now()
+	tx, err := s.beginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var plan []byte
+	err = tx.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON, ANALYZE false) "+query).Scan(&plan)
+	if isTimeout(ctx, err) {
+		http.Error(w, `{"error": "explain cancelled: statement timeout or client disconnected"}`, http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		// Planning errors (unknown column, ...) are the caller's
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := parseExplainJSON(plan)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Unexpected EXPLAIN output: "+err.Error()), http.StatusInternalServerError)
+		return
+	}
+	resp.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(resp)
+}
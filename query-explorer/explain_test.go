@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseExplainJSON(t *testing.T) {
+	plan := []byte(`[{"Plan": {
+		"Node Type": "Hash Join", "Parallel Aware": false, "Join Type": "Inner",
+		"Startup Cost": 12.5, "Total Cost": 1840.75, "Plan Rows": 3200, "Plan Width": 48,
+		"Hash Cond": "(sr.species_id = s.id)",
+		"Plans": [
+			{"Node Type": "Seq Scan", "Parent Relationship": "Outer", "Relation Name": "species_regions",
+			 "Alias": "sr", "Startup Cost": 0, "Total Cost": 1200, "Plan Rows": 3200, "Plan Width": 16,
+			 "Filter": "((tdwg_code)::text = 'BZS'::text)"},
+			{"Node Type": "Hash", "Parent Relationship": "Inner", "Startup Cost": 10, "Total Cost": 10,
+			 "Plan Rows": 400, "Plan Width": 40, "Plans": [
+				{"Node Type": "Index Scan", "Parent Relationship": "Outer", "Relation Name": "species",
+				 "Alias": "s", "Index Name": "species_pkey", "Startup Cost": 0.29, "Total Cost": 8.3,
+				 "Plan Rows": 400, "Plan Width": 40}
+			]}
+		]
+	}}]`)
+
+	resp, err := parseExplainJSON(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.NodeCount != 4 || resp.TotalCost != 1840.75 || resp.PlanRows != 3200 {
+		t.Errorf("got %d nodes, cost %v, rows %v", resp.NodeCount, resp.TotalCost, resp.PlanRows)
+	}
+	if len(resp.SeqScans) != 1 || resp.SeqScans[0] != "species_regions" {
+		t.Errorf("seq scans: got %v", resp.SeqScans)
+	}
+
+	root := resp.Plan
+	if root.NodeType != "Hash Join" || root.JoinType != "Inner" || root.Details["Hash Cond"] != "(sr.species_id = s.id)" {
+		t.Errorf("root: got %+v", root)
+	}
+	if _, ok := root.Details["Node Type"]; ok {
+		t.Error("known keys must not be repeated in details")
+	}
+	scan := root.Plans[1].Plans[0]
+	if scan.Index != "species_pkey" || scan.Relationship != "Outer" || scan.Details != nil {
+		t.Errorf("index scan: got %+v", scan)
+	}
+	if root.Plans[0].Filter == "" {
+		t.Error("filter not kept")
+	}
+
+	if _, err := parseExplainJSON([]byte(`[]`)); err == nil {
+		t.Error("empty output: expected an error")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver for handler tests that go past the
+// database: each statement is answered by the first fakeQuery whose match
+// it contains and whose when (if any) accepts its args (Exec reports its
+// rows as affected), and statements and transaction boundaries are logged
+// in order. Statements nothing matches fail.
+type fakeDB struct {
+	mu      sync.Mutex
+	queries []fakeQuery
+	log     []string
+	args    [][]driver.Value // Of each logged event, nil for transaction boundaries
+}
+
+type fakeQuery struct {
+	match   string
+	when    func(args []driver.Value) bool
+	columns []string
+	rows    [][]driver.Value
+	err     error
+	rowsErr error // Ends the rows instead of io.EOF
+}
+
+// fakeAdminKey answers the API key lookup of authenticate with an admin key
+var fakeAdminKey = fakeQuery{
+	match:   "UPDATE api_keys SET last_used_at",
+	columns: []string{"id", "tenant_id", "owner", "role"},
+	rows:    [][]driver.Value{{int64(1), nil, "admin", roleAdmin}},
+}
+
+// fakeCandidateRow is a row of the candidate query (see
+// getClimateAdaptedSpecies) with the given traits and nothing else known
+func fakeCandidateRow(id int64, name, family, growthForm string, climateMatch float64) []driver.Value {
+	return []driver.Value{id, name, family, growthForm,
+		nil, nil, false, nil, true, false, nil,
+		nil, nil, nil, nil,
+		climateMatch, nil, nil, nil, nil, nil}
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// newFakeDBServer returns a test server on a fakeDB answering queries
+func newFakeDBServer(t *testing.T, queries ...fakeQuery) (*Server, *fakeDB) {
+	f := &fakeDB{queries: queries}
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = f
+	fakeDBsMu.Unlock()
+	db, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBsMu.Lock()
+		delete(fakeDBs, t.Name())
+		fakeDBsMu.Unlock()
+	})
+	return NewServer(db, getConfig()), f
+}
+
+// record logs an event (a statement, "BEGIN", "COMMIT", ...) of the test
+func (f *fakeDB) record(event string, args []driver.Value) {
+	f.mu.Lock()
+	f.log = append(f.log, event)
+	f.args = append(f.args, args)
+	f.mu.Unlock()
+}
+
+// executed returns the logged events containing match, in order
+func (f *fakeDB) executed(match string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []string
+	for _, e := range f.log {
+		if strings.Contains(e, match) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// argsOf returns the args of the first logged statement containing match
+func (f *fakeDB) argsOf(match string) []driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.log {
+		if strings.Contains(e, match) {
+			return f.args[i]
+		}
+	}
+	return nil
+}
+
+// index is the position of the first logged event containing match, -1 if
+// none
+func (f *fakeDB) index(match string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.log {
+		if strings.Contains(e, match) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fakeDB) answer(query string, args []driver.Value) (fakeQuery, error) {
+	f.record(query, args)
+	for _, q := range f.queries {
+		if strings.Contains(query, q.match) && (q.when == nil || q.when(args)) {
+			return q, q.err
+		}
+	}
+	return fakeQuery{}, fmt.Errorf("fakedb: unexpected statement %q", strings.Join(strings.Fields(query), " "))
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	f, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("fakedb: no database %q", name)
+	}
+	return &fakeConn{db: f}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.record("BEGIN", nil)
+	return fakeTx{c.db}, nil
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Begin()
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.db.record("COMMIT", nil)
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.record("ROLLBACK", nil)
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	q, err := s.db.answer(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(q.rows)), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	q, err := s.db.answer(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: q.columns, rows: q.rows, err: q.rowsErr}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 && r.err != nil {
+		return r.err
+	}
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
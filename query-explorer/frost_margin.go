@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ============================================================================
+// FROST SAFETY MARGIN
+// ============================================================================
+//
+// The climate match compares averages: a species whose coldest known month
+// is just below the site's bio6 scores well, yet a colder than usual winter
+// can kill it. Preferences.FrostSafetyMarginC asks for headroom instead: the
+// species' cold_month_min (species_climate_envelope_unified) must be at
+// least the margin below the site's bio6. Species without a known
+// cold_month_min are dropped, since their cold tolerance cannot be checked.
+
+const maxFrostSafetyMarginC = 20.0
+
+// validateFrostSafetyMargin checks the frost_safety_margin_c preference
+func validateFrostSafetyMargin(prefs *Preferences) error {
+	m := prefs.FrostSafetyMarginC
+	if m == nil {
+		return nil
+	}
+	if math.IsNaN(*m) || *m < 0 || *m > maxFrostSafetyMarginC {
+		return fmt.Errorf("frost_safety_margin_c must be between 0 and %g", maxFrostSafetyMarginC)
+	}
+	return nil
+}
+
+// frostMarginFilterSQL returns the candidate clause for
+// frost_safety_margin_c (empty when unset), with the margin bound as $n and
+// the site's bio6 at $3
+func frostMarginFilterSQL(prefs Preferences, n int) string {
+	if prefs.FrostSafetyMarginC == nil {
+		return ""
+	}
+	return fmt.Sprintf("AND sce.cold_month_min <= $3 - $%d", n)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecommendFrostSafetyMarginValidation(t *testing.T) {
+	s := newTestServer()
+	for _, margin := range []string{"-1", "20.5"} {
+		req := httptest.NewRequest("POST", "/api/recommend",
+			strings.NewReader(`{"tdwg_code": "BZS", "preferences": {"frost_safety_margin_c": `+margin+`}}`))
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "frost_safety_margin_c must be between 0 and 20") {
+			t.Errorf("margin %s: %d %s, want 400", margin, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRecommendFrostSafetyMarginFiltersCandidates(t *testing.T) {
+	for _, tc := range []struct {
+		name, prefs string
+		clause      bool
+	}{
+		{"unset", `{}`, false},
+		{"zero", `{"frost_safety_margin_c": 0}`, true},
+		{"margin", `{"frost_safety_margin_c": 3.5}`, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The candidate query fails, so the handler stops there
+			s, db := newFakeDBServer(t,
+				fakeQuery{
+					match:   "c.bio1_mean, c.bio5_mean",
+					columns: []string{"tdwg_code", "level3_name", "bio1", "bio5", "bio6", "bio12", "bio15"},
+					rows:    [][]driver.Value{{"BZS", "Brazil South", 18.0, 28.0, 8.0, 1500.0, 30.0}},
+				},
+				fakeQuery{match: "as climate_match_score", err: errors.New("no candidates")},
+			)
+			req := httptest.NewRequest("POST", "/api/recommend",
+				strings.NewReader(`{"tdwg_code": "BZS", "preferences": `+tc.prefs+`}`))
+			w := httptest.NewRecorder()
+			s.router().ServeHTTP(w, req)
+			if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "no candidates") {
+				t.Fatalf("%d %s, want the candidate query to run", w.Code, w.Body.String())
+			}
+			candidates := db.executed("as climate_match_score")
+			if len(candidates) != 1 {
+				t.Fatalf("%d candidate queries, log %q", len(candidates), db.log)
+			}
+			if got := strings.Contains(candidates[0], "sce.cold_month_min <= $3 - $"); got != tc.clause {
+				t.Errorf("frost clause in candidate query: %v, want %v", got, tc.clause)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ============================================================================
+// SIMPLIFIED GEOMETRY CACHE
+// ============================================================================
+//
+// Region polygons are simplified once per zoom band into
+// simplified_geometries (migration 027) by a background job, instead of
+// running ST_Simplify on every map request. The job is time-boxed: it works
+// in small batches until GEOMETRY_CACHE_TIMEOUT and picks up where it left
+// off on the next run, since rows already built for an unchanged source are
+// skipped. Map endpoints use simplifiedGeometry, which falls back to
+// simplifying on the fly for rows the job has not reached yet.
+
+// geometryLayer is a region table whose polygons are cached
+type geometryLayer struct {
+	Table string // Source table
+	Code  string // Code expression; %s is the table alias
+}
+
+var geometryLayers = map[string]geometryLayer{
+	"tdwg_level3": {Table: "tdwg_level3", Code: "%s.level3_code"},
+	"ecoregion":   {Table: "ecoregions", Code: "%s.eco_id::text"},
+}
+
+func (l geometryLayer) code(alias string) string {
+	return fmt.Sprintf(l.Code, alias)
+}
+
+// geometryLevel is a zoom band and its simplification tolerance (degrees)
+type geometryLevel struct {
+	Level     int
+	MaxZoom   int // Last zoom of the band; -1 for the open-ended last band
+	Tolerance float64
+}
+
+var geometryLevels = []geometryLevel{
+	{Level: 0, MaxZoom: 4, Tolerance: 0.05},
+	{Level: 1, MaxZoom: 8, Tolerance: 0.005},
+	{Level: 2, MaxZoom: -1, Tolerance: 0.0005},
+}
+
+const geometryCacheBatch = 50
+
+// geometryLevelForZoom returns the band a web-map zoom level falls into
+func geometryLevelForZoom(zoom int) geometryLevel {
+	for _, l := range geometryLevels {
+		if l.MaxZoom >= 0 && zoom <= l.MaxZoom {
+			return l
+		}
+	}
+	return geometryLevels[len(geometryLevels)-1]
+}
+
+// simplifiedGeometry returns a LEFT JOIN on the cache and the geometry
+// expression to select for a layer table aliased alias at zoom. Layer and
+// alias are constants of the caller, never user input.
+func simplifiedGeometry(layer, alias string, zoom int) (join, expr string) {
+	l := geometryLevelForZoom(zoom)
+	join = fmt.Sprintf(`LEFT JOIN simplified_geometries sg ON sg.layer = '%s' AND sg.code = %s AND sg.level = %d`,
+		layer, geometryLayers[layer].code(alias), l.Level)
+	expr = fmt.Sprintf(`COALESCE(sg.geom, ST_SimplifyPreserveTopology(%s.geom, %g))`, alias, l.Tolerance)
+	return join, expr
+}
+
+type GeometryCacheSummary struct {
+	Built      int64  `json:"built"`
+	Removed    int64  `json:"removed"`
+	Complete   bool   `json:"complete"` // False if the time box ran out
+	Duration   string `json:"duration"`
+	FinishedAt string `json:"finished_at"`
+}
+
+// refreshGeometryCache builds missing or stale cache rows until done or
+// ctx expires; what was built before the deadline is kept
+func (s *Server) refreshGeometryCache(ctx context.Context) (*GeometryCacheSummary, error) {
+	if !s.geometryCacheMu.TryLock() {
+		return nil, fmt.Errorf("a geometry cache refresh is already running")
+	}
+	defer s.geometryCacheMu.Unlock()
+
+	start := time.Now()
+	summary := &GeometryCacheSummary{}
+	finish := func() *GeometryCacheSummary {
+		summary.Duration = time.Since(start).String()
+		summary.FinishedAt = time.Now().Format(time.RFC3339)
+		return summary
+	}
+
+	for _, name := range []string{"tdwg_level3", "ecoregion"} {
+		layer := geometryLayers[name]
+
+		// Regions that no longer exist
+		res, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+			DELETE FROM simplified_geometries sg
+			WHERE sg.layer = $1
+			  AND NOT EXISTS (SELECT 1 FROM %s src WHERE %s = sg.code AND src.geom IS NOT NULL)
+		`, layer.Table, layer.code("src")), name)
+		if ctx.Err() != nil {
+			return finish(), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		n, _ := res.RowsAffected()
+		summary.Removed += n
+
+		for _, level := range geometryLevels {
+			for {
+				// Table and code expression come from geometryLayers
+				res, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+					INSERT INTO simplified_geometries (layer, code, level, tolerance, geom, n_points, source_md5)
+					SELECT $1, code, $2, $3,
+					       -- Regions too small for the tolerance keep their full geometry
+					       CASE WHEN ST_IsEmpty(simplified) THEN ST_Multi(geom) ELSE simplified END,
+					       ST_NPoints(CASE WHEN ST_IsEmpty(simplified) THEN geom ELSE simplified END),
+					       source_md5
+					FROM (
+						SELECT %[2]s AS code, src.geom,
+						       ST_Multi(ST_CollectionExtract(ST_MakeValid(ST_SimplifyPreserveTopology(src.geom, $3)), 3)) AS simplified,
+						       md5(ST_AsBinary(src.geom)) AS source_md5
+						FROM %[1]s src
+						LEFT JOIN simplified_geometries sg ON sg.layer = $1 AND sg.code = %[2]s AND sg.level = $2
+						WHERE src.geom IS NOT NULL
+						  AND (sg.code IS NULL OR sg.tolerance <> $3 OR sg.source_md5 <> md5(ST_AsBinary(src.geom)))
+						ORDER BY 1
+						LIMIT %[3]d
+					) s
+					ON CONFLICT (layer, code, level) DO UPDATE
+					SET tolerance = EXCLUDED.tolerance, geom = EXCLUDED.geom, n_points = EXCLUDED.n_points,
+					    source_md5 = EXCLUDED.source_md5, updated_at = NOW()
+				`, layer.Table, layer.code("src"), geometryCacheBatch), name, level.Level, level.Tolerance)
+				if ctx.Err() != nil {
+					return finish(), nil
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s level %d: %w", name, level.Level, err)
+				}
+				n, _ := res.RowsAffected()
+				summary.Built += n
+				// A short batch means the layer is done at this level
+				if n < geometryCacheBatch {
+					break
+				}
+			}
+		}
+	}
+
+	summary.Complete = true
+	return finish(), nil
+}
+
+// startGeometryCacheJob refreshes the cache at startup and then
+// periodically, each run limited to timeout
+func (s *Server) startGeometryCacheJob(interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	run := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		summary, err := s.refreshGeometryCache(ctx)
+		if err != nil {
+			s.log.Printf("Geometry cache refresh failed: %v", err)
+			return
+		}
+		s.log.Printf("Geometry cache refresh: %d built, %d removed, complete=%v (%s)",
+			summary.Built, summary.Removed, summary.Complete, summary.Duration)
+	}
+
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+	s.log.Printf("Geometry cache job scheduled every %s (time box %s)", interval, timeout)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+type GeometryCacheLevel struct {
+	Layer     string  `json:"layer"`
+	Level     int     `json:"level"`
+	Tolerance float64 `json:"tolerance"`
+	Cached    int64   `json:"cached"`
+	Regions   int64   `json:"regions"`
+	Points    int64   `json:"points"`
+	UpdatedAt *string `json:"updated_at"`
+}
+
+// handleGeometryCache handles /api/admin/geometry-cache: GET reports
+// coverage per layer and level, POST runs a refresh now
+func (s *Server) handleGeometryCache(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		levels := []GeometryCacheLevel{}
+		for _, name := range []string{"tdwg_level3", "ecoregion"} {
+			layer := geometryLayers[name]
+			var regions int64
+			s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+layer.Table+` WHERE geom IS NOT NULL`).Scan(&regions)
+
+			for _, level := range geometryLevels {
+				l := GeometryCacheLevel{Layer: name, Level: level.Level, Tolerance: level.Tolerance, Regions: regions}
+				err := s.db.QueryRowContext(ctx, `
+					SELECT COUNT(*), COALESCE(SUM(n_points), 0),
+					       TO_CHAR(MAX(updated_at), 'YYYY-MM-DD"T"HH24:MI:SS')
+					FROM simplified_geometries
+					WHERE layer = $1 AND level = $2 AND tolerance = $3
+				`, name, level.Level, level.Tolerance).Scan(&l.Cached, &l.Points, &l.UpdatedAt)
+				if err != nil {
+					http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+					return
+				}
+				levels = append(levels, l)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"levels": levels})
+
+	case http.MethodPost:
+		summary, err := s.refreshGeometryCache(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(summary)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
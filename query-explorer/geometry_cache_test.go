@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeometryLevelForZoom(t *testing.T) {
+	for zoom, want := range map[int]int{0: 0, 4: 0, 5: 1, 8: 1, 9: 2, 18: 2} {
+		if got := geometryLevelForZoom(zoom).Level; got != want {
+			t.Errorf("zoom %d: got level %d, want %d", zoom, got, want)
+		}
+	}
+}
+
+func TestSimplifiedGeometry(t *testing.T) {
+	join, expr := simplifiedGeometry("ecoregion", "e", 6)
+	if !strings.Contains(join, "sg.layer = 'ecoregion' AND sg.code = e.eco_id::text AND sg.level = 1") {
+		t.Errorf("join: got %s", join)
+	}
+	if expr != "COALESCE(sg.geom, ST_SimplifyPreserveTopology(e.geom, 0.005))" {
+		t.Errorf("expr: got %s", expr)
+	}
+}
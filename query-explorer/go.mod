@@ -3,11 +3,16 @@ module query-explorer
 go 1.21
 
 require (
+	github.com/jackc/pgx/v5 v5.6.0
 	github.com/lib/pq v1.10.9
+	github.com/pganalyze/pg_query_go/v5 v5.1.0
 	golang.org/x/crypto v0.18.0
+	golang.org/x/text v0.14.0
 )
 
 require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
 	golang.org/x/net v0.20.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
 )
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// SITE HYDROLOGY
+// ============================================================================
+//
+// Preferences.SiteHydrology matches species_unified.wetland_indicator
+// (migration 020) to the planting site. Clear mismatches are excluded, the
+// rest get a greedy score adjustment; species with no indicator are neutral
+// unless hydrology_strict is set.
+
+var validWetlandIndicators = map[string]bool{
+	"obligate_wetland":    true,
+	"facultative_wetland": true,
+	"facultative":         true,
+	"facultative_upland":  true,
+	"upland":              true,
+}
+
+// hydrologyExcluded marks indicators that cannot establish at the site
+const hydrologyExcluded = -1.0
+
+// hydrologyAffinity is the greedy score adjustment per site hydrology and
+// wetland indicator; hydrologyExcluded drops the species
+var hydrologyAffinity = map[string]map[string]float64{
+	"upland": {
+		"obligate_wetland":    hydrologyExcluded,
+		"facultative_wetland": -0.15,
+	},
+	"riparian": {
+		"obligate_wetland":    0.05,
+		"facultative_wetland": 0.15,
+		"facultative":         0.10,
+		"upland":              -0.15,
+	},
+	"wetland": {
+		"obligate_wetland":    0.15,
+		"facultative_wetland": 0.10,
+		"facultative_upland":  hydrologyExcluded,
+		"upland":              hydrologyExcluded,
+	},
+}
+
+// validateSiteHydrology checks the site_hydrology preference
+func validateSiteHydrology(prefs *Preferences) error {
+	if prefs.SiteHydrology == "" {
+		prefs.HydrologyStrict = false
+		return nil
+	}
+	if _, ok := hydrologyAffinity[prefs.SiteHydrology]; !ok {
+		return fmt.Errorf("invalid site_hydrology: %s (use upland, riparian or wetland)", prefs.SiteHydrology)
+	}
+	return nil
+}
+
+// hydrologyIndicators returns the indicators excluded at the site and, for
+// hydrology_strict, the ones positively suited to it
+func hydrologyIndicators(site string) (excluded, suited []string) {
+	for indicator, adj := range hydrologyAffinity[site] {
+		switch {
+		case adj == hydrologyExcluded:
+			excluded = append(excluded, indicator)
+		case adj > 0:
+			suited = append(suited, indicator)
+		}
+	}
+	sort.Strings(excluded)
+	sort.Strings(suited)
+	return excluded, suited
+}
+
+// hydrologyFilterSQL returns the candidate clause for site_hydrology (empty
+// when unset) and its argument, bound as $n
+func hydrologyFilterSQL(prefs Preferences, n int) (string, interface{}) {
+	if prefs.SiteHydrology == "" {
+		return "", nil
+	}
+
+	excluded, suited := hydrologyIndicators(prefs.SiteHydrology)
+	if prefs.HydrologyStrict && len(suited) > 0 {
+		return fmt.Sprintf("AND su.wetland_indicator = ANY($%d)", n), pq.Array(suited)
+	}
+	if len(excluded) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("AND (su.wetland_indicator IS NULL OR su.wetland_indicator <> ALL($%d))", n), pq.Array(excluded)
+}
+
+// hydrologyAdjustments merges site_hydrology score adjustments into
+// adjustments (which may be nil)
+func hydrologyAdjustments(prefs Preferences, candidates []SpeciesRecommendation, adjustments map[int64]float64) map[int64]float64 {
+	affinity, ok := hydrologyAffinity[prefs.SiteHydrology]
+	if !ok {
+		return adjustments
+	}
+	if adjustments == nil {
+		adjustments = make(map[int64]float64)
+	}
+
+	for _, c := range candidates {
+		if c.WetlandIndicator == nil {
+			continue
+		}
+		if adj := affinity[*c.WetlandIndicator]; adj != hydrologyExcluded {
+			adjustments[c.SpeciesID] += adj
+		}
+	}
+	return adjustments
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestValidateSiteHydrology(t *testing.T) {
+	for _, tc := range []struct {
+		site       string
+		strict, ok bool
+	}{
+		{"", true, true}, // hydrology_strict alone is ignored
+		{"upland", true, true},
+		{"riparian", false, true},
+		{"wetland", true, true},
+		{"swamp", false, false},
+	} {
+		prefs := Preferences{SiteHydrology: tc.site, HydrologyStrict: tc.strict}
+		err := validateSiteHydrology(&prefs)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: %v", tc.site, err)
+		}
+		if tc.site == "" && prefs.HydrologyStrict {
+			t.Error("hydrology_strict kept without site_hydrology")
+		}
+	}
+}
+
+func TestHydrologyFilterSQL(t *testing.T) {
+	for _, tc := range []struct {
+		site   string
+		strict bool
+		clause string
+		arg    string
+	}{
+		{"", false, "", ""},
+		{"", true, "", ""},
+		{"upland", false, "AND (su.wetland_indicator IS NULL OR su.wetland_indicator <> ALL($9))", `{"obligate_wetland"}`},
+		{"upland", true, "AND (su.wetland_indicator IS NULL OR su.wetland_indicator <> ALL($9))", `{"obligate_wetland"}`}, // Nothing suits upland better
+		{"riparian", false, "", ""},
+		{"riparian", true, "AND su.wetland_indicator = ANY($9)", `{"facultative","facultative_wetland","obligate_wetland"}`},
+		{"wetland", false, "AND (su.wetland_indicator IS NULL OR su.wetland_indicator <> ALL($9))", `{"facultative_upland","upland"}`},
+		{"wetland", true, "AND su.wetland_indicator = ANY($9)", `{"facultative_wetland","obligate_wetland"}`},
+	} {
+		clause, arg := hydrologyFilterSQL(Preferences{SiteHydrology: tc.site, HydrologyStrict: tc.strict}, 9)
+		var got string
+		if arg != nil {
+			v, err := arg.(driver.Valuer).Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = v.(string)
+		}
+		if clause != tc.clause || got != tc.arg {
+			t.Errorf("%q strict=%v: %q with %s, want %q with %s", tc.site, tc.strict, clause, got, tc.clause, tc.arg)
+		}
+	}
+}
+
+func TestHydrologyAdjustments(t *testing.T) {
+	indicator := func(s string) *string { return &s }
+	candidates := []SpeciesRecommendation{
+		{SpeciesID: 1, WetlandIndicator: indicator("obligate_wetland")},
+		{SpeciesID: 2, WetlandIndicator: indicator("facultative_wetland")},
+		{SpeciesID: 3, WetlandIndicator: indicator("facultative")},
+		{SpeciesID: 4, WetlandIndicator: indicator("upland")},
+		{SpeciesID: 5}, // No indicator: neutral
+	}
+	for _, tc := range []struct {
+		site string
+		want map[int64]float64
+	}{
+		// Excluded indicators never reach selection, so they get no adjustment
+		{"upland", map[int64]float64{2: -0.15}},
+		{"riparian", map[int64]float64{1: 0.05, 2: 0.15, 3: 0.10, 4: -0.15}},
+		{"wetland", map[int64]float64{1: 0.15, 2: 0.10}},
+	} {
+		got := hydrologyAdjustments(Preferences{SiteHydrology: tc.site}, candidates, nil)
+		for id, adj := range got {
+			if adj == 0 {
+				delete(got, id)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.site, got, tc.want)
+		}
+	}
+
+	// Merged into plugin adjustments
+	got := hydrologyAdjustments(Preferences{SiteHydrology: "riparian"}, candidates[:1], map[int64]float64{1: 0.2, 9: 0.1})
+	if !reflect.DeepEqual(got, map[int64]float64{1: 0.25, 9: 0.1}) {
+		t.Errorf("merged: %v", got)
+	}
+	if got := hydrologyAdjustments(Preferences{}, candidates, nil); got != nil {
+		t.Errorf("without site_hydrology: %v, want nil", got)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// LOCALIZED NAMES
+// ============================================================================
+//
+// Region, biome and climate-zone labels are stored in English; translations
+// live in localized_names (migration 023). The response language comes from
+// ?lang= or Accept-Language, falling back to English (the stored labels).
+// The table is small and read-mostly, so it is cached in memory.
+//
+// Species common names (common_names) follow the same language: one name
+// per species, in the response language when it has one, otherwise in the
+// next of commonNameFallback, verified names first.
+
+const (
+	defaultLanguage      = "en"
+	localizedNamesMaxAge = 10 * time.Minute
+)
+
+var supportedLanguages = map[string]bool{"en": true, "pt": true, "es": true}
+
+var commonNameFallback = []string{"pt", "en", "es"}
+
+const (
+	nameKindTDWG       = "tdwg_region"
+	nameKindBiome      = "biome"
+	nameKindEcoregion  = "ecoregion"
+	nameKindWhittaker  = "whittaker_biome"
+	nameKindKoppenZone = "koppen_zone"
+)
+
+// requestLanguage picks the response language: ?lang= first, then the
+// highest-weighted supported Accept-Language entry (pt-BR matches pt)
+func requestLanguage(r *http.Request) string {
+	if lang := strings.ToLower(r.URL.Query().Get("lang")); supportedLanguages[lang] {
+		return lang
+	}
+
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if !supportedLanguages[base] {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > bestQ {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
+
+// setContentLanguage marks a response as localized for caches
+func setContentLanguage(w http.ResponseWriter, lang string) {
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+}
+
+type localizedNameCache struct {
+	mu       sync.RWMutex
+	names    map[string]string // kind|code|language -> name
+	loadedAt time.Time
+}
+
+func localizedNameKey(kind, code, lang string) string {
+	return kind + "|" + code + "|" + lang
+}
+
+func (c *localizedNameCache) refresh(ctx context.Context, db *sql.DB) {
+	c.mu.RLock()
+	fresh := c.names != nil && time.Since(c.loadedAt) < localizedNamesMaxAge
+	c.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT kind, code, language, name FROM localized_names`)
+	if err != nil {
+		// Keep serving stored labels (or the previous table) until the next retry
+		log.Printf("Error loading localized names: %v", err)
+		c.mu.Lock()
+		if c.names == nil {
+			c.names = map[string]string{}
+		}
+		c.loadedAt = time.Now()
+		c.mu.Unlock()
+		return
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var kind, code, lang, name string
+		if err := rows.Scan(&kind, &code, &lang, &name); err != nil {
+			log.Printf("Error scanning localized name: %v", err)
+			return
+		}
+		names[localizedNameKey(kind, code, lang)] = name
+	}
+
+	c.mu.Lock()
+	c.names, c.loadedAt = names, time.Now()
+	c.mu.Unlock()
+}
+
+// localize returns the name of kind/code in lang, or fallback
+func (s *Server) localize(ctx context.Context, kind, code, lang, fallback string) string {
+	s.names.refresh(ctx, s.db)
+	s.names.mu.RLock()
+	defer s.names.mu.RUnlock()
+	if name, ok := s.names.names[localizedNameKey(kind, code, lang)]; ok {
+		return name
+	}
+	return fallback
+}
+
+// localizeOptional is localize for nullable labels that are their own code
+func (s *Server) localizeOptional(ctx context.Context, kind string, label *string, lang string) *string {
+	if label == nil {
+		return nil
+	}
+	name := s.localize(ctx, kind, *label, lang, *label)
+	return &name
+}
+
+// handleLocalizedNames handles GET /api/i18n/names?kind=&lang=, the label
+// table for the dashboard
+func (s *Server) handleLocalizedNames(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	lang := requestLanguage(r)
+	kind := r.URL.Query().Get("kind")
+	switch kind {
+	case "", nameKindTDWG, nameKindBiome, nameKindEcoregion, nameKindWhittaker, nameKindKoppenZone:
+	default:
+		http.Error(w, fmt.Sprintf(`{"error": "invalid kind: %s"}`, kind), http.StatusBadRequest)
+		return
+	}
+
+	s.names.refresh(ctx, s.db)
+	s.names.mu.RLock()
+	names := map[string]map[string]string{}
+	for key, name := range s.names.names {
+		parts := strings.SplitN(key, "|", 3)
+		if parts[2] != lang || (kind != "" && parts[0] != kind) {
+			continue
+		}
+		if names[parts[0]] == nil {
+			names[parts[0]] = map[string]string{}
+		}
+		names[parts[0]][parts[1]] = name
+	}
+	s.names.mu.RUnlock()
+
+	languages := make([]string, 0, len(supportedLanguages))
+	for l := range supportedLanguages {
+		languages = append(languages, l)
+	}
+	sort.Strings(languages)
+
+	setContentLanguage(w, lang)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"language":  lang,
+		"languages": languages,
+		"names":     names,
+	})
+}
+
+// localizeRecommendation localizes the location, the species threat status
+// labels and the common names of resp
+func (s *Server) localizeRecommendation(ctx context.Context, resp *RecommendResponse, lang string) {
+	s.localizeLocation(ctx, &resp.LocationInfo, lang)
+	resp.Species = withThreatLabels(resp.Species, lang)
+
+	ids := make([]int64, len(resp.Species))
+	for i, sp := range resp.Species {
+		ids[i] = sp.SpeciesID
+	}
+	names, err := s.commonNames(ctx, ids, lang)
+	if err != nil {
+		s.log.Printf("Error loading common names: %v", err)
+		return
+	}
+	for i := range resp.Species {
+		if cn, ok := names[resp.Species[i].SpeciesID]; ok {
+			resp.Species[i].CommonName, resp.Species[i].CommonNameLanguage = &cn.Name, &cn.Language
+		}
+	}
+}
+
+// localizeLocation translates the region and Köppen zone names of a
+// recommendation location
+func (s *Server) localizeLocation(ctx context.Context, loc *LocationInfo, lang string) {
+	loc.TDWGName = s.localize(ctx, nameKindTDWG, loc.TDWGCode, lang, loc.TDWGName)
+	loc.KoppenName = s.localizeOptional(ctx, nameKindKoppenZone, loc.KoppenZone, lang)
+}
+
+// commonNameLanguages is the order in which common names are looked for:
+// lang, then the rest of commonNameFallback
+func commonNameLanguages(lang string) []string {
+	langs := []string{lang}
+	for _, l := range commonNameFallback {
+		if l != lang {
+			langs = append(langs, l)
+		}
+	}
+	return langs
+}
+
+// commonNameJoin is a LEFT JOIN LATERAL giving alias.common_name and
+// alias.language, the best name of species speciesID for the language order
+// in placeholder $param (a text array from commonNameLanguages)
+func commonNameJoin(alias, speciesID string, param int) string {
+	return fmt.Sprintf(`LEFT JOIN LATERAL (
+			SELECT c.common_name, c.language FROM common_names c
+			WHERE c.species_id = %[2]s AND c.language = ANY($%[3]d::text[])
+			ORDER BY array_position($%[3]d::text[], c.language), c.verified DESC NULLS LAST, c.common_name
+			LIMIT 1
+		) %[1]s ON TRUE`, alias, speciesID, param)
+}
+
+type localizedCommonName struct {
+	Name     string
+	Language string
+}
+
+// commonNames picks the common name of each species in ids for lang,
+// omitting species without one
+func (s *Server) commonNames(ctx context.Context, ids []int64, lang string) (map[int64]localizedCommonName, error) {
+	names := make(map[int64]localizedCommonName, len(ids))
+	if len(ids) == 0 {
+		return names, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (species_id) species_id, common_name, language
+		FROM common_names
+		WHERE species_id = ANY($1) AND language = ANY($2::text[])
+		ORDER BY species_id, array_position($2::text[], language), verified DESC NULLS LAST, common_name
+	`, pq.Array(ids), pq.Array(commonNameLanguages(lang)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var cn localizedCommonName
+		if err := rows.Scan(&id, &cn.Name, &cn.Language); err != nil {
+			return nil, err
+		}
+		names[id] = cn
+	}
+	return names, rows.Err()
+}
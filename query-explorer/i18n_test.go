@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLanguage(t *testing.T) {
+	tests := []struct {
+		query, acceptLanguage, want string
+	}{
+		{"", "", "en"},
+		{"", "pt-BR,pt;q=0.9,en;q=0.8", "pt"},
+		{"", "fr-FR, es;q=0.7, en;q=0.5", "es"},
+		{"", "en;q=0.3, pt;q=0.6", "pt"},
+		{"", "de", "en"},
+		{"?lang=es", "pt-BR", "es"},
+		{"?lang=xx", "pt-BR", "pt"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/tdwg"+tt.query, nil)
+		if tt.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		if got := requestLanguage(r); got != tt.want {
+			t.Errorf("lang %q, Accept-Language %q: got %s, want %s", tt.query, tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestCommonNameLanguages(t *testing.T) {
+	tests := map[string][]string{
+		"en": {"en", "pt", "es"},
+		"pt": {"pt", "en", "es"},
+		"es": {"es", "pt", "en"},
+	}
+	for lang, want := range tests {
+		got := commonNameLanguages(lang)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("commonNameLanguages(%s) = %v, want %v", lang, got, want)
+		}
+	}
+
+	join := commonNameJoin("cn", "s.id", 2)
+	for _, want := range []string{"c.species_id = s.id", "ANY($2::text[])", "array_position($2::text[], c.language)", ") cn ON TRUE"} {
+		if !strings.Contains(join, want) {
+			t.Errorf("commonNameJoin missing %q: %s", want, join)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// INATURALIST OBSERVATIONS
+// ============================================================================
+//
+// /api/climate/species?lat=&lon= adds the research-grade iNaturalist
+// observations of the species near the user: the count within radius_km
+// (default 50), the most recent few with photo, and a link to the full list
+// on inaturalist.org, so users can check the species really occurs nearby.
+// Answers are cached in memory per species and ~10 km cell for a day; the
+// iNaturalist API asks clients to stay around one request per second, and a
+// failed lookup only leaves the field out.
+
+const (
+	inatDefaultRadiusKm = 50
+	inatMaxRadiusKm     = 200
+	inatRecent          = 3
+	inatCacheMaxAge     = 24 * time.Hour
+	inatCacheMaxEntries = 10000
+	inatTimeout         = 5 * time.Second
+)
+
+// defaultINatAPIURL is the iNaturalist API base (Server.inatURL in tests)
+const defaultINatAPIURL = "https://api.inaturalist.org/v1"
+
+type INatObservation struct {
+	ID         int64   `json:"id"`
+	URL        string  `json:"url"`
+	ObservedOn string  `json:"observed_on,omitempty"`
+	PlaceGuess string  `json:"place_guess,omitempty"`
+	PhotoURL   *string `json:"photo_url,omitempty"`
+}
+
+// INatObservations summarizes the observations near a location
+type INatObservations struct {
+	RadiusKm      float64           `json:"radius_km"`
+	ResearchGrade int               `json:"research_grade_count"`
+	Recent        []INatObservation `json:"recent"`
+	BrowseURL     string            `json:"browse_url"` // The same search on inaturalist.org
+	FetchedAt     string            `json:"fetched_at"`
+}
+
+type inatCacheEntry struct {
+	obs       *INatObservations
+	fetchedAt time.Time
+}
+
+type inatCache struct {
+	mu      sync.Mutex
+	entries map[string]inatCacheEntry
+}
+
+// inatCell rounds a location to 0.1°, so users of one area share entries
+func inatCell(lat, lon float64) (float64, float64) {
+	return math.Round(lat*10) / 10, math.Round(lon*10) / 10
+}
+
+func inatCacheKey(name string, lat, lon, radiusKm float64) string {
+	lat, lon = inatCell(lat, lon)
+	return fmt.Sprintf("%s|%.1f|%.1f|%g", name, lat, lon, radiusKm)
+}
+
+func (c *inatCache) get(key string) (*INatObservations, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.fetchedAt) > inatCacheMaxAge {
+		return nil, false
+	}
+	return e.obs, true
+}
+
+func (c *inatCache) put(key string, obs *INatObservations) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= inatCacheMaxEntries {
+		for k, e := range c.entries {
+			if time.Since(e.fetchedAt) > inatCacheMaxAge {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= inatCacheMaxEntries {
+			c.entries = map[string]inatCacheEntry{}
+		}
+	}
+	c.entries[key] = inatCacheEntry{obs: obs, fetchedAt: time.Now()}
+}
+
+// inatSearchParams are the observation filters shared by the API call and
+// the browse link
+func inatSearchParams(name string, lat, lon, radiusKm float64) url.Values {
+	return url.Values{
+		"taxon_name":    {name},
+		"quality_grade": {"research"},
+		"lat":           {strconv.FormatFloat(lat, 'f', 4, 64)},
+		"lng":           {strconv.FormatFloat(lon, 'f', 4, 64)},
+		"radius":        {strconv.FormatFloat(radiusKm, 'f', -1, 64)},
+	}
+}
+
+// nearbyINatObservations returns the research-grade observations of name
+// within radiusKm of the 0.1° cell of lat/lon, from the cache when fresh.
+// The search and browse link use the cell, not the point: the entry is
+// shared with every user of the cell.
+func (s *Server) nearbyINatObservations(ctx context.Context, name string, lat, lon, radiusKm float64) (*INatObservations, error) {
+	key := inatCacheKey(name, lat, lon, radiusKm)
+	if obs, ok := s.inat.get(key); ok {
+		return obs, nil
+	}
+	lat, lon = inatCell(lat, lon)
+
+	params := inatSearchParams(name, lat, lon, radiusKm)
+	params.Set("per_page", strconv.Itoa(inatRecent))
+	params.Set("order_by", "observed_on")
+	params.Set("photos", "true")
+
+	ctx, cancel := context.WithTimeout(ctx, inatTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.inatURL+"/observations?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "DiversiPlant/1.0 (+https://diversiplant.andreyandrade.com)")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("iNaturalist returned %s", resp.Status)
+	}
+
+	var body struct {
+		TotalResults int `json:"total_results"`
+		Results      []struct {
+			ID         int64  `json:"id"`
+			URI        string `json:"uri"`
+			ObservedOn string `json:"observed_on"`
+			PlaceGuess string `json:"place_guess"`
+			Photos     []struct {
+				URL string `json:"url"`
+			} `json:"photos"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding iNaturalist response: %w", err)
+	}
+
+	obs := &INatObservations{
+		RadiusKm:      radiusKm,
+		ResearchGrade: body.TotalResults,
+		Recent:        []INatObservation{},
+		BrowseURL:     "https://www.inaturalist.org/observations?" + inatSearchParams(name, lat, lon, radiusKm).Encode(),
+		FetchedAt:     time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, r := range body.Results {
+		o := INatObservation{ID: r.ID, URL: r.URI, ObservedOn: r.ObservedOn, PlaceGuess: r.PlaceGuess}
+		if o.URL == "" {
+			o.URL = "https://www.inaturalist.org/observations/" + strconv.FormatInt(r.ID, 10)
+		}
+		if len(r.Photos) > 0 {
+			photo := r.Photos[0].URL
+			o.PhotoURL = &photo
+		}
+		obs.Recent = append(obs.Recent, o)
+	}
+
+	s.inat.put(key, obs)
+	return obs, nil
+}
+
+// parseINatLocation reads ?lat=&lon=&radius_km=; ok is false without a location
+func parseINatLocation(q url.Values) (lat, lon, radiusKm float64, ok bool, err error) {
+	if q.Get("lat") == "" && q.Get("lon") == "" {
+		return 0, 0, 0, false, nil
+	}
+	lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
+	lon, err2 := strconv.ParseFloat(q.Get("lon"), 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, 0, false, fmt.Errorf("invalid lat/lon")
+	}
+	radiusKm = inatDefaultRadiusKm
+	if v := q.Get("radius_km"); v != "" {
+		radiusKm, err = strconv.ParseFloat(v, 64)
+		if err != nil || radiusKm <= 0 || radiusKm > inatMaxRadiusKm {
+			return 0, 0, 0, false, fmt.Errorf("radius_km must be between 0 and %d", inatMaxRadiusKm)
+		}
+	}
+	return lat, lon, radiusKm, true, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNearbyINatObservations(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		q := r.URL.Query()
+		if q.Get("taxon_name") != "Euterpe edulis" || q.Get("quality_grade") != "research" || q.Get("radius") != "50" {
+			t.Errorf("query = %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"total_results": 42, "results": [
+			{"id": 1001, "observed_on": "2026-03-02", "place_guess": "Ubatuba, SP", "photos": [{"url": "https://static.inaturalist.org/1.jpg"}]},
+			{"id": 1002, "uri": "https://www.inaturalist.org/observations/1002"}
+		]}`))
+	}))
+	defer srv.Close()
+	s := newTestServer()
+	s.inatURL = srv.URL
+
+	obs, err := s.nearbyINatObservations(context.Background(), "Euterpe edulis", -23.43, -45.08, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.ResearchGrade != 42 || len(obs.Recent) != 2 {
+		t.Fatalf("obs = %+v", obs)
+	}
+	// Built from the shared cell, never the caller's point
+	if browse, _ := url.Parse(obs.BrowseURL); browse.Query().Get("lat") != "-23.4000" || browse.Query().Get("lng") != "-45.1000" {
+		t.Errorf("browse_url = %s", obs.BrowseURL)
+	}
+	if obs.Recent[0].URL != "https://www.inaturalist.org/observations/1001" || obs.Recent[0].PhotoURL == nil || obs.Recent[1].PhotoURL != nil {
+		t.Errorf("recent = %+v", obs.Recent)
+	}
+
+	// A nearby point in the same 0.1° cell is served from the cache
+	if _, err := s.nearbyINatObservations(context.Background(), "Euterpe edulis", -23.41, -45.06, 50); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("%d API calls, want 1", calls)
+	}
+}
+
+func TestParseINatLocation(t *testing.T) {
+	if _, _, _, ok, err := parseINatLocation(url.Values{}); ok || err != nil {
+		t.Errorf("no location: ok=%v err=%v", ok, err)
+	}
+	_, _, radius, ok, err := parseINatLocation(url.Values{"lat": {"-23.4"}, "lon": {"-45.1"}})
+	if !ok || err != nil || radius != inatDefaultRadiusKm {
+		t.Errorf("default radius: %v %v %v", radius, ok, err)
+	}
+	for _, q := range []url.Values{
+		{"lat": {"-23.4"}},
+		{"lat": {"95"}, "lon": {"0"}},
+		{"lat": {"0"}, "lon": {"0"}, "radius_km": {"500"}},
+	} {
+		if _, _, _, _, err := parseINatLocation(q); err == nil {
+			t.Errorf("parseINatLocation(%v): expected an error", q)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ============================================================================
+// JSON DECODING AND ENCODING
+// ============================================================================
+//
+// Request bodies are decoded strictly: unknown fields and trailing data are
+// rejected, so a misspelled option ("n_specie") is an error instead of being
+// silently ignored. Numbers landing in interface{} stay json.Number.
+
+// decodeJSON strictly decodes exactly one JSON value from body into v
+func decodeJSON(body io.Reader, v interface{}) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return errors.New("unexpected data after JSON body")
+	}
+	return nil
+}
+
+// decodeJSONBody decodes the request body into v, answering 400 on failure
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := decodeJSON(r.Body, v); err != nil {
+		writeDecodeError(w, "Invalid JSON", err)
+		return false
+	}
+	return true
+}
+
+func writeDecodeError(w http.ResponseWriter, prefix string, err error) {
+	msg, _ := json.Marshal(map[string]string{"error": fmt.Sprintf("%s: %s", prefix, err.Error())})
+	http.Error(w, string(msg), http.StatusBadRequest)
+}
+
+// queryJSONValue converts a value scanned from a column of the given
+// Postgres type for JSON output. NUMERIC arrives as text and is kept as the
+// exact decimal (json.Number) rather than a float64 or a string.
+func queryJSONValue(v interface{}, dbType string) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	s := string(b)
+	if dbType == "NUMERIC" && isJSONNumber(s) {
+		return json.Number(s)
+	}
+	return s
+}
+
+// isJSONNumber rejects NUMERIC's NaN and Infinity, which JSON cannot carry
+func isJSONNumber(s string) bool {
+	if _, err := strconv.ParseFloat(s, 64); err != nil && !errors.Is(err, strconv.ErrRange) {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONStrict(t *testing.T) {
+	var req RecommendRequest
+	if err := decodeJSON(strings.NewReader(`{"tdwg_code": "BZL", "n_species": 10}`), &req); err != nil {
+		t.Fatalf("valid body rejected: %v", err)
+	}
+	for _, body := range []string{
+		`{"tdwg_code": "BZL", "n_specie": 10}`,
+		`{"preferences": {"growth_form": ["tree"]}}`,
+		`{"tdwg_code": "BZL"} {"tdwg_code": "BZS"}`,
+		`{"tdwg_code": `,
+	} {
+		if err := decodeJSON(strings.NewReader(body), &RecommendRequest{}); err == nil {
+			t.Errorf("%s accepted", body)
+		}
+	}
+}
+
+func TestQueryJSONValue(t *testing.T) {
+	out, _ := json.Marshal([]interface{}{
+		queryJSONValue([]byte("12345678901234567890.123456789"), "NUMERIC"),
+		queryJSONValue([]byte("NaN"), "NUMERIC"),
+		queryJSONValue([]byte("Fabaceae"), "VARCHAR"),
+		queryJSONValue(int64(9007199254740993), "INT8"),
+	})
+	want := `[12345678901234567890.123456789,"NaN","Fabaceae",9007199254740993]`
+	if string(out) != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
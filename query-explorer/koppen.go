@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ============================================================================
+// KÖPPEN ZONE MATCHING
+// ============================================================================
+//
+// A simpler climate match than the envelopes: a species suits the site if it
+// is native somewhere with the site's Köppen zone. Species zones are those of
+// their native TDWG regions (species_koppen_zones, migration 033), and the
+// site zone is that of its TDWG region, so both sides use the same
+// region-level classification. Preferences.KoppenMatch:
+//
+//	filter  keep only species whose zones include the site's, in addition
+//	        to climate_threshold
+//	only    the zone overlap replaces climate_threshold; the climate match
+//	        score still orders candidates
+
+var validKoppenMatchModes = map[string]bool{"filter": true, "only": true}
+
+// validateKoppenMatch checks the koppen_match preference
+func validateKoppenMatch(prefs *Preferences) error {
+	if prefs.KoppenMatch == "" || validKoppenMatchModes[prefs.KoppenMatch] {
+		return nil
+	}
+	return fmt.Errorf("invalid koppen_match: %s (use filter or only)", prefs.KoppenMatch)
+}
+
+// siteKoppenZone returns the Köppen zone of the site's TDWG region, nil if
+// unknown
+func (s *Server) siteKoppenZone(ctx context.Context, tdwgCode string) *string {
+	var zone sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT koppen_zone FROM tdwg_climate WHERE tdwg_code = $1`, tdwgCode).Scan(&zone)
+	if err != nil || !zone.Valid {
+		return nil
+	}
+	return &zone.String
+}
+
+// koppenFilterSQL returns the candidate clause for koppen_match (empty when
+// unset), with the site zone bound as $n
+func koppenFilterSQL(prefs Preferences, n int) string {
+	if prefs.KoppenMatch == "" {
+		return ""
+	}
+	return fmt.Sprintf("AND skz.koppen_zones @> ARRAY[$%d]::text[]", n)
+}
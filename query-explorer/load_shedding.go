@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// LOAD SHEDDING
+// ============================================================================
+//
+// A circuit breaker protects the shared Postgres during traffic spikes. Every
+// LOAD_SHED_INTERVAL (5s) it checks two signals of overload over the last
+// interval:
+//
+//	pool wait     mean wait for a connection from the pool (sql.DBStats)
+//	error budget  share of /api/ requests that failed (5xx) or were slow
+//
+// When either passes its threshold the breaker opens: expensive endpoints
+// (recommendations, ad-hoc queries, exports, climate matching) answer 503
+// with Retry-After, while cheap reads keep working and /api/stats is served
+// from its last good response. After the cooldown the breaker lets one in
+// LOAD_SHED_PROBE_EVERY expensive requests through; a healthy interval closes
+// it, an unhealthy one reopens it with twice the cooldown (up to 5m).
+//
+// Endpoints with a longer statement timeout (streams, exports) count only
+// their errors, not their duration.
+
+const (
+	defaultLoadShedInterval    = 5 * time.Second
+	defaultLoadShedPoolWait    = 500 * time.Millisecond
+	defaultLoadShedSlowRequest = 5 * time.Second
+	defaultLoadShedErrorBudget = 0.1
+	defaultLoadShedMinRequests = 20
+	defaultLoadShedCooldown    = 30 * time.Second
+	maxLoadShedCooldown        = 5 * time.Minute
+	defaultLoadShedProbeEvery  = 10
+)
+
+// defaultExpensiveEndpoints are shed while the breaker is open; a trailing
+// slash matches the subtree and {name} segments match as in the router
+var defaultExpensiveEndpoints = []string{
+	"/api/recommend",
+	"/api/recommend/stream",
+	"/api/recommend/batch",
+	"/api/recommend/sensitivity",
+	"/api/recommend/explain",
+	"/api/recommend/sandbox",
+	"/api/recommend/sandbox/",
+	"/api/query",
+	"/api/query/async",
+	"/api/query/explain",
+	"/api/export/",
+	"/api/climate/match",
+	"/api/climate/analogs",
+	"/api/climate/species",
+	"/api/compliance/check",
+	"/api/ecoregion/species",
+	"/api/species/{id}/suitability-map",
+}
+
+type loadShedding struct {
+	Interval    time.Duration // 0 disables the breaker
+	MaxPoolWait time.Duration
+	SlowRequest time.Duration
+	ErrorBudget float64
+	MinRequests int // Below this many requests the error budget is not judged
+	Cooldown    time.Duration
+	ProbeEvery  int
+	Expensive   []string
+}
+
+// loadLoadShedding reads LOAD_SHED_INTERVAL, LOAD_SHED_POOL_WAIT,
+// LOAD_SHED_SLOW_REQUEST, LOAD_SHED_ERROR_BUDGET, LOAD_SHED_MIN_REQUESTS,
+// LOAD_SHED_COOLDOWN, LOAD_SHED_PROBE_EVERY and LOAD_SHED_ENDPOINTS (comma
+// separated, replaces the default list)
+func loadLoadShedding() loadShedding {
+	c := loadShedding{
+		Interval:    getEnvDuration("LOAD_SHED_INTERVAL", defaultLoadShedInterval),
+		MaxPoolWait: getEnvDuration("LOAD_SHED_POOL_WAIT", defaultLoadShedPoolWait),
+		SlowRequest: getEnvDuration("LOAD_SHED_SLOW_REQUEST", defaultLoadShedSlowRequest),
+		ErrorBudget: defaultLoadShedErrorBudget,
+		MinRequests: getEnvInt("LOAD_SHED_MIN_REQUESTS", defaultLoadShedMinRequests),
+		Cooldown:    getEnvDuration("LOAD_SHED_COOLDOWN", defaultLoadShedCooldown),
+		ProbeEvery:  getEnvInt("LOAD_SHED_PROBE_EVERY", defaultLoadShedProbeEvery),
+		Expensive:   defaultExpensiveEndpoints,
+	}
+	if value, ok := os.LookupEnv("LOAD_SHED_ERROR_BUDGET"); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f > 0 && f <= 1 {
+			c.ErrorBudget = f
+		} else {
+			log.Printf("Invalid LOAD_SHED_ERROR_BUDGET=%q, using %g", value, defaultLoadShedErrorBudget)
+		}
+	}
+	if value := os.Getenv("LOAD_SHED_ENDPOINTS"); value != "" {
+		c.Expensive = nil
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); strings.HasPrefix(path, "/") {
+				c.Expensive = append(c.Expensive, path)
+			}
+		}
+	}
+	if c.ProbeEvery < 1 {
+		c.ProbeEvery = 1
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = defaultLoadShedCooldown
+	}
+	return c
+}
+
+// expensive reports whether path is shed while the breaker is open
+func (c loadShedding) expensive(path string) bool {
+	for _, pattern := range c.Expensive {
+		if path == pattern || patternCovers(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// LoadSheddingStatus is the breaker as reported by /api/health
+type LoadSheddingStatus struct {
+	State      breakerState `json:"state"`
+	Reason     string       `json:"reason,omitempty"`
+	RetryAfter int          `json:"retry_after_s,omitempty"`
+	Shed       int64        `json:"shed_requests"` // Since startup
+}
+
+type loadBreaker struct {
+	cfg loadShedding
+
+	mu        sync.Mutex
+	state     breakerState
+	reason    string
+	openUntil time.Time
+	cooldown  time.Duration
+	probes    int
+	shed      int64
+
+	// The current interval
+	requests, bad int
+	waitCount     int64
+	waitDuration  time.Duration
+}
+
+func newLoadBreaker(cfg loadShedding) *loadBreaker {
+	return &loadBreaker{cfg: cfg, state: breakerClosed, cooldown: cfg.Cooldown}
+}
+
+// observe counts a finished request toward the interval's error budget;
+// timed says whether its duration counts (not for long-running endpoints)
+func (b *loadBreaker) observe(d time.Duration, status int, timed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	if status >= 500 || (timed && d > b.cfg.SlowRequest) {
+		b.bad++
+	}
+}
+
+// allow reports whether an expensive request may run now, and otherwise
+// how long the client should wait
+func (b *loadBreaker) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && !now.Before(b.openUntil) {
+		b.state, b.probes = breakerHalfOpen, 0
+	}
+	switch b.state {
+	case breakerOpen:
+		b.shed++
+		return false, b.openUntil.Sub(now)
+	case breakerHalfOpen:
+		b.probes++
+		if (b.probes-1)%b.cfg.ProbeEvery != 0 {
+			b.shed++
+			return false, b.cfg.Interval
+		}
+	}
+	return true, 0
+}
+
+// evaluate judges the interval that just ended from the request counts and
+// the pool statistics, and moves the breaker accordingly
+func (b *loadBreaker) evaluate(now time.Time, stats sql.DBStats) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && !now.Before(b.openUntil) {
+		b.state, b.probes = breakerHalfOpen, 0
+	}
+
+	var reasons []string
+	if waits := stats.WaitCount - b.waitCount; waits > 0 {
+		if mean := (stats.WaitDuration - b.waitDuration) / time.Duration(waits); mean > b.cfg.MaxPoolWait {
+			reasons = append(reasons, "pool wait "+mean.Round(time.Millisecond).String())
+		}
+	}
+	if b.requests >= b.cfg.MinRequests && float64(b.bad) > b.cfg.ErrorBudget*float64(b.requests) {
+		reasons = append(reasons, strconv.Itoa(b.bad)+"/"+strconv.Itoa(b.requests)+" requests slow or failed")
+	}
+	b.waitCount, b.waitDuration = stats.WaitCount, stats.WaitDuration
+	b.requests, b.bad = 0, 0
+
+	switch {
+	case len(reasons) > 0 && b.state != breakerOpen:
+		if b.state == breakerHalfOpen {
+			b.cooldown *= 2
+			if b.cooldown > maxLoadShedCooldown {
+				b.cooldown = maxLoadShedCooldown
+			}
+		}
+		b.state, b.reason, b.openUntil = breakerOpen, strings.Join(reasons, ", "), now.Add(b.cooldown)
+		log.Printf("Load shedding on for %s: %s", b.cooldown, b.reason)
+	case len(reasons) == 0 && b.state == breakerHalfOpen:
+		b.state, b.reason, b.cooldown = breakerClosed, "", b.cfg.Cooldown
+		log.Printf("Load shedding off")
+	}
+}
+
+// shedding reports whether the breaker is not closed
+func (b *loadBreaker) shedding() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
+
+func (b *loadBreaker) status(now time.Time) LoadSheddingStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := LoadSheddingStatus{State: b.state, Reason: b.reason, Shed: b.shed}
+	if b.state == breakerOpen && now.Before(b.openUntil) {
+		st.RetryAfter = int(b.openUntil.Sub(now).Round(time.Second) / time.Second)
+	}
+	return st
+}
+
+// startLoadShedding samples the pool every interval; without it the breaker
+// stays closed
+func (s *Server) startLoadShedding() {
+	if s.cfg.LoadShedding.Interval <= 0 {
+		s.log.Printf("Load shedding disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.cfg.LoadShedding.Interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.breaker.evaluate(now, s.db.Stats())
+		}
+	}()
+}
+
+// statusRecorder keeps the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming endpoints working through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// loadSheddingMiddleware sheds expensive API requests while the breaker is
+// open and feeds every API request's outcome to it
+func (s *Server) loadSheddingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/api/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.cfg.LoadShedding.expensive(r.URL.Path) {
+			if ok, wait := s.breaker.allow(time.Now()); !ok {
+				retry := int((wait + time.Second - 1) / time.Second)
+				if retry < 1 {
+					retry = 1
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", strconv.Itoa(retry))
+				http.Error(w, `{"error": "The database is overloaded; retry later"}`, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		timed := s.cfg.StatementTimeouts.forPath(r.URL.Path) <= s.cfg.StatementTimeouts.fallback
+		s.breaker.observe(time.Since(start), rec.status, timed)
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testLoadShedding() loadShedding {
+	return loadShedding{
+		Interval:    5 * time.Second,
+		MaxPoolWait: 500 * time.Millisecond,
+		SlowRequest: time.Second,
+		ErrorBudget: 0.1,
+		MinRequests: 10,
+		Cooldown:    30 * time.Second,
+		ProbeEvery:  3,
+		Expensive:   []string{"/api/recommend", "/api/export/", "/api/species/{id}/suitability-map"},
+	}
+}
+
+func TestLoadBreaker(t *testing.T) {
+	b := newLoadBreaker(testLoadShedding())
+	now := time.Now()
+
+	// Within budget: 1 slow request in 10
+	for i := 0; i < 9; i++ {
+		b.observe(10*time.Millisecond, 200, true)
+	}
+	b.observe(2*time.Second, 200, true)
+	b.evaluate(now, sql.DBStats{})
+	if b.shedding() {
+		t.Fatal("opened within the error budget")
+	}
+
+	// Long-running endpoints count errors only; too few requests are not judged
+	for i := 0; i < 10; i++ {
+		b.observe(time.Minute, 200, false)
+	}
+	b.observe(0, 503, true)
+	b.evaluate(now, sql.DBStats{})
+	if b.shedding() {
+		t.Fatal("opened on long-running requests")
+	}
+
+	// Pool waits averaging 1s open the breaker
+	b.evaluate(now, sql.DBStats{WaitCount: 4, WaitDuration: 4 * time.Second})
+	if !b.shedding() {
+		t.Fatal("pool wait did not open the breaker")
+	}
+	if ok, wait := b.allow(now.Add(10 * time.Second)); ok || wait != 20*time.Second {
+		t.Errorf("open: allow = %v, wait %s", ok, wait)
+	}
+
+	// After the cooldown one in ProbeEvery requests goes through
+	later := now.Add(31 * time.Second)
+	var allowed int
+	for i := 0; i < 6; i++ {
+		if ok, _ := b.allow(later); ok {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("half open: %d of 6 allowed, want 2", allowed)
+	}
+
+	// An unhealthy interval reopens with twice the cooldown
+	for i := 0; i < 10; i++ {
+		b.observe(0, 500, true)
+	}
+	b.evaluate(later, sql.DBStats{WaitCount: 4, WaitDuration: 4 * time.Second})
+	if st := b.status(later); st.State != breakerOpen || st.RetryAfter != 60 {
+		t.Errorf("reopened: %+v", st)
+	}
+
+	// A healthy interval after the cooldown closes it
+	b.evaluate(later.Add(61*time.Second), sql.DBStats{WaitCount: 4, WaitDuration: 4 * time.Second})
+	if b.shedding() {
+		t.Error("healthy interval did not close the breaker")
+	}
+	if b.cooldown != 30*time.Second {
+		t.Errorf("cooldown %s not reset", b.cooldown)
+	}
+}
+
+func TestLoadSheddingMiddleware(t *testing.T) {
+	s := newTestServer()
+	s.cfg.LoadShedding = testLoadShedding()
+	s.breaker = newLoadBreaker(s.cfg.LoadShedding)
+	s.breaker.evaluate(time.Now(), sql.DBStats{WaitCount: 1, WaitDuration: time.Second})
+
+	handler := s.loadSheddingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	for _, path := range []string{"/api/recommend", "/api/export/species", "/api/species/42/suitability-map"} {
+		rec := serve(path)
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" {
+			t.Errorf("%s: %d, Retry-After %q", path, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+	for _, path := range []string{"/api/species", "/api/species/42", "/api/recommend/plugins", "/api/health"} {
+		if rec := serve(path); rec.Code != http.StatusOK {
+			t.Errorf("%s shed: %d", path, rec.Code)
+		}
+	}
+
+	// The last good /api/stats response is served while shedding
+	s.stats.body = []byte(`{"total_species": 42}` + "\n")
+	rec := httptest.NewRecorder()
+	s.handleStats(rec, httptest.NewRequest("GET", "/api/stats", nil))
+	if rec.Body.String() != `{"total_species": 42}`+"\n" || rec.Header().Get("X-Cache") != "stale" {
+		t.Errorf("stats: %q, X-Cache %q", rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+}
@@ -0,0 +1,28 @@
+#!/bin/bash
+# Starts the docker-compose database (schema.sql and seed.sql on first start)
+# and applies the migrations it has not applied yet, in order, recording
+# them in fixture_migrations.
+#
+# Usage: ./loadtest/fixture_db.sh   (from query-explorer/; see `make fixture-db`)
+
+set -euo pipefail
+
+COMPOSE=(docker compose -f ../docker-compose.yml)
+
+"${COMPOSE[@]}" up -d --wait db
+
+psql() {
+    "${COMPOSE[@]}" exec -T db sh -c 'psql -q -v ON_ERROR_STOP=1 -U "$POSTGRES_USER" -d "$POSTGRES_DB" "$@"' psql "$@"
+}
+
+psql -c "CREATE TABLE IF NOT EXISTS fixture_migrations (name TEXT PRIMARY KEY, applied_at TIMESTAMP DEFAULT NOW())"
+applied=$(psql -At -c "SELECT name FROM fixture_migrations")
+
+for f in ../database/migrations/*.sql; do
+    name=$(basename "$f")
+    if grep -qx "$name" <<< "$applied"; then
+        continue
+    fi
+    echo "Applying $name"
+    { cat "$f"; echo; echo "INSERT INTO fixture_migrations (name) VALUES ('$name');"; } | psql
+done
@@ -0,0 +1,96 @@
+// Load profile for the hot endpoints: species listing, point climate and
+// recommendations, each at its own constant arrival rate. Run with
+// `make loadtest` (see README); BASE_URL, DURATION and the *_RATE
+// variables (requests per second) override the defaults.
+//
+// The thresholds are the release budget: k6 exits non-zero when one of
+// them is exceeded.
+
+import http from 'k6/http';
+import { check } from 'k6';
+
+const BASE_URL = __ENV.BASE_URL || 'http://localhost:8080';
+const DURATION = __ENV.DURATION || '1m';
+
+function rate(name, fallback) {
+  return parseInt(__ENV[name] || fallback, 10);
+}
+
+function scenario(exec, ratePerSecond) {
+  return {
+    executor: 'constant-arrival-rate',
+    exec,
+    rate: ratePerSecond,
+    timeUnit: '1s',
+    duration: DURATION,
+    preAllocatedVUs: Math.max(2, ratePerSecond * 2),
+    maxVUs: Math.max(10, ratePerSecond * 10),
+  };
+}
+
+export const options = {
+  scenarios: {
+    species: scenario('species', rate('SPECIES_RATE', 20)),
+    climate_point: scenario('climatePoint', rate('CLIMATE_POINT_RATE', 10)),
+    recommend: scenario('recommend', rate('RECOMMEND_RATE', 2)),
+  },
+  thresholds: {
+    'http_req_failed': ['rate<0.01'],
+    'http_req_duration{endpoint:species}': ['p(95)<300'],
+    'http_req_duration{endpoint:climate_point}': ['p(95)<500'],
+    'http_req_duration{endpoint:recommend}': ['p(95)<3000'],
+  },
+};
+
+// Locations in the fixture's regions (BZS, BZL)
+const SITES = [
+  { tdwg: 'BZS', lat: -27.6, lon: -48.65 },
+  { tdwg: 'BZS', lat: -25.43, lon: -49.27 },
+  { tdwg: 'BZL', lat: -23.55, lon: -46.63 },
+  { tdwg: 'BZL', lat: -22.91, lon: -43.17 },
+];
+const GROWTH_FORMS = ['', 'tree', 'shrub', 'palm'];
+
+function pick(list) {
+  return list[Math.floor(Math.random() * list.length)];
+}
+
+export function species() {
+  const site = pick(SITES);
+  let url = `${BASE_URL}/api/species?tdwg_code=${site.tdwg}&limit=50&offset=${pick([0, 50, 100])}`;
+  const form = pick(GROWTH_FORMS);
+  if (form) {
+    url += `&growth_form=${form}`;
+  }
+  const res = http.get(url, { tags: { endpoint: 'species' } });
+  check(res, { 'species 200': (r) => r.status === 200 });
+}
+
+export function climatePoint() {
+  const site = pick(SITES);
+  // Jitter within about 10 km, so requests do not repeat
+  const lat = site.lat + (Math.random() - 0.5) * 0.2;
+  const lon = site.lon + (Math.random() - 0.5) * 0.2;
+  const res = http.get(`${BASE_URL}/api/climate/point?lat=${lat.toFixed(4)}&lon=${lon.toFixed(4)}`, {
+    tags: { endpoint: 'climate_point' },
+    // Points without raster coverage are answered with 404
+    responseCallback: http.expectedStatuses(200, 404),
+  });
+  check(res, { 'climate point answered': (r) => r.status === 200 || r.status === 404 });
+}
+
+export function recommend() {
+  const site = pick(SITES);
+  const body = {
+    latitude: site.lat,
+    longitude: site.lon,
+    // Varied so requests miss the recommendation cache
+    n_species: 10 + Math.floor(Math.random() * 20),
+    climate_threshold: 0.5 + Math.random() * 0.2,
+  };
+  const res = http.post(`${BASE_URL}/api/recommend`, JSON.stringify(body), {
+    headers: { 'Content-Type': 'application/json' },
+    tags: { endpoint: 'recommend' },
+  });
+  check(res, { 'recommend 200': (r) => r.status === 200 });
+}
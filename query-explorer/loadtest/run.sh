@@ -0,0 +1,26 @@
+#!/bin/bash
+# Starts the API against the fixture database, waits for /api/health,
+# runs the k6 load profile and stops the API again.
+#
+# Usage: ./loadtest/run.sh [k6 flags]   (from query-explorer/; see `make loadtest`)
+
+set -euo pipefail
+
+PORT=8080
+BASE_URL=${BASE_URL:-http://localhost:$PORT}
+K6=${K6:-k6}
+
+go build -o /tmp/query-explorer-loadtest .
+DEV_MODE=true DB_PASSWORD=${DB_PASSWORD:-diversiplant_dev} /tmp/query-explorer-loadtest &
+SERVER_PID=$!
+trap 'kill $SERVER_PID 2>/dev/null || true' EXIT
+
+for _ in $(seq 1 30); do
+    if curl -fs "$BASE_URL/api/health" > /dev/null; then
+        break
+    fi
+    sleep 1
+done
+curl -fs "$BASE_URL/api/health" > /dev/null || { echo "API did not become healthy" >&2; exit 1; }
+
+BASE_URL=$BASE_URL $K6 run "$@" loadtest/hot_endpoints.js
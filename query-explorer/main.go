@@ -8,19 +8,16 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/acme/autocert"
 )
 
-var db *sql.DB
-
 type Config struct {
 	DBHost     string
 	DBPort     string
@@ -30,6 +27,44 @@ type Config struct {
 	Domain     string
 	CertDir    string
 	DevMode    bool
+
+	DataQualityInterval time.Duration
+	StatementTimeouts   statementTimeouts
+	QueryJobWorkers     int
+	QueryJobTimeout     time.Duration
+
+	SpatialExportTimeout   time.Duration
+	SpatialExportMaxQueued int
+
+	GeometryCacheInterval time.Duration
+	GeometryCacheTimeout  time.Duration
+	SourceSummaryAt       string // HH:MM, or "off"
+	AbundanceInterval     time.Duration
+	TDWGRollupInterval    time.Duration
+
+	TaxonomyPropagationInterval time.Duration
+
+	RateLimits rateLimits
+
+	CandidatePool candidatePoolLimits
+
+	Nursery nurseryConfig
+
+	Coordinates coordinatePrivacy
+
+	LoadShedding loadShedding
+
+	Retention retentionConfig
+
+	Demo demoConfig
+
+	Dataset datasetConfig
+
+	Public publicConfig
+
+	Tiles tileConfig
+
+	TrailingSlash string
 }
 
 func getConfig() Config {
@@ -42,6 +77,44 @@ func getConfig() Config {
 		Domain:     getEnv("DOMAIN", "diversiplant.andreyandrade.com"),
 		CertDir:    getEnv("CERT_DIR", "/opt/diversiplant-admin/certs"),
 		DevMode:    getEnv("DEV_MODE", "false") == "true",
+
+		DataQualityInterval: getEnvDuration("DATA_QUALITY_INTERVAL", 24*time.Hour),
+		StatementTimeouts:   loadStatementTimeouts(),
+		QueryJobWorkers:     getEnvInt("QUERY_JOB_WORKERS", 2),
+		QueryJobTimeout:     getEnvDuration("QUERY_JOB_TIMEOUT", 30*time.Minute),
+
+		SpatialExportTimeout:   getEnvDuration("SPATIAL_EXPORT_TIMEOUT", 30*time.Minute),
+		SpatialExportMaxQueued: getEnvInt("SPATIAL_EXPORT_MAX_QUEUED", 3),
+
+		GeometryCacheInterval: getEnvDuration("GEOMETRY_CACHE_INTERVAL", 24*time.Hour),
+		GeometryCacheTimeout:  getEnvDuration("GEOMETRY_CACHE_TIMEOUT", 10*time.Minute),
+		SourceSummaryAt:       loadSourceSummaryAt(),
+		AbundanceInterval:     getEnvDuration("ABUNDANCE_INTERVAL", 24*time.Hour),
+		TDWGRollupInterval:    getEnvDuration("TDWG_ROLLUP_INTERVAL", 24*time.Hour),
+
+		TaxonomyPropagationInterval: getEnvDuration("TAXONOMY_PROPAGATION_INTERVAL", time.Hour),
+
+		RateLimits: loadRateLimits(),
+
+		CandidatePool: loadCandidatePoolLimits(),
+
+		Nursery: loadNurseryConfig(),
+
+		Coordinates: loadCoordinatePrivacy(),
+
+		LoadShedding: loadLoadShedding(),
+
+		Retention: loadRetentionConfig(),
+
+		Demo: loadDemoConfig(),
+
+		Dataset: loadDatasetConfig(),
+
+		Public: loadPublicConfig(),
+
+		Tiles: loadTileConfig(),
+
+		TrailingSlash: loadTrailingSlash(),
 	}
 }
 
@@ -52,14 +125,43 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
-func initDB(cfg Config) error {
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+// getEnvDuration parses a duration (e.g. "30m", "24h"); "0" disables
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+// dbConnString is the lib/pq connection string of the configured database
+func (cfg Config) dbConnString() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
+}
 
-	var err error
-	db, err = sql.Open("postgres", connStr)
+func initDB(cfg Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.dbConnString())
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	db.SetMaxOpenConns(25)
@@ -70,46 +172,37 @@ func initDB(cfg Config) error {
 	defer cancel()
 
 	if err := db.PingContext(ctx); err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	log.Println("Database connected successfully")
-	return nil
+	return db, nil
 }
 
 func main() {
 	cfg := getConfig()
 
-	if err := initDB(cfg); err != nil {
+	db, err := initDB(cfg)
+	if err != nil {
 		log.Fatalf("Database initialization failed: %v", err)
 	}
 	defer db.Close()
 
-	mux := http.NewServeMux()
-
-	// Dashboard proxy (must be registered before catch-all)
-	dashboardProxy := newDashboardProxy()
-	mux.Handle("/diversiplant/", dashboardProxy)
-
-	// API routes
-	mux.HandleFunc("/api/health", handleHealth)
-	mux.HandleFunc("/api/stats", handleStats)
-	mux.HandleFunc("/api/tdwg", handleTDWG)
-	mux.HandleFunc("/api/species", handleSpecies)
-	mux.HandleFunc("/api/query", handleQuery)
-	mux.HandleFunc("/api/sources", handleSources)
-	mux.HandleFunc("/api/climate", handleClimate)
-	mux.HandleFunc("/api/climate/stats", handleClimateStats)
-	mux.HandleFunc("/api/climate/species", handleClimateSpecies)
-	mux.HandleFunc("/api/climate/point", handleClimatePoint)
-	mux.HandleFunc("/api/recommend", handleRecommend)
-	mux.HandleFunc("/api/ecoregion/species", handleEcoregionSpecies)
-
-	// Static files
-	mux.Handle("/", http.FileServer(http.Dir("static")))
-
-	// CORS middleware
-	handler := corsMiddleware(mux)
+	s := NewServer(db, cfg)
+	s.startDataQualityJob(cfg.DataQualityInterval)
+	s.startQueryJobWorkers(cfg.QueryJobWorkers, cfg.QueryJobTimeout)
+	s.startSpatialExportWorker(cfg.SpatialExportTimeout)
+	s.startGeometryCacheJob(cfg.GeometryCacheInterval, cfg.GeometryCacheTimeout)
+	s.startSourceSummaryJob(cfg.SourceSummaryAt)
+	s.startAbundanceJob(cfg.AbundanceInterval)
+	s.startTDWGRollupJob(cfg.TDWGRollupInterval)
+	s.startTaxonomyPropagationJob(cfg.TaxonomyPropagationInterval)
+	s.startRetentionJob()
+	s.startDatasetReleaseJob()
+	s.startLoadShedding()
+
+	handler := s.routes()
 
 	if cfg.DevMode {
 		// Development mode - HTTP only
@@ -157,27 +250,6 @@ func main() {
 	}
 }
 
-func newDashboardProxy() http.Handler {
-	dashboardURL := getEnv("DASHBOARD_URL", "http://127.0.0.1:8001")
-	target, _ := url.Parse(dashboardURL)
-	proxy := httputil.NewSingleHostReverseProxy(target)
-
-	// Custom error handler for when the Python server is offline
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Dashboard proxy error: %v", err)
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.WriteHeader(http.StatusBadGateway)
-		fmt.Fprintf(w, `<html><body style="font-family:sans-serif;text-align:center;padding:60px">
-			<h1>Dashboard Offline</h1>
-			<p>The DiversiPlant Shiny dashboard is not running.</p>
-			<p>Start it with: <code>uvicorn app:app --host 127.0.0.1 --port 8001</code></p>
-			<p><a href="/">Go to Admin UI</a></p>
-		</body></html>`)
-	}
-
-	return proxy
-}
-
 func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
 	target := "https://" + r.Host + r.URL.Path
 	if r.URL.RawQuery != "" {
@@ -189,14 +261,17 @@ func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+		if isPublicPath(r.URL.Path) {
+			// The widget API takes no credentials (see public.go)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+		} else {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		}
 
+		// OPTIONS is answered by the router, with the methods of the route
 		next.ServeHTTP(w, r)
 	})
 }
@@ -209,9 +284,13 @@ type HealthResponse struct {
 	PostGIS   string           `json:"postgis"`
 	Timestamp string           `json:"timestamp"`
 	Tables    map[string]int64 `json:"tables"`
+	Dashboard []UpstreamHealth `json:"dashboard,omitempty"`
+
+	LoadShedding LoadSheddingStatus `json:"load_shedding"`
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
 	resp := HealthResponse{
@@ -221,7 +300,7 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check database
-	if err := db.Ping(); err != nil {
+	if err := s.db.Ping(); err != nil {
 		resp.Status = "error"
 		resp.Database = err.Error()
 	} else {
@@ -230,7 +309,7 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 
 	// Check PostGIS
 	var postgisVersion string
-	err := db.QueryRow("SELECT PostGIS_version()").Scan(&postgisVersion)
+	err := s.db.QueryRowContext(ctx, "SELECT PostGIS_version()").Scan(&postgisVersion)
 	if err != nil {
 		resp.PostGIS = "not available"
 	} else {
@@ -241,7 +320,7 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	tables := []string{"species", "species_unified", "species_regions", "species_geometry", "tdwg_level3", "tdwg_climate"}
 	for _, table := range tables {
 		var count int64
-		err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
+		err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
 		if err != nil {
 			resp.Tables[table] = -1
 		} else {
@@ -249,6 +328,12 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Dashboard upstreams, as last seen by the proxy
+	if s.upstreams != nil {
+		resp.Dashboard = s.upstreams.health()
+	}
+	resp.LoadShedding = s.breaker.status(time.Now())
+
 	json.NewEncoder(w).Encode(resp)
 }
 
@@ -272,20 +357,40 @@ type GrowthFormCount struct {
 	Count      int64  `json:"count"`
 }
 
-func handleStats(w http.ResponseWriter, r *http.Request) {
+// statsCache is the last /api/stats response, served while shedding load
+type statsCache struct {
+	sync.Mutex
+	body []byte
+	at   time.Time
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
+	if s.breaker.shedding() {
+		s.stats.Lock()
+		body, at := s.stats.body, s.stats.at
+		s.stats.Unlock()
+		if body != nil {
+			w.Header().Set("X-Cache", "stale")
+			w.Header().Set("Last-Modified", at.UTC().Format(http.TimeFormat))
+			w.Write(body)
+			return
+		}
+	}
+
 	resp := StatsResponse{}
 
 	// Get counts
-	db.QueryRow("SELECT COUNT(*) FROM species").Scan(&resp.TotalSpecies)
-	db.QueryRow("SELECT COUNT(*) FROM species_unified").Scan(&resp.SpeciesUnified)
-	db.QueryRow("SELECT COUNT(*) FROM species_regions").Scan(&resp.SpeciesRegions)
-	db.QueryRow("SELECT COUNT(*) FROM species_geometry").Scan(&resp.SpeciesGeometry)
-	db.QueryRow("SELECT COUNT(*) FROM tdwg_level3").Scan(&resp.TDWGRegions)
+	s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM species").Scan(&resp.TotalSpecies)
+	s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM species_unified").Scan(&resp.SpeciesUnified)
+	s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM species_regions").Scan(&resp.SpeciesRegions)
+	s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM species_geometry").Scan(&resp.SpeciesGeometry)
+	s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tdwg_level3").Scan(&resp.TDWGRegions)
 
 	// Source breakdown
-	rows, err := db.Query(`
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT growth_form_source, COUNT(*)
 		FROM species_unified
 		WHERE growth_form_source IS NOT NULL
@@ -302,7 +407,7 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Growth form counts
-	rows, err = db.Query(`
+	rows, err = s.db.QueryContext(ctx, `
 		SELECT growth_form, COUNT(*)
 		FROM species_unified
 		WHERE growth_form IS NOT NULL
@@ -319,7 +424,14 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	json.NewEncoder(w).Encode(resp)
+	body, _ := json.Marshal(resp)
+	body = append(body, '\n')
+	if ctx.Err() == nil {
+		s.stats.Lock()
+		s.stats.body, s.stats.at = body, time.Now()
+		s.stats.Unlock()
+	}
+	w.Write(body)
 }
 
 type TDWGResponse struct {
@@ -329,9 +441,21 @@ type TDWGResponse struct {
 	Distance  float64 `json:"distance_km,omitempty"`
 }
 
-func handleTDWG(w http.ResponseWriter, r *http.Request) {
+// handleTDWG handles GET /api/tdwg?lat=&lon= and POST /api/tdwg (batch,
+// see tdwg_batch.go)
+func (s *Server) handleTDWG(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
+	if r.Method == http.MethodPost {
+		s.handleTDWGBatch(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET or POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
 	lat, _ := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
 	lon, _ := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
 
@@ -343,7 +467,7 @@ func handleTDWG(w http.ResponseWriter, r *http.Request) {
 	var resp TDWGResponse
 
 	// Try exact match first
-	err := db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
 		SELECT level3_code, level3_name, COALESCE(continent, '')
 		FROM tdwg_level3
 		WHERE ST_Contains(geom, ST_SetSRID(ST_Point($1, $2), 4326))
@@ -352,7 +476,7 @@ func handleTDWG(w http.ResponseWriter, r *http.Request) {
 
 	if err == sql.ErrNoRows {
 		// Try nearby
-		err = db.QueryRow(`
+		err = s.db.QueryRowContext(ctx, `
 			SELECT level3_code, level3_name, COALESCE(continent, ''),
 				   ROUND((ST_Distance(geom, ST_SetSRID(ST_Point($1, $2), 4326)) * 111)::numeric, 2)
 			FROM tdwg_level3
@@ -367,6 +491,9 @@ func handleTDWG(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lang := requestLanguage(r)
+	resp.Name = s.localize(ctx, nameKindTDWG, resp.Code, lang, resp.Name)
+	setContentLanguage(w, lang)
 	json.NewEncoder(w).Encode(resp)
 }
 
@@ -376,6 +503,7 @@ type SpeciesRequest struct {
 	Limit      int    `json:"limit"`
 	Offset     int    `json:"offset"`
 	NativeOnly bool   `json:"native_only"`
+	Status     string `json:"status"`
 }
 
 type SpeciesResponse struct {
@@ -387,24 +515,69 @@ type SpeciesResponse struct {
 }
 
 type SpeciesItem struct {
-	ID            int64   `json:"id"`
-	CanonicalName string  `json:"canonical_name"`
-	Family        string  `json:"family"`
-	GrowthForm    string  `json:"growth_form"`
-	Source        string  `json:"source"`
-	CommonName    *string `json:"common_name,omitempty"`
-	IsNative      bool    `json:"is_native"`
+	ID                 int64   `json:"id"`
+	CanonicalName      string  `json:"canonical_name"`
+	Family             string  `json:"family"`
+	GrowthForm         string  `json:"growth_form"`
+	Source             string  `json:"source"`
+	CommonName         *string `json:"common_name,omitempty"`
+	CommonNameLanguage *string `json:"common_name_language,omitempty"` // Requested language or the nearest fallback
+	IsNative           bool    `json:"is_native"`
+	Establishment      *string `json:"establishment_means,omitempty"`
+	Abundance          *string `json:"abundance,omitempty"` // rare, occasional, common in tdwg_code (see abundance.go)
 }
 
-func handleSpecies(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleSpecies(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
+	// aoi_id stands for its largest TDWG region (see aoi.go)
+	if err := s.resolveAOIQuery(r); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
 	tdwgCode := r.URL.Query().Get("tdwg_code")
 	growthForm := r.URL.Query().Get("growth_form")
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
 	nativeOnly := r.URL.Query().Get("native_only") == "true"
 
+	// Establishment status filter, e.g. ?status=native,naturalized
+	var statuses []string
+	if status := r.URL.Query().Get("status"); status != "" {
+		var err error
+		statuses, err = parseEstablishmentMeans(strings.Split(status, ","))
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Flora e Funga do Brasil filters, e.g. ?domain=Cerrado (see brazil_flora.go)
+	flora, err := parseBrazilFloraFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	// A level-2 code stands for its level-3 regions (see tdwg_levels.go)
+	regions, err := s.level3Codes(ctx, tdwgCode)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	regionCond, regionArg := "sr.tdwg_code = $1", interface{}(tdwgCode)
+	if regions != nil {
+		regionCond, regionArg = "sr.tdwg_code = ANY($1)", pq.Array(regions)
+	}
+
+	// Regional abundance filter, e.g. ?min_abundance=occasional (see abundance.go)
+	minAbundance, err := parseAbundanceClass(r.URL.Query().Get("min_abundance"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
 	if limit <= 0 || limit > 500 {
 		limit = 50
 	}
@@ -414,19 +587,26 @@ func handleSpecies(w http.ResponseWriter, r *http.Request) {
 
 	start := time.Now()
 
-	// Build query
+	// Build query; over several regions, one row per species, native first
+	distinct := ""
+	if regions != nil {
+		distinct = "DISTINCT ON (s.canonical_name, s.id)"
+	}
 	query := `
-		SELECT s.id, s.canonical_name, COALESCE(s.family, ''),
+		SELECT ` + distinct + ` s.id, s.canonical_name, COALESCE(s.family, ''),
 			   COALESCE(su.growth_form, ''), COALESCE(su.growth_form_source, ''),
-			   cn.common_name, sr.is_native
+			   cn.common_name, cn.language, sr.is_native, sr.establishment_means::text,
+			   ab.abundance::text
 		FROM species s
 		JOIN species_unified su ON s.id = su.species_id
 		JOIN species_regions sr ON s.id = sr.species_id
-		LEFT JOIN common_names cn ON s.id = cn.species_id AND cn.language = 'pt'
-		WHERE sr.tdwg_code = $1
+		LEFT JOIN species_region_abundance ab ON ab.species_id = s.id AND ab.tdwg_code = sr.tdwg_code
+		` + commonNameJoin("cn", "s.id", 2) + `
+		WHERE ` + regionCond + `
 	`
-	args := []interface{}{tdwgCode}
-	argNum := 2
+	lang := requestLanguage(r)
+	args := []interface{}{regionArg, pq.Array(commonNameLanguages(lang))}
+	argNum := 3
 
 	if growthForm != "" {
 		query += fmt.Sprintf(" AND su.growth_form = $%d", argNum)
@@ -438,36 +618,82 @@ func handleSpecies(w http.ResponseWriter, r *http.Request) {
 		query += " AND sr.is_native = TRUE"
 	}
 
+	if len(statuses) > 0 {
+		query += fmt.Sprintf(" AND sr.establishment_means::text = ANY($%d)", argNum)
+		args = append(args, pq.Array(statuses))
+		argNum++
+	}
+
+	if minAbundance != "" {
+		query += fmt.Sprintf(" AND ab.abundance >= $%d::abundance_class", argNum)
+		args = append(args, minAbundance)
+		argNum++
+	}
+
+	for _, clause := range brazilFloraClauses(flora, func(v interface{}) string {
+		args = append(args, v)
+		argNum++
+		return fmt.Sprintf("$%d", argNum-1)
+	}) {
+		query += " AND " + clause
+	}
+
 	// Count query - build separately for reliability
 	countQuery := `
 		SELECT COUNT(DISTINCT s.id)
 		FROM species s
 		JOIN species_unified su ON s.id = su.species_id
 		JOIN species_regions sr ON s.id = sr.species_id
-		WHERE sr.tdwg_code = $1
+		LEFT JOIN species_region_abundance ab ON ab.species_id = s.id AND ab.tdwg_code = sr.tdwg_code
+		WHERE ` + regionCond + `
 	`
-	countArgs := []interface{}{tdwgCode}
+	countArgs := []interface{}{regionArg}
 	countArgNum := 2
 
 	if growthForm != "" {
 		countQuery += fmt.Sprintf(" AND su.growth_form = $%d", countArgNum)
 		countArgs = append(countArgs, growthForm)
+		countArgNum++
 	}
 
 	if nativeOnly {
 		countQuery += " AND sr.is_native = TRUE"
 	}
 
+	if len(statuses) > 0 {
+		countQuery += fmt.Sprintf(" AND sr.establishment_means::text = ANY($%d)", countArgNum)
+		countArgs = append(countArgs, pq.Array(statuses))
+		countArgNum++
+	}
+
+	if minAbundance != "" {
+		countQuery += fmt.Sprintf(" AND ab.abundance >= $%d::abundance_class", countArgNum)
+		countArgs = append(countArgs, minAbundance)
+		countArgNum++
+	}
+
+	for _, clause := range brazilFloraClauses(flora, func(v interface{}) string {
+		countArgs = append(countArgs, v)
+		countArgNum++
+		return fmt.Sprintf("$%d", countArgNum-1)
+	}) {
+		countQuery += " AND " + clause
+	}
+
 	var total int64
-	if err := db.QueryRow(countQuery, countArgs...).Scan(&total); err != nil {
-		log.Printf("Count query error: %v", err)
+	if err := s.db.QueryRowContext(ctx, countQuery, countArgs...).Scan(&total); err != nil {
+		s.log.Printf("Count query error: %v", err)
 	}
 
 	// Add pagination
-	query += fmt.Sprintf(" ORDER BY s.canonical_name LIMIT $%d OFFSET $%d", argNum, argNum+1)
+	order := "s.canonical_name"
+	if regions != nil {
+		order = "s.canonical_name, s.id, sr.is_native DESC, sr.establishment_means"
+	}
+	query += fmt.Sprintf(" ORDER BY %s LIMIT $%d OFFSET $%d", order, argNum, argNum+1)
 	args = append(args, limit, offset)
 
-	rows, err := db.Query(query, args...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
 		return
@@ -479,13 +705,20 @@ func handleSpecies(w http.ResponseWriter, r *http.Request) {
 
 	for rows.Next() {
 		var sp SpeciesItem
-		rows.Scan(&sp.ID, &sp.CanonicalName, &sp.Family, &sp.GrowthForm, &sp.Source, &sp.CommonName, &sp.IsNative)
+		rows.Scan(&sp.ID, &sp.CanonicalName, &sp.Family, &sp.GrowthForm, &sp.Source, &sp.CommonName, &sp.CommonNameLanguage, &sp.IsNative, &sp.Establishment, &sp.Abundance)
 		if !seen[sp.ID] {
 			species = append(species, sp)
 			seen[sp.ID] = true
 		}
 	}
 
+	setContentLanguage(w, lang)
+	if wantsCSV(r) {
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		writeSpeciesCSV(w, r, tdwgCode, species)
+		return
+	}
+
 	resp := SpeciesResponse{
 		Species:   species,
 		Total:     total,
@@ -498,8 +731,9 @@ func handleSpecies(w http.ResponseWriter, r *http.Request) {
 }
 
 type QueryRequest struct {
-	SQL   string `json:"sql"`
-	Limit int    `json:"limit"`
+	SQL   string        `json:"sql"`
+	Limit int           `json:"limit"`
+	Args  []interface{} `json:"-"` // Placeholder values, for saved query parameters
 }
 
 type QueryResponse struct {
@@ -510,7 +744,7 @@ type QueryResponse struct {
 	Error     string          `json:"error,omitempty"`
 }
 
-func handleQuery(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
@@ -519,44 +753,65 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req QueryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
 
-	// Security: Only allow SELECT
-	sql := strings.TrimSpace(strings.ToUpper(req.SQL))
-	if !strings.HasPrefix(sql, "SELECT") && !strings.HasPrefix(sql, "EXPLAIN") {
-		http.Error(w, `{"error": "Only SELECT queries allowed"}`, http.StatusForbidden)
+	s.runQuery(w, r, req, s.newQueryAudit(r, "adhoc", nil))
+}
+
+// runQuery validates and executes an ad-hoc query, answering in the format
+// the request asks for (JSON, NDJSON or CSV). The outcome is recorded in
+// audit (see query_audit.go).
+func (s *Server) runQuery(w http.ResponseWriter, r *http.Request, req QueryRequest, audit *queryAudit) {
+	ctx := r.Context()
+
+	audit.SQL = req.SQL
+	defer audit.record()
+
+	// Security: a single read-only SELECT/EXPLAIN (see sqlvalidate.go)
+	validated, err := validateReadOnlyQuery(req.SQL)
+	if err != nil {
+		audit.reject(err)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusForbidden)
 		return
 	}
 
-	// Disallow dangerous keywords
-	forbidden := []string{"DROP", "DELETE", "UPDATE", "INSERT", "TRUNCATE", "ALTER", "CREATE", "GRANT", "REVOKE"}
-	for _, kw := range forbidden {
-		if strings.Contains(sql, kw) {
-			http.Error(w, fmt.Sprintf(`{"error": "%s not allowed"}`, kw), http.StatusForbidden)
-			return
-		}
-	}
+	// NDJSON and CSV stream rows instead of buffering them (see
+	// query_stream.go, csv_export.go)
+	asCSV := wantsCSV(r)
+	stream := asCSV || r.URL.Query().Get("format") == "ndjson"
 
 	limit := req.Limit
-	if limit <= 0 || limit > 1000 {
+	if stream {
+		if limit <= 0 || limit > maxStreamQueryRows {
+			limit = defaultStreamQueryRows
+		}
+	} else if limit <= 0 || limit > 1000 {
 		limit = 100
 	}
 
-	// Remove trailing semicolon and whitespace
-	req.SQL = strings.TrimSpace(req.SQL)
-	req.SQL = strings.TrimSuffix(req.SQL, ";")
-
-	// Add LIMIT if not present
-	if !strings.Contains(sql, "LIMIT") {
-		req.SQL = fmt.Sprintf("%s LIMIT %d", req.SQL, limit)
-	}
+	req.SQL = limitQuery(req.SQL, validated, limit)
 
 	start := time.Now()
 
-	rows, err := db.Query(req.SQL)
+	tx, err := s.beginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		audit.finish(ctx, -1, err)
+		json.NewEncoder(w).Encode(QueryResponse{Error: err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, req.SQL, req.Args...)
+	if err != nil {
+		audit.finish(ctx, -1, err)
+	}
+	if isTimeout(ctx, err) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(QueryResponse{Error: "query cancelled: statement timeout or client disconnected"})
+		return
+	}
 	if err != nil {
 		json.NewEncoder(w).Encode(QueryResponse{Error: err.Error()})
 		return
@@ -564,150 +819,51 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	columns, _ := rows.Columns()
+	if asCSV {
+		n, err := streamQueryCSV(w, r, rows, columns)
+		audit.finish(ctx, n, err)
+		return
+	}
+	if stream {
+		n, err := streamQueryNDJSON(w, r, rows, columns)
+		audit.finish(ctx, n, err)
+		return
+	}
+
 	resp := QueryResponse{
 		Columns: columns,
 		Rows:    [][]interface{}{},
 	}
 
+	types := queryColumnTypes(rows)
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		rows.Scan(valuePtrs...)
-
-		row := make([]interface{}, len(columns))
-		for i, v := range values {
-			switch val := v.(type) {
-			case []byte:
-				row[i] = string(val)
-			case nil:
-				row[i] = nil
-			default:
-				row[i] = val
-			}
-		}
+		row, _ := scanQueryRow(rows, types)
 		resp.Rows = append(resp.Rows, row)
 	}
+	if err := rows.Err(); err != nil {
+		audit.finish(ctx, len(resp.Rows), err)
+		if isTimeout(ctx, err) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+		json.NewEncoder(w).Encode(QueryResponse{Error: err.Error()})
+		return
+	}
 
 	resp.RowCount = len(resp.Rows)
+	audit.finish(ctx, resp.RowCount, nil)
 	resp.QueryTime = time.Since(start).String()
 
 	json.NewEncoder(w).Encode(resp)
 }
 
-func handleSources(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	type GrowthFormStats struct {
-		Source   string `json:"source"`
-		Total    int64  `json:"total"`
-		Trees    int64  `json:"trees"`
-		Shrubs   int64  `json:"shrubs"`
-		Herbs    int64  `json:"herbs"`
-		Climbers int64  `json:"climbers"`
-		Palms    int64  `json:"palms"`
-	}
-
-	type ThreatStats struct {
-		Source string `json:"source"`
-		Total  int64  `json:"total"`
-		CR     int64  `json:"cr"`
-		EN     int64  `json:"en"`
-		VU     int64  `json:"vu"`
-		NT     int64  `json:"nt"`
-		LC     int64  `json:"lc"`
-	}
-
-	type LifespanStats struct {
-		Source      string   `json:"source"`
-		Total       int64    `json:"total"`
-		AvgLifespan *float64 `json:"avg_lifespan"`
-		MinLifespan *float64 `json:"min_lifespan"`
-		MaxLifespan *float64 `json:"max_lifespan"`
-	}
-
-	type AllSourcesResponse struct {
-		GrowthForm []GrowthFormStats `json:"growth_form"`
-		Threat     []ThreatStats     `json:"threat_status"`
-		Lifespan   []LifespanStats   `json:"lifespan"`
-	}
-
-	resp := AllSourcesResponse{}
-
-	// Growth form sources
-	rows, err := db.Query(`
-		SELECT
-			growth_form_source as source,
-			COUNT(*) as total,
-			COUNT(*) FILTER (WHERE is_tree) as trees,
-			COUNT(*) FILTER (WHERE is_shrub) as shrubs,
-			COUNT(*) FILTER (WHERE is_herb) as herbs,
-			COUNT(*) FILTER (WHERE is_climber) as climbers,
-			COUNT(*) FILTER (WHERE is_palm) as palms
-		FROM species_unified
-		WHERE growth_form_source IS NOT NULL
-		GROUP BY growth_form_source
-		ORDER BY COUNT(*) DESC
-	`)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var s GrowthFormStats
-			rows.Scan(&s.Source, &s.Total, &s.Trees, &s.Shrubs, &s.Herbs, &s.Climbers, &s.Palms)
-			resp.GrowthForm = append(resp.GrowthForm, s)
-		}
-	}
-
-	// Threat status sources
-	rows2, err := db.Query(`
-		SELECT
-			threat_status_source as source,
-			COUNT(*) as total,
-			COUNT(*) FILTER (WHERE threat_status = 'CR') as cr,
-			COUNT(*) FILTER (WHERE threat_status = 'EN') as en,
-			COUNT(*) FILTER (WHERE threat_status = 'VU') as vu,
-			COUNT(*) FILTER (WHERE threat_status = 'NT') as nt,
-			COUNT(*) FILTER (WHERE threat_status = 'LC') as lc
-		FROM species_unified
-		WHERE threat_status_source IS NOT NULL
-		GROUP BY threat_status_source
-		ORDER BY COUNT(*) DESC
-	`)
-	if err == nil {
-		defer rows2.Close()
-		for rows2.Next() {
-			var s ThreatStats
-			rows2.Scan(&s.Source, &s.Total, &s.CR, &s.EN, &s.VU, &s.NT, &s.LC)
-			resp.Threat = append(resp.Threat, s)
-		}
+// limitQuery strips the trailing semicolon and adds a LIMIT if the query has
+// none (on its own line, in case the query ends in a comment)
+func limitQuery(query string, validated *validatedQuery, limit int) string {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if !validated.HasLimit {
+		query = fmt.Sprintf("%s\nLIMIT %d", query, limit)
 	}
-
-	// Lifespan sources
-	rows3, err := db.Query(`
-		SELECT
-			lifespan_source as source,
-			COUNT(*) as total,
-			ROUND(AVG(lifespan_years)::numeric, 1) as avg_lifespan,
-			ROUND(MIN(lifespan_years)::numeric, 1) as min_lifespan,
-			ROUND(MAX(lifespan_years)::numeric, 1) as max_lifespan
-		FROM species_unified
-		WHERE lifespan_source IS NOT NULL
-		GROUP BY lifespan_source
-		ORDER BY COUNT(*) DESC
-	`)
-	if err == nil {
-		defer rows3.Close()
-		for rows3.Next() {
-			var s LifespanStats
-			rows3.Scan(&s.Source, &s.Total, &s.AvgLifespan, &s.MinLifespan, &s.MaxLifespan)
-			resp.Lifespan = append(resp.Lifespan, s)
-		}
-	}
-
-	json.NewEncoder(w).Encode(resp)
+	return query
 }
 
 // Climate API Handlers
@@ -741,19 +897,78 @@ type ClimateData struct {
 	KoppenZone     *string  `json:"koppen_zone"`
 	WhittakerBiome *string  `json:"whittaker_biome"`
 	AridityIndex   *float64 `json:"aridity_index"`
+
+	// Display names in the request language (see i18n.go)
+	KoppenName         *string `json:"koppen_name,omitempty"`
+	WhittakerBiomeName *string `json:"whittaker_biome_name,omitempty"`
+
+	// Regions averaged near a border, with blend_km (see border_blend.go)
+	Blend []BorderRegion `json:"border_blend,omitempty"`
+}
+
+// ClimatePointResponse is the raster climate at a point; bio variables
+// not loaded into worldclim_raster are left out
+type ClimatePointResponse struct {
+	Lat            float64  `json:"lat"`
+	Lon            float64  `json:"lon"`
+	Source         string   `json:"source"`
+	Bio1           *float64 `json:"bio1,omitempty"`
+	Bio2           *float64 `json:"bio2,omitempty"`
+	Bio3           *float64 `json:"bio3,omitempty"`
+	Bio4           *float64 `json:"bio4,omitempty"`
+	Bio5           *float64 `json:"bio5,omitempty"`
+	Bio6           *float64 `json:"bio6,omitempty"`
+	Bio7           *float64 `json:"bio7,omitempty"`
+	Bio8           *float64 `json:"bio8,omitempty"`
+	Bio9           *float64 `json:"bio9,omitempty"`
+	Bio10          *float64 `json:"bio10,omitempty"`
+	Bio11          *float64 `json:"bio11,omitempty"`
+	Bio12          *float64 `json:"bio12,omitempty"`
+	Bio13          *float64 `json:"bio13,omitempty"`
+	Bio14          *float64 `json:"bio14,omitempty"`
+	Bio15          *float64 `json:"bio15,omitempty"`
+	Bio16          *float64 `json:"bio16,omitempty"`
+	Bio17          *float64 `json:"bio17,omitempty"`
+	Bio18          *float64 `json:"bio18,omitempty"`
+	Bio19          *float64 `json:"bio19,omitempty"`
+	AridityIndex   *float64 `json:"aridity_index,omitempty"`
+	WhittakerBiome *string  `json:"whittaker_biome,omitempty"`
+	KoppenZone     *string  `json:"koppen_zone,omitempty"`
+
+	// Display names in the request language (see i18n.go)
+	KoppenName         *string `json:"koppen_name,omitempty"`
+	WhittakerBiomeName *string `json:"whittaker_biome_name,omitempty"`
 }
 
-func handleClimate(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleClimate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
 	tdwgCode := r.URL.Query().Get("tdwg_code")
 	lat, _ := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
 	lon, _ := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	blendKm, err := parseBlendKm(r.URL.Query().Get("blend_km"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
 
 	var data ClimateData
+	var blend []BorderRegion
+	if blendKm > 0 && tdwgCode == "" && (lat != 0 || lon != 0) {
+		if blend, err = s.borderRegions(ctx, lat, lon, blendKm); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	if tdwgCode != "" {
-		err := db.QueryRow(`
+	if len(blend) > 1 {
+		if data, err = s.blendedClimate(ctx, blend); err != nil {
+			http.Error(w, `{"error": "No climate data for this location"}`, http.StatusNotFound)
+			return
+		}
+	} else if tdwgCode != "" {
+		err := s.db.QueryRowContext(ctx, `
 			SELECT c.tdwg_code, t.level3_name,
 				   c.bio1_mean, c.bio1_min, c.bio1_max,
 				   c.bio2_mean, c.bio3_mean, c.bio4_mean,
@@ -782,7 +997,7 @@ func handleClimate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else if lat != 0 || lon != 0 {
-		err := db.QueryRow(`
+		err := s.db.QueryRowContext(ctx, `
 			SELECT c.tdwg_code, t.level3_name,
 				   c.bio1_mean, c.bio1_min, c.bio1_max,
 				   c.bio2_mean, c.bio3_mean, c.bio4_mean,
@@ -816,6 +1031,14 @@ func handleClimate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lang := requestLanguage(r)
+	data.TDWGName = s.localize(ctx, nameKindTDWG, data.TDWGCode, lang, data.TDWGName)
+	for i := range data.Blend {
+		data.Blend[i].TDWGName = s.localize(ctx, nameKindTDWG, data.Blend[i].TDWGCode, lang, data.Blend[i].TDWGName)
+	}
+	data.KoppenName = s.localizeOptional(ctx, nameKindKoppenZone, data.KoppenZone, lang)
+	data.WhittakerBiomeName = s.localizeOptional(ctx, nameKindWhittaker, data.WhittakerBiome, lang)
+	setContentLanguage(w, lang)
 	json.NewEncoder(w).Encode(data)
 }
 
@@ -843,12 +1066,13 @@ type KoppenCount struct {
 	Count int64  `json:"count"`
 }
 
-func handleClimateStats(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleClimateStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
 	resp := ClimateStatsResponse{}
 
-	db.QueryRow(`
+	s.db.QueryRowContext(ctx, `
 		SELECT COUNT(*), COUNT(bio1_mean), COUNT(bio12_mean),
 			   ROUND(AVG(bio1_mean)::numeric, 1),
 			   ROUND(MIN(bio1_mean)::numeric, 1),
@@ -861,7 +1085,7 @@ func handleClimateStats(w http.ResponseWriter, r *http.Request) {
 		&resp.AvgPrecipitation,
 	)
 
-	rows, err := db.Query(`
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT whittaker_biome, COUNT(*),
 			   ROUND(AVG(bio1_mean)::numeric, 1),
 			   ROUND(AVG(bio12_mean)::numeric, 0)
@@ -879,7 +1103,7 @@ func handleClimateStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	rows2, err := db.Query(`
+	rows2, err := s.db.QueryContext(ctx, `
 		SELECT koppen_zone, COUNT(*)
 		FROM tdwg_climate
 		WHERE koppen_zone IS NOT NULL
@@ -913,9 +1137,13 @@ type SpeciesClimateResponse struct {
 	DominantBiome     *string  `json:"dominant_biome"`
 	DominantKoppen    *string  `json:"dominant_koppen"`
 	Biomes            []string `json:"biomes,omitempty"`
+
+	// Research-grade observations near ?lat=&lon= (see inaturalist.go)
+	INaturalist *INatObservations `json:"inaturalist,omitempty"`
 }
 
-func handleClimateSpecies(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleClimateSpecies(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
 	speciesName := r.URL.Query().Get("name")
@@ -925,6 +1153,15 @@ func handleClimateSpecies(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error": "Provide species name or id"}`, http.StatusBadRequest)
 		return
 	}
+	if err := s.resolveAOIQuery(r); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	lat, lon, radiusKm, nearby, err := parseINatLocation(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
 
 	var resp SpeciesClimateResponse
 	var query string
@@ -972,7 +1209,7 @@ func handleClimateSpecies(w http.ResponseWriter, r *http.Request) {
 		args = []interface{}{speciesName}
 	}
 
-	err := db.QueryRow(query, args...).Scan(
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(
 		&resp.SpeciesID, &resp.CanonicalName, &resp.Family, &resp.NRegions,
 		&resp.TempMeanAvg, &resp.TempAbsoluteMin, &resp.TempAbsoluteMax,
 		&resp.PrecipMeanAvg, &resp.PrecipAbsoluteMin, &resp.PrecipAbsoluteMax,
@@ -984,7 +1221,7 @@ func handleClimateSpecies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, _ := db.Query(`
+	rows, _ := s.db.QueryContext(ctx, `
 		SELECT DISTINCT c.whittaker_biome
 		FROM species s
 		JOIN species_distribution sd ON s.id = sd.species_id AND sd.native = TRUE
@@ -1001,11 +1238,19 @@ func handleClimateSpecies(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if nearby {
+		resp.INaturalist, err = s.nearbyINatObservations(ctx, resp.CanonicalName, lat, lon, radiusKm)
+		if err != nil {
+			s.log.Printf("Error fetching iNaturalist observations of %s: %v", resp.CanonicalName, err)
+		}
+	}
+
 	json.NewEncoder(w).Encode(resp)
 }
 
 // handleClimatePoint returns precise climate data from WorldClim rasters at exact coordinates
-func handleClimatePoint(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleClimatePoint(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	w.Header().Set("Content-Type", "application/json")
 
 	latStr := r.URL.Query().Get("lat")
@@ -1030,7 +1275,7 @@ func handleClimatePoint(w http.ResponseWriter, r *http.Request) {
 
 	// Check if raster data exists
 	var rasterCount int
-	err = db.QueryRow("SELECT COUNT(*) FROM worldclim_raster").Scan(&rasterCount)
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM worldclim_raster").Scan(&rasterCount)
 	if err != nil || rasterCount == 0 {
 		// Fall back to TDWG-based climate data
 		http.Error(w, `{"error": "Raster data not loaded. Use /api/climate with lat/lon for TDWG-based data."}`, http.StatusNotFound)
@@ -1039,25 +1284,44 @@ func handleClimatePoint(w http.ResponseWriter, r *http.Request) {
 
 	// Query climate data from raster using the SQL function
 	var climateJSON []byte
-	err = db.QueryRow("SELECT get_climate_json_at_point($1, $2)", lat, lon).Scan(&climateJSON)
+	err = s.db.QueryRowContext(ctx, "SELECT get_climate_json_at_point($1, $2)", lat, lon).Scan(&climateJSON)
 	if err != nil {
 		http.Error(w, `{"error": "No climate data at this location"}`, http.StatusNotFound)
 		return
 	}
 
 	// Parse the JSON and add metadata
-	var climateData map[string]any
-	json.Unmarshal(climateJSON, &climateData)
-
-	if len(climateData) == 0 {
+	climateData, ok := decodeClimatePoint(climateJSON)
+	if !ok {
 		http.Error(w, `{"error": "No climate data at this location (possibly ocean or missing coverage)"}`, http.StatusNotFound)
 		return
 	}
 
 	// Add request coordinates
-	climateData["lat"] = lat
-	climateData["lon"] = lon
-	climateData["source"] = "worldclim_raster"
-
+	climateData.Lat = lat
+	climateData.Lon = lon
+	climateData.Source = "worldclim_raster"
+
+	lang := requestLanguage(r)
+	if v := climateData.KoppenZone; v != nil {
+		name := s.localize(ctx, nameKindKoppenZone, *v, lang, *v)
+		climateData.KoppenName = &name
+	}
+	if v := climateData.WhittakerBiome; v != nil {
+		name := s.localize(ctx, nameKindWhittaker, *v, lang, *v)
+		climateData.WhittakerBiomeName = &name
+	}
+	setContentLanguage(w, lang)
 	json.NewEncoder(w).Encode(climateData)
 }
+
+// decodeClimatePoint reads the get_climate_json_at_point object, false when
+// the point has no data
+func decodeClimatePoint(data []byte) (ClimatePointResponse, bool) {
+	var fields map[string]json.RawMessage
+	var climate ClimatePointResponse
+	if json.Unmarshal(data, &fields) != nil || len(fields) == 0 || json.Unmarshal(data, &climate) != nil {
+		return climate, false
+	}
+	return climate, true
+}
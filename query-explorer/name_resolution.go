@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ============================================================================
+// NAME RESOLUTION
+// ============================================================================
+//
+// /api/names/resolve maps submitted names to accepted canonical names using
+// the local copy of the GBIF backbone (gbif_backbone, migration 046, synced
+// by scripts/load_gbif_backbone.py), then to the species id in this
+// database, if any. Names are normalized first: authorship, "cf."/"aff."
+// qualifiers and extra whitespace are dropped and the genus capitalized.
+//
+//	exact    the name is an accepted backbone name
+//	synonym  the name is a backbone synonym; accepted_name is what it means
+//	fuzzy    no exact match; the closest backbone name by trigram
+//	         similarity (at least 0.5), followed to its accepted name
+//	none     nothing close enough
+//
+// confidence is 1 for an exact accepted match and lower for synonyms,
+// doubtful names, misspellings and names with several accepted meanings
+// (homonyms, pro parte synonyms), which also list the alternatives. Names
+// missing from the backbone fall back to an exact match in species, without
+// accepted_taxon_key.
+//
+//	GET  /api/names/resolve?name=Tabebuia%20serratifolia
+//	POST /api/names/resolve {"names": ["Tabebuia serratifolia", ...]}
+
+const (
+	maxResolveNames   = 100
+	minNameSimilarity = 0.5
+	maxNameCandidates = 5
+)
+
+// infraspecificMarkers keep the name going past the epithet
+var infraspecificMarkers = map[string]string{
+	"subsp.": "subsp.", "subsp": "subsp.", "ssp.": "subsp.", "ssp": "subsp.",
+	"var.": "var.", "var": "var.",
+	"f.": "f.", "forma": "f.",
+}
+
+// nameQualifiers are identification qualifiers dropped before matching
+var nameQualifiers = map[string]bool{"cf.": true, "cf": true, "aff.": true, "aff": true, "sp.": true, "spp.": true}
+
+type NameCandidate struct {
+	TaxonKey         int64   `json:"taxon_key"`
+	MatchedName      string  `json:"matched_name"`
+	Status           string  `json:"status"` // Backbone taxonomic status of the matched name
+	AcceptedTaxonKey int64   `json:"accepted_taxon_key"`
+	AcceptedName     string  `json:"accepted_name"`
+	Family           *string `json:"family"`
+	SpeciesID        *int64  `json:"species_id"` // In this database
+	Similarity       float64 `json:"similarity"`
+	exact            bool
+}
+
+type NameResolution struct {
+	Input            string          `json:"input"`
+	Normalized       string          `json:"normalized"`
+	MatchType        string          `json:"match_type"` // exact, synonym, fuzzy, none
+	Confidence       float64         `json:"confidence"`
+	MatchedName      *string         `json:"matched_name"`
+	AcceptedName     *string         `json:"accepted_name"`
+	AcceptedTaxonKey *int64          `json:"accepted_taxon_key"`
+	Family           *string         `json:"family"`
+	SpeciesID        *int64          `json:"species_id"`
+	Alternatives     []NameCandidate `json:"alternatives,omitempty"` // Other accepted meanings
+}
+
+// normalizeSubmittedName reduces a submitted name to genus, epithet and an
+// optional infraspecific rank and epithet
+func normalizeSubmittedName(name string) string {
+	var words []string
+	for _, w := range strings.Fields(name) {
+		if !nameQualifiers[strings.ToLower(w)] {
+			words = append(words, w)
+		}
+	}
+	if len(words) == 0 {
+		return ""
+	}
+
+	genus := []rune(strings.ToLower(strings.Trim(words[0], "×")))
+	if len(genus) == 0 {
+		return ""
+	}
+	genus[0] = unicode.ToUpper(genus[0])
+	out := []string{string(genus)}
+
+	// Authorship starts with a capital letter or a parenthesis
+	isEpithet := func(w string) bool {
+		r := []rune(w)[0]
+		return unicode.IsLower(r) && !strings.HasSuffix(w, ".")
+	}
+	rest := words[1:]
+	if len(rest) > 0 && isEpithet(rest[0]) {
+		out = append(out, rest[0])
+		rest = rest[1:]
+		for i := 0; i+1 < len(rest); i++ {
+			if marker, ok := infraspecificMarkers[strings.ToLower(rest[i])]; ok && isEpithet(rest[i+1]) {
+				out = append(out, marker, rest[i+1])
+				break
+			}
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// resolveCandidates picks the match among the candidates, best first:
+// exact before fuzzy, then by similarity
+func resolveCandidates(res *NameResolution, candidates []NameCandidate) {
+	res.MatchType = "none"
+	if len(candidates) == 0 || (!candidates[0].exact && candidates[0].Similarity < minNameSimilarity) {
+		return
+	}
+	best := candidates[0]
+	res.MatchedName, res.AcceptedName = &best.MatchedName, &best.AcceptedName
+	res.AcceptedTaxonKey, res.Family, res.SpeciesID = &best.AcceptedTaxonKey, best.Family, best.SpeciesID
+
+	switch {
+	case !best.exact:
+		res.MatchType, res.Confidence = "fuzzy", 0.9*best.Similarity
+	case best.Status == "accepted":
+		res.MatchType, res.Confidence = "exact", 1
+	case best.Status == "doubtful":
+		res.MatchType, res.Confidence = "exact", 0.8
+	default:
+		res.MatchType, res.Confidence = "synonym", 0.95
+	}
+	if !best.exact && best.Status != "accepted" {
+		res.Confidence *= 0.95
+	}
+
+	// The same name (or an equally close one) meaning other accepted taxa
+	for _, c := range candidates[1:] {
+		if c.exact == best.exact && c.Similarity == best.Similarity && c.AcceptedTaxonKey != best.AcceptedTaxonKey {
+			res.Alternatives = append(res.Alternatives, c)
+		}
+	}
+	if len(res.Alternatives) > 0 {
+		res.Confidence /= float64(len(res.Alternatives) + 1)
+	}
+	res.Confidence = math.Round(res.Confidence*1000) / 1000
+}
+
+// resolveName resolves one submitted name
+func (s *Server) resolveName(ctx context.Context, input string) (NameResolution, error) {
+	res := NameResolution{Input: input, Normalized: normalizeSubmittedName(input), MatchType: "none"}
+	if res.Normalized == "" {
+		return res, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH candidates AS (
+			SELECT b.*, TRUE AS exact, 1.0::real AS sim
+			FROM gbif_backbone b
+			WHERE LOWER(b.canonical_name) = LOWER($1)
+			UNION ALL
+			SELECT b.*, FALSE, similarity(b.canonical_name, $1)
+			FROM gbif_backbone b
+			WHERE b.canonical_name % $1 AND LOWER(b.canonical_name) <> LOWER($1)
+			  AND NOT EXISTS (SELECT 1 FROM gbif_backbone x WHERE LOWER(x.canonical_name) = LOWER($1))
+		)
+		SELECT c.taxon_key, c.canonical_name, c.taxonomic_status,
+		       COALESCE(a.taxon_key, c.taxon_key), COALESCE(a.canonical_name, c.canonical_name),
+		       COALESCE(a.family, c.family), sp.id, c.exact, c.sim
+		FROM candidates c
+		LEFT JOIN gbif_backbone a ON a.taxon_key = c.accepted_key
+		LEFT JOIN LATERAL (
+			SELECT s.id FROM species s
+			WHERE s.gbif_taxon_key = COALESCE(a.taxon_key, c.taxon_key)
+			   OR LOWER(s.canonical_name) = LOWER(COALESCE(a.canonical_name, c.canonical_name))
+			ORDER BY s.gbif_taxon_key = COALESCE(a.taxon_key, c.taxon_key) DESC NULLS LAST
+			LIMIT 1
+		) sp ON TRUE
+		ORDER BY c.exact DESC, c.sim DESC, c.taxonomic_status = 'accepted' DESC, sp.id IS NOT NULL DESC, c.taxon_key
+		LIMIT $2
+	`, res.Normalized, maxNameCandidates)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	var candidates []NameCandidate
+	for rows.Next() {
+		var c NameCandidate
+		var sim float64
+		if err := rows.Scan(&c.TaxonKey, &c.MatchedName, &c.Status, &c.AcceptedTaxonKey, &c.AcceptedName,
+			&c.Family, &c.SpeciesID, &c.exact, &sim); err != nil {
+			return res, err
+		}
+		c.Similarity = math.Round(sim*1000) / 1000
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	resolveCandidates(&res, candidates)
+	if res.MatchType != "none" {
+		return res, nil
+	}
+
+	// Names the backbone lacks (or before the first sync) may still be in
+	// the species table
+	var c NameCandidate
+	err = s.db.QueryRowContext(ctx, `
+		SELECT id, canonical_name, family FROM species
+		WHERE LOWER(canonical_name) = LOWER($1)
+		ORDER BY taxonomic_status = 'accepted' DESC NULLS LAST, id
+		LIMIT 1
+	`, res.Normalized).Scan(&c.SpeciesID, &c.AcceptedName, &c.Family)
+	if err == sql.ErrNoRows {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	c.MatchedName, c.Status, c.exact = c.AcceptedName, "accepted", true
+	resolveCandidates(&res, []NameCandidate{c})
+	res.AcceptedTaxonKey = nil
+	return res, nil
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleNamesResolve handles GET and POST /api/names/resolve
+func (s *Server) handleNamesResolve(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	var names []string
+	switch r.Method {
+	case http.MethodGet:
+		if name := r.URL.Query().Get("name"); name != "" {
+			names = []string{name}
+		}
+	case http.MethodPost:
+		var req struct {
+			Names []string `json:"names"`
+		}
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		names = req.Names
+	default:
+		http.Error(w, `{"error": "GET or POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if len(names) == 0 {
+		http.Error(w, `{"error": "Provide name or names"}`, http.StatusBadRequest)
+		return
+	}
+	if len(names) > maxResolveNames {
+		http.Error(w, fmt.Sprintf(`{"error": "At most %d names per request"}`, maxResolveNames), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	results := make([]NameResolution, 0, len(names))
+	for _, name := range names {
+		res, err := s.resolveName(ctx, name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, res)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":    results,
+		"query_time": time.Since(start).String(),
+	})
+}
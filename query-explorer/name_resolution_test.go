@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSubmittedName(t *testing.T) {
+	tests := map[string]string{
+		"Handroanthus serratifolius":                  "Handroanthus serratifolius",
+		"  handroanthus   serratifolius  ":            "Handroanthus serratifolius",
+		"Tabebuia serratifolia (Vahl) G.Nicholson":    "Tabebuia serratifolia",
+		"Cedrela cf. fissilis":                        "Cedrela fissilis",
+		"Inga vera subsp. affinis (DC.) T.D.Penn.":    "Inga vera subsp. affinis",
+		"Inga vera ssp affinis":                       "Inga vera subsp. affinis",
+		"Eugenia uniflora L.":                         "Eugenia uniflora",
+		"Eugenia":                                     "Eugenia",
+		"Eugenia sp.":                                 "Eugenia",
+		"Astronium fraxinifolium Schott var. glabrum": "Astronium fraxinifolium var. glabrum",
+		"PSIDIUM guajava":                             "Psidium guajava",
+		"":                                            "",
+		"cf.":                                         "",
+	}
+	for in, want := range tests {
+		if got := normalizeSubmittedName(in); got != want {
+			t.Errorf("normalizeSubmittedName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestResolveCandidates(t *testing.T) {
+	accepted := NameCandidate{TaxonKey: 1, MatchedName: "Handroanthus serratifolius", Status: "accepted",
+		AcceptedTaxonKey: 1, AcceptedName: "Handroanthus serratifolius", Similarity: 1, exact: true}
+	synonym := NameCandidate{TaxonKey: 2, MatchedName: "Tabebuia serratifolia", Status: "heterotypic synonym",
+		AcceptedTaxonKey: 1, AcceptedName: "Handroanthus serratifolius", Similarity: 1, exact: true}
+	misspelt := accepted
+	misspelt.exact, misspelt.Similarity = false, 0.8
+
+	tests := []struct {
+		name       string
+		candidates []NameCandidate
+		matchType  string
+		confidence float64
+		nAlt       int
+	}{
+		{"no candidates", nil, "none", 0, 0},
+		{"exact accepted", []NameCandidate{accepted}, "exact", 1, 0},
+		{"synonym", []NameCandidate{synonym}, "synonym", 0.95, 0},
+		{"fuzzy", []NameCandidate{misspelt}, "fuzzy", 0.72, 0},
+		{"too far", []NameCandidate{{Similarity: 0.4, Status: "accepted"}}, "none", 0, 0},
+		{"pro parte synonym", []NameCandidate{synonym, {TaxonKey: 3, Status: "proparte synonym",
+			AcceptedTaxonKey: 9, AcceptedName: "Other name", Similarity: 1, exact: true}}, "synonym", 0.475, 1},
+	}
+	for _, tc := range tests {
+		res := NameResolution{}
+		resolveCandidates(&res, tc.candidates)
+		if res.MatchType != tc.matchType || res.Confidence != tc.confidence || len(res.Alternatives) != tc.nAlt {
+			t.Errorf("%s: got %s %.3f with %d alternatives, want %s %.3f with %d",
+				tc.name, res.MatchType, res.Confidence, len(res.Alternatives), tc.matchType, tc.confidence, tc.nAlt)
+		}
+		if tc.matchType != "none" && (res.AcceptedName == nil || *res.AcceptedName != tc.candidates[0].AcceptedName) {
+			t.Errorf("%s: accepted name %v", tc.name, res.AcceptedName)
+		}
+	}
+}
+
+func TestNamesResolveValidation(t *testing.T) {
+	s := newTestServer()
+	many := fmt.Sprintf(`{"names": [%s"x"]}`, strings.Repeat(`"x", `, maxResolveNames))
+	tests := []struct {
+		method, path, body string
+		code               int
+	}{
+		{"DELETE", "/api/names/resolve?name=x", "", http.StatusMethodNotAllowed},
+		{"GET", "/api/names/resolve", "", http.StatusBadRequest},
+		{"POST", "/api/names/resolve", `{"names": []}`, http.StatusBadRequest},
+		{"POST", "/api/names/resolve", many, http.StatusBadRequest},
+		{"POST", "/api/names/resolve", `{"names": "x"}`, http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		s.handleNamesResolve(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != tc.code {
+			t.Errorf("%s %s %.20s: %d, want %d", tc.method, tc.path, tc.body, w.Code, tc.code)
+		}
+	}
+}
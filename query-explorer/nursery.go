@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// NURSERY PARTNER ORDERS
+// ============================================================================
+//
+// A finished plan can be sent to a partner nursery's ordering API with
+// POST /api/plans/{id}/order. The order lists the plan's seedlings under the
+// nursery's catalog codes (nursery_catalog, migration 034, maintained with
+// PUT /api/admin/nursery-catalog) and is POSTed as JSON to
+// NURSERY_WEBHOOK_URL. With NURSERY_WEBHOOK_SECRET the body is signed
+// (X-Signature: sha256=<HMAC-SHA256 hex>), and Idempotency-Key identifies
+// the plan so a retried push does not order twice. The nursery answers with
+// {"order_id": "..."}, which is stored on the plan; a plan is ordered once.
+// The plan is claimed (nursery_order_ref = 'pending') before the push, so
+// two concurrent orders cannot both reach the nursery, and released again
+// if the push fails.
+//
+// Species the catalog does not map, or without a seedling quantity, reject
+// the order unless ?partial=true, which leaves them out.
+//
+// PUT /api/admin/nursery-catalog?dry_run=true writes nothing and returns a
+// validation report instead: how many codes would be new, changed or
+// removed, the rows the database would reject, and codes that are legal but
+// likely mistakes, such as one code on two species.
+
+const (
+	defaultNurseryPartner = "nursery"
+	defaultNurseryTimeout = 15 * time.Second
+	maxCatalogItems       = 5000
+	maxCatalogCodeLength  = 100       // nursery_catalog.catalog_code
+	nurseryOrderPending   = "pending" // nursery_order_ref while the push is in flight
+)
+
+type nurseryConfig struct {
+	URL     string
+	Secret  string
+	Partner string
+	Timeout time.Duration
+}
+
+// loadNurseryConfig reads NURSERY_WEBHOOK_URL, NURSERY_WEBHOOK_SECRET,
+// NURSERY_PARTNER and NURSERY_TIMEOUT; no URL disables the integration
+func loadNurseryConfig() nurseryConfig {
+	c := nurseryConfig{
+		URL:     getEnv("NURSERY_WEBHOOK_URL", ""),
+		Secret:  getEnv("NURSERY_WEBHOOK_SECRET", ""),
+		Partner: getEnv("NURSERY_PARTNER", defaultNurseryPartner),
+		Timeout: getEnvDuration("NURSERY_TIMEOUT", defaultNurseryTimeout),
+	}
+	if c.URL != "" && !strings.HasPrefix(c.URL, "https://") && !strings.HasPrefix(c.URL, "http://") {
+		log.Printf("Invalid NURSERY_WEBHOOK_URL=%q, nursery orders disabled", c.URL)
+		c.URL = ""
+	}
+	return c
+}
+
+// NurseryOrder is the order reference recorded on a plan
+type NurseryOrder struct {
+	Partner   string `json:"partner"`
+	Reference string `json:"reference"`
+	OrderedAt string `json:"ordered_at"`
+}
+
+type NurseryOrderItem struct {
+	CatalogCode   string `json:"catalog_code"`
+	SpeciesID     int64  `json:"species_id"`
+	CanonicalName string `json:"canonical_name"`
+	Quantity      int    `json:"quantity"`
+}
+
+// NurseryOrderPayload is the body sent to the nursery
+type NurseryOrderPayload struct {
+	PlanID       int64              `json:"plan_id"`
+	PlanName     string             `json:"plan_name"`
+	TDWGCode     string             `json:"tdwg_code"`
+	StateCode    *string            `json:"state_code,omitempty"`
+	Municipality *string            `json:"municipality,omitempty"`
+	Items        []NurseryOrderItem `json:"items"`
+	TotalQty     int                `json:"total_quantity"`
+}
+
+// NurseryOrderSkip is a plan species left out of the order
+type NurseryOrderSkip struct {
+	SpeciesID     int64  `json:"species_id"`
+	CanonicalName string `json:"canonical_name"`
+	Reason        string `json:"reason"` // not_in_catalog, no_quantity
+}
+
+// buildNurseryOrder maps the plan's species to catalog codes
+func buildNurseryOrder(p *Plan, catalog map[int64]string) (NurseryOrderPayload, []NurseryOrderSkip) {
+	order := NurseryOrderPayload{
+		PlanID: p.ID, PlanName: p.Name, TDWGCode: p.TDWGCode,
+		StateCode: p.StateCode, Municipality: p.Municipality,
+		Items: []NurseryOrderItem{},
+	}
+	skipped := []NurseryOrderSkip{}
+	for _, sp := range p.Species {
+		code, ok := catalog[sp.SpeciesID]
+		switch {
+		case !ok:
+			skipped = append(skipped, NurseryOrderSkip{sp.SpeciesID, sp.CanonicalName, "not_in_catalog"})
+		case sp.Quantity == nil || *sp.Quantity == 0:
+			skipped = append(skipped, NurseryOrderSkip{sp.SpeciesID, sp.CanonicalName, "no_quantity"})
+		default:
+			order.Items = append(order.Items, NurseryOrderItem{code, sp.SpeciesID, sp.CanonicalName, *sp.Quantity})
+			order.TotalQty += *sp.Quantity
+		}
+	}
+	return order, skipped
+}
+
+// signNurseryPayload returns the X-Signature value of body
+func signNurseryPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadNurseryCatalog returns the catalog codes of species for partner
+func (s *Server) loadNurseryCatalog(ctx context.Context, partner string, species []PlanSpecies) (map[int64]string, error) {
+	ids := make([]int64, len(species))
+	for i, sp := range species {
+		ids[i] = sp.SpeciesID
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT species_id, catalog_code FROM nursery_catalog
+		WHERE partner = $1 AND species_id = ANY($2)
+	`, partner, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	catalog := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var code string
+		if err := rows.Scan(&id, &code); err != nil {
+			return nil, err
+		}
+		catalog[id] = code
+	}
+	return catalog, rows.Err()
+}
+
+// sendNurseryOrder POSTs the order and returns the nursery's reference
+func sendNurseryOrder(ctx context.Context, cfg nurseryConfig, order NurseryOrderPayload) (string, error) {
+	body, _ := json.Marshal(order)
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "diversiplant-plan-"+strconv.FormatInt(order.PlanID, 10))
+	if cfg.Secret != "" {
+		req.Header.Set("X-Signature", signNurseryPayload(cfg.Secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("nursery returned %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	var out struct {
+		OrderID string `json:"order_id"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil || out.OrderID == "" {
+		return "", fmt.Errorf("nursery response has no order_id")
+	}
+	return out.OrderID, nil
+}
+
+// orderPlan handles POST /api/plans/{id}/order
+func (s *Server) orderPlan(w http.ResponseWriter, r *http.Request, key *APIKey, p *Plan) {
+	ctx := r.Context()
+
+	if s.cfg.Nursery.URL == "" {
+		http.Error(w, `{"error": "Nursery integration not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+	if p.NurseryOrder != nil && p.NurseryOrder.Reference == nurseryOrderPending {
+		http.Error(w, `{"error": "An order of this plan is already being sent"}`, http.StatusConflict)
+		return
+	}
+	if p.NurseryOrder != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "plan already ordered: "+p.NurseryOrder.Reference), http.StatusConflict)
+		return
+	}
+
+	catalog, err := s.loadNurseryCatalog(ctx, s.cfg.Nursery.Partner, p.Species)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	order, skipped := buildNurseryOrder(p, catalog)
+	if len(skipped) > 0 && r.URL.Query().Get("partial") != "true" || len(order.Items) == 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "plan species missing from the order (use ?partial=true to leave them out)",
+			"skipped": skipped,
+		})
+		return
+	}
+
+	// Claim the plan; a concurrent order that got here first owns the push
+	var claimed int64
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE restoration_plans
+		SET nursery_partner = $3, nursery_order_ref = $4
+		WHERE id = $1 AND owner_key_id = $2 AND nursery_order_ref IS NULL
+		RETURNING id
+	`, p.ID, key.ID, s.cfg.Nursery.Partner, nurseryOrderPending).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Plan already ordered or being ordered"}`, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	ref, err := sendNurseryOrder(ctx, s.cfg.Nursery, order)
+	if err != nil {
+		s.log.Printf("Error sending plan %d to %s: %v", p.ID, s.cfg.Nursery.Partner, err)
+		// Release the claim even if the client has gone away
+		if _, rerr := s.db.ExecContext(context.WithoutCancel(ctx), `
+			UPDATE restoration_plans SET nursery_partner = NULL, nursery_order_ref = NULL
+			WHERE id = $1 AND nursery_order_ref = $2
+		`, p.ID, nurseryOrderPending); rerr != nil {
+			s.log.Printf("Error releasing the order claim of plan %d: %v", p.ID, rerr)
+		}
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadGateway)
+		return
+	}
+
+	var orderedAt time.Time
+	err = s.db.QueryRowContext(context.WithoutCancel(ctx), `
+		UPDATE restoration_plans
+		SET nursery_order_ref = $3, nursery_ordered_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND nursery_order_ref = $2
+		RETURNING nursery_ordered_at
+	`, p.ID, nurseryOrderPending, ref).Scan(&orderedAt)
+	if err != nil {
+		// The nursery has the order; keep the reference in the log for support
+		s.log.Printf("Error recording order %s of plan %d: %v", ref, p.ID, err)
+		http.Error(w, `{"error": "Order sent but could not be recorded"}`, http.StatusInternalServerError)
+		return
+	}
+
+	p.NurseryOrder = &NurseryOrder{Partner: s.cfg.Nursery.Partner, Reference: ref, OrderedAt: orderedAt.Format(time.RFC3339)}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"nursery_order": p.NurseryOrder,
+		"items":         order.Items,
+		"skipped":       skipped,
+	})
+}
+
+type nurseryCatalogItem struct {
+	SpeciesID   int64  `json:"species_id"`
+	CatalogCode string `json:"catalog_code"`
+}
+
+type CatalogImportIssue struct {
+	SpeciesID   int64  `json:"species_id"`
+	CatalogCode string `json:"catalog_code,omitempty"`
+	Issue       string `json:"issue"`
+}
+
+// CatalogImportReport is the dry-run report of a catalog PUT
+type CatalogImportReport struct {
+	DryRun               bool                 `json:"dry_run"`
+	Partner              string               `json:"partner"`
+	RowsParsed           int                  `json:"rows_parsed"`
+	New                  int                  `json:"new"`
+	Updated              int                  `json:"updated"`
+	Unchanged            int                  `json:"unchanged"`
+	Removed              int                  `json:"removed"`
+	ConstraintViolations []CatalogImportIssue `json:"constraint_violations"` // Would fail the PUT
+	SuspiciousValues     []CatalogImportIssue `json:"suspicious_values"`
+}
+
+// previewCatalogImport reports what a PUT of items would do to a catalog
+// holding existing codes, given which species exist
+func previewCatalogImport(partner string, items []nurseryCatalogItem, existing map[int64]string, species map[int64]bool) CatalogImportReport {
+	rep := CatalogImportReport{
+		DryRun:               true,
+		Partner:              partner,
+		RowsParsed:           len(items),
+		ConstraintViolations: []CatalogImportIssue{},
+		SuspiciousValues:     []CatalogImportIssue{},
+	}
+	violation := func(it nurseryCatalogItem, issue string) {
+		rep.ConstraintViolations = append(rep.ConstraintViolations, CatalogImportIssue{it.SpeciesID, it.CatalogCode, issue})
+	}
+	suspicious := func(it nurseryCatalogItem, issue string) {
+		rep.SuspiciousValues = append(rep.SuspiciousValues, CatalogImportIssue{it.SpeciesID, it.CatalogCode, issue})
+	}
+
+	// The catalog as it would be after the PUT, to find shared codes
+	after := make(map[int64]string, len(existing))
+	for id, code := range existing {
+		after[id] = code
+	}
+	seen := map[int64]bool{}
+	for _, it := range items {
+		code := strings.TrimSpace(it.CatalogCode)
+		if seen[it.SpeciesID] {
+			suspicious(it, "duplicate_species") // The last one wins
+		}
+		seen[it.SpeciesID] = true
+
+		if code == "" {
+			if _, ok := existing[it.SpeciesID]; ok {
+				rep.Removed++
+			} else {
+				suspicious(it, "remove_unmapped_species")
+			}
+			delete(after, it.SpeciesID)
+			continue
+		}
+		if !species[it.SpeciesID] {
+			violation(it, "unknown_species")
+			continue
+		}
+		if len(code) > maxCatalogCodeLength {
+			violation(it, "catalog_code_too_long")
+			continue
+		}
+		if code != it.CatalogCode {
+			suspicious(it, "catalog_code_whitespace")
+		}
+		switch old, ok := existing[it.SpeciesID]; {
+		case !ok:
+			rep.New++
+		case old == code:
+			rep.Unchanged++
+		default:
+			rep.Updated++
+		}
+		after[it.SpeciesID] = code
+	}
+
+	bySpecies := map[string][]int64{}
+	for id, code := range after {
+		bySpecies[code] = append(bySpecies[code], id)
+	}
+	for _, it := range items {
+		code := strings.TrimSpace(it.CatalogCode)
+		if code != "" && len(bySpecies[code]) > 1 && after[it.SpeciesID] == code {
+			suspicious(it, "shared_catalog_code")
+		}
+	}
+	return rep
+}
+
+// previewCatalogPut handles PUT /api/admin/nursery-catalog?dry_run=true
+func (s *Server) previewCatalogPut(ctx context.Context, w http.ResponseWriter, items []nurseryCatalogItem) {
+	ids := make([]int64, len(items))
+	for i, it := range items {
+		ids[i] = it.SpeciesID
+	}
+
+	species := map[int64]bool{}
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM species WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			species[id] = true
+		}
+	}
+	rows.Close()
+
+	// The whole catalog, so codes shared with unlisted species are found
+	existing := map[int64]string{}
+	rows, err = s.db.QueryContext(ctx, `SELECT species_id, catalog_code FROM nursery_catalog WHERE partner = $1`, s.cfg.Nursery.Partner)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var id int64
+		var code string
+		if err := rows.Scan(&id, &code); err == nil {
+			existing[id] = code
+		}
+	}
+	rows.Close()
+
+	json.NewEncoder(w).Encode(previewCatalogImport(s.cfg.Nursery.Partner, items, existing, species))
+}
+
+// handleNurseryCatalog handles GET/PUT /api/admin/nursery-catalog. PUT
+// upserts {"items": [{species_id, catalog_code}]}; an empty catalog_code
+// removes the species. With ?dry_run=true it only reports.
+func (s *Server) handleNurseryCatalog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT species_id, catalog_code FROM nursery_catalog
+			WHERE partner = $1 ORDER BY species_id
+		`, s.cfg.Nursery.Partner)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		items := []nurseryCatalogItem{}
+		for rows.Next() {
+			var it nurseryCatalogItem
+			if err := rows.Scan(&it.SpeciesID, &it.CatalogCode); err != nil {
+				s.log.Printf("Error scanning nursery catalog row: %v", err)
+				continue
+			}
+			items = append(items, it)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"partner": s.cfg.Nursery.Partner, "items": items})
+
+	case http.MethodPut:
+		var body struct {
+			Items []nurseryCatalogItem `json:"items"`
+		}
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+		if len(body.Items) == 0 || len(body.Items) > maxCatalogItems {
+			http.Error(w, fmt.Sprintf(`{"error": "items must list 1 to %d species"}`, maxCatalogItems), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("dry_run") == "true" {
+			s.previewCatalogPut(ctx, w, body.Items)
+			return
+		}
+		sort.Slice(body.Items, func(i, j int) bool { return body.Items[i].SpeciesID < body.Items[j].SpeciesID })
+
+		tx, err := s.beginTx(ctx, nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		updated, removed := 0, 0
+		for _, it := range body.Items {
+			code := strings.TrimSpace(it.CatalogCode)
+			if code == "" {
+				if _, err := tx.ExecContext(ctx, `DELETE FROM nursery_catalog WHERE partner = $1 AND species_id = $2`,
+					s.cfg.Nursery.Partner, it.SpeciesID); err != nil {
+					http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+					return
+				}
+				removed++
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO nursery_catalog (partner, species_id, catalog_code)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (partner, species_id) DO UPDATE
+				SET catalog_code = EXCLUDED.catalog_code, updated_at = CURRENT_TIMESTAMP
+			`, s.cfg.Nursery.Partner, it.SpeciesID, code); err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": %q}`, fmt.Sprintf("species %d: %s", it.SpeciesID, err.Error())), http.StatusBadRequest)
+				return
+			}
+			updated++
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"partner": s.cfg.Nursery.Partner, "updated": updated, "removed": removed})
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
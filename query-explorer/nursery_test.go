@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildNurseryOrder(t *testing.T) {
+	q := func(n int) *int { return &n }
+	p := &Plan{ID: 7, Name: "APP Córrego", TDWGCode: "BZS", Species: []PlanSpecies{
+		{SpeciesID: 1, CanonicalName: "Inga edulis", Quantity: q(300)},
+		{SpeciesID: 2, CanonicalName: "Cecropia pachystachya", Quantity: q(200)},
+		{SpeciesID: 3, CanonicalName: "Euterpe edulis"},
+	}}
+	order, skipped := buildNurseryOrder(p, map[int64]string{1: "ING-01", 3: "EUT-05"})
+
+	if len(order.Items) != 1 || order.Items[0].CatalogCode != "ING-01" || order.TotalQty != 300 {
+		t.Errorf("items = %+v, total %d", order.Items, order.TotalQty)
+	}
+	if len(skipped) != 2 || skipped[0].Reason != "not_in_catalog" || skipped[1].Reason != "no_quantity" {
+		t.Errorf("skipped = %+v", skipped)
+	}
+}
+
+func TestSendNurseryOrder(t *testing.T) {
+	order := NurseryOrderPayload{PlanID: 7, Items: []NurseryOrderItem{{CatalogCode: "ING-01", Quantity: 300}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-Signature"); got != signNurseryPayload("s3cret", body) {
+			t.Errorf("X-Signature = %q", got)
+		}
+		if got := r.Header.Get("Idempotency-Key"); got != "diversiplant-plan-7" {
+			t.Errorf("Idempotency-Key = %q", got)
+		}
+		var got NurseryOrderPayload
+		if err := json.Unmarshal(body, &got); err != nil || got.Items[0].CatalogCode != "ING-01" {
+			t.Errorf("payload = %s", body)
+		}
+		w.Write([]byte(`{"order_id": "PO-123"}`))
+	}))
+	defer srv.Close()
+
+	cfg := nurseryConfig{URL: srv.URL, Secret: "s3cret", Partner: "test", Timeout: defaultNurseryTimeout}
+	ref, err := sendNurseryOrder(context.Background(), cfg, order)
+	if err != nil || ref != "PO-123" {
+		t.Errorf("sendNurseryOrder = %q, %v", ref, err)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown product", http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	cfg.URL = failing.URL
+	if _, err := sendNurseryOrder(context.Background(), cfg, order); err == nil {
+		t.Error("error response accepted")
+	}
+}
+
+func TestPreviewCatalogImport(t *testing.T) {
+	existing := map[int64]string{1: "A-1", 2: "B-2", 3: "C-3"}
+	species := map[int64]bool{1: true, 2: true, 3: true, 4: true, 5: true}
+	items := []nurseryCatalogItem{
+		{SpeciesID: 1, CatalogCode: "A-1"},  // unchanged
+		{SpeciesID: 2, CatalogCode: "B-9"},  // updated
+		{SpeciesID: 3, CatalogCode: ""},     // removed
+		{SpeciesID: 4, CatalogCode: " D-4"}, // new, with whitespace
+		{SpeciesID: 5, CatalogCode: "A-1"},  // new, but shares species 1's code
+		{SpeciesID: 6, CatalogCode: "F-6"},  // unknown species
+		{SpeciesID: 7, CatalogCode: ""},     // nothing to remove
+	}
+	rep := previewCatalogImport("nursery", items, existing, species)
+
+	if rep.RowsParsed != 7 || rep.New != 2 || rep.Updated != 1 || rep.Unchanged != 1 || rep.Removed != 1 {
+		t.Errorf("counts = %+v", rep)
+	}
+	issues := func(list []CatalogImportIssue) map[string][]int64 {
+		m := map[string][]int64{}
+		for _, is := range list {
+			m[is.Issue] = append(m[is.Issue], is.SpeciesID)
+		}
+		return m
+	}
+	v := issues(rep.ConstraintViolations)
+	if len(v) != 1 || !equalIDs(v["unknown_species"], []int64{6}) {
+		t.Errorf("violations = %v", v)
+	}
+	s := issues(rep.SuspiciousValues)
+	if !equalIDs(s["catalog_code_whitespace"], []int64{4}) ||
+		!equalIDs(s["remove_unmapped_species"], []int64{7}) ||
+		!equalIDs(s["shared_catalog_code"], []int64{1, 5}) {
+		t.Errorf("suspicious = %v", s)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	maxObservationPhotos     = 5
+	maxObservationPhotoBytes = 5 << 20
+)
+
+var allowedPhotoTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// ObservationRequest is the body of POST /api/observations.
+// Multipart submissions send it as JSON in the "observation" field and
+// attach images in one or more "photos" fields.
+type ObservationRequest struct {
+	SpeciesID              int64   `json:"species_id,omitempty"`
+	ScientificName         string  `json:"scientific_name,omitempty"`
+	Latitude               float64 `json:"latitude"`
+	Longitude              float64 `json:"longitude"`
+	CoordinateUncertaintyM *int    `json:"coordinate_uncertainty_m,omitempty"`
+	ObservedOn             string  `json:"observed_on,omitempty"` // YYYY-MM-DD
+	Establishment          string  `json:"establishment,omitempty"`
+	Notes                  string  `json:"notes,omitempty"`
+
+	// Store the coordinates generalized (see coordinate_privacy.go; always
+	// done for threatened species)
+	GeneralizeCoordinates bool `json:"generalize_coordinates,omitempty"`
+}
+
+// Observation is a stored field observation
+type Observation struct {
+	ID                     int64    `json:"id"`
+	SpeciesID              int64    `json:"species_id"`
+	CanonicalName          string   `json:"canonical_name"`
+	Latitude               float64  `json:"latitude"`
+	Longitude              float64  `json:"longitude"`
+	CoordinateUncertaintyM *int     `json:"coordinate_uncertainty_m,omitempty"`
+	CoordinateGridDeg      *float64 `json:"coordinate_grid_deg,omitempty"` // Generalized to a grid of this size
+	TDWGCode               *string  `json:"tdwg_code,omitempty"`
+	EcoID                  *int     `json:"eco_id,omitempty"`
+	ObservedOn             *string  `json:"observed_on,omitempty"`
+	Establishment          string   `json:"establishment"`
+	Notes                  *string  `json:"notes,omitempty"`
+	Status                 string   `json:"status"`
+	PhotoIDs               []int64  `json:"photo_ids"`
+	CreatedAt              string   `json:"created_at"`
+}
+
+type uploadedPhoto struct {
+	contentType string
+	data        []byte
+}
+
+// handleObservations handles GET/POST /api/observations
+func (s *Server) handleObservations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		key, ok := s.requireRole(w, r, roleUser)
+		if !ok {
+			return
+		}
+		s.listOwnObservations(ctx, w, key)
+	case http.MethodPost:
+		key, ok := s.requireRole(w, r, roleUser)
+		if !ok {
+			return
+		}
+		s.submitObservation(w, r, key)
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) submitObservation(w http.ResponseWriter, r *http.Request, key *APIKey) {
+	ctx := r.Context()
+	var req ObservationRequest
+	var photos []uploadedPhoto
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxObservationPhotos*maxObservationPhotoBytes+(1<<20))
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			http.Error(w, `{"error": "Invalid multipart form"}`, http.StatusBadRequest)
+			return
+		}
+		if err := decodeJSON(strings.NewReader(r.FormValue("observation")), &req); err != nil {
+			writeDecodeError(w, "Invalid JSON in 'observation' field", err)
+			return
+		}
+
+		files := r.MultipartForm.File["photos"]
+		if len(files) > maxObservationPhotos {
+			http.Error(w, fmt.Sprintf(`{"error": "At most %d photos per observation"}`, maxObservationPhotos), http.StatusBadRequest)
+			return
+		}
+		for _, fh := range files {
+			photo, err := readObservationPhoto(fh)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+				return
+			}
+			photos = append(photos, photo)
+		}
+	} else if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	if req.Latitude < -90 || req.Latitude > 90 || req.Longitude < -180 || req.Longitude > 180 ||
+		(req.Latitude == 0 && req.Longitude == 0) {
+		http.Error(w, `{"error": "Invalid coordinates"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Establishment == "" {
+		req.Establishment = "unknown"
+	}
+	if req.Establishment != "native" && req.Establishment != "introduced" && req.Establishment != "unknown" {
+		http.Error(w, `{"error": "establishment must be native, introduced or unknown"}`, http.StatusBadRequest)
+		return
+	}
+
+	var observedOn sql.NullString
+	if req.ObservedOn != "" {
+		t, err := time.Parse("2006-01-02", req.ObservedOn)
+		if err != nil || t.After(time.Now()) {
+			http.Error(w, `{"error": "observed_on must be a past date (YYYY-MM-DD)"}`, http.StatusBadRequest)
+			return
+		}
+		observedOn = sql.NullString{String: req.ObservedOn, Valid: true}
+	}
+
+	speciesID := req.SpeciesID
+	if speciesID == 0 {
+		if req.ScientificName == "" {
+			http.Error(w, `{"error": "Provide species_id or scientific_name"}`, http.StatusBadRequest)
+			return
+		}
+		err := s.db.QueryRowContext(ctx, `SELECT id FROM species WHERE lower(canonical_name) = lower($1) LIMIT 1`, req.ScientificName).Scan(&speciesID)
+		if err != nil {
+			http.Error(w, `{"error": "Species not found"}`, http.StatusNotFound)
+			return
+		}
+	}
+
+	// Resolve region and ecoregion now so curators see where the record falls
+	var tdwgCode sql.NullString
+	var ecoID sql.NullInt64
+	s.db.QueryRowContext(ctx, `SELECT level3_code FROM get_tdwg_by_coords($1, $2)`, req.Latitude, req.Longitude).Scan(&tdwgCode)
+	s.db.QueryRowContext(ctx, `
+		SELECT eco_id FROM ecoregions
+		WHERE ST_Contains(geom, ST_SetSRID(ST_Point($1, $2), 4326))
+		LIMIT 1
+	`, req.Longitude, req.Latitude).Scan(&ecoID)
+
+	// Then generalize what is stored
+	var gridDeg *float64
+	if s.cfg.Coordinates.generalizes(req.GeneralizeCoordinates, s.speciesThreatened(ctx, speciesID)) {
+		grid := s.cfg.Coordinates.GridDeg
+		gridDeg = &grid
+		req.Latitude, req.Longitude = generalizeCoordinates(req.Latitude, req.Longitude, grid)
+		req.CoordinateUncertaintyM = generalizedUncertaintyM(req.CoordinateUncertaintyM, grid)
+	}
+
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to save observation"}`, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO observations
+		(species_id, submitted_by, latitude, longitude, coordinate_uncertainty_m,
+		 coordinate_grid_deg, tdwg_code, eco_id, observed_on, establishment, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''))
+		RETURNING id
+	`, speciesID, key.ID, req.Latitude, req.Longitude, req.CoordinateUncertaintyM,
+		gridDeg, tdwgCode, ecoID, observedOn, req.Establishment, req.Notes).Scan(&id)
+	if err != nil {
+		s.log.Printf("Error saving observation: %v", err)
+		http.Error(w, `{"error": "Failed to save observation"}`, http.StatusBadRequest)
+		return
+	}
+
+	for _, photo := range photos {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO observation_photos (observation_id, content_type, data)
+			VALUES ($1, $2, $3)
+		`, id, photo.contentType, photo.data); err != nil {
+			s.log.Printf("Error saving observation photo: %v", err)
+			http.Error(w, `{"error": "Failed to save photos"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, `{"error": "Failed to save observation"}`, http.StatusInternalServerError)
+		return
+	}
+
+	obs, err := s.getObservation(ctx, id)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to load observation"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(obs)
+}
+
+func readObservationPhoto(fh *multipart.FileHeader) (uploadedPhoto, error) {
+	if fh.Size > maxObservationPhotoBytes {
+		return uploadedPhoto{}, fmt.Errorf("photo %s exceeds 5 MB", fh.Filename)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return uploadedPhoto{}, fmt.Errorf("could not read photo %s", fh.Filename)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxObservationPhotoBytes+1))
+	if err != nil || len(data) > maxObservationPhotoBytes {
+		return uploadedPhoto{}, fmt.Errorf("could not read photo %s", fh.Filename)
+	}
+
+	contentType := http.DetectContentType(data)
+	if !allowedPhotoTypes[contentType] {
+		return uploadedPhoto{}, fmt.Errorf("photo %s must be JPEG, PNG or WebP", fh.Filename)
+	}
+	// The EXIF GPS position would give away generalized coordinates
+	if data, err = stripPhotoMetadata(contentType, data); err != nil {
+		return uploadedPhoto{}, fmt.Errorf("photo %s: %v", fh.Filename, err)
+	}
+
+	return uploadedPhoto{contentType: contentType, data: data}, nil
+}
+
+const observationColumns = `
+	o.id, o.species_id, s.canonical_name, o.latitude, o.longitude,
+	o.coordinate_uncertainty_m, o.coordinate_grid_deg, o.tdwg_code, o.eco_id,
+	TO_CHAR(o.observed_on, 'YYYY-MM-DD'), o.establishment, o.notes, o.status,
+	TO_CHAR(o.created_at, 'YYYY-MM-DD"T"HH24:MI:SS'),
+	COALESCE(ARRAY(SELECT p.id FROM observation_photos p WHERE p.observation_id = o.id ORDER BY p.id), '{}')
+`
+
+func scanObservation(row interface{ Scan(...interface{}) error }) (Observation, error) {
+	var o Observation
+	var photoIDs pq.Int64Array
+	err := row.Scan(
+		&o.ID, &o.SpeciesID, &o.CanonicalName, &o.Latitude, &o.Longitude,
+		&o.CoordinateUncertaintyM, &o.CoordinateGridDeg, &o.TDWGCode, &o.EcoID,
+		&o.ObservedOn, &o.Establishment, &o.Notes, &o.Status,
+		&o.CreatedAt, &photoIDs,
+	)
+	o.PhotoIDs = []int64(photoIDs)
+	if o.PhotoIDs == nil {
+		o.PhotoIDs = []int64{}
+	}
+	return o, err
+}
+
+func (s *Server) getObservation(ctx context.Context, id int64) (Observation, error) {
+	return scanObservation(s.db.QueryRowContext(ctx, `
+		SELECT `+observationColumns+`
+		FROM observations o
+		JOIN species s ON o.species_id = s.id
+		WHERE o.id = $1
+	`, id))
+}
+
+func (s *Server) listOwnObservations(ctx context.Context, w http.ResponseWriter, key *APIKey) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+observationColumns+`
+		FROM observations o
+		JOIN species s ON o.species_id = s.id
+		WHERE o.submitted_by = $1
+		ORDER BY o.created_at DESC
+		LIMIT 500
+	`, key.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	observations := []Observation{}
+	for rows.Next() {
+		o, err := scanObservation(rows)
+		if err != nil {
+			s.log.Printf("Error scanning observation row: %v", err)
+			continue
+		}
+		observations = append(observations, o)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"observations": observations})
+}
+
+// photoCacheControl returns the Cache-Control for a photo of an observation
+// with the given status and submitter, as seen by key (nil if anonymous);
+// false when key may not see it. Photos of accepted observations are public,
+// the others only to their submitter and curators.
+func photoCacheControl(status string, submittedBy int64, key *APIKey) (string, bool) {
+	if status == "accepted" {
+		return "public, max-age=86400", true
+	}
+	if key != nil && (key.ID == submittedBy || key.hasRole(roleCurator)) {
+		return "private, no-cache", true
+	}
+	return "", false
+}
+
+// handleObservationPhoto handles GET /api/observations/photos/{id}
+func (s *Server) handleObservationPhoto(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Invalid photo id"}`, http.StatusBadRequest)
+		return
+	}
+
+	var contentType, status string
+	var submittedBy int64
+	var data []byte
+	err = s.db.QueryRowContext(ctx, `
+		SELECT p.content_type, p.data, o.status, o.submitted_by
+		FROM observation_photos p
+		JOIN observations o ON p.observation_id = o.id
+		WHERE p.id = $1
+	`, id).Scan(&contentType, &data, &status, &submittedBy)
+
+	var cacheControl string
+	ok := err == nil
+	if ok {
+		var key *APIKey
+		if status != "accepted" {
+			key, _ = s.authenticate(r)
+		}
+		cacheControl, ok = photoCacheControl(status, submittedBy, key)
+	}
+	if !ok {
+		// Pending and rejected photos are not found for anyone else
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Photo not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Write(data)
+}
+
+// applyObservation folds an accepted observation into the distribution
+// tables: the ecoregion observation count is incremented, and the TDWG region
+// gains a species_regions row when the establishment means is known.
+func applyObservation(ctx context.Context, tx *sql.Tx, id int64) error {
+	var speciesID int64
+	var tdwgCode sql.NullString
+	var ecoID sql.NullInt64
+	var establishment string
+	err := tx.QueryRowContext(ctx, `
+		SELECT species_id, tdwg_code, eco_id, establishment
+		FROM observations
+		WHERE id = $1 AND applied_at IS NULL
+		FOR UPDATE
+	`, id).Scan(&speciesID, &tdwgCode, &ecoID, &establishment)
+	if err == sql.ErrNoRows {
+		return nil // Already applied
+	}
+	if err != nil {
+		return err
+	}
+
+	if ecoID.Valid {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO species_ecoregions (species_id, eco_id, n_observations)
+			VALUES ($1, $2, 1)
+			ON CONFLICT (species_id, eco_id)
+			DO UPDATE SET n_observations = species_ecoregions.n_observations + 1
+		`, speciesID, ecoID.Int64); err != nil {
+			return fmt.Errorf("failed to update species_ecoregions: %w", err)
+		}
+	}
+
+	if tdwgCode.Valid && establishment != "unknown" {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO species_regions (species_id, tdwg_code, is_native, is_introduced, source)
+			VALUES ($1, $2, $3, $4, 'observation')
+			ON CONFLICT (species_id, tdwg_code) DO NOTHING
+		`, speciesID, tdwgCode.String, establishment == "native", establishment == "introduced"); err != nil {
+			return fmt.Errorf("failed to update species_regions: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE observations SET applied_at = NOW() WHERE id = $1`, id)
+	return err
+}
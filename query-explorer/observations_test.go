@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPhotoCacheControl(t *testing.T) {
+	owner := &APIKey{ID: 7, Role: roleUser}
+	other := &APIKey{ID: 8, Role: roleUser}
+	curator := &APIKey{ID: 9, Role: roleCurator}
+
+	tests := []struct {
+		status string
+		key    *APIKey
+		want   string
+		ok     bool
+	}{
+		{"accepted", nil, "public, max-age=86400", true},
+		{"accepted", other, "public, max-age=86400", true},
+		{"pending", nil, "", false},
+		{"pending", other, "", false},
+		{"pending", owner, "private, no-cache", true},
+		{"rejected", curator, "private, no-cache", true},
+		{"rejected", other, "", false},
+	}
+	for _, tc := range tests {
+		got, ok := photoCacheControl(tc.status, 7, tc.key)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%s as %+v: %q, %v; want %q, %v", tc.status, tc.key, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestSubmitObservationMatchesNameExactly(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		code int
+	}{
+		{"araucaria ANGUSTIFOLIA", http.StatusBadRequest}, // Found; the fake insert fails
+		{"Araucaria%", http.StatusNotFound},
+		{"Araucaria_angustifolia", http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, db := newFakeDBServer(t, fakeAdminKey,
+				fakeQuery{
+					match: "FROM species WHERE lower(canonical_name) = lower($1)",
+					when: func(args []driver.Value) bool {
+						return strings.EqualFold(args[0].(string), "Araucaria angustifolia")
+					},
+					columns: []string{"id"},
+					rows:    [][]driver.Value{{int64(42)}},
+				},
+				fakeQuery{match: "FROM species WHERE", columns: []string{"id"}},
+				fakeQuery{match: "INSERT INTO observations", err: errors.New("stop")},
+			)
+			body := `{"scientific_name": "` + tc.name + `", "latitude": -25.4, "longitude": -49.3}`
+			req := httptest.NewRequest("POST", "/api/observations", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer user")
+			w := httptest.NewRecorder()
+			s.router().ServeHTTP(w, req)
+			if w.Code != tc.code {
+				t.Fatalf("%d %s, want %d", w.Code, w.Body.String(), tc.code)
+			}
+			if tc.code == http.StatusBadRequest {
+				if args := db.argsOf("INSERT INTO observations"); len(args) == 0 || args[0] != int64(42) {
+					t.Errorf("observation inserted with %v, want species 42", args)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// PLANTING OUTCOMES
+// ============================================================================
+//
+// Field teams upload what survived: POST /api/plans/{id}/outcomes takes a
+// CSV (',' or ';', as spreadsheets save it) with one row per species and
+// monitoring visit:
+//
+//	species,monitored_at,planted,surviving,mean_height_m,notes
+//	Inga edulis,2025-03-10,300,261,"1,8",
+//
+// species is the plan species' name or ID; monitored_at is YYYY-MM-DD or
+// DD/MM/YYYY; mean_height_m and notes are optional, and the decimal comma
+// is accepted. The body is read row by row and written in one transaction:
+// one bad row rejects the upload and the response lists every bad row.
+// A second upload of the same species and date replaces the first.
+//
+// Each outcome copies the plan's selection hash and the species' climate
+// match score from the recommendation the plan was built from (migration
+// 047), so GET /api/admin/evaluation can compare survival across score
+// bands and tell whether climate matching predicts success.
+
+const (
+	maxOutcomeRows       = 10000
+	maxOutcomeBytes      = 10 << 20
+	maxOutcomeErrors     = 50
+	defaultEvalBandWidth = 0.1
+)
+
+type PlantingOutcome struct {
+	SpeciesID         int64    `json:"species_id"`
+	CanonicalName     string   `json:"canonical_name"`
+	MonitoredAt       string   `json:"monitored_at"`
+	Planted           int      `json:"planted"`
+	Surviving         int      `json:"surviving"`
+	SurvivalRate      float64  `json:"survival_rate"`
+	MeanHeightM       *float64 `json:"mean_height_m,omitempty"`
+	Notes             *string  `json:"notes,omitempty"`
+	ClimateMatchScore *float64 `json:"climate_match_score,omitempty"`
+}
+
+// OutcomeImportError is a rejected CSV row
+type OutcomeImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// outcomeRowError marks errors in one row, as opposed to an unreadable body
+type outcomeRowError struct {
+	line int
+	msg  string
+}
+
+func (e *outcomeRowError) Error() string { return fmt.Sprintf("line %d: %s", e.line, e.msg) }
+
+// outcomeReader reads outcome rows of one plan from a CSV
+type outcomeReader struct {
+	csv     *csv.Reader
+	cols    map[string]int
+	species map[string]PlanSpecies // By ID and lower-case name
+	line    int
+}
+
+func newOutcomeReader(body io.Reader, p *Plan) (*outcomeReader, error) {
+	br := bufio.NewReader(body)
+	header, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if strings.TrimSpace(header) == "" {
+		return nil, fmt.Errorf("empty CSV")
+	}
+	header = strings.TrimPrefix(header, "\ufeff")
+	cr := csv.NewReader(io.MultiReader(strings.NewReader(header), br))
+	if strings.Count(header, ";") > strings.Count(header, ",") {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	names, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	o := &outcomeReader{csv: cr, cols: map[string]int{}, species: map[string]PlanSpecies{}, line: 1}
+	for i, name := range names {
+		o.cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"species", "monitored_at", "planted", "surviving"} {
+		if _, ok := o.cols[required]; !ok {
+			return nil, fmt.Errorf("header: missing column %s", required)
+		}
+	}
+	for _, sp := range p.Species {
+		o.species[strconv.FormatInt(sp.SpeciesID, 10)] = sp
+		o.species[strings.ToLower(sp.CanonicalName)] = sp
+	}
+	return o, nil
+}
+
+// field is the trimmed value of a column, "" when the row is short
+func (o *outcomeReader) field(record []string, col string) string {
+	i, ok := o.cols[col]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// next returns the next outcome, an *outcomeRowError for an invalid row,
+// or io.EOF
+func (o *outcomeReader) next() (PlantingOutcome, error) {
+	var out PlantingOutcome
+	record, err := o.csv.Read()
+	if err != nil {
+		return out, err
+	}
+	o.line, _ = o.csv.FieldPos(0)
+	rowErr := func(format string, args ...interface{}) error {
+		return &outcomeRowError{line: o.line, msg: fmt.Sprintf(format, args...)}
+	}
+
+	name := o.field(record, "species")
+	sp, ok := o.species[strings.ToLower(name)]
+	if !ok {
+		return out, rowErr("species %q is not in the plan", name)
+	}
+	out.SpeciesID, out.CanonicalName, out.ClimateMatchScore = sp.SpeciesID, sp.CanonicalName, sp.ClimateMatchScore
+
+	raw := o.field(record, "monitored_at")
+	date, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		if date, err = time.Parse("02/01/2006", raw); err != nil {
+			return out, rowErr("invalid monitored_at %q (use YYYY-MM-DD or DD/MM/YYYY)", raw)
+		}
+	}
+	if date.After(time.Now()) {
+		return out, rowErr("monitored_at %s is in the future", raw)
+	}
+	out.MonitoredAt = date.Format("2006-01-02")
+
+	if out.Planted, err = strconv.Atoi(o.field(record, "planted")); err != nil || out.Planted <= 0 {
+		return out, rowErr("planted must be a positive integer")
+	}
+	if out.Surviving, err = strconv.Atoi(o.field(record, "surviving")); err != nil || out.Surviving < 0 {
+		return out, rowErr("surviving must be a non-negative integer")
+	}
+	if out.Surviving > out.Planted {
+		return out, rowErr("surviving (%d) exceeds planted (%d)", out.Surviving, out.Planted)
+	}
+	out.SurvivalRate = float64(out.Surviving) / float64(out.Planted)
+
+	if raw := o.field(record, "mean_height_m"); raw != "" {
+		h, err := strconv.ParseFloat(strings.Replace(raw, ",", ".", 1), 64)
+		if err != nil || h < 0 || h > 9999 {
+			return out, rowErr("invalid mean_height_m %q", raw)
+		}
+		out.MeanHeightM = &h
+	}
+	if notes := o.field(record, "notes"); notes != "" {
+		out.Notes = &notes
+	}
+	return out, nil
+}
+
+// ============================================================================
+// STORAGE
+// ============================================================================
+
+// importOutcomes streams the CSV into planting_outcomes; nothing is written
+// unless every row is valid
+func (s *Server) importOutcomes(r *http.Request, p *Plan) (int, []OutcomeImportError, error) {
+	ctx := r.Context()
+	reader, err := newOutcomeReader(r.Body, p)
+	if err != nil {
+		return 0, []OutcomeImportError{{Line: 1, Error: err.Error()}}, nil
+	}
+
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO planting_outcomes (plan_id, species_id, monitored_at, planted, surviving, mean_height_m, notes,
+		                               selection_hash, climate_match_score)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (plan_id, species_id, monitored_at) DO UPDATE
+		SET planted = EXCLUDED.planted, surviving = EXCLUDED.surviving, mean_height_m = EXCLUDED.mean_height_m,
+		    notes = EXCLUDED.notes, imported_at = CURRENT_TIMESTAMP
+	`)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer stmt.Close()
+
+	var rejected []OutcomeImportError
+	n := 0
+	for {
+		out, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *outcomeRowError
+		if errors.As(err, &rowErr) {
+			if len(rejected) < maxOutcomeErrors {
+				rejected = append(rejected, OutcomeImportError{Line: rowErr.line, Error: rowErr.msg})
+			}
+			continue
+		}
+		if err != nil {
+			var maxBytes *http.MaxBytesError
+			if errors.As(err, &maxBytes) {
+				err = fmt.Errorf("body larger than %d MB", maxOutcomeBytes>>20)
+			}
+			return 0, append(rejected, OutcomeImportError{Line: reader.line + 1, Error: err.Error()}), nil
+		}
+		if n++; n > maxOutcomeRows {
+			return 0, append(rejected, OutcomeImportError{Line: reader.line, Error: fmt.Sprintf("at most %d rows per upload", maxOutcomeRows)}), nil
+		}
+		if len(rejected) > 0 {
+			continue // Only validating from here on
+		}
+		if _, err := stmt.ExecContext(ctx, p.ID, out.SpeciesID, out.MonitoredAt, out.Planted, out.Surviving,
+			out.MeanHeightM, out.Notes, p.SelectionHash, out.ClimateMatchScore); err != nil {
+			return 0, nil, fmt.Errorf("line %d: %w", reader.line, err)
+		}
+	}
+	if len(rejected) > 0 {
+		return 0, rejected, nil
+	}
+	if n == 0 {
+		return 0, []OutcomeImportError{{Line: 1, Error: "no rows"}}, nil
+	}
+	return n, nil, tx.Commit()
+}
+
+func (s *Server) listOutcomes(r *http.Request, p *Plan) ([]PlantingOutcome, error) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT o.species_id, s.canonical_name, o.monitored_at, o.planted, o.surviving, o.mean_height_m, o.notes,
+		       o.climate_match_score
+		FROM planting_outcomes o
+		JOIN species s ON s.id = o.species_id
+		WHERE o.plan_id = $1
+		ORDER BY s.canonical_name, o.monitored_at
+	`, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	outcomes := []PlantingOutcome{}
+	for rows.Next() {
+		var out PlantingOutcome
+		var monitored time.Time
+		if err := rows.Scan(&out.SpeciesID, &out.CanonicalName, &monitored, &out.Planted, &out.Surviving,
+			&out.MeanHeightM, &out.Notes, &out.ClimateMatchScore); err != nil {
+			return nil, err
+		}
+		out.MonitoredAt = monitored.Format("2006-01-02")
+		out.SurvivalRate = float64(out.Surviving) / float64(out.Planted)
+		outcomes = append(outcomes, out)
+	}
+	return outcomes, rows.Err()
+}
+
+// ============================================================================
+// EVALUATION
+// ============================================================================
+
+// outcomeSample is the latest monitoring of one species in one plan
+type outcomeSample struct {
+	PlanID      int64
+	Score       *float64
+	Planted     int
+	Surviving   int
+	MeanHeightM *float64
+}
+
+type OutcomeGroup struct {
+	NPlans       int      `json:"n_plans"`
+	NSpecies     int      `json:"n_plan_species"` // Species-in-plan pairs
+	Planted      int      `json:"planted"`
+	Surviving    int      `json:"surviving"`
+	SurvivalRate *float64 `json:"survival_rate"` // surviving / planted
+	MeanHeightM  *float64 `json:"mean_height_m"` // Averaged over pairs that report it
+}
+
+type ScoreBand struct {
+	MinScore float64 `json:"min_score"`
+	MaxScore float64 `json:"max_score"`
+	OutcomeGroup
+}
+
+type EvaluationSummary struct {
+	BandWidth float64      `json:"band_width"`
+	Bands     []ScoreBand  `json:"bands"`
+	Unscored  OutcomeGroup `json:"unscored"` // Plans not built from a recommendation
+
+	// Pearson correlation of climate match score and survival rate over the
+	// scored pairs, weighted by seedlings planted; null below 3 pairs
+	Correlation *float64 `json:"correlation"`
+}
+
+// groupAccumulator sums samples into an OutcomeGroup
+type groupAccumulator struct {
+	plans     map[int64]bool
+	group     OutcomeGroup
+	heightSum float64
+	nHeight   int
+}
+
+func (a *groupAccumulator) add(sm outcomeSample) {
+	if a.plans == nil {
+		a.plans = map[int64]bool{}
+	}
+	a.plans[sm.PlanID] = true
+	a.group.NSpecies++
+	a.group.Planted += sm.Planted
+	a.group.Surviving += sm.Surviving
+	if sm.MeanHeightM != nil {
+		a.heightSum += *sm.MeanHeightM
+		a.nHeight++
+	}
+}
+
+func (a *groupAccumulator) result() OutcomeGroup {
+	g := a.group
+	g.NPlans = len(a.plans)
+	if g.Planted > 0 {
+		rate := math.Round(float64(g.Surviving)/float64(g.Planted)*1000) / 1000
+		g.SurvivalRate = &rate
+	}
+	if a.nHeight > 0 {
+		h := math.Round(a.heightSum/float64(a.nHeight)*100) / 100
+		g.MeanHeightM = &h
+	}
+	return g
+}
+
+// summarizeOutcomes groups samples into climate match score bands of
+// bandWidth; a score of exactly 1 falls in the last band
+func summarizeOutcomes(samples []outcomeSample, bandWidth float64) EvaluationSummary {
+	nBands := int(math.Ceil(1/bandWidth - 1e-9))
+	bands := make([]groupAccumulator, nBands)
+	var unscored groupAccumulator
+	var sw, sx, sy, sxx, syy, sxy float64
+	nScored := 0
+	for _, sm := range samples {
+		if sm.Score == nil {
+			unscored.add(sm)
+			continue
+		}
+		i := int(*sm.Score / bandWidth)
+		i = max(0, min(i, nBands-1))
+		bands[i].add(sm)
+
+		w, x, y := float64(sm.Planted), *sm.Score, float64(sm.Surviving)/float64(sm.Planted)
+		sw, sx, sy = sw+w, sx+w*x, sy+w*y
+		sxx, syy, sxy = sxx+w*x*x, syy+w*y*y, sxy+w*x*y
+		nScored++
+	}
+
+	sum := EvaluationSummary{BandWidth: bandWidth, Unscored: unscored.result()}
+	for i := range bands {
+		sum.Bands = append(sum.Bands, ScoreBand{
+			MinScore:     math.Round(float64(i)*bandWidth*1000) / 1000,
+			MaxScore:     math.Round(math.Min(float64(i+1)*bandWidth, 1)*1000) / 1000,
+			OutcomeGroup: bands[i].result(),
+		})
+	}
+	if nScored >= 3 {
+		cov := sxy/sw - (sx/sw)*(sy/sw)
+		vx, vy := sxx/sw-(sx/sw)*(sx/sw), syy/sw-(sy/sw)*(sy/sw)
+		if vx > 1e-12 && vy > 1e-12 {
+			r := math.Round(cov/math.Sqrt(vx*vy)*1000) / 1000
+			sum.Correlation = &r
+		}
+	}
+	return sum
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// planOutcomes handles GET/POST /api/plans/{id}/outcomes for the plan's owner
+func (s *Server) planOutcomes(w http.ResponseWriter, r *http.Request, p *Plan) {
+	if r.Method == http.MethodGet {
+		outcomes, err := s.listOutcomes(r, p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"plan_id": p.ID, "outcomes": outcomes})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOutcomeBytes)
+	n, rejected, err := s.importOutcomes(r, p)
+	if err != nil {
+		s.log.Printf("Error importing outcomes of plan %d: %v", p.ID, err)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if len(rejected) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "invalid CSV, nothing imported",
+			"errors": rejected,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"plan_id": p.ID, "imported": n})
+}
+
+// handleEvaluation handles GET /api/admin/evaluation?band_width=0.1
+func (s *Server) handleEvaluation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	bandWidth := defaultEvalBandWidth
+	if v := r.URL.Query().Get("band_width"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0.01 || f > 0.5 {
+			http.Error(w, `{"error": "band_width must be between 0.01 and 0.5"}`, http.StatusBadRequest)
+			return
+		}
+		bandWidth = f
+	}
+
+	start := time.Now()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (plan_id, species_id) plan_id, climate_match_score, planted, surviving, mean_height_m
+		FROM planting_outcomes
+		ORDER BY plan_id, species_id, monitored_at DESC
+	`)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var samples []outcomeSample
+	for rows.Next() {
+		var sm outcomeSample
+		if err := rows.Scan(&sm.PlanID, &sm.Score, &sm.Planted, &sm.Surviving, &sm.MeanHeightM); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		samples = append(samples, sm)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"evaluation": summarizeOutcomes(samples, bandWidth),
+		"n_outcomes": len(samples),
+		"query_time": time.Since(start).String(),
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOutcomeReader(t *testing.T) {
+	score := 0.82
+	p := &Plan{ID: 3, Species: []PlanSpecies{
+		{SpeciesID: 1, CanonicalName: "Inga edulis", ClimateMatchScore: &score},
+		{SpeciesID: 2, CanonicalName: "Cecropia pachystachya"},
+	}}
+	csv := "\ufeffspecies;monitored_at;planted;surviving;mean_height_m;notes\n" +
+		"inga edulis;2025-03-10;300;261;1,8;\n" +
+		"2;10/03/2025;200;150;;formigas\n" +
+		"Euterpe edulis;2025-03-10;10;5;;\n" +
+		"1;2025-13-01;10;5;;\n" +
+		"1;2025-03-10;10;11;;\n"
+	reader, err := newOutcomeReader(strings.NewReader(csv), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []PlantingOutcome
+	var lines []int
+	for {
+		out, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *outcomeRowError
+		if errors.As(err, &rowErr) {
+			lines = append(lines, rowErr.line)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, out)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d outcomes, want 2", len(got))
+	}
+	if o := got[0]; o.SpeciesID != 1 || o.Surviving != 261 || o.MeanHeightM == nil || *o.MeanHeightM != 1.8 ||
+		o.ClimateMatchScore != &score {
+		t.Errorf("first outcome = %+v", o)
+	}
+	if o := got[1]; o.SpeciesID != 2 || o.MonitoredAt != "2025-03-10" || o.Notes == nil || *o.Notes != "formigas" ||
+		o.ClimateMatchScore != nil {
+		t.Errorf("second outcome = %+v", o)
+	}
+	if len(lines) != 3 || lines[0] != 4 || lines[2] != 6 {
+		t.Errorf("rejected lines = %v, want [4 5 6]", lines)
+	}
+
+	if _, err := newOutcomeReader(strings.NewReader("species,planted\n"), p); err == nil {
+		t.Error("header without monitored_at and surviving accepted")
+	}
+	if _, err := newOutcomeReader(strings.NewReader(""), p); err == nil {
+		t.Error("empty body accepted")
+	}
+}
+
+func TestSummarizeOutcomes(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	samples := []outcomeSample{
+		{PlanID: 1, Score: f(0.95), Planted: 100, Surviving: 90, MeanHeightM: f(2)},
+		{PlanID: 1, Score: f(1), Planted: 100, Surviving: 80},
+		{PlanID: 2, Score: f(0.55), Planted: 100, Surviving: 50, MeanHeightM: f(1)},
+		{PlanID: 2, Score: f(0.15), Planted: 50, Surviving: 10},
+		{PlanID: 3, Planted: 40, Surviving: 20},
+	}
+	sum := summarizeOutcomes(samples, 0.1)
+
+	if len(sum.Bands) != 10 {
+		t.Fatalf("%d bands, want 10", len(sum.Bands))
+	}
+	top := sum.Bands[9]
+	if top.MinScore != 0.9 || top.MaxScore != 1 || top.NSpecies != 2 || top.NPlans != 1 ||
+		top.SurvivalRate == nil || *top.SurvivalRate != 0.85 || top.MeanHeightM == nil || *top.MeanHeightM != 2 {
+		t.Errorf("top band = %+v", top)
+	}
+	if sum.Bands[0].SurvivalRate != nil || sum.Bands[1].Planted != 50 {
+		t.Errorf("low bands = %+v, %+v", sum.Bands[0], sum.Bands[1])
+	}
+	if sum.Unscored.NSpecies != 1 || *sum.Unscored.SurvivalRate != 0.5 {
+		t.Errorf("unscored = %+v", sum.Unscored)
+	}
+	if sum.Correlation == nil || *sum.Correlation <= 0.9 {
+		t.Errorf("correlation = %v, want strongly positive", sum.Correlation)
+	}
+
+	if sum := summarizeOutcomes(samples[:2], 0.25); sum.Correlation != nil || len(sum.Bands) != 4 {
+		t.Errorf("two samples: correlation %v, %d bands", sum.Correlation, len(sum.Bands))
+	}
+}
+
+func TestEvaluationRequiresAdmin(t *testing.T) {
+	s := newTestServer()
+	w := httptest.NewRecorder()
+	s.handleEvaluation(w, httptest.NewRequest("GET", "/api/admin/evaluation", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: %d, want 401", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.handleEvaluation(w, httptest.NewRequest("POST", "/api/admin/evaluation", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// LIST PAGINATION, FILTERS AND SORTING
+// ============================================================================
+//
+// List endpoints (saved queries, query history, audit) share one set of
+// parameters instead of each parsing its own:
+//
+//	limit=50            page size, capped per endpoint
+//	cursor=...          opaque next_cursor of the previous page
+//	since=, until=      date range on the endpoint's time column (RFC 3339 or
+//	                    YYYY-MM-DD; a bare until date includes that whole day)
+//	q=...               case-insensitive substring over the search columns
+//	sort=name, -name    sort key, "-" for descending
+//
+// Pages are keyset-based: the cursor carries the sort value and ID of the
+// last row, so pages stay stable while rows are inserted. Sort expressions
+// must not be NULL (wrap nullable columns in COALESCE).
+
+// listSpec describes what a list endpoint allows
+type listSpec struct {
+	DefaultLimit  int
+	MaxLimit      int
+	Sorts         map[string]string // sort key -> SQL expression
+	DefaultSort   string            // key, "-" prefix for descending
+	IDColumn      string            // unique tie-breaker, e.g. "q.id"
+	TimeColumn    string            // filtered by since/until; "" disables
+	SearchColumns []string          // matched by q; empty disables
+}
+
+// listParams are the parsed list parameters of one request
+type listParams struct {
+	spec   listSpec
+	Limit  int
+	Sort   string
+	Desc   bool
+	Since  *time.Time
+	Until  *time.Time
+	Search string
+	cursor *listCursor
+}
+
+type listCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// parseListParams reads the list parameters of r against spec
+func parseListParams(r *http.Request, spec listSpec) (*listParams, error) {
+	query := r.URL.Query()
+	p := &listParams{spec: spec, Limit: spec.DefaultLimit}
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("limit must be a positive integer")
+		}
+		p.Limit = n
+	}
+	if p.Limit > spec.MaxLimit {
+		p.Limit = spec.MaxLimit
+	}
+
+	sortParam := query.Get("sort")
+	if sortParam == "" {
+		sortParam = spec.DefaultSort
+	}
+	p.Desc = strings.HasPrefix(sortParam, "-")
+	p.Sort = strings.TrimPrefix(sortParam, "-")
+	if _, ok := spec.Sorts[p.Sort]; !ok {
+		return nil, fmt.Errorf("sort must be one of %s", strings.Join(sortedKeys(spec.Sorts), ", "))
+	}
+
+	var err error
+	if p.Since, err = parseListTime(query.Get("since"), false); err != nil {
+		return nil, fmt.Errorf("since: %v", err)
+	}
+	if p.Until, err = parseListTime(query.Get("until"), true); err != nil {
+		return nil, fmt.Errorf("until: %v", err)
+	}
+	if (p.Since != nil || p.Until != nil) && spec.TimeColumn == "" {
+		return nil, fmt.Errorf("since/until are not supported here")
+	}
+
+	p.Search = strings.TrimSpace(query.Get("q"))
+	if p.Search != "" && len(spec.SearchColumns) == 0 {
+		return nil, fmt.Errorf("q is not supported here")
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		c, err := decodeListCursor(v)
+		if err != nil || c.Sort != p.Sort || c.Desc != p.Desc {
+			return nil, fmt.Errorf("invalid cursor (it only applies to the same sort)")
+		}
+		p.cursor = c
+	}
+	return p, nil
+}
+
+// parseListTime accepts RFC 3339 or YYYY-MM-DD; an end date is exclusive of
+// the following midnight
+func parseListTime(v string, end bool) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, fmt.Errorf("use RFC 3339 or YYYY-MM-DD")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// SQL returns the filter conditions (each prefixed with AND) and the
+// ORDER BY/LIMIT tail, numbering placeholders after the caller's args. One
+// row more than Limit is fetched so trim can tell whether another follows.
+func (p *listParams) SQL(args []interface{}) (where, tail string, allArgs []interface{}) {
+	var b strings.Builder
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if p.Since != nil {
+		fmt.Fprintf(&b, " AND %s >= %s", p.spec.TimeColumn, arg(*p.Since))
+	}
+	if p.Until != nil {
+		fmt.Fprintf(&b, " AND %s < %s", p.spec.TimeColumn, arg(*p.Until))
+	}
+	if p.Search != "" {
+		ph := arg("%" + escapeLike(p.Search) + "%")
+		conds := make([]string, len(p.spec.SearchColumns))
+		for i, col := range p.spec.SearchColumns {
+			conds[i] = fmt.Sprintf("%s ILIKE %s", col, ph)
+		}
+		fmt.Fprintf(&b, " AND (%s)", strings.Join(conds, " OR "))
+	}
+
+	expr := p.spec.Sorts[p.Sort]
+	dir, cmp := "ASC", ">"
+	if p.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if p.cursor != nil {
+		// The untyped text parameter takes the type of the sort expression
+		fmt.Fprintf(&b, " AND (%s, %s) %s (%s, %s)",
+			expr, p.spec.IDColumn, cmp, arg(p.cursor.Value), arg(p.cursor.ID))
+	}
+
+	tail = fmt.Sprintf("ORDER BY %s %s, %s %s LIMIT %d", expr, dir, p.spec.IDColumn, dir, p.Limit+1)
+	return b.String(), tail, args
+}
+
+// CursorColumn is the select-list expression whose text value, together with
+// the row ID, is passed to trim for building next_cursor
+func (p *listParams) CursorColumn() string {
+	return "(" + p.spec.Sorts[p.Sort] + ")::text"
+}
+
+// listKey is a fetched row's CursorColumn value and ID
+type listKey struct {
+	Value string
+	ID    int64
+}
+
+// trim reports how many of the fetched rows (one key each, in order) belong
+// to the page, and the cursor of the next page ("" on the last page)
+func (p *listParams) trim(keys []listKey) (int, string) {
+	if len(keys) <= p.Limit {
+		return len(keys), ""
+	}
+	last := keys[p.Limit-1]
+	return p.Limit, encodeListCursor(listCursor{Sort: p.Sort, Desc: p.Desc, Value: last.Value, ID: last.ID})
+}
+
+func encodeListCursor(c listCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeLike escapes LIKE wildcards so q matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// writeListError answers 400 for invalid list parameters
+func writeListError(w http.ResponseWriter, err error) {
+	http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+}
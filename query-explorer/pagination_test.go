@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testListSpec = listSpec{
+	DefaultLimit:  10,
+	MaxLimit:      50,
+	Sorts:         map[string]string{"name": "t.name", "created_at": "t.created_at"},
+	DefaultSort:   "-created_at",
+	IDColumn:      "t.id",
+	TimeColumn:    "t.created_at",
+	SearchColumns: []string{"t.name", "t.notes"},
+}
+
+func TestParseListParams(t *testing.T) {
+	p, err := parseListParams(httptest.NewRequest("GET", "/x", nil), testListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Limit != 10 || p.Sort != "created_at" || !p.Desc {
+		t.Errorf("defaults: got limit %d, sort %s, desc %v", p.Limit, p.Sort, p.Desc)
+	}
+
+	p, err = parseListParams(httptest.NewRequest("GET", "/x?limit=500&sort=name&until=2026-03-01", nil), testListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Limit != 50 || p.Sort != "name" || p.Desc {
+		t.Errorf("got limit %d, sort %s, desc %v", p.Limit, p.Sort, p.Desc)
+	}
+	if got := p.Until.Format("2006-01-02"); got != "2026-03-02" {
+		t.Errorf("until date should include the whole day, got %s", got)
+	}
+
+	for _, query := range []string{
+		"limit=0", "limit=abc", "sort=owner", "since=yesterday", "cursor=bm90IGpzb24",
+	} {
+		if _, err := parseListParams(httptest.NewRequest("GET", "/x?"+query, nil), testListSpec); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+
+	noSearch := testListSpec
+	noSearch.SearchColumns = nil
+	if _, err := parseListParams(httptest.NewRequest("GET", "/x?q=abc", nil), noSearch); err == nil {
+		t.Error("q without search columns: expected an error")
+	}
+}
+
+func TestListParamsSQL(t *testing.T) {
+	p, err := parseListParams(httptest.NewRequest("GET", "/x?q=50%25_off&since=2026-01-01&sort=name&limit=2", nil), testListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, tail, args := p.SQL([]interface{}{int64(7)})
+
+	want := " AND t.created_at >= $2 AND (t.name ILIKE $3 OR t.notes ILIKE $3)"
+	if where != want {
+		t.Errorf("where:\n got %q\nwant %q", where, want)
+	}
+	if tail != "ORDER BY t.name ASC, t.id ASC LIMIT 3" {
+		t.Errorf("tail: got %q", tail)
+	}
+	if len(args) != 3 || args[0] != int64(7) || args[2] != `%50\%\_off%` {
+		t.Errorf("args: got %v", args)
+	}
+
+	// A full page yields a cursor that continues after its last row
+	n, next := p.trim([]listKey{{"a", 1}, {"b", 2}, {"c", 3}})
+	if n != 2 || next == "" {
+		t.Fatalf("trim: got %d, %q", n, next)
+	}
+	p, err = parseListParams(httptest.NewRequest("GET", "/x?sort=name&cursor="+next, nil), testListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, _, args = p.SQL(nil)
+	if where != " AND (t.name, t.id) > ($1, $2)" || args[0] != "b" || args[1] != int64(2) {
+		t.Errorf("cursor: got %q %v", where, args)
+	}
+	if n, next := p.trim([]listKey{{"c", 3}}); n != 1 || next != "" {
+		t.Errorf("last page: got %d, %q", n, next)
+	}
+
+	// A cursor is bound to its sort
+	if _, err := parseListParams(httptest.NewRequest("GET", "/x?sort=-name&cursor="+next, nil), testListSpec); err == nil {
+		t.Error("cursor reused with another sort: expected an error")
+	}
+}
+
+func TestListParamsDescendingCursor(t *testing.T) {
+	cursor := encodeListCursor(listCursor{Sort: "created_at", Desc: true, Value: "2026-01-01 10:00:00", ID: 9})
+	p, err := parseListParams(httptest.NewRequest("GET", "/x?cursor="+cursor, nil), testListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, tail, _ := p.SQL(nil)
+	if !strings.Contains(where, "(t.created_at, t.id) < ($1, $2)") || !strings.HasPrefix(tail, "ORDER BY t.created_at DESC, t.id DESC") {
+		t.Errorf("got %q / %q", where, tail)
+	}
+}
+
+func TestListSpecsDefaults(t *testing.T) {
+	specs := map[string]listSpec{
+		"admin units": adminUnitList, "ecoregions": ecoregionList, "query audit": queryAuditList,
+		"saved queries": savedQueryList, "source species": sourceSpeciesList, "taxon species": taxonSpeciesList,
+		"curation queue": curationQueueList, "trait flags": traitFlagList,
+	}
+	for name, spec := range specs {
+		if _, err := parseListParams(httptest.NewRequest("GET", "/x", nil), spec); err != nil {
+			t.Errorf("%s: default parameters rejected: %v", name, err)
+		}
+		if spec.IDColumn == "" || spec.DefaultLimit > spec.MaxLimit {
+			t.Errorf("%s: invalid spec %+v", name, spec)
+		}
+	}
+}
+
+func BenchmarkListParamsSQL(b *testing.B) {
+	p, err := parseListParams(httptest.NewRequest("GET", "/x?q=ipe&sort=name&since=2026-01-01&limit=50", nil), testListSpec)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.SQL([]interface{}{int64(1)})
+	}
+}
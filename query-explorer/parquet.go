@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// ============================================================================
+// MINIMAL PARQUET WRITER
+// ============================================================================
+//
+// Just enough of the Parquet format for the spatial exports (see
+// spatial_export.go): flat schemas of INT64, DOUBLE, UTF8 and binary
+// columns, required or optional, PLAIN-encoded into one GZIP data page per
+// column chunk. The footer is Thrift compact protocol, written by hand
+// rather than pulling in a Parquet and Thrift dependency for one endpoint.
+// Plain pages, GZIP and converted types are the baseline every reader
+// (GDAL/OGR, DuckDB, pyarrow) supports.
+
+type parquetType int32
+
+// Physical types, as numbered in parquet.thrift
+const (
+	parquetInt64     parquetType = 2
+	parquetDouble    parquetType = 5
+	parquetByteArray parquetType = 6
+)
+
+const (
+	parquetMagic = "PAR1"
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetConvertedUTF8 = 0
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+	parquetCodecGzip     = 2
+	parquetPageData      = 0
+)
+
+// parquetColumn is one leaf of a flat schema
+type parquetColumn struct {
+	Name     string
+	Type     parquetType
+	UTF8     bool // BYTE_ARRAY holding text
+	Optional bool
+}
+
+// parquetWriter writes row groups to w as they come; Close writes the
+// footer. Values are int64, float64, string or []byte by column type, and
+// nil for null in optional columns.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []parquetColumn
+	rowGroups []parquetRowGroup
+	numRows   int64
+}
+
+type parquetChunk struct {
+	offset             int64
+	numValues          int64
+	uncompressed, size int64
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int64
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, columns: columns}
+	if err := p.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// WriteRowGroup writes rows (one value per column each) as a row group
+func (p *parquetWriter) WriteRowGroup(rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	group := parquetRowGroup{numRows: int64(len(rows))}
+	for i, col := range p.columns {
+		var levels []bool
+		var values bytes.Buffer
+		for r, row := range rows {
+			v := row[i]
+			if col.Optional {
+				levels = append(levels, v != nil)
+			}
+			if v == nil {
+				if !col.Optional {
+					return fmt.Errorf("row %d: %s is required", r, col.Name)
+				}
+				continue
+			}
+			if err := writePlainValue(&values, col, v); err != nil {
+				return fmt.Errorf("row %d: %w", r, err)
+			}
+		}
+
+		var page bytes.Buffer
+		if col.Optional {
+			encoded := encodeDefinitionLevels(levels)
+			binary.Write(&page, binary.LittleEndian, uint32(len(encoded)))
+			page.Write(encoded)
+		}
+		page.Write(values.Bytes())
+
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(page.Bytes())
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		var header thriftWriter
+		header.i32(1, parquetPageData)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(compressed.Len()))
+		header.structBegin(5) // DataPageHeader
+		header.i32(1, int32(len(rows)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.structEnd()
+		header.stop()
+
+		chunk := parquetChunk{offset: p.offset, numValues: int64(len(rows))}
+		chunk.uncompressed = int64(header.buf.Len() + page.Len())
+		chunk.size = int64(header.buf.Len() + compressed.Len())
+		if err := p.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(compressed.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.numRows += group.numRows
+	return nil
+}
+
+func writePlainValue(buf *bytes.Buffer, col parquetColumn, v interface{}) error {
+	switch col.Type {
+	case parquetInt64:
+		n, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("%s: want int64, got %T", col.Name, v)
+		}
+		binary.Write(buf, binary.LittleEndian, n)
+	case parquetDouble:
+		f, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("%s: want float64, got %T", col.Name, v)
+		}
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(f))
+	case parquetByteArray:
+		var b []byte
+		switch x := v.(type) {
+		case string:
+			b = []byte(x)
+		case []byte:
+			b = x
+		default:
+			return fmt.Errorf("%s: want string or []byte, got %T", col.Name, v)
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(len(b)))
+		buf.Write(b)
+	default:
+		return fmt.Errorf("%s: unsupported type %d", col.Name, col.Type)
+	}
+	return nil
+}
+
+// encodeDefinitionLevels encodes 0/1 levels (bit width 1) as runs of the
+// RLE/bit-packing hybrid: a varint of count<<1 followed by the value byte
+func encodeDefinitionLevels(levels []bool) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		writeUvarint(&buf, uint64(j-i)<<1)
+		if levels[i] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		i = j
+	}
+	return buf.Bytes()
+}
+
+// Close writes the file metadata, with metadata as key/value pairs (e.g.
+// the GeoParquet "geo" key), and the trailing magic
+func (p *parquetWriter) Close(metadata map[string]string) error {
+	var f thriftWriter
+	f.i32(1, 1) // version
+
+	f.listBegin(2, thriftStruct, len(p.columns)+1)
+	f.elemBegin() // Root
+	f.binary(4, []byte("schema"))
+	f.i32(5, int32(len(p.columns)))
+	f.elemEnd()
+	for _, col := range p.columns {
+		f.elemBegin()
+		f.i32(1, int32(col.Type))
+		repetition := int32(parquetRequired)
+		if col.Optional {
+			repetition = parquetOptional
+		}
+		f.i32(3, repetition)
+		f.binary(4, []byte(col.Name))
+		if col.UTF8 {
+			f.i32(6, parquetConvertedUTF8)
+		}
+		f.elemEnd()
+	}
+
+	f.i64(3, p.numRows)
+
+	f.listBegin(4, thriftStruct, len(p.rowGroups))
+	for _, g := range p.rowGroups {
+		f.elemBegin()
+		f.listBegin(1, thriftStruct, len(g.chunks))
+		var total int64
+		for i, c := range g.chunks {
+			col := p.columns[i]
+			f.elemBegin() // ColumnChunk
+			f.i64(2, c.offset)
+			f.structBegin(3) // ColumnMetaData
+			f.i32(1, int32(col.Type))
+			f.listBegin(2, thriftI32, 2)
+			f.listI32(parquetEncodingPlain)
+			f.listI32(parquetEncodingRLE)
+			f.listBegin(3, thriftBinary, 1)
+			f.listBinary([]byte(col.Name))
+			f.i32(4, parquetCodecGzip)
+			f.i64(5, c.numValues)
+			f.i64(6, c.uncompressed)
+			f.i64(7, c.size)
+			f.i64(9, c.offset)
+			f.structEnd()
+			f.elemEnd()
+			total += c.uncompressed
+		}
+		f.i64(2, total)
+		f.i64(3, g.numRows)
+		f.elemEnd()
+	}
+
+	if len(metadata) > 0 {
+		keys := make([]string, 0, len(metadata))
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		f.listBegin(5, thriftStruct, len(keys))
+		for _, k := range keys {
+			f.elemBegin()
+			f.binary(1, []byte(k))
+			f.binary(2, []byte(metadata[k]))
+			f.elemEnd()
+		}
+	}
+	f.binary(6, []byte("diversiplant query-explorer"))
+	f.stop()
+
+	if err := p.write(f.buf.Bytes()); err != nil {
+		return err
+	}
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], uint32(f.buf.Len()))
+	if err := p.write(trailer[:]); err != nil {
+		return err
+	}
+	return p.write([]byte(parquetMagic))
+}
+
+// ============================================================================
+// THRIFT COMPACT PROTOCOL
+// ============================================================================
+
+// Compact protocol type ids
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes one struct; nested structs and list elements keep
+// their own last field id on a stack
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16
+}
+
+func (t *thriftWriter) lastID() int16 {
+	if len(t.last) == 0 {
+		t.last = []int16{0}
+	}
+	return t.last[len(t.last)-1]
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID(); delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		writeUvarint(&t.buf, zigzag(int64(id)))
+	}
+	t.last[len(t.last)-1] = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	writeUvarint(&t.buf, zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, b []byte) {
+	t.field(id, thriftBinary)
+	t.listBinary(b)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) structEnd() {
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		writeUvarint(&t.buf, uint64(n))
+	}
+}
+
+// elemBegin and elemEnd delimit a struct element of a list
+func (t *thriftWriter) elemBegin() {
+	t.lastID()
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.structEnd()
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) listBinary(b []byte) {
+	writeUvarint(&t.buf, uint64(len(b)))
+	t.buf.Write(b)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
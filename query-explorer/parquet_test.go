@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestZigzag(t *testing.T) {
+	for v, want := range map[int64]uint64{0: 0, -1: 1, 1: 2, -2: 3, 2: 4, 1 << 40: 1 << 41} {
+		if got := zigzag(v); got != want {
+			t.Errorf("zigzag(%d) = %d, want %d", v, got, want)
+		}
+	}
+}
+
+func TestEncodeDefinitionLevels(t *testing.T) {
+	got := encodeDefinitionLevels([]bool{true, true, true, false, true})
+	want := []byte{3 << 1, 1, 1 << 1, 0, 1 << 1, 1}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Runs of 64 and more need a two-byte varint
+	levels := make([]bool, 100)
+	if got := encodeDefinitionLevels(levels); !bytes.Equal(got, []byte{0xc8, 0x01, 0}) {
+		t.Errorf("long run: got %v", got)
+	}
+}
+
+func TestThriftWriterFieldDeltas(t *testing.T) {
+	var tw thriftWriter
+	tw.i32(1, 7)
+	tw.structBegin(3)
+	tw.i64(1, -1)
+	tw.structEnd()
+	tw.i32(20, 0) // Delta over 15: long form
+	tw.stop()
+	want := []byte{0x15, 14, 0x2c, 0x16, 1, 0, 0x05, 40, 0, 0}
+	if !bytes.Equal(tw.buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", tw.buf.Bytes(), want)
+	}
+}
+
+func TestParquetWriterFile(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := newParquetWriter(&buf, []parquetColumn{
+		{Name: "id", Type: parquetInt64},
+		{Name: "name", Type: parquetByteArray, UTF8: true, Optional: true},
+		{Name: "area", Type: parquetDouble, Optional: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.WriteRowGroup([][]interface{}{{int64(1), "Myrcia", 2.5}, {int64(2), nil, nil}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(map[string]string{"geo": `{"version":"1.1.0"}`}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if !bytes.HasPrefix(b, []byte(parquetMagic)) || !bytes.HasSuffix(b, []byte(parquetMagic)) {
+		t.Fatalf("missing magic: %q...%q", b[:4], b[len(b)-4:])
+	}
+	footerLen := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	footer := b[len(b)-8-footerLen : len(b)-8]
+	for _, s := range []string{"schema", "id", "name", "area", "geo", `{"version":"1.1.0"}`, "diversiplant query-explorer"} {
+		if !bytes.Contains(footer, []byte(s)) {
+			t.Errorf("footer lacks %q", s)
+		}
+	}
+	if pw.numRows != 2 || len(pw.rowGroups) != 1 || len(pw.rowGroups[0].chunks) != 3 {
+		t.Errorf("got %d rows in %d groups", pw.numRows, len(pw.rowGroups))
+	}
+	if pw.rowGroups[0].chunks[0].offset != int64(len(parquetMagic)) {
+		t.Errorf("first chunk at %d", pw.rowGroups[0].chunks[0].offset)
+	}
+}
+
+func TestParquetWriterRejectsBadValues(t *testing.T) {
+	pw, _ := newParquetWriter(&bytes.Buffer{}, []parquetColumn{{Name: "id", Type: parquetInt64}})
+	for _, tc := range []struct {
+		row  []interface{}
+		want string
+	}{
+		{[]interface{}{nil}, "id is required"},
+		{[]interface{}{"1"}, "want int64"},
+		{[]interface{}{1}, "want int64"},
+	} {
+		err := pw.WriteRowGroup([][]interface{}{tc.row})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: got %v, want %q", tc.row, err, tc.want)
+		}
+	}
+	if err := pw.WriteRowGroup(nil); err != nil || len(pw.rowGroups) != 0 {
+		t.Errorf("empty group: %v, %d groups", err, len(pw.rowGroups))
+	}
+}
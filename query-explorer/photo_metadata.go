@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ============================================================================
+// PHOTO METADATA
+// ============================================================================
+//
+// Phone photos carry the EXIF GPS position of where they were taken, which
+// would undo the coordinate generalization of the observation (see
+// coordinate_privacy.go). Uploads are stored with their metadata removed:
+//
+//	JPEG  APP1 (EXIF, XMP) and APP13 (IPTC) segments
+//	PNG   eXIf and text (tEXt, zTXt, iTXt, where XMP lives) chunks
+//	WebP  EXIF and XMP chunks, and their VP8X flags
+//
+// The image data itself is copied unchanged.
+
+var errMalformedPhoto = errors.New("malformed image")
+
+// stripPhotoMetadata returns data without its EXIF, XMP and text metadata
+func stripPhotoMetadata(contentType string, data []byte) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	case "image/webp":
+		return stripWebPMetadata(data)
+	}
+	return data, nil
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformedPhoto
+	}
+	out := append(make([]byte, 0, len(data)), data[:2]...)
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, errMalformedPhoto
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // Fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // Start of scan, or end: the rest is image data
+			return append(out, data[i:]...), nil
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, errMalformedPhoto
+		}
+		if marker != 0xE1 && marker != 0xED {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true}
+
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformedPhoto
+	}
+	out := append(make([]byte, 0, len(data)), pngSignature...)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errMalformedPhoto
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return nil, errMalformedPhoto
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformedPhoto
+	}
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformedPhoto
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2 // Chunks are padded to an even size
+		if end > len(data) || end < i+8 {
+			return nil, errMalformedPhoto
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
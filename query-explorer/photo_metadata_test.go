@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestStripJPEGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := append([]byte("Exif\x00\x00"), []byte("GPSLatitude -23.5617")...)
+	app1 := append([]byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}, exif...)
+	plain := buf.Bytes()
+	tagged := append(append(append([]byte{}, plain[:2]...), app1...), plain[2:]...)
+
+	got, err := stripPhotoMetadata("image/jpeg", tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(got, []byte("GPSLatitude")) || !bytes.Equal(got, plain) {
+		t.Errorf("EXIF kept: %d bytes, want %d", len(got), len(plain))
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+	if _, err := stripPhotoMetadata("image/jpeg", tagged[:40]); err == nil {
+		t.Error("truncated JPEG accepted")
+	}
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(append(chunk, kind...), data...)
+	return append(chunk, 0, 0, 0, 0) // CRC, not checked
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 25
+	tagged := append(append([]byte{}, plain[:ihdrEnd]...), pngChunk("eXIf", []byte("MM\x00*GPS"))...)
+	tagged = append(tagged, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00<exif:GPSLatitude>"))...)
+	tagged = append(tagged, plain[ihdrEnd:]...)
+
+	got, err := stripPhotoMetadata("image/png", tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("metadata kept: %d bytes, want %d", len(got), len(plain))
+	}
+	if _, err := png.Decode(bytes.NewReader(got)); err != nil {
+		t.Errorf("stripped PNG does not decode: %v", err)
+	}
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	riffChunk := func(kind string, data []byte) []byte {
+		chunk := append([]byte(kind), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+		chunk = append(chunk, data...)
+		if len(data)%2 == 1 {
+			chunk = append(chunk, 0)
+		}
+		return chunk
+	}
+	vp8x := riffChunk("VP8X", []byte{0x0C, 0, 0, 0, 7, 0, 0, 7, 0, 0})
+	frame := riffChunk("VP8 ", []byte("frame"))
+	body := append(append(append([]byte("WEBP"), vp8x...), frame...), riffChunk("EXIF", []byte("GPS"))...)
+	body = append(body, riffChunk("XMP ", []byte("<x/>"))...)
+	file := append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+
+	got, err := stripPhotoMetadata("image/webp", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(got, []byte("EXIF")) || bytes.Contains(got, []byte("XMP ")) || !bytes.Contains(got, frame) {
+		t.Errorf("chunks: %q", got)
+	}
+	if flags := got[20]; flags != 0 {
+		t.Errorf("VP8X flags %#x, want EXIF and XMP cleared", flags)
+	}
+	if size := binary.LittleEndian.Uint32(got[4:]); int(size) != len(got)-8 {
+		t.Errorf("RIFF size %d for %d bytes", size, len(got))
+	}
+}
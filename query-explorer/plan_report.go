@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// ============================================================================
+// HTML PLAN REPORT
+// ============================================================================
+//
+// GET /api/plans/{id}/export?format=html is the plan as a printable,
+// self-contained HTML page (templates/plan_report.html): the PRA fields and
+// species table, in the whitelabel theme of the caller's tenant (see
+// theme.go), its logo inlined as a data URL. Keys without a tenant get the
+// DiversiPlant palette.
+
+var planReportTemplate = template.Must(template.ParseFS(templateFS, "templates/plan_report.html"))
+
+type planReportData struct {
+	Title  string
+	CSS    template.CSS
+	Logo   template.URL
+	Fields [][]string
+	Header []string
+	Rows   [][]string
+	Total  []string
+}
+
+// newPlanReport lays out the export rows of p in theme; logo is the data
+// URL of the tenant logo, or empty
+func newPlanReport(p *Plan, theme ReportTheme, logo template.URL) planReportData {
+	rows := planExportRows(p)
+	// Property fields, a blank row, the table header, species, total
+	return planReportData{
+		Title:  p.Name,
+		CSS:    template.CSS(theme.CSSVariables()), // #RRGGBB colors only (hexColorPattern)
+		Logo:   logo,
+		Fields: rows[:6],
+		Header: rows[7],
+		Rows:   rows[8 : len(rows)-1],
+		Total:  rows[len(rows)-1],
+	}
+}
+
+// logoDataURL inlines a raster logo; anything else is left out
+func logoDataURL(logo []byte, contentType string) template.URL {
+	if len(logo) == 0 || !allowedLogoTypes[contentType] {
+		return ""
+	}
+	return template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(logo))
+}
+
+// exportPlanReport writes the HTML report of p in the theme of key's tenant
+func (s *Server) exportPlanReport(w http.ResponseWriter, r *http.Request, key *APIKey, p *Plan) {
+	ctx := r.Context()
+	theme, logo := defaultReportTheme, template.URL("")
+	if key.TenantID.Valid {
+		var err error
+		if theme, err = s.themeForTenant(ctx, key.TenantID.Int64); err != nil {
+			s.log.Printf("Error loading tenant theme: %v", err)
+		}
+		if theme.HasLogo {
+			data, contentType, err := s.tenantLogo(ctx, key.TenantID.Int64)
+			if err == nil {
+				logo = logoDataURL(data, contentType)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="plano-%d.html"`, p.ID))
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src data:; style-src 'unsafe-inline'")
+	if err := planReportTemplate.Execute(w, newPlanReport(p, theme, logo)); err != nil {
+		s.log.Printf("Error writing plan report: %v", err)
+	}
+}
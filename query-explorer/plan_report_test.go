@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPlanReport(t *testing.T) {
+	qty := 40
+	p := &Plan{ID: 3, Name: `Mata <script>alert(1)</script>`, Species: []PlanSpecies{
+		{CanonicalName: "Euterpe edulis", Family: "Arecaceae", GrowthForm: "palm", IsNative: true, Quantity: &qty},
+	}}
+	theme := defaultReportTheme
+	theme.PrimaryColor = "#123456"
+	logo := logoDataURL([]byte("\x89PNG\r\n\x1a\n"), "image/png")
+
+	var buf bytes.Buffer
+	if err := planReportTemplate.Execute(&buf, newPlanReport(p, theme, logo)); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	for _, want := range []string{"--dp-primary:#123456", `src="data:image/png;base64,`, "<td>Euterpe edulis</td>", "<td>Palmeira</td>", "<td>40</td>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+	if strings.Contains(html, "<script>") {
+		t.Error("plan name not escaped")
+	}
+
+	if logoDataURL([]byte("<svg onload=alert(1)>"), "image/svg+xml") != "" {
+		t.Error("SVG logo inlined")
+	}
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// RESTORATION PLANS
+// ============================================================================
+//
+// A plan is a user's planting list for a site (migration 022), typically
+// built from a recommendation. Plans carry the composition compliance report
+// of the site's state and export to the agency spreadsheet layouts.
+
+const maxPlanSpecies = 500
+
+var validPlanMethods = map[string]bool{
+	"total_planting": true,
+	"enrichment":     true,
+	"nucleation":     true,
+	"direct_seeding": true,
+}
+
+type PlanSpeciesInput struct {
+	SpeciesID     int64    `json:"species_id"`
+	Quantity      *int     `json:"quantity,omitempty"`
+	SpacingRowM   *float64 `json:"spacing_row_m,omitempty"`
+	SpacingPlantM *float64 `json:"spacing_plant_m,omitempty"`
+
+	// Ignored; taken from the recommendation with selection_hash for
+	// evaluation (see resolvePlanScores)
+	ClimateMatchScore *float64 `json:"climate_match_score,omitempty"`
+}
+
+// PlanRequest is the body of POST /api/plans
+type PlanRequest struct {
+	Name string `json:"name"`
+
+	// Location (one required; resolved like /api/recommend)
+	TDWGCode  string   `json:"tdwg_code,omitempty"`
+	StateCode string   `json:"state_code,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	AOIID     string   `json:"aoi_id,omitempty"`
+
+	Municipality  string   `json:"municipality,omitempty"`
+	CARCode       string   `json:"car_code,omitempty"`
+	AreaHa        *float64 `json:"area_ha,omitempty"`
+	SpacingRowM   *float64 `json:"spacing_row_m,omitempty"`
+	SpacingPlantM *float64 `json:"spacing_plant_m,omitempty"`
+	Method        string   `json:"method,omitempty"` // total_planting, enrichment, nucleation, direct_seeding
+	Notes         string   `json:"notes,omitempty"`
+	SelectionHash string   `json:"selection_hash,omitempty"` // Of the recommendation the plan was built from
+
+	Species []PlanSpeciesInput `json:"species"`
+}
+
+type PlanSpecies struct {
+	SpeciesID          int64    `json:"species_id"`
+	CanonicalName      string   `json:"canonical_name"`
+	CommonNamePT       *string  `json:"common_name_pt,omitempty"`
+	Family             string   `json:"family"`
+	GrowthForm         string   `json:"growth_form"`
+	ThreatStatus       *string  `json:"threat_status,omitempty"`
+	ThreatStatusLabel  *string  `json:"threat_status_label,omitempty"`
+	IsNative           bool     `json:"is_native"`
+	IsEndemic          bool     `json:"is_endemic"`
+	EstablishmentMeans *string  `json:"establishment_means,omitempty"`
+	Quantity           *int     `json:"quantity,omitempty"`
+	SpacingRowM        *float64 `json:"spacing_row_m,omitempty"`
+	SpacingPlantM      *float64 `json:"spacing_plant_m,omitempty"`
+	ClimateMatchScore  *float64 `json:"climate_match_score,omitempty"`
+}
+
+type Plan struct {
+	ID            int64             `json:"id"`
+	Name          string            `json:"name"`
+	TDWGCode      string            `json:"tdwg_code"`
+	Level3Codes   []string          `json:"level3_codes,omitempty"` // Of a unit spanning several regions
+	StateCode     *string           `json:"state_code,omitempty"`
+	Municipality  *string           `json:"municipality,omitempty"`
+	CARCode       *string           `json:"car_code,omitempty"`
+	AreaHa        *float64          `json:"area_ha,omitempty"`
+	SpacingRowM   *float64          `json:"spacing_row_m,omitempty"`
+	SpacingPlantM *float64          `json:"spacing_plant_m,omitempty"`
+	Method        *string           `json:"method,omitempty"`
+	Notes         *string           `json:"notes,omitempty"`
+	SelectionHash *string           `json:"selection_hash,omitempty"`
+	CreatedAt     string            `json:"created_at"`
+	Species       []PlanSpecies     `json:"species,omitempty"`
+	Compliance    *ComplianceReport `json:"compliance,omitempty"`
+
+	NurseryOrder *NurseryOrder `json:"nursery_order,omitempty"`
+}
+
+// validatePlanRequest checks a plan and fills missing seedling quantities
+// from area and spacing, split evenly across the species without one
+func validatePlanRequest(req *PlanRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("name required")
+	}
+	if len(req.Species) == 0 {
+		return fmt.Errorf("species required")
+	}
+	if len(req.Species) > maxPlanSpecies {
+		return fmt.Errorf("at most %d species per plan", maxPlanSpecies)
+	}
+	if req.Method != "" && !validPlanMethods[req.Method] {
+		return fmt.Errorf("invalid method: %s (use total_planting, enrichment, nucleation or direct_seeding)", req.Method)
+	}
+	if req.AreaHa != nil && *req.AreaHa <= 0 {
+		return fmt.Errorf("area_ha must be positive")
+	}
+	if len(req.SelectionHash) > 16 {
+		return fmt.Errorf("invalid selection_hash")
+	}
+
+	seen := map[int64]bool{}
+	var missing []int
+	assigned := 0
+	for i, sp := range req.Species {
+		if seen[sp.SpeciesID] {
+			return fmt.Errorf("species %d listed twice", sp.SpeciesID)
+		}
+		seen[sp.SpeciesID] = true
+		for _, s := range []*float64{sp.SpacingRowM, sp.SpacingPlantM} {
+			if s != nil && *s <= 0 {
+				return fmt.Errorf("spacing must be positive")
+			}
+		}
+		if sp.Quantity == nil {
+			missing = append(missing, i)
+		} else if *sp.Quantity < 0 {
+			return fmt.Errorf("quantity must not be negative")
+		} else {
+			assigned += *sp.Quantity
+		}
+	}
+	for _, s := range []*float64{req.SpacingRowM, req.SpacingPlantM} {
+		if s != nil && *s <= 0 {
+			return fmt.Errorf("spacing must be positive")
+		}
+	}
+
+	total := plannedSeedlings(req.AreaHa, req.SpacingRowM, req.SpacingPlantM)
+	if total > assigned && len(missing) > 0 {
+		remaining := total - assigned
+		for n, i := range missing {
+			q := remaining / len(missing)
+			if n < remaining%len(missing) {
+				q++
+			}
+			req.Species[i].Quantity = &q
+		}
+	}
+	return nil
+}
+
+// plannedSeedlings is the number of seedlings the area holds at the spacing
+// (0 when either is unknown)
+func plannedSeedlings(areaHa, row, plant *float64) int {
+	if areaHa == nil || row == nil || plant == nil {
+		return 0
+	}
+	return int(*areaHa * 10000 / (*row * *plant))
+}
+
+// errUnknownSelection is a selection_hash no cached recommendation has
+var errUnknownSelection = errors.New("selection_hash matches no cached recommendation; recommend again")
+
+// recommendationScores returns the climate match score of each species of
+// the cached recommendation with the selection hash
+func (s *Server) recommendationScores(ctx context.Context, hash string) (map[int64]float64, error) {
+	var respJSON []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT response
+		FROM recommendation_cache
+		WHERE response->>'selection_hash' = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, hash).Scan(&respJSON)
+	if err == sql.ErrNoRows {
+		return nil, errUnknownSelection
+	}
+	if err != nil {
+		return nil, err
+	}
+	var resp RecommendResponse
+	if err := json.Unmarshal(respJSON, &resp); err != nil {
+		return nil, err
+	}
+	scores := make(map[int64]float64, len(resp.Species))
+	for _, sp := range resp.Species {
+		scores[sp.SpeciesID] = sp.ClimateMatchScore
+	}
+	return scores, nil
+}
+
+// resolvePlanScores replaces the client's climate match scores with the
+// recommendation's; species added outside it have none
+func resolvePlanScores(req *PlanRequest, scores map[int64]float64) {
+	for i, sp := range req.Species {
+		req.Species[i].ClimateMatchScore = nil
+		if sc, ok := scores[sp.SpeciesID]; ok {
+			req.Species[i].ClimateMatchScore = &sc
+		}
+	}
+}
+
+func (s *Server) createPlan(ctx context.Context, key *APIKey, req PlanRequest, location LocationInfo) (int64, error) {
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO restoration_plans (
+			owner_key_id, name, tdwg_code, state_code, municipality, car_code,
+			area_ha, spacing_row_m, spacing_plant_m, method, notes, selection_hash, level3_codes
+		) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), $13)
+		RETURNING id
+	`, key.ID, req.Name, location.TDWGCode, strings.ToUpper(req.StateCode), req.Municipality, req.CARCode,
+		req.AreaHa, req.SpacingRowM, req.SpacingPlantM, req.Method, req.Notes, req.SelectionHash, pq.Array(location.Level3Codes)).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, sp := range req.Species {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO restoration_plan_species (plan_id, species_id, quantity, spacing_row_m, spacing_plant_m, position, climate_match_score)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, id, sp.SpeciesID, sp.Quantity, sp.SpacingRowM, sp.SpacingPlantM, i, sp.ClimateMatchScore); err != nil {
+			return 0, fmt.Errorf("species %d: %w", sp.SpeciesID, err)
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+const planColumns = `p.id, p.name, p.tdwg_code, p.state_code, p.municipality, p.car_code,
+	p.area_ha, p.spacing_row_m, p.spacing_plant_m, p.method, p.notes, p.selection_hash, p.created_at,
+	p.nursery_partner, p.nursery_order_ref, p.nursery_ordered_at, p.level3_codes`
+
+func scanPlan(row interface{ Scan(...interface{}) error }) (Plan, error) {
+	var p Plan
+	var createdAt time.Time
+	var partner, orderRef sql.NullString
+	var orderedAt sql.NullTime
+	err := row.Scan(&p.ID, &p.Name, &p.TDWGCode, &p.StateCode, &p.Municipality, &p.CARCode,
+		&p.AreaHa, &p.SpacingRowM, &p.SpacingPlantM, &p.Method, &p.Notes, &p.SelectionHash, &createdAt,
+		&partner, &orderRef, &orderedAt, pq.Array(&p.Level3Codes))
+	p.CreatedAt = createdAt.Format(time.RFC3339)
+	if orderRef.Valid {
+		p.NurseryOrder = &NurseryOrder{Partner: partner.String, Reference: orderRef.String, OrderedAt: orderedAt.Time.Format(time.RFC3339)}
+	}
+	return p, err
+}
+
+// getPlan loads a plan with its species; only the owner can read it
+func (s *Server) getPlan(ctx context.Context, key *APIKey, id int64) (*Plan, error) {
+	p, err := scanPlan(s.db.QueryRowContext(ctx, `
+		SELECT `+planColumns+`
+		FROM restoration_plans p
+		WHERE p.id = $1 AND p.owner_key_id = $2
+	`, id, key.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Nativeness is relative to the plan's unit (see tdwg_levels.go): the
+	// regions stored with it, or those of its level-2 code
+	regions := p.Level3Codes
+	if len(regions) == 0 {
+		if regions, err = s.level3Codes(ctx, p.TDWGCode); err != nil {
+			return nil, err
+		}
+	}
+	if regions == nil {
+		regions = []string{p.TDWGCode}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.canonical_name,
+		       (SELECT cn.common_name FROM common_names cn
+		        WHERE cn.species_id = s.id AND cn.language = 'pt' LIMIT 1),
+		       COALESCE(s.family, 'Unknown'), COALESCE(su.growth_form, 'unknown'), su.threat_status,
+		       COALESCE(sr.is_native, false), COALESCE(sr.is_endemic, false), sr.establishment_means::text,
+		       ps.quantity, ps.spacing_row_m, ps.spacing_plant_m, ps.climate_match_score
+		FROM restoration_plan_species ps
+		JOIN species s ON ps.species_id = s.id
+		LEFT JOIN species_unified su ON s.id = su.species_id
+		`+regionStatusJoin("s.id", "$2")+`
+		WHERE ps.plan_id = $1
+		ORDER BY ps.position, s.canonical_name
+	`, id, pq.Array(regions))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sp PlanSpecies
+		if err := rows.Scan(&sp.SpeciesID, &sp.CanonicalName, &sp.CommonNamePT, &sp.Family, &sp.GrowthForm,
+			&sp.ThreatStatus, &sp.IsNative, &sp.IsEndemic, &sp.EstablishmentMeans,
+			&sp.Quantity, &sp.SpacingRowM, &sp.SpacingPlantM, &sp.ClimateMatchScore); err != nil {
+			return nil, err
+		}
+		p.Species = append(p.Species, sp)
+	}
+	return &p, rows.Err()
+}
+
+// planCompliance evaluates the plan against its state's rule set
+func (s *Server) planCompliance(ctx context.Context, p *Plan) (*ComplianceReport, error) {
+	state := ""
+	if p.StateCode != nil {
+		state = *p.StateCode
+	}
+	rs, err := s.loadComplianceRuleSet(ctx, complianceCode("", state))
+	if err != nil {
+		return nil, err
+	}
+
+	species := make([]SpeciesRecommendation, len(p.Species))
+	var quantities map[int64]int
+	for i, sp := range p.Species {
+		species[i] = SpeciesRecommendation{
+			SpeciesID:          sp.SpeciesID,
+			CanonicalName:      sp.CanonicalName,
+			Family:             sp.Family,
+			GrowthForm:         sp.GrowthForm,
+			ThreatStatus:       sp.ThreatStatus,
+			IsNative:           sp.IsNative,
+			IsEndemic:          sp.IsEndemic,
+			EstablishmentMeans: sp.EstablishmentMeans,
+		}
+		if sp.Quantity != nil {
+			if quantities == nil {
+				quantities = make(map[int64]int)
+			}
+			quantities[sp.SpeciesID] = *sp.Quantity
+		}
+	}
+	return evaluateCompliance(rs, species, quantities), nil
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handlePlans handles GET/POST /api/plans
+func (s *Server) handlePlans(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT `+planColumns+`
+			FROM restoration_plans p
+			WHERE p.owner_key_id = $1
+			ORDER BY p.created_at DESC
+			LIMIT 500
+		`, key.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		plans := []Plan{}
+		for rows.Next() {
+			p, err := scanPlan(rows)
+			if err != nil {
+				s.log.Printf("Error scanning plan row: %v", err)
+				continue
+			}
+			plans = append(plans, p)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"plans": plans})
+
+	case http.MethodPost:
+		var req PlanRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if err := validatePlanRequest(&req); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		var scores map[int64]float64
+		if req.SelectionHash != "" {
+			var err error
+			if scores, err = s.recommendationScores(ctx, req.SelectionHash); err == errUnknownSelection {
+				http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusUnprocessableEntity)
+				return
+			} else if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+				return
+			}
+		}
+		resolvePlanScores(&req, scores)
+
+		location, err := s.resolveLocationClimate(ctx, RecommendRequest{
+			TDWGCode: req.TDWGCode, StateCode: req.StateCode, Latitude: req.Latitude, Longitude: req.Longitude,
+			AOIID: req.AOIID,
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		id, err := s.createPlan(ctx, key, req, location)
+		if err != nil {
+			s.log.Printf("Error creating plan: %v", err)
+			http.Error(w, `{"error": "Failed to create plan"}`, http.StatusBadRequest)
+			return
+		}
+
+		plan, err := s.getPlan(ctx, key, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		localizePlan(plan, requestLanguage(r))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(plan)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// planItem adapts a handler of one of the caller's plans to the
+// /api/plans/{id} routes (GET /export?format=pra|html, POST /order,
+// GET/POST /outcomes), loading the plan
+func planItem(handle func(*Server, http.ResponseWriter, *http.Request, *APIKey, *Plan)) func(*Server, http.ResponseWriter, *http.Request) {
+	return func(s *Server, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, `{"error": "Not found"}`, http.StatusNotFound)
+			return
+		}
+		key, ok := s.requireRole(w, r, roleUser)
+		if !ok {
+			return
+		}
+
+		plan, err := s.getPlan(r.Context(), key, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, `{"error": "Plan not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		handle(s, w, r, key, plan)
+	}
+}
+
+// handlePlan handles /api/plans/{id} (GET, DELETE)
+func (s *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		planItem((*Server).getPlanDetail)(s, w, r)
+		return
+	}
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Not found"}`, http.StatusNotFound)
+		return
+	}
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM restoration_plans WHERE id = $1 AND owner_key_id = $2`, id, key.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, `{"error": "Plan not found"}`, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPlanDetail answers GET /api/plans/{id}, with the plan's compliance
+func (s *Server) getPlanDetail(w http.ResponseWriter, r *http.Request, _ *APIKey, plan *Plan) {
+	var err error
+	if plan.Compliance, err = s.planCompliance(r.Context(), plan); err != nil {
+		s.log.Printf("Error evaluating plan compliance: %v", err)
+	}
+	localizePlan(plan, requestLanguage(r))
+	json.NewEncoder(w).Encode(plan)
+}
+
+// ============================================================================
+// PRA / SICAR EXPORT
+// ============================================================================
+//
+// The Programas de Regularização Ambiental ask for the species list of a
+// recovery project (PRAD/PRADA) as a spreadsheet: species, origin, quantity
+// and spacing, preceded by the property identification. Exported as CSV in
+// the Brazilian Excel dialect (UTF-8 BOM, ';' separator, decimal comma) so it
+// opens directly and can be pasted into the agency templates.
+
+var planExportFormats = map[string]bool{"pra": true, "html": true}
+
+var planMethodLabels = map[string]string{
+	"total_planting": "Plantio total",
+	"enrichment":     "Enriquecimento",
+	"nucleation":     "Nucleação",
+	"direct_seeding": "Semeadura direta",
+}
+
+var growthFormLabels = map[string]string{
+	"tree":      "Árvore",
+	"shrub":     "Arbusto",
+	"subshrub":  "Subarbusto",
+	"palm":      "Palmeira",
+	"liana":     "Liana",
+	"vine":      "Trepadeira",
+	"scrambler": "Escandente",
+	"bamboo":    "Bambu",
+	"forb":      "Erva",
+	"graminoid": "Graminoide",
+}
+
+// originLabel is the "Origem" column: native or exotic in the plan's region
+func originLabel(sp PlanSpecies) string {
+	if sp.IsNative || (sp.EstablishmentMeans != nil && *sp.EstablishmentMeans == "native") {
+		if sp.IsEndemic {
+			return "Nativa (endêmica)"
+		}
+		return "Nativa"
+	}
+	if sp.EstablishmentMeans != nil {
+		switch *sp.EstablishmentMeans {
+		case "naturalized":
+			return "Exótica naturalizada"
+		case "invasive":
+			return "Exótica invasora"
+		}
+	}
+	return "Exótica"
+}
+
+// decimalBR formats a number with a decimal comma ("" for nil)
+func decimalBR(v *float64, prec int) string {
+	if v == nil {
+		return ""
+	}
+	return strings.Replace(strconv.FormatFloat(*v, 'f', prec, 64), ".", ",", 1)
+}
+
+func planExportRows(p *Plan) [][]string {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	method := ""
+	if p.Method != nil {
+		method = planMethodLabels[*p.Method]
+	}
+
+	rows := [][]string{
+		{"Projeto", p.Name},
+		{"Inscrição no CAR", str(p.CARCode)},
+		{"Município", str(p.Municipality)},
+		{"UF", strings.TrimPrefix(str(p.StateCode), "BR-")},
+		{"Área (ha)", decimalBR(p.AreaHa, 4)},
+		{"Método", method},
+		{},
+		{"Nº", "Família", "Nome científico", "Nome popular", "Forma de vida", "Origem",
+			"Ameaça (IUCN)", "Quantidade de mudas", "Espaçamento entre linhas (m)", "Espaçamento entre plantas (m)"},
+	}
+
+	total := 0
+	for i, sp := range p.Species {
+		quantity, row, plant := "", sp.SpacingRowM, sp.SpacingPlantM
+		if sp.Quantity != nil {
+			quantity = strconv.Itoa(*sp.Quantity)
+			total += *sp.Quantity
+		}
+		if row == nil {
+			row = p.SpacingRowM
+		}
+		if plant == nil {
+			plant = p.SpacingPlantM
+		}
+		form := growthFormLabels[sp.GrowthForm]
+		if form == "" {
+			form = sp.GrowthForm
+		}
+		rows = append(rows, []string{
+			strconv.Itoa(i + 1), sp.Family, sp.CanonicalName, str(sp.CommonNamePT), form, originLabel(sp),
+			str(sp.ThreatStatus), quantity, decimalBR(row, 2), decimalBR(plant, 2),
+		})
+	}
+	rows = append(rows, []string{"", "", "", "", "", "", "Total", strconv.Itoa(total), "", ""})
+	return rows
+}
+
+// exportPlan handles GET /api/plans/{id}/export: format=pra (CSV, default)
+// or html (the themed report, see plan_report.go)
+func (s *Server) exportPlan(w http.ResponseWriter, r *http.Request, key *APIKey, p *Plan) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "pra"
+	}
+	if !planExportFormats[format] {
+		http.Error(w, fmt.Sprintf(`{"error": "unsupported format: %s (use pra or html)"}`, format), http.StatusBadRequest)
+		return
+	}
+	if format == "html" {
+		s.exportPlanReport(w, r, key, p)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="plano-%d-%s.csv"`, p.ID, format))
+
+	w.Write([]byte("\ufeff")) // BOM: Excel otherwise reads the file as Latin-1
+	cw := csv.NewWriter(w)
+	cw.Comma = ';'
+	// Names and the other fields are the user's text; numbers never start
+	// with a formula character here
+	for _, row := range planExportRows(p) {
+		cw.Write(csvSafeRecord(row))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("Error writing plan export: %v", err)
+	}
+}
+
+// localizePlan sets the threat status labels of the plan species
+func localizePlan(plan *Plan, lang string) {
+	for i := range plan.Species {
+		plan.Species[i].ThreatStatusLabel = threatStatusLabel(plan.Species[i].ThreatStatus, lang)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidatePlanRequestFillsQuantities(t *testing.T) {
+	area, row, plant := 1.0, 3.0, 2.0
+	fixed := 400
+	req := PlanRequest{
+		Name:          "APP Córrego",
+		AreaHa:        &area,
+		SpacingRowM:   &row,
+		SpacingPlantM: &plant,
+		Species:       []PlanSpeciesInput{{SpeciesID: 1, Quantity: &fixed}, {SpeciesID: 2}, {SpeciesID: 3}, {SpeciesID: 4}},
+	}
+	if err := validatePlanRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 ha at 3 x 2 m holds 1666 seedlings; 1266 left for three species
+	want := []int{400, 422, 422, 422}
+	for i, sp := range req.Species {
+		if sp.Quantity == nil || *sp.Quantity != want[i] {
+			t.Errorf("species %d: quantity = %v, want %d", sp.SpeciesID, sp.Quantity, want[i])
+		}
+	}
+
+	req.Species = append(req.Species, PlanSpeciesInput{SpeciesID: 2})
+	if err := validatePlanRequest(&req); err == nil {
+		t.Error("duplicate species accepted")
+	}
+}
+
+func TestResolvePlanScores(t *testing.T) {
+	forged := 1.0
+	req := PlanRequest{Species: []PlanSpeciesInput{{SpeciesID: 1, ClimateMatchScore: &forged}, {SpeciesID: 2, ClimateMatchScore: &forged}}}
+	resolvePlanScores(&req, map[int64]float64{1: 0.62})
+	if sc := req.Species[0].ClimateMatchScore; sc == nil || *sc != 0.62 {
+		t.Errorf("species 1: score = %v, want the recommendation's 0.62", sc)
+	}
+	if sc := req.Species[1].ClimateMatchScore; sc != nil {
+		t.Errorf("species 2 is not in the recommendation but has score %v", *sc)
+	}
+}
+
+func TestExportPlanEscapesFormulas(t *testing.T) {
+	qty, area := 10, 1.5
+	notes := "nota"
+	p := &Plan{ID: 9, Name: `=HYPERLINK("http://x","clique")`, Notes: &notes, AreaHa: &area, Species: []PlanSpecies{
+		{CanonicalName: "Inga edulis", Family: "+Fabaceae", GrowthForm: "tree", IsNative: true, Quantity: &qty},
+	}}
+	w := httptest.NewRecorder()
+	newTestServer().exportPlan(w, httptest.NewRequest("GET", "/api/plans/9/export", nil), &APIKey{ID: 1}, p)
+
+	body := w.Body.String()
+	if !strings.Contains(body, `Projeto;"'=HYPERLINK(""http://x"",""clique"")"`) || !strings.Contains(body, ";'+Fabaceae;") {
+		t.Errorf("formulas not escaped:\n%s", body)
+	}
+	if !strings.Contains(body, "Área (ha);1,5000") || !strings.Contains(body, ";10;") {
+		t.Errorf("values changed:\n%s", body)
+	}
+}
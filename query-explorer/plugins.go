@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ============================================================================
+// RECOMMENDATION PIPELINE PLUGINS
+// ============================================================================
+//
+// Deployments can extend the recommendation pipeline without touching core
+// code by registering plugins (typically from an init() function in a
+// separate file compiled into the binary). Plugins are never applied
+// implicitly: a request opts in by listing plugin names in
+// RecommendRequest.Plugins.
+//
+// Three stages are exposed:
+//   - CandidateFilter: runs after the climate-adapted candidate query and may
+//     drop or reorder candidates.
+//   - Scorer: returns an additive adjustment to each candidate's combined
+//     selection score during greedy diversity selection.
+//   - PostProcessor: runs on the final response before it is returned.
+
+// PluginContext carries the request state shared with every plugin stage.
+// Plugins querying DB pass Ctx, so they stop with the request and within its
+// statement timeout.
+type PluginContext struct {
+	Ctx      context.Context
+	DB       *sql.DB
+	Request  RecommendRequest
+	Location LocationInfo
+}
+
+// CandidateFilter filters the candidate pool before selection.
+type CandidateFilter interface {
+	Name() string
+	Filter(ctx PluginContext, candidates []SpeciesRecommendation) ([]SpeciesRecommendation, error)
+}
+
+// Scorer adjusts the combined selection score of a candidate.
+type Scorer interface {
+	Name() string
+	Score(ctx PluginContext, candidate SpeciesRecommendation) (float64, error)
+}
+
+// PostProcessor modifies the final recommendation response.
+type PostProcessor interface {
+	Name() string
+	Process(ctx PluginContext, resp *RecommendResponse) error
+}
+
+var (
+	pluginMu         sync.RWMutex
+	candidateFilters = map[string]CandidateFilter{}
+	scorers          = map[string]Scorer{}
+	postProcessors   = map[string]PostProcessor{}
+)
+
+// RegisterCandidateFilter registers a candidate filter under its name.
+// It panics if the name is already taken by any plugin.
+func RegisterCandidateFilter(f CandidateFilter) {
+	pluginMu.Lock()
+	defer pluginMu.Unlock()
+	mustBeUnregistered(f.Name())
+	candidateFilters[f.Name()] = f
+}
+
+// RegisterScorer registers a scorer under its name.
+// It panics if the name is already taken by any plugin.
+func RegisterScorer(s Scorer) {
+	pluginMu.Lock()
+	defer pluginMu.Unlock()
+	mustBeUnregistered(s.Name())
+	scorers[s.Name()] = s
+}
+
+// RegisterPostProcessor registers a post-processor under its name.
+// It panics if the name is already taken by any plugin.
+func RegisterPostProcessor(p PostProcessor) {
+	pluginMu.Lock()
+	defer pluginMu.Unlock()
+	mustBeUnregistered(p.Name())
+	postProcessors[p.Name()] = p
+}
+
+// mustBeUnregistered must be called with pluginMu held.
+func mustBeUnregistered(name string) {
+	if name == "" {
+		panic("plugin: empty plugin name")
+	}
+	_, f := candidateFilters[name]
+	_, s := scorers[name]
+	_, p := postProcessors[name]
+	if f || s || p {
+		panic(fmt.Sprintf("plugin: %q registered twice", name))
+	}
+}
+
+// registeredPlugins returns the sorted names of all registered plugins.
+func registeredPlugins() []string {
+	pluginMu.RLock()
+	defer pluginMu.RUnlock()
+
+	var names []string
+	for name := range candidateFilters {
+		names = append(names, name)
+	}
+	for name := range scorers {
+		names = append(names, name)
+	}
+	for name := range postProcessors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pipelinePlugins is the set of plugins a single request opted into,
+// in the order they were requested.
+type pipelinePlugins struct {
+	filters        []CandidateFilter
+	scorers        []Scorer
+	postProcessors []PostProcessor
+}
+
+// resolvePlugins looks up the requested plugin names. Unknown names are an
+// error so that a typo never silently produces unscored results.
+func resolvePlugins(names []string) (*pipelinePlugins, error) {
+	pluginMu.RLock()
+	defer pluginMu.RUnlock()
+
+	p := &pipelinePlugins{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		found := false
+		if f, ok := candidateFilters[name]; ok {
+			p.filters = append(p.filters, f)
+			found = true
+		}
+		if s, ok := scorers[name]; ok {
+			p.scorers = append(p.scorers, s)
+			found = true
+		}
+		if pp, ok := postProcessors[name]; ok {
+			p.postProcessors = append(p.postProcessors, pp)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown plugin: %s", name)
+		}
+	}
+	return p, nil
+}
+
+func (p *pipelinePlugins) filterCandidates(ctx PluginContext, candidates []SpeciesRecommendation) ([]SpeciesRecommendation, error) {
+	var err error
+	for _, f := range p.filters {
+		candidates, err = f.Filter(ctx, candidates)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", f.Name(), err)
+		}
+	}
+	return candidates, nil
+}
+
+// scoreAdjustments sums every scorer's adjustment per candidate species.
+// A nil map is returned when no scorer was requested.
+func (p *pipelinePlugins) scoreAdjustments(ctx PluginContext, candidates []SpeciesRecommendation) (map[int64]float64, error) {
+	if len(p.scorers) == 0 {
+		return nil, nil
+	}
+
+	adjustments := make(map[int64]float64, len(candidates))
+	for _, s := range p.scorers {
+		for _, c := range candidates {
+			score, err := s.Score(ctx, c)
+			if err != nil {
+				return nil, fmt.Errorf("plugin %s: %w", s.Name(), err)
+			}
+			adjustments[c.SpeciesID] += score
+		}
+	}
+	return adjustments, nil
+}
+
+func (p *pipelinePlugins) postProcess(ctx PluginContext, resp *RecommendResponse) error {
+	for _, pp := range p.postProcessors {
+		if err := pp.Process(ctx, resp); err != nil {
+			return fmt.Errorf("plugin %s: %w", pp.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testFilter struct {
+	name   string
+	filter func(ctx PluginContext, candidates []SpeciesRecommendation) ([]SpeciesRecommendation, error)
+}
+
+func (f testFilter) Name() string { return f.name }
+func (f testFilter) Filter(ctx PluginContext, candidates []SpeciesRecommendation) ([]SpeciesRecommendation, error) {
+	return f.filter(ctx, candidates)
+}
+
+type testScorer struct {
+	name  string
+	score func(c SpeciesRecommendation) float64
+}
+
+func (s testScorer) Name() string { return s.name }
+func (s testScorer) Score(ctx PluginContext, c SpeciesRecommendation) (float64, error) {
+	return s.score(c), nil
+}
+
+// unregisterPlugins drops the named test plugins when t ends
+func unregisterPlugins(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		pluginMu.Lock()
+		defer pluginMu.Unlock()
+		for _, name := range names {
+			delete(candidateFilters, name)
+			delete(scorers, name)
+			delete(postProcessors, name)
+		}
+	})
+}
+
+func mustPanic(t *testing.T, what string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s did not panic", what)
+		}
+	}()
+	f()
+}
+
+func TestRegisterPluginPanics(t *testing.T) {
+	unregisterPlugins(t, "test-dup")
+	RegisterScorer(testScorer{name: "test-dup"})
+
+	mustPanic(t, "registering a scorer twice", func() { RegisterScorer(testScorer{name: "test-dup"}) })
+	mustPanic(t, "reusing a scorer name for a filter", func() { RegisterCandidateFilter(testFilter{name: "test-dup"}) })
+	mustPanic(t, "registering an empty name", func() { RegisterScorer(testScorer{}) })
+}
+
+func TestResolvePluginsUnknown(t *testing.T) {
+	unregisterPlugins(t, "test-known")
+	RegisterScorer(testScorer{name: "test-known"})
+
+	if _, err := resolvePlugins([]string{"test-known", "test-typo"}); err == nil || err.Error() != "unknown plugin: test-typo" {
+		t.Errorf("err = %v, want unknown plugin: test-typo", err)
+	}
+	p, err := resolvePlugins([]string{"test-known", "test-known"})
+	if err != nil || len(p.scorers) != 1 {
+		t.Errorf("resolvePlugins = %+v, %v; want the scorer once", p, err)
+	}
+}
+
+func TestScoreAdjustmentsSummed(t *testing.T) {
+	unregisterPlugins(t, "test-tenth", "test-by-id")
+	RegisterScorer(testScorer{name: "test-tenth", score: func(SpeciesRecommendation) float64 { return 0.1 }})
+	RegisterScorer(testScorer{name: "test-by-id", score: func(c SpeciesRecommendation) float64 { return float64(c.SpeciesID) }})
+
+	candidates := []SpeciesRecommendation{{SpeciesID: 1}, {SpeciesID: 2}}
+	none, _ := resolvePlugins(nil)
+	if adj, err := none.scoreAdjustments(PluginContext{}, candidates); adj != nil || err != nil {
+		t.Errorf("without scorers: %v, %v; want nil", adj, err)
+	}
+
+	p, err := resolvePlugins([]string{"test-tenth", "test-by-id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adj, err := p.scoreAdjustments(PluginContext{}, candidates)
+	if err != nil || adj[1] != 1.1 || adj[2] != 2.1 {
+		t.Errorf("adjustments = %v, %v; want 1: 1.1, 2: 2.1", adj, err)
+	}
+}
+
+func TestRecommendPluginContextCarriesRequestContext(t *testing.T) {
+	var seen context.Context
+	unregisterPlugins(t, "test-ctx")
+	RegisterCandidateFilter(testFilter{name: "test-ctx", filter: func(ctx PluginContext, _ []SpeciesRecommendation) ([]SpeciesRecommendation, error) {
+		seen = ctx.Ctx
+		return nil, errors.New("stop")
+	}})
+
+	s, _ := newFakeDBServer(t,
+		fakeQuery{
+			match:   "c.bio1_mean, c.bio5_mean",
+			columns: []string{"tdwg_code", "level3_name", "bio1", "bio5", "bio6", "bio12", "bio15"},
+			rows:    [][]driver.Value{{"BZS", "Brazil South", 18.0, 28.0, 8.0, 1500.0, 30.0}},
+		},
+		fakeQuery{match: "as climate_match_score"},
+	)
+	type requestKey struct{}
+	req := httptest.NewRequest("POST", "/api/recommend", strings.NewReader(`{"tdwg_code": "BZS", "plugins": ["test-ctx"]}`))
+	req = req.WithContext(context.WithValue(req.Context(), requestKey{}, "this request"))
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "plugin test-ctx: stop") {
+		t.Fatalf("%d %s, want the filter to run", w.Code, w.Body.String())
+	}
+	if seen == nil || seen.Value(requestKey{}) != "this request" {
+		t.Errorf("PluginContext.Ctx = %v, want the request context", seen)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// PUBLIC WIDGET API
+// ============================================================================
+//
+// /api/public/ is a small read-only subset of the API for lookup widgets
+// embedded in partner websites:
+//
+//	GET /api/public/species/search?q=&limit=   species search (limit <= 10)
+//	GET /api/public/region?lat=&lon=           TDWG region at a point
+//	GET /api/public/climate?lat=&lon=          WorldClim climate at a point
+//
+// It is kept apart from the rest of the API:
+//
+//   - only the parameters above (and lang) reach the handlers, coordinates
+//     moved to the center of their OBSERVATION_COORDINATE_GRID cell (see
+//     coordinate_privacy.go); API keys, cookies and any other parameter are
+//     dropped, so nothing authenticated or ad hoc (queries, exports, admin)
+//     is reachable through it
+//   - its own limit per client IP, PUBLIC_RATE_LIMIT ("30/m"), instead of
+//     the RATE_LIMIT buckets and demo quotas
+//   - responses (200 and 404) are cached in memory for PUBLIC_CACHE_TTL (1h),
+//     at most PUBLIC_CACHE_ENTRIES of them, and sent with a public
+//     Cache-Control of the same age
+//   - CORS allows any origin, for GET only
+//
+// PUBLIC_API=false turns it off (404).
+
+const (
+	publicPrefix           = "/api/public/"
+	defaultPublicRateLimit = "30/m"
+	defaultPublicCacheTTL  = time.Hour
+	defaultPublicCacheSize = 5000
+	publicSearchLimit      = 10
+)
+
+// publicEndpoint is a public path: the handler serving it and the query
+// parameters passed through
+type publicEndpoint struct {
+	Handler func(*Server, http.ResponseWriter, *http.Request)
+	Params  []string
+}
+
+var publicEndpoints = map[string]publicEndpoint{
+	"species/search": {(*Server).handleSpeciesSearch, []string{"q", "limit"}},
+	"region":         {(*Server).handleTDWG, []string{"lat", "lon"}},
+	"climate":        {(*Server).handleClimatePoint, []string{"lat", "lon"}},
+}
+
+type publicConfig struct {
+	Enabled   bool
+	RateLimit rateLimit
+	CacheTTL  time.Duration
+	CacheSize int
+}
+
+// loadPublicConfig reads PUBLIC_API, PUBLIC_RATE_LIMIT, PUBLIC_CACHE_TTL and
+// PUBLIC_CACHE_ENTRIES
+func loadPublicConfig() publicConfig {
+	c := publicConfig{
+		Enabled:   getEnv("PUBLIC_API", "true") != "false",
+		CacheTTL:  getEnvDuration("PUBLIC_CACHE_TTL", defaultPublicCacheTTL),
+		CacheSize: getEnvInt("PUBLIC_CACHE_ENTRIES", defaultPublicCacheSize),
+	}
+	var err error
+	if c.RateLimit, err = parseRateLimit(getEnv("PUBLIC_RATE_LIMIT", defaultPublicRateLimit)); err != nil {
+		log.Printf("PUBLIC_RATE_LIMIT: %v, using %s", err, defaultPublicRateLimit)
+		c.RateLimit, _ = parseRateLimit(defaultPublicRateLimit)
+	}
+	return c
+}
+
+func isPublicPath(path string) bool {
+	return strings.HasPrefix(path, publicPrefix)
+}
+
+// publicQuery keeps the endpoint's parameters of q, generalizes coordinates
+// to gridDeg and caps the search limit, and sets lang; the result is also
+// the cache key
+func publicQuery(e publicEndpoint, q url.Values, lang string, gridDeg float64) url.Values {
+	out := url.Values{}
+	for _, name := range e.Params {
+		v := strings.TrimSpace(q.Get(name))
+		if v == "" {
+			continue
+		}
+		switch name {
+		case "lat":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				f, _ = generalizeCoordinates(f, 0, gridDeg)
+				v = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+		case "lon":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				_, f = generalizeCoordinates(0, f, gridDeg)
+				v = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+		case "limit":
+			if n, err := strconv.Atoi(v); err == nil && n > publicSearchLimit {
+				v = strconv.Itoa(publicSearchLimit)
+			}
+		}
+		out.Set(name, v)
+	}
+	out.Set("lang", lang)
+	return out
+}
+
+type publicResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// publicCache holds the public responses by path and normalized query, at
+// most max of them; when full, the least recently used entry goes
+type publicCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // Of *publicCacheEntry, in lru
+	lru     list.List                // Most recently used first
+	max     int
+}
+
+type publicCacheEntry struct {
+	key  string
+	resp *publicResponse
+}
+
+func newPublicCache(max int) *publicCache {
+	return &publicCache{entries: make(map[string]*list.Element), max: max}
+}
+
+func (c *publicCache) get(key string, now time.Time) *publicResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*publicCacheEntry)
+	if now.After(e.resp.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e.resp
+}
+
+// put stores resp, evicting the least recently used entries beyond max
+func (c *publicCache) put(key string, resp *publicResponse, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*publicCacheEntry).resp = resp
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&publicCacheEntry{key: key, resp: resp})
+	for len(c.entries) > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*publicCacheEntry).key)
+	}
+}
+
+// publicRecorder buffers a handler's response for the cache
+type publicRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *publicRecorder) Header() http.Header { return r.header }
+
+func (r *publicRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+}
+
+func (r *publicRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+// publicHandler serves /api/public/ under cfg
+func (s *Server) publicHandler(cfg publicConfig) http.Handler {
+	limiter := newRateLimiter()
+	if cfg.RateLimit.Requests > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				limiter.sweep(now.Add(-cfg.RateLimit.Period))
+			}
+		}()
+	}
+	cache := newPublicCache(max(cfg.CacheSize, 1))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, publicPrefix), "/")
+		endpoint, ok := publicEndpoints[name]
+		if !cfg.Enabled || !ok {
+			http.Error(w, `{"error": "Not found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		now := time.Now()
+		if ip := clientIP(r); cfg.RateLimit.Requests > 0 && (ip == nil || !s.cfg.RateLimits.isExempt(ip)) {
+			ok, remaining, wait := limiter.take(ip.String(), cfg.RateLimit, 1, now)
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(cfg.RateLimit.Requests))
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, `{"error": "Rate limit exceeded, retry later"}`, http.StatusTooManyRequests)
+				return
+			}
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		}
+
+		query := publicQuery(endpoint, r.URL.Query(), requestLanguage(r), s.cfg.Coordinates.GridDeg)
+		key := name + "?" + query.Encode()
+		resp := cache.get(key, now)
+		if resp != nil {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+			// A fresh request: no key, cookie or encoding of the caller's
+			inner := r.Clone(r.Context())
+			inner.Method = http.MethodGet
+			inner.Header = http.Header{}
+			inner.URL.RawQuery = query.Encode()
+			rec := &publicRecorder{header: http.Header{}}
+			endpoint.Handler(s, rec, inner)
+
+			resp = &publicResponse{status: rec.status, header: rec.header, body: rec.body.Bytes(), expires: now.Add(cfg.CacheTTL)}
+			if resp.status == 0 {
+				resp.status = http.StatusOK
+			}
+			if (resp.status == http.StatusOK || resp.status == http.StatusNotFound) && cfg.CacheTTL > 0 {
+				cache.put(key, resp, now)
+			}
+		}
+
+		for _, name := range []string{"Content-Type", "Content-Language", "Vary"} {
+			if v := resp.header.Get(name); v != "" {
+				w.Header().Set(name, v)
+			}
+		}
+		if resp.status == http.StatusOK || resp.status == http.StatusNotFound {
+			w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(cfg.CacheTTL.Seconds())))
+		}
+		w.WriteHeader(resp.status)
+		if r.Method != http.MethodHead {
+			w.Write(resp.body)
+		}
+	})
+}
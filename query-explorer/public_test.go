@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPublicQuery(t *testing.T) {
+	q, _ := url.ParseQuery("lat=-23.56174&lon=-46.65612&sql=SELECT+1&api_key=x")
+	got := publicQuery(publicEndpoints["climate"], q, "pt", 0.1)
+	if got.Encode() != "lang=pt&lat=-23.55&lon=-46.65" {
+		t.Errorf("climate query = %s", got.Encode())
+	}
+
+	q, _ = url.ParseQuery("q=ipe&limit=50")
+	if got := publicQuery(publicEndpoints["species/search"], q, "en", 0.1); got.Get("limit") != "10" || got.Get("q") != "ipe" {
+		t.Errorf("search query = %s", got.Encode())
+	}
+}
+
+func TestPublicCache(t *testing.T) {
+	now := time.Now()
+	fresh := func() *publicResponse { return &publicResponse{status: 200, expires: now.Add(time.Hour)} }
+	c := newPublicCache(2)
+	c.put("a", &publicResponse{status: 200, expires: now.Add(-time.Second)}, now)
+	c.put("b", fresh(), now)
+	if c.get("a", now) != nil || c.get("b", now) == nil {
+		t.Error("expired entry served or fresh entry missing")
+	}
+
+	// Full: the least recently used entry goes, not everything
+	c.put("c", fresh(), now)
+	c.get("b", now)
+	c.put("d", fresh(), now)
+	if len(c.entries) != 2 || c.get("b", now) == nil || c.get("d", now) == nil || c.get("c", now) != nil {
+		t.Errorf("eviction kept %d entries, b %v d %v c %v", len(c.entries), c.get("b", now) != nil, c.get("d", now) != nil, c.get("c", now) != nil)
+	}
+}
+
+func TestPublicHandler(t *testing.T) {
+	s := newTestServer()
+	s.cfg.RateLimits.exempt = nil
+	h := s.publicHandler(publicConfig{Enabled: true, RateLimit: rateLimit{Requests: 3, Period: time.Minute}, CacheTTL: time.Hour, CacheSize: 10})
+
+	for _, tc := range []struct {
+		method, url string
+		want        int
+	}{
+		{"GET", "/api/public/query", http.StatusNotFound},
+		{"POST", "/api/public/region", http.StatusMethodNotAllowed},
+		{"GET", "/api/public/region", http.StatusBadRequest}, // No coordinates
+		{"GET", "/api/public/species/search?q=a", http.StatusBadRequest},
+		{"GET", "/api/public/climate", http.StatusBadRequest},
+		{"GET", "/api/public/climate", http.StatusTooManyRequests}, // Fourth counted request
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.url, w.Code, tc.want)
+		}
+		if w.Code == http.StatusBadRequest && w.Header().Get("Cache-Control") != "" {
+			t.Errorf("%s: error response marked cacheable", tc.url)
+		}
+	}
+
+	off := s.publicHandler(publicConfig{})
+	w := httptest.NewRecorder()
+	off.ServeHTTP(w, httptest.NewRequest("GET", "/api/public/region?lat=1&lon=1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("disabled: %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// QUERY AUDIT LOG
+// ============================================================================
+//
+// Every statement run through the query-explorer, whether ad hoc, a saved
+// query or an async job, is recorded in query_audit (migration 026) with its
+// caller, duration, row count and outcome. Rejected statements are recorded
+// too. GET /api/query/history lists the log: users see their own entries,
+// admins everyone's.
+
+// queryAudit collects one query_audit row while a statement runs
+type queryAudit struct {
+	Source       string // adhoc, saved, async
+	SavedQueryID int64
+	JobID        string
+	KeyID        sql.NullInt64
+	RemoteAddr   string
+	SQL          string
+
+	db       *sql.DB
+	start    time.Time
+	status   string
+	rowCount sql.NullInt64
+	err      string
+}
+
+// newQueryAudit starts an audit entry for a request. key is the caller if
+// the handler already authenticated it; otherwise a key sent with the
+// request is looked up, and the entry stays anonymous without one.
+func (s *Server) newQueryAudit(r *http.Request, source string, key *APIKey) *queryAudit {
+	if key == nil && apiKeyFromRequest(r) != "" {
+		key, _ = s.authenticate(r)
+	}
+	a := &queryAudit{Source: source, db: s.db, start: time.Now()}
+	if key != nil {
+		a.KeyID = sql.NullInt64{Int64: key.ID, Valid: true}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		a.RemoteAddr = host
+	}
+	return a
+}
+
+// reject marks a statement refused before it ran
+func (a *queryAudit) reject(err error) {
+	a.status, a.err = "rejected", err.Error()
+}
+
+// finish records the outcome of a statement that ran; rows < 0 means the
+// row count is unknown
+func (a *queryAudit) finish(ctx context.Context, rows int, err error) {
+	if rows >= 0 {
+		a.rowCount = sql.NullInt64{Int64: int64(rows), Valid: true}
+	}
+	switch {
+	case err == nil:
+		a.status = "ok"
+	case isTimeout(ctx, err):
+		a.status, a.err = "timeout", err.Error()
+	default:
+		a.status, a.err = "error", err.Error()
+	}
+}
+
+// record writes the entry in the background; an entry without an outcome
+// is recorded as an error
+func (a *queryAudit) record() {
+	if a.status == "" {
+		a.status = "error"
+	}
+	durationMs := float64(time.Since(a.start).Microseconds()) / 1000
+
+	go func() {
+		// Recorded after the response, so not bound to the request context
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := a.db.ExecContext(ctx, `
+			INSERT INTO query_audit (
+				api_key_id, remote_addr, source, saved_query_id, job_id,
+				sql_text, status, duration_ms, row_count, error
+			) VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, 0), NULLIF($5, ''), $6, $7, $8, $9, NULLIF($10, ''))
+		`, a.KeyID, a.RemoteAddr, a.Source, a.SavedQueryID, a.JobID,
+			a.SQL, a.status, durationMs, a.rowCount, a.err)
+		if err != nil {
+			log.Printf("query audit: %v", err)
+		}
+	}()
+}
+
+type QueryAuditEntry struct {
+	ID           int64   `json:"id"`
+	ExecutedAt   string  `json:"executed_at"`
+	Owner        *string `json:"owner"`
+	RemoteAddr   *string `json:"remote_addr,omitempty"`
+	Source       string  `json:"source"`
+	SavedQueryID *int64  `json:"saved_query_id,omitempty"`
+	JobID        *string `json:"job_id,omitempty"`
+	SQL          string  `json:"sql"`
+	Status       string  `json:"status"`
+	DurationMs   float64 `json:"duration_ms"`
+	RowCount     *int64  `json:"row_count"`
+	Error        *string `json:"error,omitempty"`
+}
+
+var queryAuditList = listSpec{
+	DefaultLimit: 100,
+	MaxLimit:     1000,
+	Sorts: map[string]string{
+		"executed_at": "a.executed_at",
+		"duration_ms": "a.duration_ms",
+		"row_count":   "COALESCE(a.row_count, -1)",
+	},
+	DefaultSort:   "-executed_at",
+	IDColumn:      "a.id",
+	TimeColumn:    "a.executed_at",
+	SearchColumns: []string{"a.sql_text", "a.error"},
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleQueryHistory handles GET /api/query/history. Besides the list
+// parameters it filters on status, source, min_duration_ms and (admins
+// only) api_key_id.
+func (s *Server) handleQueryHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	p, err := parseListParams(r, queryAuditList)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	var keyID int64
+	if key.hasRole(roleAdmin) {
+		if v := query.Get("api_key_id"); v != "" {
+			if keyID, err = strconv.ParseInt(v, 10, 64); err != nil {
+				http.Error(w, `{"error": "api_key_id must be an integer"}`, http.StatusBadRequest)
+				return
+			}
+		}
+	} else {
+		keyID = key.ID
+	}
+	status, source := query.Get("status"), query.Get("source")
+	var minDuration float64
+	if v := query.Get("min_duration_ms"); v != "" {
+		if minDuration, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, `{"error": "min_duration_ms must be a number"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	where, tail, args := p.SQL([]interface{}{keyID, status, source, minDuration})
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT a.id, a.executed_at, k.owner, a.remote_addr, a.source, a.saved_query_id, a.job_id,
+		       a.sql_text, a.status, a.duration_ms, a.row_count, a.error, `+p.CursorColumn()+`
+		FROM query_audit a
+		LEFT JOIN api_keys k ON a.api_key_id = k.id
+		WHERE ($1 = 0 OR a.api_key_id = $1)
+		  AND ($2 = '' OR a.status = $2)
+		  AND ($3 = '' OR a.source = $3)
+		  AND a.duration_ms >= $4`+where+`
+		`+tail, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []QueryAuditEntry{}
+	var keys []listKey
+	for rows.Next() {
+		var e QueryAuditEntry
+		var executedAt time.Time
+		var cursorValue string
+		if err := rows.Scan(&e.ID, &executedAt, &e.Owner, &e.RemoteAddr, &e.Source, &e.SavedQueryID, &e.JobID,
+			&e.SQL, &e.Status, &e.DurationMs, &e.RowCount, &e.Error, &cursorValue); err != nil {
+			s.log.Printf("Error scanning query audit row: %v", err)
+			continue
+		}
+		e.ExecutedAt = executedAt.Format(time.RFC3339)
+		entries = append(entries, e)
+		keys = append(keys, listKey{cursorValue, e.ID})
+	}
+
+	n, next := p.trim(keys)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":     entries[:n],
+		"next_cursor": next,
+	})
+}
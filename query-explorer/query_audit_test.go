@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQueryAuditFinish(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		ctx        context.Context
+		rows       int
+		err        error
+		wantStatus string
+		wantRows   bool
+	}{
+		{context.Background(), 12, nil, "ok", true},
+		{context.Background(), -1, errors.New(`relation "x" does not exist`), "error", false},
+		{context.Background(), 3, errors.New("pq: canceling statement due to statement timeout"), "timeout", true},
+		{cancelled, -1, context.Canceled, "timeout", false},
+	}
+	for _, tt := range tests {
+		a := &queryAudit{}
+		a.finish(tt.ctx, tt.rows, tt.err)
+		if a.status != tt.wantStatus || a.rowCount.Valid != tt.wantRows {
+			t.Errorf("finish(%d, %v): got %s (row count %v), want %s", tt.rows, tt.err, a.status, a.rowCount.Valid, tt.wantStatus)
+		}
+		if tt.err != nil && a.err != tt.err.Error() {
+			t.Errorf("finish(%v): error not kept, got %q", tt.err, a.err)
+		}
+	}
+
+	a := &queryAudit{}
+	a.reject(errors.New("only SELECT queries allowed"))
+	if a.status != "rejected" {
+		t.Errorf("reject: got status %s", a.status)
+	}
+}
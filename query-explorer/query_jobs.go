@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// ASYNCHRONOUS QUERY JOBS
+// ============================================================================
+//
+// Long PostGIS aggregations outlive proxy timeouts, so POST /api/query/async
+// only validates and stores the query (migration 025) and answers 202 with a
+// job ID. Workers claim queued jobs with FOR UPDATE SKIP LOCKED, run them
+// read-only under QUERY_JOB_TIMEOUT and store the result as JSON;
+// GET /api/query/jobs/{id} polls it and DELETE cancels the job. The ID is a
+// random token, like /api/query itself no API key is needed. Finished jobs
+// are deleted by the retention job (retention.go).
+
+const (
+	defaultQueryJobRows = 10000
+	maxQueryJobRows     = 100000
+	queryJobPollEvery   = 5 * time.Second
+)
+
+type QueryJob struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Limit      int             `json:"limit"`
+	CreatedAt  string          `json:"created_at"`
+	StartedAt  *string         `json:"started_at,omitempty"`
+	FinishedAt *string         `json:"finished_at,omitempty"`
+	Error      *string         `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+}
+
+// queryJobQueue wakes a server's workers and tracks the jobs they run
+type queryJobQueue struct {
+	sync.Mutex
+	wake    chan struct{}
+	running map[string]context.CancelFunc
+}
+
+func newQueryJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// wakeQueryJobWorkers nudges an idle worker without blocking
+func (s *Server) wakeQueryJobWorkers() {
+	select {
+	case s.jobs.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) startQueryJobWorkers(workers int, timeout time.Duration) {
+	if workers <= 0 {
+		return
+	}
+
+	// Jobs running when the previous process stopped will never finish
+	if _, err := s.db.ExecContext(context.Background(), `
+		UPDATE query_jobs SET status = 'failed', error = 'interrupted by server restart', finished_at = NOW()
+		WHERE status = 'running'
+	`); err != nil {
+		s.log.Printf("Error resetting interrupted query jobs: %v", err)
+	}
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			ticker := time.NewTicker(queryJobPollEvery)
+			defer ticker.Stop()
+			for {
+				// Drain the queue before waiting again
+				for s.runNextQueryJob(timeout) {
+				}
+				select {
+				case <-s.jobs.wake:
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	s.log.Printf("Query job workers started: %d (timeout %s)", workers, timeout)
+}
+
+// runNextQueryJob claims and runs the oldest queued job; false if none
+func (s *Server) runNextQueryJob(timeout time.Duration) bool {
+	var id, query string
+	var limit int
+	var createdBy sql.NullInt64
+	err := s.db.QueryRowContext(context.Background(), `
+		UPDATE query_jobs SET status = 'running', started_at = NOW()
+		WHERE id = (
+			SELECT id FROM query_jobs WHERE status = 'queued'
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, sql_text, row_limit, created_by
+	`).Scan(&id, &query, &limit, &createdBy)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		s.log.Printf("Error claiming query job: %v", err)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	s.jobs.Lock()
+	s.jobs.running[id] = cancel
+	s.jobs.Unlock()
+	defer func() {
+		s.jobs.Lock()
+		delete(s.jobs.running, id)
+		s.jobs.Unlock()
+		cancel()
+	}()
+
+	audit := &queryAudit{Source: "async", JobID: id, KeyID: createdBy, SQL: query, db: s.db, start: time.Now()}
+	defer audit.record()
+
+	result, rowCount, err := s.executeQueryJob(ctx, query, limit)
+	audit.finish(ctx, rowCount, err)
+	status, stored, errText := "succeeded", sql.NullString{String: string(result), Valid: true}, sql.NullString{}
+	if err != nil {
+		status, stored = "failed", sql.NullString{}
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("query cancelled: exceeded job timeout of %s", timeout)
+		}
+		errText = sql.NullString{String: err.Error(), Valid: true}
+	}
+
+	// A job cancelled while running keeps its 'cancelled' status
+	if _, err := s.db.ExecContext(context.Background(), `
+		UPDATE query_jobs SET status = $2, result = $3, error = $4, finished_at = NOW()
+		WHERE id = $1 AND status = 'running'
+	`, id, status, stored, errText); err != nil {
+		s.log.Printf("Error storing query job %s result: %v", id, err)
+	}
+	return true
+}
+
+// executeQueryJob runs a validated query and returns the QueryResponse JSON
+// and its row count (-1 if the query failed before returning rows)
+func (s *Server) executeQueryJob(ctx context.Context, query string, limit int) ([]byte, int, error) {
+	validated, err := validateReadOnlyQuery(query)
+	if err != nil {
+		return nil, -1, err
+	}
+	query = limitQuery(query, validated, limit)
+
+	start := time.Now()
+	tx, err := s.beginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, -1, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer rows.Close()
+
+	columns, _ := rows.Columns()
+	resp := QueryResponse{
+		Columns: columns,
+		Rows:    [][]interface{}{},
+	}
+	types := queryColumnTypes(rows)
+	for rows.Next() {
+		row, err := scanQueryRow(rows, types)
+		if err != nil {
+			return nil, len(resp.Rows), err
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, len(resp.Rows), err
+	}
+
+	resp.RowCount = len(resp.Rows)
+	resp.QueryTime = time.Since(start).String()
+	result, err := json.Marshal(resp)
+	return result, resp.RowCount, err
+}
+
+func (s *Server) getQueryJob(ctx context.Context, id string) (QueryJob, error) {
+	var job QueryJob
+	var createdAt time.Time
+	var startedAt, finishedAt sql.NullTime
+	var result []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, status, row_limit, created_at, started_at, finished_at, error, result
+		FROM query_jobs WHERE id = $1
+	`, id).Scan(&job.ID, &job.Status, &job.Limit, &createdAt, &startedAt, &finishedAt, &job.Error, &result)
+	if err != nil {
+		return job, err
+	}
+
+	job.CreatedAt = createdAt.Format(time.RFC3339)
+	if startedAt.Valid {
+		s := startedAt.Time.Format(time.RFC3339)
+		job.StartedAt = &s
+	}
+	if finishedAt.Valid {
+		s := finishedAt.Time.Format(time.RFC3339)
+		job.FinishedAt = &s
+	}
+	if result != nil {
+		job.Result = result
+	}
+	return job, nil
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleQueryAsync handles POST /api/query/async
+func (s *Server) handleQueryAsync(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req QueryRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	// The key is optional; it only records who submitted the job
+	var key *APIKey
+	if apiKeyFromRequest(r) != "" {
+		key, _ = s.authenticate(r)
+	}
+
+	// Rejected up front so the caller does not poll for a validation error
+	req.SQL = strings.TrimSpace(req.SQL)
+	if _, err := validateReadOnlyQuery(req.SQL); err != nil {
+		audit := s.newQueryAudit(r, "async", key)
+		audit.SQL = req.SQL
+		audit.reject(err)
+		audit.record()
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusForbidden)
+		return
+	}
+	if req.Limit <= 0 || req.Limit > maxQueryJobRows {
+		req.Limit = defaultQueryJobRows
+	}
+
+	var createdBy sql.NullInt64
+	if key != nil {
+		createdBy = sql.NullInt64{Int64: key.ID, Valid: true}
+	}
+
+	id, err := newQueryJobID()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to create job"}`, http.StatusInternalServerError)
+		return
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO query_jobs (id, sql_text, row_limit, created_by) VALUES ($1, $2, $3, $4)
+	`, id, req.SQL, req.Limit, createdBy); err != nil {
+		s.log.Printf("Error creating query job: %v", err)
+		http.Error(w, `{"error": "Failed to create job"}`, http.StatusInternalServerError)
+		return
+	}
+	s.wakeQueryJobWorkers()
+
+	w.Header().Set("Location", "/api/query/jobs/"+id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     id,
+		"status": "queued",
+		"url":    "/api/query/jobs/" + id,
+	})
+}
+
+// handleQueryJob handles /api/query/jobs/{id}: GET polls status and result,
+// DELETE cancels a queued or running job
+func (s *Server) handleQueryJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	id := pathParam(r, "id")
+
+	switch r.Method {
+	case http.MethodGet:
+		job, err := s.getQueryJob(ctx, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, `{"error": "Job not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if job.Status == "queued" || job.Status == "running" {
+			w.Header().Set("Retry-After", "2")
+		}
+		json.NewEncoder(w).Encode(job)
+
+	case http.MethodDelete:
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE query_jobs SET status = 'cancelled', error = 'cancelled by client', finished_at = NOW()
+			WHERE id = $1 AND status IN ('queued', 'running')
+		`, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, `{"error": "Job not found or already finished"}`, http.StatusNotFound)
+			return
+		}
+
+		s.jobs.Lock()
+		if cancel, ok := s.jobs.running[id]; ok {
+			cancel()
+		}
+		s.jobs.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
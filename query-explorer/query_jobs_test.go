@@ -0,0 +1,202 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeQueryJob is the stored state of one query job behind a fakeDB: the
+// statements of query_jobs.go update it as Postgres would
+type fakeQueryJob struct {
+	mu     sync.Mutex
+	status string
+	result interface{}
+}
+
+func (j *fakeQueryJob) get() (string, interface{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status, j.result
+}
+
+// transition moves the job to status if it is in one of from
+func (j *fakeQueryJob) transition(status string, from ...string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, f := range from {
+		if j.status == f {
+			j.status = status
+			return true
+		}
+	}
+	return false
+}
+
+func (j *fakeQueryJob) queries(sqlText string) []fakeQuery {
+	return []fakeQuery{
+		{
+			match:   "SET status = 'running', started_at = NOW()",
+			when:    func([]driver.Value) bool { return j.transition("running", "queued") },
+			columns: []string{"id", "sql_text", "row_limit", "created_by"},
+			rows:    [][]driver.Value{{"job1", sqlText, int64(100), nil}},
+		},
+		{match: "SET status = 'running', started_at = NOW()", columns: []string{"id"}},
+		{
+			match: "SET status = 'cancelled'",
+			when:  func([]driver.Value) bool { return j.transition("cancelled", "queued", "running") },
+			rows:  [][]driver.Value{{}},
+		},
+		{match: "SET status = 'cancelled'"},
+		{
+			match: "WHERE id = $1 AND status = 'running'",
+			when: func(args []driver.Value) bool {
+				j.mu.Lock()
+				defer j.mu.Unlock()
+				if j.status == "running" {
+					j.status, j.result = args[1].(string), args[2]
+				}
+				return true
+			},
+		},
+		{
+			// Only reached if the result is stored without the guard
+			match: "UPDATE query_jobs SET status = $2",
+			when: func(args []driver.Value) bool {
+				j.mu.Lock()
+				defer j.mu.Unlock()
+				j.status, j.result = args[1].(string), args[2]
+				return true
+			},
+		},
+		{match: "SET LOCAL statement_timeout"},
+		{match: "INSERT INTO query_audit"},
+	}
+}
+
+func TestQueryAsyncEnqueues(t *testing.T) {
+	s, db := newFakeDBServer(t, fakeQuery{match: "INSERT INTO query_jobs"}, fakeQuery{match: "INSERT INTO query_audit"})
+
+	req := httptest.NewRequest("POST", "/api/query/async", strings.NewReader(`{"sql": "SELECT canonical_name FROM species"}`))
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("%d %s, want 202", w.Code, w.Body.String())
+	}
+	var resp struct{ ID, Status, URL string }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Status != "queued" || len(resp.ID) != 32 {
+		t.Fatalf("response %s: %v", w.Body.String(), err)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/query/jobs/"+resp.ID || resp.URL != loc {
+		t.Errorf("Location %q, url %q", loc, resp.URL)
+	}
+	args := db.argsOf("INSERT INTO query_jobs")
+	if len(args) != 4 || args[0] != resp.ID || args[1] != "SELECT canonical_name FROM species" || args[2] != int64(defaultQueryJobRows) || args[3] != nil {
+		t.Errorf("job inserted with %v", args)
+	}
+	select {
+	case <-s.jobs.wake:
+	default:
+		t.Error("workers not woken")
+	}
+
+	req = httptest.NewRequest("POST", "/api/query/async", strings.NewReader(`{"sql": "DELETE FROM species"}`))
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || len(db.executed("INSERT INTO query_jobs")) != 1 {
+		t.Errorf("write query: %d %s, want 403 and no job", w.Code, w.Body.String())
+	}
+}
+
+func TestQueryJobPoll(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s, _ := newFakeDBServer(t,
+		fakeQuery{
+			match:   "FROM query_jobs WHERE id = $1",
+			when:    func(args []driver.Value) bool { return args[0] == "queued1" },
+			columns: []string{"id", "status", "row_limit", "created_at", "started_at", "finished_at", "error", "result"},
+			rows:    [][]driver.Value{{"queued1", "queued", int64(100), created, nil, nil, nil, nil}},
+		},
+		fakeQuery{
+			match:   "FROM query_jobs WHERE id = $1",
+			when:    func(args []driver.Value) bool { return args[0] == "done1" },
+			columns: []string{"id", "status", "row_limit", "created_at", "started_at", "finished_at", "error", "result"},
+			rows:    [][]driver.Value{{"done1", "succeeded", int64(100), created, created, created, nil, []byte(`{"row_count":1}`)}},
+		},
+		fakeQuery{match: "FROM query_jobs WHERE id = $1", columns: []string{"id"}},
+	)
+
+	for _, tc := range []struct {
+		id, retryAfter, body string
+		code                 int
+	}{
+		{"queued1", "2", `"status":"queued"`, http.StatusOK},
+		{"done1", "", `"result":{"row_count":1}`, http.StatusOK},
+		{"missing", "", "Job not found", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/query/jobs/"+tc.id, nil))
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.body) || w.Header().Get("Retry-After") != tc.retryAfter {
+			t.Errorf("%s: %d %s (Retry-After %q)", tc.id, w.Code, w.Body.String(), w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestQueryJobRun(t *testing.T) {
+	job := &fakeQueryJob{status: "queued"}
+	s, _ := newFakeDBServer(t, append(job.queries("SELECT 42 AS answer"),
+		fakeQuery{match: "SELECT 42 AS answer", columns: []string{"answer"}, rows: [][]driver.Value{{int64(42)}}})...)
+
+	if !s.runNextQueryJob(time.Minute) {
+		t.Fatal("no job claimed")
+	}
+	status, result := job.get()
+	if status != "succeeded" || !strings.Contains(result.(string), `"rows":[[42]]`) {
+		t.Errorf("job %s with %s", status, result)
+	}
+	if s.runNextQueryJob(time.Minute) {
+		t.Error("claimed a job from an empty queue")
+	}
+}
+
+func TestQueryJobCancel(t *testing.T) {
+	job := &fakeQueryJob{status: "queued"}
+	var s *Server
+	var cancelCode int
+	// The client cancels while the job's query runs
+	s, _ = newFakeDBServer(t, append(job.queries("SELECT 42 AS answer"),
+		fakeQuery{
+			match: "SELECT 42 AS answer",
+			when: func([]driver.Value) bool {
+				w := httptest.NewRecorder()
+				s.router().ServeHTTP(w, httptest.NewRequest("DELETE", "/api/query/jobs/job1", nil))
+				cancelCode = w.Code
+				return true
+			},
+			columns: []string{"answer"},
+			rows:    [][]driver.Value{{int64(42)}},
+		})...)
+
+	s.runNextQueryJob(time.Minute)
+	if cancelCode != http.StatusNoContent {
+		t.Errorf("cancel while running: %d, want 204", cancelCode)
+	}
+	if status, result := job.get(); status != "cancelled" || result != nil {
+		t.Errorf("cancelled job stored as %s with %v", status, result)
+	}
+	if len(s.jobs.running) != 0 {
+		t.Errorf("jobs still tracked as running: %v", s.jobs.running)
+	}
+
+	// A finished job cannot be cancelled
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("DELETE", "/api/query/jobs/job1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("cancel after finishing: %d, want 404", w.Code)
+	}
+}
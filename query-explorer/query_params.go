@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// ============================================================================
+// SAVED QUERY PARAMETERS
+// ============================================================================
+//
+// A saved query may use named parameters, :tdwg_code or :limit, instead of
+// values pasted into the SQL. They are found with the Postgres scanner, so
+// text inside string literals, comments and ::casts is left alone, and are
+// rewritten to $1, $2, ... placeholders: values are always bound by the
+// driver, never interpolated. A parameter may declare a type (the
+// placeholder is cast to it and values are checked before the query runs)
+// and a default; without a default it is required.
+
+type QueryParam struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type,omitempty"` // queryParamTypes; empty lets Postgres infer it
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+// queryParamTypes maps declared types to the cast added to the placeholder
+var queryParamTypes = map[string]string{
+	"text":      "text",
+	"integer":   "bigint",
+	"numeric":   "numeric",
+	"boolean":   "boolean",
+	"date":      "date",
+	"timestamp": "timestamptz",
+}
+
+var queryParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// compileQueryParams rewrites the :name parameters of query to numbered
+// placeholders. It returns the parameters in placeholder order, taking type
+// and default from declared, which may only name parameters the query uses.
+func compileQueryParams(query string, declared []QueryParam) (string, []QueryParam, error) {
+	scanned, err := pg_query.Scan(query)
+	if err != nil {
+		return "", nil, fmt.Errorf("syntax error: %s", err.Error())
+	}
+
+	specs := make(map[string]QueryParam, len(declared))
+	for _, p := range declared {
+		if !queryParamName.MatchString(p.Name) {
+			return "", nil, fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+		p.Name = strings.ToLower(p.Name)
+		if _, dup := specs[p.Name]; dup {
+			return "", nil, fmt.Errorf("parameter %q declared twice", p.Name)
+		}
+		if _, ok := queryParamTypes[p.Type]; p.Type != "" && !ok {
+			return "", nil, fmt.Errorf("parameter %q: unknown type %q (one of %s)", p.Name, p.Type, strings.Join(sortedKeys(queryParamTypes), ", "))
+		}
+		if p.Default != nil {
+			if _, err := queryParamValue(p, p.Default); err != nil {
+				return "", nil, fmt.Errorf("default of %s", err.Error())
+			}
+		}
+		specs[p.Name] = p
+	}
+
+	var out strings.Builder
+	var params []QueryParam
+	index := map[string]int{}
+	last, depth := 0, 0
+	tokens := scanned.Tokens
+	for i, t := range tokens {
+		switch t.Token {
+		case pg_query.Token_PARAM:
+			return "", nil, fmt.Errorf("positional parameters ($1) are not allowed, use :name")
+		case pg_query.Token_ASCII_91: // [
+			depth++
+		case pg_query.Token_ASCII_93: // ]
+			depth--
+		case pg_query.Token_ASCII_58: // :
+			// Inside brackets a colon is an array slice (a[lo:hi])
+			if depth > 0 || i+1 == len(tokens) || tokens[i+1].Start != t.End {
+				continue
+			}
+			next := tokens[i+1]
+			name := query[next.Start:next.End]
+			if !queryParamName.MatchString(name) {
+				continue
+			}
+			name = strings.ToLower(name)
+
+			n, seen := index[name]
+			if !seen {
+				p, ok := specs[name]
+				if !ok {
+					p = QueryParam{Name: name}
+				}
+				params = append(params, p)
+				n = len(params)
+				index[name] = n
+			}
+			placeholder := "$" + strconv.Itoa(n)
+			if cast := queryParamTypes[params[n-1].Type]; cast != "" {
+				placeholder += "::" + cast
+			}
+			out.WriteString(query[last:t.Start])
+			out.WriteString(placeholder)
+			last = int(next.End)
+		}
+	}
+	out.WriteString(query[last:])
+
+	for name := range specs {
+		if _, ok := index[name]; !ok {
+			return "", nil, fmt.Errorf("parameter %q is not used in the query", name)
+		}
+	}
+	return out.String(), params, nil
+}
+
+// bindQueryParams returns the placeholder arguments for params from the
+// values sent by the caller
+func bindQueryParams(params []QueryParam, values map[string]interface{}) ([]interface{}, error) {
+	known := make(map[string]bool, len(params))
+	args := make([]interface{}, len(params))
+	for i, p := range params {
+		known[p.Name] = true
+		v, ok := values[p.Name]
+		if !ok {
+			if p.Default == nil {
+				return nil, fmt.Errorf("parameter %q required", p.Name)
+			}
+			v = p.Default
+		}
+		arg, err := queryParamValue(p, v)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown parameters: %s", strings.Join(unknown, ", "))
+	}
+	return args, nil
+}
+
+// queryParamValue checks a JSON value (numbers are json.Number) against the
+// declared type and converts it for the driver; null binds SQL NULL
+func queryParamValue(p QueryParam, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	invalid := func() error {
+		what := p.Type
+		if what == "" {
+			what = "a string, number or boolean"
+		}
+		return fmt.Errorf("parameter %q: expected %s, got %v", p.Name, what, v)
+	}
+
+	switch p.Type {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, invalid()
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return nil, invalid()
+		}
+		return i, nil
+	case "numeric":
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, invalid()
+		}
+		return n.String(), nil
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, invalid()
+		}
+		return b, nil
+	case "date", "timestamp":
+		s, ok := v.(string)
+		if !ok {
+			return nil, invalid()
+		}
+		if _, err := time.Parse("2006-01-02", s); err == nil {
+			return s, nil
+		}
+		if _, err := time.Parse(time.RFC3339, s); err == nil && p.Type == "timestamp" {
+			return s, nil
+		}
+		return nil, invalid()
+	case "text":
+		s, ok := v.(string)
+		if !ok {
+			return nil, invalid()
+		}
+		return s, nil
+	}
+
+	switch v := v.(type) {
+	case string, bool:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return nil, invalid()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileQueryParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		declared []QueryParam
+		want     string
+		params   []string
+	}{
+		{
+			name:   "named",
+			query:  "SELECT * FROM species_regions WHERE tdwg_code = :tdwg_code LIMIT :limit",
+			want:   "SELECT * FROM species_regions WHERE tdwg_code = $1 LIMIT $2",
+			params: []string{"tdwg_code", "limit"},
+		},
+		{
+			name:   "repeated",
+			query:  "SELECT :x AS a, :X AS b",
+			want:   "SELECT $1 AS a, $1 AS b",
+			params: []string{"x"},
+		},
+		{
+			name:   "casts, literals and comments untouched",
+			query:  "SELECT ':not_a_param', 1::int /* :nor */, x::text -- :this\nFROM t WHERE y = :y",
+			want:   "SELECT ':not_a_param', 1::int /* :nor */, x::text -- :this\nFROM t WHERE y = $1",
+			params: []string{"y"},
+		},
+		{
+			name:   "array slices",
+			query:  "SELECT a[lo:hi], a[1:2] FROM t WHERE n = :n",
+			want:   "SELECT a[lo:hi], a[1:2] FROM t WHERE n = $1",
+			params: []string{"n"},
+		},
+		{
+			name:     "declared type adds a cast",
+			query:    "SELECT * FROM species WHERE id > :min_id",
+			declared: []QueryParam{{Name: "min_id", Type: "integer"}},
+			want:     "SELECT * FROM species WHERE id > $1::bigint",
+			params:   []string{"min_id"},
+		},
+	}
+	for _, tt := range tests {
+		got, params, err := compileQueryParams(tt.query, tt.declared)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+		var names []string
+		for _, p := range params {
+			names = append(names, p.Name)
+		}
+		if !reflect.DeepEqual(names, tt.params) {
+			t.Errorf("%s: params %v, want %v", tt.name, names, tt.params)
+		}
+		if _, err := validateReadOnlyQuery(got); err != nil {
+			t.Errorf("%s: compiled query rejected: %v", tt.name, err)
+		}
+	}
+}
+
+func TestCompileQueryParamsErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		declared []QueryParam
+		want     string
+	}{
+		{"SELECT * FROM t WHERE a = $1", nil, "positional"},
+		{"SELECT :a", []QueryParam{{Name: "b"}}, "not used"},
+		{"SELECT :a", []QueryParam{{Name: "a", Type: "json"}}, "unknown type"},
+		{"SELECT :a", []QueryParam{{Name: "a"}, {Name: "A"}}, "declared twice"},
+		{"SELECT :a", []QueryParam{{Name: "a; DROP"}}, "invalid parameter name"},
+		{"SELECT :a", []QueryParam{{Name: "a", Type: "integer", Default: "x"}}, "default"},
+	}
+	for _, tt := range tests {
+		_, _, err := compileQueryParams(tt.query, tt.declared)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("compileQueryParams(%q, %+v) = %v, want error containing %q", tt.query, tt.declared, err, tt.want)
+		}
+	}
+}
+
+func TestBindQueryParams(t *testing.T) {
+	params := []QueryParam{
+		{Name: "tdwg_code", Type: "text"},
+		{Name: "limit", Type: "integer", Default: json.Number("10")},
+		{Name: "since", Type: "date", Default: "2020-01-01"},
+		{Name: "any"},
+	}
+
+	args, err := bindQueryParams(params, map[string]interface{}{
+		"tdwg_code": "BZS'; DROP TABLE species; --",
+		"any":       json.Number("1.5"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"BZS'; DROP TABLE species; --", int64(10), "2020-01-01", "1.5"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %#v, want %#v", args, want)
+	}
+
+	errors := []map[string]interface{}{
+		{},                                    // tdwg_code required
+		{"tdwg_code": "BZS", "other": "x"},    // unknown
+		{"tdwg_code": json.Number("1")},       // not text
+		{"tdwg_code": "BZS", "limit": "ten"},  // not integer
+		{"tdwg_code": "BZS", "since": "soon"}, // not a date
+		{"tdwg_code": "BZS", "any": []interface{}{"a"}},
+	}
+	for _, values := range errors {
+		if _, err := bindQueryParams(params, values); err == nil {
+			t.Errorf("bindQueryParams(%v): expected an error", values)
+		}
+	}
+}
+
+func BenchmarkCompileQueryParams(b *testing.B) {
+	query := `SELECT s.canonical_name, sr.tdwg_code
+		FROM species s JOIN species_regions sr ON sr.species_id = s.id
+		WHERE sr.tdwg_code = :tdwg_code AND s.family = ANY(:families) -- :not_a_param
+		ORDER BY s.canonical_name LIMIT :limit`
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := compileQueryParams(query, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ============================================================================
+// STREAMING QUERY RESULTS
+// ============================================================================
+//
+// POST /api/query?format=ndjson writes one JSON object per row, keys in column
+// order, as rows are scanned; nothing is buffered beyond the current row. The
+// response is flushed every flush_every rows (default 1000). A failure after
+// the first row can no longer change the status code, so it is reported as a
+// final {"error": "..."} line.
+
+const (
+	defaultStreamQueryRows  = 100000
+	maxStreamQueryRows      = 1000000
+	defaultStreamFlushEvery = 1000
+)
+
+// queryColumnTypes returns the Postgres type name of each result column
+func queryColumnTypes(rows *sql.Rows) []string {
+	columnTypes, _ := rows.ColumnTypes()
+	types := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		types[i] = ct.DatabaseTypeName()
+	}
+	return types
+}
+
+// scanQueryRow scans the current row into JSON-friendly values (see
+// queryJSONValue)
+func scanQueryRow(rows *sql.Rows, types []string) ([]interface{}, error) {
+	values := make([]interface{}, len(types))
+	valuePtrs := make([]interface{}, len(types))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	err := rows.Scan(valuePtrs...)
+
+	for i, v := range values {
+		values[i] = queryJSONValue(v, types[i])
+	}
+	return values, err
+}
+
+// streamQueryNDJSON returns the number of rows written and the error that
+// ended the stream, if any
+func streamQueryNDJSON(w http.ResponseWriter, r *http.Request, rows *sql.Rows, columns []string) (int, error) {
+	flushEvery := defaultStreamFlushEvery
+	if n, err := strconv.Atoi(r.URL.Query().Get("flush_every")); err == nil && n > 0 {
+		flushEvery = n
+	}
+	flusher, _ := w.(http.Flusher)
+
+	// Column names are encoded once; each line is assembled in key order
+	keys := make([][]byte, len(columns))
+	for i, c := range columns {
+		keys[i], _ = json.Marshal(c)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+
+	types := queryColumnTypes(rows)
+	var line bytes.Buffer
+	count := 0
+	fail := func(err error) {
+		msg, _ := json.Marshal(map[string]string{"error": err.Error()})
+		w.Write(append(msg, '\n'))
+	}
+
+	for rows.Next() {
+		row, err := scanQueryRow(rows, types)
+		if err != nil {
+			fail(err)
+			return count, err
+		}
+
+		line.Reset()
+		line.WriteByte('{')
+		for i, v := range row {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.Write(keys[i])
+			line.WriteByte(':')
+			value, err := json.Marshal(v)
+			if err != nil {
+				value = []byte("null")
+			}
+			line.Write(value)
+		}
+		line.WriteString("}\n")
+
+		if _, err := w.Write(line.Bytes()); err != nil {
+			// Client went away; the request context cancels the query
+			log.Printf("Query stream aborted after %d rows: %v", count, err)
+			return count, err
+		}
+		count++
+		if flusher != nil && count%flushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	err := rows.Err()
+	if err != nil {
+		fail(err)
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return count, err
+}
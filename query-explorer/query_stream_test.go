@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQueryNDJSONStream(t *testing.T) {
+	rows := [][]driver.Value{{int64(1), "Inga edulis"}, {int64(2), nil}, {int64(3), "Ocotea porosa"}}
+	for _, tc := range []struct {
+		name    string
+		rowsErr error
+		want    []string
+	}{
+		{"complete", nil, []string{
+			`{"species_id":1,"canonical_name":"Inga edulis"}`,
+			`{"species_id":2,"canonical_name":null}`,
+			`{"species_id":3,"canonical_name":"Ocotea porosa"}`,
+		}},
+		{"failed", errors.New("canceling statement due to statement timeout"), []string{
+			`{"species_id":1,"canonical_name":"Inga edulis"}`,
+			`{"species_id":2,"canonical_name":null}`,
+			`{"species_id":3,"canonical_name":"Ocotea porosa"}`,
+			`{"error":"canceling statement due to statement timeout"}`,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newFakeDBServer(t,
+				fakeQuery{
+					match:   "SELECT id AS species_id, canonical_name FROM species",
+					columns: []string{"species_id", "canonical_name"},
+					rows:    rows,
+					rowsErr: tc.rowsErr,
+				},
+				fakeQuery{match: "INSERT INTO query_audit"},
+			)
+			body := `{"sql": "SELECT id AS species_id, canonical_name FROM species"}`
+			req := httptest.NewRequest("POST", "/api/query?format=ndjson&flush_every=2", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			s.router().ServeHTTP(w, req)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+				t.Fatalf("%d %s (%s)", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+			}
+
+			var got []string
+			scanner := bufio.NewScanner(w.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if !json.Valid([]byte(line)) {
+					t.Errorf("line %d is not JSON: %q", len(got)+1, line)
+				}
+				got = append(got, line)
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("stream:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ============================================================================
+// GROWTH-FORM QUOTAS
+// ============================================================================
+//
+// growth_form_quotas asks for a composition, {"tree": 0.5, "shrub": 0.3,
+// "forb": 0.2}, instead of whatever mix maximizes diversity. Shares become
+// target counts of n_species (largest remainder), and the greedy selection
+// only considers candidates whose growth form still has room: its own
+// target, or the slots no quota claims when the shares sum to less than 1.
+// A form that runs out of candidates gives its unfilled slots back to the
+// other forms, so the selection still reaches n_species when it can. The
+// achieved composition is in DiversityMetrics.GrowthFormComposition.
+
+// normalizeGrowthFormQuotas validates req.GrowthFormQuotas
+func normalizeGrowthFormQuotas(req *RecommendRequest) error {
+	if len(req.GrowthFormQuotas) == 0 {
+		req.GrowthFormQuotas = nil
+		return nil
+	}
+	sum := 0.0
+	for form, share := range req.GrowthFormQuotas {
+		if !validGrowthForms[form] {
+			return fmt.Errorf("growth_form_quotas: invalid growth form %q", form)
+		}
+		if math.IsNaN(share) || share < 0 || share > 1 {
+			return fmt.Errorf("growth_form_quotas.%s must be between 0 and 1", form)
+		}
+		sum += share
+	}
+	if sum > 1+1e-9 {
+		return fmt.Errorf("growth_form_quotas sum to %.3g, more than 1", sum)
+	}
+	if req.NSpecies == 0 {
+		return fmt.Errorf("growth_form_quotas require n_species")
+	}
+	return nil
+}
+
+// growthFormTargets turns quota shares into species counts out of n
+func growthFormTargets(quotas map[string]float64, n int) map[string]int {
+	forms := make([]string, 0, len(quotas))
+	sum := 0.0
+	for form, share := range quotas {
+		forms = append(forms, form)
+		sum += share
+	}
+	sort.Strings(forms)
+
+	targets := make(map[string]int, len(quotas))
+	assigned := 0
+	for _, form := range forms {
+		targets[form] = int(quotas[form] * float64(n))
+		assigned += targets[form]
+	}
+
+	// Hand the rounded-down slots to the largest remainders
+	byRemainder := append([]string(nil), forms...)
+	sort.SliceStable(byRemainder, func(i, j int) bool {
+		ri := quotas[byRemainder[i]]*float64(n) - float64(targets[byRemainder[i]])
+		rj := quotas[byRemainder[j]]*float64(n) - float64(targets[byRemainder[j]])
+		return ri > rj
+	})
+	total := int(math.Round(sum * float64(n)))
+	for i := 0; assigned < total && i < len(byRemainder); i++ {
+		targets[byRemainder[i]]++
+		assigned++
+	}
+	return targets
+}
+
+// quotaTracker follows how many slots each growth form has left during
+// greedyDiversitySelection; a nil tracker allows everything
+type quotaTracker struct {
+	targets   map[string]int
+	used      map[string]int // Quota slots taken per form
+	free      int            // Slots left that no quota claims
+	available map[string]int // Candidates left per form
+}
+
+func newQuotaTracker(quotas map[string]float64, n int, candidates []SpeciesRecommendation) *quotaTracker {
+	if len(quotas) == 0 {
+		return nil
+	}
+	q := &quotaTracker{
+		targets:   growthFormTargets(quotas, n),
+		used:      map[string]int{},
+		free:      n,
+		available: map[string]int{},
+	}
+	for _, t := range q.targets {
+		q.free -= t
+	}
+	for _, c := range candidates {
+		q.available[c.GrowthForm]++
+	}
+	return q
+}
+
+// allows reports whether a species of form may be added
+func (q *quotaTracker) allows(form string) bool {
+	return q == nil || q.used[form] < q.targets[form] || q.free > 0
+}
+
+// take records a species of form being added, whether it was a candidate
+// (counted in available) or locked in beforehand
+func (q *quotaTracker) take(form string, candidate bool) {
+	if q == nil {
+		return
+	}
+	if candidate {
+		q.available[form]--
+	}
+	if q.used[form] < q.targets[form] {
+		q.used[form]++
+	} else {
+		q.free--
+	}
+}
+
+// release frees the unfilled slots of forms without candidates left
+func (q *quotaTracker) release() {
+	if q == nil {
+		return
+	}
+	for form, t := range q.targets {
+		if q.available[form] <= 0 && q.used[form] < t {
+			q.free += t - q.used[form]
+			q.targets[form] = q.used[form]
+		}
+	}
+}
+
+// growthFormComposition returns the share of each growth form in counts
+func growthFormComposition(counts map[string]int, n int) map[string]float64 {
+	if n == 0 {
+		return nil
+	}
+	shares := make(map[string]float64, len(counts))
+	for form, c := range counts {
+		shares[form] = math.Round(float64(c)/float64(n)*1000) / 1000
+	}
+	return shares
+}
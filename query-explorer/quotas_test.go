@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGrowthFormTargets(t *testing.T) {
+	tests := []struct {
+		quotas map[string]float64
+		n      int
+		want   map[string]int
+	}{
+		{map[string]float64{"tree": 0.5, "shrub": 0.3, "forb": 0.2}, 10, map[string]int{"tree": 5, "shrub": 3, "forb": 2}},
+		// Equal remainders: the slot goes to the first form by name
+		{map[string]float64{"tree": 0.5, "shrub": 0.5}, 5, map[string]int{"shrub": 3, "tree": 2}},
+		{map[string]float64{"tree": 0.34, "shrub": 0.33, "forb": 0.33}, 4, map[string]int{"tree": 2, "shrub": 1, "forb": 1}},
+		{map[string]float64{"palm": 0.25}, 7, map[string]int{"palm": 2}},
+	}
+	for _, tt := range tests {
+		if got := growthFormTargets(tt.quotas, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("growthFormTargets(%v, %d) = %v, want %v", tt.quotas, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeGrowthFormQuotas(t *testing.T) {
+	for _, quotas := range []map[string]float64{
+		{"tree": 0.7, "shrub": 0.5},
+		{"cactus": 0.5},
+		{"tree": -0.5},
+	} {
+		req := RecommendRequest{NSpecies: 10, GrowthFormQuotas: quotas}
+		if err := normalizeGrowthFormQuotas(&req); err == nil {
+			t.Errorf("normalizeGrowthFormQuotas(%v): expected an error", quotas)
+		}
+	}
+	req := RecommendRequest{GrowthFormQuotas: map[string]float64{"tree": 1}}
+	if err := normalizeGrowthFormQuotas(&req); err == nil {
+		t.Error("quotas without n_species accepted")
+	}
+}
+
+func growthFormCounts(selected []SpeciesRecommendation) map[string]int {
+	counts := map[string]int{}
+	for _, sp := range selected {
+		counts[sp.GrowthForm]++
+	}
+	return counts
+}
+
+func TestGreedySelectionEnforcesQuotas(t *testing.T) {
+	candidates, traits := testPool()
+
+	// The pool is mostly trees; the quotas ask for a shrub and a graminoid
+	selected := greedyDiversitySelection(candidates, traits, 4, selectionOptions{
+		GrowthFormQuotas: map[string]float64{"tree": 0.5, "shrub": 0.25, "graminoid": 0.25},
+	})
+	if got, want := growthFormCounts(selected), map[string]int{"tree": 2, "shrub": 1, "graminoid": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("composition = %v, want %v", got, want)
+	}
+	metrics := calculateDiversityMetrics(selected, traits, defaultMetricWeights)
+	if metrics.GrowthFormComposition["tree"] != 0.5 {
+		t.Errorf("reported composition = %v", metrics.GrowthFormComposition)
+	}
+
+	// Only one shrub exists: its unfilled slots go to the other forms
+	selected = greedyDiversitySelection(candidates, traits, 4, selectionOptions{
+		GrowthFormQuotas: map[string]float64{"shrub": 0.75, "tree": 0.25},
+	})
+	if got := growthFormCounts(selected); len(selected) != 4 || got["shrub"] != 1 {
+		t.Errorf("composition = %v (%d species)", got, len(selected))
+	}
+
+	// Forms without a quota only fill the slots the quotas leave free
+	selected = greedyDiversitySelection(candidates, traits, 4, selectionOptions{
+		GrowthFormQuotas: map[string]float64{"tree": 1},
+	})
+	if got := growthFormCounts(selected); got["tree"] != 4 {
+		t.Errorf("composition = %v", got)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// RATE LIMITING
+// ============================================================================
+//
+// /api/ requests are rate-limited per client with token buckets. A client
+// is its API key when the request carries a valid one, otherwise its IP.
+// RATE_LIMIT is the default ("120/m"; "0" disables) and RATE_LIMITS
+// overrides per endpoint like STATEMENT_TIMEOUTS:
+// "/api/recommend=10/m,/api/query=30/m". Each endpoint rule has its own
+// bucket; everything else shares the default one.
+//
+// Keyed clients get the limits multiplied by RATE_LIMIT_KEY_FACTOR (5), or
+// by the key's api_keys.rate_limit_factor (0 = unlimited). Addresses in
+// RATE_LIMIT_EXEMPT (loopback and private networks, i.e. the dashboard
+// container) are not limited. A rejected request gets 429 with Retry-After.
+// The public widget API has its own limit (see public.go).
+
+const defaultRateLimit = "120/m"
+
+// The expensive endpoints have their own default limits, set in
+// routeRegistry (see routes.go)
+
+const defaultRateLimitExempt = "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
+// rateLimit allows Requests per Period, in bursts of up to Requests
+type rateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// parseRateLimit reads "30/m", "5/s", "1000/h"; "0" is no limit
+func parseRateLimit(s string) (rateLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "0" {
+		return rateLimit{}, nil
+	}
+	count, unit, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 0 {
+		return rateLimit{}, fmt.Errorf("invalid rate limit %q (use e.g. 30/m)", s)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[unit]
+	if !ok {
+		return rateLimit{}, fmt.Errorf("invalid rate limit unit %q (s, m or h)", unit)
+	}
+	return rateLimit{Requests: n, Period: period}, nil
+}
+
+// String formats l as parseRateLimit reads it
+func (l rateLimit) String() string {
+	if l.Requests == 0 {
+		return "0"
+	}
+	units := map[time.Duration]string{time.Second: "s", time.Minute: "m", time.Hour: "h"}
+	return fmt.Sprintf("%d/%s", l.Requests, units[l.Period])
+}
+
+type rateLimits struct {
+	fallback  rateLimit
+	endpoints map[string]rateLimit
+	keyFactor float64
+	exempt    []*net.IPNet
+}
+
+func loadRateLimits() rateLimits {
+	l := rateLimits{endpoints: make(map[string]rateLimit), keyFactor: 5}
+
+	var err error
+	if l.fallback, err = parseRateLimit(getEnv("RATE_LIMIT", defaultRateLimit)); err != nil {
+		log.Printf("RATE_LIMIT: %v, using %s", err, defaultRateLimit)
+		l.fallback, _ = parseRateLimit(defaultRateLimit)
+	}
+	if l.fallback.Requests == 0 {
+		// Rate limiting disabled altogether
+		return l
+	}
+
+	for _, rt := range routeRegistry() {
+		if rt.RateLimit != "" {
+			l.endpoints[rt.Pattern], _ = parseRateLimit(rt.RateLimit)
+		}
+	}
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMITS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, value, ok := strings.Cut(entry, "=")
+		limit, err := parseRateLimit(value)
+		if !ok || err != nil || !strings.HasPrefix(path, "/") {
+			log.Printf("Invalid RATE_LIMITS entry %q, ignoring", entry)
+			continue
+		}
+		l.endpoints[strings.TrimSpace(path)] = limit
+	}
+
+	if v := os.Getenv("RATE_LIMIT_KEY_FACTOR"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			l.keyFactor = f
+		} else {
+			log.Printf("Invalid RATE_LIMIT_KEY_FACTOR=%q, using %g", v, l.keyFactor)
+		}
+	}
+
+	for _, cidr := range strings.Split(getEnv("RATE_LIMIT_EXEMPT", defaultRateLimitExempt), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Invalid RATE_LIMIT_EXEMPT entry %q, ignoring", cidr)
+			continue
+		}
+		l.exempt = append(l.exempt, network)
+	}
+	return l
+}
+
+// forPath returns the rule for a request path and the bucket it draws from
+// (the matched pattern, or "" for the default), matching like
+// statementTimeouts.forPath
+func (l rateLimits) forPath(path string) (rateLimit, string) {
+	if limit, ok := l.endpoints[path]; ok {
+		return limit, path
+	}
+	best, bestPattern := l.fallback, ""
+	for pattern, limit := range l.endpoints {
+		if patternCovers(pattern, path) && len(pattern) > len(bestPattern) {
+			best, bestPattern = limit, pattern
+		}
+	}
+	return best, bestPattern
+}
+
+func (l rateLimits) isExempt(ip net.IP) bool {
+	for _, network := range l.exempt {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================
+// TOKEN BUCKETS
+// ============================================================================
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// take spends a token from bucket id, refilled at limit (scaled by factor).
+// It returns the tokens left, or how long until the next one if empty.
+func (rl *rateLimiter) take(id string, limit rateLimit, factor float64, now time.Time) (bool, int, time.Duration) {
+	capacity := float64(limit.Requests) * factor
+	perSecond := capacity / limit.Period.Seconds()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b, ok := rl.buckets[id]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		rl.buckets[id] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// sweep drops buckets idle long enough to have refilled completely
+func (rl *rateLimiter) sweep(olderThan time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for id, b := range rl.buckets {
+		if b.last.Before(olderThan) {
+			delete(rl.buckets, id)
+		}
+	}
+}
+
+// ============================================================================
+// CLIENT IDENTITY
+// ============================================================================
+
+// rateLimitKeyCache caches API key lookups (valid or not) for a minute, so
+// the limiter does not hit the database on every request
+type rateLimitKeyCache struct {
+	sync.Mutex
+	entries map[string]rateLimitKey
+}
+
+type rateLimitKey struct {
+	id      int64 // 0: unknown or revoked key
+	factor  sql.NullFloat64
+	expires time.Time
+}
+
+func (s *Server) lookupRateLimitKey(ctx context.Context, raw string) rateLimitKey {
+	hash := hashAPIKey(raw)
+	now := time.Now()
+
+	s.rateLimitKeys.Lock()
+	entry, ok := s.rateLimitKeys.entries[hash]
+	s.rateLimitKeys.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry
+	}
+
+	entry = rateLimitKey{expires: now.Add(time.Minute)}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, rate_limit_factor FROM api_keys WHERE key_hash = $1 AND NOT revoked
+	`, hash).Scan(&entry.id, &entry.factor)
+	if err != nil && err != sql.ErrNoRows {
+		// Not cached: treat as anonymous this time and look up again next time
+		s.log.Printf("Rate limit key lookup: %v", err)
+		return rateLimitKey{}
+	}
+
+	s.rateLimitKeys.Lock()
+	if len(s.rateLimitKeys.entries) > 10000 {
+		s.rateLimitKeys.entries = make(map[string]rateLimitKey)
+	}
+	s.rateLimitKeys.entries[hash] = entry
+	s.rateLimitKeys.Unlock()
+	return entry
+}
+
+// clientIP is the request's remote address (the server faces the internet
+// directly, so X-Forwarded-For is not trusted)
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// ============================================================================
+// MIDDLEWARE
+// ============================================================================
+
+func (s *Server) rateLimitMiddleware(next http.Handler, limits rateLimits) http.Handler {
+	if limits.fallback.Requests == 0 {
+		return next
+	}
+
+	limiter := newRateLimiter()
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			// Buckets idle for the longest period are full again
+			limiter.sweep(now.Add(-time.Hour))
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if ip != nil && limits.isExempt(ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, bucket := limits.forPath(r.URL.Path)
+		if limit.Requests == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, factor := "ip:"+ip.String(), 1.0
+		if raw := apiKeyFromRequest(r); raw != "" {
+			if key := s.lookupRateLimitKey(r.Context(), raw); key.id != 0 {
+				client, factor = fmt.Sprintf("key:%d", key.id), limits.keyFactor
+				if key.factor.Valid {
+					factor = key.factor.Float64
+				}
+			}
+		}
+		if factor == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, remaining, wait := limiter.take(client+"|"+bucket, limit, factor, time.Now())
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(float64(limit.Requests)*factor)))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "Rate limit exceeded, retry later"}`, http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want rateLimit
+		err  bool
+	}{
+		{"30/m", rateLimit{30, time.Minute}, false},
+		{" 5/s ", rateLimit{5, time.Second}, false},
+		{"1000/h", rateLimit{1000, time.Hour}, false},
+		{"0", rateLimit{}, false},
+		{"30", rateLimit{}, true},
+		{"30/d", rateLimit{}, true},
+		{"-1/m", rateLimit{}, true},
+		{"x/m", rateLimit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseRateLimit(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestRateLimitsForPath(t *testing.T) {
+	l := rateLimits{
+		fallback: rateLimit{120, time.Minute},
+		endpoints: map[string]rateLimit{
+			"/api/recommend":        {20, time.Minute},
+			"/api/recommend/stream": {10, time.Minute},
+			"/api/queries/":         {30, time.Minute},
+		},
+	}
+	tests := []struct {
+		path, bucket string
+		requests     int
+	}{
+		{"/api/recommend", "/api/recommend", 20},
+		{"/api/recommend/stream", "/api/recommend/stream", 10},
+		{"/api/queries/7/execute", "/api/queries/", 30},
+		{"/api/queries", "", 120},
+		{"/api/species", "", 120},
+	}
+	for _, tt := range tests {
+		limit, bucket := l.forPath(tt.path)
+		if bucket != tt.bucket || limit.Requests != tt.requests {
+			t.Errorf("forPath(%q) = %+v, %q", tt.path, limit, bucket)
+		}
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	rl := newRateLimiter()
+	limit := rateLimit{2, time.Minute}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, wantRemaining := range []int{1, 0} {
+		if ok, remaining, _ := rl.take("a", limit, 1, now); !ok || remaining != wantRemaining {
+			t.Fatalf("take %d: ok=%v remaining=%d", i, ok, remaining)
+		}
+	}
+	ok, _, wait := rl.take("a", limit, 1, now)
+	if ok || wait != 30*time.Second {
+		t.Fatalf("empty bucket: ok=%v wait=%s, want 30s", ok, wait)
+	}
+
+	// Other clients have their own bucket
+	if ok, _, _ := rl.take("b", limit, 1, now); !ok {
+		t.Error("second client limited by the first")
+	}
+
+	// One token back after half the period, never more than the capacity
+	if ok, _, _ := rl.take("a", limit, 1, now.Add(30*time.Second)); !ok {
+		t.Error("no token after refill")
+	}
+	if _, remaining, _ := rl.take("a", limit, 1, now.Add(time.Hour)); remaining != 1 {
+		t.Errorf("after an idle hour: %d remaining, want 1", remaining)
+	}
+
+	// A factor scales capacity and refill
+	if _, remaining, _ := rl.take("c", limit, 5, now); remaining != 9 {
+		t.Errorf("factor 5: %d remaining, want 9", remaining)
+	}
+
+	rl.sweep(now.Add(time.Minute))
+	if _, ok := rl.buckets["a"]; !ok {
+		t.Error("sweep dropped a recently used bucket")
+	}
+	if _, ok := rl.buckets["b"]; ok {
+		t.Error("sweep kept an idle bucket")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	limits := rateLimits{
+		fallback:  rateLimit{100, time.Minute},
+		endpoints: map[string]rateLimit{"/api/recommend": {1, time.Minute}},
+		exempt:    []*net.IPNet{private},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := newTestServer().rateLimitMiddleware(ok, limits)
+
+	serve := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("/api/recommend", "203.0.113.5:4000"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request: %d, remaining %q", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	rec := serve("/api/recommend", "203.0.113.5:4001")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: got %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", rec.Header().Get("Retry-After"))
+	}
+
+	// Other endpoints, other clients and exempt networks are unaffected
+	if rec := serve("/api/species", "203.0.113.5:4002"); rec.Code != http.StatusOK {
+		t.Errorf("other endpoint: %d", rec.Code)
+	}
+	if rec := serve("/api/recommend", "198.51.100.7:4000"); rec.Code != http.StatusOK {
+		t.Errorf("other client: %d", rec.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rec := serve("/api/recommend", "10.1.2.3:4000"); rec.Code != http.StatusOK {
+			t.Errorf("exempt client: %d", rec.Code)
+		}
+	}
+}
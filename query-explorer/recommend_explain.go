@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ============================================================================
+// RECOMMENDATION EXPLANATIONS
+// ============================================================================
+//
+// POST /api/recommend/explain runs a recommendation (always with
+// climate_diagnostics) and says, for each selected species, why it is
+// there: its climate match per bio variable, the species ranked before it
+// that it is most and least similar to, and which traits make up its Gower
+// distance to the most similar one. That distance is its diversity
+// contribution under greedy selection, so the trait drivers say what the
+// species added to the mix.
+
+const maxExplainSpecies = 200
+
+// gowerTraits names the terms of gowerDistance, in its order
+var gowerTraits = []string{
+	"tree", "shrub", "herb", "climber", "palm", "nitrogen_fixer",
+	"animal_dispersal", "wind_dispersal", "height", "lifespan", "family",
+}
+
+// TraitDriver is one trait's share of the distance between two species
+type TraitDriver struct {
+	Trait        string  `json:"trait"`
+	Contribution float64 `json:"contribution"` // Its term of the Gower distance
+}
+
+type SimilarSpecies struct {
+	SpeciesID     int64   `json:"species_id"`
+	CanonicalName string  `json:"canonical_name"`
+	Distance      float64 `json:"distance"` // Gower distance
+}
+
+type SpeciesExplanation struct {
+	SpeciesID             int64               `json:"species_id"`
+	CanonicalName         string              `json:"canonical_name"`
+	SelectionRank         int                 `json:"selection_rank"`
+	ClimateMatchScore     float64             `json:"climate_match_score"`
+	Climate               *ClimateDiagnostics `json:"climate,omitempty"`
+	DiversityContribution float64             `json:"diversity_contribution"`
+
+	// Among the species ranked before it (absent for the first)
+	MostSimilar  *SimilarSpecies `json:"most_similar,omitempty"`
+	LeastSimilar *SimilarSpecies `json:"least_similar,omitempty"`
+	TraitDrivers []TraitDriver   `json:"trait_drivers,omitempty"` // Versus most_similar, largest first
+}
+
+type RecommendExplainResponse struct {
+	*RecommendResponse
+	Explanations []SpeciesExplanation `json:"explanations"`
+}
+
+// gowerTerms splits gowerDistance(a, b) into its per-trait terms
+func gowerTerms(a, b TraitVector) []float64 {
+	flag := func(x, y bool) float64 {
+		if x != y {
+			return 1
+		}
+		return 0
+	}
+	terms := []float64{
+		flag(a.IsTree, b.IsTree),
+		flag(a.IsShrub, b.IsShrub),
+		flag(a.IsHerb, b.IsHerb),
+		flag(a.IsClimber, b.IsClimber),
+		flag(a.IsPalm, b.IsPalm),
+		flag(a.IsNitrogenFixer, b.IsNitrogenFixer),
+		flag(a.DispersalAnimal, b.DispersalAnimal),
+		flag(a.DispersalWind, b.DispersalWind),
+		math.Abs(a.HeightNorm - b.HeightNorm),
+		math.Abs(a.LifespanNorm - b.LifespanNorm),
+		0, // family, below
+	}
+	if a.FamilyCode != b.FamilyCode {
+		terms[10] = 1
+	}
+	for i := range terms {
+		terms[i] /= float64(len(terms))
+	}
+	return terms
+}
+
+// traitDrivers lists the traits that differ between a and b, largest first
+func traitDrivers(a, b TraitVector) []TraitDriver {
+	drivers := []TraitDriver{}
+	for i, term := range gowerTerms(a, b) {
+		if term > 0 {
+			drivers = append(drivers, TraitDriver{Trait: gowerTraits[i], Contribution: round3(term)})
+		}
+	}
+	sort.SliceStable(drivers, func(i, j int) bool { return drivers[i].Contribution > drivers[j].Contribution })
+	return drivers
+}
+
+// explainSelection explains each species of a ranked selection against the
+// species ranked before it
+func explainSelection(species []SpeciesRecommendation, traits map[int64]TraitVector) []SpeciesExplanation {
+	explanations := make([]SpeciesExplanation, len(species))
+	for i, sp := range species {
+		e := SpeciesExplanation{
+			SpeciesID:             sp.SpeciesID,
+			CanonicalName:         sp.CanonicalName,
+			SelectionRank:         sp.SelectionRank,
+			ClimateMatchScore:     sp.ClimateMatchScore,
+			Climate:               sp.ClimateDiagnostics,
+			DiversityContribution: sp.DiversityContribution,
+		}
+		tv := traits[sp.SpeciesID]
+		for _, prev := range species[:i] {
+			d := gowerDistance(tv, traits[prev.SpeciesID])
+			other := &SimilarSpecies{SpeciesID: prev.SpeciesID, CanonicalName: prev.CanonicalName, Distance: round3(d)}
+			if e.MostSimilar == nil || d < gowerDistance(tv, traits[e.MostSimilar.SpeciesID]) {
+				e.MostSimilar = other
+			}
+			if e.LeastSimilar == nil || d > gowerDistance(tv, traits[e.LeastSimilar.SpeciesID]) {
+				e.LeastSimilar = other
+			}
+		}
+		if e.MostSimilar != nil {
+			e.TraitDrivers = traitDrivers(tv, traits[e.MostSimilar.SpeciesID])
+		}
+		explanations[i] = e
+	}
+	return explanations
+}
+
+// handleRecommendExplain handles POST /api/recommend/explain
+func (s *Server) handleRecommendExplain(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, plugins, ok := decodeRecommendRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.NSpecies < 1 || req.NSpecies > maxExplainSpecies {
+		http.Error(w, fmt.Sprintf(`{"error": "n_species must be between 1 and %d"}`, maxExplainSpecies), http.StatusBadRequest)
+		return
+	}
+	req.ClimateDiagnostics = true
+
+	lang := requestLanguage(r)
+	setContentLanguage(w, lang)
+
+	start := time.Now()
+	resp, err := s.executeRecommendation(ctx, req, plugins, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	traits, err := s.loadTraitVectors(ctx, resp.Species, req.Preferences.IncludeFlaggedTraits)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to load traits"}`, http.StatusInternalServerError)
+		return
+	}
+	s.localizeRecommendation(ctx, resp, lang)
+
+	resp.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(RecommendExplainResponse{
+		RecommendResponse: resp,
+		Explanations:      explainSelection(resp.Species, traits),
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGowerTermsSumToDistance(t *testing.T) {
+	_, traits := randomPool(20)
+	for i := int64(1); i < 20; i++ {
+		a, b := traits[i], traits[i+1]
+		sum := 0.0
+		for _, term := range gowerTerms(a, b) {
+			sum += term
+		}
+		if d := gowerDistance(a, b); math.Abs(sum-d) > 1e-12 {
+			t.Errorf("species %d/%d: terms sum to %v, distance is %v", i, i+1, sum, d)
+		}
+	}
+}
+
+func TestExplainSelection(t *testing.T) {
+	candidates, traits := testPool()
+	selected := greedyDiversitySelection(candidates, traits, 4, selectionOptions{})
+	explanations := explainSelection(selected, traits)
+
+	if len(explanations) != 4 {
+		t.Fatalf("got %d explanations, want 4", len(explanations))
+	}
+	if first := explanations[0]; first.MostSimilar != nil || first.LeastSimilar != nil || first.TraitDrivers != nil {
+		t.Errorf("first species compared with nothing: %+v", first)
+	}
+	for _, e := range explanations[1:] {
+		if e.MostSimilar == nil || e.LeastSimilar == nil {
+			t.Fatalf("species %d: no neighbors", e.SpeciesID)
+		}
+		// Under greedy selection, the contribution is the distance to the
+		// most similar species ranked before
+		if math.Abs(e.MostSimilar.Distance-round3(e.DiversityContribution)) > 1e-9 {
+			t.Errorf("species %d: most similar at %v, contribution %v", e.SpeciesID, e.MostSimilar.Distance, e.DiversityContribution)
+		}
+		if e.LeastSimilar.Distance < e.MostSimilar.Distance {
+			t.Errorf("species %d: least similar closer than most similar", e.SpeciesID)
+		}
+		sum := 0.0
+		for i, d := range e.TraitDrivers {
+			sum += d.Contribution
+			if i > 0 && d.Contribution > e.TraitDrivers[i-1].Contribution {
+				t.Errorf("species %d: trait drivers not sorted", e.SpeciesID)
+			}
+		}
+		if math.Abs(sum-e.MostSimilar.Distance) > 0.01 {
+			t.Errorf("species %d: drivers sum to %v, distance %v", e.SpeciesID, sum, e.MostSimilar.Distance)
+		}
+	}
+}
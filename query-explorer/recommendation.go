@@ -1,13 +1,15 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -20,9 +22,17 @@ import (
 type RecommendRequest struct {
 	// Location (one required)
 	TDWGCode  string   `json:"tdwg_code,omitempty"`
-	StateCode string   `json:"state_code,omitempty"` // BR-SP, BR-MG, etc.
+	StateCode string   `json:"state_code,omitempty"` // ISO 3166 country or subdivision: BR, BR-SP, AR-X (see admin_units.go)
 	Latitude  *float64 `json:"latitude,omitempty"`
 	Longitude *float64 `json:"longitude,omitempty"`
+	AOIID     string   `json:"aoi_id,omitempty"` // Stored area of interest (see aoi.go)
+
+	// Site elevation in meters (optional, used by elevation_mode)
+	ElevationM *float64 `json:"elevation_m,omitempty"`
+
+	// Site soil (optional, used by soil_match; default: SoilGrids at latitude/longitude)
+	SoilPH      *float64 `json:"soil_ph,omitempty"`
+	SoilTexture string   `json:"soil_texture,omitempty"` // light, medium, heavy, organic
 
 	// Parameters
 	NSpecies         int     `json:"n_species"`         // Default: 20
@@ -30,41 +40,152 @@ type RecommendRequest struct {
 
 	// Filters
 	Preferences Preferences `json:"preferences,omitempty"`
+
+	// Pipeline plugins to apply, by registered name (see plugins.go)
+	Plugins []string `json:"plugins,omitempty"`
+
+	// Greedy start (see startStrategies)
+	StartStrategy string `json:"start_strategy,omitempty"` // best_climate (default), most_distinct, random_top_k
+	StartTopK     int    `json:"start_top_k,omitempty"`    // random_top_k pool size (default: 10)
+	StartSeed     *int64 `json:"start_seed,omitempty"`     // random_top_k seed (default: random_seed, echoed in response)
+
+	// Selection algorithm (see annealing.go)
+	Algorithm    string `json:"algorithm,omitempty"`      // greedy (default), annealing
+	TimeBudgetMs int    `json:"time_budget_ms,omitempty"` // annealing search time (default: 200, max 5000)
+
+	// Reproducible results (see determinism.go)
+	RandomSeed    *int64 `json:"random_seed,omitempty"`   // Seed of every random choice without its own
+	Deterministic bool   `json:"deterministic,omitempty"` // Without random_seed, derive it from the request
+
+	// Per-variable climate match breakdown on each species (see climate_match.go)
+	ClimateDiagnostics bool `json:"climate_diagnostics,omitempty"`
+
+	// Composition compliance report (see compliance.go)
+	Compliance        bool   `json:"compliance,omitempty"`
+	ComplianceRuleSet string `json:"compliance_rule_set,omitempty"` // Default: state_code, else 'default'
+
+	// Greedy score and total diversity score weights (see weights.go)
+	DiversityWeight *float64       `json:"diversity_weight,omitempty"` // Default: 0.7
+	ClimateWeight   *float64       `json:"climate_weight,omitempty"`   // Default: 0.3
+	MetricWeights   *MetricWeights `json:"metric_weights,omitempty"`   // Default: 0.5 / 0.25 / 0.25
+
+	// Variables counted by the climate match, with relative weights (see
+	// climate_variables.go)
+	ClimateVariables map[string]float64 `json:"climate_variables,omitempty"` // Default: all six
+
+	// Envelope limits from min/max or robust quantiles of the native regions
+	// (see robust_envelope.go)
+	EnvelopeMode string `json:"envelope_mode,omitempty"` // minmax (default), percentile, kde
+
+	// Blend the regions within this distance of latitude/longitude instead
+	// of hard-assigning one (see border_blend.go)
+	BorderBlendKm float64 `json:"border_blend_km,omitempty"` // Default: 0, off; max 50
+
+	// Target share of the selection per growth form (see quotas.go)
+	GrowthFormQuotas map[string]float64 `json:"growth_form_quotas,omitempty"`
+
+	// Group the species by succession stage (see succession.go)
+	SuccessionStages bool `json:"succession_stages,omitempty"`
+
+	// Candidates fetched at most (see candidate_pool.go)
+	MaxCandidates int `json:"max_candidates,omitempty"` // Default: from n_species, 500 to 2000
+
+	// Add the anonymized request and result to the public research dataset
+	// (see research_dataset.go)
+	ShareForResearch bool `json:"share_for_research,omitempty"`
+
+	// Seeds filled in by the server rather than sent (see determinism.go)
+	startSeedFilled, randomSeedFilled bool
 }
 
 type Preferences struct {
-	GrowthForms        []string `json:"growth_forms,omitempty"`    // graminoid, forb, subshrub, shrub, tree, scrambler, vine, liana, palm, bamboo, other
-	IncludeIntroduced  bool     `json:"include_introduced,omitempty"` // Include introduced species (default: false)
-	IncludeThreatened  *bool    `json:"include_threatened,omitempty"`
-	MinHeightM         *float64 `json:"min_height_m,omitempty"`
-	MaxHeightM         *float64 `json:"max_height_m,omitempty"`
-	NitrogenFixersOnly bool     `json:"nitrogen_fixers_only,omitempty"`
-	EndemicsOnly       bool     `json:"endemics_only,omitempty"`
+	GrowthForms          []string `json:"growth_forms,omitempty"`       // graminoid, forb, subshrub, shrub, tree, scrambler, vine, liana, palm, bamboo, other
+	IncludeIntroduced    bool     `json:"include_introduced,omitempty"` // Include introduced species (default: false)
+	IncludeThreatened    *bool    `json:"include_threatened,omitempty"`
+	MinHeightM           *float64 `json:"min_height_m,omitempty"`
+	MaxHeightM           *float64 `json:"max_height_m,omitempty"`
+	NitrogenFixersOnly   bool     `json:"nitrogen_fixers_only,omitempty"`
+	EndemicsOnly         bool     `json:"endemics_only,omitempty"`
+	EstablishmentMeans   []string `json:"establishment_means,omitempty"`    // native, naturalized, invasive, cultivated (overrides include_introduced)
+	IncludeFlaggedTraits bool     `json:"include_flagged_traits,omitempty"` // Use trait values flagged as implausible (default: false)
+	ElevationMode        string   `json:"elevation_mode,omitempty"`         // filter, score (elevation_m, or the DEM at latitude/longitude; default: off)
+	ElevationToleranceM  *float64 `json:"elevation_tolerance_m,omitempty"`  // Slack around species ranges in meters (default: 100)
+	SiteHydrology        string   `json:"site_hydrology,omitempty"`         // upland, riparian, wetland (matches species wetland indicator)
+	HydrologyStrict      bool     `json:"hydrology_strict,omitempty"`       // Only species whose indicator suits the site
+	ExcludeSpecies       []string `json:"exclude_species,omitempty"`        // Canonical names never recommended (case-insensitive)
+	ExcludeFamilies      []string `json:"exclude_families,omitempty"`       // Families never recommended (case-insensitive)
+	FrostSafetyMarginC   *float64 `json:"frost_safety_margin_c,omitempty"`  // Species must tolerate this much colder than the site's bio6
+	KoppenMatch          string   `json:"koppen_match,omitempty"`           // filter, only (species native in the site's Köppen zone; default: off)
+	SoilMatch            string   `json:"soil_match,omitempty"`             // filter (drop species whose pH or texture tolerance excludes the site; default: off)
+	MinAbundance         string   `json:"min_abundance,omitempty"`          // rare, occasional, common (in the site's region, see abundance.go)
+
+	// Flora e Funga do Brasil domains and vegetation types, any of (see
+	// brazil_flora.go)
+	PhytogeographicDomains []string `json:"phytogeographic_domains,omitempty"`
+	VegetationTypes        []string `json:"vegetation_types,omitempty"`
 }
 
 type RecommendResponse struct {
 	Species          []SpeciesRecommendation `json:"species"`
 	DiversityMetrics DiversityMetrics        `json:"diversity_metrics"`
 	LocationInfo     LocationInfo            `json:"location_info"`
+	StartStrategy    string                  `json:"start_strategy,omitempty"`
+	StartSeed        *int64                  `json:"start_seed,omitempty"`
+	Algorithm        string                  `json:"algorithm,omitempty"`
+	Annealing        *AnnealingStats         `json:"annealing,omitempty"`
+	DiversityWeight  float64                 `json:"diversity_weight"`
+	ClimateWeight    float64                 `json:"climate_weight"`
+	MetricWeights    MetricWeights           `json:"metric_weights"`
+	CandidatePool    CandidatePoolInfo       `json:"candidate_pool"`
+	Compliance       *ComplianceReport       `json:"compliance,omitempty"`
+	Succession       []SuccessionGroup       `json:"succession,omitempty"`
+	Cached           bool                    `json:"cached,omitempty"` // Served from recommendation_cache
+	RandomSeed       *int64                  `json:"random_seed,omitempty"`
+	SelectionHash    string                  `json:"selection_hash"`      // Fingerprint of the ranked species (see determinism.go)
+	Watermark        string                  `json:"watermark,omitempty"` // Demo requests (see demo.go)
+	Display          string                  `json:"display,omitempty"`   // ?display= mode (see display.go)
 	QueryTime        string                  `json:"query_time"`
 }
 
 type SpeciesRecommendation struct {
-	SpeciesID             int64    `json:"species_id"`
-	CanonicalName         string   `json:"canonical_name"`
-	CommonNamePT          *string  `json:"common_name_pt,omitempty"`
-	CommonNameEN          *string  `json:"common_name_en,omitempty"`
-	Family                string   `json:"family"`
-	GrowthForm            string   `json:"growth_form"`
-	MaxHeightM            *float64 `json:"max_height_m,omitempty"`
-	LifespanYears         *float64 `json:"lifespan_years,omitempty"`
-	IsNitrogenFixer       bool     `json:"is_nitrogen_fixer"`
-	ThreatStatus          *string  `json:"threat_status,omitempty"`
-	IsNative              bool     `json:"is_native"`
-	IsEndemic             bool     `json:"is_endemic"`
-	ClimateMatchScore     float64  `json:"climate_match_score"`
-	SelectionRank         int      `json:"selection_rank"`
-	DiversityContribution float64  `json:"diversity_contribution"`
+	SpeciesID             int64               `json:"species_id"`
+	CanonicalName         string              `json:"canonical_name"`
+	CommonNamePT          *string             `json:"common_name_pt,omitempty"`
+	CommonNameEN          *string             `json:"common_name_en,omitempty"`
+	CommonName            *string             `json:"common_name,omitempty"`          // In the response language, or the nearest fallback
+	CommonNameLanguage    *string             `json:"common_name_language,omitempty"` // Language of common_name
+	DisplayName           string              `json:"display_name,omitempty"`         // With ?display= (see display.go)
+	Family                string              `json:"family"`
+	GrowthForm            string              `json:"growth_form"`
+	MaxHeightM            *float64            `json:"max_height_m,omitempty"`
+	LifespanYears         *float64            `json:"lifespan_years,omitempty"`
+	IsNitrogenFixer       bool                `json:"is_nitrogen_fixer"`
+	ThreatStatus          *string             `json:"threat_status,omitempty"`
+	ThreatStatusLabel     *string             `json:"threat_status_label,omitempty"` // In the response language (see threat_status.go)
+	IsNative              bool                `json:"is_native"`
+	IsEndemic             bool                `json:"is_endemic"`
+	EstablishmentMeans    *string             `json:"establishment_means,omitempty"`
+	ElevationLowM         *float64            `json:"elevation_low_m,omitempty"`
+	ElevationHighM        *float64            `json:"elevation_high_m,omitempty"`
+	WetlandIndicator      *string             `json:"wetland_indicator,omitempty"`
+	LightRequirement      *string             `json:"light_requirement,omitempty"`
+	KoppenZones           []string            `json:"koppen_zones,omitempty"`     // Of the native regions
+	SuccessionStage       string              `json:"succession_stage,omitempty"` // With succession_stages (see succession.go)
+	ClimateMatchScore     float64             `json:"climate_match_score"`
+	RegionWeight          *float64            `json:"region_weight,omitempty"` // With border_blend_km: weight of the regions it qualifies in
+	Abundance             *string             `json:"abundance,omitempty"`     // rare, occasional, common in the site's region (see abundance.go)
+	ClimateDiagnostics    *ClimateDiagnostics `json:"climate_diagnostics,omitempty"`
+	SelectionRank         int                 `json:"selection_rank"`
+	DiversityContribution float64             `json:"diversity_contribution"`
+}
+
+// rankScore is the climate term of selection: the climate match, times
+// region_weight with a border blend
+func (sp SpeciesRecommendation) rankScore() float64 {
+	if sp.RegionWeight != nil {
+		return sp.ClimateMatchScore * *sp.RegionWeight
+	}
+	return sp.ClimateMatchScore
 }
 
 type DiversityMetrics struct {
@@ -75,18 +196,43 @@ type DiversityMetrics struct {
 	NSpecies              int     `json:"n_species"`
 	NFamilies             int     `json:"n_families"`
 	NGrowthForms          int     `json:"n_growth_forms"`
+
+	// Share of the selection per growth form
+	GrowthFormComposition map[string]float64 `json:"growth_form_composition,omitempty"`
 }
 
 type LocationInfo struct {
-	TDWGCode  string   `json:"tdwg_code"`
-	TDWGName  string   `json:"tdwg_name"`
-	Latitude  *float64 `json:"latitude,omitempty"`
-	Longitude *float64 `json:"longitude,omitempty"`
-	Bio1      float64  `json:"bio1"`  // Annual mean temp
-	Bio5      float64  `json:"bio5"`  // Max temp warmest month
-	Bio6      float64  `json:"bio6"`  // Min temp coldest month
-	Bio12     float64  `json:"bio12"` // Annual precipitation
-	Bio15     float64  `json:"bio15"` // Precipitation seasonality
+	TDWGCode   string   `json:"tdwg_code"`
+	TDWGName   string   `json:"tdwg_name"`
+	AOIID      string   `json:"aoi_id,omitempty"`
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+	ElevationM *float64 `json:"elevation_m,omitempty"`
+	Bio1       float64  `json:"bio1"`  // Annual mean temp
+	Bio5       float64  `json:"bio5"`  // Max temp warmest month
+	Bio6       float64  `json:"bio6"`  // Min temp coldest month
+	Bio12      float64  `json:"bio12"` // Annual precipitation
+	Bio15      float64  `json:"bio15"` // Precipitation seasonality
+
+	// Köppen zone of the TDWG region (see koppen.go)
+	KoppenZone *string `json:"koppen_zone,omitempty"`
+	KoppenName *string `json:"koppen_name,omitempty"`
+
+	// Site soil used by soil_match (see soil.go)
+	SoilPH      *float64 `json:"soil_ph,omitempty"`
+	SoilTexture string   `json:"soil_texture,omitempty"`
+
+	// Regions whose candidates are pooled near a border, with border_blend_km
+	Blend []BorderRegion `json:"border_blend,omitempty"`
+
+	// The climate is TDWGCode's tdwg_climate means, as the re-scoring job
+	// uses (see rescore.go)
+	RegionClimate bool `json:"-"`
+
+	// Level-3 regions of a level-2 tdwg_code (see tdwg_levels.go) or of a
+	// state_code spanning several (see admin_units.go)
+	Level3Codes []string `json:"level3_codes,omitempty"`
+	AdminCode   string   `json:"admin_code,omitempty"` // Resolved state_code
 }
 
 type TraitVector struct {
@@ -103,63 +249,63 @@ type TraitVector struct {
 	FamilyCode      int
 }
 
-// ============================================================================
-// CACHE KEY GENERATION
-// ============================================================================
-
-func (r *RecommendRequest) CacheKey() string {
-	latVal := 0.0
-	lonVal := 0.0
-	if r.Latitude != nil {
-		latVal = *r.Latitude
-	}
-	if r.Longitude != nil {
-		lonVal = *r.Longitude
-	}
-
-	prefsJSON, _ := json.Marshal(r.Preferences)
-	data := fmt.Sprintf("%s_%s_%.6f_%.6f_%d_%.2f_%s",
-		r.TDWGCode, r.StateCode,
-		latVal, lonVal,
-		r.NSpecies, r.ClimateThreshold,
-		string(prefsJSON),
-	)
-
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
-}
-
 // ============================================================================
 // CACHE OPERATIONS
 // ============================================================================
 
-func getCachedRecommendation(db *sql.DB, cacheKey string) (*RecommendResponse, bool) {
-	var speciesIDs []int64
-	var metricsJSON string
+// getCachedRecommendation returns the stored response for cacheKey, marked
+// as cached. Entries written before migration 035 have no response and are
+// recomputed.
+func (s *Server) getCachedRecommendation(ctx context.Context, cacheKey string) (*RecommendResponse, bool) {
+	var respJSON []byte
 
-	err := db.QueryRow(`
-		SELECT recommended_species, diversity_metrics
+	err := s.db.QueryRowContext(ctx, `
+		SELECT response
 		FROM recommendation_cache
-		WHERE cache_key = $1 AND expires_at > NOW()
-	`, cacheKey).Scan(&speciesIDs, &metricsJSON)
+		WHERE cache_key = $1 AND expires_at > NOW() AND response IS NOT NULL
+	`, cacheKey).Scan(&respJSON)
 
 	if err != nil {
 		return nil, false
 	}
 
+	var resp RecommendResponse
+	if err := json.Unmarshal(respJSON, &resp); err != nil {
+		s.log.Printf("Error decoding cached recommendation %s: %v", cacheKey, err)
+		return nil, false
+	}
+	resp.Cached = true
+
 	// Update hit count
-	db.Exec("UPDATE recommendation_cache SET hit_count = hit_count + 1 WHERE cache_key = $1", cacheKey)
+	s.db.ExecContext(ctx, "UPDATE recommendation_cache SET hit_count = hit_count + 1 WHERE cache_key = $1", cacheKey)
 
-	// Return cached response (would need to reconstruct full response)
-	// For simplicity, returning false to always compute fresh for now
-	return nil, false
+	return &resp, true
 }
 
-func cacheRecommendation(db *sql.DB, cacheKey string, req RecommendRequest, speciesIDs []int64, metrics DiversityMetrics, ttl time.Duration) error {
-	metricsJSON, err := json.Marshal(metrics)
+// cacheRecommendation stores the full response, with the species IDs and
+// metrics also in their own columns for analysis. The site of a
+// recommendation with threatened species is stored generalized (see
+// coordinate_privacy.go).
+func (s *Server) cacheRecommendation(ctx context.Context, cacheKey string, req RecommendRequest, resp *RecommendResponse, ttl time.Duration) error {
+	metricsJSON, err := json.Marshal(resp.DiversityMetrics)
 	if err != nil {
 		return err
 	}
+	if s.cfg.Coordinates.generalizes(false, includesThreatened(resp.Species)) {
+		grid := s.cfg.Coordinates.GridDeg
+		stored := *resp
+		stored.LocationInfo.Latitude, stored.LocationInfo.Longitude = generalizePoint(resp.LocationInfo.Latitude, resp.LocationInfo.Longitude, grid)
+		req.Latitude, req.Longitude = generalizePoint(req.Latitude, req.Longitude, grid)
+		resp = &stored
+	}
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	speciesIDs := make([]int64, len(resp.Species))
+	for i, sp := range resp.Species {
+		speciesIDs[i] = sp.SpeciesID
+	}
 
 	latVal := sql.NullFloat64{}
 	lonVal := sql.NullFloat64{}
@@ -172,14 +318,17 @@ func cacheRecommendation(db *sql.DB, cacheKey string, req RecommendRequest, spec
 
 	prefsJSON, _ := json.Marshal(req.Preferences)
 
-	_, err = db.Exec(`
+	_, err = s.db.ExecContext(ctx, `
 		INSERT INTO recommendation_cache
-		(cache_key, location_tdwg, location_lat, location_lon, preferences, climate_threshold, n_species, recommended_species, diversity_metrics, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW() + $10::interval)
+		(cache_key, location_tdwg, location_lat, location_lon, preferences, climate_threshold, n_species, recommended_species, diversity_metrics, response, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW() + $11::interval)
 		ON CONFLICT (cache_key) DO UPDATE
 		SET hit_count = recommendation_cache.hit_count + 1,
-		    expires_at = NOW() + $10::interval
-	`, cacheKey, req.TDWGCode, latVal, lonVal, prefsJSON, req.ClimateThreshold, req.NSpecies, speciesIDs, metricsJSON, fmt.Sprintf("%d seconds", int(ttl.Seconds())))
+		    recommended_species = EXCLUDED.recommended_species,
+		    diversity_metrics = EXCLUDED.diversity_metrics,
+		    response = EXCLUDED.response,
+		    expires_at = NOW() + $11::interval
+	`, cacheKey, resp.LocationInfo.TDWGCode, latVal, lonVal, prefsJSON, req.ClimateThreshold, req.NSpecies, pq.Array(speciesIDs), metricsJSON, respJSON, fmt.Sprintf("%d seconds", int(ttl.Seconds())))
 
 	return err
 }
@@ -188,18 +337,72 @@ func cacheRecommendation(db *sql.DB, cacheKey string, req RecommendRequest, spec
 // MAIN RECOMMENDATION LOGIC
 // ============================================================================
 
-func executeRecommendation(db *sql.DB, req RecommendRequest) (*RecommendResponse, error) {
+// recommendObserver receives progress from executeRecommendation as it
+// happens, for streaming responses. Any field may be nil.
+type recommendObserver struct {
+	OnLocation func(LocationInfo)
+	OnSelect   func(SpeciesRecommendation) // Called in rank order with final rank and contribution
+}
+
+func (o *recommendObserver) location(loc LocationInfo) {
+	if o != nil && o.OnLocation != nil {
+		o.OnLocation(loc)
+	}
+}
+
+func (o *recommendObserver) selectFunc() func(SpeciesRecommendation) {
+	if o == nil {
+		return nil
+	}
+	return o.OnSelect
+}
+
+func (s *Server) executeRecommendation(ctx context.Context, req RecommendRequest, plugins *pipelinePlugins, obs *recommendObserver) (resp *RecommendResponse, err error) {
+	tel := newRecoTelemetry(req)
+	defer func() {
+		if err != nil {
+			tel.Error = err.Error()
+		}
+		go s.recordRecoTelemetry(tel)
+	}()
+
 	// 1. Resolve location to TDWG + climate
-	location, err := resolveLocation(db, req)
+	if err := s.fillSiteElevation(ctx, &req); err != nil {
+		return nil, err
+	}
+	if err := s.fillSiteSoil(ctx, &req); err != nil {
+		return nil, err
+	}
+	location, err := s.resolveLocation(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve location: %w", err)
 	}
+	tel.TDWGCode = location.TDWGCode
+	tel.phase("resolve")
+	obs.location(location)
 
 	// 2. Get climatically adapted candidates
-	candidates, err := getClimateAdaptedSpecies(db, location, req)
+	candidates, truncated, err := s.getClimateAdaptedSpecies(ctx, location, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get candidates: %w", err)
 	}
+	tel.phase("candidates")
+
+	pluginCtx := PluginContext{Ctx: ctx, DB: s.db, Request: req, Location: location}
+	candidates, err = plugins.filterCandidates(pluginCtx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	tel.CandidatePoolSize = len(candidates)
+	tel.phase("plugin_filters")
+	pool := CandidatePoolInfo{Size: len(candidates), Limit: s.cfg.CandidatePool.size(req), Truncated: truncated}
+
+	if req.ClimateDiagnostics {
+		if err := s.attachClimateDiagnostics(ctx, location, candidates, req.climateVariableWeights(), req.EnvelopeMode); err != nil {
+			return nil, fmt.Errorf("failed to load climate envelopes: %w", err)
+		}
+		tel.phase("climate_diagnostics")
+	}
 
 	if len(candidates) == 0 {
 		return nil, fmt.Errorf("no species found matching criteria (try lowering climate_threshold)")
@@ -207,63 +410,163 @@ func executeRecommendation(db *sql.DB, req RecommendRequest) (*RecommendResponse
 
 	var selected []SpeciesRecommendation
 	var metrics DiversityMetrics
+	var annealing *AnnealingStats
 
 	if req.NSpecies > 0 && req.NSpecies < len(candidates) {
 		// 3. Load trait vectors
-		traitVectors, err := loadTraitVectors(db, candidates)
+		traitVectors, err := s.loadTraitVectors(ctx, candidates, req.Preferences.IncludeFlaggedTraits)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load traits: %w", err)
 		}
+		tel.phase("traits")
 
-		// 4. Greedy diversity maximization
-		selected = greedyDiversitySelection(candidates, traitVectors, req.NSpecies)
+		adjustments, err := plugins.scoreAdjustments(pluginCtx, candidates)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = elevationAdjustments(req, candidates, adjustments)
+		adjustments = hydrologyAdjustments(req.Preferences, candidates, adjustments)
+		tel.phase("plugin_scorers")
+
+		// 4. Diversity maximization (greedy, or annealing from the greedy set)
+		diversityWeight, climateWeight := req.selectionWeights()
+		opts := selectionOptions{
+			Start:            startStrategies[req.StartStrategy],
+			TopK:             req.StartTopK,
+			Seed:             req.StartSeed,
+			Adjustments:      adjustments,
+			DiversityWeight:  diversityWeight,
+			ClimateWeight:    climateWeight,
+			GrowthFormQuotas: req.GrowthFormQuotas,
+			OnSelect:         obs.selectFunc(),
+		}
+		if req.Algorithm == "annealing" {
+			selected, annealing, err = annealingSelection(ctx, candidates, traitVectors, req.NSpecies, opts, req.annealingConfig())
+			if err != nil {
+				return nil, fmt.Errorf("annealing selection: %w", err)
+			}
+		} else {
+			selected = greedyDiversitySelection(candidates, traitVectors, req.NSpecies, opts)
+		}
+		tel.greedy(len(candidates), len(selected))
+		tel.phase("selection")
+
+		logPoolTruncation(location, pool, candidates, selected)
 
 		// 5. Calculate final metrics
-		metrics = calculateDiversityMetrics(selected, traitVectors)
+		metrics = calculateDiversityMetrics(selected, traitVectors, req.metricWeights())
+		tel.phase("metrics")
 	} else {
 		// Return all candidates (no greedy selection)
 		selected = candidates
+		onSelect := obs.selectFunc()
 		for i := range selected {
 			selected[i].SelectionRank = i + 1
+			if onSelect != nil {
+				onSelect(selected[i])
+			}
 		}
 		metrics = DiversityMetrics{
 			NSpecies: len(selected),
 		}
 		// Count families and growth forms
 		families := map[string]bool{}
-		gforms := map[string]bool{}
+		gforms := map[string]int{}
 		for _, sp := range selected {
 			families[sp.Family] = true
-			gforms[sp.GrowthForm] = true
+			gforms[sp.GrowthForm]++
 		}
 		metrics.NFamilies = len(families)
 		metrics.NGrowthForms = len(gforms)
+		metrics.GrowthFormComposition = growthFormComposition(gforms, len(selected))
 	}
 
-	// 6. Cache result
-	speciesIDs := make([]int64, len(selected))
-	for i, sp := range selected {
-		speciesIDs[i] = sp.SpeciesID
-	}
-	cacheRecommendation(db, req.CacheKey(), req, speciesIDs, metrics, 24*time.Hour)
-
-	return &RecommendResponse{
+	diversityWeight, climateWeight := req.selectionWeights()
+	resp = &RecommendResponse{
 		Species:          selected,
 		DiversityMetrics: metrics,
 		LocationInfo:     location,
-	}, nil
+		StartStrategy:    req.StartStrategy,
+		StartSeed:        req.StartSeed,
+		Algorithm:        req.Algorithm,
+		Annealing:        annealing,
+		DiversityWeight:  diversityWeight,
+		ClimateWeight:    climateWeight,
+		MetricWeights:    req.metricWeights(),
+		CandidatePool:    pool,
+	}
+	if req.SuccessionStages {
+		resp.Succession = groupBySuccession(resp.Species)
+	}
+
+	// 6. Plugin post-processing
+	if err := plugins.postProcess(pluginCtx, resp); err != nil {
+		return nil, err
+	}
+	tel.phase("post_process")
+
+	// 7. Compliance report
+	if req.Compliance {
+		rs, err := s.loadComplianceRuleSet(ctx, complianceCode(req.ComplianceRuleSet, req.StateCode))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load compliance rule set: %w", err)
+		}
+		resp.Compliance = evaluateCompliance(rs, resp.Species, nil)
+		tel.phase("compliance")
+	}
+
+	resp.RandomSeed = req.RandomSeed
+	resp.SelectionHash = selectionHash(resp.Species)
+
+	// 8. Cache the finished response
+	if err := s.cacheRecommendation(ctx, req.CacheKey(), req, resp, 24*time.Hour); err != nil {
+		s.log.Printf("Error caching recommendation: %v", err)
+	}
+	tel.phase("cache")
+
+	if req.ShareForResearch && s.cfg.Dataset.Store != nil {
+		go s.recordResearchContribution(anonymizeRecommendation(req, resp, time.Now()))
+	}
+
+	tel.NSelected = len(resp.Species)
+	finalMetrics := resp.DiversityMetrics
+	tel.Metrics = &finalMetrics
+	return resp, nil
 }
 
 // ============================================================================
 // LOCATION RESOLUTION
 // ============================================================================
 
-func resolveLocation(db *sql.DB, req RecommendRequest) (LocationInfo, error) {
+func (s *Server) resolveLocation(ctx context.Context, req RecommendRequest) (LocationInfo, error) {
+	location, err := s.resolveLocationClimate(ctx, req)
+	location.ElevationM = req.ElevationM
+	location.SoilPH, location.SoilTexture = req.SoilPH, req.SoilTexture
+	if err == nil && len(location.Level3Codes) > 0 {
+		location.KoppenZone = s.unitKoppenZone(ctx, location.Level3Codes)
+	} else if err == nil {
+		location.KoppenZone = s.siteKoppenZone(ctx, location.TDWGCode)
+	}
+	return location, err
+}
+
+// resolveLocationClimate resolves the request location to a TDWG unit and
+// the climate used for matching
+func (s *Server) resolveLocationClimate(ctx context.Context, req RecommendRequest) (LocationInfo, error) {
 	var location LocationInfo
 
-	// Case 1: TDWG code provided
+	// Case 0: stored area of interest (its largest TDWG overlap and climate)
+	if req.AOIID != "" {
+		return s.resolveAOILocation(ctx, req.AOIID)
+	}
+
+	// Case 1: TDWG code provided (a level-2 code stands for its level-3
+	// regions)
+	if isLevel2Code(req.TDWGCode) {
+		return s.resolveLevel2Location(ctx, req.TDWGCode)
+	}
 	if req.TDWGCode != "" {
-		err := db.QueryRow(`
+		err := s.db.QueryRowContext(ctx, `
 			SELECT c.tdwg_code, COALESCE(t.level3_name, c.tdwg_code),
 			       c.bio1_mean, c.bio5_mean, c.bio6_mean, c.bio12_mean, c.bio15_mean
 			FROM tdwg_climate c
@@ -280,30 +583,14 @@ func resolveLocation(db *sql.DB, req RecommendRequest) (LocationInfo, error) {
 		if err != nil {
 			return location, fmt.Errorf("invalid TDWG code: %s", req.TDWGCode)
 		}
+		location.RegionClimate = true
 		return location, nil
 	}
 
-	// Case 2: Brazilian state code provided
+	// Case 2: ISO 3166 country or subdivision code provided (see
+	// admin_units.go)
 	if req.StateCode != "" {
-		// Map state to TDWG (simplified - would need proper mapping table)
-		stateToTDWG := map[string]string{
-			"BR-SC": "BZS", "BR-PR": "BZS", "BR-RS": "BZS", // South
-			"BR-SP": "BZL", "BR-RJ": "BZL", "BR-ES": "BZL", // Southeast
-			"BR-MG": "BZL",
-			"BR-BA": "BZE", "BR-SE": "BZE", "BR-AL": "BZE", // East
-			"BR-PE": "BZE", "BR-PB": "BZE", "BR-RN": "BZE",
-			"BR-CE": "BZN", "BR-PI": "BZN", "BR-MA": "BZN", // North
-			"BR-PA": "BZN", "BR-AM": "BZN", "BR-RR": "BZN",
-			"BR-GO": "BZC", "BR-MT": "BZC", "BR-MS": "BZC", "BR-DF": "BZC", // Central
-		}
-
-		tdwgCode, ok := stateToTDWG[req.StateCode]
-		if !ok {
-			return location, fmt.Errorf("invalid state code: %s", req.StateCode)
-		}
-
-		req.TDWGCode = tdwgCode
-		return resolveLocation(db, req)
+		return s.resolveAdminLocation(ctx, req)
 	}
 
 	// Case 3: Coordinates provided
@@ -312,7 +599,7 @@ func resolveLocation(db *sql.DB, req RecommendRequest) (LocationInfo, error) {
 		lon := *req.Longitude
 
 		// Get TDWG code and name from coordinates
-		err := db.QueryRow(`
+		err := s.db.QueryRowContext(ctx, `
 			SELECT level3_code, level3_name FROM get_tdwg_by_coords($1, $2)
 		`, lat, lon).Scan(&location.TDWGCode, &location.TDWGName)
 
@@ -320,8 +607,18 @@ func resolveLocation(db *sql.DB, req RecommendRequest) (LocationInfo, error) {
 			return location, fmt.Errorf("failed to resolve coordinates to TDWG: %w", err)
 		}
 
+		if req.BorderBlendKm > 0 {
+			blend, err := s.borderRegions(ctx, lat, lon, req.BorderBlendKm)
+			if err != nil {
+				return location, fmt.Errorf("failed to find border regions: %w", err)
+			}
+			if len(blend) > 1 {
+				location.Blend = blend
+			}
+		}
+
 		// Get climate at point using pivot query
-		err = db.QueryRow(`
+		err = s.db.QueryRowContext(ctx, `
 			SELECT
 				MAX(CASE WHEN bio_var = 'bio1' THEN value END) as bio1,
 				MAX(CASE WHEN bio_var = 'bio5' THEN value END) as bio5,
@@ -337,9 +634,15 @@ func resolveLocation(db *sql.DB, req RecommendRequest) (LocationInfo, error) {
 			&location.Bio15,
 		)
 
-		if err != nil || location.Bio1 == 0 {
+		if (err != nil || location.Bio1 == 0) && location.Blend != nil {
+			// Fallback to the blended TDWG climate if raster fails
+			err = s.setBlendedClimate(ctx, &location, location.Blend)
+			if err != nil {
+				return location, fmt.Errorf("failed to get climate data: %w", err)
+			}
+		} else if err != nil || location.Bio1 == 0 {
 			// Fallback to TDWG climate if raster fails
-			err = db.QueryRow(`
+			err = s.db.QueryRowContext(ctx, `
 				SELECT c.bio1_mean, c.bio5_mean, c.bio6_mean, c.bio12_mean, c.bio15_mean
 				FROM tdwg_climate c
 				WHERE c.tdwg_code = $1
@@ -353,6 +656,7 @@ func resolveLocation(db *sql.DB, req RecommendRequest) (LocationInfo, error) {
 			if err != nil {
 				return location, fmt.Errorf("failed to get climate data: %w", err)
 			}
+			location.RegionClimate = true
 		}
 
 		location.Latitude = req.Latitude
@@ -361,84 +665,189 @@ func resolveLocation(db *sql.DB, req RecommendRequest) (LocationInfo, error) {
 		return location, nil
 	}
 
-	return location, fmt.Errorf("must provide either tdwg_code, state_code, or coordinates")
+	return location, fmt.Errorf("must provide either aoi_id, tdwg_code, state_code, or coordinates")
 }
 
 // ============================================================================
 // CLIMATE-ADAPTED SPECIES QUERY
 // ============================================================================
 
-func getClimateAdaptedSpecies(db *sql.DB, loc LocationInfo, req RecommendRequest) ([]SpeciesRecommendation, error) {
-	// Build WHERE clause from preferences
-	whereClause := buildWhereClause(req.Preferences)
+// getClimateAdaptedSpecies returns the candidates for req in climate order,
+// at most s.cfg.CandidatePool.size(req) of them (all when it is 0); truncated
+// reports whether more passed the filters
+func (s *Server) getClimateAdaptedSpecies(ctx context.Context, loc LocationInfo, req RecommendRequest) (candidates []SpeciesRecommendation, truncated bool, err error) {
+	limit := s.cfg.CandidatePool.size(req)
+	query, args, err := candidateQuery(loc, req, limit)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sp SpeciesRecommendation
+		err := rows.Scan(
+			&sp.SpeciesID, &sp.CanonicalName, &sp.Family, &sp.GrowthForm,
+			&sp.MaxHeightM, &sp.LifespanYears, &sp.IsNitrogenFixer,
+			&sp.ThreatStatus, &sp.IsNative, &sp.IsEndemic, &sp.EstablishmentMeans,
+			&sp.ElevationLowM, &sp.ElevationHighM, &sp.WetlandIndicator, &sp.LightRequirement,
+			&sp.ClimateMatchScore, &sp.RegionWeight, &sp.Abundance, &sp.CommonNamePT, &sp.CommonNameEN,
+			pq.Array(&sp.KoppenZones),
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		candidates = append(candidates, sp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if limit > 0 && len(candidates) > limit {
+		return candidates[:limit], true, nil
+	}
+	return candidates, false, nil
+}
+
+// candidateQuery builds the candidate query of getClimateAdaptedSpecies and
+// its args, fetching limit+1 rows, or all for a limit of 0. Every arg is referenced by the query:
+// Postgres cannot type an unused placeholder and rejects the statement.
+func candidateQuery(loc LocationInfo, req RecommendRequest, limit int) (string, []interface{}, error) {
+	args := []interface{}{
+		loc.Bio1,
+		loc.Bio5,
+		loc.Bio6,
+		loc.Bio12,
+		loc.Bio15,
+		loc.TDWGCode,
+		req.ClimateThreshold,
+	}
 
 	// Build native/introduced filter
 	nativeClause := "AND sr.is_native = TRUE"
-	if req.Preferences.IncludeIntroduced {
+	if len(req.Preferences.EstablishmentMeans) > 0 {
+		// Explicit establishment statuses (validated by the handler)
+		nativeClause = "AND sr.establishment_means::text = ANY($8)"
+		args = append(args, pq.Array(req.Preferences.EstablishmentMeans))
+	} else if req.Preferences.IncludeIntroduced {
 		// Accept both native AND introduced species
 		nativeClause = "AND (sr.is_native = TRUE OR sr.is_introduced = TRUE)"
 	}
+	regionJoin, regionClause, regionWeight, args := candidateRegionSQL(loc, nativeClause, args)
+
+	// Build WHERE clause from preferences
+	whereClause, args := buildWhereClause(req.Preferences, args)
+
+	elevationClause := elevationFilterSQL(req.Preferences, len(args)+1)
+	if elevationClause != "" {
+		args = append(args, *req.ElevationM, *req.Preferences.ElevationToleranceM)
+	}
+
+	hydrologyClause, hydrologyArg := hydrologyFilterSQL(req.Preferences, len(args)+1)
+	if hydrologyClause != "" {
+		args = append(args, hydrologyArg)
+	}
+
+	frostClause := frostMarginFilterSQL(req.Preferences, len(args)+1)
+	if frostClause != "" {
+		args = append(args, *req.Preferences.FrostSafetyMarginC)
+	}
+
+	koppenClause := koppenFilterSQL(req.Preferences, len(args)+1)
+	if koppenClause != "" {
+		if loc.KoppenZone == nil {
+			return "", nil, fmt.Errorf("koppen_match: no Köppen zone known for %s", loc.TDWGCode)
+		}
+		args = append(args, *loc.KoppenZone)
+	}
+
+	soilClause, soilArgs := soilFilterSQL(req, len(args)+1)
+	args = append(args, soilArgs...)
+
+	abundanceClause := abundanceFilterSQL(req.Preferences, loc, len(args)+1)
+	if abundanceClause != "" {
+		args = append(args, req.Preferences.MinAbundance)
+	}
+
+	climateMatch, args := climateMatchSQL(req, "s.id", args)
+	scoreJoin := storedScoreJoin(loc, req)
+	if scoreJoin != "" {
+		climateMatch = "COALESCE(rcm.score, " + climateMatch + ")"
+	}
+	thresholdClause := "AND " + climateMatch + " >= $7"
+	if req.Preferences.KoppenMatch == "only" {
+		// The zone overlap replaces the threshold; $7 stays referenced
+		thresholdClause = "AND ($7::float8 IS NULL OR TRUE)"
+	}
+	// With a blend, candidates are ranked by the weighted match (see
+	// rankScore); climate_match_score stays the unweighted one
+	rank := climateMatch
+	if len(loc.Blend) > 1 {
+		rank = "(" + climateMatch + ") * " + regionWeight
+	}
+
+	// One row past the limit tells whether the pool was truncated; no
+	// limit binds NULL, which Postgres reads as LIMIT ALL
+	var limitArg interface{}
+	if limit > 0 {
+		limitArg = limit + 1
+	}
+	args = append(args, limitArg)
+	limitParam := len(args)
 
+	includeFlagged := req.Preferences.IncludeFlaggedTraits
 	query := fmt.Sprintf(`
 		SELECT
 			s.id,
 			s.canonical_name,
 			COALESCE(s.family, 'Unknown') as family,
 			COALESCE(su.growth_form, 'unknown') as growth_form,
-			su.max_height_m,
-			su.lifespan_years,
+			%s as max_height_m,
+			%s as lifespan_years,
 			COALESCE(tv.is_nitrogen_fixer, false) as is_nitrogen_fixer,
 			su.threat_status,
 			COALESCE(sr.is_native, false) as is_native,
 			COALESCE(sr.is_endemic, false) as is_endemic,
-			calculate_climate_match(s.id, $1, $2, $3, $4, $5) as climate_match_score,
+			sr.establishment_means::text,
+			ev.low_m, ev.high_m,
+			su.wetland_indicator,
+			su.light_requirement,
+			%s as climate_match_score,
+			%s as region_weight,
+			%s as abundance,
 			cn_pt.common_name as common_name_pt,
-			cn_en.common_name as common_name_en
+			cn_en.common_name as common_name_en,
+			skz.koppen_zones
 		FROM species s
 		JOIN species_unified su ON s.id = su.species_id
-		JOIN species_regions sr ON s.id = sr.species_id
+		%s
 		JOIN species_climate_envelope_unified sce ON s.id = sce.species_id
+		%s
 		LEFT JOIN species_trait_vectors tv ON s.id = tv.species_id
+		LEFT JOIN species_elevation_unified ev ON s.id = ev.species_id
 		LEFT JOIN common_names cn_pt ON s.id = cn_pt.species_id AND cn_pt.language = 'pt'
 		LEFT JOIN common_names cn_en ON s.id = cn_en.species_id AND cn_en.language = 'en'
-		WHERE sr.tdwg_code = $6
-		  %s
+		LEFT JOIN species_koppen_zones skz ON s.id = skz.species_id
+		WHERE %s
 		  AND su.growth_form IS NOT NULL
-		  AND calculate_climate_match(s.id, $1, $2, $3, $4, $5) >= $7
 		  %s
-		ORDER BY climate_match_score DESC
-	`, nativeClause, whereClause)
-
-	rows, err := db.Query(query,
-		loc.Bio1,
-		loc.Bio5,
-		loc.Bio6,
-		loc.Bio12,
-		loc.Bio15,
-		loc.TDWGCode,
-		req.ClimateThreshold,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var candidates []SpeciesRecommendation
-	for rows.Next() {
-		var sp SpeciesRecommendation
-		err := rows.Scan(
-			&sp.SpeciesID, &sp.CanonicalName, &sp.Family, &sp.GrowthForm,
-			&sp.MaxHeightM, &sp.LifespanYears, &sp.IsNitrogenFixer,
-			&sp.ThreatStatus, &sp.IsNative, &sp.IsEndemic,
-			&sp.ClimateMatchScore, &sp.CommonNamePT, &sp.CommonNameEN,
-		)
-		if err != nil {
-			return nil, err
-		}
-		candidates = append(candidates, sp)
-	}
-
-	return candidates, nil
+		  %s
+		  %s
+		  %s
+		  %s
+		  %s
+		  %s
+		  %s
+		ORDER BY %s DESC, s.id
+		LIMIT $%d
+	`, cleanTraitSQL("s.id", "su.max_height_m", "max_height_m", includeFlagged),
+		cleanTraitSQL("s.id", "su.lifespan_years", "lifespan_years", includeFlagged),
+		climateMatch, regionWeight, abundanceSelectSQL(loc), regionJoin, scoreJoin, regionClause, thresholdClause, whereClause, elevationClause, hydrologyClause, frostClause, koppenClause, soilClause, abundanceClause, rank, limitParam)
+	return query, args, nil
 }
 
 // validGrowthForms defines the 11 accepted growth form values
@@ -448,32 +857,71 @@ var validGrowthForms = map[string]bool{
 	"palm": true, "bamboo": true, "other": true,
 }
 
-// joinWithOr joins SQL clauses with OR operator
-func joinWithOr(clauses []string) string {
-	result := ""
-	for i, clause := range clauses {
-		if i > 0 {
-			result += " OR "
+// validEstablishmentMeans mirrors the establishment_means enum on species_regions
+var validEstablishmentMeans = map[string]bool{
+	"native": true, "naturalized": true, "invasive": true, "cultivated": true,
+}
+
+// parseEstablishmentMeans validates a list of establishment statuses
+func parseEstablishmentMeans(values []string) ([]string, error) {
+	var statuses []string
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if !validEstablishmentMeans[v] {
+			return nil, fmt.Errorf("invalid establishment status: %s (use native, naturalized, invasive or cultivated)", v)
+		}
+		statuses = append(statuses, v)
+	}
+	return statuses, nil
+}
+
+// maxExclusions bounds exclude_species and exclude_families each
+const maxExclusions = 1000
+
+// parseExclusions normalizes an exclusion (or other name) list to distinct
+// lowercase names
+func parseExclusions(field string, values []string) ([]string, error) {
+	if len(values) > maxExclusions {
+		return nil, fmt.Errorf("%s: at most %d entries", field, maxExclusions)
+	}
+	seen := make(map[string]bool, len(values))
+	var names []string
+	for _, v := range values {
+		v = strings.ToLower(strings.Join(strings.Fields(v), " "))
+		if v == "" || seen[v] {
+			continue
 		}
-		result += clause
+		seen[v] = true
+		names = append(names, v)
 	}
-	return result
+	sort.Strings(names)
+	return names, nil
 }
 
-func buildWhereClause(prefs Preferences) string {
+// buildWhereClause returns the preference filters as an AND clause. Values
+// are never written into the SQL: each becomes the next placeholder after
+// args, and the extended args are returned.
+func buildWhereClause(prefs Preferences, args []interface{}) (string, []interface{}) {
 	var clauses []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
 
 	if len(prefs.GrowthForms) > 0 {
-		var formClauses []string
+		var forms []string
 		for _, form := range prefs.GrowthForms {
 			if validGrowthForms[form] {
-				formClauses = append(formClauses, fmt.Sprintf("su.growth_form = '%s'", form))
+				forms = append(forms, form)
 			}
 		}
 
-		// Combine with OR (any of the growth forms)
-		if len(formClauses) > 0 {
-			clauses = append(clauses, "("+joinWithOr(formClauses)+")")
+		// Any of the growth forms
+		if len(forms) > 0 {
+			clauses = append(clauses, "su.growth_form = ANY("+arg(pq.Array(forms))+")")
 		}
 	}
 
@@ -481,12 +929,15 @@ func buildWhereClause(prefs Preferences) string {
 		clauses = append(clauses, "(su.threat_status IS NULL OR su.threat_status NOT IN ('CR', 'EN', 'VU'))")
 	}
 
+	// Flagged (implausible) heights never satisfy a height filter by default
+	height := cleanTraitSQL("su.species_id", "su.max_height_m", "max_height_m", prefs.IncludeFlaggedTraits)
+
 	if prefs.MinHeightM != nil {
-		clauses = append(clauses, fmt.Sprintf("su.max_height_m >= %.2f", *prefs.MinHeightM))
+		clauses = append(clauses, height+" >= "+arg(*prefs.MinHeightM))
 	}
 
 	if prefs.MaxHeightM != nil {
-		clauses = append(clauses, fmt.Sprintf("su.max_height_m <= %.2f", *prefs.MaxHeightM))
+		clauses = append(clauses, height+" <= "+arg(*prefs.MaxHeightM))
 	}
 
 	if prefs.NitrogenFixersOnly {
@@ -497,30 +948,29 @@ func buildWhereClause(prefs Preferences) string {
 		clauses = append(clauses, "sr.is_endemic = TRUE")
 	}
 
-	if len(clauses) == 0 {
-		return ""
+	// Exclusions are lowercased by parseExclusions
+	if len(prefs.ExcludeSpecies) > 0 {
+		clauses = append(clauses, "NOT (lower(s.canonical_name) = ANY("+arg(pq.Array(prefs.ExcludeSpecies))+"))")
 	}
 
-	return " AND " + joinWithAnd(clauses)
-}
+	if len(prefs.ExcludeFamilies) > 0 {
+		clauses = append(clauses, "(s.family IS NULL OR NOT (lower(s.family) = ANY("+arg(pq.Array(prefs.ExcludeFamilies))+")))")
+	}
 
-// joinWithAnd joins SQL clauses with AND operator
-func joinWithAnd(clauses []string) string {
-	result := ""
-	for i, clause := range clauses {
-		if i > 0 {
-			result += " AND "
-		}
-		result += clause
+	clauses = append(clauses, brazilFloraClauses(prefs, arg)...)
+
+	if len(clauses) == 0 {
+		return "", args
 	}
-	return result
+
+	return " AND " + strings.Join(clauses, " AND "), args
 }
 
 // ============================================================================
 // TRAIT VECTOR LOADING
 // ============================================================================
 
-func loadTraitVectors(db *sql.DB, candidates []SpeciesRecommendation) (map[int64]TraitVector, error) {
+func (s *Server) loadTraitVectors(ctx context.Context, candidates []SpeciesRecommendation, includeFlagged bool) (map[int64]TraitVector, error) {
 	if len(candidates) == 0 {
 		return make(map[int64]TraitVector), nil
 	}
@@ -531,20 +981,21 @@ func loadTraitVectors(db *sql.DB, candidates []SpeciesRecommendation) (map[int64
 		ids[i] = c.SpeciesID
 	}
 
-	// Query trait vectors
-	query := `
+	// Query trait vectors (flagged heights/lifespans fall back to the defaults)
+	query := fmt.Sprintf(`
 		SELECT species_id,
 		       COALESCE(is_tree, false), COALESCE(is_shrub, false),
 		       COALESCE(is_herb, false), COALESCE(is_climber, false),
 		       COALESCE(is_palm, false), COALESCE(is_nitrogen_fixer, false),
-		       COALESCE(height_normalized, 0.25), COALESCE(lifespan_normalized, 0.3),
+		       COALESCE(%s, 0.25), COALESCE(%s, 0.3),
 		       COALESCE(dispersal_animal, false), COALESCE(dispersal_wind, false),
 		       COALESCE(family_code, 0)
 		FROM species_trait_vectors
 		WHERE species_id = ANY($1)
-	`
+	`, cleanTraitSQL("species_id", "height_normalized", "max_height_m", includeFlagged),
+		cleanTraitSQL("species_id", "lifespan_normalized", "lifespan_years", includeFlagged))
 
-	rows, err := db.Query(query, pq.Array(ids))
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -572,62 +1023,294 @@ func loadTraitVectors(db *sql.DB, candidates []SpeciesRecommendation) (map[int64
 // GREEDY DIVERSITY SELECTION ALGORITHM
 // ============================================================================
 
+// selectionOptions tunes greedyDiversitySelection
+type selectionOptions struct {
+	Start       startStrategy     // Picks the first species (nil: startBestClimate)
+	TopK        int               // random_top_k pool size
+	Seed        *int64            // random_top_k seed
+	Adjustments map[int64]float64 // Plugin score adjustments (may be nil)
+
+	// Weights of marginal diversity and climate match in the greedy score
+	// (both 0: the defaults, 0.7 and 0.3)
+	DiversityWeight float64
+	ClimateWeight   float64
+
+	// Selected species are kept, in order, and selection continues from
+	// them instead of from the start strategy (see sandbox.go)
+	Selected []SpeciesRecommendation
+
+	// Target share of n_species per growth form (see quotas.go)
+	GrowthFormQuotas map[string]float64
+
+	// OnSelect, if set, is called with each species as soon as it is chosen
+	OnSelect func(SpeciesRecommendation)
+}
+
 func greedyDiversitySelection(
 	candidates []SpeciesRecommendation,
 	traits map[int64]TraitVector,
 	nSpecies int,
+	opts selectionOptions,
 ) []SpeciesRecommendation {
-	if len(candidates) == 0 || nSpecies == 0 {
+	if (len(candidates) == 0 && len(opts.Selected) == 0) || nSpecies == 0 {
 		return []SpeciesRecommendation{}
 	}
+	adjustments := opts.Adjustments
+	start := opts.Start
+	if start == nil {
+		start = startBestClimate
+	}
+	diversityWeight, climateWeight := opts.DiversityWeight, opts.ClimateWeight
+	if diversityWeight == 0 && climateWeight == 0 {
+		diversityWeight, climateWeight = defaultDiversityWeight, defaultClimateWeight
+	}
 
 	selected := []SpeciesRecommendation{}
-	remaining := make([]SpeciesRecommendation, len(candidates))
-	copy(remaining, candidates)
+	kept := make(map[int64]bool, len(opts.Selected))
+	for _, sp := range opts.Selected {
+		contribution := calculateMarginalDiversity(selected, sp, traits)
+		sp.SelectionRank = len(selected) + 1
+		sp.DiversityContribution = contribution
+		selected = append(selected, sp)
+		kept[sp.SpeciesID] = true
+	}
+	remaining := make([]SpeciesRecommendation, 0, len(candidates))
+	for _, c := range candidates {
+		if !kept[c.SpeciesID] {
+			remaining = append(remaining, c)
+		}
+	}
 
-	// Start with best climate match
-	selected = append(selected, remaining[0])
-	remaining = remaining[1:]
+	quota := newQuotaTracker(opts.GrowthFormQuotas, nSpecies, remaining)
+	for _, sp := range selected {
+		quota.take(sp.GrowthForm, false)
+	}
+
+	// minDist[i] is calculateMarginalDiversity(selected, remaining[i]),
+	// updated with the distance to each picked species rather than
+	// recomputed against the whole selection every round
+	minDist := make([]float64, len(remaining))
+	for i, c := range remaining {
+		minDist[i] = calculateMarginalDiversity(selected, c, traits)
+	}
+
+	// pick moves remaining[idx] to selected with its final rank and
+	// diversity contribution (the first species contributes 1.0)
+	pick := func(idx int, contribution float64) {
+		sp := remaining[idx]
+		sp.SelectionRank = len(selected) + 1
+		sp.DiversityContribution = contribution
+		selected = append(selected, sp)
+		quota.take(sp.GrowthForm, true)
+		remaining = append(remaining[:idx], remaining[idx+1:]...)
+		minDist = append(minDist[:idx], minDist[idx+1:]...)
+		picked := traits[sp.SpeciesID]
+		for i, c := range remaining {
+			if d := gowerDistance(traits[c.SpeciesID], picked); d < minDist[i] {
+				minDist[i] = d
+			}
+		}
+		if opts.OnSelect != nil {
+			opts.OnSelect(sp)
+		}
+	}
+
+	// Seed the selection with the start strategy's pick, among the
+	// candidates the quotas allow
+	quota.release()
+	if len(selected) == 0 && len(remaining) > 0 {
+		pool, index := remaining, []int(nil)
+		if quota != nil {
+			pool = nil
+			for i, c := range remaining {
+				if quota.allows(c.GrowthForm) {
+					pool = append(pool, c)
+					index = append(index, i)
+				}
+			}
+		}
+		if len(pool) > 0 {
+			idx := start(pool, traits, opts)
+			if index != nil {
+				idx = index[idx]
+			}
+			pick(idx, 1.0)
+		}
+	}
 
 	// Iteratively add species maximizing marginal diversity
 	for len(selected) < nSpecies && len(remaining) > 0 {
 		bestIdx := -1
-		bestScore := -1.0
+		bestScore := 0.0
+		bestGain := 0.0
 
+		quota.release()
 		for i, candidate := range remaining {
+			if !quota.allows(candidate.GrowthForm) {
+				continue
+			}
 			// Marginal diversity gain
-			diversityGain := calculateMarginalDiversity(selected, candidate, traits)
+			diversityGain := minDist[i]
 
-			// Combined score: diversity (70%) + climate match (30%)
-			combinedScore := diversityGain*0.7 + candidate.ClimateMatchScore*0.3
+			// Combined score: weighted diversity gain + climate match
+			combinedScore := diversityGain*diversityWeight + candidate.rankScore()*climateWeight
+			combinedScore += adjustments[candidate.SpeciesID]
 
-			if combinedScore > bestScore {
+			if bestIdx < 0 || preferCandidate(combinedScore, candidate, bestScore, remaining[bestIdx]) {
 				bestScore = combinedScore
+				bestGain = diversityGain
 				bestIdx = i
 			}
 		}
 
-		if bestIdx >= 0 {
-			selected = append(selected, remaining[bestIdx])
-			remaining = append(remaining[:bestIdx], remaining[bestIdx+1:]...)
-		} else {
+		if bestIdx < 0 {
 			break
 		}
+		pick(bestIdx, bestGain)
 	}
 
-	// Assign ranks and diversity contributions
-	for i := range selected {
-		selected[i].SelectionRank = i + 1
-		if i == 0 {
-			selected[i].DiversityContribution = 1.0
-		} else {
-			selected[i].DiversityContribution = calculateMarginalDiversity(
-				selected[:i], selected[i], traits,
-			)
+	return selected
+}
+
+// ============================================================================
+// TIE-BREAKING
+// ============================================================================
+//
+// Selection must not depend on candidate slice order. Whenever two candidates
+// compete, the winner is decided by, in order:
+//   1. higher score (combined greedy score, or the strategy's own metric)
+//   2. higher rank score (the climate match, weighted with a border blend)
+//   3. lower species ID
+// Scores are compared rounded to multiples of scoreTieEpsilon, so
+// floating-point noise from summing the same terms in a different order
+// cannot decide a tie. Rounding (rather than "closer than epsilon") keeps
+// the comparison transitive: a chain of near-equal scores cannot make the
+// winner depend on which candidate was seen first.
+
+const scoreTieEpsilon = 1e-9
+
+func quantizeScore(s float64) float64 {
+	return math.Round(s / scoreTieEpsilon)
+}
+
+// preferCandidate reports whether a (with score scoreA) beats b (with scoreB)
+func preferCandidate(scoreA float64, a SpeciesRecommendation, scoreB float64, b SpeciesRecommendation) bool {
+	if qa, qb := quantizeScore(scoreA), quantizeScore(scoreB); qa != qb {
+		return qa > qb
+	}
+	if qa, qb := quantizeScore(a.rankScore()), quantizeScore(b.rankScore()); qa != qb {
+		return qa > qb
+	}
+	return a.SpeciesID < b.SpeciesID
+}
+
+// ============================================================================
+// GREEDY START STRATEGIES
+// ============================================================================
+
+// startStrategy returns the index of the candidate the greedy loop starts from.
+// candidates is never empty.
+type startStrategy func(candidates []SpeciesRecommendation, traits map[int64]TraitVector, opts selectionOptions) int
+
+const defaultStartTopK = 10
+
+var startStrategies = map[string]startStrategy{
+	"best_climate":  startBestClimate,
+	"most_distinct": startMostDistinct,
+	"random_top_k":  startRandomTopK,
+}
+
+// startBestClimate picks the highest climate match
+func startBestClimate(candidates []SpeciesRecommendation, _ map[int64]TraitVector, _ selectionOptions) int {
+	best := 0
+	for i, c := range candidates {
+		if preferCandidate(c.rankScore(), c, candidates[best].rankScore(), candidates[best]) {
+			best = i
 		}
 	}
+	return best
+}
 
-	return selected
+// startMostDistinct picks the candidate with the largest mean Gower distance
+// to the rest of the pool, i.e. the most atypical trait vector
+func startMostDistinct(candidates []SpeciesRecommendation, traits map[int64]TraitVector, _ selectionOptions) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+
+	totals := make([]float64, len(candidates))
+	for i := range candidates {
+		ti := traits[candidates[i].SpeciesID]
+		for j := i + 1; j < len(candidates); j++ {
+			d := gowerDistance(ti, traits[candidates[j].SpeciesID])
+			totals[i] += d
+			totals[j] += d
+		}
+	}
+
+	best := 0
+	for i := range totals {
+		if preferCandidate(totals[i], candidates[i], totals[best], candidates[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// startRandomTopK picks uniformly among the TopK best climate matches using
+// Seed, so a given seed always reproduces the same start
+func startRandomTopK(candidates []SpeciesRecommendation, _ map[int64]TraitVector, opts selectionOptions) int {
+	k := opts.TopK
+	if k <= 0 {
+		k = defaultStartTopK
+	}
+	if k > len(candidates) {
+		k = len(candidates)
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ca, cb := candidates[order[a]], candidates[order[b]]
+		return preferCandidate(ca.rankScore(), ca, cb.rankScore(), cb)
+	})
+
+	var seed int64
+	if opts.Seed != nil {
+		seed = *opts.Seed
+	}
+	rng := rand.New(rand.NewSource(seed))
+	return order[rng.Intn(k)]
+}
+
+// parseStartStrategy validates the start_strategy request fields and fills
+// in defaults. random_top_k without a seed gets one (see determinism.go) so
+// the response can report it and the result can be reproduced.
+func parseStartStrategy(req *RecommendRequest) error {
+	if req.StartStrategy == "" {
+		req.StartStrategy = "best_climate"
+	}
+	if _, ok := startStrategies[req.StartStrategy]; !ok {
+		return fmt.Errorf("invalid start_strategy: %s (use best_climate, most_distinct or random_top_k)", req.StartStrategy)
+	}
+	if req.StartStrategy != "random_top_k" {
+		req.StartTopK = 0
+		req.StartSeed = nil
+		return nil
+	}
+	if req.StartTopK < 0 {
+		return fmt.Errorf("start_top_k must be positive")
+	}
+	if req.StartTopK == 0 {
+		req.StartTopK = defaultStartTopK
+	}
+	if req.StartSeed == nil {
+		seed := req.seed()
+		req.StartSeed, req.startSeedFilled = &seed, true
+	}
+	return nil
 }
 
 func calculateMarginalDiversity(
@@ -707,21 +1390,24 @@ func gowerDistance(a, b TraitVector) float64 {
 // DIVERSITY METRICS CALCULATION
 // ============================================================================
 
+// calculateDiversityMetrics summarizes a selection; weights combine the
+// three components into the total score (zero value: the defaults)
 func calculateDiversityMetrics(
 	species []SpeciesRecommendation,
 	traits map[int64]TraitVector,
+	weights MetricWeights,
 ) DiversityMetrics {
 	if len(species) == 0 {
 		return DiversityMetrics{}
 	}
 
-	// Count unique families and growth forms
+	// Count unique families and species per growth form
 	families := make(map[string]bool)
-	growthForms := make(map[string]bool)
+	growthForms := make(map[string]int)
 
 	for _, sp := range species {
 		families[sp.Family] = true
-		growthForms[sp.GrowthForm] = true
+		growthForms[sp.GrowthForm]++
 	}
 
 	// Functional diversity: mean pairwise distance
@@ -736,28 +1422,41 @@ func calculateDiversityMetrics(
 			pairs++
 		}
 	}
+	return summarizeDiversity(len(species), len(families), growthForms, totalDistance, pairs, weights)
+}
+
+// summarizeDiversity turns the counts and the pairwise distance sum of a
+// selection into its metrics (shared with diversityAccumulator)
+func summarizeDiversity(nSpecies, nFamilies int, growthForms map[string]int, totalDistance float64, pairs int, weights MetricWeights) DiversityMetrics {
+	if nSpecies == 0 {
+		return DiversityMetrics{}
+	}
+	nGrowthForms := len(growthForms)
+
 	functionalDiv := 0.0
 	if pairs > 0 {
 		functionalDiv = totalDistance / float64(pairs)
 	}
 
 	// Phylogenetic diversity (family-level proxy)
-	phyloDiv := float64(len(families)) / float64(len(species))
+	phyloDiv := float64(nFamilies) / float64(nSpecies)
 
 	// Growth form richness (max 5 forms: tree, shrub, herb, climber, palm)
-	gfRichness := float64(len(growthForms)) / 5.0
+	gfRichness := float64(nGrowthForms) / 5.0
 
 	// Total diversity score (weighted)
-	totalScore := functionalDiv*0.5 + phyloDiv*0.25 + gfRichness*0.25
+	w := weights.orDefault()
+	totalScore := functionalDiv*w.Functional + phyloDiv*w.Phylogenetic + gfRichness*w.GrowthForm
 
 	return DiversityMetrics{
 		FunctionalDiversity:   math.Round(functionalDiv*1000) / 1000,
 		PhylogeneticDiversity: math.Round(phyloDiv*1000) / 1000,
 		GrowthFormRichness:    math.Round(gfRichness*1000) / 1000,
 		TotalDiversityScore:   math.Round(totalScore*1000) / 1000,
-		NSpecies:              len(species),
-		NFamilies:             len(families),
-		NGrowthForms:          len(growthForms),
+		NSpecies:              nSpecies,
+		NFamilies:             nFamilies,
+		NGrowthForms:          nGrowthForms,
+		GrowthFormComposition: growthFormComposition(growthForms, nSpecies),
 	}
 }
 
@@ -765,20 +1464,26 @@ func calculateDiversityMetrics(
 // HTTP HANDLER
 // ============================================================================
 
-func handleRecommend(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
-		return
+// decodeRecommendRequest reads a RecommendRequest from the body, applies
+// defaults and validates it. Errors are written to w (400).
+func decodeRecommendRequest(w http.ResponseWriter, r *http.Request) (RecommendRequest, *pipelinePlugins, bool) {
+	var req RecommendRequest
+	if !decodeJSONBody(w, r, &req) {
+		return req, nil, false
 	}
 
-	var req RecommendRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
-		return
+	plugins, err := normalizeRecommendRequest(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return req, nil, false
 	}
+	demoCapSpecies(r.Context(), &req)
+	return req, plugins, true
+}
 
+// normalizeRecommendRequest applies defaults to req, validates it and
+// resolves its plugins
+func normalizeRecommendRequest(req *RecommendRequest) (*pipelinePlugins, error) {
 	// Set defaults (0 = return all candidates, no limit)
 	if req.NSpecies < 0 {
 		req.NSpecies = 0
@@ -787,22 +1492,139 @@ func handleRecommend(w http.ResponseWriter, r *http.Request) {
 		req.ClimateThreshold = 0.6
 	}
 
+	statuses, err := parseEstablishmentMeans(req.Preferences.EstablishmentMeans)
+	if err != nil {
+		return nil, err
+	}
+	req.Preferences.EstablishmentMeans = statuses
+
+	if req.Preferences.ExcludeSpecies, err = parseExclusions("exclude_species", req.Preferences.ExcludeSpecies); err != nil {
+		return nil, err
+	}
+	if req.Preferences.ExcludeFamilies, err = parseExclusions("exclude_families", req.Preferences.ExcludeFamilies); err != nil {
+		return nil, err
+	}
+	if req.Preferences.PhytogeographicDomains, err = parseExclusions("phytogeographic_domains", req.Preferences.PhytogeographicDomains); err != nil {
+		return nil, err
+	}
+	if req.Preferences.VegetationTypes, err = parseExclusions("vegetation_types", req.Preferences.VegetationTypes); err != nil {
+		return nil, err
+	}
+
+	if err := parseStartStrategy(req); err != nil {
+		return nil, err
+	}
+
+	if err := parseAlgorithm(req); err != nil {
+		return nil, err
+	}
+
+	if err := validateElevationPreferences(req); err != nil {
+		return nil, err
+	}
+
+	if err := validateSiteHydrology(&req.Preferences); err != nil {
+		return nil, err
+	}
+
+	if err := validateFrostSafetyMargin(&req.Preferences); err != nil {
+		return nil, err
+	}
+
+	if err := validateKoppenMatch(&req.Preferences); err != nil {
+		return nil, err
+	}
+
+	if err := validateSoilMatch(req); err != nil {
+		return nil, err
+	}
+
+	if err := validateMinAbundance(&req.Preferences); err != nil {
+		return nil, err
+	}
+
+	if err := normalizeGrowthFormQuotas(req); err != nil {
+		return nil, err
+	}
+
+	if err := normalizeWeights(req); err != nil {
+		return nil, err
+	}
+
+	if err := validateMaxCandidates(*req); err != nil {
+		return nil, err
+	}
+
+	if err := normalizeClimateVariables(req); err != nil {
+		return nil, err
+	}
+
+	if err := validateEnvelopeMode(req); err != nil {
+		return nil, err
+	}
+
+	if err := validateBorderBlend(*req); err != nil {
+		return nil, err
+	}
+
+	return resolvePlugins(req.Plugins)
+}
+
+func (s *Server) handleRecommend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, plugins, ok := decodeRecommendRequest(w, r)
+	if !ok {
+		return
+	}
+
+	display, ok := requestDisplay(w, r)
+	if !ok {
+		return
+	}
+	lang := requestLanguage(r)
+	setContentLanguage(w, lang)
+
 	// Check cache
 	cacheKey := req.CacheKey()
-	if cached, ok := getCachedRecommendation(db, cacheKey); ok {
+	if cached, ok := s.getCachedRecommendation(ctx, cacheKey); ok {
+		s.localizeRecommendation(ctx, cached, lang)
+		applyDisplay(cached, display)
+		cached.Watermark = watermark(ctx)
 		json.NewEncoder(w).Encode(cached)
 		return
 	}
 
 	// Execute recommendation
 	start := time.Now()
-	recommendations, err := executeRecommendation(db, req)
+	recommendations, err := s.executeRecommendation(ctx, req, plugins, nil)
 	if err != nil {
 		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
 		return
 	}
 
 	recommendations.QueryTime = time.Since(start).String()
+	recommendations.Watermark = watermark(ctx)
+	s.localizeRecommendation(ctx, recommendations, lang)
+	applyDisplay(recommendations, display)
 
 	json.NewEncoder(w).Encode(recommendations)
 }
+
+// handleRecommendPlugins handles GET /api/recommend/plugins
+func (s *Server) handleRecommendPlugins(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	names := registeredPlugins()
+	if names == nil {
+		names = []string{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"plugins": names})
+}
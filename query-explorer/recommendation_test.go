@@ -0,0 +1,723 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testPool builds a candidate pool dominated by similar trees, with one
+// palm whose trait vector is far from everything else. Candidates are in
+// descending climate order, as returned by getClimateAdaptedSpecies.
+func testPool() ([]SpeciesRecommendation, map[int64]TraitVector) {
+	candidates := []SpeciesRecommendation{
+		{SpeciesID: 1, Family: "Fabaceae", GrowthForm: "tree", ClimateMatchScore: 0.95},
+		{SpeciesID: 2, Family: "Fabaceae", GrowthForm: "tree", ClimateMatchScore: 0.93},
+		{SpeciesID: 3, Family: "Myrtaceae", GrowthForm: "tree", ClimateMatchScore: 0.91},
+		{SpeciesID: 4, Family: "Myrtaceae", GrowthForm: "shrub", ClimateMatchScore: 0.88},
+		{SpeciesID: 5, Family: "Fabaceae", GrowthForm: "tree", ClimateMatchScore: 0.86},
+		{SpeciesID: 6, Family: "Arecaceae", GrowthForm: "palm", ClimateMatchScore: 0.70},
+		{SpeciesID: 7, Family: "Poaceae", GrowthForm: "graminoid", ClimateMatchScore: 0.65},
+	}
+	traits := map[int64]TraitVector{
+		1: {IsTree: true, HeightNorm: 0.6, LifespanNorm: 0.7, IsNitrogenFixer: true, DispersalAnimal: true, FamilyCode: 1},
+		2: {IsTree: true, HeightNorm: 0.55, LifespanNorm: 0.6, IsNitrogenFixer: true, DispersalAnimal: true, FamilyCode: 1},
+		3: {IsTree: true, HeightNorm: 0.5, LifespanNorm: 0.6, DispersalAnimal: true, FamilyCode: 2},
+		4: {IsShrub: true, HeightNorm: 0.2, LifespanNorm: 0.3, DispersalAnimal: true, FamilyCode: 2},
+		5: {IsTree: true, HeightNorm: 0.65, LifespanNorm: 0.65, IsNitrogenFixer: true, DispersalWind: true, FamilyCode: 1},
+		6: {IsPalm: true, IsTree: true, HeightNorm: 1.0, LifespanNorm: 1.0, DispersalWind: true, FamilyCode: 3},
+		7: {IsHerb: true, HeightNorm: 0.0, LifespanNorm: 0.0, DispersalWind: true, FamilyCode: 4},
+	}
+	return candidates, traits
+}
+
+func selectedIDs(selected []SpeciesRecommendation) []int64 {
+	ids := make([]int64, len(selected))
+	for i, sp := range selected {
+		ids[i] = sp.SpeciesID
+	}
+	return ids
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStartBestClimate(t *testing.T) {
+	candidates, traits := testPool()
+	// Out of order on purpose: the strategy must not rely on slice order
+	candidates[0], candidates[3] = candidates[3], candidates[0]
+
+	idx := startBestClimate(candidates, traits, selectionOptions{})
+	if got := candidates[idx].SpeciesID; got != 1 {
+		t.Fatalf("best_climate started from species %d, want 1", got)
+	}
+}
+
+func TestStartMostDistinct(t *testing.T) {
+	candidates, traits := testPool()
+
+	idx := startMostDistinct(candidates, traits, selectionOptions{})
+	got := candidates[idx].SpeciesID
+	if got != 6 && got != 7 {
+		t.Fatalf("most_distinct started from species %d, want the palm (6) or graminoid (7)", got)
+	}
+
+	single := candidates[:1]
+	if idx := startMostDistinct(single, traits, selectionOptions{}); idx != 0 {
+		t.Fatalf("most_distinct on a single candidate returned %d, want 0", idx)
+	}
+}
+
+func TestStartRandomTopK(t *testing.T) {
+	candidates, traits := testPool()
+
+	starts := map[int64]bool{}
+	for seed := int64(0); seed < 50; seed++ {
+		s := seed
+		opts := selectionOptions{TopK: 3, Seed: &s}
+		idx := startRandomTopK(candidates, traits, opts)
+		id := candidates[idx].SpeciesID
+		if id > 3 {
+			t.Fatalf("seed %d: started from species %d, outside the top 3 climate matches", seed, id)
+		}
+		if again := startRandomTopK(candidates, traits, opts); again != idx {
+			t.Fatalf("seed %d: start not reproducible (%d then %d)", seed, idx, again)
+		}
+		starts[id] = true
+	}
+	if len(starts) < 2 {
+		t.Fatalf("50 seeds produced a single start species %v", starts)
+	}
+
+	// TopK larger than the pool is clamped
+	seed := int64(7)
+	idx := startRandomTopK(candidates[:2], traits, selectionOptions{TopK: 100, Seed: &seed})
+	if idx < 0 || idx > 1 {
+		t.Fatalf("start index %d out of range for a pool of 2", idx)
+	}
+}
+
+// TestStartStrategiesComparative runs the full greedy selection under each
+// strategy on the same pool and compares the resulting compositions.
+func TestStartStrategiesComparative(t *testing.T) {
+	const n = 4
+	seed := int64(42)
+
+	results := map[string][]SpeciesRecommendation{}
+	for name, start := range startStrategies {
+		candidates, traits := testPool()
+		opts := selectionOptions{Start: start, TopK: 3, Seed: &seed}
+		selected := greedyDiversitySelection(candidates, traits, n, opts)
+
+		if len(selected) != n {
+			t.Fatalf("%s: selected %d species, want %d", name, len(selected), n)
+		}
+		seen := map[int64]bool{}
+		for i, sp := range selected {
+			if seen[sp.SpeciesID] {
+				t.Fatalf("%s: species %d selected twice", name, sp.SpeciesID)
+			}
+			seen[sp.SpeciesID] = true
+			if sp.SelectionRank != i+1 {
+				t.Fatalf("%s: rank %d at position %d", name, sp.SelectionRank, i)
+			}
+		}
+
+		// Same inputs must give the same selection
+		candidates, traits = testPool()
+		again := greedyDiversitySelection(candidates, traits, n, opts)
+		if !equalIDs(selectedIDs(selected), selectedIDs(again)) {
+			t.Fatalf("%s: selection not reproducible: %v vs %v", name, selectedIDs(selected), selectedIDs(again))
+		}
+
+		results[name] = selected
+	}
+
+	if first := results["best_climate"][0].SpeciesID; first != 1 {
+		t.Errorf("best_climate: first species %d, want 1", first)
+	}
+	if first := results["most_distinct"][0].SpeciesID; first != 6 && first != 7 {
+		t.Errorf("most_distinct: first species %d, want 6 or 7", first)
+	}
+	if first := results["random_top_k"][0].SpeciesID; first > 3 {
+		t.Errorf("random_top_k: first species %d outside top 3", first)
+	}
+
+	// Starting from an outlier should not reduce growth-form coverage
+	_, traits := testPool()
+	climate := calculateDiversityMetrics(results["best_climate"], traits, MetricWeights{})
+	distinct := calculateDiversityMetrics(results["most_distinct"], traits, MetricWeights{})
+	if distinct.NGrowthForms < climate.NGrowthForms {
+		t.Errorf("most_distinct covers %d growth forms, best_climate %d", distinct.NGrowthForms, climate.NGrowthForms)
+	}
+
+	// nil Start falls back to best_climate
+	candidates, traits := testPool()
+	def := greedyDiversitySelection(candidates, traits, n, selectionOptions{})
+	if !equalIDs(selectedIDs(def), selectedIDs(results["best_climate"])) {
+		t.Errorf("default start %v differs from best_climate %v", selectedIDs(def), selectedIDs(results["best_climate"]))
+	}
+}
+
+func TestParseStartStrategy(t *testing.T) {
+	req := RecommendRequest{}
+	if err := parseStartStrategy(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.StartStrategy != "best_climate" || req.StartSeed != nil || req.StartTopK != 0 {
+		t.Errorf("default: got %+v", req)
+	}
+
+	req = RecommendRequest{StartStrategy: "random_top_k"}
+	if err := parseStartStrategy(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.StartSeed == nil || req.StartTopK != defaultStartTopK {
+		t.Errorf("random_top_k defaults: seed=%v top_k=%d", req.StartSeed, req.StartTopK)
+	}
+
+	seed := int64(5)
+	req = RecommendRequest{StartStrategy: "most_distinct", StartSeed: &seed, StartTopK: 3}
+	if err := parseStartStrategy(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.StartSeed != nil || req.StartTopK != 0 {
+		t.Errorf("most_distinct should drop random parameters, got seed=%v top_k=%d", req.StartSeed, req.StartTopK)
+	}
+
+	for _, bad := range []RecommendRequest{
+		{StartStrategy: "worst_climate"},
+		{StartStrategy: "random_top_k", StartTopK: -1},
+	} {
+		if err := parseStartStrategy(&bad); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
+
+func TestPreferCandidate(t *testing.T) {
+	a := SpeciesRecommendation{SpeciesID: 10, ClimateMatchScore: 0.8}
+	b := SpeciesRecommendation{SpeciesID: 20, ClimateMatchScore: 0.9}
+	c := SpeciesRecommendation{SpeciesID: 30, ClimateMatchScore: 0.8}
+
+	tests := []struct {
+		name   string
+		scoreA float64
+		a      SpeciesRecommendation
+		scoreB float64
+		b      SpeciesRecommendation
+		want   bool
+	}{
+		{"higher score wins", 0.6, a, 0.5, b, true},
+		{"lower score loses", 0.5, b, 0.6, a, false},
+		{"tie: higher climate wins", 0.5, b, 0.5, a, true},
+		{"tie: lower climate loses", 0.5, a, 0.5, b, false},
+		{"tie on climate: lower ID wins", 0.5, a, 0.5, c, true},
+		{"tie on climate: higher ID loses", 0.5, c, 0.5, a, false},
+		{"float noise is a tie", 0.1 + 0.2, c, 0.3, a, false},
+		{"identical candidate does not win", 0.5, a, 0.5, a, false},
+	}
+	for _, tt := range tests {
+		if got := preferCandidate(tt.scoreA, tt.a, tt.scoreB, tt.b); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// tiedPool returns candidates whose traits and climate scores collide, so
+// every greedy step is decided by the tie-breaking rules alone.
+func tiedPool() ([]SpeciesRecommendation, map[int64]TraitVector) {
+	candidates := []SpeciesRecommendation{}
+	traits := map[int64]TraitVector{}
+	for _, id := range []int64{42, 7, 19, 3, 88, 61} {
+		candidates = append(candidates, SpeciesRecommendation{SpeciesID: id, GrowthForm: "tree", ClimateMatchScore: 0.8})
+		traits[id] = TraitVector{IsTree: true, HeightNorm: 0.5, LifespanNorm: 0.5, FamilyCode: 1}
+	}
+	// Two species share a distinct herb vector; 88 has the better climate
+	for _, id := range []int64{19, 88} {
+		traits[id] = TraitVector{IsHerb: true, HeightNorm: 0.1, LifespanNorm: 0.1, FamilyCode: 2}
+	}
+	candidates[4].ClimateMatchScore = 0.8 + scoreTieEpsilon/10 // 88: still a climate tie
+	return candidates, traits
+}
+
+func reversed(in []SpeciesRecommendation) []SpeciesRecommendation {
+	out := make([]SpeciesRecommendation, len(in))
+	for i := range in {
+		out[len(in)-1-i] = in[i]
+	}
+	return out
+}
+
+// TestGreedySelectionTieBreaking locks the selection for a fully tied pool:
+// start from the lowest ID, then the herb with the lowest ID (marginal
+// diversity wins), then the remaining trees by ascending ID.
+func TestGreedySelectionTieBreaking(t *testing.T) {
+	want := []int64{3, 19, 7, 42, 61, 88}
+
+	candidates, traits := tiedPool()
+	got := selectedIDs(greedyDiversitySelection(candidates, traits, len(candidates), selectionOptions{}))
+	if !equalIDs(got, want) {
+		t.Fatalf("selection %v, want %v", got, want)
+	}
+}
+
+// TestGreedySelectionOrderIndependent checks that permuting the candidate
+// slice never changes the result, for every start strategy.
+func TestGreedySelectionOrderIndependent(t *testing.T) {
+	seed := int64(3)
+	pools := map[string]func() ([]SpeciesRecommendation, map[int64]TraitVector){
+		"mixed": testPool,
+		"tied":  tiedPool,
+	}
+
+	for poolName, pool := range pools {
+		for name, start := range startStrategies {
+			opts := selectionOptions{Start: start, TopK: 3, Seed: &seed}
+
+			candidates, traits := pool()
+			base := selectedIDs(greedyDiversitySelection(candidates, traits, 4, opts))
+
+			permutations := [][]SpeciesRecommendation{reversed(candidates)}
+			for shift := 1; shift < len(candidates); shift++ {
+				rotated := append(append([]SpeciesRecommendation{}, candidates[shift:]...), candidates[:shift]...)
+				permutations = append(permutations, rotated)
+			}
+
+			for i, perm := range permutations {
+				got := selectedIDs(greedyDiversitySelection(perm, traits, 4, opts))
+				if !equalIDs(got, base) {
+					t.Errorf("%s/%s permutation %d: selection %v, want %v", poolName, name, i, got, base)
+				}
+			}
+		}
+	}
+}
+
+// maxPlaceholder returns the highest $n in query
+func maxPlaceholder(query string) int {
+	max := 0
+	for _, m := range regexp.MustCompile(`\$(\d+)`).FindAllStringSubmatch(query, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func TestBuildWhereClauseUsesPlaceholders(t *testing.T) {
+	hostile := "tree') OR 1=1; DROP TABLE species; --"
+	minHeight, maxHeight := 2.0, 15.5
+	prefs := Preferences{
+		GrowthForms: []string{"tree", hostile, "shrub"},
+		MinHeightM:  &minHeight,
+		MaxHeightM:  &maxHeight,
+	}
+
+	existing := []interface{}{"a", "b", "c"}
+	clause, args := buildWhereClause(prefs, existing)
+
+	if strings.Contains(clause, "DROP") || strings.Contains(clause, "'tree'") || strings.Contains(clause, "15.5") {
+		t.Errorf("values interpolated into SQL: %s", clause)
+	}
+	if len(args) != 6 || maxPlaceholder(clause) != 6 {
+		t.Fatalf("got %d args and placeholders up to $%d, want 6: %s", len(args), maxPlaceholder(clause), clause)
+	}
+	if !strings.Contains(clause, "su.growth_form = ANY($4)") {
+		t.Errorf("growth forms not bound as $4: %s", clause)
+	}
+	// Invalid growth forms are dropped, not bound
+	forms, _ := args[3].(interface{ Value() (driver.Value, error) }).Value()
+	if forms != `{"tree","shrub"}` {
+		t.Errorf("growth form arg = %v", forms)
+	}
+	if args[4] != minHeight || args[5] != maxHeight {
+		t.Errorf("height args = %v, %v", args[4], args[5])
+	}
+
+	query := "SELECT 1 FROM species s JOIN species_unified su ON s.id = su.species_id WHERE true" + clause
+	if _, err := validateReadOnlyQuery(query); err != nil {
+		t.Errorf("clause does not parse: %v\n%s", err, query)
+	}
+
+	// Only hostile growth forms: no filter at all, no extra args
+	clause, args = buildWhereClause(Preferences{GrowthForms: []string{hostile}}, nil)
+	if clause != "" || len(args) != 0 {
+		t.Errorf("got %q, %v", clause, args)
+	}
+}
+
+func TestCandidateQueryBindsEveryArg(t *testing.T) {
+	zone := "Cfa"
+	site := LocationInfo{TDWGCode: "BZS", Bio1: 18, Bio5: 28, Bio6: 8, Bio12: 1500, Bio15: 30, KoppenZone: &zone}
+	blended := site
+	blended.Blend = []BorderRegion{{TDWGCode: "BZS", Weight: 0.7}, {TDWGCode: "AGE", Weight: 0.3}}
+	level2 := site
+	level2.TDWGCode, level2.Level3Codes = "84", []string{"BZL", "BZS"}
+	stored := site
+	stored.RegionClimate = true
+	margin := 3.5
+
+	cases := map[string]struct {
+		loc   LocationInfo
+		prefs Preferences
+	}{
+		"default":       {site, Preferences{}},
+		"stored scores": {stored, Preferences{KoppenMatch: "only"}},
+		"koppen only":   {site, Preferences{KoppenMatch: "only"}},
+		"koppen filter": {site, Preferences{KoppenMatch: "filter"}},
+		"only blended":  {blended, Preferences{KoppenMatch: "only", MinAbundance: "common"}},
+		"only level 2":  {level2, Preferences{KoppenMatch: "only", EstablishmentMeans: []string{"native"}}},
+		"frost margin":  {site, Preferences{FrostSafetyMarginC: &margin, KoppenMatch: "filter"}},
+	}
+	for name, tc := range cases {
+		req := RecommendRequest{ClimateThreshold: 0.6, Preferences: tc.prefs}
+		query, args, err := candidateQuery(tc.loc, req, 100)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// An unreferenced arg fails in Postgres ("could not determine data type")
+		for i := 1; i <= len(args); i++ {
+			if !regexp.MustCompile(`\$` + strconv.Itoa(i) + `\b`).MatchString(query) {
+				t.Errorf("%s: $%d is bound but not referenced", name, i)
+			}
+		}
+		if maxPlaceholder(query) != len(args) {
+			t.Errorf("%s: placeholders up to $%d for %d args", name, maxPlaceholder(query), len(args))
+		}
+		if _, err := validateReadOnlyQuery(query); err != nil {
+			t.Errorf("%s: query does not parse: %v", name, err)
+		}
+	}
+}
+
+func TestCandidateQueryBlendRanksByWeight(t *testing.T) {
+	loc := LocationInfo{TDWGCode: "BZS", Bio1: 18, Bio5: 28, Bio6: 8, Bio12: 1500, Bio15: 30,
+		Blend: []BorderRegion{{TDWGCode: "BZS", Weight: 0.7}, {TDWGCode: "AGE", Weight: 0.3}}}
+	query, _, err := candidateQuery(loc, RecommendRequest{ClimateThreshold: 0.6}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	score := regexp.MustCompile(`(?s)SELECT.*?,\s*([^,]*?) as climate_match_score`).FindStringSubmatch(query)
+	if score == nil || strings.Contains(score[1], "region_weight") {
+		t.Errorf("climate_match_score is weighted: %v", score)
+	}
+	if !regexp.MustCompile(`ORDER BY \(.*\) \* sr\.region_weight DESC, s\.id`).MatchString(query) {
+		t.Errorf("blended candidates not ordered by the weighted match:\n%s", query)
+	}
+
+	w := 0.5
+	weighted, plain := SpeciesRecommendation{SpeciesID: 1, ClimateMatchScore: 0.9, RegionWeight: &w}, SpeciesRecommendation{SpeciesID: 2, ClimateMatchScore: 0.6}
+	if weighted.rankScore() != 0.45 || plain.rankScore() != 0.6 {
+		t.Errorf("rankScore = %v, %v", weighted.rankScore(), plain.rankScore())
+	}
+	if got := startBestClimate([]SpeciesRecommendation{weighted, plain}, nil, selectionOptions{}); got != 1 {
+		t.Errorf("best_climate start = %d, want the higher weighted match", got)
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	names, err := parseExclusions("exclude_species", []string{" Mimosa  pudica", "mimosa pudica", "", "Ricinus communis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mimosa pudica", "ricinus communis"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("parseExclusions = %v, want %v", names, want)
+	}
+	if _, err := parseExclusions("exclude_families", make([]string, maxExclusions+1)); err == nil {
+		t.Error("expected an error past maxExclusions")
+	}
+
+	prefs := Preferences{ExcludeSpecies: names, ExcludeFamilies: []string{"poaceae"}}
+	clause, args := buildWhereClause(prefs, []interface{}{"a"})
+	if len(args) != 3 || !strings.Contains(clause, "lower(s.canonical_name) = ANY($2)") || !strings.Contains(clause, "lower(s.family) = ANY($3)") {
+		t.Errorf("got %d args: %s", len(args), clause)
+	}
+	if strings.Contains(clause, "pudica") {
+		t.Errorf("names interpolated into SQL: %s", clause)
+	}
+}
+
+func TestDeterministicSeed(t *testing.T) {
+	parse := func(req RecommendRequest) int64 {
+		if err := parseStartStrategy(&req); err != nil {
+			t.Fatal(err)
+		}
+		return *req.StartSeed
+	}
+
+	req := RecommendRequest{TDWGCode: "BZS", NSpecies: 10, StartStrategy: "random_top_k", Deterministic: true}
+	if a, b := parse(req), parse(req); a != b || a < 0 {
+		t.Errorf("deterministic seeds %d, %d", a, b)
+	}
+	other := req
+	other.TDWGCode = "BZL"
+	if parse(req) == parse(other) {
+		t.Error("different requests share a derived seed")
+	}
+
+	seed := int64(99)
+	req.RandomSeed = &seed
+	if got := parse(req); got != 99 {
+		t.Errorf("start_seed = %d, want random_seed 99", got)
+	}
+}
+
+func TestSelectionHash(t *testing.T) {
+	a := []SpeciesRecommendation{{SpeciesID: 1}, {SpeciesID: 23}}
+	b := []SpeciesRecommendation{{SpeciesID: 12}, {SpeciesID: 3}}
+	if selectionHash(a) == selectionHash(b) || selectionHash(a) != selectionHash(append([]SpeciesRecommendation{}, a...)) {
+		t.Error("selection hash does not identify the ranked list")
+	}
+	if selectionHash(a) == selectionHash([]SpeciesRecommendation{a[1], a[0]}) {
+		t.Error("selection hash ignores rank order")
+	}
+}
+
+func TestPreferCandidateTransitive(t *testing.T) {
+	// Each score is within epsilon of the next, but the first and last are not
+	step := scoreTieEpsilon * 0.6
+	pool := []SpeciesRecommendation{{SpeciesID: 1}, {SpeciesID: 2}, {SpeciesID: 3}}
+	scores := map[int64]float64{1: 0.5, 2: 0.5 + step, 3: 0.5 + 2*step}
+	best := func(order []int) int64 {
+		b := pool[order[0]]
+		for _, i := range order[1:] {
+			if preferCandidate(scores[pool[i].SpeciesID], pool[i], scores[b.SpeciesID], b) {
+				b = pool[i]
+			}
+		}
+		return b.SpeciesID
+	}
+	want := best([]int{0, 1, 2})
+	for _, order := range [][]int{{2, 1, 0}, {1, 0, 2}, {1, 2, 0}, {0, 2, 1}, {2, 0, 1}} {
+		if got := best(order); got != want {
+			t.Errorf("order %v: best %d, want %d", order, got, want)
+		}
+	}
+}
+
+func TestBrazilFloraClauses(t *testing.T) {
+	prefs := Preferences{
+		PhytogeographicDomains: []string{"mata atlântica"},
+		VegetationTypes:        []string{"restinga", "floresta ombrófila (= floresta pluvial)"},
+	}
+	clause, args := buildWhereClause(prefs, []interface{}{"a", "b"})
+	if len(args) != 4 || maxPlaceholder(clause) != 4 || strings.Contains(clause, "restinga") {
+		t.Fatalf("got %d args, placeholders up to $%d: %s", len(args), maxPlaceholder(clause), clause)
+	}
+	query := "SELECT 1 FROM species s WHERE true" + clause
+	if _, err := validateReadOnlyQuery(query); err != nil {
+		t.Errorf("clause does not parse: %v\n%s", err, query)
+	}
+}
+
+func TestParseBrazilFloraFilters(t *testing.T) {
+	prefs, err := parseBrazilFloraFilters(url.Values{
+		"domain":          {"Cerrado,Mata  Atlântica", "cerrado"},
+		"vegetation_type": {"Cerrado (lato sensu)"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cerrado", "mata atlântica"}; !reflect.DeepEqual(prefs.PhytogeographicDomains, want) {
+		t.Errorf("domains = %q, want %q", prefs.PhytogeographicDomains, want)
+	}
+	if want := []string{"cerrado (lato sensu)"}; !reflect.DeepEqual(prefs.VegetationTypes, want) {
+		t.Errorf("vegetation types = %q, want %q", prefs.VegetationTypes, want)
+	}
+}
+
+// benchPool builds a pool of n candidates with random traits, in
+// descending climate order; the same n always gives the same pool
+func benchPool(n int) ([]SpeciesRecommendation, map[int64]TraitVector) {
+	rng := rand.New(rand.NewSource(int64(n)))
+	forms := []string{"tree", "shrub", "palm", "forb", "graminoid", "liana"}
+	candidates := make([]SpeciesRecommendation, n)
+	traits := make(map[int64]TraitVector, n)
+	for i := range candidates {
+		id := int64(i + 1)
+		form := forms[rng.Intn(len(forms))]
+		candidates[i] = SpeciesRecommendation{
+			SpeciesID:         id,
+			Family:            "Family" + strconv.Itoa(rng.Intn(40)),
+			GrowthForm:        form,
+			ClimateMatchScore: 1 - float64(i)/float64(n),
+		}
+		traits[id] = TraitVector{
+			IsTree:          form == "tree" || form == "palm",
+			IsShrub:         form == "shrub",
+			IsHerb:          form == "forb" || form == "graminoid",
+			IsClimber:       form == "liana",
+			IsPalm:          form == "palm",
+			HeightNorm:      rng.Float64(),
+			LifespanNorm:    rng.Float64(),
+			IsNitrogenFixer: rng.Intn(5) == 0,
+			DispersalAnimal: rng.Intn(2) == 0,
+			DispersalWind:   rng.Intn(3) == 0,
+			FamilyCode:      rng.Intn(40),
+		}
+	}
+	return candidates, traits
+}
+
+func BenchmarkGowerDistance(b *testing.B) {
+	_, traits := benchPool(2)
+	x, y := traits[1], traits[2]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gowerDistance(x, y)
+	}
+}
+
+// BenchmarkGreedyDiversitySelection covers the default candidate pool
+// bounds (see candidate_pool.go)
+func BenchmarkGreedyDiversitySelection(b *testing.B) {
+	for _, n := range []int{defaultCandidatePoolMin, defaultCandidatePoolMax} {
+		candidates, traits := benchPool(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				greedyDiversitySelection(candidates, traits, 30, selectionOptions{})
+			}
+		})
+	}
+}
+
+func BenchmarkBuildWhereClause(b *testing.B) {
+	minHeight, maxHeight, margin := 2.0, 25.0, 2.0
+	prefs := Preferences{
+		GrowthForms:            []string{"tree", "shrub", "palm"},
+		MinHeightM:             &minHeight,
+		MaxHeightM:             &maxHeight,
+		NitrogenFixersOnly:     true,
+		EstablishmentMeans:     []string{"native", "naturalized"},
+		SiteHydrology:          "riparian",
+		ExcludeSpecies:         []string{"Pinus elliottii", "Eucalyptus grandis"},
+		ExcludeFamilies:        []string{"Poaceae"},
+		FrostSafetyMarginC:     &margin,
+		PhytogeographicDomains: []string{"Mata Atlântica"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buildWhereClause(prefs, []interface{}{"BZS", 0.6})
+	}
+}
+
+func TestRecommendServesCachedResponse(t *testing.T) {
+	stored := `{"species":[{"species_id":7,"canonical_name":"Inga edulis","climate_match_score":0.9}],"query_time":"1s"}`
+	s, db := newFakeDBServer(t,
+		fakeQuery{match: "response IS NOT NULL", columns: []string{"response"}, rows: [][]driver.Value{{[]byte(stored)}}},
+		fakeQuery{match: "SET hit_count = hit_count + 1"},
+		fakeQuery{match: "FROM localized_names", columns: []string{"kind", "code", "language", "name"}},
+		fakeQuery{match: "FROM common_names", columns: []string{"species_id", "common_name", "language"}},
+	)
+	req := httptest.NewRequest("POST", "/api/recommend", strings.NewReader(`{"tdwg_code": "BZS"}`))
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"cached":true`) || !strings.Contains(w.Body.String(), `"Inga edulis"`) {
+		t.Fatalf("%d %s, want the stored response marked cached", w.Code, w.Body.String())
+	}
+	key := db.argsOf("FROM recommendation_cache")
+	if hit := db.argsOf("SET hit_count"); len(key) != 1 || len(hit) != 1 || hit[0] != key[0] {
+		t.Errorf("hit count updated for %v, looked up %v", hit, key)
+	}
+	if db.index("c.bio1_mean") >= 0 {
+		t.Error("cached recommendation recomputed")
+	}
+}
+
+func TestRecommendRecomputesCacheRowWithoutResponse(t *testing.T) {
+	// Rows written before migration 035 have no response, so the lookup
+	// finds nothing
+	s, db := newFakeDBServer(t,
+		fakeQuery{match: "response IS NOT NULL", columns: []string{"response"}},
+		fakeQuery{
+			match:   "c.bio1_mean, c.bio5_mean",
+			columns: []string{"tdwg_code", "level3_name", "bio1", "bio5", "bio6", "bio12", "bio15"},
+			rows:    [][]driver.Value{{"BZS", "Brazil South", 18.0, 28.0, 8.0, 1500.0, 30.0}},
+		},
+		fakeQuery{match: "as climate_match_score", err: errors.New("no candidates")},
+	)
+	req := httptest.NewRequest("POST", "/api/recommend", strings.NewReader(`{"tdwg_code": "BZS"}`))
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "no candidates") {
+		t.Fatalf("%d %s, want the recommendation recomputed", w.Code, w.Body.String())
+	}
+	if db.index("SET hit_count") >= 0 {
+		t.Error("hit counted for a recomputed recommendation")
+	}
+}
+
+func TestParseEstablishmentMeans(t *testing.T) {
+	statuses, err := parseEstablishmentMeans([]string{" Native ", "", "NATURALIZED", "cultivated"})
+	if want := []string{"native", "naturalized", "cultivated"}; err != nil || !reflect.DeepEqual(statuses, want) {
+		t.Errorf("parseEstablishmentMeans = %v, %v; want %v", statuses, err, want)
+	}
+	if statuses, err := parseEstablishmentMeans([]string{" ", ""}); err != nil || statuses != nil {
+		t.Errorf("blank statuses = %v, %v; want none", statuses, err)
+	}
+	if _, err := parseEstablishmentMeans([]string{"native", "wild"}); err == nil || !strings.HasPrefix(err.Error(), "invalid establishment status: wild") {
+		t.Errorf("err = %v, want invalid establishment status: wild", err)
+	}
+}
+
+func TestSpeciesStatusFilter(t *testing.T) {
+	s, db := newFakeDBServer(t,
+		fakeQuery{match: "SELECT COUNT(DISTINCT s.id)", columns: []string{"count"}, rows: [][]driver.Value{{int64(1)}}},
+		fakeQuery{
+			match:   "sr.is_native, sr.establishment_means::text",
+			columns: []string{"id", "canonical_name", "family", "growth_form", "source", "common_name", "language", "is_native", "establishment_means", "abundance"},
+			rows:    [][]driver.Value{{int64(7), "Inga edulis", "Fabaceae", "tree", "gift", nil, nil, true, "native", nil}},
+		},
+	)
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/species?tdwg_code=BZS&status=Native,naturalized", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"total":1`) || !strings.Contains(w.Body.String(), `"establishment_means":"native"`) {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+
+	for _, q := range []struct {
+		match    string
+		clause   string
+		argIndex int
+	}{
+		{"SELECT COUNT(DISTINCT s.id)", "sr.establishment_means::text = ANY($2)", 1},
+		{"sr.is_native, sr.establishment_means::text", "sr.establishment_means::text = ANY($3)", 2},
+	} {
+		stmts, args := db.executed(q.match), db.argsOf(q.match)
+		if len(stmts) != 1 || !strings.Contains(stmts[0], q.clause) {
+			t.Errorf("%s: %v, want %s", q.match, stmts, q.clause)
+			continue
+		}
+		if len(args) <= q.argIndex || args[q.argIndex] != `{"native","naturalized"}` {
+			t.Errorf("%s: args %v, want the statuses bound at $%d", q.match, args, q.argIndex+1)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/species?tdwg_code=BZS&status=native,wild", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid establishment status: wild") {
+		t.Errorf("invalid status: %d %s, want 400", w.Code, w.Body.String())
+	}
+	if n := len(db.executed("SELECT COUNT(DISTINCT s.id)")); n != 1 {
+		t.Errorf("invalid status queried the database (%d counts)", n)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ============================================================================
+// REGION BOUNDS
+// ============================================================================
+//
+// Bounding box, centroid and label point of a TDWG region or ecoregion,
+// precomputed in migration 028, so the map can zoom to a region without its
+// geometry. Coordinates are GeoJSON-ordered: bbox is [min_lon, min_lat,
+// max_lon, max_lat] and points are [lon, lat]. For regions crossing the
+// antimeridian max_lon exceeds 180.
+
+type RegionBounds struct {
+	Code       string     `json:"code"`
+	Name       string     `json:"name"`
+	BBox       [4]float64 `json:"bbox"`
+	Centroid   [2]float64 `json:"centroid"`
+	LabelPoint [2]float64 `json:"label_point"` // Always inside the region
+}
+
+// regionBounds reads the stored bounds of one row of table; where selects it
+func (s *Server) regionBounds(ctx context.Context, table, codeExpr, nameExpr, where string, arg interface{}) (*RegionBounds, error) {
+	var b RegionBounds
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT %s, COALESCE(%s, ''),
+		       ST_XMin(bbox), ST_YMin(bbox), ST_XMax(bbox), ST_YMax(bbox),
+		       ST_X(centroid), ST_Y(centroid), ST_X(label_point), ST_Y(label_point)
+		FROM %s
+		WHERE %s AND bbox IS NOT NULL
+	`, codeExpr, nameExpr, table, where), arg).Scan(&b.Code, &b.Name,
+		&b.BBox[0], &b.BBox[1], &b.BBox[2], &b.BBox[3],
+		&b.Centroid[0], &b.Centroid[1], &b.LabelPoint[0], &b.LabelPoint[1])
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// writeRegionBounds answers a bounds lookup; bounds change only with the
+// geometries, so responses are cacheable
+func (s *Server) writeRegionBounds(w http.ResponseWriter, r *http.Request, kind string, b *RegionBounds, err error) {
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Region not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	lang := requestLanguage(r)
+	b.Name = s.localize(r.Context(), kind, b.Code, lang, b.Name)
+	setContentLanguage(w, lang)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	json.NewEncoder(w).Encode(b)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleTDWGBounds handles GET /api/tdwg/{code}/bounds
+func (s *Server) handleTDWGBounds(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	b, err := s.regionBounds(ctx, "tdwg_level3", "level3_code", "level3_name",
+		"UPPER(level3_code) = UPPER($1)", pathParam(r, "code"))
+	s.writeRegionBounds(w, r, nameKindTDWG, b, err)
+}
+
+// handleEcoregionBounds handles GET /api/ecoregion/{eco_id}/bounds
+func (s *Server) handleEcoregionBounds(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	ecoID, err := strconv.Atoi(pathParam(r, "eco_id"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid eco_id"}`, http.StatusBadRequest)
+		return
+	}
+
+	b, err := s.regionBounds(ctx, "ecoregions", "eco_id::text", "eco_name", "eco_id = $1", ecoID)
+	s.writeRegionBounds(w, r, nameKindEcoregion, b, err)
+}
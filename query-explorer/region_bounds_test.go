@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func regionBoundsServer(t *testing.T) (*Server, *fakeDB) {
+	columns := []string{"code", "name", "xmin", "ymin", "xmax", "ymax", "cx", "cy", "lx", "ly"}
+	return newFakeDBServer(t,
+		fakeQuery{
+			match:   "FROM tdwg_level3",
+			when:    func(args []driver.Value) bool { return strings.EqualFold(args[0].(string), "BZS") },
+			columns: columns,
+			rows:    [][]driver.Value{{"BZS", "Brazil South", -57.6, -33.8, -44.0, -22.5, -51.2, -27.1, -50.9, -26.8}},
+		},
+		fakeQuery{match: "FROM tdwg_level3", columns: columns},
+		fakeQuery{
+			match:   "FROM ecoregions",
+			when:    func(args []driver.Value) bool { return args[0] == int64(1) },
+			columns: columns,
+			rows:    [][]driver.Value{{"1", "Adelie Land tundra", 136.0, -67.0, 142.9, -66.0, 139.5, -66.6, 139.4, -66.6}},
+		},
+		fakeQuery{match: "FROM ecoregions", columns: columns},
+		fakeQuery{
+			match:   "FROM localized_names",
+			columns: []string{"kind", "code", "language", "name"},
+			rows:    [][]driver.Value{{nameKindTDWG, "BZS", "pt", "Sul do Brasil"}},
+		},
+	)
+}
+
+func TestRegionBounds(t *testing.T) {
+	s, _ := regionBoundsServer(t)
+	for _, tc := range []struct {
+		path string
+		want RegionBounds
+	}{
+		{"/api/tdwg/bzs/bounds", RegionBounds{"BZS", "Brazil South", [4]float64{-57.6, -33.8, -44.0, -22.5}, [2]float64{-51.2, -27.1}, [2]float64{-50.9, -26.8}}},
+		{"/api/tdwg/bzs/bounds?lang=pt", RegionBounds{"BZS", "Sul do Brasil", [4]float64{-57.6, -33.8, -44.0, -22.5}, [2]float64{-51.2, -27.1}, [2]float64{-50.9, -26.8}}},
+		{"/api/ecoregion/1/bounds", RegionBounds{"1", "Adelie Land tundra", [4]float64{136.0, -67.0, 142.9, -66.0}, [2]float64{139.5, -66.6}, [2]float64{139.4, -66.6}}},
+	} {
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=86400" {
+			t.Fatalf("%s: %d %s (Cache-Control %q)", tc.path, w.Code, w.Body.String(), w.Header().Get("Cache-Control"))
+		}
+		var got RegionBounds
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got != tc.want {
+			t.Errorf("%s: %s, want %+v", tc.path, w.Body.String(), tc.want)
+		}
+	}
+
+	// The map reads the bounds as GeoJSON-ordered arrays
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("GET", "/api/tdwg/BZS/bounds", nil))
+	var shape map[string]json.RawMessage
+	json.Unmarshal(w.Body.Bytes(), &shape)
+	if string(shape["bbox"]) != "[-57.6,-33.8,-44,-22.5]" || string(shape["centroid"]) != "[-51.2,-27.1]" || string(shape["label_point"]) != "[-50.9,-26.8]" {
+		t.Errorf("bounds %s", w.Body.String())
+	}
+}
+
+func TestRegionBoundsErrors(t *testing.T) {
+	s, db := regionBoundsServer(t)
+	for _, tc := range []struct {
+		path, body string
+		code       int
+	}{
+		{"/api/tdwg/XXX/bounds", "Region not found", http.StatusNotFound},
+		{"/api/ecoregion/999/bounds", "Region not found", http.StatusNotFound},
+		{"/api/ecoregion/abc/bounds", "Invalid eco_id", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.body) || w.Header().Get("Cache-Control") != "" {
+			t.Errorf("%s: %d %s, want %d %q", tc.path, w.Code, w.Body.String(), tc.code, tc.body)
+		}
+	}
+	if n := len(db.executed("FROM ecoregions")); n != 1 {
+		t.Errorf("%d ecoregion lookups, want none for the invalid eco_id", n)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// CLIMATE RE-SCORING
+// ============================================================================
+//
+// After climate layers or envelopes are refreshed, an admin starts a
+// re-scoring run (POST /api/admin/rescore, migration 038). In the
+// background it drops the cached recommendations of the affected regions,
+// then recomputes species_region_climate_match region by region, one
+// transaction each, recording progress and score drift as it goes: how
+// many scores changed, by how much, and how many species crossed the
+// default climate_threshold and so enter or leave recommendations. GET
+// /api/admin/rescore/{id} reports the progress and the regions that moved
+// most; DELETE cancels, keeping the regions already done. One run at a time.
+//
+// Recommendations for a TDWG region with the default climate weights and
+// envelope read their climate match from species_region_climate_match, so a
+// climate or envelope update reaches them when the run rescores the region,
+// together with the cache drop; species without a stored score are scored
+// on the fly.
+
+const (
+	rescoreThreshold  = 0.6 // The default climate_threshold
+	rescoreTopRegions = 20  // Regions listed in a run's drift report
+)
+
+type RescoreRequest struct {
+	Reason  string   `json:"reason"`
+	Regions []string `json:"regions,omitempty"` // TDWG codes; default: all with climate data
+}
+
+type RescoreRegionDrift struct {
+	TDWGCode          string  `json:"tdwg_code"`
+	NScores           int     `json:"n_scores"`
+	NNew              int     `json:"n_new"`
+	NChanged          int     `json:"n_changed"`
+	NRemoved          int     `json:"n_removed"`
+	NCrossedThreshold int     `json:"n_crossed_threshold"`
+	MeanAbsDrift      float64 `json:"mean_abs_drift"`
+	MaxAbsDrift       float64 `json:"max_abs_drift"`
+}
+
+type RescoreRun struct {
+	ID                int64    `json:"id"`
+	Reason            *string  `json:"reason,omitempty"`
+	Status            string   `json:"status"` // running, completed, failed, cancelled
+	Regions           []string `json:"regions,omitempty"`
+	RegionsTotal      int      `json:"regions_total"`
+	RegionsDone       int      `json:"regions_done"`
+	Progress          float64  `json:"progress"` // 0-1
+	CacheInvalidated  int      `json:"cache_invalidated"`
+	NScores           int64    `json:"n_scores"`
+	NNew              int64    `json:"n_new"`
+	NChanged          int64    `json:"n_changed"`
+	NRemoved          int64    `json:"n_removed"`
+	NCrossedThreshold int64    `json:"n_crossed_threshold"`
+	MeanAbsDrift      float64  `json:"mean_abs_drift"` // Over scores with a previous value
+	MaxAbsDrift       float64  `json:"max_abs_drift"`
+	Error             *string  `json:"error,omitempty"`
+	StartedAt         string   `json:"started_at"`
+	FinishedAt        *string  `json:"finished_at,omitempty"`
+
+	// Regions with the largest mean drift (single run only)
+	TopRegions []RescoreRegionDrift `json:"top_regions,omitempty"`
+}
+
+// rescoreState is the run in progress, if any
+type rescoreState struct {
+	sync.Mutex
+	id     int64
+	cancel context.CancelFunc
+}
+
+// storedScoreJoin returns the candidate query join of the stored scores of
+// loc's region (alias rcm, on $6), "" when the request's climate match is
+// not the one the job stores: another climate (a point, a blend or several
+// regions), variable weights or an envelope mode
+func storedScoreJoin(loc LocationInfo, req RecommendRequest) string {
+	if !loc.RegionClimate || loc.multiRegion() || req.ClimateVariables != nil || req.EnvelopeMode != "" {
+		return ""
+	}
+	return "LEFT JOIN species_region_climate_match rcm ON rcm.species_id = s.id AND rcm.tdwg_code = $6"
+}
+
+// rescoreRegionSQL recomputes the scores of one region ($1) and returns its
+// drift; $2 is the threshold. Modifying CTEs run whether or not referenced.
+const rescoreRegionSQL = `
+	WITH site AS (
+		SELECT bio1_mean, bio5_mean, bio6_mean, bio12_mean, bio15_mean
+		FROM tdwg_climate WHERE tdwg_code = $1
+	),
+	fresh AS (
+		SELECT sr.species_id,
+		       ROUND(calculate_climate_match(sr.species_id, site.bio1_mean, site.bio5_mean,
+		             site.bio6_mean, site.bio12_mean, site.bio15_mean)::numeric, 4) AS score
+		FROM (SELECT DISTINCT species_id FROM species_regions WHERE tdwg_code = $1) sr, site
+	),
+	drift AS (
+		SELECT f.score, m.score AS old_score
+		FROM fresh f
+		LEFT JOIN species_region_climate_match m ON m.tdwg_code = $1 AND m.species_id = f.species_id
+	),
+	removed AS (
+		DELETE FROM species_region_climate_match m
+		WHERE m.tdwg_code = $1 AND NOT EXISTS (SELECT 1 FROM fresh f WHERE f.species_id = m.species_id)
+		RETURNING 1
+	),
+	upserted AS (
+		INSERT INTO species_region_climate_match (tdwg_code, species_id, score, computed_at)
+		SELECT $1, species_id, score, NOW() FROM fresh
+		ON CONFLICT (tdwg_code, species_id) DO UPDATE
+		SET score = EXCLUDED.score, computed_at = EXCLUDED.computed_at
+		RETURNING 1
+	)
+	SELECT COUNT(*),
+	       COUNT(*) FILTER (WHERE old_score IS NULL),
+	       COUNT(*) FILTER (WHERE old_score <> score),
+	       (SELECT COUNT(*) FROM removed),
+	       COUNT(*) FILTER (WHERE (old_score >= $2) <> (score >= $2)),
+	       COALESCE(AVG(ABS(score - old_score)), 0)::float8,
+	       COALESCE(MAX(ABS(score - old_score)), 0)::float8
+	FROM drift
+`
+
+// normalizeRescoreRegions upper-cases region codes, dropping blanks and
+// duplicates
+func normalizeRescoreRegions(regions []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, code := range regions {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" && !seen[code] {
+			seen[code] = true
+			out = append(out, code)
+		}
+	}
+	return out
+}
+
+// startRescore records a run and starts it in the background
+func (s *Server) startRescore(ctx context.Context, key *APIKey, req RescoreRequest) (int64, error) {
+	s.rescore.Lock()
+	defer s.rescore.Unlock()
+	if s.rescore.cancel != nil {
+		return 0, fmt.Errorf("re-scoring run %d is already running", s.rescore.id)
+	}
+
+	// Runs left 'running' by a restart will never finish
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE climate_rescore_runs SET status = 'failed', error = 'interrupted', finished_at = NOW()
+		WHERE status = 'running'
+	`); err != nil {
+		return 0, err
+	}
+
+	regions := req.Regions
+	if len(regions) == 0 {
+		rows, err := s.db.QueryContext(ctx, `SELECT tdwg_code FROM tdwg_climate WHERE bio1_mean IS NOT NULL ORDER BY tdwg_code`)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			regions = append(regions, code)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	var requested interface{}
+	if len(req.Regions) > 0 {
+		requested, _ = json.Marshal(req.Regions)
+	}
+	var id int64
+	if err := s.db.QueryRowContext(ctx, `
+		INSERT INTO climate_rescore_runs (started_by, reason, regions, regions_total)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+		RETURNING id
+	`, key.ID, req.Reason, requested, len(regions)).Scan(&id); err != nil {
+		return 0, err
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	s.rescore.id, s.rescore.cancel = id, cancel
+	go func() {
+		defer func() {
+			s.rescore.Lock()
+			s.rescore.id, s.rescore.cancel = 0, nil
+			s.rescore.Unlock()
+			cancel()
+		}()
+		s.runRescore(runCtx, id, regions, len(req.Regions) > 0)
+	}()
+	return id, nil
+}
+
+// runRescore invalidates the caches and rescores every region, recording
+// the outcome on the run
+func (s *Server) runRescore(ctx context.Context, id int64, regions []string, partial bool) {
+	finish := func(status string, runErr error) {
+		var msg sql.NullString
+		if runErr != nil {
+			msg = sql.NullString{String: runErr.Error(), Valid: true}
+			s.log.Printf("Re-scoring run %d %s: %v", id, status, runErr)
+		}
+		// The run context may be cancelled; the final update must still land
+		if _, err := s.db.ExecContext(context.Background(), `
+			UPDATE climate_rescore_runs SET status = $2, error = $3, finished_at = NOW() WHERE id = $1
+		`, id, status, msg); err != nil {
+			s.log.Printf("Error finishing re-scoring run %d: %v", id, err)
+		}
+	}
+
+	// Cached responses would keep serving the old scores. Cache rows without
+	// a resolved region cannot be matched, so a partial run drops them too.
+	invalidate := `DELETE FROM recommendation_cache`
+	args := []interface{}{}
+	if partial {
+		invalidate += ` WHERE location_tdwg = ANY($1) OR COALESCE(location_tdwg, '') = ''`
+		args = append(args, pq.Array(regions))
+	}
+	res, err := s.db.ExecContext(ctx, invalidate, args...)
+	if err != nil {
+		finish("failed", fmt.Errorf("invalidating recommendation cache: %w", err))
+		return
+	}
+	n, _ := res.RowsAffected()
+	s.db.ExecContext(ctx, `UPDATE climate_rescore_runs SET cache_invalidated = $2 WHERE id = $1`, id, n)
+
+	for _, code := range regions {
+		if ctx.Err() != nil {
+			finish("cancelled", nil)
+			return
+		}
+		if err := s.rescoreRegion(ctx, id, code); err != nil {
+			if ctx.Err() != nil {
+				finish("cancelled", nil)
+			} else {
+				finish("failed", fmt.Errorf("%s: %w", code, err))
+			}
+			return
+		}
+	}
+	finish("completed", nil)
+}
+
+// rescoreRegion rescores one region and adds its drift to the run, in one
+// transaction so progress never counts a region twice
+func (s *Server) rescoreRegion(ctx context.Context, runID int64, code string) error {
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	d := RescoreRegionDrift{TDWGCode: code}
+	if err := tx.QueryRowContext(ctx, rescoreRegionSQL, code, rescoreThreshold).Scan(
+		&d.NScores, &d.NNew, &d.NChanged, &d.NRemoved, &d.NCrossedThreshold, &d.MeanAbsDrift, &d.MaxAbsDrift,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO climate_rescore_region_drift
+		(run_id, tdwg_code, n_scores, n_new, n_changed, n_removed, n_crossed_threshold, mean_abs_drift, max_abs_drift)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, runID, code, d.NScores, d.NNew, d.NChanged, d.NRemoved, d.NCrossedThreshold, d.MeanAbsDrift, d.MaxAbsDrift); err != nil {
+		return err
+	}
+	// sum_abs_drift accumulates mean × compared scores, to average over the run
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE climate_rescore_runs
+		SET regions_done = regions_done + 1,
+		    n_scores = n_scores + $2, n_new = n_new + $3, n_changed = n_changed + $4,
+		    n_removed = n_removed + $5, n_crossed_threshold = n_crossed_threshold + $6,
+		    sum_abs_drift = sum_abs_drift + $7, max_abs_drift = GREATEST(max_abs_drift, $8)
+		WHERE id = $1
+	`, runID, d.NScores, d.NNew, d.NChanged, d.NRemoved, d.NCrossedThreshold,
+		d.MeanAbsDrift*float64(d.NScores-d.NNew), d.MaxAbsDrift); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const rescoreRunColumns = `
+	id, reason, status, regions, regions_total, regions_done, cache_invalidated,
+	n_scores, n_new, n_changed, n_removed, n_crossed_threshold, sum_abs_drift, max_abs_drift,
+	error, TO_CHAR(started_at, 'YYYY-MM-DD"T"HH24:MI:SS'), TO_CHAR(finished_at, 'YYYY-MM-DD"T"HH24:MI:SS')
+`
+
+func scanRescoreRun(row interface{ Scan(...interface{}) error }) (RescoreRun, error) {
+	var run RescoreRun
+	var regions []byte
+	var sumDrift float64
+	err := row.Scan(&run.ID, &run.Reason, &run.Status, &regions, &run.RegionsTotal, &run.RegionsDone,
+		&run.CacheInvalidated, &run.NScores, &run.NNew, &run.NChanged, &run.NRemoved, &run.NCrossedThreshold,
+		&sumDrift, &run.MaxAbsDrift, &run.Error, &run.StartedAt, &run.FinishedAt)
+	if err != nil {
+		return run, err
+	}
+	if regions != nil {
+		json.Unmarshal(regions, &run.Regions)
+	}
+	if run.RegionsTotal > 0 {
+		run.Progress = round3(float64(run.RegionsDone) / float64(run.RegionsTotal))
+	}
+	if compared := run.NScores - run.NNew; compared > 0 {
+		run.MeanAbsDrift = sumDrift / float64(compared)
+	}
+	return run, nil
+}
+
+// handleRescore handles GET/POST /api/admin/rescore
+func (s *Server) handleRescore(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	key, ok := s.requireRole(w, r, roleAdmin)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := s.db.QueryContext(ctx, `SELECT `+rescoreRunColumns+` FROM climate_rescore_runs ORDER BY id DESC LIMIT 50`)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		runs := []RescoreRun{}
+		for rows.Next() {
+			run, err := scanRescoreRun(rows)
+			if err != nil {
+				s.log.Printf("Error scanning re-scoring run: %v", err)
+				continue
+			}
+			runs = append(runs, run)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"runs": runs})
+
+	case http.MethodPost:
+		var req RescoreRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		req.Regions = normalizeRescoreRegions(req.Regions)
+		id, err := s.startRescore(ctx, key, req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/admin/rescore/%d", id))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": "running"})
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRescoreRun handles GET/DELETE /api/admin/rescore/{id}
+func (s *Server) handleRescoreRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid run id"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		run, err := scanRescoreRun(s.db.QueryRowContext(ctx, `SELECT `+rescoreRunColumns+` FROM climate_rescore_runs WHERE id = $1`, id))
+		if err == sql.ErrNoRows {
+			http.Error(w, `{"error": "Run not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT tdwg_code, n_scores, n_new, n_changed, n_removed, n_crossed_threshold, mean_abs_drift, max_abs_drift
+			FROM climate_rescore_region_drift
+			WHERE run_id = $1
+			ORDER BY mean_abs_drift DESC, n_crossed_threshold DESC, tdwg_code
+			LIMIT $2
+		`, id, rescoreTopRegions)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		run.TopRegions = []RescoreRegionDrift{}
+		for rows.Next() {
+			var d RescoreRegionDrift
+			if err := rows.Scan(&d.TDWGCode, &d.NScores, &d.NNew, &d.NChanged, &d.NRemoved, &d.NCrossedThreshold, &d.MeanAbsDrift, &d.MaxAbsDrift); err != nil {
+				s.log.Printf("Error scanning region drift: %v", err)
+				continue
+			}
+			run.TopRegions = append(run.TopRegions, d)
+		}
+		json.NewEncoder(w).Encode(run)
+
+	case http.MethodDelete:
+		s.rescore.Lock()
+		running := s.rescore.cancel != nil && s.rescore.id == id
+		if running {
+			s.rescore.cancel()
+		}
+		s.rescore.Unlock()
+		if !running {
+			http.Error(w, `{"error": "Run is not running"}`, http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": "cancelling"})
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeRescoreRegions(t *testing.T) {
+	got := normalizeRescoreRegions([]string{" bzs", "BZS", "", "bzl ", "  "})
+	want := []string{"BZS", "BZL"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if normalizeRescoreRegions(nil) != nil {
+		t.Fatal("no regions must stay nil, meaning all regions")
+	}
+}
+
+func TestCandidateQueryReadsStoredScores(t *testing.T) {
+	region := LocationInfo{TDWGCode: "BZS", Bio1: 18, Bio5: 28, Bio6: 8, Bio12: 1500, Bio15: 30, RegionClimate: true}
+	query, _, err := candidateQuery(region, RecommendRequest{ClimateThreshold: 0.6}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"LEFT JOIN species_region_climate_match rcm ON rcm.species_id = s.id AND rcm.tdwg_code = $6",
+		"COALESCE(rcm.score, calculate_climate_match(s.id, $1, $2, $3, $4, $5)) as climate_match_score",
+		"AND COALESCE(rcm.score, calculate_climate_match(s.id, $1, $2, $3, $4, $5)) >= $7",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("region query missing %q", want)
+		}
+	}
+
+	point := region
+	point.RegionClimate = false
+	blended := region
+	blended.Blend = []BorderRegion{{TDWGCode: "BZS", Weight: 0.7}, {TDWGCode: "AGE", Weight: 0.3}}
+	for name, tc := range map[string]struct {
+		loc LocationInfo
+		req RecommendRequest
+	}{
+		"point climate": {point, RecommendRequest{}},
+		"border blend":  {blended, RecommendRequest{}},
+		"weights":       {region, RecommendRequest{ClimateVariables: map[string]float64{"bio1": 1}}},
+		"envelope mode": {region, RecommendRequest{EnvelopeMode: "percentile"}},
+	} {
+		if join := storedScoreJoin(tc.loc, tc.req); join != "" {
+			t.Errorf("%s: stored scores used: %s", name, join)
+		}
+	}
+}
+
+func TestRescoreHandlersRequireAdmin(t *testing.T) {
+	s := newTestServer()
+	for _, tc := range []struct {
+		method, url string
+		handler     http.HandlerFunc
+	}{
+		{"GET", "/api/admin/rescore", s.handleRescore},
+		{"POST", "/api/admin/rescore", s.handleRescore},
+		{"GET", "/api/admin/rescore/1", s.handleRescoreRun},
+		{"DELETE", "/api/admin/rescore/1", s.handleRescoreRun},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, tc.url, strings.NewReader(`{}`)))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s %s: %d, want 401", tc.method, tc.url, w.Code)
+		}
+	}
+}
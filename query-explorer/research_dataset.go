@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// PUBLIC RESEARCH DATASET
+// ============================================================================
+//
+// Recommendations requested with share_for_research: true are kept,
+// anonymized, for a public dataset on how the tool is used (migration 048).
+// Nothing is kept unless PUBLIC_DATASET_URL names the store (s3:// or
+// file://, as ARCHIVE_URL) the releases are written to. A record holds:
+//
+//   - the request with its coordinates moved to the center of a 1 degree
+//     cell (about 110 km) and the elevation rounded to 100 m
+//   - the month, not the time, of the request
+//   - the selected species, their climate match scores and ranks, the
+//     diversity metrics and the candidate pool size
+//
+// and never the API key, the client address or the exact site.
+//
+// Every PUBLIC_DATASET_INTERVAL (default weekly) with new contributions, or
+// on POST /api/admin/dataset/release, a new version is cut: gzipped NDJSON
+// of every contribution so far, written once and never changed. The
+// versions are public:
+//
+//	GET /api/dataset             releases, license and record fields
+//	GET /api/dataset/{version}   one release (stable, cacheable forever)
+//	GET /api/dataset/latest      redirect to the newest release
+
+const (
+	researchGridDeg       = 1.0
+	researchElevationStep = 100.0
+	defaultDatasetLicense = "CC-BY-4.0"
+)
+
+var errNoNewContributions = errors.New("no contributions since the last release")
+
+type datasetConfig struct {
+	Store    archiveStore // nil: no dataset
+	Interval time.Duration
+	License  string
+}
+
+// loadDatasetConfig reads PUBLIC_DATASET_URL, PUBLIC_DATASET_INTERVAL and
+// PUBLIC_DATASET_LICENSE
+func loadDatasetConfig() datasetConfig {
+	c := datasetConfig{
+		Interval: getEnvDuration("PUBLIC_DATASET_INTERVAL", 7*24*time.Hour),
+		License:  getEnv("PUBLIC_DATASET_LICENSE", defaultDatasetLicense),
+	}
+	store, err := newArchiveStore(getEnv("PUBLIC_DATASET_URL", ""))
+	if err != nil {
+		log.Printf("Invalid PUBLIC_DATASET_URL: %v; research dataset disabled", err)
+	}
+	c.Store = store
+	return c
+}
+
+type ResearchSpecies struct {
+	SpeciesID         int64   `json:"species_id"`
+	CanonicalName     string  `json:"canonical_name"`
+	Family            string  `json:"family"`
+	GrowthForm        string  `json:"growth_form"`
+	ClimateMatchScore float64 `json:"climate_match_score"`
+	SelectionRank     int     `json:"selection_rank"`
+}
+
+// ResearchRecord is one published recommendation
+type ResearchRecord struct {
+	Month             string            `json:"month"` // YYYY-MM
+	TDWGCode          string            `json:"tdwg_code"`
+	Request           RecommendRequest  `json:"request"` // Coordinates generalized
+	Species           []ResearchSpecies `json:"species"`
+	DiversityMetrics  DiversityMetrics  `json:"diversity_metrics"`
+	CandidatePoolSize int               `json:"candidate_pool_size"`
+	SelectionHash     string            `json:"selection_hash"`
+}
+
+// anonymizeRecommendation builds the dataset record of a recommendation
+func anonymizeRecommendation(req RecommendRequest, resp *RecommendResponse, at time.Time) ResearchRecord {
+	// Drawn seeds are request timestamps, and an AOI id names a user's area
+	req = req.withClientSeeds()
+	req.ShareForResearch, req.AOIID = false, ""
+	if req.Latitude != nil && req.Longitude != nil {
+		lat, lon := generalizeCoordinates(*req.Latitude, *req.Longitude, researchGridDeg)
+		req.Latitude, req.Longitude = &lat, &lon
+	} else {
+		req.Latitude, req.Longitude = nil, nil
+	}
+	if req.ElevationM != nil {
+		e := math.Round(*req.ElevationM/researchElevationStep) * researchElevationStep
+		req.ElevationM = &e
+	}
+
+	rec := ResearchRecord{
+		Month:             at.UTC().Format("2006-01"),
+		TDWGCode:          resp.LocationInfo.TDWGCode,
+		Request:           req,
+		Species:           make([]ResearchSpecies, len(resp.Species)),
+		DiversityMetrics:  resp.DiversityMetrics,
+		CandidatePoolSize: resp.CandidatePool.Size,
+		SelectionHash:     resp.SelectionHash,
+	}
+	for i, sp := range resp.Species {
+		rec.Species[i] = ResearchSpecies{
+			SpeciesID:         sp.SpeciesID,
+			CanonicalName:     sp.CanonicalName,
+			Family:            sp.Family,
+			GrowthForm:        sp.GrowthForm,
+			ClimateMatchScore: sp.ClimateMatchScore,
+			SelectionRank:     sp.SelectionRank,
+		}
+	}
+	return rec
+}
+
+// recordResearchContribution stores rec; like telemetry, failures are only
+// logged
+func (s *Server) recordResearchContribution(rec ResearchRecord) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		s.log.Printf("research contribution: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO research_contributions (contributed_month, tdwg_code, record)
+		VALUES (($1 || '-01')::date, NULLIF($2, ''), $3)
+	`, rec.Month, rec.TDWGCode, string(data)); err != nil {
+		s.log.Printf("research contribution: %v", err)
+	}
+}
+
+// ============================================================================
+// RELEASES
+// ============================================================================
+
+type DatasetRelease struct {
+	Version    int    `json:"version"`
+	ReleasedAt string `json:"released_at"`
+	NRecords   int    `json:"n_records"`
+	Bytes      int64  `json:"bytes"`
+	SHA256     string `json:"sha256"`
+	URL        string `json:"url"`
+	objectKey  string
+	lastID     int64
+}
+
+// datasetObjectKey names the object of a release
+func datasetObjectKey(version int) string {
+	return fmt.Sprintf("recommendations/v%d/diversiplant-recommendations-v%d.ndjson.gz", version, version)
+}
+
+// runDatasetRelease cuts the next version; errNoNewContributions when the
+// last one is still current
+func (s *Server) runDatasetRelease(ctx context.Context) (*DatasetRelease, error) {
+	store := s.cfg.Dataset.Store
+	if store == nil {
+		return nil, fmt.Errorf("PUBLIC_DATASET_URL not set")
+	}
+	if !s.datasetMu.TryLock() {
+		return nil, fmt.Errorf("a release is already in progress")
+	}
+	defer s.datasetMu.Unlock()
+
+	rel := &DatasetRelease{}
+	var released int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT MAX(version) FROM dataset_releases), 0) + 1,
+		       COALESCE((SELECT MAX(id) FROM research_contributions), 0),
+		       COALESCE((SELECT MAX(last_contribution_id) FROM dataset_releases), 0)
+	`).Scan(&rel.Version, &rel.lastID, &released)
+	if err != nil {
+		return nil, err
+	}
+	if rel.lastID <= released {
+		return nil, errNoNewContributions
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT record FROM research_contributions WHERE id <= $1 ORDER BY id
+	`, rel.lastID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for rows.Next() {
+		var record []byte
+		if err := rows.Scan(&record); err != nil {
+			return nil, err
+		}
+		zw.Write(append(record, '\n'))
+		rel.NRecords++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	sum := sha256.Sum256(data)
+	rel.SHA256, rel.Bytes, rel.objectKey = hex.EncodeToString(sum[:]), int64(len(data)), datasetObjectKey(rel.Version)
+	if err := store.Put(ctx, rel.objectKey, data); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", rel.objectKey, err)
+	}
+
+	var releasedAt time.Time
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO dataset_releases (version, n_records, last_contribution_id, object_key, bytes, sha256)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING released_at
+	`, rel.Version, rel.NRecords, rel.lastID, rel.objectKey, rel.Bytes, rel.SHA256).Scan(&releasedAt)
+	if err != nil {
+		return nil, err
+	}
+	rel.ReleasedAt = releasedAt.Format(time.RFC3339)
+	rel.URL = fmt.Sprintf("/api/dataset/%d", rel.Version)
+	return rel, nil
+}
+
+// startDatasetReleaseJob cuts a release every PUBLIC_DATASET_INTERVAL
+func (s *Server) startDatasetReleaseJob() {
+	cfg := s.cfg.Dataset
+	if cfg.Store == nil || cfg.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			rel, err := s.runDatasetRelease(context.Background())
+			if err == errNoNewContributions {
+				continue
+			}
+			if err != nil {
+				s.log.Printf("Research dataset release failed: %v", err)
+				continue
+			}
+			s.log.Printf("Research dataset v%d released: %d records", rel.Version, rel.NRecords)
+		}
+	}()
+}
+
+func (s *Server) listDatasetReleases(ctx context.Context) ([]DatasetRelease, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT version, released_at, n_records, bytes, sha256, object_key
+		FROM dataset_releases
+		ORDER BY version DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := []DatasetRelease{}
+	for rows.Next() {
+		var rel DatasetRelease
+		var releasedAt time.Time
+		if err := rows.Scan(&rel.Version, &releasedAt, &rel.NRecords, &rel.Bytes, &rel.SHA256, &rel.objectKey); err != nil {
+			return nil, err
+		}
+		rel.ReleasedAt = releasedAt.Format(time.RFC3339)
+		rel.URL = fmt.Sprintf("/api/dataset/%d", rel.Version)
+		releases = append(releases, rel)
+	}
+	return releases, rows.Err()
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// datasetFields documents the record layout for GET /api/dataset
+var datasetFields = map[string]string{
+	"month":               "Month of the request, YYYY-MM",
+	"tdwg_code":           "TDWG level 3 region of the site",
+	"request":             "The /api/recommend request; latitude/longitude are 1 degree cell centers, elevation_m rounded to 100 m",
+	"species":             "Selected species with climate_match_score and selection_rank",
+	"diversity_metrics":   "Diversity metrics of the selection",
+	"candidate_pool_size": "Candidates after filtering, before selection",
+	"selection_hash":      "Fingerprint of the ranked selection",
+}
+
+// handleDataset handles GET /api/dataset, /api/dataset/{version} and
+// /api/dataset/latest
+func (s *Server) handleDataset(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	store := s.cfg.Dataset.Store
+	if store == nil {
+		http.Error(w, `{"error": "Research dataset is not published here"}`, http.StatusNotFound)
+		return
+	}
+
+	name := pathParam(r, "version")
+	if name == "" {
+		releases, err := s.listDatasetReleases(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		resp := map[string]interface{}{
+			"releases": releases,
+			"license":  s.cfg.Dataset.License,
+			"format":   "gzipped NDJSON, one recommendation per line",
+			"fields":   datasetFields,
+		}
+		if len(releases) > 0 {
+			resp["latest"] = releases[0].Version
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var rel DatasetRelease
+	var err error
+	if name == "latest" {
+		err = s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM dataset_releases`).Scan(&rel.Version)
+		if err == nil && rel.Version == 0 {
+			err = sql.ErrNoRows
+		}
+		if err == nil {
+			http.Redirect(w, r, fmt.Sprintf("/api/dataset/%d", rel.Version), http.StatusFound)
+			return
+		}
+	} else {
+		if rel.Version, err = strconv.Atoi(strings.TrimPrefix(name, "v")); err != nil || rel.Version <= 0 {
+			http.Error(w, `{"error": "Not found"}`, http.StatusNotFound)
+			return
+		}
+		err = s.db.QueryRowContext(ctx, `
+			SELECT object_key, sha256 FROM dataset_releases WHERE version = $1
+		`, rel.Version).Scan(&rel.objectKey, &rel.SHA256)
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Release not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + rel.SHA256 + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	data, err := store.Get(ctx, rel.objectKey)
+	if err != nil {
+		s.log.Printf("Error reading dataset v%d: %v", rel.Version, err)
+		http.Error(w, `{"error": "Release unavailable"}`, http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="diversiplant-recommendations-v%d.ndjson.gz"`, rel.Version))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	w.Write(data)
+}
+
+// handleDatasetRelease handles POST /api/admin/dataset/release
+func (s *Server) handleDatasetRelease(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	rel, err := s.runDatasetRelease(r.Context())
+	if err == errNoNewContributions {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rel)
+}
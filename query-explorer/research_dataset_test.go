@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnonymizeRecommendation(t *testing.T) {
+	lat, lon, elev := -23.5617, -46.6561, 763.0
+	drawn, chosen := time.Now().UnixNano(), int64(424242)
+	req := RecommendRequest{Latitude: &lat, Longitude: &lon, ElevationM: &elev, NSpecies: 2, ShareForResearch: true,
+		AOIID: "aoi-7f3a", StartSeed: &chosen, RandomSeed: &drawn, randomSeedFilled: true}
+	resp := &RecommendResponse{
+		Species: []SpeciesRecommendation{
+			{SpeciesID: 1, CanonicalName: "Inga edulis", Family: "Fabaceae", ClimateMatchScore: 0.91, SelectionRank: 1},
+			{SpeciesID: 2, CanonicalName: "Euterpe edulis", Family: "Arecaceae", ClimateMatchScore: 0.84, SelectionRank: 2},
+		},
+		LocationInfo:  LocationInfo{TDWGCode: "BZS", Latitude: &lat, Longitude: &lon},
+		CandidatePool: CandidatePoolInfo{Size: 312},
+		SelectionHash: "abc",
+	}
+	rec := anonymizeRecommendation(req, resp, time.Date(2026, 10, 14, 15, 4, 5, 0, time.UTC))
+
+	if rec.Month != "2026-10" || rec.TDWGCode != "BZS" || rec.CandidatePoolSize != 312 || len(rec.Species) != 2 {
+		t.Errorf("record = %+v", rec)
+	}
+	if *rec.Request.Latitude != -23.5 || *rec.Request.Longitude != -46.5 || *rec.Request.ElevationM != 800 {
+		t.Errorf("location = %v, %v, %v", *rec.Request.Latitude, *rec.Request.Longitude, *rec.Request.ElevationM)
+	}
+	if lat != -23.5617 {
+		t.Error("caller's coordinates modified")
+	}
+
+	if rec.Request.StartSeed == nil || *rec.Request.StartSeed != chosen || rec.Request.RandomSeed != nil {
+		t.Errorf("seeds = %v, %v; want the client's start_seed only", rec.Request.StartSeed, rec.Request.RandomSeed)
+	}
+
+	data, _ := json.Marshal(rec)
+	for _, leak := range []string{"23.56", "46.65", "763", "share_for_research", "15:04", "aoi", strconv.FormatInt(drawn, 10)} {
+		if strings.Contains(string(data), leak) {
+			t.Errorf("record contains %q: %s", leak, data)
+		}
+	}
+}
+
+func TestDatasetDisabled(t *testing.T) {
+	s := newTestServer()
+	s.cfg.Dataset.Store = nil
+	for _, path := range []string{"/api/dataset", "/api/dataset/1", "/api/dataset/latest"} {
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: %d, want 404", path, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest("POST", "/api/dataset", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: %d, want 405", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.handleDatasetRelease(w, httptest.NewRequest("POST", "/api/admin/dataset/release", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous release: %d, want 401", w.Code)
+	}
+}
+
+func TestDatasetObjectKey(t *testing.T) {
+	if got := datasetObjectKey(3); got != "recommendations/v3/diversiplant-recommendations-v3.ndjson.gz" {
+		t.Errorf("datasetObjectKey(3) = %q", got)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// RETENTION AND ARCHIVING
+// ============================================================================
+//
+// Every RETENTION_INTERVAL the server deletes rows older than their kind's
+// retention period, in batches, after writing each batch to the archive
+// (archive_store.go) as gzipped NDJSON of the raw rows (migration 045). A
+// batch is read without locks and uploaded first; its rows are then deleted
+// in a short transaction, so no row lock is held across the upload. Rows
+// that stopped being expired meanwhile stay, their copy in the object left
+// as a harmless duplicate. Without ARCHIVE_URL rows are deleted unarchived,
+// as finished query jobs always were.
+//
+//	recommendations  recommendation_cache, by expires_at   RETENTION_RECOMMENDATIONS (90 days)
+//	query_jobs       finished async jobs with their result  RETENTION_QUERY_JOBS (24h)
+//	spatial_exports  finished GeoParquet exports            RETENTION_SPATIAL_EXPORTS (24h)
+//	query_audit      the query audit log                    RETENTION_QUERY_AUDIT (0: kept)
+//
+// A period of 0 keeps that kind forever. CSV exports (/api/export, plan
+// exports) are streamed and never stored, so they need no policy; the files
+// of spatial exports are deleted with their job, unarchived.
+//
+// POST /api/admin/archive/recommendations/{id}/restore puts an archived
+// recommendation back in recommendation_cache, valid for another day, and
+// returns its response.
+
+const (
+	defaultRetentionBatch     = 1000
+	restoredRecommendationTTL = 24 * time.Hour
+)
+
+type retentionPolicy struct {
+	Kind      string
+	Table     string
+	IDType    string // SQL type of the id column
+	AgeColumn string // Rows go once this is older than the period
+	Env       string
+	Default   time.Duration
+}
+
+var retentionPolicies = []retentionPolicy{
+	{"recommendations", "recommendation_cache", "integer", "expires_at", "RETENTION_RECOMMENDATIONS", 90 * 24 * time.Hour},
+	{"query_jobs", "query_jobs", "varchar", "finished_at", "RETENTION_QUERY_JOBS", 24 * time.Hour},
+	{"spatial_exports", "spatial_export_jobs", "varchar", "finished_at", "RETENTION_SPATIAL_EXPORTS", 24 * time.Hour},
+	{"query_audit", "query_audit", "bigint", "executed_at", "RETENTION_QUERY_AUDIT", 0},
+}
+
+type retentionConfig struct {
+	Interval  time.Duration
+	BatchSize int
+	Periods   map[string]time.Duration // Kind -> period; 0 keeps the rows
+	Archive   archiveStore             // nil: delete without archiving
+}
+
+// loadRetentionConfig reads RETENTION_INTERVAL, RETENTION_BATCH_SIZE, the
+// RETENTION_* periods and ARCHIVE_URL; an invalid archive disables deletion
+// rather than deleting unarchived
+func loadRetentionConfig() retentionConfig {
+	c := retentionConfig{
+		Interval:  getEnvDuration("RETENTION_INTERVAL", time.Hour),
+		BatchSize: getEnvInt("RETENTION_BATCH_SIZE", defaultRetentionBatch),
+		Periods:   map[string]time.Duration{},
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultRetentionBatch
+	}
+	for _, p := range retentionPolicies {
+		c.Periods[p.Kind] = getEnvDuration(p.Env, p.Default)
+	}
+	archive, err := newArchiveStore(getEnv("ARCHIVE_URL", ""))
+	if err != nil {
+		log.Printf("Invalid ARCHIVE_URL: %v; retention disabled", err)
+		c.Interval = 0
+	}
+	c.Archive = archive
+	return c
+}
+
+type RetentionKindResult struct {
+	Kind     string `json:"kind"`
+	Period   string `json:"period"` // "0s": kept
+	Archived int64  `json:"archived"`
+	Deleted  int64  `json:"deleted"`
+	Batches  int    `json:"batches"`
+	Error    string `json:"error,omitempty"`
+}
+
+type RetentionRunSummary struct {
+	Archive  string                `json:"archive"` // "" when rows are deleted unarchived
+	Kinds    []RetentionKindResult `json:"kinds"`
+	Duration string                `json:"duration"`
+}
+
+// archiveObjectKey names a batch object, e.g.
+// query_audit/2026/10/14/20261014T030000Z-1001-2000.ndjson.gz
+func archiveObjectKey(kind string, at time.Time, firstID, lastID string) string {
+	at = at.UTC()
+	return fmt.Sprintf("%s/%s/%s-%s-%s.ndjson.gz", kind, at.Format("2006/01/02"), at.Format("20060102T150405Z"), firstID, lastID)
+}
+
+// encodeArchiveBatch gzips one JSON row per line
+func encodeArchiveBatch(records []string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, rec := range records {
+		if _, err := zw.Write(append([]byte(rec), '\n')); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// findArchivedRecord returns the row with the given id from a batch object
+func findArchivedRecord(data []byte, id int64) (json.RawMessage, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var row struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return nil, err
+		}
+		if row.ID == id {
+			return json.RawMessage(append([]byte(nil), scanner.Bytes()...)), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("record %d not found in archive object", id)
+}
+
+// archiveBatch archives and deletes up to BatchSize expired rows of p;
+// it returns how many rows went
+func (s *Server) archiveBatch(ctx context.Context, p retentionPolicy, period time.Duration) (int, error) {
+	cfg := s.cfg.Retention
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT t.id::text, row_to_json(t)::text
+		FROM %s t
+		WHERE t.%s < NOW() - $1 * INTERVAL '1 second'
+		ORDER BY t.%s
+		LIMIT $2
+	`, p.Table, p.AgeColumn, p.AgeColumn), period.Seconds(), cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var ids, records []string
+	for rows.Next() {
+		var id, rec string
+		if err := rows.Scan(&id, &rec); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		records = append(records, rec)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	var key string
+	var data []byte
+	if cfg.Archive != nil {
+		if data, err = encodeArchiveBatch(records); err != nil {
+			return 0, err
+		}
+		key = archiveObjectKey(p.Kind, time.Now(), ids[0], ids[len(ids)-1])
+		if err := cfg.Archive.Put(ctx, key, data); err != nil {
+			return 0, fmt.Errorf("archiving %s: %w", key, err)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Only the rows still expired and not taken by another run go
+	ids, err = lockExpiredRows(ctx, tx, p, period, ids)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	if cfg.Archive != nil {
+		var batchID int64
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO archive_batches (kind, object_key, n_records, bytes)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+		`, p.Kind, key, len(records), len(data)).Scan(&batchID); err != nil {
+			return 0, err
+		}
+		if p.Kind == "recommendations" {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO archived_recommendations (id, cache_key, batch_id)
+				SELECT id, cache_key, $1 FROM recommendation_cache WHERE id = ANY($2::integer[])
+				ON CONFLICT (id) DO UPDATE
+				SET cache_key = EXCLUDED.cache_key, batch_id = EXCLUDED.batch_id,
+				    archived_at = NOW(), restored_at = NULL
+			`, batchID, pq.Array(ids)); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ANY($1::%s[])`, p.Table, p.IDType), pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// lockExpiredRows locks the rows of ids of p that are still expired,
+// skipping those another transaction holds, and returns their ids
+func lockExpiredRows(ctx context.Context, tx *sql.Tx, p retentionPolicy, period time.Duration, ids []string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT t.id::text
+		FROM %s t
+		WHERE t.id = ANY($1::%s[]) AND t.%s < NOW() - $2 * INTERVAL '1 second'
+		FOR UPDATE SKIP LOCKED
+	`, p.Table, p.IDType, p.AgeColumn), pq.Array(ids), period.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var locked []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		locked = append(locked, id)
+	}
+	return locked, rows.Err()
+}
+
+// runRetention applies every policy once; a failing kind does not stop the
+// others
+func (s *Server) runRetention(ctx context.Context) (*RetentionRunSummary, error) {
+	if !s.retentionMu.TryLock() {
+		return nil, fmt.Errorf("a retention run is already in progress")
+	}
+	defer s.retentionMu.Unlock()
+
+	start := time.Now()
+	cfg := s.cfg.Retention
+	summary := &RetentionRunSummary{Kinds: []RetentionKindResult{}}
+	if cfg.Archive != nil {
+		summary.Archive = cfg.Archive.String()
+	}
+	for _, p := range retentionPolicies {
+		period := cfg.Periods[p.Kind]
+		result := RetentionKindResult{Kind: p.Kind, Period: period.String()}
+		for period > 0 {
+			n, err := s.archiveBatch(ctx, p, period)
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+			if n == 0 {
+				break
+			}
+			result.Deleted += int64(n)
+			if cfg.Archive != nil {
+				result.Archived += int64(n)
+				result.Batches++
+			}
+			if n < cfg.BatchSize {
+				break
+			}
+		}
+		summary.Kinds = append(summary.Kinds, result)
+	}
+	summary.Duration = time.Since(start).String()
+	return summary, nil
+}
+
+func (s *Server) startRetentionJob() {
+	cfg := s.cfg.Retention
+	if cfg.Interval <= 0 {
+		return
+	}
+	if cfg.Archive == nil {
+		s.log.Printf("ARCHIVE_URL not set: expired rows are deleted without archiving")
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			summary, err := s.runRetention(context.Background())
+			if err != nil {
+				s.log.Printf("Retention run skipped: %v", err)
+				continue
+			}
+			for _, k := range summary.Kinds {
+				if k.Error != "" {
+					s.log.Printf("Retention %s failed after %d rows: %s", k.Kind, k.Deleted, k.Error)
+				} else if k.Deleted > 0 {
+					s.log.Printf("Retention %s: %d rows deleted, %d archived", k.Kind, k.Deleted, k.Archived)
+				}
+			}
+		}
+	}()
+	s.log.Printf("Retention job scheduled every %s", cfg.Interval)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+type RetentionPolicyStatus struct {
+	Kind           string  `json:"kind"`
+	Table          string  `json:"table"`
+	Period         string  `json:"period"`
+	Batches        int64   `json:"archived_batches"`
+	Records        int64   `json:"archived_records"`
+	Bytes          int64   `json:"archived_bytes"`
+	LastArchivedAt *string `json:"last_archived_at"`
+}
+
+// handleRetention handles GET (policies and archive totals) and POST (run
+// now) on /api/admin/retention
+func (s *Server) handleRetention(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		cfg := s.cfg.Retention
+		policies := []RetentionPolicyStatus{}
+		for _, p := range retentionPolicies {
+			st := RetentionPolicyStatus{Kind: p.Kind, Table: p.Table, Period: cfg.Periods[p.Kind].String()}
+			err := s.db.QueryRowContext(ctx, `
+				SELECT COUNT(*), COALESCE(SUM(n_records), 0), COALESCE(SUM(bytes), 0),
+				       TO_CHAR(MAX(archived_at), 'YYYY-MM-DD"T"HH24:MI:SS')
+				FROM archive_batches WHERE kind = $1
+			`, p.Kind).Scan(&st.Batches, &st.Records, &st.Bytes, &st.LastArchivedAt)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+				return
+			}
+			policies = append(policies, st)
+		}
+		archive := ""
+		if cfg.Archive != nil {
+			archive = cfg.Archive.String()
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"interval": cfg.Interval.String(),
+			"archive":  archive,
+			"policies": policies,
+		})
+
+	case http.MethodPost:
+		summary, err := s.runRetention(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(summary)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// handleArchivedRecommendation handles
+// POST /api/admin/archive/recommendations/{id}/restore
+func (s *Server) handleArchivedRecommendation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, `{"error": "Invalid recommendation id"}`, http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+	archive := s.cfg.Retention.Archive
+	if archive == nil {
+		http.Error(w, `{"error": "No archive configured (ARCHIVE_URL)"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var cacheKey, objectKey string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT ar.cache_key, b.object_key
+		FROM archived_recommendations ar
+		JOIN archive_batches b ON b.id = ar.batch_id
+		WHERE ar.id = $1
+	`, id).Scan(&cacheKey, &objectKey)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Recommendation not archived"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := archive.Get(ctx, objectKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadGateway)
+		return
+	}
+	record, err := findArchivedRecord(data, id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	// A later request with the same parameters may have been cached since
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO recommendation_cache
+		SELECT (json_populate_record(NULL::recommendation_cache, $1::json)).*
+		ON CONFLICT DO NOTHING
+	`, string(record))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, `{"error": "recommendation_cache already holds this ID or cache key"}`, http.StatusConflict)
+		return
+	}
+	var expiresAt string
+	var response json.RawMessage
+	err = tx.QueryRowContext(ctx, `
+		UPDATE recommendation_cache SET expires_at = NOW() + $2::interval
+		WHERE id = $1
+		RETURNING TO_CHAR(expires_at, 'YYYY-MM-DD"T"HH24:MI:SS'), COALESCE(response, 'null'::jsonb)
+	`, id, fmt.Sprintf("%d seconds", int(restoredRecommendationTTL.Seconds()))).Scan(&expiresAt, &response)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE archived_recommendations SET restored_at = NOW() WHERE id = $1`, id); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         id,
+		"cache_key":  cacheKey,
+		"object_key": objectKey,
+		"expires_at": expiresAt,
+		"response":   response,
+	})
+}
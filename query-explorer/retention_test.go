@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveObjectKey(t *testing.T) {
+	at := time.Date(2026, 10, 14, 3, 0, 0, 0, time.FixedZone("BRT", -3*3600))
+	want := "query_audit/2026/10/14/20261014T060000Z-1001-2000.ndjson.gz"
+	if got := archiveObjectKey("query_audit", at, "1001", "2000"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestArchiveBatchRoundTrip(t *testing.T) {
+	data, err := encodeArchiveBatch([]string{
+		`{"id": 7, "cache_key": "a"}`,
+		`{"id": 12, "cache_key": "b", "response": {"species": []}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := findArchivedRecord(data, 12)
+	if err != nil || string(rec) != `{"id": 12, "cache_key": "b", "response": {"species": []}}` {
+		t.Errorf("find 12: %s, %v", rec, err)
+	}
+	if _, err := findArchivedRecord(data, 8); err == nil {
+		t.Error("missing id should fail")
+	}
+	if _, err := findArchivedRecord([]byte("not gzip"), 7); err == nil {
+		t.Error("corrupt object should fail")
+	}
+}
+
+func TestLoadRetentionConfig(t *testing.T) {
+	t.Setenv("RETENTION_QUERY_AUDIT", "8760h")
+	t.Setenv("RETENTION_BATCH_SIZE", "-1")
+	t.Setenv("ARCHIVE_URL", "file:///var/archive")
+	c := loadRetentionConfig()
+	if c.Periods["query_audit"] != 8760*time.Hour || c.Periods["query_jobs"] != 24*time.Hour {
+		t.Errorf("periods %v", c.Periods)
+	}
+	if c.BatchSize != defaultRetentionBatch {
+		t.Errorf("batch size %d", c.BatchSize)
+	}
+	if c.Archive == nil || c.Archive.String() != "file:///var/archive" || c.Interval != time.Hour {
+		t.Errorf("archive %v, interval %s", c.Archive, c.Interval)
+	}
+
+	// Never delete unarchived because of a typo in the archive URL
+	t.Setenv("ARCHIVE_URL", "s4://bucket")
+	if c := loadRetentionConfig(); c.Interval != 0 {
+		t.Errorf("invalid archive should disable retention, interval %s", c.Interval)
+	}
+}
+
+func TestArchivedRecommendationRouting(t *testing.T) {
+	h := newTestServer().router()
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{"POST", "/api/admin/archive/recommendations/42", http.StatusNotFound},
+		{"POST", "/api/admin/archive/recommendations/abc/restore", http.StatusBadRequest},
+		{"GET", "/api/admin/archive/recommendations/42/restore", http.StatusMethodNotAllowed},
+		{"POST", "/api/admin/archive/recommendations/42/restore", http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.path, w.Code, tc.code)
+		}
+	}
+}
+
+// loggedArchive is a dirArchive whose uploads go to the fakeDB log, to
+// check them against the transactions
+type loggedArchive struct {
+	dirArchive
+	db  *fakeDB
+	err error
+}
+
+func (a loggedArchive) Put(ctx context.Context, key string, data []byte) error {
+	a.db.record("PUT "+key, nil)
+	if a.err != nil {
+		return a.err
+	}
+	return a.dirArchive.Put(ctx, key, data)
+}
+
+// archiveBatchQueries answer archiveBatch for two expired recommendations,
+// of which only 1 is still expired once locked
+var archiveBatchQueries = []fakeQuery{
+	{match: "row_to_json(t)", columns: []string{"id", "row"},
+		rows: [][]driver.Value{{"1", `{"id": 1}`}, {"2", `{"id": 2}`}}},
+	{match: "FOR UPDATE SKIP LOCKED", columns: []string{"id"}, rows: [][]driver.Value{{"1"}}},
+	{match: "INSERT INTO archive_batches", columns: []string{"id"}, rows: [][]driver.Value{{int64(7)}}},
+	{match: "INSERT INTO archived_recommendations"},
+	{match: "DELETE FROM recommendation_cache"},
+}
+
+func TestArchiveBatchUploadsBeforeLocking(t *testing.T) {
+	s, db := newFakeDBServer(t, archiveBatchQueries...)
+	s.cfg.Retention = retentionConfig{BatchSize: 10, Archive: loggedArchive{dirArchive{t.TempDir()}, db, nil}}
+
+	n, err := s.archiveBatch(context.Background(), retentionPolicies[0], time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("got %d, %v; want 1 row", n, err)
+	}
+	put, begin, lock := db.index("PUT recommendations/"), db.index("BEGIN"), db.index("FOR UPDATE")
+	if put < 0 || begin < put || lock < begin {
+		t.Errorf("upload at %d, begin at %d, lock at %d: %q", put, begin, lock, db.log)
+	}
+	if db.index("COMMIT") < db.index("DELETE FROM recommendation_cache") {
+		t.Errorf("delete not committed: %q", db.log)
+	}
+}
+
+func TestArchiveBatchFailedUploadDeletesNothing(t *testing.T) {
+	s, db := newFakeDBServer(t, archiveBatchQueries...)
+	s.cfg.Retention = retentionConfig{BatchSize: 10, Archive: loggedArchive{dirArchive{t.TempDir()}, db, fmt.Errorf("bucket unreachable")}}
+
+	if n, err := s.archiveBatch(context.Background(), retentionPolicies[0], time.Hour); err == nil || n != 0 {
+		t.Errorf("got %d, %v; want the upload error", n, err)
+	}
+	if db.index("BEGIN") >= 0 || db.index("DELETE") >= 0 {
+		t.Errorf("rows touched after a failed upload: %q", db.log)
+	}
+}
+
+func TestHandleRetention(t *testing.T) {
+	s, db := newFakeDBServer(t, fakeAdminKey,
+		fakeQuery{match: "FROM archive_batches WHERE kind", columns: []string{"count", "records", "bytes", "last"},
+			rows: [][]driver.Value{{int64(2), int64(1500), int64(40960), "2026-10-13T03:00:00"}}},
+		fakeQuery{match: "row_to_json(t)", columns: []string{"id", "row"}})
+	s.cfg.Retention = retentionConfig{BatchSize: 10, Periods: map[string]time.Duration{"query_jobs": time.Hour}}
+	h := s.router()
+
+	req := httptest.NewRequest("GET", "/api/admin/retention", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"archived_batches":2`) {
+		t.Errorf("GET: %d %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/api/admin/retention", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"kind":"query_jobs"`) {
+		t.Errorf("POST: %d %s", w.Code, w.Body.String())
+	}
+	// Only the kind with a period is read
+	if got := db.executed("row_to_json(t)"); len(got) != 1 || !strings.Contains(got[0], "query_jobs") {
+		t.Errorf("batches read: %q", got)
+	}
+}
+
+func TestHandleArchivedRecommendationRestore(t *testing.T) {
+	archive := dirArchive{t.TempDir()}
+	data, err := encodeArchiveBatch([]string{`{"id": 42, "cache_key": "k"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Put(context.Background(), "recommendations/b.ndjson.gz", data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		archive archiveStore
+		queries []fakeQuery
+		code    int
+	}{
+		{"no archive", nil, nil, http.StatusServiceUnavailable},
+		{"not archived", archive, []fakeQuery{{match: "FROM archived_recommendations ar", columns: []string{"cache_key", "object_key"}}},
+			http.StatusNotFound},
+		{"object missing", archive, []fakeQuery{{match: "FROM archived_recommendations ar", columns: []string{"cache_key", "object_key"},
+			rows: [][]driver.Value{{"k", "recommendations/gone.ndjson.gz"}}}}, http.StatusBadGateway},
+		{"already cached", archive, []fakeQuery{
+			{match: "FROM archived_recommendations ar", columns: []string{"cache_key", "object_key"},
+				rows: [][]driver.Value{{"k", "recommendations/b.ndjson.gz"}}},
+			{match: "INSERT INTO recommendation_cache"},
+		}, http.StatusConflict},
+		{"restored", archive, []fakeQuery{
+			{match: "FROM archived_recommendations ar", columns: []string{"cache_key", "object_key"},
+				rows: [][]driver.Value{{"k", "recommendations/b.ndjson.gz"}}},
+			{match: "INSERT INTO recommendation_cache", rows: [][]driver.Value{{}}},
+			{match: "UPDATE recommendation_cache SET expires_at", columns: []string{"expires_at", "response"},
+				rows: [][]driver.Value{{"2026-10-15T03:00:00", []byte(`{"species": []}`)}}},
+			{match: "UPDATE archived_recommendations SET restored_at"},
+		}, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, db := newFakeDBServer(t, append([]fakeQuery{fakeAdminKey}, tc.queries...)...)
+			s.cfg.Retention = retentionConfig{Archive: tc.archive}
+			req := httptest.NewRequest("POST", "/api/admin/archive/recommendations/42/restore", nil)
+			req.Header.Set("Authorization", "Bearer admin")
+			w := httptest.NewRecorder()
+			s.router().ServeHTTP(w, req)
+			if w.Code != tc.code {
+				t.Errorf("%d %s, want %d", w.Code, w.Body.String(), tc.code)
+			}
+			if tc.code == http.StatusOK && (db.index("COMMIT") < 0 || !strings.Contains(w.Body.String(), `"response":{"species":[]}`)) {
+				t.Errorf("%s, log %q", w.Body.String(), db.log)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// OUTLIER-ROBUST CLIMATE ENVELOPES
+// ============================================================================
+//
+// species_climate_envelope takes the absolute min/max of the climate of a
+// species' native regions, so a single erroneous distribution record in a
+// far hotter or colder region inflates its apparent tolerance and lets it
+// through the bio5/bio6 hard limits everywhere. A recommendation may ask
+// for envelope_mode "percentile" or "kde" instead: the limits become the
+// 5th-95th percentiles across the native regions, or the same quantiles of
+// a Gaussian kernel density fitted to them, which is smoother for species
+// with few regions. Means are unchanged.
+//
+// The robust envelopes are precomputed into species_climate_envelope_robust
+// (migration 053) by POST /api/admin/envelopes?method=percentile|kde, with
+// optional lower and upper quantiles. Species with fewer than
+// minRobustEnvelopeRegions regions get no robust row and keep their
+// min/max envelope in every mode.
+
+const (
+	envelopeMinMax     = "minmax"
+	envelopePercentile = "percentile"
+	envelopeKDE        = "kde"
+
+	minRobustEnvelopeRegions = 5
+	defaultEnvelopeLower     = 0.05
+	defaultEnvelopeUpper     = 0.95
+)
+
+var errEnvelopeRebuildRunning = errors.New("an envelope rebuild is already running")
+
+// parseEnvelopeMode validates an envelope_mode; minmax is returned as ""
+// so it shares the default's cache entries
+func parseEnvelopeMode(mode string) (string, error) {
+	switch mode {
+	case "", envelopeMinMax:
+		return "", nil
+	case envelopePercentile, envelopeKDE:
+		return mode, nil
+	}
+	return "", fmt.Errorf("envelope_mode must be %s, %s or %s", envelopeMinMax, envelopePercentile, envelopeKDE)
+}
+
+// validateEnvelopeMode normalizes req.EnvelopeMode
+func validateEnvelopeMode(req *RecommendRequest) error {
+	mode, err := parseEnvelopeMode(req.EnvelopeMode)
+	if err != nil {
+		return err
+	}
+	req.EnvelopeMode = mode
+	return nil
+}
+
+// percentile returns quantile q of sorted values, interpolating linearly
+// between ranks as PostgreSQL's percentile_cont does
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+// kdeQuantile returns quantile q of a Gaussian kernel density estimate of
+// sorted values, with Silverman's rule-of-thumb bandwidth. The result is
+// clamped to the range of the values, so a robust envelope is never wider
+// than the min/max one.
+func kdeQuantile(sorted []float64, q float64) float64 {
+	n := float64(len(sorted))
+	mean := 0.0
+	for _, v := range sorted {
+		mean += v
+	}
+	mean /= n
+	variance := 0.0
+	for _, v := range sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	sd := 0.0
+	if n > 1 {
+		sd = math.Sqrt(variance / (n - 1))
+	}
+	spread := sd
+	if iqr := (percentile(sorted, 0.75) - percentile(sorted, 0.25)) / 1.34; iqr > 0 && iqr < sd {
+		spread = iqr
+	}
+	h := 0.9 * spread * math.Pow(n, -0.2)
+	lo, hi := sorted[0], sorted[len(sorted)-1]
+	if h <= 0 {
+		return percentile(sorted, q)
+	}
+
+	cdf := func(x float64) float64 {
+		total := 0.0
+		for _, v := range sorted {
+			total += 0.5 * (1 + math.Erf((x-v)/(h*math.Sqrt2)))
+		}
+		return total / n
+	}
+	a, b := lo-4*h, hi+4*h
+	for i := 0; i < 60; i++ {
+		mid := (a + b) / 2
+		if cdf(mid) < q {
+			a = mid
+		} else {
+			b = mid
+		}
+	}
+	return math.Max(lo, math.Min(hi, (a+b)/2))
+}
+
+// envelopeSamples holds one species' native-region climate values per
+// envelope column
+type envelopeSamples struct {
+	TempMean, TempMin, TempMax                   []float64 // bio1_mean, bio1_min, bio1_max
+	PrecipMean, PrecipMin, PrecipMax, PrecipSeas []float64 // bio12_mean, bio12_min, bio12_max, bio15_mean
+	ColdMonth, WarmMonth                         []float64 // bio6_mean, bio5_mean
+	Regions                                      int
+}
+
+func (e *envelopeSamples) add(v []sql.NullFloat64) {
+	cols := []*[]float64{&e.TempMean, &e.TempMin, &e.TempMax, &e.PrecipMean, &e.PrecipMin, &e.PrecipMax,
+		&e.PrecipSeas, &e.ColdMonth, &e.WarmMonth}
+	for i, col := range cols {
+		if v[i].Valid {
+			*col = append(*col, v[i].Float64)
+		}
+	}
+	e.Regions++
+}
+
+type robustEnvelope struct {
+	climateEnvelope
+	WarmMonthMax *float64
+}
+
+// buildRobustEnvelope computes the envelope of samples with the lower and
+// upper quantiles of method in place of the minima and maxima
+func buildRobustEnvelope(samples envelopeSamples, method string, lower, upper float64) robustEnvelope {
+	mean := func(values []float64) *float64 {
+		if len(values) == 0 {
+			return nil
+		}
+		total := 0.0
+		for _, v := range values {
+			total += v
+		}
+		m := total / float64(len(values))
+		return &m
+	}
+	quantile := func(values []float64, q float64) *float64 {
+		if len(values) == 0 {
+			return nil
+		}
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		v := percentile(sorted, q)
+		if method == envelopeKDE {
+			v = kdeQuantile(sorted, q)
+		}
+		return &v
+	}
+	return robustEnvelope{
+		climateEnvelope: climateEnvelope{
+			TempMean:          mean(samples.TempMean),
+			TempMin:           quantile(samples.TempMin, lower),
+			TempMax:           quantile(samples.TempMax, upper),
+			PrecipMean:        mean(samples.PrecipMean),
+			PrecipMin:         quantile(samples.PrecipMin, lower),
+			PrecipMax:         quantile(samples.PrecipMax, upper),
+			PrecipSeasonality: mean(samples.PrecipSeas),
+			ColdMonthMin:      quantile(samples.ColdMonth, lower),
+		},
+		WarmMonthMax: quantile(samples.WarmMonth, upper),
+	}
+}
+
+// parseEnvelopeQuantiles reads the lower and upper query parameters
+func parseEnvelopeQuantiles(lowerParam, upperParam string) (float64, float64, error) {
+	lower, upper := defaultEnvelopeLower, defaultEnvelopeUpper
+	var err error
+	if lowerParam != "" {
+		if lower, err = strconv.ParseFloat(lowerParam, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid lower: %s", lowerParam)
+		}
+	}
+	if upperParam != "" {
+		if upper, err = strconv.ParseFloat(upperParam, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid upper: %s", upperParam)
+		}
+	}
+	if !(lower >= 0 && lower < 0.5 && upper > 0.5 && upper <= 1) {
+		return 0, 0, fmt.Errorf("lower must be within [0, 0.5) and upper within (0.5, 1]")
+	}
+	return lower, upper, nil
+}
+
+type RobustEnvelopeSet struct {
+	Method        string  `json:"method"`
+	Species       int64   `json:"species"`
+	LowerQuantile float64 `json:"lower_quantile"`
+	UpperQuantile float64 `json:"upper_quantile"`
+	UpdatedAt     *string `json:"updated_at"`
+}
+
+type RobustEnvelopeSummary struct {
+	Method        string  `json:"method"`
+	LowerQuantile float64 `json:"lower_quantile"`
+	UpperQuantile float64 `json:"upper_quantile"`
+	Built         int64   `json:"built"`
+	Skipped       int64   `json:"skipped"` // Fewer than minRobustEnvelopeRegions regions
+	Duration      string  `json:"duration"`
+	FinishedAt    string  `json:"finished_at"`
+}
+
+// parseEnvelopeRow reads a species ID and the climate values of one region
+// from a copyRows record into values
+func parseEnvelopeRow(record []string, values []sql.NullFloat64) (int64, error) {
+	if len(record) != len(values)+1 {
+		return 0, fmt.Errorf("envelope row has %d fields, want %d", len(record), len(values)+1)
+	}
+	id, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("envelope row: species_id %q", record[0])
+	}
+	for i, field := range record[1:] {
+		values[i] = sql.NullFloat64{}
+		if field == "" {
+			continue
+		}
+		if values[i].Float64, err = strconv.ParseFloat(field, 64); err != nil {
+			return 0, fmt.Errorf("envelope row: value %q", field)
+		}
+		values[i].Valid = true
+	}
+	return id, nil
+}
+
+// rebuildRobustEnvelopes replaces the robust envelopes of method in one
+// transaction, from the native regions behind species_climate_envelope.
+// Both sides are bulk: the regions are read with copyRows and the
+// envelopes written with COPY FROM.
+func (s *Server) rebuildRobustEnvelopes(ctx context.Context, method string, lower, upper float64) (*RobustEnvelopeSummary, error) {
+	if !s.envelopeMu.TryLock() {
+		return nil, errEnvelopeRebuildRunning
+	}
+	defer s.envelopeMu.Unlock()
+
+	start := time.Now()
+	summary := &RobustEnvelopeSummary{Method: method, LowerQuantile: lower, UpperQuantile: upper}
+
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM species_climate_envelope_robust WHERE method = $1`, method); err != nil {
+		return nil, err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("species_climate_envelope_robust",
+		"species_id", "method", "temp_mean", "temp_min", "temp_max",
+		"precip_mean", "precip_min", "precip_max", "precip_seasonality", "cold_month_min", "warm_month_max",
+		"lower_quantile", "upper_quantile", "n_regions_sampled"))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	flush := func(id int64, samples envelopeSamples) error {
+		if samples.Regions < minRobustEnvelopeRegions {
+			summary.Skipped++
+			return nil
+		}
+		env := buildRobustEnvelope(samples, method, lower, upper)
+		_, err := stmt.ExecContext(ctx, id, method, env.TempMean, env.TempMin, env.TempMax,
+			env.PrecipMean, env.PrecipMin, env.PrecipMax, env.PrecipSeasonality, env.ColdMonthMin, env.WarmMonthMax,
+			lower, upper, samples.Regions)
+		if err == nil {
+			summary.Built++
+		}
+		return err
+	}
+
+	var current int64
+	var samples envelopeSamples
+	values := make([]sql.NullFloat64, 9)
+	// Same regions as migration 009, one row per species and region
+	err = s.copyRows(ctx, `
+		SELECT DISTINCT ON (sr.species_id, sr.tdwg_code) sr.species_id,
+		       c.bio1_mean, c.bio1_min, c.bio1_max, c.bio12_mean, c.bio12_min, c.bio12_max,
+		       c.bio15_mean, c.bio6_mean, c.bio5_mean
+		FROM species_climate_envelope e
+		JOIN species_regions sr ON sr.species_id = e.species_id AND sr.is_native = TRUE
+		JOIN tdwg_climate c ON c.tdwg_code = sr.tdwg_code
+		WHERE c.bio1_mean IS NOT NULL
+		ORDER BY sr.species_id, sr.tdwg_code
+	`, func(record []string) error {
+		id, err := parseEnvelopeRow(record, values)
+		if err != nil {
+			return err
+		}
+		if id != current && current != 0 {
+			if err := flush(current, samples); err != nil {
+				return err
+			}
+			samples = envelopeSamples{}
+		}
+		current = id
+		samples.add(values)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if current != 0 {
+		if err := flush(current, samples); err != nil {
+			return nil, err
+		}
+	}
+	// An argument-less Exec ends the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	summary.Duration = time.Since(start).String()
+	summary.FinishedAt = time.Now().Format(time.RFC3339)
+	s.log.Printf("Rebuilt %s climate envelopes (%g-%g): %d species, %d skipped", method, lower, upper, summary.Built, summary.Skipped)
+	return summary, nil
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleRobustEnvelopes handles GET/POST /api/admin/envelopes
+func (s *Server) handleRobustEnvelopes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT method, COUNT(*), MIN(lower_quantile), MIN(upper_quantile),
+			       TO_CHAR(MAX(updated_at), 'YYYY-MM-DD"T"HH24:MI:SS')
+			FROM species_climate_envelope_robust
+			GROUP BY method
+			ORDER BY method
+		`)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		sets := []RobustEnvelopeSet{}
+		for rows.Next() {
+			var set RobustEnvelopeSet
+			if err := rows.Scan(&set.Method, &set.Species, &set.LowerQuantile, &set.UpperQuantile, &set.UpdatedAt); err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+				return
+			}
+			sets = append(sets, set)
+		}
+		var minmax int64
+		s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM species_climate_envelope`).Scan(&minmax)
+		json.NewEncoder(w).Encode(map[string]interface{}{"minmax_species": minmax, "envelopes": sets})
+
+	case http.MethodPost:
+		q := r.URL.Query()
+		method, err := parseEnvelopeMode(q.Get("method"))
+		if err == nil && method == "" {
+			err = fmt.Errorf("method must be %s or %s", envelopePercentile, envelopeKDE)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		lower, upper, err := parseEnvelopeQuantiles(q.Get("lower"), q.Get("upper"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		summary, err := s.rebuildRobustEnvelopes(ctx, method, lower, upper)
+		if errors.Is(err, errEnvelopeRebuildRunning) {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(summary)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseEnvelopeMode(t *testing.T) {
+	for in, want := range map[string]string{"": "", "minmax": "", "percentile": "percentile", "kde": "kde"} {
+		if got, err := parseEnvelopeMode(in); err != nil || got != want {
+			t.Errorf("%q: got %q, %v", in, got, err)
+		}
+	}
+	if _, err := parseEnvelopeMode("median"); err == nil {
+		t.Error("median accepted")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	for q, want := range map[float64]float64{0: 1, 0.05: 1.5, 0.5: 6, 0.95: 10.5, 1: 11} {
+		if got := percentile(sorted, q); math.Abs(got-want) > 1e-9 {
+			t.Errorf("q=%g: got %g, want %g", q, got, want)
+		}
+	}
+	if got := percentile([]float64{7}, 0.05); got != 7 {
+		t.Errorf("single value: got %g", got)
+	}
+}
+
+func TestKDEQuantile(t *testing.T) {
+	sorted := []float64{10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	lo, hi := kdeQuantile(sorted, 0.05), kdeQuantile(sorted, 0.95)
+	if lo < 10 || lo > 12 || hi < 18 || hi > 20 {
+		t.Errorf("5-95%%: got %g-%g", lo, hi)
+	}
+	if median := kdeQuantile(sorted, 0.5); math.Abs(median-15) > 1e-6 {
+		t.Errorf("median of a symmetric sample: got %g", median)
+	}
+	if got := kdeQuantile([]float64{4, 4, 4}, 0.05); got != 4 {
+		t.Errorf("constant sample: got %g", got)
+	}
+}
+
+func TestBuildRobustEnvelope(t *testing.T) {
+	// 29 regions around 21 °C and one erroneous record in a cold region
+	var samples envelopeSamples
+	records := []float64{-5}
+	for i := 0; i < 29; i++ {
+		records = append(records, 19+float64(i%5))
+	}
+	for _, bio1 := range records {
+		samples.TempMean = append(samples.TempMean, bio1)
+		samples.TempMin = append(samples.TempMin, bio1-4)
+		samples.TempMax = append(samples.TempMax, bio1+4)
+		samples.ColdMonth = append(samples.ColdMonth, bio1-8)
+		samples.Regions++
+	}
+
+	for _, method := range []string{envelopePercentile, envelopeKDE} {
+		env := buildRobustEnvelope(samples, method, 0.05, 0.95)
+		if *env.TempMin < 10 || *env.ColdMonthMin < 6 {
+			t.Errorf("%s: the outlier still sets temp_min %g, cold_month_min %g", method, *env.TempMin, *env.ColdMonthMin)
+		}
+		if *env.TempMax > 27 {
+			t.Errorf("%s: temp_max %g above the largest record", method, *env.TempMax)
+		}
+		if want := (-5 + 19*6 + 20*6 + 21*6 + 22*6 + 23*5) / 30.0; math.Abs(*env.TempMean-want) > 1e-9 {
+			t.Errorf("%s: temp_mean %g, want the plain average %g", method, *env.TempMean, want)
+		}
+		if env.PrecipMean != nil || env.PrecipMin != nil || env.WarmMonthMax != nil {
+			t.Errorf("%s: columns without samples should be nil", method)
+		}
+	}
+}
+
+func TestParseEnvelopeQuantiles(t *testing.T) {
+	if lower, upper, err := parseEnvelopeQuantiles("", ""); err != nil || lower != 0.05 || upper != 0.95 {
+		t.Errorf("defaults: %g, %g, %v", lower, upper, err)
+	}
+	if lower, upper, err := parseEnvelopeQuantiles("0.1", "0.9"); err != nil || lower != 0.1 || upper != 0.9 {
+		t.Errorf("0.1-0.9: %g, %g, %v", lower, upper, err)
+	}
+	for _, tc := range [][2]string{{"x", ""}, {"0.6", ""}, {"", "0.5"}, {"-0.1", ""}, {"", "1.5"}} {
+		if _, _, err := parseEnvelopeQuantiles(tc[0], tc[1]); err == nil {
+			t.Errorf("lower=%s upper=%s accepted", tc[0], tc[1])
+		}
+	}
+}
+
+func TestParseEnvelopeRow(t *testing.T) {
+	values := make([]sql.NullFloat64, 9)
+	values[1] = sql.NullFloat64{Float64: 3, Valid: true} // Reset by the next row
+	id, err := parseEnvelopeRow([]string{"42", "21.5", "", "30", "1400", "900", "2100", "35", "12.25", "-1e1"}, values)
+	if err != nil || id != 42 {
+		t.Fatalf("id %d, %v", id, err)
+	}
+	if !values[0].Valid || values[0].Float64 != 21.5 || values[1].Valid || values[7].Float64 != 12.25 || values[8].Float64 != -10 {
+		t.Errorf("values %+v", values)
+	}
+	for _, record := range [][]string{{"42", "1"}, {"x", "", "", "", "", "", "", "", "", ""}, {"42", "a", "", "", "", "", "", "", "", ""}} {
+		if _, err := parseEnvelopeRow(record, values); err == nil {
+			t.Errorf("%v accepted", record)
+		}
+	}
+}
+
+func TestRobustEnvelopeValidation(t *testing.T) {
+	s := newTestServer()
+	for _, tc := range []struct {
+		method, path string
+		handler      http.HandlerFunc
+		want         int
+	}{
+		{http.MethodPost, "/api/admin/envelopes?method=kde", s.handleRobustEnvelopes, http.StatusUnauthorized},
+		{http.MethodGet, "/api/climate/match?species_id=1&tdwg_code=BZL&envelope_mode=median", s.handleClimateMatch, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+
+	req := RecommendRequest{EnvelopeMode: "max"}
+	if err := validateEnvelopeMode(&req); err == nil {
+		t.Error("envelope_mode max accepted")
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// ROUTER
+// ============================================================================
+//
+// routes() serves routeRegistry through this router instead of a bare
+// ServeMux, so the method lists of the registry are enforced in one place:
+//
+//   - A method a route does not list is answered 405 with an Allow header,
+//     before the handler runs.
+//   - HEAD is served by the GET handler, with the body discarded; OPTIONS is
+//     answered with Allow (and the CORS headers) without reaching the
+//     rate limits, as corsMiddleware used to.
+//   - Patterns may have {name} segments, each matching one non-empty path
+//     segment, read by the handler with pathParam(r, "name"); "{name}.ext"
+//     matches a segment ending in .ext and captures the rest. Exact patterns
+//     win over parameter patterns, which win over subtrees ("/api/species/"),
+//     longest first. Among parameter patterns, the first segment that
+//     differs decides: a literal beats "{name}.ext", which beats "{name}".
+//   - Fallback subtrees ("/" and "/api/") only match a path that no other
+//     route matches, even after the trailing-slash policy below.
+//   - A path that differs from a route only by its trailing slash is
+//     redirected to it (308, method and body kept), served as it, or left
+//     unmatched, by TRAILING_SLASH (redirect, strip or strict).
+//
+// Paths with "." or ".." segments or repeated slashes are redirected to the
+// clean path, as ServeMux did.
+
+const (
+	trailingSlashRedirect = "redirect"
+	trailingSlashStrip    = "strip"
+	trailingSlashStrict   = "strict"
+)
+
+func loadTrailingSlash() string {
+	switch value := getEnv("TRAILING_SLASH", trailingSlashRedirect); value {
+	case trailingSlashRedirect, trailingSlashStrip, trailingSlashStrict:
+		return value
+	default:
+		log.Printf("Invalid TRAILING_SLASH=%q, using %s", value, trailingSlashRedirect)
+		return trailingSlashRedirect
+	}
+}
+
+type routerEntry struct {
+	pattern  string
+	segments []string // Of parameter patterns
+	methods  []string // "*" for any
+	handler  http.Handler
+	fallback bool
+}
+
+type router struct {
+	exact         map[string]*routerEntry
+	params        []*routerEntry
+	subtrees      []*routerEntry // Longest first
+	trailingSlash string
+}
+
+func newRouter(trailingSlash string) *router {
+	return &router{exact: map[string]*routerEntry{}, trailingSlash: trailingSlash}
+}
+
+// Handle registers h for pattern and methods; registering a pattern twice
+// panics, as with ServeMux
+func (rt *router) Handle(pattern string, methods []string, h http.Handler) {
+	rt.handle(&routerEntry{pattern: pattern, methods: methods, handler: h, fallback: pattern == "/"})
+}
+
+// HandleFallback registers h for the subtree pattern as a fallback
+func (rt *router) HandleFallback(pattern string, methods []string, h http.Handler) {
+	if !strings.HasSuffix(pattern, "/") {
+		panic("router: fallback " + pattern + " is not a subtree")
+	}
+	rt.handle(&routerEntry{pattern: pattern, methods: methods, handler: h, fallback: true})
+}
+
+func (rt *router) handle(e *routerEntry) {
+	pattern := e.pattern
+	switch {
+	case strings.Contains(pattern, "{"):
+		for _, p := range rt.params {
+			if p.pattern == pattern {
+				panic("router: multiple registrations for " + pattern)
+			}
+		}
+		e.segments = strings.Split(strings.Trim(pattern, "/"), "/")
+		rt.params = append(rt.params, e)
+		sort.SliceStable(rt.params, func(i, j int) bool { return moreSpecific(rt.params[i].segments, rt.params[j].segments) })
+	case strings.HasSuffix(pattern, "/"):
+		for _, p := range rt.subtrees {
+			if p.pattern == pattern {
+				panic("router: multiple registrations for " + pattern)
+			}
+		}
+		rt.subtrees = append(rt.subtrees, e)
+		sort.SliceStable(rt.subtrees, func(i, j int) bool { return len(rt.subtrees[i].pattern) > len(rt.subtrees[j].pattern) })
+	default:
+		if _, ok := rt.exact[pattern]; ok {
+			panic("router: multiple registrations for " + pattern)
+		}
+		rt.exact[pattern] = e
+	}
+}
+
+// segmentRank orders pattern segments: literal, "{name}.ext", "{name}"
+func segmentRank(seg string) int {
+	if !strings.HasPrefix(seg, "{") {
+		return 2
+	}
+	if !strings.HasSuffix(seg, "}") {
+		return 1
+	}
+	return 0
+}
+
+// moreSpecific reports whether parameter pattern a takes precedence over b
+func moreSpecific(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if ra, rb := segmentRank(a[i]), segmentRank(b[i]); ra != rb {
+			return ra > rb
+		}
+	}
+	return false
+}
+
+// matchPattern matches path against a parameter pattern, returning the
+// values of its {name} segments
+func matchPattern(pattern, path string) (map[string]string, bool) {
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	return matchSegments(segments, path)
+}
+
+func matchSegments(segments []string, path string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != len(segments) || !strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+		return nil, false
+	}
+	var params map[string]string
+	for i, seg := range segments {
+		name, ok := strings.CutPrefix(seg, "{")
+		if !ok {
+			if seg != parts[i] {
+				return nil, false
+			}
+			continue
+		}
+		name, ext, _ := strings.Cut(name, "}")
+		value, ok := strings.CutSuffix(parts[i], ext)
+		if !ok || value == "" {
+			return nil, false
+		}
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[name] = value
+	}
+	return params, true
+}
+
+// lookupRoute finds the exact or parameter route of path
+func (rt *router) lookupRoute(path string) (*routerEntry, map[string]string) {
+	if e, ok := rt.exact[path]; ok {
+		return e, nil
+	}
+	for _, e := range rt.params {
+		if params, ok := matchSegments(e.segments, path); ok {
+			return e, params
+		}
+	}
+	return nil, nil
+}
+
+func (rt *router) lookupSubtree(path string) *routerEntry {
+	for _, e := range rt.subtrees {
+		if strings.HasPrefix(path, e.pattern) {
+			return e
+		}
+	}
+	return nil
+}
+
+// match finds the route of path; redirect is set when the trailing-slash
+// policy sends the client to another path instead
+func (rt *router) match(path string) (e *routerEntry, params map[string]string, redirect string) {
+	if e, params := rt.lookupRoute(path); e != nil {
+		return e, params, ""
+	}
+	subtree := rt.lookupSubtree(path)
+	if subtree != nil && !subtree.fallback {
+		return subtree, nil, ""
+	}
+
+	// Only a fallback is left: try the path with its slash toggled
+	var alt *routerEntry
+	var altPath string
+	if trimmed := strings.TrimSuffix(path, "/"); trimmed != path && trimmed != "" {
+		altPath = trimmed
+		alt, params = rt.lookupRoute(trimmed)
+	} else if s := rt.lookupSubtree(path + "/"); s != nil && s.pattern == path+"/" && !s.fallback {
+		altPath, alt = path+"/", s
+	}
+	if alt != nil {
+		switch rt.trailingSlash {
+		case trailingSlashRedirect:
+			return nil, nil, altPath
+		case trailingSlashStrip:
+			return alt, params, ""
+		}
+	}
+	return subtree, nil, ""
+}
+
+// allows reports whether e serves method; GET routes also serve HEAD
+func (e *routerEntry) allows(method string) bool {
+	for _, m := range e.methods {
+		if m == "*" || m == method || (m == http.MethodGet && method == http.MethodHead) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow is the Allow header of e, or "" for routes of any method
+func (e *routerEntry) allow() string {
+	var methods []string
+	for _, m := range e.methods {
+		if m == "*" {
+			return ""
+		}
+		methods = append(methods, m)
+		if m == http.MethodGet && !e.listed(http.MethodHead) {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	if !e.listed(http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
+	return strings.Join(methods, ", ")
+}
+
+func (e *routerEntry) listed(method string) bool {
+	for _, m := range e.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanPath is p without "." and ".." segments or repeated slashes,
+// keeping its trailing slash
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if cleaned := cleanPath(r.URL.Path); cleaned != r.URL.Path {
+		redirectPath(w, r, cleaned, http.StatusMovedPermanently)
+		return
+	}
+
+	e, params, redirect := rt.match(r.URL.Path)
+	if redirect != "" {
+		redirectPath(w, r, redirect, http.StatusPermanentRedirect)
+		return
+	}
+	if e == nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodOptions && !e.listed(http.MethodOptions) {
+		if allow := e.allow(); allow != "" {
+			w.Header().Set("Allow", allow)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !e.allows(r.Method) {
+		w.Header().Set("Allow", e.allow())
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if params != nil {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+	}
+	if r.Method == http.MethodHead && !e.listed(http.MethodHead) && !e.listed("*") {
+		get := *r
+		get.Method = http.MethodGet
+		e.handler.ServeHTTP(headResponseWriter{w}, &get)
+		return
+	}
+	e.handler.ServeHTTP(w, r)
+}
+
+// preflight answers OPTIONS requests with rt ahead of next, so they skip
+// the rate limits and load shedding
+func (rt *router) preflight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			rt.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func redirectPath(w http.ResponseWriter, r *http.Request, p string, code int) {
+	u := *r.URL
+	u.Path = p
+	http.Redirect(w, r, u.String(), code)
+}
+
+// headResponseWriter drops the body a GET handler writes for a HEAD request
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+type pathParamsKey struct{}
+
+// pathParam is the value of the {name} segment of the route pattern
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+// patternCovers reports whether a subtree or parameter pattern matches
+// path, for the per-endpoint settings keyed by pattern
+func patternCovers(pattern, path string) bool {
+	if strings.Contains(pattern, "{") {
+		_, ok := matchPattern(pattern, path)
+		return ok
+	}
+	return strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testRouter registers handlers that echo their pattern and {id}
+func testRouter(trailingSlash string) *router {
+	rt := newRouter(trailingSlash)
+	for pattern, methods := range map[string][]string{
+		"/":                   {http.MethodGet, http.MethodHead},
+		"/api/items":          {http.MethodGet, http.MethodPost},
+		"/api/items/search":   {http.MethodGet},
+		"/api/items/{id}":     {http.MethodGet, http.MethodDelete},
+		"/api/items/":         {http.MethodGet},
+		"/api/proxy/":         {"*"},
+		"/api/jobs/{id}/logs": {http.MethodGet},
+	} {
+		pattern := pattern
+		rt.Handle(pattern, methods, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s %s", r.Method, pattern, pathParam(r, "id"))
+		}))
+	}
+	return rt
+}
+
+func TestRouterMatch(t *testing.T) {
+	rt := testRouter(trailingSlashRedirect)
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/api/items", 200, "GET /api/items "},
+		{"GET", "/api/items/search", 200, "GET /api/items/search "},
+		{"DELETE", "/api/items/42", 200, "DELETE /api/items/{id} 42"},
+		{"GET", "/api/items/42/photos", 200, "GET /api/items/ "},
+		{"GET", "/api/jobs/abc/logs", 200, "GET /api/jobs/{id}/logs abc"},
+		{"PATCH", "/api/proxy/x", 200, "PATCH /api/proxy/ "},
+		{"GET", "/static.css", 200, "GET / "},
+		{"HEAD", "/api/items", 200, ""},
+		{"PUT", "/api/items", 405, ""},
+		{"POST", "/api/items/42", 405, ""},
+		{"GET", "/api/jobs//logs", 301, ""},
+		{"GET", "/api/items/../items", 301, ""},
+	} {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.code || (tc.body != "" && rec.Body.String() != tc.body) {
+			t.Errorf("%s %s: got %d %q, want %d %q", tc.method, tc.path, rec.Code, rec.Body.String(), tc.code, tc.body)
+		}
+	}
+}
+
+func TestRouterMethods(t *testing.T) {
+	rt := testRouter(trailingSlashRedirect)
+
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest("PUT", "/api/items/42", nil))
+	if got := rec.Header().Get("Allow"); rec.Code != 405 || got != "GET, HEAD, DELETE, OPTIONS" {
+		t.Errorf("405: got %d with Allow %q", rec.Code, got)
+	}
+
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/api/items", nil))
+	if got := rec.Header().Get("Allow"); rec.Code != 200 || got != "GET, HEAD, POST, OPTIONS" || rec.Body.Len() != 0 {
+		t.Errorf("OPTIONS: got %d with Allow %q and body %q", rec.Code, got, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest("HEAD", "/api/items/search", nil))
+	if rec.Code != 200 || rec.Body.Len() != 0 {
+		t.Errorf("HEAD: got %d with body %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/api/proxy/x", nil))
+	if rec.Code != 200 || rec.Header().Get("Allow") != "" {
+		t.Errorf("OPTIONS on any-method route: got %d with Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestRouterTrailingSlash(t *testing.T) {
+	for _, tc := range []struct {
+		policy, path string
+		code         int
+		location     string
+	}{
+		{trailingSlashRedirect, "/api/items/search/", 200, ""}, // Claimed by the /api/items/ subtree
+		{trailingSlashRedirect, "/api/jobs/abc/logs/", 308, "/api/jobs/abc/logs?x=1"},
+		{trailingSlashRedirect, "/api/proxy", 308, "/api/proxy/?x=1"},
+		{trailingSlashStrip, "/api/jobs/abc/logs/", 200, ""},
+		{trailingSlashStrip, "/api/proxy", 200, ""},
+		{trailingSlashStrict, "/api/jobs/abc/logs/", 200, ""}, // Left to the catch-all
+		{trailingSlashStrict, "/api/missing/", 200, ""},
+	} {
+		rec := httptest.NewRecorder()
+		testRouter(tc.policy).ServeHTTP(rec, httptest.NewRequest("GET", tc.path+"?x=1", nil))
+		if rec.Code != tc.code || rec.Header().Get("Location") != tc.location {
+			t.Errorf("%s %s: got %d to %q, want %d to %q", tc.policy, tc.path, rec.Code, rec.Header().Get("Location"), tc.code, tc.location)
+		}
+		if tc.policy == trailingSlashStrict && rec.Body.String() != "GET / " {
+			t.Errorf("strict %s: served by %q", tc.path, rec.Body.String())
+		}
+	}
+
+	rt := newRouter(trailingSlashRedirect)
+	rt.Handle("/api/only", []string{http.MethodGet}, http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest("GET", "/api/other", nil))
+	if rec.Code != 404 {
+		t.Errorf("unmatched without catch-all: got %d", rec.Code)
+	}
+}
+
+func TestRouterParamPrecedence(t *testing.T) {
+	rt := newRouter(trailingSlashRedirect)
+	for _, pattern := range []string{
+		"/api/exports/{id}",
+		"/api/exports/{id}.parquet",
+		"/api/exports/latest.parquet",
+		"/api/curation/{type}/{id}/accept",
+		"/api/curation/flags/{id}/accept",
+	} {
+		pattern := pattern
+		rt.Handle(pattern, []string{http.MethodGet}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s %s", pattern, pathParam(r, "type"), pathParam(r, "id"))
+		}))
+	}
+	for _, tc := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/api/exports/ab12", 200, "/api/exports/{id}  ab12"},
+		{"/api/exports/ab12.parquet", 200, "/api/exports/{id}.parquet  ab12"},
+		{"/api/exports/ab12.csv", 200, "/api/exports/{id}  ab12.csv"},
+		{"/api/exports/latest.parquet", 200, "/api/exports/latest.parquet  "},
+		{"/api/exports/.parquet", 200, "/api/exports/{id}  .parquet"}, // No empty {id}
+		{"/api/curation/flags/7/accept", 200, "/api/curation/flags/{id}/accept  7"},
+		{"/api/curation/names/7/accept", 200, "/api/curation/{type}/{id}/accept names 7"},
+		{"/api/curation/names/7/reject", 404, ""},
+	} {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.code || (tc.body != "" && rec.Body.String() != tc.body) {
+			t.Errorf("%s: got %d %q, want %d %q", tc.path, rec.Code, rec.Body.String(), tc.code, tc.body)
+		}
+	}
+}
+
+func TestRouterFallback(t *testing.T) {
+	rt := testRouter(trailingSlashRedirect)
+	rt.HandleFallback("/api/", []string{"*"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s fallback", r.Method)
+	}))
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"POST", "/api/missing", 200, "POST fallback"},
+		{"GET", "/api/items/42", 200, "GET /api/items/{id} 42"},
+		{"GET", "/api/items/42/photos", 200, "GET /api/items/ "}, // Ordinary subtrees still win
+		{"GET", "/api/jobs/abc/logs/", 308, ""},
+		{"GET", "/api/proxy", 308, ""},
+		{"GET", "/static.css", 200, "GET / "},
+	} {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.code || (tc.body != "" && rec.Body.String() != tc.body) {
+			t.Errorf("%s %s: got %d %q, want %d %q", tc.method, tc.path, rec.Code, rec.Body.String(), tc.code, tc.body)
+		}
+	}
+}
+
+func TestRoutesUnknownAPIPath(t *testing.T) {
+	h := newTestServer().router()
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/api/no-such-endpoint", nil))
+		if rec.Code != http.StatusNotFound || !json.Valid(rec.Body.Bytes()) {
+			t.Errorf("%s: got %d %q", method, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestPatternCovers(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/api/query/jobs/{id}", "/api/query/jobs/abc", true},
+		{"/api/query/jobs/{id}", "/api/query/jobs/abc/x", false},
+		{"/api/query/jobs/{id}", "/api/query/jobs/", false},
+		{"/api/species/", "/api/species/42", true},
+		{"/api/species", "/api/species", false}, // Exact patterns are looked up directly
+	} {
+		if got := patternCovers(tc.pattern, tc.path); got != tc.want {
+			t.Errorf("patternCovers(%q, %q) = %v", tc.pattern, tc.path, got)
+		}
+	}
+}
+
+func TestLoadTrailingSlash(t *testing.T) {
+	t.Setenv("TRAILING_SLASH", "strip")
+	if got := loadTrailingSlash(); got != trailingSlashStrip {
+		t.Errorf("got %q", got)
+	}
+	t.Setenv("TRAILING_SLASH", "sometimes")
+	if got := loadTrailingSlash(); got != trailingSlashRedirect {
+		t.Errorf("invalid value: got %q", got)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ROUTE REGISTRY
+// ============================================================================
+//
+// Every endpoint is declared once in routeRegistry: its pattern, methods,
+// auth, stability and the per-route middleware defaults (statement timeout
+// and rate limit, still overridable by STATEMENT_TIMEOUTS and RATE_LIMITS).
+// routes() builds the router from it and GET /api/routes publishes it with the
+// limits in effect, so clients can discover the API without the README.
+//
+// Auth is what the handler checks, for documentation: none, optional (a key
+// is used when sent, e.g. for the query audit), or the least role; WriteAuth
+// is the role of the other methods when GET needs less. Stability is stable,
+// beta (new, the response may still change) or internal (operations and
+// the dashboard itself, no compatibility promise).
+
+const (
+	authNone     = "none"
+	authOptional = "optional"
+
+	stabilityStable   = "stable"
+	stabilityBeta     = "beta"
+	stabilityInternal = "internal"
+)
+
+// route is one registry entry; exactly one of handle and handler is set
+type route struct {
+	Pattern   string
+	Methods   []string // "*" for any
+	Auth      string   // Default: authNone
+	WriteAuth string   // Non-GET methods, when stricter than Auth
+	Tenant    bool     // Operates on the caller's tenant
+	Stability string   // Default: stabilityStable
+
+	Timeout   time.Duration // Default statement timeout (see timeout.go)
+	RateLimit string        // Default rate limit, with its own bucket (see ratelimit.go)
+	Fallback  bool          // Subtree matched only when no other route is (see router.go)
+
+	handle  func(*Server, http.ResponseWriter, *http.Request)
+	handler func(*Server) http.Handler
+}
+
+// routeRegistry lists the endpoints; more specific patterns need not come
+// first (see router.go for the precedence)
+func routeRegistry() []route {
+	var (
+		get       = []string{http.MethodGet}
+		getHead   = []string{http.MethodGet, http.MethodHead}
+		post      = []string{http.MethodPost}
+		getPost   = []string{http.MethodGet, http.MethodPost}
+		getDelete = []string{http.MethodGet, http.MethodDelete}
+	)
+	return []route{
+		// Dashboard proxy, status page, tiles and static files
+		{Pattern: "/diversiplant/", Methods: []string{"*"}, Stability: stabilityInternal, handler: (*Server).newDashboardProxy},
+		{Pattern: "/status", Methods: getHead, Stability: stabilityInternal, handle: (*Server).handleStatusPage},
+		{Pattern: "/tiles/", Methods: getHead, Stability: stabilityBeta,
+			handler: func(s *Server) http.Handler { return s.tileHandler(s.cfg.Tiles) }},
+		{Pattern: "/", Methods: getHead, Fallback: true, Stability: stabilityInternal,
+			handler: func(*Server) http.Handler { return http.FileServer(http.Dir("static")) }},
+
+		// Service; unknown /api/ paths answer a JSON 404 instead of reaching
+		// the static files
+		{Pattern: "/api/", Methods: []string{"*"}, Fallback: true, Stability: stabilityInternal, handle: (*Server).handleAPINotFound},
+		{Pattern: "/api/health", Methods: get, handle: (*Server).handleHealth},
+		{Pattern: "/api/stats", Methods: get, handle: (*Server).handleStats},
+		{Pattern: "/api/routes", Methods: get, Stability: stabilityBeta, handle: (*Server).handleRoutes},
+		{Pattern: "/api/schemas", Methods: get, Stability: stabilityBeta, handle: (*Server).handleSchemas},
+		{Pattern: "/api/schemas/{name}", Methods: get, Stability: stabilityBeta, handle: (*Server).handleSchemas},
+		{Pattern: "/api/demo", Methods: get, handle: (*Server).handleDemo},
+		{Pattern: publicPrefix, Methods: getHead,
+			handler: func(s *Server) http.Handler { return s.publicHandler(s.cfg.Public) }},
+		{Pattern: "/api/dataset", Methods: get, handle: (*Server).handleDataset},
+		{Pattern: "/api/dataset/{version}", Methods: get, handle: (*Server).handleDataset},
+
+		// Regions and species
+		{Pattern: "/api/tdwg", Methods: getPost, handle: (*Server).handleTDWG},
+		{Pattern: "/api/tdwg/{code}/bounds", Methods: get, handle: (*Server).handleTDWGBounds},
+		{Pattern: "/api/tdwg.geojson", Methods: get, Stability: stabilityBeta, handle: (*Server).handleTDWGGeoJSON},
+		{Pattern: "/api/tdwg/{code}.geojson", Methods: get, Stability: stabilityBeta, handle: (*Server).handleTDWGRegionGeoJSON},
+		{Pattern: "/api/admin-units", Methods: get, Stability: stabilityBeta, handle: (*Server).handleAdminUnits},
+		{Pattern: "/api/tdwg/level1", Methods: get, Stability: stabilityBeta, handle: (*Server).handleTDWGLevel1},
+		{Pattern: "/api/tdwg/level1/{code}", Methods: get, Stability: stabilityBeta, handle: (*Server).handleTDWGLevel1Unit},
+		{Pattern: "/api/tdwg/level2", Methods: get, Stability: stabilityBeta, handle: (*Server).handleTDWGLevel2},
+		{Pattern: "/api/tdwg/level2/{code}", Methods: get, Stability: stabilityBeta, handle: (*Server).handleTDWGLevel2Unit},
+		{Pattern: "/api/species", Methods: get, handle: (*Server).handleSpecies},
+		{Pattern: "/api/species/search", Methods: get, handle: (*Server).handleSpeciesSearch},
+		{Pattern: "/api/species/within", Methods: getPost, Stability: stabilityBeta, handle: (*Server).handleSpeciesWithin},
+		{Pattern: "/api/species/remaps", Methods: get, handle: (*Server).handleSpeciesRemaps},
+		{Pattern: "/api/species/{id}", Methods: get, handle: speciesItem((*Server).handleSpeciesDetail)},
+		{Pattern: "/api/species/{id}/envelope", Methods: get, handle: speciesItem((*Server).handleSpeciesEnvelope)},
+		{Pattern: "/api/species/{id}/suitability-map", Methods: get, handle: speciesItem((*Server).handleSpeciesSuitabilityMap)},
+		{Pattern: "/api/taxa/families", Methods: get, handle: (*Server).handleTaxaFamilies},
+		{Pattern: "/api/taxa/families/{family}/genera", Methods: get, handle: (*Server).handleTaxaGenera},
+		{Pattern: "/api/taxa/families/{family}/genera/{genus}/species", Methods: get, handle: (*Server).handleTaxaSpecies},
+		{Pattern: "/api/export/{name}", Methods: get, Auth: roleUser, Timeout: 10 * time.Minute, handle: (*Server).handleExport},
+		{Pattern: "/api/export/spatial", Methods: getPost, Auth: roleUser, Stability: stabilityBeta, handle: (*Server).handleSpatialExports},
+		{Pattern: "/api/export/spatial/{id}", Methods: getDelete, Auth: roleUser, Stability: stabilityBeta, handle: (*Server).handleSpatialExport},
+		{Pattern: "/api/export/spatial/{id}.parquet", Methods: get, Auth: roleUser, Stability: stabilityBeta,
+			handle: (*Server).handleSpatialExportDownload},
+
+		// SQL queries
+		{Pattern: "/api/query", Methods: post, Auth: authOptional, RateLimit: "30/m", handle: (*Server).handleQuery},
+		{Pattern: "/api/query/async", Methods: post, Auth: authOptional, RateLimit: "10/m", handle: (*Server).handleQueryAsync},
+		{Pattern: "/api/query/history", Methods: get, Auth: roleUser, handle: (*Server).handleQueryHistory},
+		{Pattern: "/api/query/explain", Methods: post, handle: (*Server).handleQueryExplain},
+		{Pattern: "/api/query/jobs/{id}", Methods: getDelete, handle: (*Server).handleQueryJob},
+		{Pattern: "/api/queries", Methods: getPost, Auth: roleUser, handle: (*Server).handleSavedQueries},
+		{Pattern: "/api/queries/{id}", Methods: []string{http.MethodGet, http.MethodPut, http.MethodDelete},
+			Auth: roleUser, RateLimit: "30/m", handle: (*Server).handleSavedQuery},
+		{Pattern: "/api/queries/{id}/run", Methods: post, Auth: roleUser, RateLimit: "30/m", handle: (*Server).handleSavedQueryRun},
+		{Pattern: "/api/queries/{id}/execute", Methods: post, Auth: roleUser, RateLimit: "30/m", handle: (*Server).handleSavedQueryRun},
+		{Pattern: "/api/sources", Methods: get, handle: (*Server).handleSources},
+		{Pattern: "/api/sources/{name}/species", Methods: get, handle: sourceItem((*Server).handleSourceSpecies)},
+		{Pattern: "/api/sources/{name}/coverage", Methods: get, handle: sourceItem((*Server).handleSourceCoverage)},
+
+		// Site data
+		{Pattern: "/api/climate", Methods: get, handle: (*Server).handleClimate},
+		{Pattern: "/api/climate/stats", Methods: get, handle: (*Server).handleClimateStats},
+		{Pattern: "/api/climate/species", Methods: get, handle: (*Server).handleClimateSpecies},
+		{Pattern: "/api/climate/point", Methods: get, handle: (*Server).handleClimatePoint},
+		{Pattern: "/api/climate/match", Methods: get, handle: (*Server).handleClimateMatch},
+		{Pattern: "/api/climate/analogs", Methods: get, handle: (*Server).handleClimateAnalogs},
+		{Pattern: "/api/elevation", Methods: get, handle: (*Server).handleElevation},
+		{Pattern: "/api/soil", Methods: get, handle: (*Server).handleSoil},
+		{Pattern: "/api/i18n/names", Methods: get, handle: (*Server).handleLocalizedNames},
+		{Pattern: "/api/names/resolve", Methods: getPost, handle: (*Server).handleNamesResolve},
+		{Pattern: "/api/i18n/threat-status", Methods: get, handle: (*Server).handleThreatStatuses},
+		{Pattern: "/api/flora-brasil/vocabulary", Methods: get, handle: (*Server).handleFloraVocabulary},
+
+		// Recommendations
+		{Pattern: "/api/recommend", Methods: post, RateLimit: "20/m", handle: (*Server).handleRecommend},
+		{Pattern: "/api/recommend/plugins", Methods: get, handle: (*Server).handleRecommendPlugins},
+		{Pattern: "/api/recommend/stream", Methods: post, Timeout: 2 * time.Minute, RateLimit: "10/m", handle: (*Server).handleRecommendStream},
+		{Pattern: "/api/recommend/sensitivity", Methods: post, Timeout: time.Minute, RateLimit: "5/m", handle: (*Server).handleRecommendSensitivity},
+		{Pattern: "/api/recommend/explain", Methods: post, RateLimit: "10/m", handle: (*Server).handleRecommendExplain},
+		{Pattern: "/api/recommend/batch", Methods: post, Timeout: time.Minute, RateLimit: "2/m", handle: (*Server).handleRecommendBatch},
+		{Pattern: "/api/recommend/sandbox", Methods: post, RateLimit: "10/m", handle: (*Server).handleRecommendSandboxes},
+		{Pattern: "/api/recommend/sandbox/{id}", Methods: []string{http.MethodGet, http.MethodPatch, http.MethodDelete},
+			RateLimit: "60/m", handle: (*Server).handleRecommendSandbox},
+		{Pattern: "/api/compliance/check", Methods: post, handle: (*Server).handleComplianceCheck},
+		{Pattern: "/api/compliance/rules", Methods: get, handle: (*Server).handleComplianceRules},
+		{Pattern: "/api/plans", Methods: getPost, Auth: roleUser, handle: (*Server).handlePlans},
+		{Pattern: "/api/plans/{id}", Methods: getDelete, Auth: roleUser, handle: (*Server).handlePlan},
+		{Pattern: "/api/plans/{id}/export", Methods: get, Auth: roleUser, handle: planItem((*Server).exportPlan)},
+		{Pattern: "/api/plans/{id}/order", Methods: post, Auth: roleUser, handle: planItem((*Server).orderPlan)},
+		{Pattern: "/api/plans/{id}/outcomes", Methods: getPost, Auth: roleUser,
+			handle: planItem(func(s *Server, w http.ResponseWriter, r *http.Request, _ *APIKey, p *Plan) { s.planOutcomes(w, r, p) })},
+		{Pattern: "/api/aoi", Methods: getPost, Auth: roleUser, Stability: stabilityBeta, handle: (*Server).handleAOIs},
+		{Pattern: "/api/aoi/{id}", Methods: getDelete, WriteAuth: roleUser, Stability: stabilityBeta, handle: aoiView("")},
+		{Pattern: "/api/aoi/{id}/regions", Methods: get, Stability: stabilityBeta, handle: aoiView("regions")},
+		{Pattern: "/api/aoi/{id}/ecoregions", Methods: get, Stability: stabilityBeta, handle: aoiView("ecoregions")},
+		{Pattern: "/api/aoi/{id}/climate", Methods: get, Stability: stabilityBeta, handle: aoiView("climate")},
+		{Pattern: "/api/aoi/{id}/species", Methods: get, Stability: stabilityBeta, handle: aoiView("species")},
+		{Pattern: "/api/ecoregion/species", Methods: getPost, handle: (*Server).handleEcoregionSpecies},
+		{Pattern: "/api/ecoregion/{eco_id}/bounds", Methods: get, handle: (*Server).handleEcoregionBounds},
+		{Pattern: "/api/ecoregions", Methods: get, Stability: stabilityBeta, handle: (*Server).handleEcoregions},
+		{Pattern: "/api/ecoregions/{eco_id}.geojson", Methods: get, Stability: stabilityBeta, handle: (*Server).handleEcoregionGeoJSON},
+
+		// Contributions and curation
+		{Pattern: "/api/observations", Methods: getPost, Auth: roleUser, handle: (*Server).handleObservations},
+		{Pattern: "/api/observations/photos/{id}", Methods: get, handle: (*Server).handleObservationPhoto},
+		{Pattern: "/api/suggestions/common-names", Methods: post, Auth: roleUser, handle: (*Server).handleCommonNameSuggestion},
+		{Pattern: "/api/suggestions/traits", Methods: post, Auth: roleUser, handle: (*Server).handleTraitSuggestion},
+		{Pattern: "/api/curation/queue", Methods: get, Auth: roleCurator, handle: (*Server).handleCurationQueue},
+		{Pattern: "/api/curation/{type}/{id}/accept", Methods: post, Auth: roleCurator, handle: curationReview(true)},
+		{Pattern: "/api/curation/{type}/{id}/reject", Methods: post, Auth: roleCurator, handle: curationReview(false)},
+		{Pattern: "/api/curation/flags", Methods: get, Auth: roleCurator, handle: (*Server).handleTraitFlags},
+		{Pattern: "/api/curation/flags/{id}/resolve", Methods: post, Auth: roleCurator, handle: traitFlagAction(true)},
+		{Pattern: "/api/curation/flags/{id}/dismiss", Methods: post, Auth: roleCurator, handle: traitFlagAction(false)},
+
+		// Administration
+		{Pattern: "/api/admin/data-quality/run", Methods: post, Auth: roleAdmin, Stability: stabilityInternal,
+			Timeout: 10 * time.Minute, handle: (*Server).handleDataQualityRun},
+		{Pattern: "/api/admin/reco-telemetry", Methods: get, Auth: roleAdmin, Stability: stabilityInternal,
+			Timeout: time.Minute, handle: (*Server).handleRecoTelemetry},
+		{Pattern: "/api/admin/compliance/rules/{code}", Methods: []string{http.MethodPut}, Auth: roleAdmin, Stability: stabilityInternal,
+			handle: (*Server).handleAdminComplianceRules},
+		{Pattern: "/api/admin/geometry-cache", Methods: getPost, Auth: roleAdmin, Stability: stabilityInternal,
+			Timeout: 10 * time.Minute, handle: (*Server).handleGeometryCache},
+		{Pattern: "/api/admin/envelopes", Methods: getPost, Auth: roleAdmin, Stability: stabilityInternal,
+			Timeout: 10 * time.Minute, handle: (*Server).handleRobustEnvelopes},
+		{Pattern: "/api/admin/rescore", Methods: getPost, Auth: roleAdmin, Stability: stabilityInternal, handle: (*Server).handleRescore},
+		{Pattern: "/api/admin/rescore/{id}", Methods: getDelete, Auth: roleAdmin, Stability: stabilityInternal, handle: (*Server).handleRescoreRun},
+		{Pattern: "/api/admin/nursery-catalog", Methods: []string{http.MethodGet, http.MethodPut}, Auth: roleAdmin, Stability: stabilityInternal,
+			handle: (*Server).handleNurseryCatalog},
+		{Pattern: "/api/admin/climate-qa", Methods: get, Auth: roleAdmin, Stability: stabilityInternal, handle: (*Server).handleClimateQA},
+		{Pattern: "/api/admin/evaluation", Methods: get, Auth: roleAdmin, Stability: stabilityInternal, handle: (*Server).handleEvaluation},
+		{Pattern: "/api/admin/retention", Methods: getPost, Auth: roleAdmin, Stability: stabilityInternal, handle: (*Server).handleRetention},
+		{Pattern: "/api/admin/taxonomy/propagate", Methods: getPost, Auth: roleAdmin, Stability: stabilityInternal,
+			handle: (*Server).handleTaxonomyPropagate},
+		{Pattern: "/api/admin/dataset/release", Methods: post, Auth: roleAdmin, Stability: stabilityInternal, handle: (*Server).handleDatasetRelease},
+		{Pattern: "/api/admin/archive/recommendations/{id}/restore", Methods: post, Auth: roleAdmin, Stability: stabilityInternal,
+			handle: (*Server).handleArchivedRecommendation},
+
+		// Account and tenant
+		{Pattern: "/api/notifications", Methods: getPost, Auth: roleUser, handle: (*Server).handleNotifications},
+		{Pattern: "/api/me/export", Methods: get, Auth: roleUser, handle: (*Server).handleMeExport},
+		{Pattern: "/api/me/delete", Methods: []string{http.MethodPost, http.MethodDelete}, Auth: roleUser, handle: (*Server).handleMeDelete},
+		{Pattern: "/api/tenant/theme", Methods: []string{http.MethodGet, http.MethodPost, http.MethodPut}, Auth: roleUser, WriteAuth: roleAdmin,
+			Tenant: true, handle: (*Server).handleTenantTheme},
+		{Pattern: "/api/tenant/theme/logo", Methods: []string{http.MethodGet, http.MethodPost, http.MethodDelete}, Auth: roleUser, WriteAuth: roleAdmin,
+			Tenant: true, handle: (*Server).handleTenantThemeLogo},
+	}
+}
+
+// serve is the handler of rt on s
+func (rt route) serve(s *Server) http.Handler {
+	if rt.handler != nil {
+		return rt.handler(s)
+	}
+	handle := rt.handle
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handle(s, w, r) })
+}
+
+// RouteInfo is a registry entry as published by /api/routes
+type RouteInfo struct {
+	Pattern          string   `json:"pattern"`
+	Methods          []string `json:"methods"`
+	Auth             string   `json:"auth"`
+	WriteAuth        string   `json:"write_auth,omitempty"`
+	Tenant           bool     `json:"tenant,omitempty"`
+	Stability        string   `json:"stability"`
+	RateLimitClass   string   `json:"rate_limit_class"`     // default, public, none, or the route's own bucket
+	RateLimit        string   `json:"rate_limit,omitempty"` // Per client without a key, e.g. 20/m
+	StatementTimeout string   `json:"statement_timeout,omitempty"`
+}
+
+type RoutesResponse struct {
+	Routes []RouteInfo `json:"routes"`
+}
+
+// routeInfo describes rt with the limits of s's configuration
+func (s *Server) routeInfo(rt route) RouteInfo {
+	info := RouteInfo{
+		Pattern:        rt.Pattern,
+		Methods:        rt.Methods,
+		Auth:           rt.Auth,
+		WriteAuth:      rt.WriteAuth,
+		Tenant:         rt.Tenant,
+		Stability:      rt.Stability,
+		RateLimitClass: "none",
+	}
+	if info.Auth == "" {
+		info.Auth = authNone
+	}
+	if info.Stability == "" {
+		info.Stability = stabilityStable
+	}
+	if !strings.HasPrefix(rt.Pattern, "/api/") {
+		return info // Outside the API middleware
+	}
+
+	if d := s.cfg.StatementTimeouts.forPath(rt.Pattern); d > 0 {
+		info.StatementTimeout = d.String()
+	}
+	switch limits := s.cfg.RateLimits; {
+	case isPublicPath(rt.Pattern):
+		info.RateLimitClass, info.RateLimit = "public", s.cfg.Public.RateLimit.String()
+	case limits.fallback.Requests > 0:
+		limit, bucket := limits.forPath(rt.Pattern)
+		info.RateLimitClass = bucket
+		if bucket == "" {
+			info.RateLimitClass = "default"
+		}
+		if limit.Requests > 0 {
+			info.RateLimit = limit.String()
+		} else {
+			info.RateLimitClass = "none"
+		}
+	}
+	return info
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleRoutes handles GET /api/routes
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	stability := r.URL.Query().Get("stability")
+	if stability != "" && stability != stabilityStable && stability != stabilityBeta && stability != stabilityInternal {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "stability must be stable, beta or internal"), http.StatusBadRequest)
+		return
+	}
+	resp := RoutesResponse{Routes: []RouteInfo{}}
+	for _, rt := range routeRegistry() {
+		info := s.routeInfo(rt)
+		if stability == "" || info.Stability == stability {
+			resp.Routes = append(resp.Routes, info)
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAPINotFound answers the /api/ paths no route matches
+func (s *Server) handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	http.Error(w, `{"error": "Not found"}`, http.StatusNotFound)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteRegistry(t *testing.T) {
+	auths := map[string]bool{"": true, authNone: true, authOptional: true, roleUser: true, roleCurator: true, roleAdmin: true}
+	stabilities := map[string]bool{"": true, stabilityStable: true, stabilityBeta: true, stabilityInternal: true}
+	methods := map[string]bool{"*": true, "GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+	seen := map[string]bool{}
+	for _, rt := range routeRegistry() {
+		if seen[rt.Pattern] {
+			t.Errorf("%s registered twice", rt.Pattern)
+		}
+		seen[rt.Pattern] = true
+		if (rt.handle == nil) == (rt.handler == nil) {
+			t.Errorf("%s: needs exactly one of handle and handler", rt.Pattern)
+		}
+		if !auths[rt.Auth] || !auths[rt.WriteAuth] || !stabilities[rt.Stability] {
+			t.Errorf("%s: invalid auth %q/%q or stability %q", rt.Pattern, rt.Auth, rt.WriteAuth, rt.Stability)
+		}
+		if len(rt.Methods) == 0 {
+			t.Errorf("%s: no methods", rt.Pattern)
+		}
+		for _, m := range rt.Methods {
+			if !methods[m] {
+				t.Errorf("%s: invalid method %q", rt.Pattern, m)
+			}
+		}
+		if rt.RateLimit != "" {
+			if _, err := parseRateLimit(rt.RateLimit); err != nil {
+				t.Errorf("%s: %v", rt.Pattern, err)
+			}
+		}
+	}
+
+	// The per-route defaults reach the middleware configuration
+	if d := loadStatementTimeouts().forPath("/api/export/species"); d != 10*time.Minute {
+		t.Errorf("/api/export/ timeout = %v, want 10m", d)
+	}
+	if limit, bucket := loadRateLimits().forPath("/api/recommend/batch"); limit.String() != "2/m" || bucket != "/api/recommend/batch" {
+		t.Errorf("/api/recommend/batch limit = %v in bucket %q", limit, bucket)
+	}
+}
+
+func TestRateLimitString(t *testing.T) {
+	for _, v := range []string{"0", "5/s", "120/m", "1000/h"} {
+		limit, err := parseRateLimit(v)
+		if err != nil || limit.String() != v {
+			t.Errorf("%s: got %q, %v", v, limit.String(), err)
+		}
+	}
+}
+
+func TestHandleRoutes(t *testing.T) {
+	s := newTestServer()
+	s.cfg.RateLimits = rateLimits{fallback: rateLimit{120, time.Minute}, endpoints: map[string]rateLimit{"/api/recommend": {20, time.Minute}}}
+	s.cfg.Public.RateLimit = rateLimit{30, time.Minute}
+
+	w := httptest.NewRecorder()
+	s.handleRoutes(w, httptest.NewRequest(http.MethodGet, "/api/routes", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	checkSchema(t, "RoutesResponse", w.Body.Bytes())
+	var resp RoutesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	byPattern := map[string]RouteInfo{}
+	for _, info := range resp.Routes {
+		byPattern[info.Pattern] = info
+	}
+	for pattern, want := range map[string]RouteInfo{
+		"/api/routes":        {Auth: authNone, Stability: stabilityBeta, RateLimitClass: "default", RateLimit: "120/m"},
+		"/api/recommend":     {Auth: authNone, Stability: stabilityStable, RateLimitClass: "/api/recommend", RateLimit: "20/m"},
+		"/api/public/":       {Auth: authNone, Stability: stabilityStable, RateLimitClass: "public", RateLimit: "30/m"},
+		"/api/admin/rescore": {Auth: roleAdmin, Stability: stabilityInternal, RateLimitClass: "default", RateLimit: "120/m"},
+		"/tiles/":            {Auth: authNone, Stability: stabilityBeta, RateLimitClass: "none"},
+	} {
+		got, ok := byPattern[pattern]
+		if !ok {
+			t.Errorf("%s missing", pattern)
+			continue
+		}
+		if got.Auth != want.Auth || got.Stability != want.Stability || got.RateLimitClass != want.RateLimitClass || got.RateLimit != want.RateLimit {
+			t.Errorf("%s: got %+v", pattern, got)
+		}
+	}
+	if got := byPattern["/api/tenant/theme"]; got.WriteAuth != roleAdmin || !got.Tenant {
+		t.Errorf("/api/tenant/theme: got %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleRoutes(w, httptest.NewRequest(http.MethodGet, "/api/routes?stability=internal", nil))
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	for _, info := range resp.Routes {
+		if info.Stability != stabilityInternal {
+			t.Errorf("stability=internal listed %s (%s)", info.Pattern, info.Stability)
+		}
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/api/routes", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/routes?stability=alpha", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		s.handleRoutes(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// RECOMMENDATION SANDBOXES
+// ============================================================================
+//
+// A sandbox keeps a recommendation in memory so the client can refine it
+// step by step: lock species it wants to keep, remove ones it does not,
+// change n_species or the preferences. The candidate pool, trait vectors and
+// score adjustments are kept with it, so an edit reruns only the greedy
+// selection of the unlocked species against the locked ones; the database
+// is queried again only when preferences or the climate threshold change,
+// and then only for trait vectors not loaded yet. Sandboxes expire after
+// sandboxTTL without use and are lost on restart; n_species is capped at
+// maxSandboxSpecies.
+
+const (
+	sandboxTTL        = 30 * time.Minute
+	maxSandboxes      = 500
+	maxSandboxSpecies = 200 // Each edit reruns the greedy loop for up to this many
+)
+
+type recommendSandbox struct {
+	mu     sync.Mutex
+	server *Server // Loads the candidate pool
+
+	ID       string
+	Revision int
+	expires  atomic.Int64 // Unix nanoseconds; read without mu
+
+	req           RecommendRequest
+	plugins       *pipelinePlugins
+	location      LocationInfo
+	pool          []SpeciesRecommendation // Candidates for the current preferences
+	poolTruncated bool
+	traits        map[int64]TraitVector // Every species loaded so far
+	adjustments   map[int64]float64
+
+	selected  []SpeciesRecommendation
+	diversity *diversityAccumulator // Metrics of selected, kept in step by reselect
+	locked    map[int64]bool
+	removed   map[int64]bool
+}
+
+// sandboxStore holds a server's live sandboxes
+type sandboxStore struct {
+	sync.Mutex
+	byID map[string]*recommendSandbox
+}
+
+// storeSandbox registers sb, dropping expired sandboxes first
+func (s *Server) storeSandbox(sb *recommendSandbox) error {
+	s.sandboxes.Lock()
+	defer s.sandboxes.Unlock()
+	now := time.Now().UnixNano()
+	for id, other := range s.sandboxes.byID {
+		if now > other.expires.Load() {
+			delete(s.sandboxes.byID, id)
+		}
+	}
+	if len(s.sandboxes.byID) >= maxSandboxes {
+		return fmt.Errorf("too many active sandboxes, try again later")
+	}
+	s.sandboxes.byID[sb.ID] = sb
+	return nil
+}
+
+// lookupSandbox returns a live sandbox and extends its lifetime
+func (s *Server) lookupSandbox(id string) *recommendSandbox {
+	s.sandboxes.Lock()
+	sb := s.sandboxes.byID[id]
+	s.sandboxes.Unlock()
+	if sb == nil || time.Now().UnixNano() > sb.expires.Load() {
+		return nil
+	}
+	sb.touch()
+	return sb
+}
+
+func (sb *recommendSandbox) touch() {
+	sb.expires.Store(time.Now().Add(sandboxTTL).UnixNano())
+}
+
+// validateSandboxRequest bounds n_species, since every edit reruns the
+// greedy selection. Annealing is rejected: its swaps would not keep the
+// locked species.
+func validateSandboxRequest(req RecommendRequest) error {
+	if req.NSpecies <= 0 || req.NSpecies > maxSandboxSpecies {
+		return fmt.Errorf("sandboxes need n_species between 1 and %d", maxSandboxSpecies)
+	}
+	if req.Algorithm != "" && req.Algorithm != "greedy" {
+		return fmt.Errorf("sandboxes support only the greedy algorithm, not %s", req.Algorithm)
+	}
+	return nil
+}
+
+// loadPool fetches the candidates for the sandbox request, and the trait
+// vectors and score adjustments that are not cached yet
+func (sb *recommendSandbox) loadPool(ctx context.Context) error {
+	if err := sb.server.fillSiteElevation(ctx, &sb.req); err != nil {
+		return err
+	}
+	if err := sb.server.fillSiteSoil(ctx, &sb.req); err != nil {
+		return err
+	}
+	// The location never changes within a sandbox
+	location := sb.location
+	if location.TDWGCode == "" {
+		var err error
+		if location, err = sb.server.resolveLocation(ctx, sb.req); err != nil {
+			return fmt.Errorf("failed to resolve location: %w", err)
+		}
+	}
+	candidates, truncated, err := sb.server.getClimateAdaptedSpecies(ctx, location, sb.req)
+	if err != nil {
+		return fmt.Errorf("failed to get candidates: %w", err)
+	}
+	pluginCtx := PluginContext{Ctx: ctx, DB: sb.server.db, Request: sb.req, Location: location}
+	candidates, err = sb.plugins.filterCandidates(pluginCtx, candidates)
+	if err != nil {
+		return err
+	}
+
+	var missing []SpeciesRecommendation
+	for _, c := range candidates {
+		if _, ok := sb.traits[c.SpeciesID]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	loaded, err := sb.server.loadTraitVectors(ctx, missing, sb.req.Preferences.IncludeFlaggedTraits)
+	if err != nil {
+		return fmt.Errorf("failed to load traits: %w", err)
+	}
+	if sb.traits == nil {
+		sb.traits = make(map[int64]TraitVector, len(loaded))
+	}
+	for id, tv := range loaded {
+		sb.traits[id] = tv
+	}
+
+	// Adjustments depend on the preferences, so they are recomputed
+	adjustments, err := sb.plugins.scoreAdjustments(pluginCtx, candidates)
+	if err != nil {
+		return err
+	}
+	adjustments = elevationAdjustments(sb.req, candidates, adjustments)
+	sb.adjustments = hydrologyAdjustments(sb.req.Preferences, candidates, adjustments)
+
+	sb.location, sb.pool, sb.poolTruncated = location, candidates, truncated
+	return nil
+}
+
+// reselect keeps the locked species and fills the rest of the selection
+// greedily from the pool, skipping removed species
+func (sb *recommendSandbox) reselect() {
+	var locked []SpeciesRecommendation
+	for _, sp := range sb.selected {
+		if sb.locked[sp.SpeciesID] {
+			locked = append(locked, sp)
+		}
+	}
+	available := make([]SpeciesRecommendation, 0, len(sb.pool))
+	for _, c := range sb.pool {
+		if !sb.removed[c.SpeciesID] {
+			available = append(available, c)
+		}
+	}
+
+	diversityWeight, climateWeight := sb.req.selectionWeights()
+	sb.selected = greedyDiversitySelection(available, sb.traits, sb.req.NSpecies, selectionOptions{
+		Start:            startStrategies[sb.req.StartStrategy],
+		TopK:             sb.req.StartTopK,
+		Seed:             sb.req.StartSeed,
+		Adjustments:      sb.adjustments,
+		DiversityWeight:  diversityWeight,
+		ClimateWeight:    climateWeight,
+		Selected:         locked,
+		GrowthFormQuotas: sb.req.GrowthFormQuotas,
+	})
+	if sb.diversity == nil {
+		sb.diversity = newDiversityAccumulator(sb.traits)
+	}
+	sb.diversity.sync(sb.selected)
+	sb.Revision++
+}
+
+// SandboxUpdate edits a sandbox; every field is optional
+type SandboxUpdate struct {
+	Lock             []int64      `json:"lock,omitempty"`    // Keep these species (from the selection or the pool)
+	Unlock           []int64      `json:"unlock,omitempty"`  // Let them be reselected
+	Remove           []int64      `json:"remove,omitempty"`  // Exclude from the selection
+	Restore          []int64      `json:"restore,omitempty"` // Undo remove
+	NSpecies         *int         `json:"n_species,omitempty"`
+	ClimateThreshold *float64     `json:"climate_threshold,omitempty"`
+	Preferences      *Preferences `json:"preferences,omitempty"`
+}
+
+// apply validates and applies u; the pool is reloaded if the request changed
+func (sb *recommendSandbox) apply(ctx context.Context, u SandboxUpdate) error {
+	if u.Preferences != nil || u.ClimateThreshold != nil || u.NSpecies != nil {
+		req := sb.req
+		if u.Preferences != nil {
+			req.Preferences = *u.Preferences
+		}
+		if u.ClimateThreshold != nil {
+			req.ClimateThreshold = *u.ClimateThreshold
+		}
+		if u.NSpecies != nil {
+			req.NSpecies = *u.NSpecies
+		}
+		plugins, err := normalizeRecommendRequest(&req)
+		if err != nil {
+			return err
+		}
+		if err := validateSandboxRequest(req); err != nil {
+			return err
+		}
+		poolChanged := u.Preferences != nil || req.ClimateThreshold != sb.req.ClimateThreshold
+		sb.req, sb.plugins = req, plugins
+		if poolChanged {
+			if err := sb.loadPool(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	inPool := make(map[int64]SpeciesRecommendation, len(sb.pool))
+	for _, c := range sb.pool {
+		inPool[c.SpeciesID] = c
+	}
+	inSelection := make(map[int64]bool, len(sb.selected))
+	for _, sp := range sb.selected {
+		inSelection[sp.SpeciesID] = true
+	}
+	known := func(ids []int64) error {
+		for _, id := range ids {
+			if _, ok := inPool[id]; !ok && !inSelection[id] {
+				return fmt.Errorf("species %d is not in this sandbox", id)
+			}
+		}
+		return nil
+	}
+	for _, ids := range [][]int64{u.Lock, u.Unlock, u.Remove, u.Restore} {
+		if err := known(ids); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range u.Restore {
+		delete(sb.removed, id)
+	}
+	for _, id := range u.Unlock {
+		delete(sb.locked, id)
+	}
+	for _, id := range u.Remove {
+		delete(sb.locked, id)
+		sb.removed[id] = true
+	}
+	for _, id := range u.Lock {
+		if sb.removed[id] {
+			return fmt.Errorf("species %d is removed; restore it before locking", id)
+		}
+		if !sb.locked[id] && !inSelection[id] {
+			// Pulled in from the pool: appended after the current selection
+			sb.selected = append(sb.selected, inPool[id])
+		}
+		sb.locked[id] = true
+	}
+	if len(sb.locked) > sb.req.NSpecies {
+		return fmt.Errorf("%d species locked but n_species is %d", len(sb.locked), sb.req.NSpecies)
+	}
+
+	sb.reselect()
+	return nil
+}
+
+type SandboxResponse struct {
+	SandboxID        string                  `json:"sandbox_id"`
+	Revision         int                     `json:"revision"`
+	ExpiresAt        string                  `json:"expires_at"`
+	Species          []SpeciesRecommendation `json:"species"`
+	DiversityMetrics DiversityMetrics        `json:"diversity_metrics"`
+	LocationInfo     LocationInfo            `json:"location_info"`
+	Locked           []int64                 `json:"locked"`
+	Removed          []int64                 `json:"removed"`
+	PoolSize         int                     `json:"pool_size"`
+	PoolTruncated    bool                    `json:"pool_truncated"`
+	Request          RecommendRequest        `json:"request"`
+	QueryTime        string                  `json:"query_time"`
+}
+
+// response describes the sandbox state; metrics come from the diversity
+// accumulator, without touching the database
+func (sb *recommendSandbox) response(ctx context.Context, lang string, start time.Time) SandboxResponse {
+	ids := func(set map[int64]bool) []int64 {
+		out := make([]int64, 0, len(set))
+		for id := range set {
+			out = append(out, id)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+		return out
+	}
+	resp := SandboxResponse{
+		SandboxID:        sb.ID,
+		Revision:         sb.Revision,
+		ExpiresAt:        time.Unix(0, sb.expires.Load()).Format(time.RFC3339),
+		Species:          sb.selected,
+		DiversityMetrics: sb.diversity.metrics(sb.req.metricWeights()),
+		LocationInfo:     sb.location,
+		Locked:           ids(sb.locked),
+		Removed:          ids(sb.removed),
+		PoolSize:         len(sb.pool),
+		PoolTruncated:    sb.poolTruncated,
+		Request:          sb.req,
+		QueryTime:        time.Since(start).String(),
+	}
+	sb.server.localizeLocation(ctx, &resp.LocationInfo, lang)
+	resp.Species = withThreatLabels(resp.Species, lang)
+	return resp
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleRecommendSandboxes handles POST /api/recommend/sandbox, which takes
+// a /api/recommend request and returns its result as a new sandbox
+func (s *Server) handleRecommendSandboxes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	req, plugins, ok := decodeRecommendRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := validateSandboxRequest(req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	id, err := newQueryJobID()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	sb := &recommendSandbox{
+		server:  s,
+		ID:      id,
+		req:     req,
+		plugins: plugins,
+		locked:  map[int64]bool{},
+		removed: map[int64]bool{},
+	}
+	if err := sb.loadPool(ctx); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if len(sb.pool) == 0 {
+		http.Error(w, `{"error": "no species found matching criteria (try lowering climate_threshold)"}`, http.StatusUnprocessableEntity)
+		return
+	}
+	sb.reselect()
+
+	sb.touch()
+	if err := s.storeSandbox(sb); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+
+	lang := requestLanguage(r)
+	setContentLanguage(w, lang)
+	w.Header().Set("Location", "/api/recommend/sandbox/"+sb.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sb.response(ctx, lang, start))
+}
+
+// handleRecommendSandbox handles /api/recommend/sandbox/{id}: GET returns
+// the current state, PATCH applies a SandboxUpdate, DELETE discards it
+func (s *Server) handleRecommendSandbox(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	id := pathParam(r, "id")
+	sb := s.lookupSandbox(id)
+	if sb == nil {
+		http.Error(w, `{"error": "Sandbox not found or expired"}`, http.StatusNotFound)
+		return
+	}
+
+	start := time.Now()
+	lang := requestLanguage(r)
+	switch r.Method {
+	case http.MethodGet:
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+		setContentLanguage(w, lang)
+		json.NewEncoder(w).Encode(sb.response(ctx, lang, start))
+
+	case http.MethodPatch:
+		var u SandboxUpdate
+		if !decodeJSONBody(w, r, &u) {
+			return
+		}
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+		// Edits are applied to a copy, so a rejected one leaves no trace
+		draft := sb.clone()
+		if err := draft.apply(ctx, u); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		sb.adopt(draft)
+		setContentLanguage(w, lang)
+		json.NewEncoder(w).Encode(sb.response(ctx, lang, start))
+
+	case http.MethodDelete:
+		s.sandboxes.Lock()
+		delete(s.sandboxes.byID, id)
+		s.sandboxes.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// clone copies the editable state; the pool and trait cache are shared,
+// since they are replaced or only added to
+func (sb *recommendSandbox) clone() *recommendSandbox {
+	c := &recommendSandbox{
+		server:        sb.server,
+		ID:            sb.ID,
+		Revision:      sb.Revision,
+		req:           sb.req,
+		plugins:       sb.plugins,
+		location:      sb.location,
+		pool:          sb.pool,
+		poolTruncated: sb.poolTruncated,
+		traits:        sb.traits,
+		adjustments:   sb.adjustments,
+		selected:      append([]SpeciesRecommendation(nil), sb.selected...),
+		diversity:     sb.diversity.clone(),
+		locked:        make(map[int64]bool, len(sb.locked)),
+		removed:       make(map[int64]bool, len(sb.removed)),
+	}
+	for id := range sb.locked {
+		c.locked[id] = true
+	}
+	for id := range sb.removed {
+		c.removed[id] = true
+	}
+	return c
+}
+
+// adopt takes over the state of an applied draft (sb.mu held)
+func (sb *recommendSandbox) adopt(d *recommendSandbox) {
+	sb.Revision = d.Revision
+	sb.req, sb.plugins, sb.location = d.req, d.plugins, d.location
+	sb.pool, sb.poolTruncated = d.pool, d.poolTruncated
+	sb.traits, sb.adjustments = d.traits, d.adjustments
+	sb.selected, sb.diversity = d.selected, d.diversity
+	sb.locked, sb.removed = d.locked, d.removed
+}
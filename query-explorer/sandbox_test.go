@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testSandbox is a sandbox over testPool with its first selection made, as
+// after POST /api/recommend/sandbox
+func testSandbox(n int) *recommendSandbox {
+	pool, traits := testPool()
+	sb := &recommendSandbox{
+		ID:      "test",
+		req:     RecommendRequest{NSpecies: n, StartStrategy: "best_climate"},
+		pool:    pool,
+		traits:  traits,
+		locked:  map[int64]bool{},
+		removed: map[int64]bool{},
+	}
+	sb.reselect()
+	return sb
+}
+
+func contains(ids []int64, id int64) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSandboxLockAndRemove(t *testing.T) {
+	ctx := context.Background()
+	sb := testSandbox(3)
+	first := selectedIDs(sb.selected)
+	if len(first) != 3 {
+		t.Fatalf("initial selection: %v", first)
+	}
+
+	// Locking keeps a species through later edits; removing excludes one
+	locked, removed := first[1], first[0]
+	if err := sb.apply(ctx, SandboxUpdate{Lock: []int64{locked}, Remove: []int64{removed}}); err != nil {
+		t.Fatal(err)
+	}
+	ids := selectedIDs(sb.selected)
+	if len(ids) != 3 || ids[0] != locked || contains(ids, removed) {
+		t.Errorf("after lock %d / remove %d: %v", locked, removed, ids)
+	}
+	if sb.selected[0].SelectionRank != 1 || sb.selected[0].DiversityContribution != 1.0 {
+		t.Errorf("locked species not re-ranked first: %+v", sb.selected[0])
+	}
+
+	// A species from the pool can be locked in
+	var outside int64
+	for _, c := range sb.pool {
+		if !contains(ids, c.SpeciesID) && c.SpeciesID != removed {
+			outside = c.SpeciesID
+		}
+	}
+	if err := sb.apply(ctx, SandboxUpdate{Lock: []int64{outside}}); err != nil {
+		t.Fatal(err)
+	}
+	ids = selectedIDs(sb.selected)
+	if !contains(ids, outside) || !contains(ids, locked) || len(ids) != 3 {
+		t.Errorf("after locking %d from the pool: %v", outside, ids)
+	}
+
+	// Restoring makes a removed species eligible again
+	if err := sb.apply(ctx, SandboxUpdate{Restore: []int64{removed}}); err != nil {
+		t.Fatal(err)
+	}
+	if sb.removed[removed] {
+		t.Error("species still removed after restore")
+	}
+	if sb.Revision != 4 {
+		t.Errorf("revision = %d, want 4", sb.Revision)
+	}
+}
+
+func TestSandboxRejectsInvalidEdits(t *testing.T) {
+	ctx := context.Background()
+	sb := testSandbox(2)
+
+	if err := sb.apply(ctx, SandboxUpdate{Lock: []int64{999}}); err == nil {
+		t.Error("locking an unknown species: expected an error")
+	}
+	if err := sb.apply(ctx, SandboxUpdate{Lock: []int64{1, 2, 3}}); err == nil {
+		t.Error("locking more species than n_species: expected an error")
+	}
+	if err := sb.apply(ctx, SandboxUpdate{Remove: []int64{4}, Lock: []int64{4}}); err == nil {
+		t.Error("locking a removed species: expected an error")
+	}
+	zero := 0
+	if err := sb.apply(ctx, SandboxUpdate{NSpecies: &zero}); err == nil {
+		t.Error("n_species 0: expected an error")
+	}
+}
+
+func TestSandboxRejectsAnnealing(t *testing.T) {
+	for _, tc := range []struct {
+		algorithm string
+		ok        bool
+	}{
+		{"", true},
+		{"greedy", true},
+		{"annealing", false},
+	} {
+		err := validateSandboxRequest(RecommendRequest{NSpecies: 5, Algorithm: tc.algorithm})
+		if (err == nil) != tc.ok {
+			t.Errorf("algorithm %q: %v", tc.algorithm, err)
+		}
+	}
+
+	s := newTestServer()
+	req := httptest.NewRequest("POST", "/api/recommend/sandbox", strings.NewReader(`{"tdwg_code": "BZS", "n_species": 5, "algorithm": "annealing"}`))
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "only the greedy algorithm") {
+		t.Errorf("%d %s, want 400", w.Code, w.Body.String())
+	}
+}
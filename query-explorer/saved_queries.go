@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// SAVED QUERIES
+// ============================================================================
+//
+// Named read-only SQL (migration 024). Queries are validated like /api/query
+// when saved and again when executed. A shared query is visible to the
+// owner's tenant; only the owner (or an admin) can change or delete it.
+// Queries can take named parameters, bound when run (query_params.go).
+
+type SavedQuery struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	Description *string      `json:"description,omitempty"`
+	SQL         string       `json:"sql"`
+	Params      []QueryParam `json:"params"`
+	Shared      bool         `json:"shared"`
+	Owner       string       `json:"owner"`
+	Mine        bool         `json:"mine"`
+	RunCount    int          `json:"run_count"`
+	LastRunAt   *string      `json:"last_run_at,omitempty"`
+	CreatedAt   string       `json:"created_at"`
+}
+
+type SavedQueryRequest struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	SQL         string       `json:"sql"`
+	Params      []QueryParam `json:"params,omitempty"` // Types and defaults of the :name parameters
+	Shared      bool         `json:"shared,omitempty"`
+}
+
+// savedQueryVisible restricts to the key's own queries and those shared in
+// its tenant ($1 key ID, $2 tenant ID)
+const savedQueryVisible = `(q.owner_key_id = $1 OR (q.shared AND q.tenant_id IS NOT DISTINCT FROM $2))`
+
+const savedQueryColumns = `q.id, q.name, q.description, q.sql_text, q.params, q.shared, k.owner,
+	q.owner_key_id = $1, q.run_count, q.last_run_at, q.created_at`
+
+// scanSavedQuery scans savedQueryColumns, followed by any extra columns
+func scanSavedQuery(row interface{ Scan(...interface{}) error }, extra ...interface{}) (SavedQuery, error) {
+	var q SavedQuery
+	var lastRun sql.NullTime
+	var createdAt time.Time
+	var params []byte
+	err := row.Scan(append([]interface{}{&q.ID, &q.Name, &q.Description, &q.SQL, &params, &q.Shared, &q.Owner,
+		&q.Mine, &q.RunCount, &lastRun, &createdAt}, extra...)...)
+	if err != nil {
+		return q, err
+	}
+	// Defaults must stay json.Number, as in request bodies
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	if err := dec.Decode(&q.Params); err != nil {
+		return q, fmt.Errorf("saved query %d params: %w", q.ID, err)
+	}
+	if lastRun.Valid {
+		s := lastRun.Time.Format(time.RFC3339)
+		q.LastRunAt = &s
+	}
+	q.CreatedAt = createdAt.Format(time.RFC3339)
+	return q, nil
+}
+
+var savedQueryList = listSpec{
+	DefaultLimit: 100,
+	MaxLimit:     500,
+	Sorts: map[string]string{
+		"name":        "q.name",
+		"created_at":  "q.created_at",
+		"last_run_at": "COALESCE(q.last_run_at, '-infinity')",
+		"run_count":   "q.run_count",
+	},
+	DefaultSort:   "name",
+	IDColumn:      "q.id",
+	TimeColumn:    "q.created_at",
+	SearchColumns: []string{"q.name", "q.description", "q.sql_text"},
+}
+
+// savedQueryByID looks up query $3 for key $1 of tenant $2, any query when
+// $4 (admin) is set
+const savedQueryByID = `
+	SELECT ` + savedQueryColumns + `
+	FROM saved_queries q
+	JOIN api_keys k ON q.owner_key_id = k.id
+	WHERE ($4 OR ` + savedQueryVisible + `) AND q.id = $3`
+
+// getSavedQuery returns a query visible to key; admins reach any query, so
+// they can moderate the private ones too
+func (s *Server) getSavedQuery(ctx context.Context, key *APIKey, id int64) (SavedQuery, error) {
+	return scanSavedQuery(s.db.QueryRowContext(ctx, savedQueryByID, key.ID, key.TenantID, id, key.hasRole(roleAdmin)))
+}
+
+// canModifySavedQuery reports whether key may change or delete q: its owner
+// or an admin
+func canModifySavedQuery(q SavedQuery, key *APIKey) bool {
+	return q.Mine || key.hasRole(roleAdmin)
+}
+
+func validateSavedQueryRequest(req *SavedQueryRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 255 {
+		return fmt.Errorf("name required (at most 255 characters)")
+	}
+	req.SQL = strings.TrimSpace(req.SQL)
+	compiled, params, err := compileQueryParams(req.SQL, req.Params)
+	if err != nil {
+		return err
+	}
+	if _, err := validateReadOnlyQuery(compiled); err != nil {
+		return err
+	}
+	req.Params = params
+	return nil
+}
+
+// paramsJSON is the params column value of req
+func (req *SavedQueryRequest) paramsJSON() string {
+	if len(req.Params) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(req.Params)
+	return string(b)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleSavedQueries handles GET/POST /api/queries
+func (s *Server) handleSavedQueries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		p, err := parseListParams(r, savedQueryList)
+		if err != nil {
+			writeListError(w, err)
+			return
+		}
+		where, tail, args := p.SQL([]interface{}{key.ID, key.TenantID})
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT `+savedQueryColumns+`, `+p.CursorColumn()+`
+			FROM saved_queries q
+			JOIN api_keys k ON q.owner_key_id = k.id
+			WHERE `+savedQueryVisible+where+`
+			`+tail, args...)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		queries := []SavedQuery{}
+		var keys []listKey
+		for rows.Next() {
+			var cursorValue string
+			q, err := scanSavedQuery(rows, &cursorValue)
+			if err != nil {
+				s.log.Printf("Error scanning saved query row: %v", err)
+				continue
+			}
+			queries = append(queries, q)
+			keys = append(keys, listKey{cursorValue, q.ID})
+		}
+		n, next := p.trim(keys)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"queries":     queries[:n],
+			"next_cursor": next,
+		})
+
+	case http.MethodPost:
+		var req SavedQueryRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if err := validateSavedQueryRequest(&req); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		var id int64
+		err := s.db.QueryRowContext(ctx, `
+			INSERT INTO saved_queries (owner_key_id, tenant_id, name, description, sql_text, params, shared)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+			RETURNING id
+		`, key.ID, key.TenantID, req.Name, req.Description, req.SQL, req.paramsJSON(), req.Shared).Scan(&id)
+		if err != nil {
+			s.log.Printf("Error saving query: %v", err)
+			http.Error(w, `{"error": "Failed to save query"}`, http.StatusInternalServerError)
+			return
+		}
+
+		q, err := s.getSavedQuery(ctx, key, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(q)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// savedQueryForRequest loads the saved query of the {id} route for the
+// caller, answering the error otherwise
+func (s *Server) savedQueryForRequest(w http.ResponseWriter, r *http.Request) (*APIKey, SavedQuery, bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error": "Not found"}`, http.StatusNotFound)
+		return nil, SavedQuery{}, false
+	}
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return nil, SavedQuery{}, false
+	}
+
+	q, err := s.getSavedQuery(r.Context(), key, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Query not found"}`, http.StatusNotFound)
+		return nil, q, false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return nil, q, false
+	}
+	return key, q, true
+}
+
+// handleSavedQueryRun handles POST /api/queries/{id}/run (or /execute)
+func (s *Server) handleSavedQueryRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key, q, ok := s.savedQueryForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Optional body: {"params": {"name": value}, "limit": n}
+	var body struct {
+		Params map[string]interface{} `json:"params"`
+		Limit  int                    `json:"limit"`
+	}
+	if err := decodeJSON(r.Body, &body); err != nil && err != io.EOF {
+		writeDecodeError(w, "Invalid JSON", err)
+		return
+	}
+	compiled, params, err := compileQueryParams(q.SQL, q.Params)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	args, err := bindQueryParams(params, body.Params)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE saved_queries SET run_count = run_count + 1, last_run_at = NOW() WHERE id = $1
+	`, q.ID); err != nil {
+		s.log.Printf("Error updating saved query stats: %v", err)
+	}
+	audit := s.newQueryAudit(r, "saved", key)
+	audit.SavedQueryID = q.ID
+	s.runQuery(w, r, QueryRequest{SQL: compiled, Limit: body.Limit, Args: args}, audit)
+}
+
+// handleSavedQuery handles /api/queries/{id} (GET, PUT, DELETE)
+func (s *Server) handleSavedQuery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key, q, ok := s.savedQueryForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	canModify := canModifySavedQuery(q, key)
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(q)
+
+	case http.MethodPut:
+		if !canModify {
+			http.Error(w, `{"error": "Only the owner can change this query"}`, http.StatusForbidden)
+			return
+		}
+		var req SavedQueryRequest
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+		if err := validateSavedQueryRequest(&req); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		if _, err := s.db.ExecContext(ctx, `
+			UPDATE saved_queries
+			SET name = $2, description = NULLIF($3, ''), sql_text = $4, params = $5, shared = $6
+			WHERE id = $1
+		`, q.ID, req.Name, req.Description, req.SQL, req.paramsJSON(), req.Shared); err != nil {
+			s.log.Printf("Error updating saved query: %v", err)
+			http.Error(w, `{"error": "Failed to update query"}`, http.StatusInternalServerError)
+			return
+		}
+		q, err := s.getSavedQuery(ctx, key, q.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(q)
+
+	case http.MethodDelete:
+		if !canModify {
+			http.Error(w, `{"error": "Only the owner can delete this query"}`, http.StatusForbidden)
+			return
+		}
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM saved_queries WHERE id = $1`, q.ID); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// JSON SCHEMAS
+// ============================================================================
+//
+// JSON Schemas (draft 2020-12) of the response types, for R and Python
+// consumers generating typed models:
+//
+//	GET /api/schemas          the published schemas and their URLs
+//	GET /api/schemas/{name}   one schema, e.g. /api/schemas/RecommendResponse
+//
+// Schemas are derived from the Go types by reflection, following
+// encoding/json: the json tag names a property, omitempty (and fields
+// promoted from an embedded pointer) make it optional, pointers, slices and
+// maps may be null, and named structs are shared through $defs. Handler
+// tests check the bodies they record against the schemas (checkSchema in
+// schemas_test.go).
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// responseSchemaTypes are the published types, by schema name
+var responseSchemaTypes = map[string]interface{}{
+	"AOIClimateResponse":       AOIClimateResponse{},
+	"AOIEcoregionsResponse":    AOIEcoregionsResponse{},
+	"AOIFeature":               AOIFeature{},
+	"AOIListResponse":          AOIListResponse{},
+	"AOIRegionsResponse":       AOIRegionsResponse{},
+	"AdminUnitListResponse":    AdminUnitListResponse{},
+	"AllSourcesResponse":       AllSourcesResponse{},
+	"AreaOfInterest":           AreaOfInterest{},
+	"BatchRecommendResponse":   BatchRecommendResponse{},
+	"ClimateAnalogsResponse":   ClimateAnalogsResponse{},
+	"ClimateData":              ClimateData{},
+	"ClimateMatchResponse":     ClimateMatchResponse{},
+	"ClimatePointResponse":     ClimatePointResponse{},
+	"ClimateStatsResponse":     ClimateStatsResponse{},
+	"EcoregionListResponse":    EcoregionListResponse{},
+	"EcoregionResponse":        EcoregionResponse{},
+	"EcoregionSpecies":         EcoregionSpecies{},
+	"ElevationResponse":        ElevationResponse{},
+	"ExplainResponse":          ExplainResponse{},
+	"HealthResponse":           HealthResponse{},
+	"PersonalDataExport":       PersonalDataExport{},
+	"QueryResponse":            QueryResponse{},
+	"RecommendExplainResponse": RecommendExplainResponse{},
+	"RecommendResponse":        RecommendResponse{},
+	"RegionBounds":             RegionBounds{},
+	"RoutesResponse":           RoutesResponse{},
+	"SandboxResponse":          SandboxResponse{},
+	"SensitivityResponse":      SensitivityResponse{},
+	"SoilResponse":             SoilResponse{},
+	"SpeciesClimateResponse":   SpeciesClimateResponse{},
+	"SpeciesDetailResponse":    SpeciesDetailResponse{},
+	"SpeciesEnvelopeResponse":  SpeciesEnvelopeResponse{},
+	"SpeciesItem":              SpeciesItem{},
+	"SpeciesRecommendation":    SpeciesRecommendation{},
+	"SpeciesResponse":          SpeciesResponse{},
+	"SpeciesSearchResponse":    SpeciesSearchResponse{},
+	"SpeciesWithinResponse":    SpeciesWithinResponse{},
+	"StatsResponse":            StatsResponse{},
+	"TDWGBatchResponse":        TDWGBatchResponse{},
+	"TDWGFeature":              TDWGFeature{},
+	"TDWGFeatureCollection":    TDWGFeatureCollection{},
+	"TDWGRegionProperties":     TDWGRegionProperties{},
+	"TDWGResponse":             TDWGResponse{},
+	"TDWGUnitResponse":         TDWGUnitResponse{},
+	"TDWGUnitsResponse":        TDWGUnitsResponse{},
+	"TaxonFamiliesResponse":    TaxonFamiliesResponse{},
+	"TaxonGeneraResponse":      TaxonGeneraResponse{},
+	"TaxonSpecies":             TaxonSpecies{},
+	"TaxonSpeciesResponse":     TaxonSpeciesResponse{},
+	"TaxonSummary":             TaxonSummary{},
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+type jsonSchema = map[string]interface{}
+
+// schemaBuilder collects the $defs of one schema document
+type schemaBuilder struct {
+	defs map[string]jsonSchema
+}
+
+// nullable lets schema also match null
+func nullable(schema jsonSchema) jsonSchema {
+	if t, ok := schema["type"].(string); ok {
+		out := jsonSchema{}
+		for k, v := range schema {
+			out[k] = v
+		}
+		out["type"] = []string{t, "null"}
+		return out
+	}
+	if len(schema) == 0 {
+		return schema // Already anything
+	}
+	return jsonSchema{"anyOf": []jsonSchema{schema, {"type": "null"}}}
+}
+
+// schema returns the schema of values of t as encoding/json writes them
+func (b *schemaBuilder) schema(t reflect.Type) jsonSchema {
+	switch {
+	case t == timeType:
+		return jsonSchema{"type": "string", "format": "date-time"}
+	case t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface &&
+		(t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType)):
+		return jsonSchema{} // Custom encoding, e.g. json.RawMessage
+	case t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface &&
+		(t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)):
+		return jsonSchema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Pointer:
+		return nullable(b.schema(t.Elem()))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonSchema{"type": []string{"string", "null"}, "contentEncoding": "base64"}
+		}
+		return nullable(jsonSchema{"type": "array", "items": b.schema(t.Elem())})
+	case reflect.Array:
+		return jsonSchema{"type": "array", "items": b.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return nullable(jsonSchema{"type": "object", "additionalProperties": b.schema(t.Elem())})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := t.Name()
+		if _, ok := b.defs[name]; !ok {
+			b.defs[name] = nil // Placeholder for recursive types
+			b.defs[name] = b.object(t)
+		}
+		return jsonSchema{"$ref": "#/$defs/" + name}
+	}
+	return jsonSchema{} // interface{}: anything
+}
+
+// object is the object schema of struct t, its fields as encoding/json
+// names and promotes them
+func (b *schemaBuilder) object(t reflect.Type) jsonSchema {
+	properties := jsonSchema{}
+	required := []string{}
+	b.addFields(t, properties, &required, false)
+	sort.Strings(required)
+	return jsonSchema{"type": "object", "properties": properties, "required": required}
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties jsonSchema, required *[]string, optional bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				// A nil embedded pointer drops its fields
+				b.addFields(ft, properties, required, optional || f.Type.Kind() == reflect.Pointer)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := properties[name]; ok {
+			continue // Shadowed by a shallower field
+		}
+		schema := b.schema(f.Type)
+		if strings.Contains(opts, "string") {
+			schema = jsonSchema{"type": "string"}
+		}
+		properties[name] = schema
+		if !optional && !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// responseSchema builds the schema document of the published type name
+func responseSchema(name string) (jsonSchema, bool) {
+	v, ok := responseSchemaTypes[name]
+	if !ok {
+		return nil, false
+	}
+	b := &schemaBuilder{defs: map[string]jsonSchema{}}
+	root := b.schema(reflect.TypeOf(v))
+	doc := jsonSchema{
+		"$schema": jsonSchemaDialect,
+		"$id":     "/api/schemas/" + name,
+		"title":   name,
+		"$defs":   b.defs,
+	}
+	for k, v := range root {
+		doc[k] = v
+	}
+	return doc, true
+}
+
+var (
+	responseSchemasOnce sync.Once
+	responseSchemasJSON map[string][]byte
+)
+
+// encodedSchemas are the schema documents, built once
+func encodedSchemas() map[string][]byte {
+	responseSchemasOnce.Do(func() {
+		responseSchemasJSON = make(map[string][]byte, len(responseSchemaTypes))
+		for name := range responseSchemaTypes {
+			doc, _ := responseSchema(name)
+			data, err := json.MarshalIndent(doc, "", "  ")
+			if err != nil {
+				panic(fmt.Sprintf("schema %s: %v", name, err))
+			}
+			responseSchemasJSON[name] = data
+		}
+	})
+	return responseSchemasJSON
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleSchemas handles GET /api/schemas and /api/schemas/{name}
+func (s *Server) handleSchemas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	schemas := encodedSchemas()
+
+	name := strings.TrimSuffix(pathParam(r, "name"), ".json")
+	if name == "" {
+		type schemaLink struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		}
+		links := make([]schemaLink, 0, len(schemas))
+		for name := range schemas {
+			links = append(links, schemaLink{name, "/api/schemas/" + name})
+		}
+		sort.Slice(links, func(i, j int) bool { return links[i].Name < links[j].Name })
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		json.NewEncoder(w).Encode(map[string]interface{}{"dialect": jsonSchemaDialect, "schemas": links})
+		return
+	}
+
+	data, ok := schemas[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Unknown schema: "+name), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validateSchema checks doc, decoded JSON, against the parts of JSON Schema
+// responseSchema emits
+func validateSchema(root, schema map[string]interface{}, doc interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, _ := root["$defs"].(map[string]interface{})[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+		if def == nil {
+			return []string{path + ": unresolved " + ref}
+		}
+		return validateSchema(root, def, doc, path)
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		var errs []string
+		for _, alt := range anyOf {
+			e := validateSchema(root, alt.(map[string]interface{}), doc, path)
+			if len(e) == 0 {
+				return nil
+			}
+			errs = append(errs, e...)
+		}
+		return errs
+	}
+
+	var types []string
+	switch t := schema["type"].(type) {
+	case nil:
+		return nil
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			types = append(types, v.(string))
+		}
+	}
+	var got string
+	switch v := doc.(type) {
+	case nil:
+		got = "null"
+	case bool:
+		got = "boolean"
+	case float64:
+		got = "number"
+		if v == float64(int64(v)) {
+			got = "integer"
+		}
+	case string:
+		got = "string"
+	case []interface{}:
+		got = "array"
+	case map[string]interface{}:
+		got = "object"
+	}
+	matched := false
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			matched = true
+		}
+	}
+	if !matched {
+		return []string{fmt.Sprintf("%s: got %s, want %v", path, got, types)}
+	}
+
+	var errs []string
+	switch v := doc.(type) {
+	case []interface{}:
+		if n, ok := schema["minItems"].(float64); ok && len(v) < int(n) {
+			errs = append(errs, fmt.Sprintf("%s: %d items, want at least %v", path, len(v), n))
+		}
+		if n, ok := schema["maxItems"].(float64); ok && len(v) > int(n) {
+			errs = append(errs, fmt.Sprintf("%s: %d items, want at most %v", path, len(v), n))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, validateSchema(root, items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing %s", path, name))
+			}
+		}
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, value := range v {
+			if p, ok := properties[name].(map[string]interface{}); ok {
+				errs = append(errs, validateSchema(root, p, value, path+"."+name)...)
+			} else if additional != nil {
+				errs = append(errs, validateSchema(root, additional, value, path+"."+name)...)
+			} else if properties != nil {
+				errs = append(errs, fmt.Sprintf("%s: undocumented property %s", path, name))
+			}
+		}
+	}
+	return errs
+}
+
+// checkSchema validates body against the published schema name
+func checkSchema(t *testing.T, name string, body []byte) {
+	t.Helper()
+	var root, doc map[string]interface{}
+	if err := json.Unmarshal(encodedSchemas()[name], &root); err != nil {
+		t.Fatalf("schema %s: %v", name, err)
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("%s: invalid JSON: %v", name, err)
+	}
+	for _, e := range validateSchema(root, root, doc, name) {
+		t.Error(e)
+	}
+}
+
+// climatePointFixture is get_climate_json_at_point output at São Paulo
+const climatePointFixture = `{"bio1": 19.3, "bio2": 9.1, "bio3": 58.2, "bio4": 189.4, "bio5": 27.6,
+	"bio6": 11.4, "bio7": 16.2, "bio8": 21.7, "bio9": 16.5, "bio10": 21.9, "bio11": 16.3,
+	"bio12": 1441, "bio13": 239, "bio14": 38, "bio15": 59.1, "bio16": 651, "bio17": 136,
+	"bio18": 586, "bio19": 136, "aridity_index": 4.96, "whittaker_biome": "Temperate Rainforest",
+	"koppen_zone": "Cfa"}`
+
+func TestRecordedBodiesMatchSchemas(t *testing.T) {
+	record := func(v interface{}) []byte {
+		w := httptest.NewRecorder()
+		writeCompressedJSON(w, httptest.NewRequest(http.MethodGet, "/", nil), v)
+		return w.Body.Bytes()
+	}
+
+	// Every key the SQL function returns must survive decoding
+	climate, ok := decodeClimatePoint([]byte(climatePointFixture))
+	if !ok {
+		t.Fatal("climate fixture not decoded")
+	}
+	climate.Lat, climate.Lon, climate.Source = -23.55, -46.63, "worldclim_raster"
+	name := "Subtropical humid"
+	climate.KoppenName = &name
+	body := record(climate)
+	checkSchema(t, "ClimatePointResponse", body)
+	var in, out map[string]interface{}
+	json.Unmarshal([]byte(climatePointFixture), &in)
+	json.Unmarshal(body, &out)
+	for k, v := range in {
+		if !reflect.DeepEqual(out[k], v) {
+			t.Errorf("climate point %s: got %v, want %v", k, out[k], v)
+		}
+	}
+	if _, ok := decodeClimatePoint([]byte(`{}`)); ok {
+		t.Error("empty point decoded")
+	}
+
+	geometry := json.RawMessage(`{"type": "Point", "coordinates": [-46.63, -23.55]}`)
+	props := TDWGRegionProperties{Code: "BZL", Name: "Southeast Brazil", Level2Code: "84", Continent: "Southern America"}
+	checkSchema(t, "TDWGFeatureCollection", record(TDWGFeatureCollection{
+		Type: "FeatureCollection", Tolerance: 0.01,
+		Features: []TDWGFeature{{Type: "Feature", ID: "BZL", Properties: props, Geometry: geometry}},
+	}))
+	checkSchema(t, "AOIFeature", record(AOIFeature{Type: "Feature", ID: "a1", Properties: AreaOfInterest{ID: "a1", Name: "Sítio"}, Geometry: geometry}))
+	checkSchema(t, "AOIListResponse", record(AOIListResponse{Areas: []AreaOfInterest{}}))
+
+	counts := make([]int64, len(taxonTraits))
+	counts[0] = 3
+	coverage, completeness := traitCoverage(counts, 4)
+	genera := int64(2)
+	summary := TaxonSummary{Name: "Fabaceae", NGenera: &genera, NSpecies: 4, TraitCoverage: coverage, Completeness: completeness}
+	checkSchema(t, "TaxonFamiliesResponse", record(TaxonFamiliesResponse{Traits: taxonTraitNames(), Families: []TaxonSummary{summary}}))
+	summary.Name, summary.NGenera = "Inga", nil
+	checkSchema(t, "TaxonGeneraResponse", record(TaxonGeneraResponse{Family: "Fabaceae", Traits: taxonTraitNames(), Genera: []TaxonSummary{summary}}))
+
+	p, err := parseListParams(httptest.NewRequest(http.MethodGet, "/?limit=1", nil), taxonSpeciesList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	species := []TaxonSpecies{{SpeciesID: 1, CanonicalName: "Inga edulis", MissingTraits: []string{}}, {SpeciesID: 2, CanonicalName: "Inga vera", MissingTraits: []string{"threat_status"}}}
+	n, next := p.trim([]listKey{{"Inga edulis", 1}, {"Inga vera", 2}})
+	checkSchema(t, "TaxonSpeciesResponse", record(TaxonSpeciesResponse{Family: "Fabaceae", Genus: "Inga", Traits: taxonTraitNames(), Species: species[:n], NextCursor: next}))
+}
+
+func TestResponseSchema(t *testing.T) {
+	doc, ok := responseSchema("SpeciesItem")
+	if !ok {
+		t.Fatal("SpeciesItem not published")
+	}
+	if doc["$ref"] != "#/$defs/SpeciesItem" || doc["$schema"] != jsonSchemaDialect {
+		t.Errorf("root: got %v, %v", doc["$ref"], doc["$schema"])
+	}
+	item := doc["$defs"].(map[string]jsonSchema)["SpeciesItem"]
+	required := strings.Join(item["required"].([]string), ",")
+	if !strings.Contains(required, "canonical_name") || strings.Contains(required, "common_name") {
+		t.Errorf("required: got %s", required)
+	}
+	properties := item["properties"].(jsonSchema)
+	if got := properties["common_name"].(jsonSchema)["type"]; !reflect.DeepEqual(got, []string{"string", "null"}) {
+		t.Errorf("common_name type: got %v", got)
+	}
+
+	// Fields promoted from an embedded pointer are optional
+	explain, _ := responseSchema("RecommendExplainResponse")
+	def := explain["$defs"].(map[string]jsonSchema)["RecommendExplainResponse"]
+	if required := strings.Join(def["required"].([]string), ","); required != "explanations" {
+		t.Errorf("RecommendExplainResponse required: got %s", required)
+	}
+	var root map[string]interface{}
+	json.Unmarshal(encodedSchemas()["SpeciesItem"], &root)
+	bad := map[string]interface{}{"id": "1", "canonical_name": "A a", "undocumented": true}
+	if errs := validateSchema(root, root, bad, "SpeciesItem"); len(errs) < 3 {
+		t.Errorf("invalid item: got %v", errs)
+	}
+	if _, ok := responseSchema("NoSuchType"); ok {
+		t.Error("unknown schema published")
+	}
+}
+
+func TestSchemasHandler(t *testing.T) {
+	s := newTestServer()
+
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/schemas", nil))
+	var index struct {
+		Schemas []struct{ Name, URL string }
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &index); err != nil || len(index.Schemas) != len(responseSchemaTypes) {
+		t.Fatalf("index: got %d schemas, %v", len(index.Schemas), err)
+	}
+
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/schemas/RecommendResponse.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/schema+json" {
+		t.Errorf("schema: got %d, %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	for path, want := range map[string]int{"/api/schemas/Nope": http.StatusNotFound} {
+		w = httptest.NewRecorder()
+		s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: got %d, want %d", path, w.Code, want)
+		}
+	}
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/schemas", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %d", w.Code)
+	}
+}
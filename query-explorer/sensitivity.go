@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ============================================================================
+// CLIMATE THRESHOLD SENSITIVITY
+// ============================================================================
+
+// defaultSensitivityThresholds is the sweep used when a request gives none
+var defaultSensitivityThresholds = []float64{0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+
+const (
+	maxSensitivityThresholds = 20
+	defaultSensitivitySample = 20 // Subset size for metric estimates when n_species is 0
+)
+
+type SensitivityRequest struct {
+	RecommendRequest
+	Thresholds []float64 `json:"thresholds,omitempty"` // Default: 0.4 to 0.9 in steps of 0.1
+}
+
+type ThresholdSensitivity struct {
+	ClimateThreshold float64          `json:"climate_threshold"`
+	NCandidates      int              `json:"n_candidates"`
+	NFamilies        int              `json:"n_families"`
+	NGrowthForms     int              `json:"n_growth_forms"`
+	MeanClimateMatch float64          `json:"mean_climate_match"`
+	Sufficient       bool             `json:"sufficient"`        // Enough candidates for n_species
+	EstimatedMetrics DiversityMetrics `json:"estimated_metrics"` // Evenly spaced subset, no greedy selection
+}
+
+type SensitivityResponse struct {
+	LocationInfo      LocationInfo           `json:"location_info"`
+	NSpeciesRequested int                    `json:"n_species_requested"`
+	Thresholds        []ThresholdSensitivity `json:"thresholds"`
+	QueryTime         string                 `json:"query_time"`
+}
+
+// evenSample returns up to n species spread evenly over pool, which is in
+// descending climate order, so the sample covers the whole climate range
+func evenSample(pool []SpeciesRecommendation, n int) []SpeciesRecommendation {
+	if n <= 0 || n >= len(pool) {
+		return pool
+	}
+	sample := make([]SpeciesRecommendation, n)
+	step := float64(len(pool)) / float64(n)
+	for i := range sample {
+		sample[i] = pool[int(float64(i)*step)]
+	}
+	return sample
+}
+
+// handleRecommendSensitivity handles POST /api/recommend/sensitivity
+//
+// The candidate query runs once at the lowest threshold; each threshold is
+// then evaluated in memory on the subset whose climate match reaches it.
+func (s *Server) handleRecommendSensitivity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body SensitivityRequest
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	req := body.RecommendRequest
+
+	plugins, err := normalizeRecommendRequest(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	thresholds := body.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = defaultSensitivityThresholds
+	}
+	if len(thresholds) > maxSensitivityThresholds {
+		http.Error(w, fmt.Sprintf(`{"error": "at most %d thresholds"}`, maxSensitivityThresholds), http.StatusBadRequest)
+		return
+	}
+	for _, t := range thresholds {
+		if t < 0.3 || t > 1.0 {
+			http.Error(w, `{"error": "thresholds must be between 0.3 and 1.0"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	sorted := append([]float64{}, thresholds...)
+	sort.Float64s(sorted)
+
+	start := time.Now()
+
+	if err := s.fillSiteElevation(ctx, &req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := s.fillSiteSoil(ctx, &req); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	location, err := s.resolveLocation(ctx, req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "failed to resolve location: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	req.ClimateThreshold = sorted[0]
+	pool, _, err := s.getClimateAdaptedSpecies(ctx, location, req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "failed to get candidates: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	pool, err = plugins.filterCandidates(PluginContext{Ctx: ctx, DB: s.db, Request: req, Location: location}, pool)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	sampleSize := req.NSpecies
+	if sampleSize == 0 {
+		sampleSize = defaultSensitivitySample
+	}
+
+	// Subsets per threshold, then one trait query for all sampled species
+	subsets := make([][]SpeciesRecommendation, len(sorted))
+	samples := make([][]SpeciesRecommendation, len(sorted))
+	var sampled []SpeciesRecommendation
+	for i, t := range sorted {
+		for _, c := range pool {
+			if c.ClimateMatchScore >= t {
+				subsets[i] = append(subsets[i], c)
+			}
+		}
+		samples[i] = evenSample(subsets[i], sampleSize)
+		sampled = append(sampled, samples[i]...)
+	}
+
+	traits, err := s.loadTraitVectors(ctx, sampled, req.Preferences.IncludeFlaggedTraits)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "failed to load traits: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	s.localizeLocation(ctx, &location, requestLanguage(r))
+	resp := SensitivityResponse{
+		LocationInfo:      location,
+		NSpeciesRequested: req.NSpecies,
+		Thresholds:        make([]ThresholdSensitivity, len(sorted)),
+	}
+	for i, t := range sorted {
+		subset := subsets[i]
+		families := map[string]bool{}
+		gforms := map[string]bool{}
+		climateSum := 0.0
+		for _, c := range subset {
+			families[c.Family] = true
+			gforms[c.GrowthForm] = true
+			climateSum += c.ClimateMatchScore
+		}
+
+		ts := ThresholdSensitivity{
+			ClimateThreshold: t,
+			NCandidates:      len(subset),
+			NFamilies:        len(families),
+			NGrowthForms:     len(gforms),
+			Sufficient:       len(subset) > 0 && len(subset) >= req.NSpecies,
+			EstimatedMetrics: calculateDiversityMetrics(samples[i], traits, req.metricWeights()),
+		}
+		if len(subset) > 0 {
+			ts.MeanClimateMatch = math.Round(climateSum/float64(len(subset))*1000) / 1000
+		}
+		resp.Thresholds[i] = ts
+	}
+
+	resp.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(resp)
+}
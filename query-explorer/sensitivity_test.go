@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecommendSensitivityValidation(t *testing.T) {
+	s := newTestServer()
+	tooMany := strings.TrimSuffix(strings.Repeat("0.5, ", maxSensitivityThresholds+1), ", ")
+	for _, tc := range []struct{ thresholds, want string }{
+		{"[0.2, 0.6]", "thresholds must be between 0.3 and 1.0"},
+		{"[0.6, 1.1]", "thresholds must be between 0.3 and 1.0"},
+		{"[" + tooMany + "]", "at most 20 thresholds"},
+	} {
+		req := httptest.NewRequest("POST", "/api/recommend/sensitivity",
+			strings.NewReader(`{"tdwg_code": "BZS", "thresholds": `+tc.thresholds+`}`))
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: %d %s, want 400 %q", tc.thresholds, w.Code, w.Body.String(), tc.want)
+		}
+	}
+}
+
+func TestRecommendSensitivityCounts(t *testing.T) {
+	s, db := newFakeDBServer(t,
+		fakeQuery{
+			match:   "c.bio1_mean, c.bio5_mean",
+			columns: []string{"tdwg_code", "level3_name", "bio1", "bio5", "bio6", "bio12", "bio15"},
+			rows:    [][]driver.Value{{"BZS", "Brazil South", 18.0, 28.0, 8.0, 1500.0, 30.0}},
+		},
+		fakeQuery{
+			match:   "as climate_match_score",
+			columns: make([]string, 21),
+			rows: [][]driver.Value{
+				fakeCandidateRow(1, "Inga edulis", "Fabaceae", "tree", 0.95),
+				fakeCandidateRow(2, "Eugenia uniflora", "Myrtaceae", "tree", 0.85),
+				fakeCandidateRow(3, "Mimosa scabrella", "Fabaceae", "shrub", 0.75),
+				fakeCandidateRow(4, "Ocotea porosa", "Lauraceae", "tree", 0.55),
+			},
+		},
+		fakeQuery{match: "FROM species_trait_vectors", columns: make([]string, 12)},
+		fakeQuery{match: "FROM localized_names", columns: []string{"kind", "code", "language", "name"}},
+	)
+	req := httptest.NewRequest("POST", "/api/recommend/sensitivity",
+		strings.NewReader(`{"tdwg_code": "BZS", "n_species": 2, "thresholds": [0.8, 0.5, 0.9]}`))
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+
+	// One candidate query, at the lowest threshold
+	if candidates := db.executed("as climate_match_score"); len(candidates) != 1 {
+		t.Errorf("%d candidate queries, want 1", len(candidates))
+	}
+	if args := db.argsOf("as climate_match_score"); len(args) < 7 || args[6] != 0.5 {
+		t.Errorf("candidate query args %v, want climate threshold 0.5", args)
+	}
+
+	var resp SensitivityResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []ThresholdSensitivity{
+		{ClimateThreshold: 0.5, NCandidates: 4, NFamilies: 3, NGrowthForms: 2, MeanClimateMatch: 0.775, Sufficient: true},
+		{ClimateThreshold: 0.8, NCandidates: 2, NFamilies: 2, NGrowthForms: 1, MeanClimateMatch: 0.9, Sufficient: true},
+		{ClimateThreshold: 0.9, NCandidates: 1, NFamilies: 1, NGrowthForms: 1, MeanClimateMatch: 0.95, Sufficient: false},
+	}
+	if resp.NSpeciesRequested != 2 || len(resp.Thresholds) != len(want) {
+		t.Fatalf("response %+v", resp)
+	}
+	for i, got := range resp.Thresholds {
+		got.EstimatedMetrics = DiversityMetrics{}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("threshold %d: %+v, want %+v", i, got, want[i])
+		}
+	}
+	if n := resp.Thresholds[0].EstimatedMetrics.NSpecies; n != 2 {
+		t.Errorf("estimate at 0.5 from %d species, want a sample of n_species", n)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// ============================================================================
+// SERVER
+// ============================================================================
+//
+// Server holds what the handlers share: the database, the configuration,
+// the logger, and the caches and background job state built on them.
+// Handlers and the jobs are its methods, so a test, a read replica or a
+// tenant's own database gets its own Server instead of swapping package
+// globals, and servers do not see each other's caches.
+
+type Server struct {
+	db         *sql.DB
+	connString string // For COPY exports, which hold their own connection
+	cfg        Config
+	log        *log.Logger
+
+	names         localizedNameCache
+	inat          inatCache
+	inatURL       string
+	jobs          queryJobQueue
+	exports       queryJobQueue // Spatial export jobs
+	sandboxes     sandboxStore
+	rateLimitKeys rateLimitKeyCache
+	upstreams     *upstreamPool // Behind /diversiplant/, for /api/health; set by routes
+	breaker       *loadBreaker
+	stats         statsCache
+
+	dataQualityMu   sync.Mutex
+	geometryCacheMu sync.Mutex
+	envelopeMu      sync.Mutex
+	retentionMu     sync.Mutex
+	datasetMu       sync.Mutex
+	taxonomyMu      sync.Mutex
+	rescore         rescoreState
+}
+
+// NewServer builds a server on db; background jobs are started separately
+// (see main)
+func NewServer(db *sql.DB, cfg Config) *Server {
+	return &Server{
+		db:            db,
+		connString:    cfg.dbConnString(),
+		cfg:           cfg,
+		log:           log.New(os.Stderr, "", log.LstdFlags),
+		inat:          inatCache{entries: map[string]inatCacheEntry{}},
+		inatURL:       defaultINatAPIURL,
+		jobs:          queryJobQueue{wake: make(chan struct{}, 1), running: map[string]context.CancelFunc{}},
+		exports:       queryJobQueue{wake: make(chan struct{}, 1), running: map[string]context.CancelFunc{}},
+		sandboxes:     sandboxStore{byID: map[string]*recommendSandbox{}},
+		rateLimitKeys: rateLimitKeyCache{entries: map[string]rateLimitKey{}},
+		breaker:       newLoadBreaker(cfg.LoadShedding),
+	}
+}
+
+// router registers the handlers of routeRegistry on the router (router.go)
+func (s *Server) router() *router {
+	mux := newRouter(s.cfg.TrailingSlash)
+	for _, rt := range routeRegistry() {
+		if rt.Fallback {
+			mux.HandleFallback(rt.Pattern, rt.Methods, rt.serve(s))
+		} else {
+			mux.Handle(rt.Pattern, rt.Methods, rt.serve(s))
+		}
+	}
+	return mux
+}
+
+// routes wraps the router in the middleware
+func (s *Server) routes() http.Handler {
+	mux := s.router()
+	return corsMiddleware(mux.preflight(s.demoMiddleware(s.rateLimitMiddleware(s.loadSheddingMiddleware(statementTimeoutMiddleware(mux, s.cfg.StatementTimeouts)), s.cfg.RateLimits), s.cfg.Demo)))
+}
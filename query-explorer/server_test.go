@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServer builds a server without a database, for code that returns
+// before querying
+func newTestServer() *Server {
+	return NewServer(nil, getConfig())
+}
+
+func TestServerRoutes(t *testing.T) {
+	t.Parallel()
+	handler := newTestServer().routes()
+
+	// Rejected before any query, so no database is needed
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/api/climate/analogs", http.StatusBadRequest},
+		{"POST", "/api/climate/analogs", http.StatusMethodNotAllowed},
+		{"OPTIONS", "/api/species", http.StatusOK},
+		{"HEAD", "/api/climate/analogs", http.StatusBadRequest},
+		{"PUT", "/api/species", http.StatusMethodNotAllowed},
+		{"GET", "/api/climate/analogs/", http.StatusPermanentRedirect},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, rec.Code, tc.code)
+		}
+	}
+}
+
+func TestServersDoNotShareCaches(t *testing.T) {
+	t.Parallel()
+	a, b := newTestServer(), newTestServer()
+	a.inat.entries["k"] = inatCacheEntry{}
+	if _, ok := b.inat.entries["k"]; ok {
+		t.Error("inat cache shared between servers")
+	}
+	if a.jobs.wake == b.jobs.wake {
+		t.Error("query job queue shared between servers")
+	}
+}
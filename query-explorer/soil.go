@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// SOIL
+// ============================================================================
+//
+// GET /api/soil?lat=-27.6&lon=-48.65 reads SoilGrids (soil_raster, migration
+// 042) at a point: pH, sand/silt/clay and organic carbon, 0-30 cm means. The
+// USDA texture class follows from the fractions, and its texture group
+// (light, medium, heavy, organic) is what species tolerances are given in.
+//
+// Preferences.SoilMatch = "filter" drops species whose soil tolerance
+// (species_soil_tolerance, from EcoCrop) excludes the site: pH outside the
+// species' absolute range, or a texture group it does not grow on. Species
+// without a known tolerance are kept. The site soil is soil_ph and
+// soil_texture, or else SoilGrids at latitude/longitude.
+
+const (
+	soilDepth = "0-30cm"
+
+	// Organic carbon from which a soil counts as organic (about 20% organic
+	// matter, the histic threshold)
+	organicSoilCarbonGKg = 120.0
+)
+
+var soilTextureGroups = map[string]bool{"light": true, "medium": true, "heavy": true, "organic": true}
+
+// errNoSoil reports a point SoilGrids does not cover (water, ice, cities)
+var errNoSoil = errors.New("no soil data at this location")
+
+type SoilResponse struct {
+	Latitude         float64  `json:"lat"`
+	Longitude        float64  `json:"lon"`
+	Depth            string   `json:"depth"`
+	PH               *float64 `json:"ph"`
+	SandPct          *float64 `json:"sand_pct"`
+	SiltPct          *float64 `json:"silt_pct"`
+	ClayPct          *float64 `json:"clay_pct"`
+	OrganicCarbonGKg *float64 `json:"organic_carbon_g_kg"`
+	TextureClass     string   `json:"texture_class,omitempty"` // USDA, e.g. "sandy loam"
+	TextureGroup     string   `json:"texture_group,omitempty"` // light, medium, heavy, organic
+	Source           string   `json:"source"`
+	QueryTime        string   `json:"query_time,omitempty"`
+}
+
+// usdaTextureClass classifies a soil in the USDA texture triangle; the
+// fractions are normalized to sum to 100
+func usdaTextureClass(sand, silt, clay float64) string {
+	total := sand + silt + clay
+	if total <= 0 {
+		return ""
+	}
+	sand, silt, clay = sand*100/total, silt*100/total, clay*100/total
+
+	switch {
+	case silt+1.5*clay < 15:
+		return "sand"
+	case silt+2*clay < 30:
+		return "loamy sand"
+	case clay >= 40 && silt >= 40:
+		return "silty clay"
+	case clay >= 40 && sand <= 45:
+		return "clay"
+	case clay >= 35 && sand > 45:
+		return "sandy clay"
+	case clay >= 27 && sand <= 20:
+		return "silty clay loam"
+	case clay >= 27 && sand <= 45:
+		return "clay loam"
+	case clay >= 20 && silt < 28 && sand > 45:
+		return "sandy clay loam"
+	case silt >= 80 && clay < 12:
+		return "silt"
+	case silt >= 50:
+		return "silt loam"
+	case clay >= 7 && silt >= 28 && sand <= 52:
+		return "loam"
+	default:
+		return "sandy loam"
+	}
+}
+
+// soilTextureGroup maps a USDA class to the grouping of species tolerances
+func soilTextureGroup(class string, organicCarbonGKg *float64) string {
+	if organicCarbonGKg != nil && *organicCarbonGKg >= organicSoilCarbonGKg {
+		return "organic"
+	}
+	switch class {
+	case "sand", "loamy sand", "sandy loam":
+		return "light"
+	case "sandy clay", "silty clay", "clay":
+		return "heavy"
+	case "":
+		return ""
+	}
+	return "medium"
+}
+
+// sampleSoil reads the soil properties at a point
+func (s *Server) sampleSoil(ctx context.Context, lat, lon float64) (*SoilResponse, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH p AS (SELECT ST_SetSRID(ST_MakePoint($2, $1), 4326) AS pt)
+		SELECT r.property, ST_Value(r.rast, 1, p.pt, true)
+		FROM soil_raster r, p
+		WHERE r.depth = $3 AND ST_Intersects(r.rast, p.pt)
+	`, lat, lon, soilDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	soil := &SoilResponse{Latitude: lat, Longitude: lon, Depth: soilDepth, Source: "soilgrids"}
+	found := false
+	for rows.Next() {
+		var property string
+		var value *float64
+		if err := rows.Scan(&property, &value); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		v := math.Round(*value*10) / 10
+		switch property {
+		case "phh2o":
+			soil.PH = &v
+		case "sand":
+			soil.SandPct = &v
+		case "silt":
+			soil.SiltPct = &v
+		case "clay":
+			soil.ClayPct = &v
+		case "soc":
+			soil.OrganicCarbonGKg = &v
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errNoSoil
+	}
+	if soil.SandPct != nil && soil.SiltPct != nil && soil.ClayPct != nil {
+		soil.TextureClass = usdaTextureClass(*soil.SandPct, *soil.SiltPct, *soil.ClayPct)
+	}
+	soil.TextureGroup = soilTextureGroup(soil.TextureClass, soil.OrganicCarbonGKg)
+	return soil, nil
+}
+
+// validateSoilMatch checks soil_match and the site soil values
+func validateSoilMatch(req *RecommendRequest) error {
+	switch req.Preferences.SoilMatch {
+	case "":
+		return nil
+	case "filter":
+	default:
+		return fmt.Errorf("invalid soil_match: %s (use filter)", req.Preferences.SoilMatch)
+	}
+	if req.SoilPH != nil && (*req.SoilPH < 3 || *req.SoilPH > 11) {
+		return fmt.Errorf("soil_ph must be between 3 and 11")
+	}
+	req.SoilTexture = strings.ToLower(strings.TrimSpace(req.SoilTexture))
+	if req.SoilTexture != "" && !soilTextureGroups[req.SoilTexture] {
+		return fmt.Errorf("invalid soil_texture: %s (use light, medium, heavy or organic)", req.SoilTexture)
+	}
+	if req.SoilPH == nil && req.SoilTexture == "" && (req.Latitude == nil || req.Longitude == nil) {
+		return fmt.Errorf("soil_match requires soil_ph, soil_texture or latitude/longitude")
+	}
+	return nil
+}
+
+// fillSiteSoil completes soil_ph and soil_texture from SoilGrids when
+// soil_match is on and the request has coordinates
+func (s *Server) fillSiteSoil(ctx context.Context, req *RecommendRequest) error {
+	if req.Preferences.SoilMatch == "" || (req.SoilPH != nil && req.SoilTexture != "") {
+		return nil
+	}
+	if req.Latitude == nil || req.Longitude == nil {
+		return nil
+	}
+	soil, err := s.sampleSoil(ctx, *req.Latitude, *req.Longitude)
+	if err != nil && (req.SoilPH == nil && req.SoilTexture == "") {
+		return fmt.Errorf("soil_match without soil_ph or soil_texture: %w", err)
+	}
+	if err != nil {
+		return nil
+	}
+	if req.SoilPH == nil {
+		req.SoilPH = soil.PH
+	}
+	if req.SoilTexture == "" {
+		req.SoilTexture = soil.TextureGroup
+	}
+	return nil
+}
+
+// soilFilterSQL returns the candidate clause for soil_match (empty when
+// unset) and its arguments, bound from $n
+func soilFilterSQL(req RecommendRequest, n int) (string, []interface{}) {
+	if req.Preferences.SoilMatch == "" {
+		return "", nil
+	}
+	var conds []string
+	var args []interface{}
+	if req.SoilPH != nil {
+		conds = append(conds, fmt.Sprintf("$%d::numeric NOT BETWEEN COALESCE(sst.ph_min, 0) AND COALESCE(sst.ph_max, 14)", n))
+		args = append(args, *req.SoilPH)
+		n++
+	}
+	if req.SoilTexture != "" {
+		conds = append(conds, fmt.Sprintf("(sst.textures IS NOT NULL AND NOT $%d = ANY(sst.textures))", n))
+		args = append(args, req.SoilTexture)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(`AND NOT EXISTS (
+		    SELECT 1 FROM species_soil_tolerance sst
+		    WHERE sst.species_id = s.id AND (%s))`, strings.Join(conds, " OR ")), args
+}
+
+// handleSoil handles GET /api/soil
+func (s *Server) handleSoil(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.resolveAOIQuery(r); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	lat, errLat := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lon, errLon := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		http.Error(w, `{"error": "Provide valid lat and lon parameters"}`, http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	soil, err := s.sampleSoil(ctx, lat, lon)
+	if err == errNoSoil {
+		http.Error(w, `{"error": "No soil data at this location (water, ice or outside coverage)"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.Printf("Error reading soil at %.5f,%.5f: %v", lat, lon, err)
+		http.Error(w, `{"error": "Soil rasters not loaded or unreadable"}`, http.StatusNotFound)
+		return
+	}
+	soil.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(soil)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUSDATextureClass(t *testing.T) {
+	tests := []struct {
+		sand, silt, clay float64
+		class, group     string
+	}{
+		{92, 5, 3, "sand", "light"},
+		{82, 12, 6, "loamy sand", "light"},
+		{65, 25, 10, "sandy loam", "light"},
+		{40, 40, 20, "loam", "medium"},
+		{20, 65, 15, "silt loam", "medium"},
+		{5, 88, 7, "silt", "medium"},
+		{60, 13, 27, "sandy clay loam", "medium"},
+		{33, 33, 34, "clay loam", "medium"},
+		{10, 58, 32, "silty clay loam", "medium"},
+		{50, 8, 42, "sandy clay", "heavy"},
+		{6, 47, 47, "silty clay", "heavy"},
+		{20, 20, 60, "clay", "heavy"},
+		// Fractions that do not sum to 100 are normalized
+		{46, 46, 23, "loam", "medium"},
+	}
+	for _, tc := range tests {
+		class := usdaTextureClass(tc.sand, tc.silt, tc.clay)
+		if class != tc.class {
+			t.Errorf("%v/%v/%v: class %q, want %q", tc.sand, tc.silt, tc.clay, class, tc.class)
+		}
+		if group := soilTextureGroup(class, nil); group != tc.group {
+			t.Errorf("%s: group %q, want %q", class, group, tc.group)
+		}
+	}
+
+	if got := usdaTextureClass(0, 0, 0); got != "" {
+		t.Errorf("empty soil: class %q", got)
+	}
+	peat := 250.0
+	if got := soilTextureGroup("sandy loam", &peat); got != "organic" {
+		t.Errorf("high carbon: group %q, want organic", got)
+	}
+}
+
+func TestValidateSoilMatch(t *testing.T) {
+	lat, lon := -27.6, -48.65
+	ph, acid := 6.0, 1.5
+	tests := []struct {
+		name string
+		req  RecommendRequest
+		ok   bool
+	}{
+		{"off", RecommendRequest{}, true},
+		{"coordinates", RecommendRequest{Latitude: &lat, Longitude: &lon, Preferences: Preferences{SoilMatch: "filter"}}, true},
+		{"explicit", RecommendRequest{TDWGCode: "BZS", SoilPH: &ph, SoilTexture: " Heavy ", Preferences: Preferences{SoilMatch: "filter"}}, true},
+		{"no site soil", RecommendRequest{TDWGCode: "BZS", Preferences: Preferences{SoilMatch: "filter"}}, false},
+		{"bad mode", RecommendRequest{SoilPH: &ph, Preferences: Preferences{SoilMatch: "score"}}, false},
+		{"bad ph", RecommendRequest{SoilPH: &acid, Preferences: Preferences{SoilMatch: "filter"}}, false},
+		{"bad texture", RecommendRequest{SoilTexture: "loam", Preferences: Preferences{SoilMatch: "filter"}}, false},
+	}
+	for _, tc := range tests {
+		err := validateSoilMatch(&tc.req)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v, want ok=%v", tc.name, err, tc.ok)
+		}
+		if tc.ok && tc.req.SoilTexture != strings.ToLower(strings.TrimSpace(tc.req.SoilTexture)) {
+			t.Errorf("%s: texture not normalized: %q", tc.name, tc.req.SoilTexture)
+		}
+	}
+}
+
+func TestSoilFilterSQL(t *testing.T) {
+	if clause, args := soilFilterSQL(RecommendRequest{}, 9); clause != "" || args != nil {
+		t.Errorf("soil_match off: %q %v", clause, args)
+	}
+
+	ph := 5.2
+	req := RecommendRequest{SoilPH: &ph, SoilTexture: "heavy", Preferences: Preferences{SoilMatch: "filter"}}
+	clause, args := soilFilterSQL(req, 9)
+	if !strings.Contains(clause, "$9::numeric") || !strings.Contains(clause, "$10 = ANY(sst.textures)") {
+		t.Errorf("placeholders: %s", clause)
+	}
+	if len(args) != 2 || args[0] != 5.2 || args[1] != "heavy" {
+		t.Errorf("args: %v", args)
+	}
+
+	req.SoilPH = nil
+	clause, args = soilFilterSQL(req, 9)
+	if strings.Contains(clause, "numeric") || !strings.Contains(clause, "$9 = ANY") || len(args) != 1 {
+		t.Errorf("texture only: %s %v", clause, args)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// ============================================================================
+// SOURCE COVERAGE
+// ============================================================================
+//
+// GET /api/sources/{name}/coverage counts, per TDWG level-3 region, the
+// species recorded there (species_regions) whose growth form, threat status
+// or lifespan came from the source, next to all species recorded there.
+// Mapping share shows where a source's data is thin, e.g. GIFT growth forms
+// concentrated in a few continents, so acquisition can target those gaps.
+//
+// format=geojson (default) is a FeatureCollection with the region polygons
+// simplified for zoom (see geometry_cache.go); format=json has the same
+// properties without geometries, for joining onto TDWG vector tiles by
+// tdwg_code. Properties are flat numbers and strings either way, so the
+// GeoJSON also feeds tippecanoe or ogr2ogr directly.
+
+const defaultCoverageZoom = 3
+
+type SourceCoverageRegion struct {
+	TDWGCode string   `json:"tdwg_code"`
+	Name     string   `json:"name"`
+	NSource  int64    `json:"n_source"`  // Species with a value from the source
+	NSpecies int64    `json:"n_species"` // All species recorded in the region
+	Share    *float64 `json:"share"`     // n_source / n_species; null without species
+}
+
+type geoJSONFeature struct {
+	Type       string               `json:"type"`
+	ID         string               `json:"id"`
+	Properties SourceCoverageRegion `json:"properties"`
+	Geometry   json.RawMessage      `json:"geometry"`
+}
+
+// coverageShare is n/total rounded to three decimals, nil for an empty region
+func coverageShare(n, total int64) *float64 {
+	if total == 0 {
+		return nil
+	}
+	share := math.Round(float64(n)/float64(total)*1000) / 1000
+	return &share
+}
+
+// handleSourceCoverage handles GET /api/sources/{name}/coverage
+func (s *Server) handleSourceCoverage(w http.ResponseWriter, r *http.Request, f sourceFilter) {
+	ctx := r.Context()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "geojson"
+	}
+	if format != "geojson" && format != "json" {
+		http.Error(w, `{"error": "format must be geojson or json"}`, http.StatusBadRequest)
+		return
+	}
+	zoom := defaultCoverageZoom
+	if v := r.URL.Query().Get("zoom"); v != "" {
+		z, err := strconv.Atoi(v)
+		if err != nil || z < 0 || z > 22 {
+			http.Error(w, `{"error": "zoom must be between 0 and 22"}`, http.StatusBadRequest)
+			return
+		}
+		zoom = z
+	}
+	if !s.requireKnownSource(w, r, f) {
+		return
+	}
+
+	geometryJoin, geometrySelect := "", ""
+	if format == "geojson" {
+		join, expr := simplifiedGeometry("tdwg_level3", "t", zoom)
+		geometryJoin, geometrySelect = join, fmt.Sprintf(", ST_AsGeoJSON(%s, 5)", expr)
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		WITH counts AS (
+			SELECT sr.tdwg_code,
+			       COUNT(DISTINCT sr.species_id) FILTER (WHERE %s) AS n_source,
+			       COUNT(DISTINCT sr.species_id) AS n_species
+			FROM species_regions sr
+			JOIN species_unified su ON su.species_id = sr.species_id
+			GROUP BY sr.tdwg_code
+		)
+		SELECT t.level3_code, COALESCE(t.level3_name, ''),
+		       COALESCE(c.n_source, 0), COALESCE(c.n_species, 0)%s
+		FROM tdwg_level3 t
+		LEFT JOIN counts c ON c.tdwg_code = t.level3_code
+		%s
+		WHERE t.geom IS NOT NULL
+		ORDER BY t.level3_code
+	`, f.condition(1), geometrySelect, geometryJoin), f.Source)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lang := requestLanguage(r)
+	regions := []SourceCoverageRegion{}
+	features := []geoJSONFeature{}
+	for rows.Next() {
+		var region SourceCoverageRegion
+		var geometry string
+		dest := []interface{}{&region.TDWGCode, &region.Name, &region.NSource, &region.NSpecies}
+		if format == "geojson" {
+			dest = append(dest, &geometry)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			s.log.Printf("Error scanning source coverage row: %v", err)
+			continue
+		}
+		region.Name = s.localize(ctx, nameKindTDWG, region.TDWGCode, lang, region.Name)
+		region.Share = coverageShare(region.NSource, region.NSpecies)
+		if format == "geojson" {
+			features = append(features, geoJSONFeature{
+				Type: "Feature", ID: region.TDWGCode, Properties: region, Geometry: json.RawMessage(geometry),
+			})
+		} else {
+			regions = append(regions, region)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	setContentLanguage(w, lang)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if format == "geojson" {
+		w.Header().Set("Content-Type", "application/geo+json")
+		writeCompressedJSON(w, r, map[string]interface{}{
+			"type":      "FeatureCollection",
+			"source":    f.Source,
+			"attribute": f.Attribute,
+			"features":  features,
+		})
+		return
+	}
+	writeCompressedJSON(w, r, map[string]interface{}{
+		"source":    f.Source,
+		"attribute": f.Attribute,
+		"regions":   regions,
+	})
+}
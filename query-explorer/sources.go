@@ -0,0 +1,368 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// DATA SOURCES
+// ============================================================================
+//
+// /api/sources summarizes which source supplied each species' growth form,
+// threat status and lifespan. The counts come from source_summary (migration
+// 043), rebuilt by refresh_source_summary() every night at SOURCE_SUMMARY_AT
+// (03:00, server time zone), so the endpoint no longer aggregates
+// species_unified per call.
+// Curators drill down with /api/sources/{name}/species, a paginated list of
+// the species a source contributed (see pagination.go for the parameters),
+// and /api/sources/{name}/coverage, its species per TDWG region (see
+// source_coverage.go).
+//
+// The responses are gzip-compressed for clients that accept it; drill-down
+// pages and coverage maps of the larger sources run to hundreds of kilobytes.
+
+var sourceAttributes = map[string]string{
+	"growth_form":   "su.growth_form_source",
+	"threat_status": "su.threat_status_source",
+	"lifespan":      "su.lifespan_source",
+}
+
+var sourceSpeciesList = listSpec{
+	DefaultLimit: 100,
+	MaxLimit:     500,
+	Sorts: map[string]string{
+		"name":   "s.canonical_name",
+		"family": "COALESCE(s.family, '')",
+	},
+	DefaultSort:   "name",
+	IDColumn:      "s.id",
+	SearchColumns: []string{"s.canonical_name", "s.family"},
+}
+
+type GrowthFormStats struct {
+	Source   string `json:"source"`
+	Total    int64  `json:"total"`
+	Trees    int64  `json:"trees"`
+	Shrubs   int64  `json:"shrubs"`
+	Herbs    int64  `json:"herbs"`
+	Climbers int64  `json:"climbers"`
+	Palms    int64  `json:"palms"`
+}
+
+type ThreatStats struct {
+	Source string `json:"source"`
+	Total  int64  `json:"total"`
+	CR     int64  `json:"cr"`
+	EN     int64  `json:"en"`
+	VU     int64  `json:"vu"`
+	NT     int64  `json:"nt"`
+	LC     int64  `json:"lc"`
+}
+
+type LifespanStats struct {
+	Source      string   `json:"source"`
+	Total       int64    `json:"total"`
+	AvgLifespan *float64 `json:"avg_lifespan"`
+	MinLifespan *float64 `json:"min_lifespan"`
+	MaxLifespan *float64 `json:"max_lifespan"`
+}
+
+type AllSourcesResponse struct {
+	GrowthForm  []GrowthFormStats `json:"growth_form"`
+	Threat      []ThreatStats     `json:"threat_status"`
+	Lifespan    []LifespanStats   `json:"lifespan"`
+	RefreshedAt *string           `json:"refreshed_at"` // null until the first refresh
+}
+
+type SourceSpecies struct {
+	SpeciesID     int64    `json:"species_id"`
+	CanonicalName string   `json:"canonical_name"`
+	Family        string   `json:"family"`
+	Attributes    []string `json:"attributes"` // Which of the species' values came from the source
+	GrowthForm    *string  `json:"growth_form,omitempty"`
+	ThreatStatus  *string  `json:"threat_status,omitempty"`
+	LifespanYears *float64 `json:"lifespan_years,omitempty"`
+}
+
+// acceptsGzip reports whether the client takes gzip responses
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// gzip;q=0 refuses it
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(v, 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// writeCompressedJSON encodes v, gzipped when the client accepts it
+func writeCompressedJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	json.NewEncoder(gz).Encode(v)
+}
+
+// refreshSourceSummary rebuilds source_summary
+func (s *Server) refreshSourceSummary(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT refresh_source_summary()`).Scan(&n)
+	return n, err
+}
+
+const defaultSourceSummaryAt = "03:00"
+
+// loadSourceSummaryAt reads SOURCE_SUMMARY_AT, the time of day of the
+// refresh, or "off"
+func loadSourceSummaryAt() string {
+	value := getEnv("SOURCE_SUMMARY_AT", defaultSourceSummaryAt)
+	if _, err := time.Parse("15:04", value); err != nil && value != "off" {
+		log.Printf("Invalid SOURCE_SUMMARY_AT=%q, using %s", value, defaultSourceSummaryAt)
+		return defaultSourceSummaryAt
+	}
+	return value
+}
+
+// nextDailyRun is the first time after now at the time of day at (a valid
+// HH:MM), in now's location
+func nextDailyRun(now time.Time, at string) time.Time {
+	t, _ := time.Parse("15:04", at)
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// startSourceSummaryJob refreshes the summary every day at at, and at
+// startup if the last refresh is over a day old (or never happened)
+func (s *Server) startSourceSummaryJob(at string) {
+	if at == "off" {
+		return
+	}
+
+	run := func() {
+		start := time.Now()
+		n, err := s.refreshSourceSummary(context.Background())
+		if err != nil {
+			s.log.Printf("Source summary refresh failed: %v", err)
+			return
+		}
+		s.log.Printf("Source summary refresh: %d rows (%s)", n, time.Since(start))
+	}
+
+	go func() {
+		var stale bool
+		err := s.db.QueryRow(`
+			SELECT COALESCE(MAX(refreshed_at) < NOW() - INTERVAL '1 day', TRUE) FROM source_summary
+		`).Scan(&stale)
+		if err == nil && stale {
+			run()
+		}
+		for {
+			time.Sleep(time.Until(nextDailyRun(time.Now(), at)))
+			run()
+		}
+	}()
+	s.log.Printf("Source summary job scheduled daily at %s", at)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleSources handles GET /api/sources
+func (s *Server) handleSources(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT attribute, source, total,
+		       COALESCE(trees, 0), COALESCE(shrubs, 0), COALESCE(herbs, 0), COALESCE(climbers, 0), COALESCE(palms, 0),
+		       COALESCE(cr, 0), COALESCE(en, 0), COALESCE(vu, 0), COALESCE(nt, 0), COALESCE(lc, 0),
+		       avg_lifespan, min_lifespan, max_lifespan,
+		       TO_CHAR(refreshed_at, 'YYYY-MM-DD"T"HH24:MI:SS')
+		FROM source_summary
+		ORDER BY attribute, total DESC, source
+	`)
+	if err != nil {
+		s.log.Printf("Error reading source summary: %v", err)
+		http.Error(w, `{"error": "Source summary unavailable"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := AllSourcesResponse{GrowthForm: []GrowthFormStats{}, Threat: []ThreatStats{}, Lifespan: []LifespanStats{}}
+	for rows.Next() {
+		var attribute, refreshedAt string
+		var g GrowthFormStats
+		var t ThreatStats
+		var l LifespanStats
+		if err := rows.Scan(&attribute, &g.Source, &g.Total,
+			&g.Trees, &g.Shrubs, &g.Herbs, &g.Climbers, &g.Palms,
+			&t.CR, &t.EN, &t.VU, &t.NT, &t.LC,
+			&l.AvgLifespan, &l.MinLifespan, &l.MaxLifespan, &refreshedAt); err != nil {
+			s.log.Printf("Error scanning source summary row: %v", err)
+			continue
+		}
+		switch attribute {
+		case "growth_form":
+			resp.GrowthForm = append(resp.GrowthForm, g)
+		case "threat_status":
+			t.Source, t.Total = g.Source, g.Total
+			resp.Threat = append(resp.Threat, t)
+		case "lifespan":
+			l.Source, l.Total = g.Source, g.Total
+			resp.Lifespan = append(resp.Lifespan, l)
+		}
+		if resp.RefreshedAt == nil || refreshedAt > *resp.RefreshedAt {
+			resp.RefreshedAt = &refreshedAt
+		}
+	}
+
+	writeCompressedJSON(w, r, resp)
+}
+
+// sourceFilter selects the species_unified rows a source contributed to,
+// in one attribute or, when Attribute is empty, any of them
+type sourceFilter struct {
+	Source    string
+	Attribute string
+}
+
+// condition is the SQL test on species_unified su, with the source bound
+// to $n
+func (f sourceFilter) condition(n int) string {
+	var conds []string
+	for _, name := range []string{"growth_form", "threat_status", "lifespan"} {
+		if f.Attribute == "" || f.Attribute == name {
+			conds = append(conds, fmt.Sprintf("%s = $%d", sourceAttributes[name], n))
+		}
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+// sourceItem adapts a handler of one source to the /api/sources/{name}
+// routes, optionally limited to one attribute (?attribute=growth_form)
+func sourceItem(handle func(*Server, http.ResponseWriter, *http.Request, sourceFilter)) func(*Server, http.ResponseWriter, *http.Request) {
+	return func(s *Server, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		f := sourceFilter{Source: pathParam(r, "name"), Attribute: r.URL.Query().Get("attribute")}
+		if _, ok := sourceAttributes[f.Attribute]; f.Attribute != "" && !ok {
+			http.Error(w, `{"error": "attribute must be growth_form, threat_status or lifespan"}`, http.StatusBadRequest)
+			return
+		}
+		handle(s, w, r, f)
+	}
+}
+
+// requireKnownSource answers 404 unless the summary lists the source (for
+// the attribute), and reports whether it does
+func (s *Server) requireKnownSource(w http.ResponseWriter, r *http.Request, f sourceFilter) bool {
+	var known bool
+	err := s.db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM source_summary WHERE source = $1 AND ($2 = '' OR attribute = $2))
+	`, f.Source, f.Attribute).Scan(&known)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return false
+	}
+	if !known {
+		http.Error(w, fmt.Sprintf(`{"error": "Unknown source: %s"}`, f.Source), http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// handleSourceSpecies handles GET /api/sources/{name}/species
+func (s *Server) handleSourceSpecies(w http.ResponseWriter, r *http.Request, f sourceFilter) {
+	ctx := r.Context()
+
+	p, err := parseListParams(r, sourceSpeciesList)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	if !s.requireKnownSource(w, r, f) {
+		return
+	}
+
+	where, tail, args := p.SQL([]interface{}{f.Source})
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.canonical_name, COALESCE(s.family, ''),
+		       su.growth_form, su.threat_status, su.lifespan_years,
+		       su.growth_form_source IS NOT DISTINCT FROM $1,
+		       su.threat_status_source IS NOT DISTINCT FROM $1,
+		       su.lifespan_source IS NOT DISTINCT FROM $1,
+		       `+p.CursorColumn()+`
+		FROM species_unified su
+		JOIN species s ON s.id = su.species_id
+		WHERE `+f.condition(1)+where+`
+		`+tail, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	species := []SourceSpecies{}
+	var keys []listKey
+	for rows.Next() {
+		var sp SourceSpecies
+		var fromGrowthForm, fromThreat, fromLifespan bool
+		var cursorValue string
+		if err := rows.Scan(&sp.SpeciesID, &sp.CanonicalName, &sp.Family,
+			&sp.GrowthForm, &sp.ThreatStatus, &sp.LifespanYears,
+			&fromGrowthForm, &fromThreat, &fromLifespan, &cursorValue); err != nil {
+			s.log.Printf("Error scanning source species row: %v", err)
+			continue
+		}
+		sp.Attributes = []string{}
+		if fromGrowthForm {
+			sp.Attributes = append(sp.Attributes, "growth_form")
+		} else {
+			sp.GrowthForm = nil
+		}
+		if fromThreat {
+			sp.Attributes = append(sp.Attributes, "threat_status")
+		} else {
+			sp.ThreatStatus = nil
+		}
+		if fromLifespan {
+			sp.Attributes = append(sp.Attributes, "lifespan")
+		} else {
+			sp.LifespanYears = nil
+		}
+		species = append(species, sp)
+		keys = append(keys, listKey{cursorValue, sp.SpeciesID})
+	}
+	n, next := p.trim(keys)
+
+	writeCompressedJSON(w, r, map[string]interface{}{
+		"source":      f.Source,
+		"attribute":   f.Attribute,
+		"species":     species[:n],
+		"next_cursor": next,
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"deflate, gzip;q=0.8":   true,
+		"br, GZIP":              true,
+		"gzip;q=0":              false,
+		"gzip; q=0.0, identity": false,
+		"identity":              false,
+	}
+	for header, want := range tests {
+		r := httptest.NewRequest("GET", "/api/sources", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsGzip(r); got != want {
+			t.Errorf("%q: %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestWriteCompressedJSON(t *testing.T) {
+	body := map[string]string{"source": "reflora"}
+
+	r := httptest.NewRequest("GET", "/api/sources", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	writeCompressedJSON(w, r, body)
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("headers: %v", w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(gz)
+	var got map[string]string
+	if err := json.Unmarshal(data, &got); err != nil || got["source"] != "reflora" {
+		t.Errorf("decoded %q: %v", data, err)
+	}
+
+	w = httptest.NewRecorder()
+	writeCompressedJSON(w, httptest.NewRequest("GET", "/api/sources", nil), body)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("compressed without Accept-Encoding")
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Errorf("plain body: %v", err)
+	}
+}
+
+func TestSourceItemRequestErrors(t *testing.T) {
+	h := newTestServer().router()
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/api/sources/reflora", http.StatusNotFound},
+		{"/api/sources/reflora/families", http.StatusNotFound},
+		{"/api/sources//species", http.StatusMovedPermanently},
+		{"/api/sources/reflora/species?attribute=height", http.StatusBadRequest},
+		{"/api/sources/reflora/species?sort=size", http.StatusBadRequest},
+		{"/api/sources/reflora/coverage?format=kml", http.StatusBadRequest},
+		{"/api/sources/reflora/coverage?zoom=30", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: %d, want %d", tc.path, w.Code, tc.code)
+		}
+	}
+}
+
+func TestSourceFilterCondition(t *testing.T) {
+	if got := (sourceFilter{Source: "gift", Attribute: "lifespan"}).condition(3); got != "(su.lifespan_source = $3)" {
+		t.Errorf("one attribute: %s", got)
+	}
+	got := (sourceFilter{Source: "gift"}).condition(1)
+	want := "(su.growth_form_source = $1 OR su.threat_status_source = $1 OR su.lifespan_source = $1)"
+	if got != want {
+		t.Errorf("any attribute: %s", got)
+	}
+}
+
+func TestCoverageShare(t *testing.T) {
+	if coverageShare(5, 0) != nil {
+		t.Error("share of an empty region should be null")
+	}
+	if got := coverageShare(1, 3); got == nil || *got != 0.333 {
+		t.Errorf("share 1/3: %v", got)
+	}
+}
+
+func TestNextDailyRun(t *testing.T) {
+	loc := time.FixedZone("BRT", -3*3600)
+	for _, tc := range []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 3, 10, 1, 0, 0, 0, loc), time.Date(2026, 3, 10, 3, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 10, 3, 0, 0, 0, loc), time.Date(2026, 3, 11, 3, 0, 0, 0, loc)},
+		{time.Date(2026, 3, 10, 23, 59, 0, 0, loc), time.Date(2026, 3, 11, 3, 0, 0, 0, loc)},
+		{time.Date(2026, 12, 31, 12, 0, 0, 0, loc), time.Date(2027, 1, 1, 3, 0, 0, 0, loc)},
+	} {
+		if got := nextDailyRun(tc.now, "03:00"); !got.Equal(tc.want) {
+			t.Errorf("%s: got %s, want %s", tc.now, got, tc.want)
+		}
+	}
+}
+
+func TestLoadSourceSummaryAt(t *testing.T) {
+	for value, want := range map[string]string{"": "03:00", "22:30": "22:30", "off": "off", "25:00": "03:00", "3am": "03:00"} {
+		t.Setenv("SOURCE_SUMMARY_AT", value)
+		if got := loadSourceSummaryAt(); got != want {
+			t.Errorf("%q: got %q, want %q", value, got, want)
+		}
+	}
+}
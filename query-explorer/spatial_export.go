@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// GEOPARQUET EXPORT OF SPATIAL LAYERS
+// ============================================================================
+//
+// GDAL, DuckDB and QGIS read GeoParquet far faster than shapefiles or
+// GeoJSON dumps, so the spatial layers are exported as GeoParquet files
+// (parquet.go): WKB geometries, CRS84, with the GeoParquet 1.1 "geo"
+// metadata and its bbox. Whole layers take minutes, so exports run as jobs
+// like /api/query/async (migration 054):
+//
+//	POST   /api/export/spatial               {"layer", "tolerance"}; 202 with the job
+//	GET    /api/export/spatial               the layers and the caller's jobs
+//	GET    /api/export/spatial/{id}          job status
+//	GET    /api/export/spatial/{id}.parquet  the file, once succeeded
+//	DELETE /api/export/spatial/{id}          cancel, or delete a finished job
+//
+// Layers are tdwg_level3, ecoregions and species_geometry (native_range as
+// geometry, full_range as a second geometry column). tolerance simplifies
+// the geometries, in degrees as in /api/tdwg.geojson; the default 0 keeps
+// full detail. One worker runs the exports under SPATIAL_EXPORT_TIMEOUT;
+// jobs belong to the API key that created them, and a key may have at most
+// SPATIAL_EXPORT_MAX_QUEUED jobs queued or running, so one user cannot fill
+// the worker's queue. Files can exceed what a BYTEA holds, so the worker
+// streams them into a large object (migration 061) and downloads read them
+// back in chunks.
+
+const (
+	geoParquetVersion      = "1.1.0"
+	spatialExportRowGroup  = 1000
+	spatialExportListLimit = 50
+	spatialExportChunk     = 1 << 20 // Bytes per lo_put and lo_get
+)
+
+// spatialLayer is an exportable table: attribute columns, then geometry
+// columns, each selected by query as WKB and its bbox
+type spatialLayer struct {
+	Columns    []parquetColumn
+	Geometries []string
+	query      func(tolerance float64) string
+}
+
+// spatialGeometry selects the WKB and bbox of the geometry alias.g
+func spatialGeometry(alias string) string {
+	return fmt.Sprintf("ST_AsBinary(%[1]s.g), ST_XMin(%[1]s.g), ST_YMin(%[1]s.g), ST_XMax(%[1]s.g), ST_YMax(%[1]s.g)", alias)
+}
+
+// simplifyExpr simplifies a geometry expression at tolerance (a parsed
+// number); 0 keeps it
+func simplifyExpr(expr string, tolerance float64) string {
+	if tolerance == 0 {
+		return expr
+	}
+	return fmt.Sprintf("ST_SimplifyPreserveTopology(%s, %g)", expr, tolerance)
+}
+
+func textColumn(name string) parquetColumn {
+	return parquetColumn{Name: name, Type: parquetByteArray, UTF8: true, Optional: true}
+}
+
+var spatialLayers = map[string]spatialLayer{
+	"tdwg_level3": {
+		Columns: []parquetColumn{
+			{Name: "level3_code", Type: parquetByteArray, UTF8: true},
+			textColumn("level3_name"), textColumn("level2_code"), textColumn("continent"),
+		},
+		Geometries: []string{"geometry"},
+		query: func(tolerance float64) string {
+			join, expr := layerGeometry("tdwg_level3", "t", tolerance)
+			return fmt.Sprintf(`
+				SELECT t.level3_code, t.level3_name, t.level2_code, t.continent, %s
+				FROM tdwg_level3 t
+				%s
+				CROSS JOIN LATERAL (SELECT ST_Multi(%s) AS g) g1
+				WHERE t.geom IS NOT NULL
+				ORDER BY t.level3_code`, spatialGeometry("g1"), join, expr)
+		},
+	},
+	"ecoregions": {
+		Columns: []parquetColumn{
+			{Name: "eco_id", Type: parquetInt64},
+			textColumn("eco_name"),
+			{Name: "biome_num", Type: parquetInt64, Optional: true},
+			textColumn("biome_name"), textColumn("realm"),
+		},
+		Geometries: []string{"geometry"},
+		query: func(tolerance float64) string {
+			join, expr := layerGeometry("ecoregion", "e", tolerance)
+			return fmt.Sprintf(`
+				SELECT e.eco_id, e.eco_name, e.biome_num, e.biome_name, e.realm, %s
+				FROM ecoregions e
+				%s
+				CROSS JOIN LATERAL (SELECT ST_Multi(%s) AS g) g1
+				WHERE e.geom IS NOT NULL AND e.eco_id IS NOT NULL
+				ORDER BY e.eco_id`, spatialGeometry("g1"), join, expr)
+		},
+	},
+	"species_geometry": {
+		Columns: []parquetColumn{
+			{Name: "species_id", Type: parquetInt64},
+			textColumn("canonical_name"), textColumn("family"),
+			{Name: "native_regions_count", Type: parquetInt64, Optional: true},
+			{Name: "full_regions_count", Type: parquetInt64, Optional: true},
+			{Name: "native_area_km2", Type: parquetDouble, Optional: true},
+			{Name: "full_area_km2", Type: parquetDouble, Optional: true},
+		},
+		Geometries: []string{"geometry", "full_range"},
+		query: func(tolerance float64) string {
+			return fmt.Sprintf(`
+				SELECT sg.species_id, s.canonical_name, s.family,
+				       sg.native_regions_count, sg.full_regions_count,
+				       sg.native_area_km2::float8, sg.full_area_km2::float8, %s, %s
+				FROM species_geometry sg
+				JOIN species s ON s.id = sg.species_id
+				CROSS JOIN LATERAL (SELECT ST_Multi(%s) AS g) g1
+				CROSS JOIN LATERAL (SELECT ST_Multi(%s) AS g) g2
+				ORDER BY sg.species_id`, spatialGeometry("g1"), spatialGeometry("g2"),
+				simplifyExpr("sg.native_range", tolerance), simplifyExpr("sg.full_range", tolerance))
+		},
+	},
+}
+
+func spatialLayerNames() []string {
+	names := make([]string, 0, len(spatialLayers))
+	for name := range spatialLayers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parquetColumns is the file schema of the layer: attributes, then one
+// optional WKB column per geometry
+func (l spatialLayer) parquetColumns() []parquetColumn {
+	columns := append([]parquetColumn(nil), l.Columns...)
+	for _, name := range l.Geometries {
+		columns = append(columns, parquetColumn{Name: name, Type: parquetByteArray, Optional: true})
+	}
+	return columns
+}
+
+// geoMetadata is the GeoParquet "geo" file metadata for the layer's
+// geometry columns and their bboxes (nil for all-null columns)
+func (l spatialLayer) geoMetadata(bboxes []*[4]float64) (string, error) {
+	columns := map[string]interface{}{}
+	for i, name := range l.Geometries {
+		col := map[string]interface{}{"encoding": "WKB", "geometry_types": []string{"MultiPolygon"}}
+		if bboxes[i] != nil {
+			col["bbox"] = bboxes[i][:]
+		}
+		columns[name] = col
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"version":        geoParquetVersion,
+		"primary_column": l.Geometries[0],
+		"columns":        columns,
+	})
+	return string(b), err
+}
+
+// writeGeoParquet writes the layer at tolerance to w and returns its rows
+func (s *Server) writeGeoParquet(ctx context.Context, w io.Writer, layer spatialLayer, tolerance float64) (int, error) {
+	tx, err := s.beginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, layer.query(tolerance))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	pw, err := newParquetWriter(w, layer.parquetColumns())
+	if err != nil {
+		return 0, err
+	}
+	bboxes := make([]*[4]float64, len(layer.Geometries))
+	var group [][]interface{}
+	n := 0
+	for rows.Next() {
+		row, err := scanSpatialRow(rows, layer, bboxes)
+		if err != nil {
+			return n, err
+		}
+		group = append(group, row)
+		n++
+		if len(group) == spatialExportRowGroup {
+			if err := pw.WriteRowGroup(group); err != nil {
+				return n, err
+			}
+			group = group[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if err := pw.WriteRowGroup(group); err != nil {
+		return n, err
+	}
+	geo, err := layer.geoMetadata(bboxes)
+	if err != nil {
+		return n, err
+	}
+	return n, pw.Close(map[string]string{"geo": geo})
+}
+
+// scanSpatialRow scans one row of layer into parquet values, extending
+// bboxes with its geometries
+func scanSpatialRow(rows *sql.Rows, layer spatialLayer, bboxes []*[4]float64) ([]interface{}, error) {
+	dest := make([]interface{}, 0, len(layer.Columns)+5*len(layer.Geometries))
+	for _, col := range layer.Columns {
+		switch col.Type {
+		case parquetInt64:
+			dest = append(dest, new(sql.NullInt64))
+		case parquetDouble:
+			dest = append(dest, new(sql.NullFloat64))
+		default:
+			dest = append(dest, new(sql.NullString))
+		}
+	}
+	for range layer.Geometries {
+		dest = append(dest, new([]byte), new(sql.NullFloat64), new(sql.NullFloat64), new(sql.NullFloat64), new(sql.NullFloat64))
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	row := make([]interface{}, 0, len(layer.Columns)+len(layer.Geometries))
+	for i := range layer.Columns {
+		switch v := dest[i].(type) {
+		case *sql.NullInt64:
+			row = append(row, parquetValue(v.Valid, v.Int64))
+		case *sql.NullFloat64:
+			row = append(row, parquetValue(v.Valid, v.Float64))
+		case *sql.NullString:
+			row = append(row, parquetValue(v.Valid, v.String))
+		}
+	}
+	for g := range layer.Geometries {
+		d := dest[len(layer.Columns)+5*g:]
+		wkb := *d[0].(*[]byte)
+		if wkb == nil {
+			row = append(row, nil)
+			continue
+		}
+		row = append(row, wkb)
+		xmin, ymin, xmax, ymax := d[1].(*sql.NullFloat64), d[2].(*sql.NullFloat64), d[3].(*sql.NullFloat64), d[4].(*sql.NullFloat64)
+		if !xmin.Valid {
+			continue // Empty geometry
+		}
+		if bboxes[g] == nil {
+			bboxes[g] = &[4]float64{xmin.Float64, ymin.Float64, xmax.Float64, ymax.Float64}
+			continue
+		}
+		b := bboxes[g]
+		b[0], b[1] = min(b[0], xmin.Float64), min(b[1], ymin.Float64)
+		b[2], b[3] = max(b[2], xmax.Float64), max(b[3], ymax.Float64)
+	}
+	return row, nil
+}
+
+// parquetValue returns v, or nil (null) when not valid
+func parquetValue[T any](valid bool, v T) interface{} {
+	if !valid {
+		return nil
+	}
+	return v
+}
+
+// ============================================================================
+// JOBS
+// ============================================================================
+
+type SpatialExportJob struct {
+	ID          string  `json:"id"`
+	Layer       string  `json:"layer"`
+	Tolerance   float64 `json:"tolerance"`
+	Status      string  `json:"status"`
+	NRows       *int    `json:"n_rows,omitempty"`
+	Bytes       *int64  `json:"bytes,omitempty"`
+	Error       *string `json:"error,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+	StartedAt   *string `json:"started_at,omitempty"`
+	FinishedAt  *string `json:"finished_at,omitempty"`
+	DownloadURL string  `json:"download_url,omitempty"` // Once succeeded
+}
+
+type SpatialExportRequest struct {
+	Layer     string   `json:"layer"`
+	Tolerance *float64 `json:"tolerance"` // Degrees; default 0, full detail
+}
+
+// validate checks the layer and returns the tolerance
+func (req SpatialExportRequest) validate() (float64, error) {
+	if _, ok := spatialLayers[req.Layer]; !ok {
+		return 0, fmt.Errorf("layer must be one of %s", strings.Join(spatialLayerNames(), ", "))
+	}
+	if req.Tolerance == nil {
+		return 0, nil
+	}
+	if t := *req.Tolerance; t < 0 || t > maxTDWGTolerance {
+		return 0, fmt.Errorf("tolerance must be between 0 and %g degrees", maxTDWGTolerance)
+	}
+	return *req.Tolerance, nil
+}
+
+type SpatialExportsResponse struct {
+	Layers []string           `json:"layers"`
+	Jobs   []SpatialExportJob `json:"jobs"` // The caller's latest jobs
+}
+
+// wakeSpatialExportWorker nudges the idle worker without blocking
+func (s *Server) wakeSpatialExportWorker() {
+	select {
+	case s.exports.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) startSpatialExportWorker(timeout time.Duration) {
+	// Exports running when the previous process stopped will never finish
+	if _, err := s.db.ExecContext(context.Background(), `
+		UPDATE spatial_export_jobs SET status = 'failed', error = 'interrupted by server restart', finished_at = NOW()
+		WHERE status = 'running'
+	`); err != nil {
+		s.log.Printf("Error resetting interrupted spatial exports: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(queryJobPollEvery)
+		defer ticker.Stop()
+		for {
+			for s.runNextSpatialExport(timeout) {
+			}
+			select {
+			case <-s.exports.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	s.log.Printf("Spatial export worker started (timeout %s)", timeout)
+}
+
+// runNextSpatialExport claims and runs the oldest queued export; false if
+// none
+func (s *Server) runNextSpatialExport(timeout time.Duration) bool {
+	var id, layerName string
+	var tolerance float64
+	err := s.db.QueryRowContext(context.Background(), `
+		UPDATE spatial_export_jobs SET status = 'running', started_at = NOW()
+		WHERE id = (
+			SELECT id FROM spatial_export_jobs WHERE status = 'queued'
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, layer, tolerance
+	`).Scan(&id, &layerName, &tolerance)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		s.log.Printf("Error claiming spatial export: %v", err)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	s.exports.Lock()
+	s.exports.running[id] = cancel
+	s.exports.Unlock()
+	defer func() {
+		s.exports.Lock()
+		delete(s.exports.running, id)
+		s.exports.Unlock()
+		cancel()
+	}()
+
+	start := time.Now()
+	n, size, err := s.storeSpatialExport(ctx, id, spatialLayers[layerName], tolerance)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("export cancelled: exceeded job timeout of %s", timeout)
+		}
+		// A job cancelled while running keeps its 'cancelled' status
+		if _, err := s.db.ExecContext(context.Background(), `
+			UPDATE spatial_export_jobs SET status = 'failed', error = $2, finished_at = NOW()
+			WHERE id = $1 AND status = 'running'
+		`, id, err.Error()); err != nil {
+			s.log.Printf("Error storing spatial export %s failure: %v", id, err)
+		}
+		return true
+	}
+	s.log.Printf("Spatial export %s (%s, tolerance %g): %d rows, %d bytes in %s",
+		id, layerName, tolerance, n, size, time.Since(start))
+	return true
+}
+
+// largeObjectWriter appends to the large object oid within tx
+type largeObjectWriter struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	oid    int64
+	offset int64
+}
+
+func (lw *largeObjectWriter) Write(b []byte) (int, error) {
+	if _, err := lw.tx.ExecContext(lw.ctx, `SELECT lo_put($1, $2, $3)`, lw.oid, lw.offset, b); err != nil {
+		return 0, err
+	}
+	lw.offset += int64(len(b))
+	return len(b), nil
+}
+
+// storeSpatialExport writes the layer into a new large object and marks the
+// job succeeded, in one transaction: a failed export or one cancelled
+// meanwhile leaves no object behind. Returns the rows and bytes written.
+func (s *Server) storeSpatialExport(ctx context.Context, id string, layer spatialLayer, tolerance float64) (int, int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	lw := &largeObjectWriter{ctx: ctx, tx: tx}
+	if err := tx.QueryRowContext(ctx, `SELECT lo_create(0)`).Scan(&lw.oid); err != nil {
+		return 0, 0, err
+	}
+	bw := bufio.NewWriterSize(lw, spatialExportChunk)
+	n, err := s.writeGeoParquet(ctx, bw, layer, tolerance)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return n, lw.offset, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE spatial_export_jobs SET status = 'succeeded', n_rows = $2, bytes = $3, finished_at = NOW()
+		WHERE id = $1 AND status = 'running'
+	`, id, n, lw.offset)
+	if err != nil {
+		return n, lw.offset, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return n, lw.offset, nil
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO spatial_export_files (job_id, lo) VALUES ($1, $2)`, id, lw.oid); err != nil {
+		return n, lw.offset, err
+	}
+	return n, lw.offset, tx.Commit()
+}
+
+// copySpatialExport writes the large object oid to w in chunks
+func (s *Server) copySpatialExport(ctx context.Context, w io.Writer, oid int64) error {
+	for offset := int64(0); ; {
+		var chunk []byte
+		if err := s.db.QueryRowContext(ctx, `SELECT lo_get($1, $2, $3)`, oid, offset, spatialExportChunk).Scan(&chunk); err != nil {
+			return err
+		}
+		if len(chunk) == 0 {
+			return nil
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		offset += int64(len(chunk))
+	}
+}
+
+const spatialExportColumns = `id, layer, tolerance, status, n_rows, bytes, error, created_at, started_at, finished_at`
+
+func scanSpatialExportJob(row interface{ Scan(...interface{}) error }) (SpatialExportJob, error) {
+	var job SpatialExportJob
+	var createdAt time.Time
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Layer, &job.Tolerance, &job.Status, &job.NRows, &job.Bytes, &job.Error,
+		&createdAt, &startedAt, &finishedAt)
+	if err != nil {
+		return job, err
+	}
+	job.CreatedAt = createdAt.Format(time.RFC3339)
+	if startedAt.Valid {
+		s := startedAt.Time.Format(time.RFC3339)
+		job.StartedAt = &s
+	}
+	if finishedAt.Valid {
+		s := finishedAt.Time.Format(time.RFC3339)
+		job.FinishedAt = &s
+	}
+	if job.Status == "succeeded" {
+		job.DownloadURL = "/api/export/spatial/" + job.ID + ".parquet"
+	}
+	return job, nil
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleSpatialExports handles GET/POST /api/export/spatial
+func (s *Server) handleSpatialExports(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT `+spatialExportColumns+` FROM spatial_export_jobs
+			WHERE created_by = $1 ORDER BY created_at DESC LIMIT $2
+		`, key.ID, spatialExportListLimit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		resp := SpatialExportsResponse{Layers: spatialLayerNames(), Jobs: []SpatialExportJob{}}
+		for rows.Next() {
+			job, err := scanSpatialExportJob(rows)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+				return
+			}
+			resp.Jobs = append(resp.Jobs, job)
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var req SpatialExportRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	tolerance, err := req.validate()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	id, err := newQueryJobID()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to create job"}`, http.StatusInternalServerError)
+		return
+	}
+	// The count and insert race only between requests of one key, which
+	// can at worst exceed the cap by its concurrent requests
+	job, err := scanSpatialExportJob(s.db.QueryRowContext(ctx, `
+		INSERT INTO spatial_export_jobs (id, layer, tolerance, created_by)
+		SELECT $1, $2, $3, $4
+		WHERE (SELECT COUNT(*) FROM spatial_export_jobs WHERE created_by = $4 AND status IN ('queued', 'running')) < $5
+		RETURNING `+spatialExportColumns, id, req.Layer, tolerance, key.ID, s.cfg.SpatialExportMaxQueued))
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf(`{"error": "At most %d exports may be queued or running; wait for one to finish or cancel it"}`,
+			s.cfg.SpatialExportMaxQueued), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		s.log.Printf("Error creating spatial export: %v", err)
+		http.Error(w, `{"error": "Failed to create job"}`, http.StatusInternalServerError)
+		return
+	}
+	s.wakeSpatialExportWorker()
+
+	w.Header().Set("Location", "/api/export/spatial/"+id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleSpatialExport handles /api/export/spatial/{id} (GET, DELETE)
+func (s *Server) handleSpatialExport(w http.ResponseWriter, r *http.Request) {
+	s.spatialExport(w, r, false)
+}
+
+// handleSpatialExportDownload handles GET /api/export/spatial/{id}.parquet
+func (s *Server) handleSpatialExportDownload(w http.ResponseWriter, r *http.Request) {
+	s.spatialExport(w, r, true)
+}
+
+func (s *Server) spatialExport(w http.ResponseWriter, r *http.Request, download bool) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	id := pathParam(r, "id")
+	if !validAOIID(id) {
+		http.Error(w, `{"error": "Export not found"}`, http.StatusNotFound)
+		return
+	}
+	key, ok := s.requireRole(w, r, roleUser)
+	if !ok {
+		return
+	}
+
+	job, err := scanSpatialExportJob(s.db.QueryRowContext(ctx, `
+		SELECT `+spatialExportColumns+` FROM spatial_export_jobs WHERE id = $1 AND created_by = $2
+	`, id, key.ID))
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Export not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodDelete:
+		if job.Status == "queued" || job.Status == "running" {
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE spatial_export_jobs SET status = 'cancelled', error = 'cancelled by client', finished_at = NOW()
+				WHERE id = $1 AND status IN ('queued', 'running')
+			`, id)
+			s.exports.Lock()
+			if cancel, ok := s.exports.running[id]; ok {
+				cancel()
+			}
+			s.exports.Unlock()
+		} else {
+			_, err = s.db.ExecContext(ctx, `DELETE FROM spatial_export_jobs WHERE id = $1`, id)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case download:
+		if job.Status != "succeeded" {
+			http.Error(w, fmt.Sprintf(`{"error": "Export is %s"}`, job.Status), http.StatusConflict)
+			return
+		}
+		var oid int64
+		if err := s.db.QueryRowContext(ctx, `SELECT lo FROM spatial_export_files WHERE job_id = $1`, id).Scan(&oid); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.parquet"`, job.Layer, id[:8]))
+		if job.Bytes != nil {
+			w.Header().Set("Content-Length", strconv.FormatInt(*job.Bytes, 10))
+		}
+		// The status goes out with the first chunk; a later failure only
+		// cuts the file short, which the client sees against Content-Length
+		if err := s.copySpatialExport(ctx, w, oid); err != nil {
+			s.log.Printf("Error sending spatial export %s: %v", id, err)
+		}
+
+	default:
+		if job.Status == "queued" || job.Status == "running" {
+			w.Header().Set("Retry-After", "5")
+		}
+		json.NewEncoder(w).Encode(job)
+	}
+}
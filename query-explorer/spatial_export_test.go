@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpatialExportRequestValidate(t *testing.T) {
+	tol := func(f float64) *float64 { return &f }
+	for _, tc := range []struct {
+		req     SpatialExportRequest
+		want    float64
+		wantErr bool
+	}{
+		{SpatialExportRequest{Layer: "tdwg_level3"}, 0, false},
+		{SpatialExportRequest{Layer: "species_geometry", Tolerance: tol(0.01)}, 0.01, false},
+		{SpatialExportRequest{Layer: "ecoregions", Tolerance: tol(1)}, 1, false},
+		{SpatialExportRequest{Layer: "ecoregions", Tolerance: tol(-0.1)}, 0, true},
+		{SpatialExportRequest{Layer: "ecoregions", Tolerance: tol(2)}, 0, true},
+		{SpatialExportRequest{Layer: "ecoregion"}, 0, true},
+		{SpatialExportRequest{}, 0, true},
+	} {
+		got, err := tc.req.validate()
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("%+v: got %g, %v", tc.req, got, err)
+		}
+	}
+}
+
+func TestSpatialLayerQueries(t *testing.T) {
+	for name, layer := range spatialLayers {
+		q := layer.query(0)
+		if strings.Contains(q, "Simplify") || strings.Contains(q, "%!") {
+			t.Errorf("%s at tolerance 0: %s", name, q)
+		}
+		if got := strings.Count(q, "ST_AsBinary"); got != len(layer.Geometries) {
+			t.Errorf("%s selects %d geometries, want %d", name, got, len(layer.Geometries))
+		}
+		if !strings.Contains(layer.query(0.02), "ST_SimplifyPreserveTopology") {
+			t.Errorf("%s at tolerance 0.02 is not simplified", name)
+		}
+		if cols := layer.parquetColumns(); len(cols) != len(layer.Columns)+len(layer.Geometries) || cols[len(layer.Columns)].Name != "geometry" {
+			t.Errorf("%s columns: %+v", name, cols)
+		}
+	}
+}
+
+func TestSpatialGeoMetadata(t *testing.T) {
+	layer := spatialLayers["species_geometry"]
+	geo, err := layer.geoMetadata([]*[4]float64{{-74, -34, -34, 5}, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta struct {
+		Version       string `json:"version"`
+		PrimaryColumn string `json:"primary_column"`
+		Columns       map[string]struct {
+			Encoding      string    `json:"encoding"`
+			GeometryTypes []string  `json:"geometry_types"`
+			BBox          []float64 `json:"bbox"`
+		} `json:"columns"`
+	}
+	if err := json.Unmarshal([]byte(geo), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Version != geoParquetVersion || meta.PrimaryColumn != "geometry" || len(meta.Columns) != 2 {
+		t.Fatalf("got %s", geo)
+	}
+	if c := meta.Columns["geometry"]; c.Encoding != "WKB" || len(c.BBox) != 4 || c.BBox[1] != -34 {
+		t.Errorf("geometry: %+v", c)
+	}
+	if c := meta.Columns["full_range"]; c.BBox != nil {
+		t.Errorf("all-null column has bbox %v", c.BBox)
+	}
+}
+
+func TestSpatialExportValidation(t *testing.T) {
+	s := newTestServer()
+	routed := s.router().ServeHTTP
+	id := strings.Repeat("ab", 16)
+	for _, tc := range []struct {
+		method, path string
+		handler      http.HandlerFunc
+		want         int
+	}{
+		{http.MethodPut, "/api/export/spatial", s.handleSpatialExports, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/export/spatial", s.handleSpatialExports, http.StatusUnauthorized},
+		{http.MethodGet, "/api/export/spatial/xyz", routed, http.StatusNotFound},
+		{http.MethodGet, "/api/export/spatial/" + id + ".geojson", routed, http.StatusNotFound},
+		{http.MethodDelete, "/api/export/spatial/" + id + ".parquet", routed, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/export/spatial/" + id, routed, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/export/spatial/" + id, routed, http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ============================================================================
+// SPECIES DETAIL
+// ============================================================================
+//
+// GET /api/species/{id} returns everything a species card shows in one
+// response: taxonomy, the unified traits with the source of each, threat
+// status, every common name, the TDWG regions where it occurs and a climate
+// summary from the envelope in use (/api/species/{id}/envelope has the full
+// envelope). Missing data is null or an empty list, never an error; only an
+// unknown id is a 404.
+
+type SpeciesTaxonomy struct {
+	Genus           *string `json:"genus"`
+	Family          *string `json:"family"`
+	TaxonomicStatus *string `json:"taxonomic_status"` // accepted, synonym, unresolved
+	AcceptedNameID  *int64  `json:"accepted_name_id,omitempty"`
+	AcceptedName    *string `json:"accepted_name,omitempty"`
+	WCVPID          *string `json:"wcvp_id"`
+	GBIFTaxonKey    *int64  `json:"gbif_taxon_key"`
+}
+
+type SpeciesTraits struct {
+	GrowthForm             *string  `json:"growth_form"`
+	GrowthFormSource       *string  `json:"growth_form_source"`
+	MaxHeightM             *float64 `json:"max_height_m"`
+	HeightSource           *string  `json:"height_source"`
+	Woodiness              *string  `json:"woodiness"`
+	NitrogenFixer          *bool    `json:"nitrogen_fixer"`
+	DispersalSyndrome      *string  `json:"dispersal_syndrome"`
+	Deciduousness          *string  `json:"deciduousness"`
+	LifespanYears          *float64 `json:"lifespan_years"`
+	LifespanSource         *string  `json:"lifespan_source"`
+	LightRequirement       *string  `json:"light_requirement"`
+	LightRequirementSource *string  `json:"light_requirement_source"`
+	WetlandIndicator       *string  `json:"wetland_indicator"`
+	WetlandIndicatorSource *string  `json:"wetland_indicator_source"`
+	IsNativeBrazil         *bool    `json:"is_native_brazil"`
+}
+
+type SpeciesThreat struct {
+	Status *string `json:"status"` // IUCN code
+	Label  *string `json:"label"`  // In the response language
+	Source *string `json:"source"`
+}
+
+type SpeciesCommonName struct {
+	Name     string  `json:"name"`
+	Language string  `json:"language"`
+	Source   *string `json:"source"`
+	Verified bool    `json:"verified"`
+}
+
+type SpeciesRegion struct {
+	TDWGCode      string  `json:"tdwg_code"`
+	Name          string  `json:"name"`
+	IsNative      bool    `json:"is_native"`
+	IsEndemic     bool    `json:"is_endemic"`
+	IsIntroduced  bool    `json:"is_introduced"`
+	Establishment *string `json:"establishment_means"` // native, naturalized, invasive, cultivated
+	Source        *string `json:"source"`
+	Abundance     *string `json:"abundance,omitempty"` // rare, occasional, common (see abundance.go)
+}
+
+// SpeciesClimateSummary is the envelope in use, reduced to temperature and
+// precipitation
+type SpeciesClimateSummary struct {
+	Source     string   `json:"source"` // gbif, ecoregion, wcvp
+	Quality    *string  `json:"quality"`
+	NSamples   *int64   `json:"n_samples"`
+	TempMin    *float64 `json:"temp_min"`
+	TempMean   *float64 `json:"temp_mean"`
+	TempMax    *float64 `json:"temp_max"`
+	PrecipMin  *float64 `json:"precip_min"`
+	PrecipMean *float64 `json:"precip_mean"`
+	PrecipMax  *float64 `json:"precip_max"`
+}
+
+type SpeciesDetailResponse struct {
+	SpeciesID     int64                  `json:"species_id"`
+	CanonicalName string                 `json:"canonical_name"`
+	Taxonomy      SpeciesTaxonomy        `json:"taxonomy"`
+	Traits        SpeciesTraits          `json:"traits"`
+	ThreatStatus  SpeciesThreat          `json:"threat_status"`
+	CommonNames   []SpeciesCommonName    `json:"common_names"`
+	NativeRegions int                    `json:"n_native_regions"`
+	Regions       []SpeciesRegion        `json:"regions"`
+	Climate       *SpeciesClimateSummary `json:"climate"` // null without an envelope
+	QueryTime     string                 `json:"query_time"`
+}
+
+// handleSpeciesDetail handles GET /api/species/{id}
+func (s *Server) handleSpeciesDetail(w http.ResponseWriter, r *http.Request, id int64) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	lang := requestLanguage(r)
+	resp := SpeciesDetailResponse{SpeciesID: id}
+	tx, tr, th := &resp.Taxonomy, &resp.Traits, &resp.ThreatStatus
+	var climate SpeciesClimateSummary
+	var envelopeSource sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT s.canonical_name, s.genus, s.family, s.taxonomic_status, s.accepted_name_id, a.canonical_name,
+		       s.wcvp_id, s.gbif_taxon_key,
+		       su.growth_form, su.growth_form_source, su.max_height_m, su.height_source,
+		       su.woodiness, su.nitrogen_fixer, su.dispersal_syndrome, su.deciduousness,
+		       su.lifespan_years, su.lifespan_source, su.light_requirement, su.light_requirement_source,
+		       su.wetland_indicator, su.wetland_indicator_source, su.is_native_brazil,
+		       su.threat_status, su.threat_status_source,
+		       e.envelope_source, e.envelope_quality, e.n_samples,
+		       e.temp_min, e.temp_mean, e.temp_max, e.precip_min, e.precip_mean, e.precip_max
+		FROM species s
+		LEFT JOIN species a ON a.id = s.accepted_name_id
+		LEFT JOIN species_unified su ON su.species_id = s.id
+		LEFT JOIN species_climate_envelope_unified e ON e.species_id = s.id
+		WHERE s.id = $1
+	`, id).Scan(&resp.CanonicalName, &tx.Genus, &tx.Family, &tx.TaxonomicStatus, &tx.AcceptedNameID, &tx.AcceptedName,
+		&tx.WCVPID, &tx.GBIFTaxonKey,
+		&tr.GrowthForm, &tr.GrowthFormSource, &tr.MaxHeightM, &tr.HeightSource,
+		&tr.Woodiness, &tr.NitrogenFixer, &tr.DispersalSyndrome, &tr.Deciduousness,
+		&tr.LifespanYears, &tr.LifespanSource, &tr.LightRequirement, &tr.LightRequirementSource,
+		&tr.WetlandIndicator, &tr.WetlandIndicatorSource, &tr.IsNativeBrazil,
+		&th.Status, &th.Source,
+		&envelopeSource, &climate.Quality, &climate.NSamples,
+		&climate.TempMin, &climate.TempMean, &climate.TempMax, &climate.PrecipMin, &climate.PrecipMean, &climate.PrecipMax)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "Species not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	th.Label = threatStatusLabel(th.Status, lang)
+	if envelopeSource.Valid {
+		climate.Source = envelopeSource.String
+		resp.Climate = &climate
+	}
+
+	// Names in the response language first
+	resp.CommonNames = []SpeciesCommonName{}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT common_name, language, source, COALESCE(verified, FALSE)
+		FROM common_names
+		WHERE species_id = $1
+		ORDER BY language <> $2, verified DESC NULLS LAST, common_name
+	`, id, lang)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var cn SpeciesCommonName
+		if err := rows.Scan(&cn.Name, &cn.Language, &cn.Source, &cn.Verified); err != nil {
+			s.log.Printf("Error scanning common name: %v", err)
+			continue
+		}
+		resp.CommonNames = append(resp.CommonNames, cn)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	resp.Regions = []SpeciesRegion{}
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT sr.tdwg_code, COALESCE(t.level3_name, ''),
+		       COALESCE(sr.is_native, FALSE), COALESCE(sr.is_endemic, FALSE), COALESCE(sr.is_introduced, FALSE),
+		       sr.establishment_means::text, sr.source, ab.abundance::text
+		FROM species_regions sr
+		LEFT JOIN tdwg_level3 t ON t.level3_code = sr.tdwg_code
+		LEFT JOIN species_region_abundance ab ON ab.species_id = sr.species_id AND ab.tdwg_code = sr.tdwg_code
+		WHERE sr.species_id = $1
+		ORDER BY sr.is_native DESC, sr.tdwg_code
+	`, id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var region SpeciesRegion
+		if err := rows.Scan(&region.TDWGCode, &region.Name, &region.IsNative, &region.IsEndemic, &region.IsIntroduced,
+			&region.Establishment, &region.Source, &region.Abundance); err != nil {
+			s.log.Printf("Error scanning species region: %v", err)
+			continue
+		}
+		region.Name = s.localize(ctx, nameKindTDWG, region.TDWGCode, lang, region.Name)
+		if region.IsNative {
+			resp.NativeRegions++
+		}
+		resp.Regions = append(resp.Regions, region)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	setContentLanguage(w, lang)
+	resp.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// SPECIES CLIMATE ENVELOPE
+// ============================================================================
+//
+// GET /api/species/{id}/envelope returns the envelope climate_match_score is
+// computed from (species_climate_envelope_unified, migration 011): per
+// bioclimatic variable the range and, for GBIF envelopes, the 5th and 95th
+// percentiles of the occurrences, together with what it rests on, i.e. the
+// source chosen (GBIF > ecoregion > WCVP), how many occurrences, ecoregions
+// or regions it was built from, its quality flag and how many sources agree.
+// A score from a low-quality WCVP envelope of two regions deserves less trust
+// than one from a thousand GBIF occurrences.
+//
+// GBIF envelopes store the mean of bio5 and bio6 over the occurrences, which
+// the unified view carries as warm_month_max and cold_month_min; they are
+// reported as the mean here, with the percentiles as the extremes.
+
+// envelopeSampleUnits names what n_samples counts for each source
+var envelopeSampleUnits = map[string]string{
+	"gbif":      "occurrences",
+	"ecoregion": "ecoregions",
+	"wcvp":      "regions",
+}
+
+// EnvelopeStats is the envelope of one variable; fields a source does not
+// provide are omitted
+type EnvelopeStats struct {
+	Min  *float64 `json:"min,omitempty"`
+	P05  *float64 `json:"p05,omitempty"`
+	Mean *float64 `json:"mean,omitempty"`
+	P95  *float64 `json:"p95,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+}
+
+type SpeciesEnvelopeResponse struct {
+	SpeciesID        int64                    `json:"species_id"`
+	CanonicalName    string                   `json:"canonical_name"`
+	Source           string                   `json:"source"` // gbif, ecoregion, wcvp
+	NSamples         *int64                   `json:"n_samples"`
+	SampleUnit       string                   `json:"sample_unit"` // occurrences, ecoregions, regions
+	Quality          *string                  `json:"quality"`     // high, medium, low
+	SourceConsensus  string                   `json:"source_consensus"`
+	AvailableSources []string                 `json:"available_sources"`
+	NCountries       *int64                   `json:"n_countries,omitempty"` // GBIF only
+	YearRange        *string                  `json:"year_range,omitempty"`  // GBIF only
+	Variables        map[string]EnvelopeStats `json:"variables"`             // bio1, bio5, bio6, bio12, bio15
+	QueryTime        string                   `json:"query_time"`
+}
+
+// speciesItem adapts a handler of one species to the /api/species/{id}
+// routes, parsing the id
+func speciesItem(handle func(*Server, http.ResponseWriter, *http.Request, int64)) func(*Server, http.ResponseWriter, *http.Request) {
+	return func(s *Server, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		id, err := strconv.ParseInt(pathParam(r, "id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, `{"error": "Invalid species id"}`, http.StatusBadRequest)
+			return
+		}
+		handle(s, w, r, id)
+	}
+}
+
+// gbifMonthMeans moves the occurrence means of bio5 and bio6, read as the
+// max and min of a GBIF envelope, to Mean
+func gbifMonthMeans(bio5, bio6 *EnvelopeStats) {
+	bio5.Mean, bio5.Max = bio5.Max, nil
+	bio6.Mean, bio6.Min = bio6.Min, nil
+}
+
+// handleSpeciesEnvelope handles GET /api/species/{id}/envelope
+func (s *Server) handleSpeciesEnvelope(w http.ResponseWriter, r *http.Request, id int64) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	resp := SpeciesEnvelopeResponse{SpeciesID: id}
+	var bio1, bio5, bio6, bio12, bio15 EnvelopeStats
+	var hasEcoregion, hasWCVP bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT s.canonical_name, e.envelope_source, e.n_samples, e.envelope_quality, e.source_consensus,
+		       e.temp_min, e.temp_mean, e.temp_max,
+		       e.warm_month_max, e.cold_month_min,
+		       e.precip_min, e.precip_mean, e.precip_max, e.precip_seasonality,
+		       g.temp_p05, g.temp_p95, g.warm_month_p95, g.cold_month_p05, g.precip_p05, g.precip_p95,
+		       g.n_countries, g.year_range,
+		       EXISTS (SELECT 1 FROM climate_envelope_ecoregion x WHERE x.species_id = s.id),
+		       EXISTS (SELECT 1 FROM species_climate_envelope x WHERE x.species_id = s.id)
+		FROM species s
+		JOIN species_climate_envelope_unified e ON e.species_id = s.id
+		-- Percentiles only when GBIF is the source in use
+		LEFT JOIN climate_envelope_gbif g ON g.species_id = s.id AND e.envelope_source = 'gbif'
+		WHERE s.id = $1
+	`, id).Scan(&resp.CanonicalName, &resp.Source, &resp.NSamples, &resp.Quality, &resp.SourceConsensus,
+		&bio1.Min, &bio1.Mean, &bio1.Max,
+		&bio5.Max, &bio6.Min,
+		&bio12.Min, &bio12.Mean, &bio12.Max, &bio15.Mean,
+		&bio1.P05, &bio1.P95, &bio5.P95, &bio6.P05, &bio12.P05, &bio12.P95,
+		&resp.NCountries, &resp.YearRange,
+		&hasEcoregion, &hasWCVP)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "No climate envelope for this species"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	// A GBIF envelope, when present, is always the one in use
+	resp.AvailableSources = []string{}
+	for _, src := range []struct {
+		name string
+		ok   bool
+	}{{"gbif", resp.Source == "gbif"}, {"ecoregion", hasEcoregion}, {"wcvp", hasWCVP}} {
+		if src.ok {
+			resp.AvailableSources = append(resp.AvailableSources, src.name)
+		}
+	}
+	resp.SampleUnit = envelopeSampleUnits[resp.Source]
+	if resp.Source == "gbif" {
+		gbifMonthMeans(&bio5, &bio6)
+	}
+	resp.Variables = map[string]EnvelopeStats{
+		"bio1":  bio1,
+		"bio5":  bio5,
+		"bio6":  bio6,
+		"bio12": bio12,
+		"bio15": bio15,
+	}
+	resp.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(resp)
+}
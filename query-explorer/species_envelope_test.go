@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpeciesItemRouting(t *testing.T) {
+	h := newTestServer().router()
+	tests := []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/api/species/abc/envelope", http.StatusBadRequest},
+		{"GET", "/api/species/0/envelope", http.StatusBadRequest},
+		{"POST", "/api/species/42", http.StatusMethodNotAllowed},
+		{"GET", "/api/species/42/traits", http.StatusNotFound},
+		{"POST", "/api/species/42/envelope", http.StatusMethodNotAllowed},
+		{"GET", "/api/species/42/suitability-map", http.StatusBadRequest},
+		{"GET", "/api/species/42/suitability-map?bbox=-54,-30,-44,-20&cells=500", http.StatusBadRequest},
+		{"GET", "/api/species/42/suitability-map?bbox=-54,-30,-44,-20&format=tiff", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.path, w.Code, tc.code)
+		}
+	}
+}
+
+func TestGBIFMonthMeans(t *testing.T) {
+	warm, cold, p05 := 31.5, 8.2, 4.0
+	bio5 := EnvelopeStats{Max: &warm}
+	bio6 := EnvelopeStats{Min: &cold, P05: &p05}
+	gbifMonthMeans(&bio5, &bio6)
+	if bio5.Max != nil || bio5.Mean == nil || *bio5.Mean != warm {
+		t.Errorf("bio5: %+v", bio5)
+	}
+	if bio6.Min != nil || bio6.Mean == nil || *bio6.Mean != cold || bio6.P05 != &p05 {
+		t.Errorf("bio6: %+v", bio6)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// SPECIES SEARCH
+// ============================================================================
+//
+// GET /api/species/search?q= matches q against canonical names and common
+// names (migration 044 adds the trigram indexes), for autocomplete and for
+// finding a species id without knowing its exact spelling. Each species is
+// returned once, with the name that matched best. Ranking goes by match kind
+// (exact, then prefix, then substring, then fuzzy only) and then by trigram
+// similarity; common names in the request language win ties.
+//
+//	q=ipe          at least 2 characters
+//	limit=10       at most 50
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+	minSearchLength    = 2
+)
+
+// searchMatchKinds names the match tiers, best first
+var searchMatchKinds = []string{"exact", "prefix", "substring", "fuzzy"}
+
+type SpeciesSearchMatch struct {
+	SpeciesID     int64   `json:"species_id"`
+	CanonicalName string  `json:"canonical_name"`
+	Family        string  `json:"family"`
+	MatchedName   string  `json:"matched_name"`
+	MatchedField  string  `json:"matched_field"`      // canonical_name, common_name
+	Language      *string `json:"language,omitempty"` // Of a matched common name
+	Match         string  `json:"match"`              // exact, prefix, substring, fuzzy
+	Similarity    float64 `json:"similarity"`
+}
+
+type SpeciesSearchResponse struct {
+	Query     string               `json:"query"`
+	Results   []SpeciesSearchMatch `json:"results"`
+	QueryTime string               `json:"query_time"`
+}
+
+// parseSearchQuery trims q and checks its length
+func parseSearchQuery(q string) (string, error) {
+	q = strings.Join(strings.Fields(q), " ")
+	if utf8.RuneCountInString(q) < minSearchLength {
+		return "", fmt.Errorf("q must have at least %d characters", minSearchLength)
+	}
+	if utf8.RuneCountInString(q) > 100 {
+		return "", fmt.Errorf("q must have at most 100 characters")
+	}
+	return q, nil
+}
+
+// handleSpeciesSearch handles GET /api/species/search
+func (s *Server) handleSpeciesSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q, err := parseSearchQuery(query.Get("q"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error": "limit must be a positive integer"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+	lang := requestLanguage(r)
+
+	start := time.Now()
+	escaped := escapeLike(q)
+	rows, err := s.db.QueryContext(ctx, `
+		WITH matches AS (
+			SELECT s.id AS species_id, s.canonical_name AS matched, 'canonical_name' AS field,
+			       NULL::text AS language, similarity(s.canonical_name, $1) AS sim
+			FROM species s
+			WHERE s.canonical_name % $1 OR s.canonical_name ILIKE $3
+			UNION ALL
+			SELECT cn.species_id, cn.common_name, 'common_name', cn.language::text,
+			       similarity(cn.common_name, $1)
+			FROM common_names cn
+			WHERE cn.common_name % $1 OR cn.common_name ILIKE $3
+		),
+		ranked AS (
+			SELECT m.*,
+			       CASE WHEN lower(m.matched) = lower($1) THEN 0
+			            WHEN m.matched ILIKE $2 THEN 1
+			            WHEN m.matched ILIKE $3 THEN 2
+			            ELSE 3 END AS tier
+			FROM matches m
+		),
+		best AS (
+			SELECT DISTINCT ON (species_id) *
+			FROM ranked
+			ORDER BY species_id, tier, sim DESC, (language IS NOT DISTINCT FROM $4) DESC
+		)
+		SELECT b.species_id, s.canonical_name, COALESCE(s.family, ''),
+		       b.matched, b.field, b.language, b.tier, b.sim
+		FROM best b
+		JOIN species s ON s.id = b.species_id
+		ORDER BY b.tier, b.sim DESC, (b.language IS NOT DISTINCT FROM $4) DESC, s.canonical_name
+		LIMIT $5
+	`, q, escaped+"%", "%"+escaped+"%", lang, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := SpeciesSearchResponse{Query: q, Results: []SpeciesSearchMatch{}}
+	for rows.Next() {
+		var m SpeciesSearchMatch
+		var tier int
+		if err := rows.Scan(&m.SpeciesID, &m.CanonicalName, &m.Family,
+			&m.MatchedName, &m.MatchedField, &m.Language, &tier, &m.Similarity); err != nil {
+			s.log.Printf("Error scanning species search row: %v", err)
+			continue
+		}
+		m.Match = searchMatchKinds[tier]
+		m.Similarity = math.Round(m.Similarity*1000) / 1000
+		resp.Results = append(resp.Results, m)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	resp.QueryTime = time.Since(start).String()
+	json.NewEncoder(w).Encode(resp)
+}
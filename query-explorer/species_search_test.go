@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	if q, err := parseSearchQuery("  ipê   amarelo "); err != nil || q != "ipê amarelo" {
+		t.Errorf("got %q, %v", q, err)
+	}
+	for _, bad := range []string{"", " a ", strings.Repeat("x", 101)} {
+		if _, err := parseSearchQuery(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	// Two runes, not two bytes
+	if _, err := parseSearchQuery("ê"); err == nil {
+		t.Error("a single accented letter should be too short")
+	}
+}
+
+func TestSpeciesSearchValidation(t *testing.T) {
+	s := newTestServer()
+	tests := []struct {
+		method, path string
+		code         int
+	}{
+		{"POST", "/api/species/search?q=ipe", http.StatusMethodNotAllowed},
+		{"GET", "/api/species/search", http.StatusBadRequest},
+		{"GET", "/api/species/search?q=i", http.StatusBadRequest},
+		{"GET", "/api/species/search?q=ipe&limit=0", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		s.handleSpeciesSearch(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.path, w.Code, tc.code)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// SPECIES WITHIN AN AREA
+// ============================================================================
+//
+// Project sites rarely align with TDWG boundaries, so /api/species/within
+// takes the site itself:
+//
+//	GET  /api/species/within?bbox=min_lon,min_lat,max_lon,max_lat
+//	GET  /api/species/within?aoi_id=   a stored area of interest (aoi.go)
+//	POST /api/species/within   body: a GeoJSON Polygon or MultiPolygon, or
+//	                           a Feature with one (e.g. a site exported
+//	                           from QGIS)
+//
+// source=regions (default) returns the species recorded in any TDWG region
+// intersecting the area, with the number of such regions and whether the
+// species is native in at least one; source=occurrences returns the species
+// with GBIF occurrences inside the area, with their number (migration 051
+// indexes the points). Occurrences of threatened species count where their
+// generalized point is (coordinate_privacy.go), so a small area around a
+// locality does not reveal it. growth_form and, for regions, native_only filter as
+// in /api/species; limit (default 50, at most 500) and offset page through
+// the result by name. Synonyms are left out.
+
+const (
+	defaultWithinLimit = 50
+	maxWithinLimit     = 500
+	maxWithinBodyBytes = 1 << 20
+	maxWithinVertices  = 10000
+)
+
+// occurrencePointExpr is the point of gbif_occurrences row o; it must match
+// the expression indexed by migration 051
+const occurrencePointExpr = "ST_SetSRID(ST_MakePoint(o.longitude::float8, o.latitude::float8), 4326)"
+
+// occurrenceHitsSQL counts the occurrences per species inside area. With
+// generalize, threatened species' occurrences count by their grid cell
+// center; the exact points are still searched through the index, in the
+// area grown by a cell.
+func occurrenceHitsSQL(generalize bool, gridDeg float64) string {
+	if !generalize {
+		return `
+			SELECT o.species_id, COUNT(*) AS n, NULL::boolean AS native
+			FROM gbif_occurrences o, area
+			WHERE ST_Intersects(area.geom, ` + occurrencePointExpr + `)
+			GROUP BY o.species_id`
+	}
+	threatened := "EXISTS (SELECT 1 FROM species_unified st WHERE st.species_id = o.species_id AND st.threat_status IN ('CR', 'EN', 'VU'))"
+	return fmt.Sprintf(`
+			SELECT o.species_id, COUNT(*) AS n, NULL::boolean AS native
+			FROM gbif_occurrences o, area
+			WHERE ST_Intersects(area.geom, %[1]s) AND NOT %[2]s
+			GROUP BY o.species_id
+			UNION ALL
+			SELECT o.species_id, COUNT(*), NULL::boolean
+			FROM gbif_occurrences o, area
+			WHERE ST_Intersects(ST_Expand(area.geom, %[3]g), %[1]s) AND %[2]s
+			  AND ST_Intersects(area.geom, %[4]s)
+			GROUP BY o.species_id`,
+		occurrencePointExpr, threatened, gridDeg, cellCenterSQL("o.latitude::float8", "o.longitude::float8", gridDeg))
+}
+
+type WithinSpecies struct {
+	ID                 int64   `json:"id"`
+	CanonicalName      string  `json:"canonical_name"`
+	Family             string  `json:"family"`
+	GrowthForm         string  `json:"growth_form"`
+	CommonName         *string `json:"common_name,omitempty"`
+	CommonNameLanguage *string `json:"common_name_language,omitempty"`
+	IsNative           *bool   `json:"is_native,omitempty"`     // source=regions: native in one of the regions
+	NRegions           *int64  `json:"n_regions,omitempty"`     // source=regions
+	NOccurrences       *int64  `json:"n_occurrences,omitempty"` // source=occurrences
+}
+
+type SpeciesWithinResponse struct {
+	Source    string          `json:"source"`
+	Regions   []string        `json:"regions,omitempty"` // TDWG codes intersecting the area (source=regions)
+	Species   []WithinSpecies `json:"species"`
+	Total     int64           `json:"total"`
+	Limit     int             `json:"limit"`
+	Offset    int             `json:"offset"`
+	QueryTime string          `json:"query_time"`
+}
+
+// parseWithinGeometry reads a GeoJSON Polygon or MultiPolygon, bare or in a
+// Feature, checks its rings and returns it as 2D GeoJSON for PostGIS
+func parseWithinGeometry(data []byte) ([]byte, error) {
+	var g struct {
+		Type        string          `json:"type"`
+		Geometry    json.RawMessage `json:"geometry"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %v", err)
+	}
+
+	var polygons [][][][]float64
+	switch g.Type {
+	case "Feature":
+		if len(g.Geometry) == 0 || string(g.Geometry) == "null" {
+			return nil, fmt.Errorf("feature has no geometry")
+		}
+		return parseWithinGeometry(g.Geometry)
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+			return nil, fmt.Errorf("invalid Polygon coordinates: %v", err)
+		}
+		polygons = [][][][]float64{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("geometry must be a Polygon or MultiPolygon, got %q", g.Type)
+	}
+
+	n := 0
+	flat := make([][][][2]float64, len(polygons))
+	for i, polygon := range polygons {
+		if len(polygon) == 0 {
+			return nil, fmt.Errorf("polygon %d has no rings", i)
+		}
+		flat[i] = make([][][2]float64, len(polygon))
+		for j, ring := range polygon {
+			if len(ring) < 4 {
+				return nil, fmt.Errorf("polygon %d ring %d needs at least 4 positions", i, j)
+			}
+			flat[i][j] = make([][2]float64, len(ring))
+			for k, p := range ring {
+				if len(p) < 2 || p[0] < -180 || p[0] > 180 || p[1] < -90 || p[1] > 90 {
+					return nil, fmt.Errorf("polygon %d ring %d: positions must be [lon, lat] within -180,-90,180,90", i, j)
+				}
+				flat[i][j][k] = [2]float64{p[0], p[1]}
+			}
+			if flat[i][j][0] != flat[i][j][len(ring)-1] {
+				return nil, fmt.Errorf("polygon %d ring %d is not closed", i, j)
+			}
+			n += len(ring)
+		}
+	}
+	if n > maxWithinVertices {
+		return nil, fmt.Errorf("geometry has %d positions, at most %d are allowed", n, maxWithinVertices)
+	}
+	return json.Marshal(map[string]interface{}{"type": "MultiPolygon", "coordinates": flat})
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleSpeciesWithin handles GET and POST /api/species/within
+func (s *Server) handleSpeciesWithin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, `{"error": "GET with bbox or POST with a GeoJSON polygon"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+
+	var area string
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWithinBodyBytes))
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "Reading body: "+err.Error()), http.StatusBadRequest)
+			return
+		}
+		geometry, err := parseWithinGeometry(body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		area = fmt.Sprintf("ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON(%s), 4326))", arg(string(geometry)))
+	} else if id := query.Get("aoi_id"); id != "" {
+		if _, err := s.getAOI(ctx, id); err == sql.ErrNoRows {
+			http.Error(w, `{"error": "Area of interest not found"}`, http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		area = aoiArea(arg(id))
+	} else {
+		bbox, err := parseBBox(query.Get("bbox"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		area = fmt.Sprintf("ST_MakeEnvelope(%s, %s, %s, %s, 4326)", arg(bbox[0]), arg(bbox[1]), arg(bbox[2]), arg(bbox[3]))
+	}
+	s.writeSpeciesWithin(w, r, area, args)
+}
+
+// writeSpeciesWithin answers a species-within query for the area geometry
+// SQL area, whose parameters are args
+func (s *Server) writeSpeciesWithin(w http.ResponseWriter, r *http.Request, area string, args []interface{}) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	source := query.Get("source")
+	if source == "" {
+		source = "regions"
+	}
+	if source != "regions" && source != "occurrences" {
+		http.Error(w, `{"error": "source must be regions or occurrences"}`, http.StatusBadRequest)
+		return
+	}
+	nativeOnly := query.Get("native_only") == "true"
+	if nativeOnly && source != "regions" {
+		http.Error(w, `{"error": "native_only applies to source=regions"}`, http.StatusBadRequest)
+		return
+	}
+	limit, offset := defaultWithinLimit, 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error": "limit must be a positive integer"}`, http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxWithinLimit)
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, `{"error": "offset must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	start := time.Now()
+	resp := SpeciesWithinResponse{Source: source, Species: []WithinSpecies{}, Limit: limit, Offset: offset}
+
+	var hits string
+	if source == "regions" {
+		native := ""
+		if nativeOnly {
+			native = "WHERE sr.is_native = TRUE"
+		}
+		hits = `
+			SELECT sr.species_id, COUNT(*) AS n, bool_or(sr.is_native) AS native
+			FROM species_regions sr
+			JOIN regions rg ON rg.level3_code = sr.tdwg_code
+			` + native + `
+			GROUP BY sr.species_id`
+	} else {
+		privacy := s.cfg.Coordinates
+		hits = occurrenceHitsSQL(privacy.GeneralizeThreatened, privacy.GridDeg)
+	}
+
+	// The area and its regions, also run alone for the region list
+	areaCTE := fmt.Sprintf(`
+		WITH area AS (SELECT %s AS geom),
+		regions AS (
+			SELECT t.level3_code FROM tdwg_level3 t, area
+			WHERE t.geom && area.geom AND ST_Intersects(t.geom, area.geom)
+		)`, area)
+	areaArgs := append([]interface{}{}, args...)
+
+	conditions := []string{acceptedSpeciesCondition}
+	if v := query.Get("growth_form"); v != "" {
+		conditions = append(conditions, "su.growth_form = "+arg(v))
+	}
+	lang := requestLanguage(r)
+	args = append(args, pq.Array(commonNameLanguages(lang)))
+	langParam := len(args)
+
+	rows, err := s.db.QueryContext(ctx, areaCTE+`,
+		hits AS (`+hits+`
+		)
+		SELECT s.id, s.canonical_name, COALESCE(s.family, ''), COALESCE(su.growth_form, ''),
+		       cn.common_name, cn.language, h.native, h.n, COUNT(*) OVER ()
+		FROM hits h
+		JOIN species s ON s.id = h.species_id
+		LEFT JOIN species_unified su ON su.species_id = s.id
+		`+commonNameJoin("cn", "s.id", langParam)+`
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY s.canonical_name, s.id
+		LIMIT `+arg(limit)+` OFFSET `+arg(offset), args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sp WithinSpecies
+		var n int64
+		if err := rows.Scan(&sp.ID, &sp.CanonicalName, &sp.Family, &sp.GrowthForm,
+			&sp.CommonName, &sp.CommonNameLanguage, &sp.IsNative, &n, &resp.Total); err != nil {
+			s.log.Printf("Error scanning species within row: %v", err)
+			continue
+		}
+		if source == "regions" {
+			sp.NRegions = &n
+		} else {
+			sp.NOccurrences = &n
+		}
+		resp.Species = append(resp.Species, sp)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	if source == "regions" {
+		codes, err := s.db.QueryContext(ctx, areaCTE+` SELECT level3_code FROM regions ORDER BY level3_code`, areaArgs...)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		defer codes.Close()
+		resp.Regions = []string{}
+		for codes.Next() {
+			var code string
+			if err := codes.Scan(&code); err == nil {
+				resp.Regions = append(resp.Regions, code)
+			}
+		}
+	}
+
+	resp.QueryTime = time.Since(start).String()
+	setContentLanguage(w, lang)
+	writeCompressedJSON(w, r, resp)
+}
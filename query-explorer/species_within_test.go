@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseWithinGeometry(t *testing.T) {
+	square := `[[[-47.9,-15.8],[-47.8,-15.8],[-47.8,-15.7],[-47.9,-15.7],[-47.9,-15.8]]]`
+	for _, body := range []string{
+		`{"type": "Polygon", "coordinates": ` + square + `}`,
+		`{"type": "Feature", "properties": {"name": "site"}, "geometry": {"type": "Polygon", "coordinates": ` + square + `}}`,
+		`{"type": "MultiPolygon", "coordinates": [` + square + `]}`,
+	} {
+		geometry, err := parseWithinGeometry([]byte(body))
+		if err != nil {
+			t.Errorf("%s: %v", body, err)
+			continue
+		}
+		var g struct {
+			Type        string
+			Coordinates [][][][2]float64
+		}
+		if err := json.Unmarshal(geometry, &g); err != nil || g.Type != "MultiPolygon" || len(g.Coordinates[0][0]) != 5 {
+			t.Errorf("%s: got %s", body, geometry)
+		}
+	}
+
+	// Altitudes are dropped
+	geometry, err := parseWithinGeometry([]byte(`{"type": "Polygon", "coordinates": [[[0,0,10],[1,0,10],[1,1,10],[0,0,10]]]}`))
+	if err != nil || strings.Contains(string(geometry), "10") {
+		t.Errorf("3D polygon: got %s, %v", geometry, err)
+	}
+
+	for _, body := range []string{
+		`{"type": "Point", "coordinates": [0, 0]}`,
+		`{"type": "Feature", "geometry": null}`,
+		`{"type": "Polygon", "coordinates": [[[0,0],[1,0],[0,0]]]}`,
+		`{"type": "Polygon", "coordinates": [[[0,0],[1,0],[1,1],[0,1]]]}`,
+		`{"type": "Polygon", "coordinates": [[[0,0],[200,0],[1,1],[0,0]]]}`,
+		`{"type": "Polygon", "coordinates": []}`,
+		`not json`,
+	} {
+		if _, err := parseWithinGeometry([]byte(body)); err == nil {
+			t.Errorf("%s: expected an error", body)
+		}
+	}
+}
+
+func TestSpeciesWithinValidation(t *testing.T) {
+	s := newTestServer()
+	square := `{"type": "Polygon", "coordinates": [[[0,0],[1,0],[1,1],[0,0]]]}`
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodDelete, "/api/species/within", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/species/within", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/species/within?bbox=1,2,0,3", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/species/within?bbox=0,0,1,1&source=points", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/species/within?bbox=0,0,1,1&source=occurrences&native_only=true", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/species/within?bbox=0,0,1,1&limit=0", "", http.StatusBadRequest},
+		{http.MethodPost, "/api/species/within", `{"type": "LineString"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/species/within?offset=-1", square, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		s.handleSpeciesWithin(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
+
+func TestOccurrenceHitsSQL(t *testing.T) {
+	if q := occurrenceHitsSQL(false, 0.1); strings.Contains(q, "UNION") || !strings.Contains(q, occurrencePointExpr) {
+		t.Errorf("without generalization: %s", q)
+	}
+	q := occurrenceHitsSQL(true, 0.1)
+	for _, want := range []string{
+		"AND NOT EXISTS (SELECT 1 FROM species_unified st",
+		"ST_Intersects(ST_Expand(area.geom, 0.1), " + occurrencePointExpr + ")",
+		"ST_Intersects(area.geom, ST_SetSRID(ST_MakePoint((FLOOR((o.longitude::float8) / 0.1) + 0.5) * 0.1, (FLOOR((o.latitude::float8) / 0.1) + 0.5) * 0.1), 4326))",
+	} {
+		if !strings.Contains(q, want) {
+			t.Errorf("generalized query missing %q:\n%s", want, q)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// ============================================================================
+// READ-ONLY SQL VALIDATION
+// ============================================================================
+//
+// /api/query runs user-supplied SQL. Statements are parsed with the real
+// Postgres parser (libpg_query) and must be a single SELECT, optionally
+// wrapped in EXPLAIN. Execution additionally happens in a READ ONLY
+// transaction, so anything the parser check misses still cannot write.
+
+// forbiddenQueryNodes are parse-tree nodes that write, lock or create objects
+// even inside an otherwise valid SELECT
+var forbiddenQueryNodes = map[string]string{
+	"InsertStmt":    "INSERT",
+	"UpdateStmt":    "UPDATE",
+	"DeleteStmt":    "DELETE",
+	"MergeStmt":     "MERGE",
+	"intoClause":    "SELECT INTO",
+	"lockingClause": "FOR UPDATE/SHARE",
+}
+
+// forbiddenQueryFunctions reach outside the query (files, other sessions,
+// server configuration) or tie up a connection
+var forbiddenQueryFunctions = map[string]bool{
+	"pg_sleep": true, "pg_sleep_for": true, "pg_sleep_until": true,
+	"pg_terminate_backend": true, "pg_cancel_backend": true,
+	"pg_reload_conf": true, "pg_rotate_logfile": true,
+	"pg_read_file": true, "pg_read_binary_file": true, "pg_ls_dir": true, "pg_stat_file": true,
+	"lo_import": true, "lo_export": true,
+	"set_config": true,
+	"dblink": true, "dblink_exec": true, "dblink_connect": true,
+	"pg_advisory_lock": true, "pg_advisory_xact_lock": true,
+}
+
+// validatedQuery describes a statement accepted by validateReadOnlyQuery
+type validatedQuery struct {
+	Explain  bool // EXPLAIN [ANALYZE] SELECT ...
+	HasLimit bool // Top-level SELECT already has a LIMIT
+}
+
+// validateReadOnlyQuery accepts exactly one SELECT or EXPLAIN SELECT
+func validateReadOnlyQuery(query string) (*validatedQuery, error) {
+	result, err := pg_query.Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("syntax error: %s", err.Error())
+	}
+	if len(result.Stmts) != 1 {
+		return nil, fmt.Errorf("exactly one statement allowed, got %d", len(result.Stmts))
+	}
+
+	v := &validatedQuery{}
+	node := result.Stmts[0].Stmt
+	if explain := node.GetExplainStmt(); explain != nil {
+		v.Explain = true
+		node = explain.Query
+	}
+
+	sel := node.GetSelectStmt()
+	if sel == nil {
+		return nil, fmt.Errorf("only SELECT queries allowed")
+	}
+	v.HasLimit = sel.LimitCount != nil
+
+	// Walk the whole tree (CTEs, subqueries, function arguments) for writes
+	tree, err := pg_query.ParseToJSON(query)
+	if err != nil {
+		return nil, fmt.Errorf("syntax error: %s", err.Error())
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(tree), &parsed); err != nil {
+		return nil, err
+	}
+	if err := checkQueryNode(parsed); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func checkQueryNode(node interface{}) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, child := range n {
+			if what, ok := forbiddenQueryNodes[key]; ok {
+				return fmt.Errorf("%s not allowed", what)
+			}
+			if key == "FuncCall" {
+				if name := funcCallName(child); forbiddenQueryFunctions[name] {
+					return fmt.Errorf("function %s not allowed", name)
+				}
+			}
+			if err := checkQueryNode(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range n {
+			if err := checkQueryNode(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// funcCallName returns the unqualified, lowercased name of a FuncCall node
+// (pg_catalog.pg_sleep and pg_sleep are the same function)
+func funcCallName(node interface{}) string {
+	call, _ := node.(map[string]interface{})
+	parts, _ := call["funcname"].([]interface{})
+	if len(parts) == 0 {
+		return ""
+	}
+	last, _ := parts[len(parts)-1].(map[string]interface{})
+	str, _ := last["String"].(map[string]interface{})
+	name, _ := str["sval"].(string)
+	return strings.ToLower(name)
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestValidateReadOnlyQuery(t *testing.T) {
+	allowed := []struct {
+		sql      string
+		hasLimit bool
+	}{
+		{"SELECT id, created_at, updated_at FROM species", false},
+		{"select canonical_name from species where family = 'Fabaceae' limit 10;", true},
+		{"SELECT * FROM species WHERE canonical_name = 'DROP TABLE species'", false},
+		{"WITH t AS (SELECT 1 AS x) SELECT x FROM t", false},
+		{"EXPLAIN SELECT * FROM species_regions", false},
+		{"EXPLAIN ANALYZE SELECT count(*) FROM species", false},
+		{"SELECT 1 UNION SELECT 2", false},
+		{"SELECT deleted, inserted FROM audit_view -- a comment", false},
+	}
+	for _, tt := range allowed {
+		v, err := validateReadOnlyQuery(tt.sql)
+		if err != nil {
+			t.Errorf("%q rejected: %v", tt.sql, err)
+			continue
+		}
+		if v.HasLimit != tt.hasLimit {
+			t.Errorf("%q: HasLimit = %v, want %v", tt.sql, v.HasLimit, tt.hasLimit)
+		}
+	}
+
+	rejected := []string{
+		"DELETE FROM species",
+		"UPDATE species SET family = NULL",
+		"SELECT 1; DROP TABLE species",
+		"WITH gone AS (DELETE FROM species RETURNING id) SELECT * FROM gone",
+		"SELECT * INTO backup FROM species",
+		"SELECT * FROM species FOR UPDATE",
+		"SELECT pg_sleep(60)",
+		"SELECT pg_catalog.pg_terminate_backend(123)",
+		"SELECT * FROM species WHERE id = (SELECT lo_import('/etc/passwd'))",
+		"EXPLAIN ANALYZE DELETE FROM species",
+		"CREATE TABLE x (id int)",
+		"SELEC * FROM species",
+		"",
+	}
+	for _, sql := range rejected {
+		if _, err := validateReadOnlyQuery(sql); err == nil {
+			t.Errorf("%q accepted", sql)
+		}
+	}
+}
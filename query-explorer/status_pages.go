@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ============================================================================
+// STATUS AND OFFLINE PAGES
+// ============================================================================
+//
+// End-user HTML pages, rendered from templates/ (embedded in the binary) in
+// the request language: the page served under /diversiplant/ when the
+// dashboard cannot be reached, and /status, which summarizes component
+// health. Both show the database and each dashboard upstream as last seen
+// by the proxy; /api/health remains the detailed JSON view for operators.
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var pageTemplates = map[string]*template.Template{
+	"offline": parsePage("templates/offline.html"),
+	"status":  parsePage("templates/status.html"),
+}
+
+func parsePage(file string) *template.Template {
+	return template.Must(template.ParseFS(templateFS,
+		"templates/layout.html", "templates/components.html", file))
+}
+
+// pageText holds the strings of the pages in one language
+type pageText struct {
+	Title, Message, Retry                  string
+	AllUp, Degraded, CheckedAt             string
+	Up, Down, LastCheck                    string
+	StatusLink, AdminLink, DashboardLink   string
+	Database, Dashboard, DashboardFallback string
+}
+
+var offlineText = map[string]pageText{
+	"en": {
+		Title:   "Dashboard temporarily unavailable",
+		Message: "The DiversiPlant dashboard is not responding right now. The database and API may still be available.",
+		Retry:   "This page reloads automatically every 30 seconds.",
+	},
+	"pt": {
+		Title:   "Dashboard temporariamente indisponível",
+		Message: "O dashboard do DiversiPlant não está respondendo no momento. O banco de dados e a API podem continuar disponíveis.",
+		Retry:   "Esta página é recarregada automaticamente a cada 30 segundos.",
+	},
+	"es": {
+		Title:   "Dashboard temporalmente no disponible",
+		Message: "El dashboard de DiversiPlant no responde en este momento. La base de datos y la API pueden seguir disponibles.",
+		Retry:   "Esta página se recarga automáticamente cada 30 segundos.",
+	},
+}
+
+var statusText = map[string]pageText{
+	"en": {Title: "System status", AllUp: "All systems operational", Degraded: "Some components are unavailable", CheckedAt: "Checked at"},
+	"pt": {Title: "Status do sistema", AllUp: "Todos os sistemas operacionais", Degraded: "Alguns componentes estão indisponíveis", CheckedAt: "Verificado em"},
+	"es": {Title: "Estado del sistema", AllUp: "Todos los sistemas operativos", Degraded: "Algunos componentes no están disponibles", CheckedAt: "Verificado el"},
+}
+
+// componentText is shared by both pages
+var componentText = map[string]pageText{
+	"en": {
+		Up: "Operational", Down: "Unavailable", LastCheck: "last check",
+		StatusLink: "System status", AdminLink: "Admin UI", DashboardLink: "Open the dashboard",
+		Database: "Database", Dashboard: "Dashboard", DashboardFallback: "Dashboard (standby)",
+	},
+	"pt": {
+		Up: "Operacional", Down: "Indisponível", LastCheck: "última verificação",
+		StatusLink: "Status do sistema", AdminLink: "Painel administrativo", DashboardLink: "Abrir o dashboard",
+		Database: "Banco de dados", Dashboard: "Dashboard", DashboardFallback: "Dashboard (reserva)",
+	},
+	"es": {
+		Up: "Operativo", Down: "No disponible", LastCheck: "última verificación",
+		StatusLink: "Estado del sistema", AdminLink: "Panel de administración", DashboardLink: "Abrir el dashboard",
+		Database: "Base de datos", Dashboard: "Dashboard", DashboardFallback: "Dashboard (reserva)",
+	},
+}
+
+// textFor merges the page's strings with the shared ones
+func textFor(page map[string]pageText, lang string) pageText {
+	t, c := page[lang], componentText[lang]
+	t.Up, t.Down, t.LastCheck = c.Up, c.Down, c.LastCheck
+	t.StatusLink, t.AdminLink, t.DashboardLink = c.StatusLink, c.AdminLink, c.DashboardLink
+	t.Database, t.Dashboard, t.DashboardFallback = c.Database, c.Dashboard, c.DashboardFallback
+	return t
+}
+
+type pageComponent struct {
+	Name      string
+	Up        bool
+	LastCheck string
+}
+
+type pageData struct {
+	Lang       string
+	Languages  []string
+	T          pageText
+	Refresh    int // Seconds; 0 for none
+	Components []pageComponent
+	AllUp      bool
+	CheckedAt  string
+}
+
+const pageTimeFormat = "2006-01-02 15:04 UTC"
+
+// componentStatus checks the database and reads the upstream pool's view
+// of the dashboard; upstream URLs are internal and not shown
+func (s *Server) componentStatus(ctx context.Context, t pageText) []pageComponent {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Format(pageTimeFormat)
+	components := []pageComponent{{Name: t.Database, Up: s.db.PingContext(ctx) == nil, LastCheck: now}}
+
+	if s.upstreams != nil {
+		for i, h := range s.upstreams.health() {
+			c := pageComponent{Name: t.Dashboard, Up: h.Healthy}
+			if i > 0 {
+				c.Name = t.DashboardFallback
+			}
+			if h.LastCheck != nil {
+				if at, err := time.Parse(time.RFC3339, *h.LastCheck); err == nil {
+					c.LastCheck = at.UTC().Format(pageTimeFormat)
+				}
+			}
+			components = append(components, c)
+		}
+	}
+	return components
+}
+
+func (s *Server) renderPage(w http.ResponseWriter, r *http.Request, name string, status int, text map[string]pageText, refresh int) {
+	lang := requestLanguage(r)
+	data := pageData{
+		Lang:      lang,
+		T:         textFor(text, lang),
+		Refresh:   refresh,
+		AllUp:     true,
+		CheckedAt: time.Now().UTC().Format(pageTimeFormat),
+	}
+	for l := range supportedLanguages {
+		data.Languages = append(data.Languages, l)
+	}
+	sort.Strings(data.Languages)
+	data.Components = s.componentStatus(r.Context(), data.T)
+	for _, c := range data.Components {
+		data.AllUp = data.AllUp && c.Up
+	}
+	if name == "status" && !data.AllUp {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	setContentLanguage(w, lang)
+	w.WriteHeader(status)
+	if err := pageTemplates[name].ExecuteTemplate(w, "layout", data); err != nil {
+		s.log.Printf("Rendering %s page: %v", name, err)
+	}
+}
+
+// serveOfflinePage is the dashboard proxy's error page
+func (s *Server) serveOfflinePage(w http.ResponseWriter, r *http.Request) {
+	s.renderPage(w, r, "offline", http.StatusBadGateway, offlineText, 30)
+}
+
+// handleStatusPage handles GET /status; it answers 503 while any component
+// is down so it can also be polled by uptime checks
+func (s *Server) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.renderPage(w, r, "status", http.StatusOK, statusText, 60)
+}
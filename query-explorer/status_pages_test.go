@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageTextComplete(t *testing.T) {
+	for lang := range supportedLanguages {
+		for name, text := range map[string]map[string]pageText{"offline": offlineText, "status": statusText} {
+			pt := textFor(text, lang)
+			if pt.Title == "" || pt.Up == "" || pt.Database == "" {
+				t.Errorf("%s page has missing %s strings: %+v", name, lang, pt)
+			}
+		}
+	}
+}
+
+func TestOfflinePageRenders(t *testing.T) {
+	data := pageData{
+		Lang:      "pt",
+		Languages: []string{"en", "es", "pt"},
+		T:         textFor(offlineText, "pt"),
+		Refresh:   30,
+		Components: []pageComponent{
+			{Name: "Banco de dados", Up: true},
+			{Name: "Dashboard <primary>", Up: false, LastCheck: "2024-01-01 00:00 UTC"},
+		},
+	}
+	var out strings.Builder
+	if err := pageTemplates["offline"].ExecuteTemplate(&out, "layout", data); err != nil {
+		t.Fatal(err)
+	}
+	html := out.String()
+	for _, want := range []string{
+		`<html lang="pt">`,
+		`content="30"`,
+		"Dashboard temporariamente indisponível",
+		`<span class="down">Indisponível</span>`,
+		"Dashboard &lt;primary&gt;",
+		`href="/status?lang=pt"`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("offline page missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// STREAMING RECOMMENDATIONS
+// ============================================================================
+//
+// POST /api/recommend/stream takes the same body as /api/recommend and sends
+// each species as soon as the greedy loop selects it. The format follows the
+// Accept header: text/event-stream gives SSE, anything else NDJSON. Events:
+//
+//	location  {"type":"location","location_info":{...}}
+//	species   {"type":"species","species":{...}}          (one per species, in rank order)
+//	done      {"type":"done","diversity_metrics":{...},"query_time":"..."}
+//	error     {"type":"error","error":"..."}
+//
+// When post-processing plugins are active they may rewrite the list after it
+// has been streamed, so "done" then also carries the "final_species" list.
+
+type streamEvent struct {
+	Type             string                  `json:"type"`
+	LocationInfo     *LocationInfo           `json:"location_info,omitempty"`
+	Species          *SpeciesRecommendation  `json:"species,omitempty"`
+	FinalSpecies     []SpeciesRecommendation `json:"final_species,omitempty"`
+	DiversityMetrics *DiversityMetrics       `json:"diversity_metrics,omitempty"`
+	StartStrategy    string                  `json:"start_strategy,omitempty"`
+	StartSeed        *int64                  `json:"start_seed,omitempty"`
+	QueryTime        string                  `json:"query_time,omitempty"`
+	Watermark        string                  `json:"watermark,omitempty"` // On "done", for demo requests
+	Error            string                  `json:"error,omitempty"`
+}
+
+// eventStream writes streamEvents as NDJSON lines or SSE messages, flushing
+// after each one
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+}
+
+func newEventStream(w http.ResponseWriter, r *http.Request) (*eventStream, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+
+	s := &eventStream{w: w, flusher: flusher, sse: strings.Contains(r.Header.Get("Accept"), "text/event-stream")}
+	if s.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+	return s, true
+}
+
+func (s *eventStream) send(ev streamEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if s.sse {
+		fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	} else {
+		s.w.Write(data)
+		s.w.Write([]byte("\n"))
+	}
+	s.flusher.Flush()
+}
+
+// handleRecommendStream handles POST /api/recommend/stream
+func (s *Server) handleRecommendStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "POST required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, plugins, ok := decodeRecommendRequest(w, r)
+	if !ok {
+		return
+	}
+
+	stream, ok := newEventStream(w, r)
+	if !ok {
+		http.Error(w, `{"error": "Streaming not supported"}`, http.StatusInternalServerError)
+		return
+	}
+
+	start := time.Now()
+	done := func(resp *RecommendResponse, final bool) {
+		ev := streamEvent{
+			Type:             "done",
+			DiversityMetrics: &resp.DiversityMetrics,
+			StartStrategy:    resp.StartStrategy,
+			StartSeed:        resp.StartSeed,
+			QueryTime:        time.Since(start).String(),
+			Watermark:        watermark(ctx),
+		}
+		if final {
+			ev.FinalSpecies = resp.Species
+		}
+		stream.send(ev)
+	}
+
+	// Cached results are replayed through the same events
+	lang := requestLanguage(r)
+	if cached, ok := s.getCachedRecommendation(ctx, req.CacheKey()); ok {
+		s.localizeRecommendation(ctx, cached, lang)
+		stream.send(streamEvent{Type: "location", LocationInfo: &cached.LocationInfo})
+		for i := range cached.Species {
+			stream.send(streamEvent{Type: "species", Species: &cached.Species[i]})
+		}
+		done(cached, false)
+		return
+	}
+
+	resp, err := s.executeRecommendation(ctx, req, plugins, &recommendObserver{
+		OnLocation: func(loc LocationInfo) {
+			s.localizeLocation(ctx, &loc, lang)
+			stream.send(streamEvent{Type: "location", LocationInfo: &loc})
+		},
+		OnSelect: func(sp SpeciesRecommendation) {
+			sp.ThreatStatusLabel = threatStatusLabel(sp.ThreatStatus, lang)
+			stream.send(streamEvent{Type: "species", Species: &sp})
+		},
+	})
+	if err != nil {
+		stream.send(streamEvent{Type: "error", Error: err.Error()})
+		return
+	}
+
+	resp.Species = withThreatLabels(resp.Species, lang)
+	done(resp, len(plugins.postProcessors) > 0)
+}
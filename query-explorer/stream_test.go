@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readStreamEvents decodes a /api/recommend/stream body in either framing
+func readStreamEvents(t *testing.T, body string, sse bool) []streamEvent {
+	t.Helper()
+	var events []streamEvent
+	if !sse {
+		for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+			var ev streamEvent
+			if err := json.Unmarshal([]byte(line), &ev); err != nil {
+				t.Fatalf("NDJSON line %q: %v", line, err)
+			}
+			events = append(events, ev)
+		}
+		return events
+	}
+
+	if !strings.HasSuffix(body, "\n\n") {
+		t.Fatalf("SSE stream does not end with a blank line: %q", body)
+	}
+	for _, msg := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n") {
+		lines := strings.Split(msg, "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("SSE message %q", msg)
+		}
+		var ev streamEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &ev); err != nil {
+			t.Fatalf("SSE data %q: %v", lines[1], err)
+		}
+		if name := strings.TrimPrefix(lines[0], "event: "); name != ev.Type {
+			t.Errorf("SSE event %q carries a %q event", name, ev.Type)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func streamEventTypes(events []streamEvent) string {
+	types := make([]string, len(events))
+	for i, ev := range events {
+		types[i] = ev.Type
+	}
+	return strings.Join(types, ",")
+}
+
+func serveRecommendStream(s *Server, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/recommend/stream", strings.NewReader(`{"tdwg_code": "BZS"}`))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	return w
+}
+
+func TestRecommendStreamFraming(t *testing.T) {
+	stored := `{"location_info":{"tdwg_code":"BZS"},
+		"species":[{"species_id":7,"canonical_name":"Inga edulis"},{"species_id":9,"canonical_name":"Ocotea porosa"}],
+		"diversity_metrics":{"n_species":2,"n_families":2,"total_diversity_score":0.6}}`
+	for _, tc := range []struct {
+		accept, contentType string
+		sse                 bool
+	}{
+		{"", "application/x-ndjson", false},
+		{"application/x-ndjson", "application/x-ndjson", false},
+		{"text/event-stream", "text/event-stream", true},
+	} {
+		s, _ := newFakeDBServer(t,
+			fakeQuery{match: "response IS NOT NULL", columns: []string{"response"}, rows: [][]driver.Value{{[]byte(stored)}}},
+			fakeQuery{match: "SET hit_count = hit_count + 1"},
+			fakeQuery{match: "FROM localized_names", columns: []string{"kind", "code", "language", "name"}},
+			fakeQuery{match: "FROM common_names", columns: []string{"species_id", "common_name", "language"}},
+		)
+		w := serveRecommendStream(s, tc.accept)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != tc.contentType {
+			t.Fatalf("Accept %q: %d %s", tc.accept, w.Code, w.Header().Get("Content-Type"))
+		}
+
+		events := readStreamEvents(t, w.Body.String(), tc.sse)
+		if got := streamEventTypes(events); got != "location,species,species,done" {
+			t.Fatalf("Accept %q: events %s", tc.accept, got)
+		}
+		if events[0].LocationInfo.TDWGCode != "BZS" || events[1].Species.SpeciesID != 7 || events[2].Species.SpeciesID != 9 {
+			t.Errorf("Accept %q: events %+v", tc.accept, events)
+		}
+		if done := events[3]; done.DiversityMetrics == nil || done.DiversityMetrics.NSpecies != 2 || done.QueryTime == "" || done.FinalSpecies != nil {
+			t.Errorf("Accept %q: done %+v, want the metrics trailer", tc.accept, done)
+		}
+	}
+}
+
+func TestRecommendStreamError(t *testing.T) {
+	for _, sse := range []bool{false, true} {
+		s, _ := newFakeDBServer(t,
+			fakeQuery{match: "response IS NOT NULL", columns: []string{"response"}},
+			fakeQuery{
+				match:   "c.bio1_mean, c.bio5_mean",
+				columns: []string{"tdwg_code", "level3_name", "bio1", "bio5", "bio6", "bio12", "bio15"},
+				rows:    [][]driver.Value{{"BZS", "Brazil South", 18.0, 28.0, 8.0, 1500.0, 30.0}},
+			},
+			fakeQuery{match: "FROM localized_names", columns: []string{"kind", "code", "language", "name"}},
+			fakeQuery{match: "as climate_match_score", err: errors.New("no candidates")},
+		)
+		accept := ""
+		if sse {
+			accept = "text/event-stream"
+		}
+		w := serveRecommendStream(s, accept)
+
+		// The status is sent with the first event, so errors are events too
+		events := readStreamEvents(t, w.Body.String(), sse)
+		if w.Code != http.StatusOK || streamEventTypes(events) != "location,error" || !strings.Contains(events[1].Error, "no candidates") {
+			t.Errorf("sse %v: %d %+v", sse, w.Code, events)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+// ============================================================================
+// SUCCESSION STAGES
+// ============================================================================
+//
+// Restoration plantings are staged: pioneers shade the ground within a few
+// years, secondaries take over, and climax species establish under them.
+// With succession_stages, every recommended species is placed in a stage
+// and the response groups them, with counts. The stage follows from the
+// lifespan, adjusted by the light requirement (migration 032):
+//
+//	lifespan < 20 years    pioneer
+//	lifespan < 50 years    early_secondary
+//	lifespan < 100 years   late_secondary
+//	otherwise              climax
+//
+// Light-demanding species are never climax and shade-tolerant ones never
+// pioneers or early secondaries. Without a lifespan, light decides alone
+// (light_demanding: pioneer, intermediate: early_secondary, shade_tolerant:
+// climax). Herbs, graminoids and climbers cover the ground from the start
+// and count as pioneers. Species with neither lifespan nor light requirement
+// are unclassified.
+
+const (
+	stagePioneer        = "pioneer"
+	stageEarlySecondary = "early_secondary"
+	stageLateSecondary  = "late_secondary"
+	stageClimax         = "climax"
+	stageUnclassified   = "unclassified"
+)
+
+var successionStages = []string{stagePioneer, stageEarlySecondary, stageLateSecondary, stageClimax, stageUnclassified}
+
+var validLightRequirements = map[string]bool{
+	"light_demanding": true,
+	"intermediate":    true,
+	"shade_tolerant":  true,
+}
+
+// groundCoverForms establish and cycle quickly whatever their lifespan
+var groundCoverForms = map[string]bool{
+	"graminoid": true, "forb": true, "subshrub": true,
+	"scrambler": true, "vine": true,
+}
+
+// successionStage places sp in a stage
+func successionStage(sp SpeciesRecommendation) string {
+	if groundCoverForms[sp.GrowthForm] {
+		return stagePioneer
+	}
+	light := ""
+	if sp.LightRequirement != nil {
+		light = *sp.LightRequirement
+	}
+
+	if sp.LifespanYears == nil {
+		switch light {
+		case "light_demanding":
+			return stagePioneer
+		case "intermediate":
+			return stageEarlySecondary
+		case "shade_tolerant":
+			return stageClimax
+		}
+		return stageUnclassified
+	}
+
+	stage := stageClimax
+	switch years := *sp.LifespanYears; {
+	case years < 20:
+		stage = stagePioneer
+	case years < 50:
+		stage = stageEarlySecondary
+	case years < 100:
+		stage = stageLateSecondary
+	}
+	switch {
+	case light == "light_demanding" && stage == stageClimax:
+		stage = stageLateSecondary
+	case light == "shade_tolerant" && (stage == stagePioneer || stage == stageEarlySecondary):
+		stage = stageLateSecondary
+	}
+	return stage
+}
+
+type SuccessionGroup struct {
+	Stage      string  `json:"stage"`
+	Count      int     `json:"count"`
+	SpeciesIDs []int64 `json:"species_ids"` // In selection order
+}
+
+// groupBySuccession sets SuccessionStage on every species in place and
+// returns one group per stage, in succession order (empty stages included)
+func groupBySuccession(species []SpeciesRecommendation) []SuccessionGroup {
+	byStage := make(map[string]*SuccessionGroup, len(successionStages))
+	groups := make([]SuccessionGroup, len(successionStages))
+	for i, stage := range successionStages {
+		groups[i] = SuccessionGroup{Stage: stage, SpeciesIDs: []int64{}}
+		byStage[stage] = &groups[i]
+	}
+	for i := range species {
+		stage := successionStage(species[i])
+		species[i].SuccessionStage = stage
+		g := byStage[stage]
+		g.Count++
+		g.SpeciesIDs = append(g.SpeciesIDs, species[i].SpeciesID)
+	}
+	return groups
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuccessionStage(t *testing.T) {
+	years := func(v float64) *float64 { return &v }
+	light := func(v string) *string { return &v }
+	tests := []struct {
+		sp   SpeciesRecommendation
+		want string
+	}{
+		{SpeciesRecommendation{GrowthForm: "tree", LifespanYears: years(12)}, stagePioneer},
+		{SpeciesRecommendation{GrowthForm: "tree", LifespanYears: years(35)}, stageEarlySecondary},
+		{SpeciesRecommendation{GrowthForm: "tree", LifespanYears: years(80)}, stageLateSecondary},
+		{SpeciesRecommendation{GrowthForm: "tree", LifespanYears: years(300)}, stageClimax},
+		{SpeciesRecommendation{GrowthForm: "tree", LifespanYears: years(300), LightRequirement: light("light_demanding")}, stageLateSecondary},
+		{SpeciesRecommendation{GrowthForm: "tree", LifespanYears: years(12), LightRequirement: light("shade_tolerant")}, stageLateSecondary},
+		{SpeciesRecommendation{GrowthForm: "palm", LightRequirement: light("shade_tolerant")}, stageClimax},
+		{SpeciesRecommendation{GrowthForm: "shrub", LightRequirement: light("light_demanding")}, stagePioneer},
+		{SpeciesRecommendation{GrowthForm: "forb", LifespanYears: years(60)}, stagePioneer},
+		{SpeciesRecommendation{GrowthForm: "tree"}, stageUnclassified},
+	}
+	for _, tt := range tests {
+		if got := successionStage(tt.sp); got != tt.want {
+			t.Errorf("successionStage(%s, lifespan %v, light %v) = %s, want %s",
+				tt.sp.GrowthForm, tt.sp.LifespanYears, tt.sp.LightRequirement, got, tt.want)
+		}
+	}
+}
+
+func TestGroupBySuccession(t *testing.T) {
+	years := func(v float64) *float64 { return &v }
+	species := []SpeciesRecommendation{
+		{SpeciesID: 1, GrowthForm: "tree", LifespanYears: years(200)},
+		{SpeciesID: 2, GrowthForm: "graminoid"},
+		{SpeciesID: 3, GrowthForm: "tree", LifespanYears: years(10)},
+	}
+	groups := groupBySuccession(species)
+	if len(groups) != len(successionStages) {
+		t.Fatalf("%d groups", len(groups))
+	}
+	if g := groups[0]; g.Stage != stagePioneer || g.Count != 2 || !reflect.DeepEqual(g.SpeciesIDs, []int64{2, 3}) {
+		t.Errorf("pioneers: %+v", g)
+	}
+	if g := groups[3]; g.Stage != stageClimax || g.Count != 1 {
+		t.Errorf("climax: %+v", g)
+	}
+	if species[0].SuccessionStage != stageClimax {
+		t.Errorf("stage not set on species: %+v", species[0])
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// SPECIES SUITABILITY MAP
+// ============================================================================
+//
+// GET /api/species/{id}/suitability-map?bbox=min_lon,min_lat,max_lon,max_lat
+// grids the bbox, reads WorldClim (worldclim_raster) at each cell center and
+// scores it with calculate_climate_match, the same function behind
+// climate_match_score, so the map shows where in the region the species
+// would rank as a recommendation.
+//
+//	cells=48         cells along the longer side (default 48, at most 128)
+//	format=geojson   one square polygon per cell with data (default), or
+//	format=png       a heat tile, one block of pixels per cell, north up,
+//	                 transparent where there is no climate data
+//
+// Scores of 0 are cells outside the species' temperature tolerance.
+
+const (
+	defaultSuitabilityCells = 48
+	maxSuitabilitySide      = 128
+	suitabilityTilePixels   = 256 // Approximate PNG size of the longer side
+)
+
+// suitabilityGrid is the cell layout over a bbox
+type suitabilityGrid struct {
+	BBox       [4]float64 // min_lon, min_lat, max_lon, max_lat
+	Cols, Rows int
+	DX, DY     float64 // Cell size in degrees
+}
+
+type suitabilityCell struct {
+	Col, Row int // Row 0 is the southern edge
+	Score    float64
+}
+
+// parseBBox reads min_lon,min_lat,max_lon,max_lat
+func parseBBox(v string) ([4]float64, error) {
+	var b [4]float64
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return b, fmt.Errorf("bbox must be min_lon,min_lat,max_lon,max_lat")
+	}
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return b, fmt.Errorf("bbox must be min_lon,min_lat,max_lon,max_lat")
+		}
+		b[i] = f
+	}
+	if b[0] < -180 || b[2] > 180 || b[1] < -90 || b[3] > 90 || b[0] >= b[2] || b[1] >= b[3] {
+		return b, fmt.Errorf("bbox must lie within -180,-90,180,90 with min below max")
+	}
+	return b, nil
+}
+
+// newSuitabilityGrid lays cells square in degrees with side cells along the
+// longer side of the bbox
+func newSuitabilityGrid(bbox [4]float64, side int) suitabilityGrid {
+	width, height := bbox[2]-bbox[0], bbox[3]-bbox[1]
+	size := math.Max(width, height) / float64(side)
+	g := suitabilityGrid{
+		BBox: bbox,
+		Cols: int(math.Max(1, math.Ceil(width/size-1e-9))),
+		Rows: int(math.Max(1, math.Ceil(height/size-1e-9))),
+	}
+	g.DX, g.DY = width/float64(g.Cols), height/float64(g.Rows)
+	return g
+}
+
+// cellPolygon is the GeoJSON ring of a cell
+func (g suitabilityGrid) cellPolygon(col, row int) [][][2]float64 {
+	w := g.BBox[0] + float64(col)*g.DX
+	s := g.BBox[1] + float64(row)*g.DY
+	e, n := w+g.DX, s+g.DY
+	return [][][2]float64{{{w, s}, {e, s}, {e, n}, {w, n}, {w, s}}}
+}
+
+// suitabilityColor ramps from red (0, outside tolerance) through yellow to
+// green (1)
+func suitabilityColor(score float64) color.NRGBA {
+	score = math.Max(0, math.Min(1, score))
+	if score == 0 {
+		return color.NRGBA{R: 200, G: 40, B: 40, A: 90}
+	}
+	if score < 0.5 {
+		return color.NRGBA{R: 230, G: uint8(60 + 390*score), B: 40, A: 200}
+	}
+	return color.NRGBA{R: uint8(230 - 420*(score-0.5)), G: 255 - uint8(100*(score-0.5)), B: 40, A: 200}
+}
+
+// renderSuitabilityPNG draws the cells into a north-up image
+func renderSuitabilityPNG(g suitabilityGrid, cells []suitabilityCell) ([]byte, error) {
+	px := suitabilityTilePixels / max(g.Cols, g.Rows)
+	if px < 1 {
+		px = 1
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, g.Cols*px, g.Rows*px))
+	for _, c := range cells {
+		col := suitabilityColor(c.Score)
+		top := (g.Rows - 1 - c.Row) * px
+		for y := top; y < top+px; y++ {
+			for x := c.Col * px; x < (c.Col+1)*px; x++ {
+				img.SetNRGBA(x, y, col)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleSpeciesSuitabilityMap handles GET /api/species/{id}/suitability-map
+func (s *Server) handleSpeciesSuitabilityMap(w http.ResponseWriter, r *http.Request, id int64) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	bbox, err := parseBBox(query.Get("bbox"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	side := defaultSuitabilityCells
+	if v := query.Get("cells"); v != "" {
+		if side, err = strconv.Atoi(v); err != nil || side < 1 || side > maxSuitabilitySide {
+			http.Error(w, fmt.Sprintf(`{"error": "cells must be between 1 and %d"}`, maxSuitabilitySide), http.StatusBadRequest)
+			return
+		}
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "geojson"
+	}
+	if format != "geojson" && format != "png" {
+		http.Error(w, `{"error": "format must be geojson or png"}`, http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT s.canonical_name FROM species s
+		JOIN species_climate_envelope sce ON sce.species_id = s.id
+		WHERE s.id = $1
+	`, id).Scan(&name)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "No climate envelope for this species"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	g := newSuitabilityGrid(bbox, side)
+	rows, err := s.db.QueryContext(ctx, `
+		WITH cells AS (
+			SELECT i, j, ST_SetSRID(ST_MakePoint($2::float8 + (i + 0.5) * $6::float8, $3::float8 + (j + 0.5) * $7::float8), 4326) AS pt
+			FROM generate_series(0, $4 - 1) i, generate_series(0, $5 - 1) j
+		),
+		climate AS (
+			SELECT c.i, c.j,
+			       MAX(ST_Value(wr.rast, 1, c.pt, true)) FILTER (WHERE wr.bio_var = 'bio1') AS bio1,
+			       MAX(ST_Value(wr.rast, 1, c.pt, true)) FILTER (WHERE wr.bio_var = 'bio5') AS bio5,
+			       MAX(ST_Value(wr.rast, 1, c.pt, true)) FILTER (WHERE wr.bio_var = 'bio6') AS bio6,
+			       MAX(ST_Value(wr.rast, 1, c.pt, true)) FILTER (WHERE wr.bio_var = 'bio12') AS bio12,
+			       MAX(ST_Value(wr.rast, 1, c.pt, true)) FILTER (WHERE wr.bio_var = 'bio15') AS bio15
+			FROM cells c
+			JOIN worldclim_raster wr
+			  ON wr.bio_var IN ('bio1', 'bio5', 'bio6', 'bio12', 'bio15') AND ST_Intersects(wr.rast, c.pt)
+			GROUP BY c.i, c.j
+		)
+		SELECT i, j, calculate_climate_match($1, bio1::numeric, bio5::numeric, bio6::numeric, bio12::numeric, bio15::numeric)
+		FROM climate
+		WHERE bio1 IS NOT NULL AND bio5 IS NOT NULL AND bio6 IS NOT NULL AND bio12 IS NOT NULL AND bio15 IS NOT NULL
+	`, id, g.BBox[0], g.BBox[1], g.Cols, g.Rows, g.DX, g.DY)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var cells []suitabilityCell
+	for rows.Next() {
+		var c suitabilityCell
+		if err := rows.Scan(&c.Col, &c.Row, &c.Score); err != nil {
+			s.log.Printf("Error scanning suitability cell: %v", err)
+			continue
+		}
+		cells = append(cells, c)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	if format == "png" {
+		data, err := renderSuitabilityPNG(g, cells)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("X-BBox", fmt.Sprintf("%g,%g,%g,%g", g.BBox[0], g.BBox[1], g.BBox[2], g.BBox[3]))
+		w.Write(data)
+		return
+	}
+
+	features := make([]map[string]interface{}, 0, len(cells))
+	for _, c := range cells {
+		features = append(features, map[string]interface{}{
+			"type":       "Feature",
+			"properties": map[string]interface{}{"score": c.Score, "col": c.Col, "row": c.Row},
+			"geometry":   map[string]interface{}{"type": "Polygon", "coordinates": g.cellPolygon(c.Col, c.Row)},
+		})
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	writeCompressedJSON(w, r, map[string]interface{}{
+		"type":           "FeatureCollection",
+		"species_id":     id,
+		"canonical_name": name,
+		"bbox":           g.BBox,
+		"cols":           g.Cols,
+		"rows":           g.Rows,
+		"cell_size_deg":  [2]float64{g.DX, g.DY},
+		"features":       features,
+	})
+}
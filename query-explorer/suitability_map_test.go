@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestParseBBox(t *testing.T) {
+	b, err := parseBBox("-54, -30,-44,-20")
+	if err != nil || b != [4]float64{-54, -30, -44, -20} {
+		t.Fatalf("parseBBox: %v %v", b, err)
+	}
+	for _, bad := range []string{"", "1,2,3", "a,b,c,d", "-44,-30,-54,-20", "-190,0,10,10", "0,-20,10,-30"} {
+		if _, err := parseBBox(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestSuitabilityGrid(t *testing.T) {
+	// 10° x 5° at 20 cells along the longer side: 20 x 10 square cells
+	g := newSuitabilityGrid([4]float64{-54, -30, -44, -25}, 20)
+	if g.Cols != 20 || g.Rows != 10 || math.Abs(g.DX-0.5) > 1e-12 || math.Abs(g.DY-0.5) > 1e-12 {
+		t.Errorf("grid %+v", g)
+	}
+	ring := g.cellPolygon(19, 9)[0]
+	if ring[0] != [2]float64{-44.5, -25.5} || ring[2] != [2]float64{-44, -25} || ring[4] != ring[0] {
+		t.Errorf("last cell ring %v", ring)
+	}
+
+	// A thin strip still gets one row
+	if g := newSuitabilityGrid([4]float64{0, 0, 10, 0.01}, 8); g.Rows != 1 || g.Cols != 8 {
+		t.Errorf("strip grid %+v", g)
+	}
+}
+
+func TestRenderSuitabilityPNG(t *testing.T) {
+	g := newSuitabilityGrid([4]float64{0, 0, 4, 2}, 4)
+	data, err := renderSuitabilityPNG(g, []suitabilityCell{{Col: 0, Row: 0, Score: 0.9}, {Col: 3, Row: 1, Score: 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 x 2 cells of 64 px
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 128 {
+		t.Fatalf("size %v", b)
+	}
+	// Row 0 is south, so the scored cell is bottom left
+	if _, _, _, a := img.At(10, 100).RGBA(); a == 0 {
+		t.Error("bottom-left cell not drawn")
+	}
+	if _, _, _, a := img.At(10, 10).RGBA(); a != 0 {
+		t.Error("cell without data should be transparent")
+	}
+	if r, g, _, _ := img.At(200, 10).RGBA(); r <= g {
+		t.Error("score 0 should be drawn red")
+	}
+}
+
+func TestSuitabilityColor(t *testing.T) {
+	low, mid, high := suitabilityColor(0.1), suitabilityColor(0.5), suitabilityColor(1)
+	if !(low.R > low.G && high.G > high.R && mid.R > 200 && mid.G > 200) {
+		t.Errorf("ramp %v %v %v", low, mid, high)
+	}
+}
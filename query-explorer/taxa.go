@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// ============================================================================
+// TAXONOMY BROWSING
+// ============================================================================
+//
+// A family -> genus -> species browser for the admin UI:
+//
+//	GET /api/taxa/families                                 every family
+//	GET /api/taxa/families/{family}/genera                 its genera
+//	GET /api/taxa/families/{family}/genera/{genus}/species its species
+//
+// Families and genera come with their number of species and, per unified
+// trait, the share of those species with a value (trait_coverage) plus the
+// mean over the traits (completeness), so gaps show up at every level. The
+// species list is paginated (see pagination.go; sort=name or -completeness)
+// and names each species' missing traits. Synonyms kept after a taxonomy
+// propagation (see taxonomy_propagation.go) are left out; their data is
+// under the accepted species.
+
+// taxonTraits are the unified traits counted for coverage, in response order
+var taxonTraits = []struct {
+	Name string
+	Expr string
+}{
+	{"growth_form", "su.growth_form"},
+	{"max_height_m", "su.max_height_m"},
+	{"woodiness", "su.woodiness"},
+	{"nitrogen_fixer", "su.nitrogen_fixer"},
+	{"dispersal_syndrome", "su.dispersal_syndrome"},
+	{"deciduousness", "su.deciduousness"},
+	{"lifespan_years", "su.lifespan_years"},
+	{"light_requirement", "su.light_requirement"},
+	{"wetland_indicator", "su.wetland_indicator"},
+	{"threat_status", "su.threat_status"},
+}
+
+const acceptedSpeciesCondition = "s.taxonomic_status IS DISTINCT FROM 'synonym'"
+
+var taxonSpeciesList = listSpec{
+	DefaultLimit: 100,
+	MaxLimit:     500,
+	Sorts: map[string]string{
+		"name":         "s.canonical_name",
+		"completeness": traitCountExpr(),
+	},
+	DefaultSort:   "name",
+	IDColumn:      "s.id",
+	SearchColumns: []string{"s.canonical_name"},
+}
+
+type TaxonSummary struct {
+	Name          string              `json:"name"`
+	NGenera       *int64              `json:"n_genera,omitempty"` // Families only
+	NSpecies      int64               `json:"n_species"`
+	TraitCoverage map[string]*float64 `json:"trait_coverage"`
+	Completeness  *float64            `json:"completeness"`
+}
+
+type TaxonSpecies struct {
+	SpeciesID     int64    `json:"species_id"`
+	CanonicalName string   `json:"canonical_name"`
+	NTraits       int      `json:"n_traits"`
+	MissingTraits []string `json:"missing_traits"`
+}
+
+// TaxonFamiliesResponse is the response of GET /api/taxa/families
+type TaxonFamiliesResponse struct {
+	Traits   []string       `json:"traits"`
+	Families []TaxonSummary `json:"families"`
+}
+
+// TaxonGeneraResponse is the response of GET /api/taxa/families/{family}/genera
+type TaxonGeneraResponse struct {
+	Family string         `json:"family"`
+	Traits []string       `json:"traits"`
+	Genera []TaxonSummary `json:"genera"`
+}
+
+// TaxonSpeciesResponse is one page of
+// GET /api/taxa/families/{family}/genera/{genus}/species
+type TaxonSpeciesResponse struct {
+	Family     string         `json:"family"`
+	Genus      string         `json:"genus"`
+	Traits     []string       `json:"traits"`
+	Species    []TaxonSpecies `json:"species"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+// traitCountExpr is the number of taxonTraits a species has, as SQL
+func traitCountExpr() string {
+	terms := make([]string, len(taxonTraits))
+	for i, t := range taxonTraits {
+		terms[i] = fmt.Sprintf("(%s IS NOT NULL)::int", t.Expr)
+	}
+	return "(" + strings.Join(terms, " + ") + ")"
+}
+
+// traitCoverageSelect counts, per taxonTraits entry, the grouped species
+// with a value
+func traitCoverageSelect() string {
+	cols := make([]string, len(taxonTraits))
+	for i, t := range taxonTraits {
+		cols[i] = fmt.Sprintf("COUNT(*) FILTER (WHERE %s IS NOT NULL)", t.Expr)
+	}
+	return strings.Join(cols, ", ")
+}
+
+// traitCoverage turns the counts of traitCoverageSelect into shares of
+// nSpecies, and their mean
+func traitCoverage(counts []int64, nSpecies int64) (map[string]*float64, *float64) {
+	coverage := make(map[string]*float64, len(taxonTraits))
+	var sum float64
+	for i, t := range taxonTraits {
+		share := coverageShare(counts[i], nSpecies)
+		coverage[t.Name] = share
+		if share != nil {
+			sum += *share
+		}
+	}
+	if nSpecies == 0 {
+		return coverage, nil
+	}
+	mean := math.Round(sum/float64(len(taxonTraits))*1000) / 1000
+	return coverage, &mean
+}
+
+// queryTaxonSummaries runs a grouped query whose rows are the name, the
+// number of genera (kept only withGenera), the number of species and the
+// traitCoverageSelect columns
+func (s *Server) queryTaxonSummaries(r *http.Request, query string, withGenera bool, args ...interface{}) ([]TaxonSummary, error) {
+	rows, err := s.db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taxa := []TaxonSummary{}
+	for rows.Next() {
+		var taxon TaxonSummary
+		var nGenera int64
+		counts := make([]int64, len(taxonTraits))
+		dest := []interface{}{&taxon.Name, &nGenera, &taxon.NSpecies}
+		for i := range counts {
+			dest = append(dest, &counts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if withGenera {
+			taxon.NGenera = &nGenera
+		}
+		taxon.TraitCoverage, taxon.Completeness = traitCoverage(counts, taxon.NSpecies)
+		taxa = append(taxa, taxon)
+	}
+	return taxa, rows.Err()
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleTaxaFamilies handles GET /api/taxa/families
+func (s *Server) handleTaxaFamilies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	families, err := s.queryTaxonSummaries(r, `
+		SELECT s.family, COUNT(DISTINCT s.genus), COUNT(*), `+traitCoverageSelect()+`
+		FROM species s
+		LEFT JOIN species_unified su ON su.species_id = s.id
+		WHERE s.family IS NOT NULL AND `+acceptedSpeciesCondition+`
+		GROUP BY s.family
+		ORDER BY s.family
+	`, true)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeCompressedJSON(w, r, TaxonFamiliesResponse{Traits: taxonTraitNames(), Families: families})
+}
+
+// handleTaxaGenera handles GET /api/taxa/families/{family}/genera
+func (s *Server) handleTaxaGenera(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	family := pathParam(r, "family")
+
+	genera, err := s.queryTaxonSummaries(r, `
+		SELECT COALESCE(s.genus, ''), 0, COUNT(*), `+traitCoverageSelect()+`
+		FROM species s
+		LEFT JOIN species_unified su ON su.species_id = s.id
+		WHERE s.family = $1 AND `+acceptedSpeciesCondition+`
+		GROUP BY s.genus
+		ORDER BY s.genus
+	`, false, family)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if len(genera) == 0 {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Unknown family: "+family), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeCompressedJSON(w, r, TaxonGeneraResponse{Family: family, Traits: taxonTraitNames(), Genera: genera})
+}
+
+// handleTaxaSpecies handles GET /api/taxa/families/{family}/genera/{genus}/species
+func (s *Server) handleTaxaSpecies(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	family, genus := pathParam(r, "family"), pathParam(r, "genus")
+
+	p, err := parseListParams(r, taxonSpeciesList)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	where, tail, args := p.SQL([]interface{}{family, genus})
+	present := make([]string, len(taxonTraits))
+	for i, t := range taxonTraits {
+		present[i] = t.Expr + " IS NOT NULL"
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.canonical_name, `+strings.Join(present, ", ")+`, `+p.CursorColumn()+`
+		FROM species s
+		LEFT JOIN species_unified su ON su.species_id = s.id
+		WHERE s.family = $1 AND s.genus = $2 AND `+acceptedSpeciesCondition+where+`
+		`+tail, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	species := []TaxonSpecies{}
+	var keys []listKey
+	for rows.Next() {
+		var sp TaxonSpecies
+		var cursorValue string
+		has := make([]bool, len(taxonTraits))
+		dest := []interface{}{&sp.SpeciesID, &sp.CanonicalName}
+		for i := range has {
+			dest = append(dest, &has[i])
+		}
+		if err := rows.Scan(append(dest, &cursorValue)...); err != nil {
+			s.log.Printf("Error scanning taxon species row: %v", err)
+			continue
+		}
+		sp.MissingTraits = []string{}
+		for i, t := range taxonTraits {
+			if has[i] {
+				sp.NTraits++
+			} else {
+				sp.MissingTraits = append(sp.MissingTraits, t.Name)
+			}
+		}
+		species = append(species, sp)
+		keys = append(keys, listKey{cursorValue, sp.SpeciesID})
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if len(species) == 0 && p.cursor == nil && p.Search == "" {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, "Unknown genus: "+family+"/"+genus), http.StatusNotFound)
+		return
+	}
+	n, next := p.trim(keys)
+
+	writeCompressedJSON(w, r, TaxonSpeciesResponse{
+		Family:     family,
+		Genus:      genus,
+		Traits:     taxonTraitNames(),
+		Species:    species[:n],
+		NextCursor: next,
+	})
+}
+
+func taxonTraitNames() []string {
+	names := make([]string, len(taxonTraits))
+	for i, t := range taxonTraits {
+		names[i] = t.Name
+	}
+	return names
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraitCoverage(t *testing.T) {
+	counts := make([]int64, len(taxonTraits))
+	counts[0], counts[1] = 4, 2 // growth_form, max_height_m
+	coverage, completeness := traitCoverage(counts, 4)
+	if len(coverage) != len(taxonTraits) {
+		t.Fatalf("coverage has %d traits, want %d", len(coverage), len(taxonTraits))
+	}
+	if *coverage["growth_form"] != 1 || *coverage["max_height_m"] != 0.5 || *coverage["threat_status"] != 0 {
+		t.Errorf("coverage = growth_form %v, max_height_m %v", *coverage["growth_form"], *coverage["max_height_m"])
+	}
+	if completeness == nil || *completeness != 0.15 {
+		t.Errorf("completeness = %v, want 0.15", completeness)
+	}
+
+	if _, completeness := traitCoverage(make([]int64, len(taxonTraits)), 0); completeness != nil {
+		t.Errorf("empty taxon completeness = %v, want nil", *completeness)
+	}
+}
+
+func TestTraitSQL(t *testing.T) {
+	if n := strings.Count(traitCoverageSelect(), "COUNT(*) FILTER"); n != len(taxonTraits) {
+		t.Errorf("traitCoverageSelect has %d counts, want %d", n, len(taxonTraits))
+	}
+	if n := strings.Count(traitCountExpr(), "IS NOT NULL)::int"); n != len(taxonTraits) {
+		t.Errorf("traitCountExpr has %d terms, want %d", n, len(taxonTraits))
+	}
+}
+
+func TestTaxaRouting(t *testing.T) {
+	h := newTestServer().router()
+	for _, tc := range []struct {
+		method, url string
+		want        int
+	}{
+		{"GET", "/api/taxa/", http.StatusNotFound},
+		{"GET", "/api/taxa/genera", http.StatusNotFound},
+		{"GET", "/api/taxa/families/Fabaceae", http.StatusNotFound},
+		{"GET", "/api/taxa/families//genera", http.StatusMovedPermanently},
+		{"GET", "/api/taxa/families/Fabaceae/genera/Inga", http.StatusNotFound},
+		{"POST", "/api/taxa/families", http.StatusMethodNotAllowed},
+		{"GET", "/api/taxa/families/Fabaceae/genera/Inga/species?limit=0", http.StatusBadRequest},
+		{"GET", "/api/taxa/families/Fabaceae/genera/Inga/species?sort=height", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.url, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// TAXONOMY PROPAGATION
+// ============================================================================
+//
+// Synchronizations (the crawlers, scripts/load_gbif_backbone.py
+// --mark-synonyms) only mark a species as a synonym: taxonomic_status =
+// 'synonym' with accepted_name_id. Every TAXONOMY_PROPAGATION_INTERVAL, or on
+// POST /api/admin/taxonomy/propagate, each marked species not yet remapped
+// has its data moved to the accepted species, in one transaction per
+// species:
+//
+//   - per-record rows (traits, observations, occurrences, suggestions, flags)
+//     all move
+//   - keyed rows (regions, common names, elevation ranges, catalog codes,
+//     ...) move unless the accepted species already has that key, in which
+//     case the accepted species' row wins and the synonym's is dropped
+//   - one-row-per-species data (unified traits, envelopes, geometry, ...)
+//     moves only if the accepted species has none
+//   - plan entries move, or add their seedlings to the accepted species'
+//     entry in the same plan; monitored outcomes go with them
+//   - cached recommendations selecting the synonym expire, so the next
+//     request recomputes them with the accepted name
+//
+// The move is recorded in species_remaps (migration 049) with the row counts
+// per table. The synonym's species row is kept, so an old species ID or a
+// name in an old plan export still resolves: GET /api/species/remaps?name=
+// or ?species_id= returns the accepted species.
+
+const maxPropagationBatch = 100
+
+// speciesRemapTable is a table with a species_id column moved by the
+// propagation
+type speciesRemapTable struct {
+	Table string
+
+	// Columns that, with species_id, identify a row; rows the accepted
+	// species has for the same key are kept over the synonym's. Ignored
+	// with PerRecord.
+	Key []string
+
+	// Rows are independent records (no uniqueness on species_id): all move
+	PerRecord bool
+
+	// Run before the move, with $1 the synonym and $2 the accepted species
+	Merge []string
+}
+
+var speciesRemapTables = []speciesRemapTable{
+	{Table: "species_traits", PerRecord: true},
+	{Table: "observations", PerRecord: true},
+	{Table: "gbif_occurrences", PerRecord: true},
+	{Table: "common_name_suggestions", PerRecord: true},
+	{Table: "trait_suggestions", PerRecord: true},
+	{Table: "trait_quality_flags", PerRecord: true},
+
+	{Table: "common_names", Key: []string{"common_name", "language"}},
+	{Table: "species_distribution", Key: []string{"tdwg_code"}},
+	{Table: "species_regions", Key: []string{"tdwg_code"}},
+	{Table: "species_distribution_brazil", Key: []string{"state_code"}},
+	{Table: "species_elevation_ranges", Key: []string{"source"}},
+	{Table: "species_region_climate_match", Key: []string{"tdwg_code"}},
+	// Both species' records count; the class is redone by the next refresh
+	{Table: "species_region_abundance", Key: []string{"tdwg_code"}, Merge: []string{
+		`UPDATE species_region_abundance a
+		 SET n_occurrences = a.n_occurrences + syn.n_occurrences,
+		     density = a.density + syn.density
+		 FROM species_region_abundance syn
+		 WHERE syn.species_id = $1 AND a.species_id = $2 AND a.tdwg_code = syn.tdwg_code`,
+	}},
+	// Until the next POST /api/admin/envelopes, the accepted species' robust
+	// envelope is widened to the synonym's limits
+	{Table: "species_climate_envelope_robust", Key: []string{"method"}, Merge: []string{
+		`UPDATE species_climate_envelope_robust a
+		 SET temp_min = LEAST(a.temp_min, syn.temp_min),
+		     temp_max = GREATEST(a.temp_max, syn.temp_max),
+		     precip_min = LEAST(a.precip_min, syn.precip_min),
+		     precip_max = GREATEST(a.precip_max, syn.precip_max),
+		     cold_month_min = LEAST(a.cold_month_min, syn.cold_month_min),
+		     warm_month_max = GREATEST(a.warm_month_max, syn.warm_month_max),
+		     n_regions_sampled = COALESCE(a.n_regions_sampled, 0) + COALESCE(syn.n_regions_sampled, 0),
+		     updated_at = CURRENT_TIMESTAMP
+		 FROM species_climate_envelope_robust syn
+		 WHERE syn.species_id = $1 AND a.species_id = $2 AND a.method = syn.method`,
+	}},
+	{Table: "nursery_catalog", Key: []string{"partner"}},
+	{Table: "restoration_plan_species", Key: []string{"plan_id"}, Merge: []string{
+		`UPDATE restoration_plan_species a
+		 SET quantity = COALESCE(a.quantity, 0) + COALESCE(syn.quantity, 0)
+		 FROM restoration_plan_species syn
+		 WHERE syn.species_id = $1 AND a.species_id = $2 AND a.plan_id = syn.plan_id
+		   AND (a.quantity IS NOT NULL OR syn.quantity IS NOT NULL)`,
+		`UPDATE planting_outcomes o SET species_id = $2
+		 WHERE o.species_id = $1
+		   AND EXISTS (SELECT 1 FROM restoration_plan_species a WHERE a.plan_id = o.plan_id AND a.species_id = $2)
+		   AND NOT EXISTS (SELECT 1 FROM planting_outcomes x
+		                   WHERE x.plan_id = o.plan_id AND x.species_id = $2 AND x.monitored_at = o.monitored_at)`,
+	}},
+
+	{Table: "species_unified"},
+	{Table: "species_geometry"},
+	{Table: "species_climate_envelope"},
+	{Table: "species_trait_vectors"},
+	{Table: "climate_envelope_gbif"},
+	{Table: "climate_envelope_wcvp"},
+	{Table: "climate_envelope_ecoregion"},
+	{Table: "climate_envelope_analysis"},
+	{Table: "species_koppen_zones"},
+	{Table: "species_brazil_flora"},
+	{Table: "species_soil_tolerance"},
+}
+
+// moveSQL moves the synonym's ($1) rows to the accepted species ($2)
+func (t speciesRemapTable) moveSQL() string {
+	if t.PerRecord {
+		return fmt.Sprintf(`UPDATE %s SET species_id = $2 WHERE species_id = $1`, t.Table)
+	}
+	match := ""
+	for _, col := range t.Key {
+		match += fmt.Sprintf(" AND x.%[1]s = %[2]s.%[1]s", col, t.Table)
+	}
+	return fmt.Sprintf(`UPDATE %[1]s SET species_id = $2 WHERE species_id = $1
+		AND NOT EXISTS (SELECT 1 FROM %[1]s x WHERE x.species_id = $2%[2]s)`, t.Table, match)
+}
+
+// dropSQL deletes what the move left: rows the accepted species already had
+func (t speciesRemapTable) dropSQL() string {
+	if t.PerRecord {
+		return ""
+	}
+	return fmt.Sprintf(`DELETE FROM %s WHERE species_id = $1`, t.Table)
+}
+
+type SpeciesRemap struct {
+	FromSpeciesID int64            `json:"from_species_id"`
+	FromName      string           `json:"from_name"`
+	ToSpeciesID   int64            `json:"to_species_id"`
+	ToName        string           `json:"to_name"`
+	RowsMoved     map[string]int64 `json:"rows_moved,omitempty"`
+	RowsDropped   map[string]int64 `json:"rows_dropped,omitempty"`
+	ExpiredCache  int64            `json:"expired_cache_entries,omitempty"`
+	RemappedAt    string           `json:"remapped_at,omitempty"`
+	Error         string           `json:"error,omitempty"`
+}
+
+type TaxonomyPropagationSummary struct {
+	Remapped []SpeciesRemap `json:"remapped"`
+	Failed   int            `json:"failed"`
+	Duration string         `json:"duration"`
+}
+
+// pendingRemaps lists synonyms with data still under their own ID. A
+// synonym of a synonym waits until the middle one is remapped, which
+// repoints it to the final accepted species.
+func (s *Server) pendingRemaps(ctx context.Context, limit int, skip []int64) ([]SpeciesRemap, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.canonical_name, a.id, a.canonical_name
+		FROM species s
+		JOIN species a ON a.id = s.accepted_name_id
+		WHERE s.taxonomic_status = 'synonym' AND a.id <> s.id
+		  AND a.taxonomic_status IS DISTINCT FROM 'synonym'
+		  AND NOT EXISTS (SELECT 1 FROM species_remaps r WHERE r.from_species_id = s.id)
+		  AND NOT s.id = ANY($2)
+		ORDER BY s.id
+		LIMIT $1
+	`, limit, pq.Int64Array(append([]int64{}, skip...)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []SpeciesRemap
+	for rows.Next() {
+		var rm SpeciesRemap
+		if err := rows.Scan(&rm.FromSpeciesID, &rm.FromName, &rm.ToSpeciesID, &rm.ToName); err != nil {
+			return nil, err
+		}
+		pending = append(pending, rm)
+	}
+	return pending, rows.Err()
+}
+
+// remapSpecies moves one synonym's data and records the remap
+func (s *Server) remapSpecies(ctx context.Context, rm *SpeciesRemap) error {
+	tx, err := s.beginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rm.RowsMoved, rm.RowsDropped = map[string]int64{}, map[string]int64{}
+	exec := func(query string, args ...interface{}) (int64, error) {
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+	for _, t := range speciesRemapTables {
+		for _, q := range t.Merge {
+			if _, err := exec(q, rm.FromSpeciesID, rm.ToSpeciesID); err != nil {
+				return fmt.Errorf("%s: %w", t.Table, err)
+			}
+		}
+		n, err := exec(t.moveSQL(), rm.FromSpeciesID, rm.ToSpeciesID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.Table, err)
+		}
+		if n > 0 {
+			rm.RowsMoved[t.Table] = n
+		}
+		if q := t.dropSQL(); q != "" {
+			if n, err = exec(q, rm.FromSpeciesID); err != nil {
+				return fmt.Errorf("%s: %w", t.Table, err)
+			}
+			if n > 0 {
+				rm.RowsDropped[t.Table] = n
+			}
+		}
+	}
+
+	// Synonyms of the synonym, and remaps onto it, now point at the
+	// accepted species
+	for _, q := range []string{
+		`UPDATE species SET accepted_name_id = $2 WHERE accepted_name_id = $1`,
+		`UPDATE species_remaps SET to_species_id = $2 WHERE to_species_id = $1`,
+	} {
+		if _, err := exec(q, rm.FromSpeciesID, rm.ToSpeciesID); err != nil {
+			return err
+		}
+	}
+	if rm.ExpiredCache, err = exec(`
+		UPDATE recommendation_cache SET expires_at = NOW()
+		WHERE expires_at > NOW() AND $1 = ANY(recommended_species)
+	`, rm.FromSpeciesID); err != nil {
+		return err
+	}
+
+	moved, _ := json.Marshal(rm.RowsMoved)
+	dropped, _ := json.Marshal(rm.RowsDropped)
+	var remappedAt time.Time
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO species_remaps (from_species_id, to_species_id, from_name, to_name, rows_moved, rows_dropped)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING remapped_at
+	`, rm.FromSpeciesID, rm.ToSpeciesID, rm.FromName, rm.ToName, string(moved), string(dropped)).Scan(&remappedAt); err != nil {
+		return err
+	}
+	rm.RemappedAt = remappedAt.Format(time.RFC3339)
+	return tx.Commit()
+}
+
+// runTaxonomyPropagation remaps every pending synonym; one that fails is
+// reported and retried on the next run
+func (s *Server) runTaxonomyPropagation(ctx context.Context) (*TaxonomyPropagationSummary, error) {
+	if !s.taxonomyMu.TryLock() {
+		return nil, fmt.Errorf("a propagation run is already in progress")
+	}
+	defer s.taxonomyMu.Unlock()
+
+	start := time.Now()
+	summary := &TaxonomyPropagationSummary{Remapped: []SpeciesRemap{}}
+	var failed []int64
+	for {
+		pending, err := s.pendingRemaps(ctx, maxPropagationBatch, failed)
+		if err != nil {
+			return nil, err
+		}
+		if len(pending) == 0 {
+			break
+		}
+		for i := range pending {
+			rm := &pending[i]
+			if err := s.remapSpecies(ctx, rm); err != nil {
+				s.log.Printf("Remapping %s to %s failed: %v", rm.FromName, rm.ToName, err)
+				*rm = SpeciesRemap{FromSpeciesID: rm.FromSpeciesID, FromName: rm.FromName,
+					ToSpeciesID: rm.ToSpeciesID, ToName: rm.ToName, Error: err.Error()}
+				failed = append(failed, rm.FromSpeciesID)
+				summary.Failed++
+			}
+			summary.Remapped = append(summary.Remapped, *rm)
+		}
+	}
+	summary.Duration = time.Since(start).String()
+	return summary, nil
+}
+
+func (s *Server) startTaxonomyPropagationJob(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			summary, err := s.runTaxonomyPropagation(context.Background())
+			if err != nil {
+				s.log.Printf("Taxonomy propagation skipped: %v", err)
+				continue
+			}
+			if n := len(summary.Remapped); n > 0 {
+				s.log.Printf("Taxonomy propagation: %d synonyms remapped, %d failed (%s)",
+					n-summary.Failed, summary.Failed, summary.Duration)
+			}
+		}
+	}()
+	s.log.Printf("Taxonomy propagation job scheduled every %s", interval)
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleTaxonomyPropagate handles GET (pending synonyms) and POST (run now)
+// /api/admin/taxonomy/propagate
+func (s *Server) handleTaxonomyPropagate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		pending, err := s.pendingRemaps(ctx, 1000, nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if pending == nil {
+			pending = []SpeciesRemap{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"pending": pending})
+
+	case http.MethodPost:
+		summary, err := s.runTaxonomyPropagation(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(summary)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSpeciesRemaps handles GET /api/species/remaps?species_id= or ?name=
+func (s *Server) handleSpeciesRemaps(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	var where string
+	var arg interface{}
+	switch {
+	case q.Get("species_id") != "":
+		id, err := strconv.ParseInt(q.Get("species_id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, `{"error": "Invalid species_id"}`, http.StatusBadRequest)
+			return
+		}
+		where, arg = "r.from_species_id = $1", id
+	case strings.TrimSpace(q.Get("name")) != "":
+		where, arg = "LOWER(r.from_name) = LOWER($1)", strings.Join(strings.Fields(q.Get("name")), " ")
+	default:
+		http.Error(w, `{"error": "Provide species_id or name"}`, http.StatusBadRequest)
+		return
+	}
+
+	var rm SpeciesRemap
+	var remappedAt time.Time
+	var moved, dropped []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT r.from_species_id, r.from_name, r.to_species_id, a.canonical_name, r.rows_moved, r.rows_dropped, r.remapped_at
+		FROM species_remaps r
+		JOIN species a ON a.id = r.to_species_id
+		WHERE `+where+`
+		ORDER BY r.remapped_at DESC
+		LIMIT 1
+	`, arg).Scan(&rm.FromSpeciesID, &rm.FromName, &rm.ToSpeciesID, &rm.ToName, &moved, &dropped, &remappedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error": "No remap for this species"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	json.Unmarshal(moved, &rm.RowsMoved)
+	json.Unmarshal(dropped, &rm.RowsDropped)
+	rm.RemappedAt = remappedAt.Format(time.RFC3339)
+	json.NewEncoder(w).Encode(rm)
+}
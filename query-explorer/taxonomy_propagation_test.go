@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpeciesRemapTableSQL(t *testing.T) {
+	perRecord := speciesRemapTable{Table: "species_traits", PerRecord: true}
+	if q := perRecord.moveSQL(); strings.Contains(q, "NOT EXISTS") || !strings.Contains(q, "UPDATE species_traits SET species_id = $2") {
+		t.Errorf("per-record move = %q", q)
+	}
+	if q := perRecord.dropSQL(); q != "" {
+		t.Errorf("per-record drop = %q, want none", q)
+	}
+
+	keyed := speciesRemapTable{Table: "common_names", Key: []string{"common_name", "language"}}
+	q := keyed.moveSQL()
+	for _, want := range []string{"x.species_id = $2", "x.common_name = common_names.common_name", "x.language = common_names.language"} {
+		if !strings.Contains(q, want) {
+			t.Errorf("keyed move missing %q: %s", want, q)
+		}
+	}
+	if q := keyed.dropSQL(); q != "DELETE FROM common_names WHERE species_id = $1" {
+		t.Errorf("keyed drop = %q", q)
+	}
+
+	single := speciesRemapTable{Table: "species_unified"}
+	if q := single.moveSQL(); !strings.HasSuffix(strings.TrimSpace(q), "WHERE x.species_id = $2)") {
+		t.Errorf("one-row move = %q", q)
+	}
+}
+
+func TestSpeciesRemapTablesUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, tbl := range speciesRemapTables {
+		if seen[tbl.Table] {
+			t.Errorf("%s listed twice", tbl.Table)
+		}
+		seen[tbl.Table] = true
+		if tbl.PerRecord && len(tbl.Key) > 0 {
+			t.Errorf("%s: Key is ignored with PerRecord", tbl.Table)
+		}
+	}
+}
+
+func TestSpeciesRemapsValidation(t *testing.T) {
+	s := newTestServer()
+	for _, tc := range []struct {
+		method, url string
+		want        int
+	}{
+		{"GET", "/api/species/remaps", http.StatusBadRequest},
+		{"GET", "/api/species/remaps?species_id=abc", http.StatusBadRequest},
+		{"GET", "/api/species/remaps?species_id=-1", http.StatusBadRequest},
+		{"POST", "/api/species/remaps?species_id=1", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		s.handleSpeciesRemaps(w, httptest.NewRequest(tc.method, tc.url, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.url, w.Code, tc.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.handleTaxonomyPropagate(w, httptest.NewRequest("POST", "/api/admin/taxonomy/propagate", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous propagate: %d, want 401", w.Code)
+	}
+}
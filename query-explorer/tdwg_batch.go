@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// BATCH TDWG LOOKUP
+// ============================================================================
+//
+// POST /api/tdwg assigns TDWG regions to many points at once, e.g. a field
+// GPS dataset, in one spatial join instead of a GET per point:
+//
+//	{"points": [{"id": "plot-1", "lat": -15.8, "lon": -47.9}, ...]}
+//
+// Each point gets the region as GET /api/tdwg?lat=&lon= would: the region
+// containing it, else the nearest within 0.5° (with distance_km), else
+// null. Results keep the order of points; id is the caller's label and is
+// echoed back.
+
+const (
+	maxTDWGBatchPoints    = 5000
+	maxTDWGBatchBodyBytes = 1 << 20
+)
+
+type TDWGBatchPoint struct {
+	ID        string  `json:"id,omitempty"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+}
+
+type TDWGPointResult struct {
+	ID        string        `json:"id,omitempty"`
+	Latitude  float64       `json:"lat"`
+	Longitude float64       `json:"lon"`
+	Region    *TDWGResponse `json:"region"` // null when no region is within 0.5°
+}
+
+type TDWGBatchResponse struct {
+	Results      []TDWGPointResult `json:"results"`
+	NMatched     int               `json:"n_matched"`
+	NUnmatched   int               `json:"n_unmatched"`
+	RegionCounts map[string]int    `json:"region_counts"` // Points per TDWG code
+	QueryTime    string            `json:"query_time"`
+}
+
+// validateTDWGBatch checks the points of a batch request
+func validateTDWGBatch(points []TDWGBatchPoint) error {
+	if len(points) == 0 {
+		return fmt.Errorf("points required")
+	}
+	if len(points) > maxTDWGBatchPoints {
+		return fmt.Errorf("at most %d points per request", maxTDWGBatchPoints)
+	}
+	for i, p := range points {
+		if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+			return fmt.Errorf("point %d: lat must be within -90,90 and lon within -180,180", i)
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleTDWGBatch handles POST /api/tdwg
+func (s *Server) handleTDWGBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Points []TDWGBatchPoint `json:"points"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxTDWGBatchBodyBytes)
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if err := validateTDWGBatch(req.Points); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	lats := make([]float64, len(req.Points))
+	lons := make([]float64, len(req.Points))
+	for i, p := range req.Points {
+		lats[i], lons[i] = p.Latitude, p.Longitude
+	}
+
+	start := time.Now()
+	resp := TDWGBatchResponse{Results: make([]TDWGPointResult, len(req.Points)), RegionCounts: map[string]int{}}
+	for i, p := range req.Points {
+		resp.Results[i] = TDWGPointResult{ID: p.ID, Latitude: p.Latitude, Longitude: p.Longitude}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH pts AS (
+			SELECT p.i, ST_SetSRID(ST_Point(p.lon, p.lat), 4326) AS pt
+			FROM unnest($1::float8[], $2::float8[]) WITH ORDINALITY AS p(lon, lat, i)
+		)
+		SELECT p.i, m.level3_code, m.name, m.continent, m.distance
+		FROM pts p
+		CROSS JOIN LATERAL (
+			SELECT t.level3_code, COALESCE(t.level3_name, '') AS name, COALESCE(t.continent, '') AS continent,
+			       CASE WHEN ST_Contains(t.geom, p.pt) THEN 0
+			            ELSE ROUND((ST_Distance(t.geom, p.pt) * 111)::numeric, 2)::float8 END AS distance
+			FROM tdwg_level3 t
+			WHERE ST_DWithin(t.geom, p.pt, 0.5)
+			ORDER BY ST_Contains(t.geom, p.pt) DESC, t.geom <-> p.pt
+			LIMIT 1
+		) m
+	`, pq.Array(lons), pq.Array(lats))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lang := requestLanguage(r)
+	names := map[string]string{}
+	for rows.Next() {
+		var i int
+		var region TDWGResponse
+		if err := rows.Scan(&i, &region.Code, &region.Name, &region.Continent, &region.Distance); err != nil {
+			s.log.Printf("Error scanning TDWG batch row: %v", err)
+			continue
+		}
+		if _, ok := names[region.Code]; !ok {
+			names[region.Code] = s.localize(ctx, nameKindTDWG, region.Code, lang, region.Name)
+		}
+		region.Name = names[region.Code]
+		resp.Results[i-1].Region = &region
+		resp.RegionCounts[region.Code]++
+		resp.NMatched++
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	resp.NUnmatched = len(resp.Results) - resp.NMatched
+	resp.QueryTime = time.Since(start).String()
+	setContentLanguage(w, lang)
+	writeCompressedJSON(w, r, resp)
+}
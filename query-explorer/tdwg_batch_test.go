@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateTDWGBatch(t *testing.T) {
+	if err := validateTDWGBatch([]TDWGBatchPoint{{ID: "a", Latitude: -15.8, Longitude: -47.9}, {Latitude: 0, Longitude: 0}}); err != nil {
+		t.Errorf("valid points: %v", err)
+	}
+	for name, points := range map[string][]TDWGBatchPoint{
+		"empty":     nil,
+		"too many":  make([]TDWGBatchPoint, maxTDWGBatchPoints+1),
+		"latitude":  {{Latitude: 91}},
+		"longitude": {{Latitude: 0, Longitude: -181}},
+	} {
+		if err := validateTDWGBatch(points); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTDWGBatchValidation(t *testing.T) {
+	s := newTestServer()
+	many := strings.Repeat(`{"lat": 1, "lon": 1},`, maxTDWGBatchPoints)
+	for _, tc := range []struct {
+		method, body string
+		want         int
+	}{
+		{http.MethodPut, "", http.StatusMethodNotAllowed},
+		{http.MethodPost, `not json`, http.StatusBadRequest},
+		{http.MethodPost, `{"points": []}`, http.StatusBadRequest},
+		{http.MethodPost, `{"points": [{"lat": -100, "lon": 0}]}`, http.StatusBadRequest},
+		{http.MethodPost, `{"points": [{"lat": 1, "lon": 1, "alt": 3}]}`, http.StatusBadRequest},
+		{http.MethodPost, fmt.Sprintf(`{"points": [%s{"lat": 1, "lon": 1}]}`, many), http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		s.handleTDWG(w, httptest.NewRequest(tc.method, "/api/tdwg", strings.NewReader(tc.body)))
+		if w.Code != tc.want {
+			t.Errorf("%s %.40s: got %d, want %d", tc.method, tc.body, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ============================================================================
+// TDWG GEOJSON
+// ============================================================================
+//
+// The TDWG level-3 polygons as GeoJSON, so the map draws regions from the
+// database instead of a separately maintained static shapefile:
+//
+//	GET /api/tdwg.geojson?bbox=   FeatureCollection of the regions
+//	                              intersecting min_lon,min_lat,max_lon,max_lat
+//	                              (all regions without bbox)
+//	GET /api/tdwg/{code}.geojson  one region, as a Feature
+//
+// Geometries are simplified with ST_SimplifyPreserveTopology at tolerance
+// degrees (default 0.05, at most 1; 0 keeps full detail). A tolerance of one
+// of the cached zoom bands (0.05, 0.005, 0.0005; see geometry_cache.go) reads
+// the precomputed geometries; any other is simplified per request. Names are
+// localized with lang, like the other region endpoints.
+
+const (
+	defaultTDWGTolerance = 0.05
+	maxTDWGTolerance     = 1.0
+)
+
+type TDWGRegionProperties struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	Level2Code string `json:"level2_code"`
+	Continent  string `json:"continent"`
+}
+
+type TDWGFeature struct {
+	Type       string               `json:"type"`
+	ID         string               `json:"id"`
+	Properties TDWGRegionProperties `json:"properties"`
+	Geometry   json.RawMessage      `json:"geometry"`
+}
+
+type TDWGFeatureCollection struct {
+	Type      string        `json:"type"`
+	Tolerance float64       `json:"tolerance"`
+	Features  []TDWGFeature `json:"features"`
+}
+
+// parseTolerance reads the simplification tolerance, in degrees
+func parseTolerance(v string) (float64, error) {
+	if v == "" {
+		return defaultTDWGTolerance, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > maxTDWGTolerance {
+		return 0, fmt.Errorf("tolerance must be between 0 and %g degrees", maxTDWGTolerance)
+	}
+	return f, nil
+}
+
+// layerGeometry returns the join and geometry expression for a
+// geometryLayers table aliased alias at tolerance: the cached band when one
+// matches, else simplified on the fly. Tolerance is a parsed number, so it
+// is safe to format in.
+func layerGeometry(layer, alias string, tolerance float64) (join, expr string) {
+	for _, l := range geometryLevels {
+		if l.Tolerance == tolerance {
+			zoom := l.MaxZoom
+			if zoom < 0 {
+				zoom = 22
+			}
+			return simplifiedGeometry(layer, alias, zoom)
+		}
+	}
+	if tolerance == 0 {
+		return "", alias + ".geom"
+	}
+	return "", fmt.Sprintf("ST_SimplifyPreserveTopology(%s.geom, %g)", alias, tolerance)
+}
+
+// tdwgGeometry is layerGeometry for tdwg_level3 aliased t
+func tdwgGeometry(tolerance float64) (join, expr string) {
+	return layerGeometry("tdwg_level3", "t", tolerance)
+}
+
+// tdwgFeatures reads the regions matching where (on alias t), localized
+func (s *Server) tdwgFeatures(ctx context.Context, where string, tolerance float64, lang string, args ...interface{}) ([]TDWGFeature, error) {
+	join, expr := tdwgGeometry(tolerance)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT t.level3_code, COALESCE(t.level3_name, ''), COALESCE(t.level2_code, ''), COALESCE(t.continent, ''),
+		       ST_AsGeoJSON(%s, 5)
+		FROM tdwg_level3 t
+		%s
+		WHERE t.geom IS NOT NULL AND %s
+		ORDER BY t.level3_code
+	`, expr, join, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	features := []TDWGFeature{}
+	for rows.Next() {
+		var p TDWGRegionProperties
+		var geometry string
+		if err := rows.Scan(&p.Code, &p.Name, &p.Level2Code, &p.Continent, &geometry); err != nil {
+			return nil, err
+		}
+		p.Name = s.localize(ctx, nameKindTDWG, p.Code, lang, p.Name)
+		features = append(features, TDWGFeature{Type: "Feature", ID: p.Code, Properties: p, Geometry: json.RawMessage(geometry)})
+	}
+	return features, rows.Err()
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleTDWGGeoJSON handles GET /api/tdwg.geojson
+func (s *Server) handleTDWGGeoJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	tolerance, err := parseTolerance(query.Get("tolerance"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	where, args := "TRUE", []interface{}{}
+	if v := query.Get("bbox"); v != "" {
+		bbox, err := parseBBox(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		where = "t.geom && ST_MakeEnvelope($1, $2, $3, $4, 4326)"
+		args = append(args, bbox[0], bbox[1], bbox[2], bbox[3])
+	}
+
+	lang := requestLanguage(r)
+	features, err := s.tdwgFeatures(ctx, where, tolerance, lang, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	setContentLanguage(w, lang)
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	writeCompressedJSON(w, r, TDWGFeatureCollection{Type: "FeatureCollection", Tolerance: tolerance, Features: features})
+}
+
+// handleTDWGRegionGeoJSON handles GET /api/tdwg/{code}.geojson
+func (s *Server) handleTDWGRegionGeoJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	code := pathParam(r, "code")
+
+	tolerance, err := parseTolerance(r.URL.Query().Get("tolerance"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	lang := requestLanguage(r)
+	features, err := s.tdwgFeatures(ctx, "UPPER(t.level3_code) = UPPER($1)", tolerance, lang, code)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if len(features) == 0 {
+		http.Error(w, `{"error": "Region not found"}`, http.StatusNotFound)
+		return
+	}
+
+	setContentLanguage(w, lang)
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	writeCompressedJSON(w, r, features[0])
+}
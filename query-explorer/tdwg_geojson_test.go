@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTolerance(t *testing.T) {
+	if got, err := parseTolerance(""); err != nil || got != defaultTDWGTolerance {
+		t.Errorf("default: got %v, %v", got, err)
+	}
+	if got, err := parseTolerance("0.01"); err != nil || got != 0.01 {
+		t.Errorf("0.01: got %v, %v", got, err)
+	}
+	for _, v := range []string{"-0.1", "2", "abc"} {
+		if _, err := parseTolerance(v); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+func TestTDWGGeometry(t *testing.T) {
+	join, expr := tdwgGeometry(0.005)
+	if !strings.Contains(join, "sg.level = 1") || !strings.HasPrefix(expr, "COALESCE(sg.geom") {
+		t.Errorf("cached band: got %s / %s", join, expr)
+	}
+	if join, _ := tdwgGeometry(0.0005); !strings.Contains(join, "sg.level = 2") {
+		t.Errorf("last band: got %s", join)
+	}
+	if join, expr := tdwgGeometry(0.02); join != "" || expr != "ST_SimplifyPreserveTopology(t.geom, 0.02)" {
+		t.Errorf("uncached: got %q / %s", join, expr)
+	}
+	if _, expr := tdwgGeometry(0); expr != "t.geom" {
+		t.Errorf("full detail: got %s", expr)
+	}
+}
+
+func TestTDWGGeoJSONValidation(t *testing.T) {
+	s := newTestServer()
+	routed := s.router().ServeHTTP
+	for _, tc := range []struct {
+		method, path string
+		handler      http.HandlerFunc
+		want         int
+	}{
+		{http.MethodPost, "/api/tdwg.geojson", s.handleTDWGGeoJSON, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/tdwg.geojson?tolerance=5", s.handleTDWGGeoJSON, http.StatusBadRequest},
+		{http.MethodGet, "/api/tdwg.geojson?bbox=1,2,3", s.handleTDWGGeoJSON, http.StatusBadRequest},
+		{http.MethodGet, "/api/tdwg/BZS.geojson?tolerance=x", routed, http.StatusBadRequest},
+		{http.MethodGet, "/api/tdwg/.geojson", routed, http.StatusNotFound},
+		{http.MethodGet, "/api/tdwg/BZS.geojson/extra", routed, http.StatusNotFound},
+		{http.MethodPost, "/api/tdwg/BZS.geojson", routed, http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		tc.handler(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
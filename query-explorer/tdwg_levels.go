@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// TDWG LEVELS 1 AND 2
+// ============================================================================
+//
+// Everything else works on level-3 regions ("botanical countries"). WGSRPD
+// groups them into 52 level-2 regions (two-digit codes, e.g. 84 Brazil) and
+// 9 level-1 continents (one digit, e.g. 8 Southern America); tdwg_level1
+// and tdwg_level2 (migration 056) list them with species counts rolled up
+// from species_regions every TDWG_ROLLUP_INTERVAL (default 24h):
+//
+//	GET /api/tdwg/level1               the continents
+//	GET /api/tdwg/level2?level1=       the regions, optionally of one continent
+//	GET /api/tdwg/level1/{code}        one continent and its regions
+//	GET /api/tdwg/level2/{code}        one region and its level-3 regions
+//
+// A level-2 code is also accepted as tdwg_code in /api/species and
+// /api/recommend, and stands for its level-3 regions: a species qualifies if
+// it does in any of them (native in one is native), and the recommendation
+// climate is the mean of theirs. Plans and compliance checks made for a
+// level-2 code are judged the same way.
+
+// isLevel2Code reports whether code has the form of a level-2 code
+func isLevel2Code(code string) bool {
+	return len(code) == 2 && code[0] >= '0' && code[0] <= '9' && code[1] >= '0' && code[1] <= '9'
+}
+
+// TDWGUnit is a level-1, level-2 or (as child of a level 2) level-3 unit;
+// the counts are null before the first roll-up
+type TDWGUnit struct {
+	Code           string  `json:"code"`
+	Name           string  `json:"name"`
+	Level1Code     string  `json:"level1_code,omitempty"` // Of level-2 units
+	NLevel3        *int64  `json:"n_level3,omitempty"`
+	NSpecies       *int64  `json:"n_species,omitempty"`
+	NNativeSpecies *int64  `json:"n_native_species,omitempty"`
+	RefreshedAt    *string `json:"refreshed_at,omitempty"`
+}
+
+type TDWGUnitsResponse struct {
+	Units []TDWGUnit `json:"units"`
+}
+
+type TDWGUnitResponse struct {
+	TDWGUnit
+	Children []TDWGUnit `json:"children"` // Level-2 units of a continent, level-3 regions of a level 2
+}
+
+// level3Codes returns the level-3 regions of a level-2 code, sorted, or
+// nil for any other code
+func (s *Server) level3Codes(ctx context.Context, code string) ([]string, error) {
+	if !isLevel2Code(code) {
+		return nil, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT level3_code FROM tdwg_level3 WHERE level2_code = $1 ORDER BY level3_code
+	`, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("invalid TDWG code: %s", code)
+	}
+	return codes, nil
+}
+
+// regionCodes returns the level-3 codes the location stands for
+func (loc LocationInfo) regionCodes() []string {
+	if len(loc.Level3Codes) > 0 {
+		return loc.Level3Codes
+	}
+	return []string{loc.TDWGCode}
+}
+
+// multiRegion reports whether the candidate query binds $6 to an array of
+// codes: a border blend, or a level-2 or administrative unit
+func (loc LocationInfo) multiRegion() bool {
+	return len(loc.Blend) > 1 || len(loc.Level3Codes) > 0
+}
+
+// regionStatusJoin is a LEFT JOIN of sr to the status of species in the
+// regions bound at param (a text[]), aggregated over them: native or
+// endemic in any, and the first establishment status in enum order
+func regionStatusJoin(species, param string) string {
+	return fmt.Sprintf(`LEFT JOIN LATERAL (
+		    SELECT BOOL_OR(x.is_native) AS is_native, BOOL_OR(x.is_endemic) AS is_endemic,
+		           MIN(x.establishment_means) AS establishment_means
+		    FROM species_regions x
+		    WHERE x.species_id = %s AND x.tdwg_code = ANY(%s)
+		) sr ON TRUE`, species, param)
+}
+
+// resolveLevel2Location is resolveLocationClimate for a level-2 code: its
+// level-3 regions, with their mean climate
+func (s *Server) resolveLevel2Location(ctx context.Context, code string) (LocationInfo, error) {
+	location := LocationInfo{TDWGCode: code}
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT level2_name FROM tdwg_level2 WHERE level2_code = $1
+	`, code).Scan(&location.TDWGName); err != nil {
+		return location, fmt.Errorf("invalid TDWG code: %s", code)
+	}
+	codes, err := s.level3Codes(ctx, code)
+	if err != nil {
+		return location, err
+	}
+	location.Level3Codes = codes
+
+	// Equal weights: blendedClimate renormalizes over the regions with data
+	regions := make([]BorderRegion, len(codes))
+	for i, c := range codes {
+		regions[i] = BorderRegion{TDWGCode: c, Weight: 1}
+	}
+	return location, s.setBlendedClimate(ctx, &location, regions)
+}
+
+// unitKoppenZone returns the most common Köppen zone of the regions, nil
+// if none is known
+func (s *Server) unitKoppenZone(ctx context.Context, codes []string) *string {
+	var zone sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT koppen_zone FROM tdwg_climate
+		WHERE tdwg_code = ANY($1) AND koppen_zone IS NOT NULL
+		GROUP BY koppen_zone
+		ORDER BY COUNT(*) DESC, koppen_zone
+		LIMIT 1
+	`, pq.Array(codes)).Scan(&zone)
+	if err != nil || !zone.Valid {
+		return nil
+	}
+	return &zone.String
+}
+
+// refreshTDWGRollups recounts the level-1 and level-2 units
+func (s *Server) refreshTDWGRollups(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT refresh_tdwg_rollups()`).Scan(&n)
+	return n, err
+}
+
+// startTDWGRollupJob recounts the units every interval, and at startup if
+// the last refresh is older than that (or never happened)
+func (s *Server) startTDWGRollupJob(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	run := func() {
+		start := time.Now()
+		n, err := s.refreshTDWGRollups(context.Background())
+		if err != nil {
+			s.log.Printf("TDWG roll-up refresh failed: %v", err)
+			return
+		}
+		s.log.Printf("TDWG roll-up refresh: %d units (%s)", n, time.Since(start))
+	}
+
+	go func() {
+		var stale bool
+		err := s.db.QueryRow(`
+			SELECT COALESCE(MAX(refreshed_at) < NOW() - $1::interval, TRUE) FROM tdwg_level2
+		`, fmt.Sprintf("%d seconds", int(interval.Seconds()))).Scan(&stale)
+		if err == nil && stale {
+			run()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run()
+		}
+	}()
+	s.log.Printf("TDWG roll-up job scheduled every %s", interval)
+}
+
+const tdwgUnitCounts = `n_level3, n_species, n_native_species, TO_CHAR(refreshed_at, 'YYYY-MM-DD"T"HH24:MI:SS')`
+
+// tdwgUnits reads level-1 (level 1) or level-2 units matching where,
+// localized
+func (s *Server) tdwgUnits(ctx context.Context, level int, where, lang string, args ...interface{}) ([]TDWGUnit, error) {
+	query := `SELECT level1_code, level1_name, '', ` + tdwgUnitCounts + ` FROM tdwg_level1 WHERE ` + where + ` ORDER BY level1_code`
+	if level == 2 {
+		query = `SELECT level2_code, level2_name, level1_code, ` + tdwgUnitCounts + ` FROM tdwg_level2 WHERE ` + where + ` ORDER BY level2_code`
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	units := []TDWGUnit{}
+	for rows.Next() {
+		var u TDWGUnit
+		if err := rows.Scan(&u.Code, &u.Name, &u.Level1Code, &u.NLevel3, &u.NSpecies, &u.NNativeSpecies, &u.RefreshedAt); err != nil {
+			return nil, err
+		}
+		u.Name = s.localize(ctx, nameKindTDWG, u.Code, lang, u.Name)
+		units = append(units, u)
+	}
+	return units, rows.Err()
+}
+
+// level3Units returns the level-3 regions of a level-2 unit, localized
+func (s *Server) level3Units(ctx context.Context, level2, lang string) ([]TDWGUnit, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT level3_code, COALESCE(level3_name, level3_code)
+		FROM tdwg_level3
+		WHERE level2_code = $1
+		ORDER BY level3_code
+	`, level2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	units := []TDWGUnit{}
+	for rows.Next() {
+		var u TDWGUnit
+		if err := rows.Scan(&u.Code, &u.Name); err != nil {
+			return nil, err
+		}
+		u.Name = s.localize(ctx, nameKindTDWG, u.Code, lang, u.Name)
+		units = append(units, u)
+	}
+	return units, rows.Err()
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// handleTDWGLevel1 handles GET /api/tdwg/level1
+func (s *Server) handleTDWGLevel1(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	lang := requestLanguage(r)
+	units, err := s.tdwgUnits(ctx, 1, "TRUE", lang)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	setContentLanguage(w, lang)
+	writeCompressedJSON(w, r, TDWGUnitsResponse{Units: units})
+}
+
+// handleTDWGLevel2 handles GET /api/tdwg/level2?level1=
+func (s *Server) handleTDWGLevel2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	where, args := "TRUE", []interface{}(nil)
+	if level1 := strings.TrimSpace(r.URL.Query().Get("level1")); level1 != "" {
+		where, args = "level1_code = $1", append(args, level1)
+	}
+	lang := requestLanguage(r)
+	units, err := s.tdwgUnits(ctx, 2, where, lang, args...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	setContentLanguage(w, lang)
+	writeCompressedJSON(w, r, TDWGUnitsResponse{Units: units})
+}
+
+// handleTDWGLevel1Unit handles GET /api/tdwg/level1/{code}
+func (s *Server) handleTDWGLevel1Unit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	code := pathParam(r, "code")
+	lang := requestLanguage(r)
+	units, err := s.tdwgUnits(ctx, 1, "level1_code = $1", lang, code)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if len(units) == 0 {
+		http.Error(w, `{"error": "Unit not found"}`, http.StatusNotFound)
+		return
+	}
+	children, err := s.tdwgUnits(ctx, 2, "level1_code = $1", lang, code)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	setContentLanguage(w, lang)
+	writeCompressedJSON(w, r, TDWGUnitResponse{TDWGUnit: units[0], Children: children})
+}
+
+// handleTDWGLevel2Unit handles GET /api/tdwg/level2/{code}
+func (s *Server) handleTDWGLevel2Unit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	code := pathParam(r, "code")
+	lang := requestLanguage(r)
+	units, err := s.tdwgUnits(ctx, 2, "level2_code = $1", lang, code)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if len(units) == 0 {
+		http.Error(w, `{"error": "Unit not found"}`, http.StatusNotFound)
+		return
+	}
+	children, err := s.level3Units(ctx, code, lang)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	setContentLanguage(w, lang)
+	writeCompressedJSON(w, r, TDWGUnitResponse{TDWGUnit: units[0], Children: children})
+}
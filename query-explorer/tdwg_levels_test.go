@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestIsLevel2Code(t *testing.T) {
+	for code, want := range map[string]bool{"84": true, "10": true, "8": false, "BZS": false, "8A": false, "084": false, "": false} {
+		if got := isLevel2Code(code); got != want {
+			t.Errorf("isLevel2Code(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestLocationRegionCodes(t *testing.T) {
+	single := LocationInfo{TDWGCode: "BZS"}
+	if got := single.regionCodes(); len(got) != 1 || got[0] != "BZS" || single.multiRegion() {
+		t.Errorf("level 3: %v multi=%v", got, single.multiRegion())
+	}
+	unit := LocationInfo{TDWGCode: "84", Level3Codes: []string{"BZC", "BZE", "BZL", "BZN", "BZS"}}
+	if got := unit.regionCodes(); len(got) != 5 || !unit.multiRegion() {
+		t.Errorf("level 2: %v multi=%v", got, unit.multiRegion())
+	}
+}
+
+func TestCandidateRegionSQLLevel2(t *testing.T) {
+	loc := LocationInfo{TDWGCode: "84", Level3Codes: []string{"BZL", "BZS"}}
+	args := []interface{}{1.0, 2.0, 3.0, 4.0, 5.0, "84", 0.6}
+	join, where, weight, args := candidateRegionSQL(loc, "AND sr.is_native = TRUE", args)
+	if !strings.Contains(join, "sr.tdwg_code = ANY($6::text[]) AND sr.is_native = TRUE") || !strings.Contains(join, "GROUP BY sr.species_id") {
+		t.Errorf("join: %s", join)
+	}
+	if where != "TRUE" || weight != "NULL::float8" || len(args) != 7 {
+		t.Errorf("where %q weight %q args %d", where, weight, len(args))
+	}
+	if _, ok := args[5].(driver.Valuer); !ok {
+		t.Errorf("$6 is %T, want the codes array", args[5])
+	}
+	if clause := abundanceFilterSQL(Preferences{MinAbundance: "common"}, loc, 8); !strings.Contains(clause, "ANY($6::text[])") {
+		t.Errorf("abundance clause: %s", clause)
+	}
+}
+
+func TestRegionStatusJoin(t *testing.T) {
+	join := regionStatusJoin("s.id", "$2")
+	for _, want := range []string{"LEFT JOIN LATERAL", "x.species_id = s.id", "x.tdwg_code = ANY($2)", "MIN(x.establishment_means)", ") sr ON TRUE"} {
+		if !strings.Contains(join, want) {
+			t.Errorf("missing %q in %s", want, join)
+		}
+	}
+}
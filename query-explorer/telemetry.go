@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ============================================================================
+// RECOMMENDATION TELEMETRY
+// ============================================================================
+
+// recoTelemetry collects anonymized measurements of a single recommendation
+// run. Only the request shape is kept: no API key, address or coordinates.
+type recoTelemetry struct {
+	LocationKind         string
+	TDWGCode             string
+	NSpeciesRequested    int
+	ClimateThreshold     float64
+	Plugins              []string
+	CandidatePoolSize    int
+	NSelected            int
+	GreedyIterations     int
+	CandidateEvaluations int64
+	Phases               map[string]float64 // milliseconds
+	Metrics              *DiversityMetrics
+	Error                string
+
+	start time.Time
+	last  time.Time
+}
+
+func newRecoTelemetry(req RecommendRequest) *recoTelemetry {
+	kind := "tdwg"
+	switch {
+	case req.TDWGCode != "":
+	case req.StateCode != "":
+		kind = "state"
+	case req.Latitude != nil && req.Longitude != nil:
+		kind = "coords"
+	}
+
+	now := time.Now()
+	return &recoTelemetry{
+		LocationKind:      kind,
+		NSpeciesRequested: req.NSpecies,
+		ClimateThreshold:  req.ClimateThreshold,
+		Plugins:           req.Plugins,
+		Phases:            map[string]float64{},
+		start:             now,
+		last:              now,
+	}
+}
+
+// phase records the time elapsed since the previous phase under name
+func (t *recoTelemetry) phase(name string) {
+	now := time.Now()
+	t.Phases[name] += float64(now.Sub(t.last).Microseconds()) / 1000
+	t.last = now
+}
+
+// greedy records the work done by greedyDiversitySelection: one iteration per
+// species added after the seed, each evaluating every remaining candidate.
+func (t *recoTelemetry) greedy(poolSize, selected int) {
+	if selected < 1 {
+		return
+	}
+	t.GreedyIterations = selected - 1
+	var evals int64
+	for k := 1; k < selected; k++ {
+		evals += int64(poolSize - k)
+	}
+	t.CandidateEvaluations = evals
+}
+
+// recordRecoTelemetry stores t. Failures are logged and otherwise ignored so
+// telemetry never affects the recommendation response.
+func (s *Server) recordRecoTelemetry(t *recoTelemetry) {
+	totalMs := float64(time.Since(t.start).Microseconds()) / 1000
+	phases, _ := json.Marshal(t.Phases)
+
+	var fd, tds sql.NullFloat64
+	var nFamilies, nGrowthForms sql.NullInt64
+	if t.Metrics != nil {
+		fd = sql.NullFloat64{Float64: t.Metrics.FunctionalDiversity, Valid: true}
+		tds = sql.NullFloat64{Float64: t.Metrics.TotalDiversityScore, Valid: true}
+		nFamilies = sql.NullInt64{Int64: int64(t.Metrics.NFamilies), Valid: true}
+		nGrowthForms = sql.NullInt64{Int64: int64(t.Metrics.NGrowthForms), Valid: true}
+	}
+
+	// Recorded after the response, so not bound to the request context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO recommendation_telemetry (
+			location_kind, tdwg_code, n_species_requested, climate_threshold, plugins,
+			candidate_pool_size, n_selected, greedy_iterations, candidate_evaluations,
+			phase_ms, total_ms,
+			functional_diversity, total_diversity_score, n_families, n_growth_forms,
+			error
+		) VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, NULLIF($16, ''))
+	`, t.LocationKind, t.TDWGCode, t.NSpeciesRequested, t.ClimateThreshold, pq.Array(t.Plugins),
+		t.CandidatePoolSize, t.NSelected, t.GreedyIterations, t.CandidateEvaluations,
+		string(phases), totalMs,
+		fd, tds, nFamilies, nGrowthForms,
+		t.Error)
+	if err != nil {
+		s.log.Printf("recommendation telemetry: %v", err)
+	}
+}
+
+// ============================================================================
+// AGGREGATES
+// ============================================================================
+
+type Distribution struct {
+	N    int64   `json:"n"`
+	Min  float64 `json:"min"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+type ThresholdCount struct {
+	ClimateThreshold float64 `json:"climate_threshold"`
+	Requests         int64   `json:"requests"`
+	MedianPoolSize   float64 `json:"median_pool_size"`
+}
+
+type RecoTelemetrySummary struct {
+	Days          int                     `json:"days"`
+	Requests      int64                   `json:"requests"`
+	Errors        int64                   `json:"errors"`
+	Distributions map[string]Distribution `json:"distributions"`
+	PhaseMs       map[string]Distribution `json:"phase_ms"`
+	Thresholds    []ThresholdCount        `json:"thresholds"`
+	LocationKinds map[string]int64        `json:"location_kinds"`
+}
+
+// telemetryDistributionColumns are the numeric columns summarized by
+// /api/admin/reco-telemetry
+var telemetryDistributionColumns = []string{
+	"candidate_pool_size",
+	"n_selected",
+	"greedy_iterations",
+	"candidate_evaluations",
+	"total_ms",
+	"functional_diversity",
+	"total_diversity_score",
+	"n_families",
+	"n_growth_forms",
+}
+
+const distributionSelectSQL = `
+	COUNT(%[1]s),
+	COALESCE(MIN(%[1]s), 0),
+	COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY %[1]s), 0),
+	COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY %[1]s), 0),
+	COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY %[1]s), 0),
+	COALESCE(MAX(%[1]s), 0),
+	COALESCE(AVG(%[1]s), 0)`
+
+func scanDistribution(row interface{ Scan(...interface{}) error }, prefix ...interface{}) (Distribution, error) {
+	var d Distribution
+	dest := append(prefix, &d.N, &d.Min, &d.P50, &d.P90, &d.P99, &d.Max, &d.Mean)
+	err := row.Scan(dest...)
+	return d, err
+}
+
+func (s *Server) summarizeRecoTelemetry(ctx context.Context, days int) (*RecoTelemetrySummary, error) {
+	since := time.Now().AddDate(0, 0, -days)
+	summary := &RecoTelemetrySummary{
+		Days:          days,
+		Distributions: map[string]Distribution{},
+		PhaseMs:       map[string]Distribution{},
+		Thresholds:    []ThresholdCount{},
+		LocationKinds: map[string]int64{},
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(error)
+		FROM recommendation_telemetry
+		WHERE created_at >= $1
+	`, since).Scan(&summary.Requests, &summary.Errors)
+	if err != nil {
+		return nil, err
+	}
+
+	// Distributions are computed over successful runs only
+	for _, col := range telemetryDistributionColumns {
+		query := fmt.Sprintf(`SELECT %s FROM recommendation_telemetry WHERE created_at >= $1 AND error IS NULL`,
+			fmt.Sprintf(distributionSelectSQL, col))
+		d, err := scanDistribution(s.db.QueryRowContext(ctx, query, since))
+		if err != nil {
+			return nil, err
+		}
+		summary.Distributions[col] = d
+	}
+
+	phaseRows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT p.key, %s
+		FROM recommendation_telemetry t, jsonb_each_text(t.phase_ms) p
+		WHERE t.created_at >= $1 AND t.error IS NULL
+		GROUP BY p.key
+	`, fmt.Sprintf(distributionSelectSQL, "p.value::float8")), since)
+	if err != nil {
+		return nil, err
+	}
+	defer phaseRows.Close()
+	for phaseRows.Next() {
+		var name string
+		d, err := scanDistribution(phaseRows, &name)
+		if err != nil {
+			return nil, err
+		}
+		summary.PhaseMs[name] = d
+	}
+	if err := phaseRows.Err(); err != nil {
+		return nil, err
+	}
+
+	thresholdRows, err := s.db.QueryContext(ctx, `
+		SELECT climate_threshold, COUNT(*),
+		       COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY candidate_pool_size), 0)
+		FROM recommendation_telemetry
+		WHERE created_at >= $1 AND climate_threshold IS NOT NULL
+		GROUP BY climate_threshold
+		ORDER BY climate_threshold
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer thresholdRows.Close()
+	for thresholdRows.Next() {
+		var tc ThresholdCount
+		if err := thresholdRows.Scan(&tc.ClimateThreshold, &tc.Requests, &tc.MedianPoolSize); err != nil {
+			return nil, err
+		}
+		summary.Thresholds = append(summary.Thresholds, tc)
+	}
+	if err := thresholdRows.Err(); err != nil {
+		return nil, err
+	}
+
+	kindRows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(location_kind, 'unknown'), COUNT(*)
+		FROM recommendation_telemetry
+		WHERE created_at >= $1
+		GROUP BY 1
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer kindRows.Close()
+	for kindRows.Next() {
+		var kind string
+		var n int64
+		if err := kindRows.Scan(&kind, &n); err != nil {
+			return nil, err
+		}
+		summary.LocationKinds[kind] = n
+	}
+
+	return summary, kindRows.Err()
+}
+
+// handleRecoTelemetry handles GET /api/admin/reco-telemetry?days=30
+func (s *Server) handleRecoTelemetry(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireRole(w, r, roleAdmin); !ok {
+		return
+	}
+
+	days := 30
+	if d := r.URL.Query().Get("days"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 1 || n > 365 {
+			http.Error(w, `{"error": "days must be between 1 and 365"}`, http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	summary, err := s.summarizeRecoTelemetry(ctx, days)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(summary)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoTelemetryShape(t *testing.T) {
+	lat, lon := -25.4, -49.3
+	for want, req := range map[string]RecommendRequest{
+		"tdwg":   {TDWGCode: "BZS", Latitude: &lat, Longitude: &lon},
+		"state":  {StateCode: "BR-PR"},
+		"coords": {Latitude: &lat, Longitude: &lon},
+	} {
+		if got := newRecoTelemetry(req).LocationKind; got != want {
+			t.Errorf("location kind %q, want %q", got, want)
+		}
+	}
+
+	tel := newRecoTelemetry(RecommendRequest{})
+	tel.greedy(10, 4)
+	if tel.GreedyIterations != 3 || tel.CandidateEvaluations != 9+8+7 {
+		t.Errorf("greedy: %d iterations, %d evaluations", tel.GreedyIterations, tel.CandidateEvaluations)
+	}
+}
+
+func TestRecordRecoTelemetry(t *testing.T) {
+	s, db := newFakeDBServer(t, fakeQuery{match: "INSERT INTO recommendation_telemetry"})
+
+	tel := newRecoTelemetry(RecommendRequest{StateCode: "BR-PR", NSpecies: 20, ClimateThreshold: 0.6, Plugins: []string{"nursery", "soil"}})
+	tel.CandidatePoolSize, tel.NSelected = 150, 20
+	tel.phase("candidates")
+	s.recordRecoTelemetry(tel)
+
+	args := db.argsOf("INSERT INTO recommendation_telemetry")
+	if len(args) != 16 {
+		t.Fatalf("inserted %v", args)
+	}
+	if args[0] != "state" || args[1] != "" || args[2] != int64(20) || args[3] != 0.6 || args[4] != `{"nursery","soil"}` ||
+		args[5] != int64(150) || args[6] != int64(20) || args[15] != "" {
+		t.Errorf("inserted %v", args)
+	}
+	var phases map[string]float64
+	if err := json.Unmarshal([]byte(args[9].(string)), &phases); err != nil || len(phases) != 1 {
+		t.Errorf("phase_ms %v: %v", args[9], err)
+	}
+	// Without metrics (a failed run) the metric columns are NULL
+	for i := 11; i <= 14; i++ {
+		if args[i] != nil {
+			t.Errorf("metric arg $%d = %v, want NULL", i+1, args[i])
+		}
+	}
+
+	tel.Metrics = &DiversityMetrics{FunctionalDiversity: 0.4, TotalDiversityScore: 0.7, NFamilies: 9, NGrowthForms: 3}
+	s.recordRecoTelemetry(tel)
+	args = db.args[len(db.args)-1]
+	if args[11] != 0.4 || args[12] != 0.7 || args[13] != int64(9) || args[14] != int64(3) {
+		t.Errorf("metrics inserted as %v", args[11:15])
+	}
+}
+
+func TestHandleRecoTelemetry(t *testing.T) {
+	distribution := func(n int64, min, p50, p90, p99, max, mean float64) []driver.Value {
+		return []driver.Value{n, min, p50, p90, p99, max, mean}
+	}
+	distributionColumns := []string{"n", "min", "p50", "p90", "p99", "max", "mean"}
+	s, db := newFakeDBServer(t, fakeAdminKey,
+		fakeQuery{match: "COUNT(*), COUNT(error)", columns: []string{"count", "errors"}, rows: [][]driver.Value{{int64(12), int64(2)}}},
+		fakeQuery{match: "COUNT(candidate_pool_size)", columns: distributionColumns,
+			rows: [][]driver.Value{distribution(10, 40, 120, 300, 480, 500, 150.5)}},
+		fakeQuery{match: "jsonb_each_text(t.phase_ms)", columns: append([]string{"key"}, distributionColumns...),
+			rows: [][]driver.Value{append([]driver.Value{"candidates"}, distribution(10, 5, 20, 80, 95, 100, 30)...)}},
+		fakeQuery{match: "WHERE created_at >= $1 AND error IS NULL", columns: distributionColumns,
+			rows: [][]driver.Value{distribution(0, 0, 0, 0, 0, 0, 0)}},
+		fakeQuery{match: "GROUP BY climate_threshold", columns: []string{"climate_threshold", "count", "median"},
+			rows: [][]driver.Value{{0.5, int64(4), 200.0}, {0.6, int64(8), 110.0}}},
+		fakeQuery{match: "COALESCE(location_kind, 'unknown')", columns: []string{"kind", "count"},
+			rows: [][]driver.Value{{"tdwg", int64(9)}, {"coords", int64(3)}}},
+	)
+
+	for _, days := range []string{"0", "366", "week"} {
+		req := httptest.NewRequest("GET", "/api/admin/reco-telemetry?days="+days, nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("days=%s: %d, want 400", days, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/admin/reco-telemetry?days=7", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	if since, ok := db.argsOf("COUNT(*), COUNT(error)")[0].(time.Time); !ok || time.Since(since) < 7*24*time.Hour-time.Minute || time.Since(since) > 7*24*time.Hour+time.Minute {
+		t.Errorf("summarized since %v, want 7 days ago", db.argsOf("COUNT(*), COUNT(error)"))
+	}
+
+	var summary RecoTelemetrySummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Days != 7 || summary.Requests != 12 || summary.Errors != 2 {
+		t.Errorf("summary %+v", summary)
+	}
+	if len(summary.Distributions) != len(telemetryDistributionColumns) ||
+		summary.Distributions["candidate_pool_size"] != (Distribution{N: 10, Min: 40, P50: 120, P90: 300, P99: 480, Max: 500, Mean: 150.5}) ||
+		summary.Distributions["total_ms"] != (Distribution{}) {
+		t.Errorf("distributions %+v", summary.Distributions)
+	}
+	if summary.PhaseMs["candidates"] != (Distribution{N: 10, Min: 5, P50: 20, P90: 80, P99: 95, Max: 100, Mean: 30}) || len(summary.PhaseMs) != 1 {
+		t.Errorf("phase_ms %+v", summary.PhaseMs)
+	}
+	wantThresholds := []ThresholdCount{{0.5, 4, 200}, {0.6, 8, 110}}
+	if !reflect.DeepEqual(summary.Thresholds, wantThresholds) {
+		t.Errorf("thresholds %+v, want %+v", summary.Thresholds, wantThresholds)
+	}
+	if !reflect.DeepEqual(summary.LocationKinds, map[string]int64{"tdwg": 9, "coords": 3}) {
+		t.Errorf("location kinds %v", summary.LocationKinds)
+	}
+	if !strings.Contains(w.Body.String(), `"phase_ms":{"candidates"`) {
+		t.Errorf("body %s", w.Body.String())
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// TERRAIN
+// ============================================================================
+//
+// GET /api/elevation?lat=-27.6&lon=-48.65 reads the DEM (dem_raster,
+// migration 041) at a point: the elevation of its cell, and the slope and
+// aspect of the terrain around it from the 3x3 cell window (Horn's method,
+// as in GDAL and ArcGIS). Cells of the window outside the tile or without
+// data take the center's value, which flattens the estimate at tile edges
+// and coastlines rather than failing.
+//
+// Recommendations use the DEM elevation when elevation_mode is set without
+// elevation_m (see elevation.go), so montane filters work from coordinates
+// alone.
+
+const (
+	metersPerDegreeLat = 110574.0
+	metersPerDegreeLon = 111320.0 // At the equator
+)
+
+// errNoElevation reports a point the DEM does not cover
+var errNoElevation = errors.New("no elevation data at this location")
+
+type ElevationResponse struct {
+	Latitude   float64  `json:"lat"`
+	Longitude  float64  `json:"lon"`
+	ElevationM float64  `json:"elevation_m"`
+	SlopeDeg   float64  `json:"slope_deg"`
+	AspectDeg  *float64 `json:"aspect_deg"`  // Compass direction the slope faces; null on flat ground
+	Aspect     string   `json:"aspect"`      // N, NE, ... or flat
+	CellSizeM  float64  `json:"cell_size_m"` // East-west cell size at the point
+	Source     string   `json:"source"`      // dem_raster.source
+	Resolution string   `json:"resolution"`  // dem_raster.resolution
+	QueryTime  string   `json:"query_time"`
+}
+
+// demSample is the DEM around a point
+type demSample struct {
+	window     [3][3]*float64 // Rows north to south, columns west to east
+	cellDegX   float64
+	cellDegY   float64
+	source     string
+	resolution string
+}
+
+// terrain is the slope and aspect of a DEM window, by Horn's method. dx and
+// dy are the cell sizes in meters; aspect is nil where the terrain is flat.
+func terrain(window [3][3]*float64, dx, dy float64) (slopeDeg float64, aspectDeg *float64) {
+	center := window[1][1]
+	var z [3][3]float64
+	for i := range window {
+		for j := range window[i] {
+			switch {
+			case window[i][j] != nil:
+				z[i][j] = *window[i][j]
+			case center != nil:
+				z[i][j] = *center
+			}
+		}
+	}
+	dzdx := ((z[0][2] + 2*z[1][2] + z[2][2]) - (z[0][0] + 2*z[1][0] + z[2][0])) / (8 * dx)
+	dzdy := ((z[2][0] + 2*z[2][1] + z[2][2]) - (z[0][0] + 2*z[0][1] + z[0][2])) / (8 * dy)
+
+	slopeDeg = math.Atan(math.Hypot(dzdx, dzdy)) * 180 / math.Pi
+	if dzdx == 0 && dzdy == 0 {
+		return 0, nil
+	}
+	// atan2 gives the downslope direction counterclockwise from east
+	a := math.Atan2(dzdy, -dzdx) * 180 / math.Pi
+	switch {
+	case a < 0:
+		a = 90 - a
+	case a > 90:
+		a = 360 - a + 90
+	default:
+		a = 90 - a
+	}
+	a = math.Mod(a, 360)
+	return slopeDeg, &a
+}
+
+// aspectLabel names an aspect by its 8-point compass direction
+func aspectLabel(aspectDeg *float64) string {
+	if aspectDeg == nil {
+		return "flat"
+	}
+	labels := []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+	return labels[int(math.Floor(math.Mod(*aspectDeg+22.5, 360)/45))]
+}
+
+// sampleDEM reads the DEM cell at a point and its neighbors
+func (s *Server) sampleDEM(ctx context.Context, lat, lon float64) (*demSample, error) {
+	var sample demSample
+	var window string
+	err := s.db.QueryRowContext(ctx, `
+		WITH p AS (SELECT ST_SetSRID(ST_MakePoint($2, $1), 4326) AS pt)
+		SELECT array_to_json(ST_Neighborhood(d.rast, 1, p.pt, 1, 1, true))::text,
+		       ST_PixelWidth(d.rast), ST_PixelHeight(d.rast), d.source, d.resolution
+		FROM dem_raster d, p
+		WHERE ST_Intersects(d.rast, p.pt)
+		  AND ST_Value(d.rast, 1, p.pt, true) IS NOT NULL
+		ORDER BY ST_PixelWidth(d.rast)
+		LIMIT 1
+	`, lat, lon).Scan(&window, &sample.cellDegX, &sample.cellDegY, &sample.source, &sample.resolution)
+	if err == sql.ErrNoRows {
+		return nil, errNoElevation
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]*float64
+	if err := json.Unmarshal([]byte(window), &rows); err != nil {
+		return nil, fmt.Errorf("reading DEM window: %w", err)
+	}
+	if len(rows) != 3 || len(rows[1]) != 3 || rows[1][1] == nil {
+		return nil, errNoElevation
+	}
+	for i := range rows {
+		for j := 0; j < 3 && j < len(rows[i]); j++ {
+			sample.window[i][j] = rows[i][j]
+		}
+	}
+	return &sample, nil
+}
+
+// fillSiteElevation sets elevation_m from the DEM when elevation_mode is on
+// and the request has coordinates but no elevation
+func (s *Server) fillSiteElevation(ctx context.Context, req *RecommendRequest) error {
+	if req.Preferences.ElevationMode == "" || req.ElevationM != nil || req.Latitude == nil || req.Longitude == nil {
+		return nil
+	}
+	sample, err := s.sampleDEM(ctx, *req.Latitude, *req.Longitude)
+	if err != nil {
+		return fmt.Errorf("elevation_mode without elevation_m: %w", err)
+	}
+	elevation := math.Round(*sample.window[1][1])
+	req.ElevationM = &elevation
+	return nil
+}
+
+// handleElevation handles GET /api/elevation
+func (s *Server) handleElevation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.resolveAOIQuery(r); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	lat, errLat := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lon, errLon := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		http.Error(w, `{"error": "Provide valid lat and lon parameters"}`, http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	sample, err := s.sampleDEM(ctx, lat, lon)
+	if err == errNoElevation {
+		http.Error(w, `{"error": "No elevation data at this location"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.Printf("Error reading DEM at %.5f,%.5f: %v", lat, lon, err)
+		http.Error(w, `{"error": "DEM raster not loaded or unreadable"}`, http.StatusNotFound)
+		return
+	}
+
+	dx := sample.cellDegX * metersPerDegreeLon * math.Cos(lat*math.Pi/180)
+	dy := sample.cellDegY * metersPerDegreeLat
+	slope, aspect := terrain(sample.window, dx, dy)
+	if aspect != nil {
+		rounded := math.Round(*aspect*10) / 10
+		aspect = &rounded
+	}
+
+	json.NewEncoder(w).Encode(ElevationResponse{
+		Latitude:   lat,
+		Longitude:  lon,
+		ElevationM: math.Round(*sample.window[1][1]*10) / 10,
+		SlopeDeg:   math.Round(slope*10) / 10,
+		AspectDeg:  aspect,
+		Aspect:     aspectLabel(aspect),
+		CellSizeM:  math.Round(dx),
+		Source:     sample.source,
+		Resolution: sample.resolution,
+		QueryTime:  time.Since(start).String(),
+	})
+}
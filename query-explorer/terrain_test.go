@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func demWindow(z [3][3]float64) [3][3]*float64 {
+	var w [3][3]*float64
+	for i := range z {
+		for j := range z[i] {
+			v := z[i][j]
+			w[i][j] = &v
+		}
+	}
+	return w
+}
+
+func TestTerrain(t *testing.T) {
+	tests := []struct {
+		name   string
+		z      [3][3]float64
+		slope  float64
+		aspect string
+	}{
+		{"flat", [3][3]float64{{5, 5, 5}, {5, 5, 5}, {5, 5, 5}}, 0, "flat"},
+		// Rising 10 m per 10 m cell eastward: 45°, facing west
+		{"rises east", [3][3]float64{{0, 10, 20}, {0, 10, 20}, {0, 10, 20}}, 45, "W"},
+		{"rises north", [3][3]float64{{20, 20, 20}, {10, 10, 10}, {0, 0, 0}}, 45, "S"},
+		{"rises southwest", [3][3]float64{{10, 0, -10}, {20, 10, 0}, {30, 20, 10}}, math.Atan(math.Sqrt2) * 180 / math.Pi, "NE"},
+	}
+	for _, tc := range tests {
+		slope, aspect := terrain(demWindow(tc.z), 10, 10)
+		if math.Abs(slope-tc.slope) > 1e-9 {
+			t.Errorf("%s: slope %v, want %v", tc.name, slope, tc.slope)
+		}
+		if got := aspectLabel(aspect); got != tc.aspect {
+			t.Errorf("%s: aspect %s (%v), want %s", tc.name, got, aspect, tc.aspect)
+		}
+	}
+
+	// Missing neighbors take the center's value
+	w := demWindow([3][3]float64{{0, 10, 20}, {0, 10, 20}, {0, 10, 20}})
+	w[0][0], w[1][0], w[2][0] = nil, nil, nil
+	if slope, aspect := terrain(w, 10, 10); aspectLabel(aspect) != "W" || slope >= 45 || slope <= 0 {
+		t.Errorf("edge window: slope %v, aspect %s", slope, aspectLabel(aspect))
+	}
+}
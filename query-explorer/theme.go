@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxLogoBytes limits uploaded tenant logos to 1 MB
+const maxLogoBytes = 1 << 20
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// Raster logos only: an SVG can carry script, and the logo is served from
+// the API origin
+var allowedLogoTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+}
+
+// ReportTheme is the whitelabel palette applied by the report renderer
+// (the HTML plan report, /api/plans/{id}/export?format=html)
+type ReportTheme struct {
+	PrimaryColor    string `json:"primary_color"`
+	SecondaryColor  string `json:"secondary_color"`
+	AccentColor     string `json:"accent_color"`
+	TextColor       string `json:"text_color"`
+	BackgroundColor string `json:"background_color"`
+	HasLogo         bool   `json:"has_logo"`
+	LogoURL         string `json:"logo_url,omitempty"`
+}
+
+// defaultReportTheme is the DiversiPlant palette used when a tenant has no theme
+var defaultReportTheme = ReportTheme{
+	PrimaryColor:    "#2E7D32",
+	SecondaryColor:  "#81C784",
+	AccentColor:     "#F9A825",
+	TextColor:       "#212121",
+	BackgroundColor: "#FFFFFF",
+}
+
+// CSSVariables renders the palette as CSS custom properties for HTML output
+func (t ReportTheme) CSSVariables() string {
+	return fmt.Sprintf(":root{--dp-primary:%s;--dp-secondary:%s;--dp-accent:%s;--dp-text:%s;--dp-background:%s}",
+		t.PrimaryColor, t.SecondaryColor, t.AccentColor, t.TextColor, t.BackgroundColor)
+}
+
+// themeForTenant loads a tenant's theme, filling unset colors from the default
+func (s *Server) themeForTenant(ctx context.Context, tenantID int64) (ReportTheme, error) {
+	theme := defaultReportTheme
+
+	var primary, secondary, accent, text, background sql.NullString
+	var hasLogo bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT primary_color, secondary_color, accent_color, text_color, background_color,
+		       logo IS NOT NULL
+		FROM tenant_themes
+		WHERE tenant_id = $1
+	`, tenantID).Scan(&primary, &secondary, &accent, &text, &background, &hasLogo)
+	if err == sql.ErrNoRows {
+		return theme, nil
+	}
+	if err != nil {
+		return theme, err
+	}
+
+	if primary.Valid {
+		theme.PrimaryColor = primary.String
+	}
+	if secondary.Valid {
+		theme.SecondaryColor = secondary.String
+	}
+	if accent.Valid {
+		theme.AccentColor = accent.String
+	}
+	if text.Valid {
+		theme.TextColor = text.String
+	}
+	if background.Valid {
+		theme.BackgroundColor = background.String
+	}
+	if hasLogo {
+		theme.HasLogo = true
+		theme.LogoURL = "/api/tenant/theme/logo"
+	}
+
+	return theme, nil
+}
+
+// tenantLogo returns the stored logo bytes and content type, if any
+func (s *Server) tenantLogo(ctx context.Context, tenantID int64) ([]byte, string, error) {
+	var logo []byte
+	var contentType sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT logo, logo_content_type FROM tenant_themes
+		WHERE tenant_id = $1 AND logo IS NOT NULL
+	`, tenantID).Scan(&logo, &contentType)
+	if err != nil {
+		return nil, "", err
+	}
+	return logo, contentType.String, nil
+}
+
+// handleTenantTheme handles GET/PUT /api/tenant/theme
+func (s *Server) handleTenantTheme(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		key, ok := s.requireTenant(w, r, roleUser)
+		if !ok {
+			return
+		}
+
+		theme, err := s.themeForTenant(ctx, key.TenantID.Int64)
+		if err != nil {
+			s.log.Printf("Error loading tenant theme: %v", err)
+			http.Error(w, `{"error": "Failed to load theme"}`, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(theme)
+
+	case http.MethodPut, http.MethodPost:
+		key, ok := s.requireTenant(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		var req ReportTheme
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		colors := map[string]string{
+			"primary_color":    req.PrimaryColor,
+			"secondary_color":  req.SecondaryColor,
+			"accent_color":     req.AccentColor,
+			"text_color":       req.TextColor,
+			"background_color": req.BackgroundColor,
+		}
+		for field, value := range colors {
+			if value != "" && !hexColorPattern.MatchString(value) {
+				http.Error(w, fmt.Sprintf(`{"error": "%s must be a #RRGGBB color"}`, field), http.StatusBadRequest)
+				return
+			}
+		}
+
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO tenant_themes
+			(tenant_id, primary_color, secondary_color, accent_color, text_color, background_color)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''))
+			ON CONFLICT (tenant_id) DO UPDATE
+			SET primary_color = EXCLUDED.primary_color,
+			    secondary_color = EXCLUDED.secondary_color,
+			    accent_color = EXCLUDED.accent_color,
+			    text_color = EXCLUDED.text_color,
+			    background_color = EXCLUDED.background_color
+		`, key.TenantID.Int64, strings.ToUpper(req.PrimaryColor), strings.ToUpper(req.SecondaryColor),
+			strings.ToUpper(req.AccentColor), strings.ToUpper(req.TextColor), strings.ToUpper(req.BackgroundColor))
+		if err != nil {
+			s.log.Printf("Error saving tenant theme: %v", err)
+			http.Error(w, `{"error": "Failed to save theme"}`, http.StatusInternalServerError)
+			return
+		}
+
+		theme, _ := s.themeForTenant(ctx, key.TenantID.Int64)
+		json.NewEncoder(w).Encode(theme)
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTenantThemeLogo handles GET/POST/DELETE /api/tenant/theme/logo
+// Uploads are multipart/form-data with the image in the "logo" field.
+func (s *Server) handleTenantThemeLogo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		key, ok := s.requireTenant(w, r, roleUser)
+		if !ok {
+			return
+		}
+
+		logo, contentType, err := s.tenantLogo(ctx, key.TenantID.Int64)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "No logo uploaded"}`, http.StatusNotFound)
+			return
+		}
+		if !allowedLogoTypes[contentType] {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		w.Write(logo)
+
+	case http.MethodPost:
+		w.Header().Set("Content-Type", "application/json")
+		key, ok := s.requireTenant(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxLogoBytes+4096)
+		file, _, err := r.FormFile("logo")
+		if err != nil {
+			http.Error(w, `{"error": "Upload the image in a multipart 'logo' field (max 1 MB)"}`, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		logo, err := io.ReadAll(io.LimitReader(file, maxLogoBytes+1))
+		if err != nil || len(logo) == 0 || len(logo) > maxLogoBytes {
+			http.Error(w, `{"error": "Logo must be between 1 byte and 1 MB"}`, http.StatusBadRequest)
+			return
+		}
+
+		contentType := http.DetectContentType(logo)
+		if !allowedLogoTypes[contentType] {
+			http.Error(w, `{"error": "Logo must be PNG or JPEG"}`, http.StatusBadRequest)
+			return
+		}
+
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO tenant_themes (tenant_id, logo, logo_content_type)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (tenant_id) DO UPDATE
+			SET logo = EXCLUDED.logo, logo_content_type = EXCLUDED.logo_content_type
+		`, key.TenantID.Int64, logo, contentType)
+		if err != nil {
+			s.log.Printf("Error saving tenant logo: %v", err)
+			http.Error(w, `{"error": "Failed to save logo"}`, http.StatusInternalServerError)
+			return
+		}
+
+		theme, _ := s.themeForTenant(ctx, key.TenantID.Int64)
+		json.NewEncoder(w).Encode(theme)
+
+	case http.MethodDelete:
+		w.Header().Set("Content-Type", "application/json")
+		key, ok := s.requireTenant(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		s.db.ExecContext(ctx, `UPDATE tenant_themes SET logo = NULL, logo_content_type = NULL WHERE tenant_id = $1`, key.TenantID.Int64)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
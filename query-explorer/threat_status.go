@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ============================================================================
+// THREAT STATUS LABELS
+// ============================================================================
+//
+// threat_status holds IUCN Red List codes (CR, EN, VU...), as do the
+// CNCFlora assessments. The labels and short definitions below are embedded
+// rather than stored in localized_names: the categories are fixed by the
+// IUCN, and the UI must never hardcode their text. Species responses carry
+// threat_status_label in the response language, and GET
+// /api/i18n/threat-status lists every category, in Red List order.
+
+type threatCategoryText struct {
+	Label      string
+	Definition string
+}
+
+type threatCategory struct {
+	Code       string
+	Threatened bool
+	Text       map[string]threatCategoryText // By language
+}
+
+var threatCategories = []threatCategory{
+	{"EX", false, map[string]threatCategoryText{
+		"en": {"Extinct", "No reasonable doubt that the last individual has died."},
+		"pt": {"Extinta", "Não há dúvida razoável de que o último indivíduo morreu."},
+		"es": {"Extinta", "No hay duda razonable de que el último individuo ha muerto."},
+	}},
+	{"EW", false, map[string]threatCategoryText{
+		"en": {"Extinct in the Wild", "Known only to survive in cultivation or well outside its past range."},
+		"pt": {"Extinta na Natureza", "Sobrevive apenas em cultivo ou fora da sua distribuição original."},
+		"es": {"Extinta en Estado Silvestre", "Solo sobrevive en cultivo o fuera de su distribución original."},
+	}},
+	{"CR", true, map[string]threatCategoryText{
+		"en": {"Critically Endangered", "Facing an extremely high risk of extinction in the wild."},
+		"pt": {"Criticamente em Perigo", "Enfrenta risco extremamente alto de extinção na natureza."},
+		"es": {"En Peligro Crítico", "Enfrenta un riesgo extremadamente alto de extinción en estado silvestre."},
+	}},
+	{"EN", true, map[string]threatCategoryText{
+		"en": {"Endangered", "Facing a very high risk of extinction in the wild."},
+		"pt": {"Em Perigo", "Enfrenta risco muito alto de extinção na natureza."},
+		"es": {"En Peligro", "Enfrenta un riesgo muy alto de extinción en estado silvestre."},
+	}},
+	{"VU", true, map[string]threatCategoryText{
+		"en": {"Vulnerable", "Facing a high risk of extinction in the wild."},
+		"pt": {"Vulnerável", "Enfrenta risco alto de extinção na natureza."},
+		"es": {"Vulnerable", "Enfrenta un riesgo alto de extinción en estado silvestre."},
+	}},
+	{"NT", false, map[string]threatCategoryText{
+		"en": {"Near Threatened", "Close to qualifying for a threatened category in the near future."},
+		"pt": {"Quase Ameaçada", "Próxima de se qualificar como ameaçada num futuro próximo."},
+		"es": {"Casi Amenazada", "Cerca de calificar para una categoría de amenaza en el futuro cercano."},
+	}},
+	{"LC", false, map[string]threatCategoryText{
+		"en": {"Least Concern", "Evaluated and widespread or abundant; not threatened."},
+		"pt": {"Menos Preocupante", "Avaliada e de ampla distribuição ou abundante; não ameaçada."},
+		"es": {"Preocupación Menor", "Evaluada y de amplia distribución o abundante; no amenazada."},
+	}},
+	{"DD", false, map[string]threatCategoryText{
+		"en": {"Data Deficient", "Too little information to assess its risk of extinction."},
+		"pt": {"Dados Insuficientes", "Informação insuficiente para avaliar seu risco de extinção."},
+		"es": {"Datos Insuficientes", "Información insuficiente para evaluar su riesgo de extinción."},
+	}},
+	{"NE", false, map[string]threatCategoryText{
+		"en": {"Not Evaluated", "Not yet assessed against the Red List criteria."},
+		"pt": {"Não Avaliada", "Ainda não avaliada pelos critérios da Lista Vermelha."},
+		"es": {"No Evaluada", "Aún no evaluada con los criterios de la Lista Roja."},
+	}},
+}
+
+var threatCategoryByCode = func() map[string]threatCategory {
+	m := make(map[string]threatCategory, len(threatCategories))
+	for _, c := range threatCategories {
+		m[c.Code] = c
+	}
+	return m
+}()
+
+// threatText returns the text of a category in lang, falling back to English
+func (c threatCategory) threatText(lang string) threatCategoryText {
+	if t, ok := c.Text[lang]; ok {
+		return t
+	}
+	return c.Text[defaultLanguage]
+}
+
+// threatStatusLabel returns the label of status in lang; nil for no status,
+// and the code itself for codes the table does not know
+func threatStatusLabel(status *string, lang string) *string {
+	if status == nil || *status == "" {
+		return nil
+	}
+	c, ok := threatCategoryByCode[strings.ToUpper(*status)]
+	if !ok {
+		return status
+	}
+	label := c.threatText(lang).Label
+	return &label
+}
+
+// withThreatLabels returns a copy of species with threat_status_label set
+func withThreatLabels(species []SpeciesRecommendation, lang string) []SpeciesRecommendation {
+	if species == nil {
+		return nil
+	}
+	out := make([]SpeciesRecommendation, len(species))
+	for i, sp := range species {
+		sp.ThreatStatusLabel = threatStatusLabel(sp.ThreatStatus, lang)
+		out[i] = sp
+	}
+	return out
+}
+
+type ThreatCategoryInfo struct {
+	Code       string `json:"code"`
+	Label      string `json:"label"`
+	Definition string `json:"definition"`
+	Threatened bool   `json:"threatened"` // CR, EN and VU
+}
+
+// handleThreatStatuses handles GET /api/i18n/threat-status
+func (s *Server) handleThreatStatuses(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	lang := requestLanguage(r)
+	categories := make([]ThreatCategoryInfo, len(threatCategories))
+	for i, c := range threatCategories {
+		t := c.threatText(lang)
+		categories[i] = ThreatCategoryInfo{Code: c.Code, Label: t.Label, Definition: t.Definition, Threatened: c.Threatened}
+	}
+
+	setContentLanguage(w, lang)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"language":   lang,
+		"categories": categories,
+	})
+}
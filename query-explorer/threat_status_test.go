@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestThreatCategoriesTranslated(t *testing.T) {
+	for _, c := range threatCategories {
+		for lang := range supportedLanguages {
+			if text, ok := c.Text[lang]; !ok || text.Label == "" || text.Definition == "" {
+				t.Errorf("%s: no %s text", c.Code, lang)
+			}
+		}
+		if c.Threatened != threatenedStatuses[c.Code] {
+			t.Errorf("%s: threatened = %v, compliance says %v", c.Code, c.Threatened, threatenedStatuses[c.Code])
+		}
+	}
+}
+
+func TestThreatStatusLabel(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		status *string
+		lang   string
+		want   *string
+	}{
+		{str("EN"), "pt", str("Em Perigo")},
+		{str("vu"), "es", str("Vulnerable")},
+		{str("CR"), "fr", str("Critically Endangered")},
+		{str("LR/cd"), "pt", str("LR/cd")}, // Unknown codes are kept
+		{str(""), "pt", nil},
+		{nil, "pt", nil},
+	}
+	for _, tt := range tests {
+		got := threatStatusLabel(tt.status, tt.lang)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("threatStatusLabel(%v, %s) = %v, want %v", tt.status, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestWithThreatLabelsCopies(t *testing.T) {
+	status := "NT"
+	species := []SpeciesRecommendation{{SpeciesID: 1, ThreatStatus: &status}, {SpeciesID: 2}}
+	labeled := withThreatLabels(species, "pt")
+	if species[0].ThreatStatusLabel != nil {
+		t.Error("input modified")
+	}
+	if labeled[0].ThreatStatusLabel == nil || *labeled[0].ThreatStatusLabel != "Quase Ameaçada" || labeled[1].ThreatStatusLabel != nil {
+		t.Errorf("labels: %v, %v", labeled[0].ThreatStatusLabel, labeled[1].ThreatStatusLabel)
+	}
+}
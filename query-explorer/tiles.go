@@ -0,0 +1,276 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// VECTOR TILES
+// ============================================================================
+//
+// GET /tiles/{layer}/{z}/{x}/{y}.mvt serves Mapbox Vector Tiles built with
+// ST_AsMVT, so the dashboard renders global layers without downloading
+// every polygon:
+//
+//	tdwg        TDWG level-3 regions (code, name, level2_code, continent)
+//	ecoregions  ecoregions (eco_id, eco_name, biome_name, realm)
+//	richness    TDWG regions with their species counts (n_species,
+//	            n_native, n_endemic) for a choropleth
+//
+// Polygons come from the simplified geometry cache for the tile's zoom (see
+// geometry_cache.go) and are clipped to the Web Mercator latitude range. Tiles
+// are cached in memory for TILE_CACHE_TTL (24h), at most TILE_CACHE_ENTRIES
+// of them (least recently used out first), and sent with a public
+// Cache-Control of the same age. Empty tiles are 204.
+//
+// Every uncached tile is an ST_AsMVT render, so renders are bounded apart
+// from the API limits (cache hits are not):
+//
+//   - zoom goes up to TILE_MAX_ZOOM (10; the layers are regional, and maps
+//     overzoom beyond it), which bounds the tiles that can be asked for
+//   - TILE_RENDER_RATE_LIMIT ("120/m") renders per client IP; the
+//     RATE_LIMIT_EXEMPT networks are exempt
+//   - renders are shed like the expensive API endpoints while the load
+//     breaker is open (see load_shedding.go), and count toward it
+
+const (
+	defaultTileCacheTTL  = 24 * time.Hour
+	defaultTileCacheSize = 20000
+	defaultTileMaxZoom   = 10
+	maxTileZoom          = 14 // Upper bound of TILE_MAX_ZOOM
+	defaultTileRateLimit = "120/m"
+	tileQueryTimeout     = 30 * time.Second
+	webMercatorMaxLat    = 85.05112878
+)
+
+// tileLayer is an MVT layer; Query selects its features at a zoom, within
+// the tile envelope in bounds.geom (EPSG:3857) and bounds.geom4326
+type tileLayer struct {
+	Query func(zoom int) string
+}
+
+// mvtGeometry is the MVT geometry column for the EPSG:4326 polygons selected
+// by geom
+func mvtGeometry(geom string) string {
+	return fmt.Sprintf(`ST_AsMVTGeom(ST_Transform(ST_ClipByBox2D(%s, ST_MakeEnvelope(-180, -%[2]g, 180, %[2]g, 4326)), 3857), bounds.geom, 4096, 64, true) AS geom`,
+		geom, webMercatorMaxLat)
+}
+
+var tileLayers = map[string]tileLayer{
+	"tdwg": {Query: func(zoom int) string {
+		join, expr := simplifiedGeometry("tdwg_level3", "t", zoom)
+		return fmt.Sprintf(`
+			SELECT t.level3_code AS code, COALESCE(t.level3_name, '') AS name,
+			       COALESCE(t.level2_code, '') AS level2_code, COALESCE(t.continent, '') AS continent, %s
+			FROM tdwg_level3 t %s, bounds
+			WHERE t.geom && bounds.geom4326`, mvtGeometry(expr), join)
+	}},
+	"ecoregions": {Query: func(zoom int) string {
+		join, expr := simplifiedGeometry("ecoregion", "e", zoom)
+		return fmt.Sprintf(`
+			SELECT e.eco_id, COALESCE(e.eco_name, '') AS eco_name,
+			       COALESCE(e.biome_name, '') AS biome_name, COALESCE(e.realm, '') AS realm, %s
+			FROM ecoregions e %s, bounds
+			WHERE e.geom && bounds.geom4326`, mvtGeometry(expr), join)
+	}},
+	"richness": {Query: func(zoom int) string {
+		join, expr := simplifiedGeometry("tdwg_level3", "t", zoom)
+		return fmt.Sprintf(`
+			SELECT t.level3_code AS code, COALESCE(t.level3_name, '') AS name,
+			       c.n_species, c.n_native, c.n_endemic, %s
+			FROM tdwg_level3 t %s
+			CROSS JOIN LATERAL (
+				SELECT COUNT(*) AS n_species,
+				       COUNT(*) FILTER (WHERE sr.is_native) AS n_native,
+				       COUNT(*) FILTER (WHERE sr.is_endemic) AS n_endemic
+				FROM species_regions sr WHERE sr.tdwg_code = t.level3_code
+			) c, bounds
+			WHERE t.geom && bounds.geom4326`, mvtGeometry(expr), join)
+	}},
+}
+
+type tileConfig struct {
+	CacheTTL   time.Duration
+	CacheSize  int
+	MaxZoom    int
+	RenderRate rateLimit // Per client IP; zero is unlimited
+}
+
+// loadTileConfig reads TILE_CACHE_TTL, TILE_CACHE_ENTRIES, TILE_MAX_ZOOM and
+// TILE_RENDER_RATE_LIMIT
+func loadTileConfig() tileConfig {
+	c := tileConfig{
+		CacheTTL:  getEnvDuration("TILE_CACHE_TTL", defaultTileCacheTTL),
+		CacheSize: getEnvInt("TILE_CACHE_ENTRIES", defaultTileCacheSize),
+		MaxZoom:   getEnvInt("TILE_MAX_ZOOM", defaultTileMaxZoom),
+	}
+	if c.MaxZoom < 0 || c.MaxZoom > maxTileZoom {
+		log.Printf("Invalid TILE_MAX_ZOOM=%d, using %d", c.MaxZoom, defaultTileMaxZoom)
+		c.MaxZoom = defaultTileMaxZoom
+	}
+	var err error
+	if c.RenderRate, err = parseRateLimit(getEnv("TILE_RENDER_RATE_LIMIT", defaultTileRateLimit)); err != nil {
+		log.Printf("TILE_RENDER_RATE_LIMIT: %v, using %s", err, defaultTileRateLimit)
+		c.RenderRate, _ = parseRateLimit(defaultTileRateLimit)
+	}
+	return c
+}
+
+// tileCoord is a tile address in the XYZ scheme
+type tileCoord struct {
+	Z, X, Y int
+}
+
+// parseTilePath reads /tiles/{layer}/{z}/{x}/{y}.mvt, up to zoom maxZoom
+func parseTilePath(path string, maxZoom int) (string, tileCoord, error) {
+	var c tileCoord
+	parts := strings.Split(strings.TrimPrefix(path, "/tiles/"), "/")
+	if len(parts) != 4 || !strings.HasSuffix(parts[3], ".mvt") {
+		return "", c, fmt.Errorf("use /tiles/{layer}/{z}/{x}/{y}.mvt")
+	}
+	if _, ok := tileLayers[parts[0]]; !ok {
+		return "", c, fmt.Errorf("unknown layer %q (tdwg, ecoregions or richness)", parts[0])
+	}
+	var err error
+	nums := []*int{&c.Z, &c.X, &c.Y}
+	for i, v := range []string{parts[1], parts[2], strings.TrimSuffix(parts[3], ".mvt")} {
+		if *nums[i], err = strconv.Atoi(v); err != nil {
+			return "", c, fmt.Errorf("use /tiles/{layer}/{z}/{x}/{y}.mvt")
+		}
+	}
+	if c.Z < 0 || c.Z > maxZoom {
+		return "", c, fmt.Errorf("zoom must be between 0 and %d", maxZoom)
+	}
+	if n := 1 << c.Z; c.X < 0 || c.X >= n || c.Y < 0 || c.Y >= n {
+		return "", c, fmt.Errorf("tile %d/%d/%d does not exist", c.Z, c.X, c.Y)
+	}
+	return parts[0], c, nil
+}
+
+// renderTile builds one tile of layer
+func (s *Server) renderTile(ctx context.Context, layer string, c tileCoord) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, tileQueryTimeout)
+	defer cancel()
+
+	var tile []byte
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`
+		WITH bounds AS (
+			SELECT ST_TileEnvelope($1, $2, $3) AS geom,
+			       ST_Transform(ST_TileEnvelope($1, $2, $3), 4326) AS geom4326
+		)
+		SELECT COALESCE(ST_AsMVT(f, '%s', 4096, 'geom'), '')
+		FROM (%s) f
+		WHERE f.geom IS NOT NULL
+	`, layer, tileLayers[layer].Query(c.Z)), c.Z, c.X, c.Y).Scan(&tile)
+	return tile, err
+}
+
+// ============================================================================
+// HTTP HANDLERS
+// ============================================================================
+
+// allowTileRender checks the client's render limit and the load breaker
+// before an uncached render; on refusal it writes the 429 or 503
+func (s *Server) allowTileRender(w http.ResponseWriter, r *http.Request, cfg tileConfig, limiter *rateLimiter, now time.Time) bool {
+	if ip := clientIP(r); cfg.RenderRate.Requests > 0 && (ip == nil || !s.cfg.RateLimits.isExempt(ip)) {
+		if ok, _, wait := limiter.take(ip.String(), cfg.RenderRate, 1, now); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, `{"error": "Tile render rate limit exceeded, retry later"}`, http.StatusTooManyRequests)
+			return false
+		}
+	}
+	if ok, wait := s.breaker.allow(now); !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+		http.Error(w, `{"error": "The database is overloaded; retry later"}`, http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// tileHandler serves /tiles/ under cfg
+func (s *Server) tileHandler(cfg tileConfig) http.Handler {
+	// publicCache serves here as a plain body cache
+	cache := newPublicCache(max(cfg.CacheSize, 1))
+	limiter := newRateLimiter()
+	if cfg.RenderRate.Requests > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				limiter.sweep(now.Add(-cfg.RenderRate.Period))
+			}
+		}()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error": "GET required"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		layer, c, err := parseTilePath(r.URL.Path, cfg.MaxZoom)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusNotFound)
+			return
+		}
+
+		now := time.Now()
+		key := fmt.Sprintf("%s/%d/%d/%d", layer, c.Z, c.X, c.Y)
+		resp := cache.get(key, now)
+		if resp != nil {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+			if !s.allowTileRender(w, r, cfg, limiter, now) {
+				return
+			}
+			start := time.Now()
+			tile, err := s.renderTile(r.Context(), layer, c)
+			status := http.StatusOK
+			if err != nil {
+				status = http.StatusInternalServerError
+			}
+			s.breaker.observe(time.Since(start), status, true)
+			if err != nil {
+				s.log.Printf("Error rendering tile %s: %v", key, err)
+				w.Header().Set("Content-Type", "application/json")
+				http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusInternalServerError)
+				return
+			}
+			resp = &publicResponse{status: http.StatusOK, body: tile, expires: now.Add(cfg.CacheTTL)}
+			if cfg.CacheTTL > 0 {
+				cache.put(key, resp, now)
+			}
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(cfg.CacheTTL.Seconds())))
+		if len(resp.body) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if !acceptsGzip(r) {
+			w.Write(resp.body)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		gz.Write(resp.body)
+	})
+}
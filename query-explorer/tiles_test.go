@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTilePath(t *testing.T) {
+	layer, c, err := parseTilePath("/tiles/richness/3/4/5.mvt", maxTileZoom)
+	if err != nil || layer != "richness" || c != (tileCoord{3, 4, 5}) {
+		t.Errorf("got %q %+v %v", layer, c, err)
+	}
+	for _, path := range []string{
+		"/tiles/tdwg/3/4/5.png",
+		"/tiles/tdwg/3/4.mvt",
+		"/tiles/rivers/3/4/5.mvt",
+		"/tiles/tdwg/a/4/5.mvt",
+		"/tiles/tdwg/15/0/0.mvt",
+		"/tiles/tdwg/11/0/0.mvt", // Past defaultTileMaxZoom
+		"/tiles/tdwg/2/4/0.mvt",
+		"/tiles/tdwg/2/0/-1.mvt",
+	} {
+		if _, _, err := parseTilePath(path, defaultTileMaxZoom); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestTileLayerQueries(t *testing.T) {
+	for name, layer := range tileLayers {
+		q := layer.Query(6)
+		if !strings.Contains(q, "sg.level = 1") || !strings.Contains(q, "bounds.geom4326") || !strings.Contains(q, "ST_AsMVTGeom") {
+			t.Errorf("%s: got %s", name, q)
+		}
+	}
+	if q := tileLayers["richness"].Query(0); !strings.Contains(q, "n_endemic") {
+		t.Errorf("richness: got %s", q)
+	}
+}
+
+func TestTileHandlerValidation(t *testing.T) {
+	h := newTestServer().tileHandler(tileConfig{CacheTTL: defaultTileCacheTTL, CacheSize: 10, MaxZoom: defaultTileMaxZoom})
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/tiles/tdwg/0/0/0.mvt", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/tiles/nope/0/0/0.mvt", http.StatusNotFound},
+		{http.MethodGet, "/tiles/tdwg/1/2/0.mvt", http.StatusNotFound},
+		{http.MethodGet, "/tiles/tdwg/12/0/0.mvt", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
+
+func TestAllowTileRender(t *testing.T) {
+	s := newTestServer()
+	s.cfg.RateLimits.exempt = nil
+	cfg := tileConfig{RenderRate: rateLimit{Requests: 2, Period: time.Minute}}
+	limiter := newRateLimiter()
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/tiles/tdwg/3/4/5.mvt", nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+		if got := s.allowTileRender(w, r, cfg, limiter, now); got != want {
+			t.Errorf("render %d: allowed %v, want %v", i+1, got, want)
+		}
+		if !want && (w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "") {
+			t.Errorf("render %d: got %d, Retry-After %q", i+1, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+}
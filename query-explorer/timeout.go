@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// STATEMENT TIMEOUTS
+// ============================================================================
+//
+// Every /api/ request carries a deadline derived from its endpoint's
+// statement timeout. Queries run with the request context, so lib/pq cancels
+// them on the server when the deadline passes or the client disconnects;
+// transactions additionally SET LOCAL statement_timeout to the time left.
+//
+// STATEMENT_TIMEOUT sets the default (30s), STATEMENT_TIMEOUTS overrides per
+// endpoint: "/api/query=10s,/api/recommend=45s". A trailing slash matches the
+// subtree, like http.ServeMux patterns.
+
+const defaultStatementTimeout = 30 * time.Second
+
+// Endpoints that legitimately run longer have their own default timeouts,
+// set in routeRegistry (see routes.go)
+
+type statementTimeouts struct {
+	fallback  time.Duration
+	endpoints map[string]time.Duration
+}
+
+func loadStatementTimeouts() statementTimeouts {
+	t := statementTimeouts{
+		fallback:  getEnvDuration("STATEMENT_TIMEOUT", defaultStatementTimeout),
+		endpoints: make(map[string]time.Duration),
+	}
+	for _, rt := range routeRegistry() {
+		if rt.Timeout > 0 {
+			t.endpoints[rt.Pattern] = rt.Timeout
+		}
+	}
+
+	for _, entry := range strings.Split(os.Getenv("STATEMENT_TIMEOUTS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, value, ok := strings.Cut(entry, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || err != nil || !strings.HasPrefix(path, "/") {
+			log.Printf("Invalid STATEMENT_TIMEOUTS entry %q, ignoring", entry)
+			continue
+		}
+		t.endpoints[strings.TrimSpace(path)] = d
+	}
+	return t
+}
+
+// forPath returns the timeout for a request path: exact match first, then
+// the longest matching subtree; 0 means no deadline
+func (t statementTimeouts) forPath(path string) time.Duration {
+	if d, ok := t.endpoints[path]; ok {
+		return d
+	}
+	best, bestLen := t.fallback, 0
+	for pattern, d := range t.endpoints {
+		if patternCovers(pattern, path) && len(pattern) > bestLen {
+			best, bestLen = d, len(pattern)
+		}
+	}
+	return best
+}
+
+// statementTimeoutMiddleware attaches the endpoint deadline to API requests.
+// The dashboard proxy and static files are left alone (long-lived websockets).
+func statementTimeoutMiddleware(next http.Handler, timeouts statementTimeouts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d := timeouts.forPath(r.URL.Path)
+		if d <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// beginTx starts a transaction bound to ctx with the server-side
+// statement_timeout set to the time left before ctx's deadline
+func (s *Server) beginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", ms)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// isTimeout reports whether err comes from a cancelled or expired request
+// (context error or Postgres query_canceled)
+func isTimeout(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	return ctx.Err() != nil || strings.Contains(err.Error(), "canceling statement due to statement timeout")
+}
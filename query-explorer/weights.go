@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ============================================================================
+// SELECTION AND METRIC WEIGHTS
+// ============================================================================
+//
+// The greedy selection scores each candidate as
+// diversity_weight * marginal diversity + climate_weight * climate match, and
+// the total diversity score weighs functional diversity, the family-level
+// phylogenetic proxy and growth-form richness. Requests may tune both: a
+// planner favouring climate safety raises climate_weight. Weights must be
+// within [0, 1] and are normalized to sum to 1; a single selection weight
+// implies the other (diversity_weight 0.4 means climate_weight 0.6).
+
+const (
+	defaultDiversityWeight = 0.7
+	defaultClimateWeight   = 0.3
+)
+
+type MetricWeights struct {
+	Functional   float64 `json:"functional"`
+	Phylogenetic float64 `json:"phylogenetic"`
+	GrowthForm   float64 `json:"growth_form"`
+}
+
+var defaultMetricWeights = MetricWeights{Functional: 0.5, Phylogenetic: 0.25, GrowthForm: 0.25}
+
+// orDefault returns w, or the defaults for the zero value
+func (w MetricWeights) orDefault() MetricWeights {
+	if w == (MetricWeights{}) {
+		return defaultMetricWeights
+	}
+	return w
+}
+
+func checkWeight(name string, w float64) error {
+	if math.IsNaN(w) || w < 0 || w > 1 {
+		return fmt.Errorf("%s must be between 0 and 1", name)
+	}
+	return nil
+}
+
+// normalizeWeights validates the weight fields of req and fills them in, so
+// that they are part of the cache key and echoed in the response
+func normalizeWeights(req *RecommendRequest) error {
+	diversity, climate := defaultDiversityWeight, defaultClimateWeight
+	switch {
+	case req.DiversityWeight != nil && req.ClimateWeight != nil:
+		diversity, climate = *req.DiversityWeight, *req.ClimateWeight
+	case req.DiversityWeight != nil:
+		diversity = *req.DiversityWeight
+		climate = 1 - diversity
+	case req.ClimateWeight != nil:
+		climate = *req.ClimateWeight
+		diversity = 1 - climate
+	}
+	if err := checkWeight("diversity_weight", diversity); err != nil {
+		return err
+	}
+	if err := checkWeight("climate_weight", climate); err != nil {
+		return err
+	}
+	sum := diversity + climate
+	if sum == 0 {
+		return fmt.Errorf("diversity_weight and climate_weight cannot both be 0")
+	}
+	diversity, climate = diversity/sum, climate/sum
+	req.DiversityWeight, req.ClimateWeight = &diversity, &climate
+
+	metrics := defaultMetricWeights
+	if req.MetricWeights != nil {
+		metrics = *req.MetricWeights
+		for name, w := range map[string]float64{
+			"metric_weights.functional":   metrics.Functional,
+			"metric_weights.phylogenetic": metrics.Phylogenetic,
+			"metric_weights.growth_form":  metrics.GrowthForm,
+		} {
+			if err := checkWeight(name, w); err != nil {
+				return err
+			}
+		}
+		sum := metrics.Functional + metrics.Phylogenetic + metrics.GrowthForm
+		if sum == 0 {
+			return fmt.Errorf("metric_weights cannot all be 0")
+		}
+		metrics = MetricWeights{metrics.Functional / sum, metrics.Phylogenetic / sum, metrics.GrowthForm / sum}
+	}
+	req.MetricWeights = &metrics
+	return nil
+}
+
+// selectionWeights returns the normalized selection weights of req
+func (r *RecommendRequest) selectionWeights() (diversity, climate float64) {
+	if r.DiversityWeight == nil || r.ClimateWeight == nil {
+		return defaultDiversityWeight, defaultClimateWeight
+	}
+	return *r.DiversityWeight, *r.ClimateWeight
+}
+
+// metricWeights returns the normalized metric weights of req
+func (r *RecommendRequest) metricWeights() MetricWeights {
+	if r.MetricWeights == nil {
+		return defaultMetricWeights
+	}
+	return *r.MetricWeights
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeWeights(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name               string
+		diversity, climate *float64
+		metrics            *MetricWeights
+		wantDiv, wantClim  float64
+		wantMetrics        MetricWeights
+		err                bool
+	}{
+		{name: "defaults", wantDiv: 0.7, wantClim: 0.3, wantMetrics: defaultMetricWeights},
+		{name: "one implies the other", diversity: f(0.4), wantDiv: 0.4, wantClim: 0.6, wantMetrics: defaultMetricWeights},
+		{name: "climate only", climate: f(1), wantDiv: 0, wantClim: 1, wantMetrics: defaultMetricWeights},
+		{name: "normalized", diversity: f(0.5), climate: f(0.5), wantDiv: 0.5, wantClim: 0.5, wantMetrics: defaultMetricWeights},
+		{name: "scaled", diversity: f(0.2), climate: f(0.2), wantDiv: 0.5, wantClim: 0.5, wantMetrics: defaultMetricWeights},
+		{name: "metric weights", metrics: &MetricWeights{1, 1, 0}, wantDiv: 0.7, wantClim: 0.3, wantMetrics: MetricWeights{0.5, 0.5, 0}},
+		{name: "out of range", diversity: f(1.5), err: true},
+		{name: "negative", climate: f(-0.1), err: true},
+		{name: "both zero", diversity: f(0), climate: f(0), err: true},
+		{name: "metrics zero", metrics: &MetricWeights{}, err: true},
+		{name: "metric out of range", metrics: &MetricWeights{2, 0, 0}, err: true},
+	}
+	for _, tt := range tests {
+		req := RecommendRequest{DiversityWeight: tt.diversity, ClimateWeight: tt.climate, MetricWeights: tt.metrics}
+		err := normalizeWeights(&req)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		div, clim := req.selectionWeights()
+		if math.Abs(div-tt.wantDiv) > 1e-9 || math.Abs(clim-tt.wantClim) > 1e-9 {
+			t.Errorf("%s: weights %v/%v, want %v/%v", tt.name, div, clim, tt.wantDiv, tt.wantClim)
+		}
+		if req.metricWeights() != tt.wantMetrics {
+			t.Errorf("%s: metric weights %+v, want %+v", tt.name, req.metricWeights(), tt.wantMetrics)
+		}
+	}
+}
+
+func TestSelectionWeightsChangeSelection(t *testing.T) {
+	candidates, traits := testPool()
+
+	// Climate only: the greedy loop follows the climate ranking
+	climateOnly := selectedIDs(greedyDiversitySelection(candidates, traits, 4, selectionOptions{ClimateWeight: 1}))
+	if !equalIDs(climateOnly, []int64{1, 2, 3, 4}) {
+		t.Errorf("climate_weight 1: got %v", climateOnly)
+	}
+
+	// Diversity only reaches for the distinct palm and graminoid
+	diversityOnly := selectedIDs(greedyDiversitySelection(candidates, traits, 4, selectionOptions{DiversityWeight: 1}))
+	found := map[int64]bool{}
+	for _, id := range diversityOnly {
+		found[id] = true
+	}
+	if !found[6] || !found[7] {
+		t.Errorf("diversity_weight 1: got %v, want the palm (6) and graminoid (7)", diversityOnly)
+	}
+}
+
+func TestMetricWeightsChangeTotalScore(t *testing.T) {
+	candidates, traits := testPool()
+	def := calculateDiversityMetrics(candidates, traits, MetricWeights{})
+	functional := calculateDiversityMetrics(candidates, traits, MetricWeights{Functional: 1})
+	if functional.TotalDiversityScore != def.FunctionalDiversity {
+		t.Errorf("functional-only total = %v, want %v", functional.TotalDiversityScore, def.FunctionalDiversity)
+	}
+	if def.TotalDiversityScore == functional.TotalDiversityScore {
+		t.Error("weights did not change the total score")
+	}
+}
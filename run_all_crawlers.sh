@@ -38,6 +38,7 @@ run_crawler "wcvp" 500
 run_crawler "treegoer" 500
 run_crawler "iucn" 500
 run_crawler "worldclim" 100
+run_crawler "worldclim_future" 100
 
 echo "================================================"
 echo "Todos os crawlers finalizados!"
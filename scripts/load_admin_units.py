@@ -0,0 +1,106 @@
+#!/usr/bin/env python3
+"""
+Load Natural Earth countries and subdivisions into admin_units (migration
+057) and compute their TDWG crosswalk with refresh_admin_to_tdwg(), so any
+ISO 3166 code is accepted as state_code.
+
+Convert the Natural Earth 1:10m shapefiles to GeoJSON first:
+    ogr2ogr -f GeoJSON data/ne_10m_admin_0_countries.geojson ne_10m_admin_0_countries.shp
+    ogr2ogr -f GeoJSON data/ne_10m_admin_1_states_provinces.geojson ne_10m_admin_1_states_provinces.shp
+
+Units already in admin_units (the seeded Brazilian states) only get their
+geometry; their crosswalk rows from the migration are kept.
+
+Usage:
+    python scripts/load_admin_units.py
+    python scripts/load_admin_units.py --countries-only --dry-run
+"""
+import argparse
+import json
+import os
+import re
+import sys
+from pathlib import Path
+
+try:
+    import psycopg2
+except ImportError as e:
+    print(f"Missing dependency: {e}")
+    print("Install with: pip install psycopg2-binary")
+    sys.exit(1)
+
+DATA_DIR = Path(__file__).resolve().parent.parent / 'data'
+COUNTRIES_FILE = DATA_DIR / 'ne_10m_admin_0_countries.geojson'
+SUBDIVISIONS_FILE = DATA_DIR / 'ne_10m_admin_1_states_provinces.geojson'
+
+# Same pattern as the API (admin_units.go)
+ISO_CODE = re.compile(r'^[A-Z]{2}(-[A-Z0-9]{1,3})?$')
+
+DB_CONFIG = {
+    'host': os.getenv('DB_HOST', 'localhost'),
+    'port': os.getenv('DB_PORT', '5432'),
+    'user': os.getenv('DB_USER', os.getenv('POSTGRES_USER', 'diversiplant')),
+    'password': os.getenv('DB_PASSWORD', os.getenv('POSTGRES_PASSWORD', 'diversiplant_dev')),
+    'dbname': os.getenv('DB_NAME', os.getenv('POSTGRES_DB', 'diversiplant')),
+}
+
+
+def countries(path: Path):
+    """(iso_code, name, country_code, level, geometry) of the countries."""
+    with open(path) as f:
+        for feature in json.load(f)['features']:
+            props = feature['properties']
+            # ISO_A2 is -99 for a few countries (France, Norway); ISO_A2_EH fills them
+            code = props.get('ISO_A2_EH') or props.get('ISO_A2') or ''
+            if ISO_CODE.match(code) and feature.get('geometry'):
+                yield code, props.get('NAME') or code, code, 0, feature['geometry']
+
+
+def subdivisions(path: Path):
+    """(iso_code, name, country_code, level, geometry) of the subdivisions."""
+    with open(path) as f:
+        for feature in json.load(f)['features']:
+            props = feature['properties']
+            code = (props.get('iso_3166_2') or '').upper()
+            if ISO_CODE.match(code) and '-' in code and feature.get('geometry'):
+                yield code, props.get('name') or code, code[:2], 1, feature['geometry']
+
+
+def main():
+    parser = argparse.ArgumentParser(description='Load ISO 3166 administrative units')
+    parser.add_argument('--countries', type=Path, default=COUNTRIES_FILE)
+    parser.add_argument('--subdivisions', type=Path, default=SUBDIVISIONS_FILE)
+    parser.add_argument('--countries-only', action='store_true')
+    parser.add_argument('--dry-run', action='store_true', help="Parse the files without writing")
+    args = parser.parse_args()
+
+    units = {}
+    for unit in countries(args.countries):
+        units[unit[0]] = unit
+    if not args.countries_only:
+        for unit in subdivisions(args.subdivisions):
+            units.setdefault(unit[0], unit)
+    print(f"{len(units)} units with ISO codes")
+    if args.dry_run:
+        return
+
+    conn = psycopg2.connect(**DB_CONFIG)
+    try:
+        cursor = conn.cursor()
+        for code, name, country, level, geometry in units.values():
+            cursor.execute("""
+                INSERT INTO admin_units (iso_code, name, country_code, level, geom)
+                VALUES (%s, %s, %s, %s, ST_Multi(ST_CollectionExtract(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON(%s), 4326)), 3)))
+                ON CONFLICT (iso_code) DO UPDATE SET geom = EXCLUDED.geom
+            """, (code, name, country, level, json.dumps(geometry)))
+        conn.commit()
+        print("Computing the TDWG crosswalk...")
+        cursor.execute("SELECT refresh_admin_to_tdwg()")
+        print(f"{cursor.fetchone()[0]} admin_to_tdwg rows")
+        conn.commit()
+    finally:
+        conn.close()
+
+
+if __name__ == '__main__':
+    main()
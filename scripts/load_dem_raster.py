@@ -0,0 +1,131 @@
+#!/usr/bin/env python3
+"""
+Load a digital elevation model (GeoTIFF, EPSG:4326, meters) into dem_raster
+(migration 041) for /api/elevation.
+
+Tiles are small so a point query reads little; slope and aspect need the
+cell's neighbors, which the API takes from the same tile (see terrain.go),
+so points on a tile edge get a flatter estimate.
+
+Usage:
+    python scripts/load_dem_raster.py data/dem/gmted2010_30s.tif --source gmted2010 --resolution 30s
+    python scripts/load_dem_raster.py ... --replace    # drop the source's tiles first
+"""
+import argparse
+import os
+import sys
+from pathlib import Path
+
+try:
+    import rasterio
+    import rasterio.windows
+    import numpy as np
+    import psycopg2
+except ImportError as e:
+    print(f"Missing dependency: {e}")
+    print("Install with: pip install rasterio numpy psycopg2-binary")
+    sys.exit(1)
+
+TILE_SIZE = 100
+
+DB_CONFIG = {
+    'host': os.getenv('DB_HOST', 'localhost'),
+    'port': os.getenv('DB_PORT', '5432'),
+    'user': os.getenv('DB_USER', os.getenv('POSTGRES_USER', 'diversiplant')),
+    'password': os.getenv('DB_PASSWORD', os.getenv('POSTGRES_PASSWORD', 'diversiplant_dev')),
+    'dbname': os.getenv('DB_NAME', os.getenv('POSTGRES_DB', 'diversiplant')),
+}
+
+
+def load_dem(tif_path: Path, source: str, resolution: str, conn) -> int:
+    """Load one GeoTIFF into dem_raster; returns the number of tiles."""
+    cursor = conn.cursor()
+
+    with rasterio.open(tif_path) as src:
+        if src.crs and src.crs.to_epsg() != 4326:
+            raise SystemExit(f"{tif_path.name} is in {src.crs}, reproject to EPSG:4326 first")
+        if src.transform.e > 0:
+            raise SystemExit(f"{tif_path.name} is not north-up")
+
+        width, height = src.width, src.height
+        nodata = src.nodata if src.nodata is not None else -32768.0
+        n_tiles_x = (width + TILE_SIZE - 1) // TILE_SIZE
+        n_tiles_y = (height + TILE_SIZE - 1) // TILE_SIZE
+        print(f"Loading {tif_path.name}: {width}x{height}, up to {n_tiles_x * n_tiles_y} tiles")
+
+        loaded = skipped = 0
+        for ty in range(n_tiles_y):
+            for tx in range(n_tiles_x):
+                window = rasterio.windows.Window(
+                    tx * TILE_SIZE, ty * TILE_SIZE,
+                    min(TILE_SIZE, width - tx * TILE_SIZE),
+                    min(TILE_SIZE, height - ty * TILE_SIZE),
+                )
+                data = src.read(1, window=window).astype('float32')
+
+                # Oceans are nodata in most DEMs
+                if np.all(np.isclose(data, nodata)):
+                    skipped += 1
+                    continue
+
+                t = rasterio.windows.transform(window, src.transform)
+                cursor.execute("""
+                    INSERT INTO dem_raster (source, resolution, filename, rast)
+                    SELECT %s, %s, %s,
+                        ST_SetBandNoDataValue(
+                            ST_SetValues(
+                                ST_AddBand(
+                                    ST_MakeEmptyRaster(%s, %s, %s::float8, %s::float8, %s::float8, %s::float8, 0, 0, 4326),
+                                    1, '32BF'::text, %s::float8, %s::float8
+                                ),
+                                1, 1, 1, %s::float8[][]
+                            ),
+                            1, %s::float8
+                        )
+                """, (
+                    source, resolution, tif_path.name,
+                    int(window.width), int(window.height), t.c, t.f, t.a, t.e,
+                    float(nodata), float(nodata),
+                    data.tolist(),
+                    float(nodata),
+                ))
+                loaded += 1
+                if loaded % 500 == 0:
+                    conn.commit()
+                    print(f"  {loaded} tiles loaded")
+
+    conn.commit()
+    print(f"  Done: {loaded} tiles loaded, {skipped} empty tiles skipped")
+    return loaded
+
+
+def main():
+    parser = argparse.ArgumentParser(description='Load a DEM GeoTIFF into dem_raster')
+    parser.add_argument('tif', nargs='+', type=Path)
+    parser.add_argument('--source', required=True, help="DEM name, e.g. srtm, gmted2010")
+    parser.add_argument('--resolution', required=True, help="Cell size, e.g. 30s, 3s")
+    parser.add_argument('--replace', action='store_true', help="Delete the source's tiles first")
+    args = parser.parse_args()
+
+    conn = psycopg2.connect(**DB_CONFIG)
+    try:
+        cursor = conn.cursor()
+        if args.replace:
+            cursor.execute("DELETE FROM dem_raster WHERE source = %s", (args.source,))
+            print(f"Deleted {cursor.rowcount} {args.source} tiles")
+            conn.commit()
+
+        total = sum(load_dem(p, args.source, args.resolution, conn) for p in args.tif)
+        cursor.execute("ANALYZE dem_raster")
+        conn.commit()
+        print(f"Total: {total} tiles")
+        print("Test: curl 'http://localhost:8080/api/elevation?lat=-27.6&lon=-48.65'")
+    except Exception:
+        conn.rollback()
+        raise
+    finally:
+        conn.close()
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,96 @@
+#!/usr/bin/env python3
+"""
+Load published elevation ranges (regional floras) into species_elevation_ranges.
+
+Input is a CSV with columns:
+    canonical_name,min_m,max_m,reference
+
+Names are matched exactly against species.canonical_name; unmatched names are
+reported. Flora ranges take precedence over GBIF percentiles in the
+species_elevation_unified view used by the recommender.
+
+Usage:
+    .venv/bin/python scripts/load_flora_elevation.py ranges.csv [--dry-run]
+"""
+import argparse
+import csv
+import os
+import sys
+
+from sqlalchemy import create_engine, text
+
+
+def parse_meters(value):
+    """Parse an elevation cell; empty cells mean an open-ended range."""
+    value = (value or '').strip()
+    if not value:
+        return None
+    return float(value)
+
+
+def load_rows(path):
+    with open(path, newline='', encoding='utf-8') as f:
+        for line_no, row in enumerate(csv.DictReader(f), start=2):
+            name = (row.get('canonical_name') or '').strip()
+            if not name:
+                continue
+            try:
+                min_m = parse_meters(row.get('min_m'))
+                max_m = parse_meters(row.get('max_m'))
+            except ValueError:
+                print(f"  line {line_no}: invalid elevation for {name}, skipped")
+                continue
+            if min_m is None and max_m is None:
+                continue
+            if min_m is not None and max_m is not None and min_m > max_m:
+                print(f"  line {line_no}: min_m > max_m for {name}, skipped")
+                continue
+            yield name, min_m, max_m, (row.get('reference') or '').strip() or None
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument('csv_path')
+    parser.add_argument('--dry-run', action='store_true', help='Report matches without writing')
+    args = parser.parse_args()
+
+    db_url = os.environ.get('DATABASE_URL', 'postgresql://localhost/diversiplant')
+    engine = create_engine(db_url)
+
+    loaded = 0
+    unmatched = []
+
+    with engine.begin() as conn:
+        for name, min_m, max_m, reference in load_rows(args.csv_path):
+            species_id = conn.execute(
+                text("SELECT id FROM species WHERE canonical_name = :name"),
+                {'name': name}
+            ).scalar()
+            if species_id is None:
+                unmatched.append(name)
+                continue
+
+            if not args.dry_run:
+                conn.execute(text("""
+                    INSERT INTO species_elevation_ranges (species_id, source, min_m, max_m, reference)
+                    VALUES (:species_id, 'flora', :min_m, :max_m, :reference)
+                    ON CONFLICT (species_id, source) DO UPDATE SET
+                        min_m = EXCLUDED.min_m,
+                        max_m = EXCLUDED.max_m,
+                        reference = EXCLUDED.reference,
+                        updated_at = CURRENT_TIMESTAMP
+                """), {'species_id': species_id, 'min_m': min_m, 'max_m': max_m, 'reference': reference})
+            loaded += 1
+
+    action = 'Would load' if args.dry_run else 'Loaded'
+    print(f"{action} {loaded} elevation ranges; {len(unmatched)} names not found")
+    for name in unmatched[:20]:
+        print(f"  not found: {name}")
+    if len(unmatched) > 20:
+        print(f"  ... and {len(unmatched) - 20} more")
+
+    return 0
+
+
+if __name__ == '__main__':
+    sys.exit(main())
@@ -0,0 +1,160 @@
+#!/usr/bin/env python3
+"""
+Sync the plant names of the GBIF Backbone Taxonomy into gbif_backbone
+(migration 046), which /api/names/resolve uses to map synonyms and
+misspellings to accepted names.
+
+Reads Taxon.tsv from the backbone archive
+(https://hosted-datasets.gbif.org/datasets/backbone/current/backbone.zip),
+keeping Plantae at species rank and below. The table is rebuilt in a
+staging copy and swapped in one transaction, so resolution keeps working
+during the load.
+
+With --mark-synonyms, species whose name is a backbone synonym (and not
+also an accepted backbone name) are marked taxonomic_status = 'synonym'
+with accepted_name_id set to the accepted species, when it is in the
+database. The server's taxonomy propagation job then moves their data
+(see query-explorer/taxonomy_propagation.go). Pro parte synonyms, which
+have several accepted names, are left alone.
+
+Usage:
+    python scripts/load_gbif_backbone.py --file backbone/Taxon.tsv
+    python scripts/load_gbif_backbone.py --file backbone.zip --dry-run
+    python scripts/load_gbif_backbone.py --file backbone.zip --mark-synonyms
+"""
+import argparse
+import csv
+import io
+import os
+import sys
+import zipfile
+from pathlib import Path
+
+try:
+    import psycopg2
+except ImportError as e:
+    print(f"Missing dependency: {e}")
+    print("Install with: pip install psycopg2-binary")
+    sys.exit(1)
+
+RANKS = {'species', 'subspecies', 'variety', 'form'}
+
+COLUMNS = ('taxon_key', 'scientific_name', 'canonical_name', 'authorship', 'rank',
+           'taxonomic_status', 'accepted_key', 'family', 'genus')
+
+DB_CONFIG = {
+    'host': os.getenv('DB_HOST', 'localhost'),
+    'port': os.getenv('DB_PORT', '5432'),
+    'user': os.getenv('DB_USER', os.getenv('POSTGRES_USER', 'diversiplant')),
+    'password': os.getenv('DB_PASSWORD', os.getenv('POSTGRES_PASSWORD', 'diversiplant_dev')),
+    'dbname': os.getenv('DB_NAME', os.getenv('POSTGRES_DB', 'diversiplant')),
+}
+
+
+def open_taxa(path: Path):
+    """Text stream of Taxon.tsv, from the file itself or the backbone zip."""
+    if path.suffix == '.zip':
+        archive = zipfile.ZipFile(path)
+        return io.TextIOWrapper(archive.open('Taxon.tsv'), encoding='utf-8')
+    return open(path, encoding='utf-8')
+
+
+def taxon_row(record: dict):
+    """COPY row of a Taxon.tsv record, or None outside the plants kept."""
+    if record.get('kingdom') != 'Plantae':
+        return None
+    rank = (record.get('taxonRank') or '').lower()
+    canonical = (record.get('canonicalName') or '').strip()
+    if rank not in RANKS or not canonical:
+        return None
+    status = (record.get('taxonomicStatus') or '').lower()
+    accepted = record.get('acceptedNameUsageID') or None
+    return (
+        record['taxonID'],
+        record.get('scientificName') or canonical,
+        canonical,
+        record.get('scientificNameAuthorship') or None,
+        rank,
+        status,
+        accepted if accepted != record['taxonID'] else None,
+        record.get('family') or None,
+        record.get('genus') or None,
+    )
+
+
+def copy_value(v):
+    if v is None:
+        return '\\N'
+    return str(v).replace('\\', '\\\\').replace('\t', ' ').replace('\n', ' ')
+
+
+MARK_SYNONYMS_SQL = """
+    UPDATE species s
+    SET taxonomic_status = 'synonym', accepted_name_id = a.id
+    FROM gbif_backbone b
+    JOIN gbif_backbone ab ON ab.taxon_key = b.accepted_key
+    JOIN species a ON LOWER(a.canonical_name) = LOWER(ab.canonical_name)
+    WHERE (s.gbif_taxon_key = b.taxon_key OR LOWER(s.canonical_name) = LOWER(b.canonical_name))
+      AND b.taxonomic_status LIKE '%%synonym' AND b.taxonomic_status <> 'proparte synonym'
+      AND NOT EXISTS (
+          SELECT 1 FROM gbif_backbone x
+          WHERE LOWER(x.canonical_name) = LOWER(s.canonical_name) AND x.taxonomic_status = 'accepted'
+      )
+      AND a.id <> s.id
+      AND s.taxonomic_status IS DISTINCT FROM 'synonym'
+"""
+
+
+def main():
+    parser = argparse.ArgumentParser(description='Sync GBIF backbone plant names')
+    parser.add_argument('--file', type=Path, required=True, help="Taxon.tsv or backbone.zip")
+    parser.add_argument('--dry-run', action='store_true', help="Count rows without writing")
+    parser.add_argument('--mark-synonyms', action='store_true',
+                        help="Mark species that are backbone synonyms for taxonomy propagation")
+    args = parser.parse_args()
+
+    csv.field_size_limit(sys.maxsize)
+    buf = io.StringIO()
+    kept = 0
+    with open_taxa(args.file) as f:
+        for record in csv.DictReader(f, delimiter='\t', quoting=csv.QUOTE_NONE):
+            row = taxon_row(record)
+            if row is None:
+                continue
+            kept += 1
+            buf.write('\t'.join(copy_value(v) for v in row) + '\n')
+    print(f"{kept} plant names at species rank or below")
+    if args.dry_run:
+        return
+
+    conn = psycopg2.connect(**DB_CONFIG)
+    try:
+        cursor = conn.cursor()
+        cursor.execute("DROP TABLE IF EXISTS gbif_backbone_staging")
+        cursor.execute("CREATE TABLE gbif_backbone_staging (LIKE gbif_backbone INCLUDING DEFAULTS)")
+        buf.seek(0)
+        cursor.copy_from(buf, 'gbif_backbone_staging', columns=COLUMNS)
+        cursor.execute("DELETE FROM gbif_backbone")
+        cursor.execute(f"""
+            INSERT INTO gbif_backbone ({', '.join(COLUMNS)})
+            SELECT DISTINCT ON (taxon_key) {', '.join(COLUMNS)} FROM gbif_backbone_staging
+        """)
+        cursor.execute("DROP TABLE gbif_backbone_staging")
+        marked = 0
+        if args.mark_synonyms:
+            cursor.execute(MARK_SYNONYMS_SQL)
+            marked = cursor.rowcount
+        conn.commit()
+        cursor.execute("ANALYZE gbif_backbone")
+        print(f"Loaded {kept} names into gbif_backbone")
+        if args.mark_synonyms:
+            print(f"Marked {marked} species as synonyms")
+    except Exception:
+        conn.rollback()
+        raise
+    finally:
+        conn.close()
+
+
+if __name__ == '__main__':
+    main()
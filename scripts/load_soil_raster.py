@@ -0,0 +1,144 @@
+#!/usr/bin/env python3
+"""
+Load SoilGrids 2.0 rasters (GeoTIFF, EPSG:4326) into soil_raster (migration
+042) for /api/soil and the recommender's soil_match preference.
+
+SoilGrids stores integers in mapped units; values are converted on load to
+pH (phh2o / 10), percent (sand, silt, clay in g/kg / 10) and g/kg (soc in
+dg/kg / 10), so the API reads conventional units. One file per property,
+already averaged over 0-30 cm (e.g. with gdal_calc from the 0-5, 5-15 and
+15-30 cm layers weighted 5:10:15).
+
+Usage:
+    python scripts/load_soil_raster.py data/soil/phh2o_0-30cm.tif --property phh2o
+    python scripts/load_soil_raster.py data/soil/sand_0-30cm.tif --property sand --replace
+"""
+import argparse
+import os
+import sys
+from pathlib import Path
+
+try:
+    import rasterio
+    import rasterio.windows
+    import numpy as np
+    import psycopg2
+except ImportError as e:
+    print(f"Missing dependency: {e}")
+    print("Install with: pip install rasterio numpy psycopg2-binary")
+    sys.exit(1)
+
+TILE_SIZE = 100
+NODATA = -9999.0
+
+PROPERTIES = ('phh2o', 'sand', 'silt', 'clay', 'soc')
+
+DB_CONFIG = {
+    'host': os.getenv('DB_HOST', 'localhost'),
+    'port': os.getenv('DB_PORT', '5432'),
+    'user': os.getenv('DB_USER', os.getenv('POSTGRES_USER', 'diversiplant')),
+    'password': os.getenv('DB_PASSWORD', os.getenv('POSTGRES_PASSWORD', 'diversiplant_dev')),
+    'dbname': os.getenv('DB_NAME', os.getenv('POSTGRES_DB', 'diversiplant')),
+}
+
+
+def load_soil(tif_path: Path, prop: str, depth: str, resolution: str, scale: float, conn) -> int:
+    """Load one GeoTIFF into soil_raster; returns the number of tiles."""
+    cursor = conn.cursor()
+
+    with rasterio.open(tif_path) as src:
+        if src.crs and src.crs.to_epsg() != 4326:
+            raise SystemExit(f"{tif_path.name} is in {src.crs}, reproject to EPSG:4326 first "
+                             "(SoilGrids is distributed in Homolosine)")
+        if src.transform.e > 0:
+            raise SystemExit(f"{tif_path.name} is not north-up")
+
+        width, height = src.width, src.height
+        src_nodata = src.nodata if src.nodata is not None else -32768.0
+        n_tiles_x = (width + TILE_SIZE - 1) // TILE_SIZE
+        n_tiles_y = (height + TILE_SIZE - 1) // TILE_SIZE
+        print(f"Loading {tif_path.name} ({prop}): {width}x{height}, up to {n_tiles_x * n_tiles_y} tiles")
+
+        loaded = skipped = 0
+        for ty in range(n_tiles_y):
+            for tx in range(n_tiles_x):
+                window = rasterio.windows.Window(
+                    tx * TILE_SIZE, ty * TILE_SIZE,
+                    min(TILE_SIZE, width - tx * TILE_SIZE),
+                    min(TILE_SIZE, height - ty * TILE_SIZE),
+                )
+                raw = src.read(1, window=window).astype('float32')
+                empty = np.isclose(raw, src_nodata)
+
+                # Water, ice and built-up areas have no soil prediction
+                if np.all(empty):
+                    skipped += 1
+                    continue
+
+                data = np.where(empty, NODATA, raw * scale)
+                t = rasterio.windows.transform(window, src.transform)
+                cursor.execute("""
+                    INSERT INTO soil_raster (property, depth, resolution, filename, rast)
+                    SELECT %s, %s, %s, %s,
+                        ST_SetBandNoDataValue(
+                            ST_SetValues(
+                                ST_AddBand(
+                                    ST_MakeEmptyRaster(%s, %s, %s::float8, %s::float8, %s::float8, %s::float8, 0, 0, 4326),
+                                    1, '32BF'::text, %s::float8, %s::float8
+                                ),
+                                1, 1, 1, %s::float8[][]
+                            ),
+                            1, %s::float8
+                        )
+                """, (
+                    prop, depth, resolution, tif_path.name,
+                    int(window.width), int(window.height), t.c, t.f, t.a, t.e,
+                    NODATA, NODATA,
+                    data.tolist(),
+                    NODATA,
+                ))
+                loaded += 1
+                if loaded % 500 == 0:
+                    conn.commit()
+                    print(f"  {loaded} tiles loaded")
+
+    conn.commit()
+    print(f"  Done: {loaded} tiles loaded, {skipped} empty tiles skipped")
+    return loaded
+
+
+def main():
+    parser = argparse.ArgumentParser(description='Load SoilGrids GeoTIFFs into soil_raster')
+    parser.add_argument('tif', nargs='+', type=Path)
+    parser.add_argument('--property', required=True, choices=PROPERTIES)
+    parser.add_argument('--depth', default='0-30cm')
+    parser.add_argument('--resolution', default='250m')
+    parser.add_argument('--scale', type=float, default=0.1,
+                        help="Factor from file values to stored units (SoilGrids mapped units: 0.1)")
+    parser.add_argument('--replace', action='store_true', help="Delete the property's tiles at this depth first")
+    args = parser.parse_args()
+
+    conn = psycopg2.connect(**DB_CONFIG)
+    try:
+        cursor = conn.cursor()
+        if args.replace:
+            cursor.execute("DELETE FROM soil_raster WHERE property = %s AND depth = %s",
+                           (args.property, args.depth))
+            print(f"Deleted {cursor.rowcount} {args.property} tiles")
+            conn.commit()
+
+        total = sum(load_soil(p, args.property, args.depth, args.resolution, args.scale, conn)
+                    for p in args.tif)
+        cursor.execute("ANALYZE soil_raster")
+        conn.commit()
+        print(f"Total: {total} tiles")
+        print("Test: curl 'http://localhost:8080/api/soil?lat=-27.6&lon=-48.65'")
+    except Exception:
+        conn.rollback()
+        raise
+    finally:
+        conn.close()
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,108 @@
+#!/usr/bin/env python3
+"""
+Load species soil tolerances from EcoCrop (data/ecocrop_agricultural.json)
+into species_soil_tolerance (migration 042) for the soil_match preference.
+
+The pH range is EcoCrop's absolute one; textures are the union of its
+optimal and absolute texture classes, with "wide" meaning all four. Species
+are matched on their WCVP canonical name, else the EcoCrop name.
+
+Usage:
+    python scripts/load_soil_tolerance.py
+    python scripts/load_soil_tolerance.py --file data/ecocrop_agricultural.json --dry-run
+"""
+import argparse
+import json
+import os
+import sys
+from pathlib import Path
+
+try:
+    import psycopg2
+except ImportError as e:
+    print(f"Missing dependency: {e}")
+    print("Install with: pip install psycopg2-binary")
+    sys.exit(1)
+
+DATA_FILE = Path(__file__).resolve().parent.parent / 'data' / 'ecocrop_agricultural.json'
+
+TEXTURES = ('light', 'medium', 'heavy', 'organic')
+
+DB_CONFIG = {
+    'host': os.getenv('DB_HOST', 'localhost'),
+    'port': os.getenv('DB_PORT', '5432'),
+    'user': os.getenv('DB_USER', os.getenv('POSTGRES_USER', 'diversiplant')),
+    'password': os.getenv('DB_PASSWORD', os.getenv('POSTGRES_PASSWORD', 'diversiplant_dev')),
+    'dbname': os.getenv('DB_NAME', os.getenv('POSTGRES_DB', 'diversiplant')),
+}
+
+
+def parse_textures(soil: dict):
+    """EcoCrop texture strings ("heavy, medium, light") to a list, or None."""
+    found = set()
+    for key in ('texture', 'texture_range'):
+        for token in (soil.get(key) or '').split(','):
+            token = token.strip().lower()
+            if token == 'wide':
+                found.update(TEXTURES)
+            elif token in TEXTURES:
+                found.add(token)
+    return [t for t in TEXTURES if t in found] or None
+
+
+def tolerance(record: dict):
+    """(name, ph_min, ph_max, textures) of a record, or None without soil data."""
+    soil = record.get('soil') or {}
+    ph_min, ph_max = soil.get('ph_abs_min'), soil.get('ph_abs_max')
+    if ph_min is not None and ph_max is not None and ph_min > ph_max:
+        ph_min, ph_max = ph_max, ph_min
+    textures = parse_textures(soil)
+    if ph_min is None and ph_max is None and textures is None:
+        return None
+    name = record.get('wcvp_canonical_name') or record.get('scientific_name')
+    return name, ph_min, ph_max, textures
+
+
+def main():
+    parser = argparse.ArgumentParser(description='Load EcoCrop soil tolerances')
+    parser.add_argument('--file', type=Path, default=DATA_FILE)
+    parser.add_argument('--dry-run', action='store_true', help="Match species without writing")
+    args = parser.parse_args()
+
+    with open(args.file) as f:
+        records = [t for t in map(tolerance, json.load(f)) if t and t[0]]
+    print(f"{len(records)} EcoCrop records with soil data")
+
+    conn = psycopg2.connect(**DB_CONFIG)
+    try:
+        cursor = conn.cursor()
+        matched = 0
+        for name, ph_min, ph_max, textures in records:
+            cursor.execute("SELECT id FROM species WHERE LOWER(canonical_name) = LOWER(%s) LIMIT 1", (name,))
+            row = cursor.fetchone()
+            if not row:
+                continue
+            matched += 1
+            if args.dry_run:
+                continue
+            cursor.execute("""
+                INSERT INTO species_soil_tolerance (species_id, ph_min, ph_max, textures, source)
+                VALUES (%s, %s, %s, %s, 'ecocrop')
+                ON CONFLICT (species_id) DO UPDATE SET
+                    ph_min = EXCLUDED.ph_min,
+                    ph_max = EXCLUDED.ph_max,
+                    textures = EXCLUDED.textures,
+                    source = EXCLUDED.source,
+                    updated_at = CURRENT_TIMESTAMP
+            """, (row[0], ph_min, ph_max, textures))
+        conn.commit()
+        print(f"Matched {matched}/{len(records)} species" + (" (dry run)" if args.dry_run else ""))
+    except Exception:
+        conn.rollback()
+        raise
+    finally:
+        conn.close()
+
+
+if __name__ == '__main__':
+    main()
@@ -96,6 +96,36 @@ class TestREFLORACrawler:
         # Test with author
         assert crawler._clean_species_name('Araucaria angustifolia (Bertol.) Kuntze') == 'Araucaria angustifolia'
 
+    def test_transform_brazil_flora(self):
+        """Test species-level endemism, domains and vegetation types."""
+        import json
+        from crawlers.reflora import REFLORACrawler
+
+        class MockCrawler(REFLORACrawler):
+            def __init__(self):
+                self.logger = None
+                self.session = None
+
+        crawler = MockCrawler()
+        remarks = json.dumps({'endemism': 'Endemica', 'phytogeographicDomain': ['Mata Atlântica', 'Cerrado']})
+        data = crawler.transform({
+            'scientificName': 'Euterpe edulis Mart.',
+            'id': 12345,
+            'profile': {'lifeForm': json.dumps({
+                'lifeForm': ['Palmeira'],
+                'vegetationType': ['Floresta Ombrófila (= Floresta Pluvial)', 'Restinga'],
+            })},
+            'distributions': [
+                {'locationID': 'BR-SP', 'establishmentMeans': 'NATIVA', 'occurrenceRemarks': remarks},
+                {'locationID': 'BR-SC', 'establishmentMeans': 'NATIVA', 'occurrenceRemarks': remarks},
+            ],
+        })
+
+        flora = data['brazil_flora']
+        assert flora['is_endemic'] is True
+        assert flora['phytogeographic_domains'] == ['Cerrado', 'Mata Atlântica']
+        assert flora['vegetation_types'] == ['Floresta Ombrófila (= Floresta Pluvial)', 'Restinga']
+
 
 class TestGIFTCrawler:
     """Test cases for GIFT crawler.
@@ -328,3 +358,197 @@ class TestIUCNCrawler:
 
         for cat in expected_categories:
             assert cat in IUCNCrawler.CATEGORIES
+
+
+class TestGBIFOccurrenceCrawler:
+    """Test cases for GBIF occurrence crawler."""
+
+    def test_clean_elevation(self):
+        """Test that occurrence elevations are parsed and implausible values dropped."""
+        from crawlers.gbif_occurrences import GBIFOccurrenceCrawler
+
+        class MockCrawler(GBIFOccurrenceCrawler):
+            def __init__(self):
+                self.logger = None
+
+        crawler = MockCrawler()
+
+        assert crawler._clean_elevation(850) == 850.0
+        assert crawler._clean_elevation('1200.5') == 1200.5
+        assert crawler._clean_elevation(0) == 0.0
+        assert crawler._clean_elevation(None) is None
+        assert crawler._clean_elevation('n/a') is None
+        assert crawler._clean_elevation(9999) is None
+        assert crawler._clean_elevation(-3000) is None
+
+
+class TestValidationReport:
+    """Test cases for the dry-run validation report."""
+
+    def test_check_record(self):
+        """Test constraint violations and suspicious values."""
+        from crawlers.validation import check_record
+
+        ok = {'canonical_name': 'Euterpe edulis', 'genus': 'Euterpe',
+              'traits': {'growth_form': 'palm', 'max_height_m': 15}}
+        assert check_record(ok) == ([], [])
+
+        bad = {'canonical_name': 'Euterpe edulis', 'genus': 'Euterpe',
+               'family': 'A' * 101,
+               'traits': {'growth_form': 'palmeira', 'max_height_m': 450}}
+        violations, suspicious = check_record(bad)
+        assert violations == ['family_too_long']
+        assert 'unknown_growth_form' in suspicious
+        assert 'implausible_max_height_m' in suspicious
+
+        assert check_record({'canonical_name': 'Euterpe edulis Mart.'})[1] == ['canonical_name_not_binomial']
+        assert check_record({'canonical_name': 'Inga vera subsp. affinis'}) == ([], [])
+        assert check_record({'canonical_name': 'Inga vera', 'genus': 'Ingá'})[1] == ['genus_mismatch']
+
+    def test_report_counts(self):
+        """Test new, updated, duplicate and invalid counts."""
+        from crawlers.validation import ValidationReport
+
+        report = ValidationReport('reflora')
+        report.add({'canonical_name': 'Inga vera'}, exists=True)
+        report.add({'canonical_name': 'Inga edulis'}, exists=False)
+        report.add({'canonical_name': 'Inga edulis'}, exists=False)
+        report.invalid({'canonical_name': ''})
+
+        result = report.to_dict(processed=4)
+        assert result['dry_run'] is True
+        assert result['rows_parsed'] == 4
+        assert result['updated_species'] == 1
+        assert result['new_species'] == 1
+        assert result['duplicates'] == 1
+        assert result['invalid'] == 1
+        assert result['examples']['duplicate'] == ['Inga edulis']
+
+
+class TestChecksums:
+    """Test cases for source record checksums."""
+
+    def test_record_checksum_ignores_key_order(self):
+        """Test that checksums depend on content, not key order."""
+        from crawlers.checksums import record_checksum
+
+        a = {'canonical_name': 'Inga vera', 'traits': {'growth_form': 'tree', 'max_height_m': 12}}
+        b = {'traits': {'max_height_m': 12, 'growth_form': 'tree'}, 'canonical_name': 'Inga vera'}
+        assert record_checksum(a) == record_checksum(b)
+        assert len(record_checksum(a)) == 64
+
+        b['traits']['max_height_m'] = 15
+        assert record_checksum(a) != record_checksum(b)
+
+    def test_store_unchanged(self):
+        """Test that only saved checksums count as unchanged."""
+        from crawlers.checksums import ChecksumStore
+
+        store = ChecksumStore('reflora')
+        store._known = {'FB123': 'abc'}
+
+        assert store.unchanged('FB123', 'abc')
+        assert not store.unchanged('FB123', 'def')
+        assert not store.unchanged('FB456', 'abc')
+
+        store.add('FB456', 'abc')
+        assert store.unchanged('FB456', 'abc')
+        assert not store.should_flush()
+
+    def test_worldclim_skips_unchanged_inputs(self):
+        """Test that unchanged regions skip the zonal statistics, keyed by tdwg_code."""
+        from crawlers.checksums import ChecksumStore
+        from crawlers.validation import ValidationReport
+        from crawlers.worldclim import WorldClimCrawler
+
+        class MockCrawler(WorldClimCrawler):
+            def __init__(self):
+                import logging
+                self.logger = logging.getLogger('test')
+                self.stats = {'unchanged': 0}
+                self.force = False
+                self.dry_run = False
+                self.report = ValidationReport('worldclim')
+                self.checksums = ChecksumStore('worldclim')
+                self.checksums._known = {}
+                self.engine = None
+                self._run_id = None
+                self._cache_dir = '/nonexistent'
+                self.zonal_calls = 0
+
+            def _download_all_bio(self, resolution):
+                return {'status': 'downloaded'}
+
+            def _get_tdwg_regions(self):
+                return [('BZS', 'POLYGON((0 0,1 0,1 1,0 0))')]
+
+            def _calculate_zonal_stats(self, tdwg_code, geom_wkt, resolution):
+                self.zonal_calls += 1
+                return {'bio1_mean': 20.0}
+
+            def _add_classifications(self, data):
+                return data
+
+            def _store_climate_data(self, data):
+                return True
+
+        crawler = MockCrawler()
+        list(crawler.fetch_data(resolution='10m'))
+        list(crawler.fetch_data(resolution='10m'))
+        assert crawler.zonal_calls == 1
+        assert crawler.stats['unchanged'] == 1
+        assert list(crawler.checksums._known) == ['BZS']
+
+        # Another resolution replaces the row, so it is recomputed
+        list(crawler.fetch_data(resolution='5m'))
+        assert crawler.zonal_calls == 2
+
+    def test_worldclim_future_keys_rows_and_ensembles(self):
+        """Test that future climate is keyed like its row and averaged per scenario."""
+        from crawlers.checksums import ChecksumStore
+        from crawlers.validation import ValidationReport
+        from crawlers.worldclim_future import WorldClimFutureCrawler
+
+        class MockCrawler(WorldClimFutureCrawler):
+            def __init__(self):
+                import logging
+                self.logger = logging.getLogger('test')
+                self.stats = {'unchanged': 0}
+                self.force = False
+                self.dry_run = False
+                self.report = ValidationReport('worldclim_future')
+                self.checksums = ChecksumStore('worldclim_future')
+                self.checksums._known = {}
+                self.engine = None
+                self._run_id = None
+                self._cache_dir = '/nonexistent'
+                self.stored = []
+                self.ensembles = []
+
+            def _download(self, resolution, scenario, gcm):
+                return __file__
+
+            def _get_tdwg_regions(self):
+                return [('BZS', 'POLYGON((0 0,1 0,1 1,0 0))')]
+
+            def _calculate_zonal_stats(self, tdwg_code, geom_wkt, tif_path):
+                return {'bio1_mean': 22.5}
+
+            def _store_climate_data(self, data):
+                self.stored.append((data['tdwg_code'], data['scenario'], data['gcm']))
+                return True
+
+            def _store_ensemble(self, scenario, resolution):
+                self.ensembles.append(scenario)
+
+        crawler = MockCrawler()
+        list(crawler.fetch_data(scenarios=['ssp245_2050'], gcms=['MIROC6', 'UKESM1-0-LL']))
+        assert crawler.stored == [('BZS', 'ssp245_2050', 'MIROC6'), ('BZS', 'ssp245_2050', 'UKESM1-0-LL')]
+        assert sorted(crawler.checksums._known) == ['BZS:ssp245_2050:MIROC6', 'BZS:ssp245_2050:UKESM1-0-LL']
+        assert crawler.ensembles == ['ssp245_2050']
+
+        # Unchanged inputs skip the region; unknown scenarios are reported
+        results = list(crawler.fetch_data(scenarios=['ssp245_2050', 'ssp999_2100'], gcms=['MIROC6']))
+        assert len(crawler.stored) == 2
+        assert crawler.stats['unchanged'] == 1
+        assert {'status': 'error', 'error': 'Unknown scenario ssp999_2100'} in results